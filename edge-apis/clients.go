@@ -108,6 +108,12 @@ func (self *BaseClient[A]) Authenticate(credentials Credentials, configTypesOver
 		self.HttpTransport.TLSClientConfig.RootCAs = self.Components.CaPool
 	}
 
+	// client certificates are also set by the ApiType, but only if the client's transport has not been wrapped
+	if certs := credentials.TlsCerts(); len(certs) != 0 {
+		self.HttpTransport.TLSClientConfig.Certificates = certs
+		self.HttpTransport.CloseIdleConnections()
+	}
+
 	apiSession, err := self.AuthEnabledApi.Authenticate(credentials, configTypesOverride, self.HttpClient)
 
 	if err != nil {
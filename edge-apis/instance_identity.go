@@ -0,0 +1,274 @@
+package edge_apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	InstanceIdentityProviderAws = "aws"
+	InstanceIdentityProviderGcp = "gcp"
+
+	DefaultAwsMetadataUrl = "http://169.254.169.254"
+	DefaultGcpMetadataUrl = "http://metadata.google.internal"
+
+	// instanceIdentityRefreshWindow is how long before expiration a cached instance identity token is re-fetched.
+	instanceIdentityRefreshWindow = 30 * time.Second
+)
+
+// InstanceIdentityProvider retrieves a signed instance identity document from a cloud provider's instance metadata
+// service (IMDS).
+type InstanceIdentityProvider interface {
+	// Name returns the short name of the cloud provider, e.g. "aws" or "gcp".
+	Name() string
+
+	// Fetch retrieves a signed identity document for the current instance.
+	Fetch(client *http.Client) (string, error)
+}
+
+// NewInstanceIdentityProvider returns the InstanceIdentityProvider for the named cloud provider. An empty metadataUrl
+// selects the provider's well known metadata endpoint. The audience is only used by providers that issue audience
+// bound tokens (gcp).
+func NewInstanceIdentityProvider(name, metadataUrl, audience string) (InstanceIdentityProvider, error) {
+	switch name {
+	case InstanceIdentityProviderAws:
+		return &AwsInstanceIdentityProvider{MetadataUrl: metadataUrl}, nil
+	case InstanceIdentityProviderGcp:
+		return &GcpInstanceIdentityProvider{MetadataUrl: metadataUrl, Audience: audience}, nil
+	}
+	return nil, errors.Errorf("unsupported instance identity provider '%s', expected one of [%s, %s]", name,
+		InstanceIdentityProviderAws, InstanceIdentityProviderGcp)
+}
+
+var _ InstanceIdentityProvider = &AwsInstanceIdentityProvider{}
+
+// AwsInstanceIdentityProvider fetches the PKCS7 signed instance identity document from the EC2 instance metadata
+// service using IMDSv2 session tokens.
+type AwsInstanceIdentityProvider struct {
+	MetadataUrl string
+}
+
+func (p *AwsInstanceIdentityProvider) Name() string {
+	return InstanceIdentityProviderAws
+}
+
+func (p *AwsInstanceIdentityProvider) Fetch(client *http.Client) (string, error) {
+	baseUrl := p.MetadataUrl
+	if baseUrl == "" {
+		baseUrl = DefaultAwsMetadataUrl
+	}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+
+	tokenReq, err := http.NewRequest(http.MethodPut, baseUrl+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	token, err := doMetadataRequest(client, tokenReq)
+	if err != nil {
+		return "", errors.Wrap(err, "could not obtain aws imds session token")
+	}
+
+	docReq, err := http.NewRequest(http.MethodGet, baseUrl+"/latest/dynamic/instance-identity/pkcs7", nil)
+	if err != nil {
+		return "", err
+	}
+	docReq.Header.Set("X-aws-ec2-metadata-token", token)
+
+	doc, err := doMetadataRequest(client, docReq)
+	if err != nil {
+		return "", errors.Wrap(err, "could not obtain aws instance identity document")
+	}
+
+	return strings.ReplaceAll(doc, "\n", ""), nil
+}
+
+var _ InstanceIdentityProvider = &GcpInstanceIdentityProvider{}
+
+// GcpInstanceIdentityProvider fetches a Google signed instance identity JWT for the instance's default service account
+// from the GCE metadata server.
+type GcpInstanceIdentityProvider struct {
+	MetadataUrl string
+	Audience    string
+}
+
+func (p *GcpInstanceIdentityProvider) Name() string {
+	return InstanceIdentityProviderGcp
+}
+
+func (p *GcpInstanceIdentityProvider) Fetch(client *http.Client) (string, error) {
+	if p.Audience == "" {
+		return "", errors.New("an audience is required to request a gcp instance identity token")
+	}
+
+	baseUrl := p.MetadataUrl
+	if baseUrl == "" {
+		baseUrl = DefaultGcpMetadataUrl
+	}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+
+	query := url.Values{}
+	query.Set("audience", p.Audience)
+	query.Set("format", "full")
+
+	req, err := http.NewRequest(http.MethodGet, baseUrl+"/computeMetadata/v1/instance/service-accounts/default/identity?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	token, err := doMetadataRequest(client, req)
+	if err != nil {
+		return "", errors.Wrap(err, "could not obtain gcp instance identity token")
+	}
+
+	return token, nil
+}
+
+func doMetadataRequest(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.String())
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+var _ Credentials = &InstanceIdentityCredentials{}
+
+// InstanceIdentityCredentials authenticates as an ext-jwt identity using a token derived from the cloud instance the
+// process is running on, allowing VMs to bootstrap without a locally provisioned identity.
+//
+// If ExchangeUrl is empty the instance identity document is presented to the controller as is, which requires it to be
+// a JWT trusted by an external JWT signer (e.g. gcp). Otherwise, the document is POSTed as
+// `{"provider": "...", "document": "..."}` to ExchangeUrl, which must respond with `{"token": "..."}` containing a JWT
+// trusted by the controller. This is required for aws, whose identity documents are not JWTs.
+//
+// Tokens are fetched lazily and re-fetched when they near expiration.
+type InstanceIdentityCredentials struct {
+	JwtCredentials
+	Provider    InstanceIdentityProvider
+	ExchangeUrl string
+
+	// HttpClient is used to contact the metadata service and exchange endpoint. If nil, a client with a short timeout
+	// is used.
+	HttpClient *http.Client
+
+	lock      sync.Mutex
+	expiresAt time.Time
+}
+
+// NewInstanceIdentityCredentials creates a Credentials instance that authenticates with the instance identity of the
+// provided cloud provider. See InstanceIdentityCredentials.
+func NewInstanceIdentityCredentials(provider InstanceIdentityProvider, exchangeUrl string) *InstanceIdentityCredentials {
+	return &InstanceIdentityCredentials{
+		JwtCredentials: JwtCredentials{BaseCredentials: BaseCredentials{}},
+		Provider:       provider,
+		ExchangeUrl:    exchangeUrl,
+	}
+}
+
+func (c *InstanceIdentityCredentials) AuthenticateRequest(request runtime.ClientRequest, reg strfmt.Registry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.refresh(); err != nil {
+		return err
+	}
+	return c.JwtCredentials.AuthenticateRequest(request, reg)
+}
+
+// refresh fetches a new token if none is cached or the cached token is about to expire. Must be called with lock held.
+func (c *InstanceIdentityCredentials) refresh() error {
+	if c.JWT != "" && (c.expiresAt.IsZero() || time.Now().Add(instanceIdentityRefreshWindow).Before(c.expiresAt)) {
+		return nil
+	}
+
+	client := c.HttpClient
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	doc, err := c.Provider.Fetch(client)
+	if err != nil {
+		return err
+	}
+
+	token := doc
+	if c.ExchangeUrl != "" {
+		if token, err = c.exchange(client, doc); err != nil {
+			return err
+		}
+	}
+
+	c.JWT = token
+	c.expiresAt = time.Time{}
+
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err = jwt.NewParser().ParseUnverified(token, claims); err == nil && claims.ExpiresAt != nil {
+		c.expiresAt = claims.ExpiresAt.Time
+	}
+
+	return nil
+}
+
+type instanceIdentityExchangeRequest struct {
+	Provider string `json:"provider"`
+	Document string `json:"document"`
+}
+
+type instanceIdentityExchangeResponse struct {
+	Token string `json:"token"`
+}
+
+func (c *InstanceIdentityCredentials) exchange(client *http.Client, doc string) (string, error) {
+	body, err := json.Marshal(&instanceIdentityExchangeRequest{
+		Provider: c.Provider.Name(),
+		Document: doc,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Post(c.ExchangeUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrapf(err, "could not exchange instance identity at %s", c.ExchangeUrl)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %d exchanging instance identity at %s", resp.StatusCode, c.ExchangeUrl)
+	}
+
+	result := &instanceIdentityExchangeResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", errors.Wrapf(err, "could not decode instance identity exchange response from %s", c.ExchangeUrl)
+	}
+
+	if result.Token == "" {
+		return "", errors.Errorf("instance identity exchange at %s returned an empty token", c.ExchangeUrl)
+	}
+
+	return result.Token, nil
+}
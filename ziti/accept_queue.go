@@ -0,0 +1,41 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+const (
+	// MetricListenerAcceptQueued is the gauge of dialed connections waiting to be accepted, across all listeners.
+	MetricListenerAcceptQueued = "listener.accept.queued"
+
+	// MetricListenerAcceptShed is the meter of dialed connections rejected or dropped because an accept queue was
+	// full. See ListenOptions.AcceptQueueFullPolicy.
+	MetricListenerAcceptShed = "listener.accept.shed"
+)
+
+// registerAcceptQueueGauge registers the MetricListenerAcceptQueued gauge, if it isn't already.
+func (context *ContextImpl) registerAcceptQueueGauge() {
+	if context.metrics == nil {
+		return
+	}
+
+	context.metrics.FuncGauge(MetricListenerAcceptQueued, func() int64 {
+		var queued int64
+		for entry := range context.listenerManagers.IterBuffered() {
+			queued += int64(entry.Val.listener.GetAcceptQueueStats().Queued)
+		}
+		return queued
+	})
+}
@@ -0,0 +1,82 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// AddressDialer dials the service configured for a host and port, so that database and cache clients connect to
+// backends hosted on Ziti by configuring their usual address along with its dial function. Its methods match the
+// dial hooks of common client libraries:
+//
+//   - pgx: pgconn.Config.DialFunc = dialer.DialContext
+//   - go-redis: redis.Options.Dialer = dialer.DialContext
+//   - go-sql-driver/mysql: mysql.RegisterDialContext("ziti", dialer.DialAddr), with "user@ziti(db.internal:3306)/db"
+//     as DSN
+//   - lib/pq: pq.DialOpen(dialer, dsn)
+//
+// The service dialed for an address is, in order of preference, the one mapped to its host and port, the one mapped to
+// its host, the one whose intercept config best matches the host and port, or the service named after the host.
+type AddressDialer struct {
+	ztx     Context
+	options *httpTransportOptions
+}
+
+var _ Dialer = (*AddressDialer)(nil)
+var _ ContextDialer = (*AddressDialer)(nil)
+
+// NewAddressDialer returns an AddressDialer mapping addresses to services with ztx. Keys of services are either a
+// host, e.g. "db.internal", or a host and port, e.g. "db.internal:5432", which takes precedence.
+func NewAddressDialer(ztx Context, services map[string]string) *AddressDialer {
+	options := &httpTransportOptions{}
+	WithHTTPServices(services)(options)
+	return &AddressDialer{
+		ztx:     ztx,
+		options: options,
+	}
+}
+
+// DialContext dials the service for addr, a host and port.
+func (self *AddressDialer) DialContext(ctx gocontext.Context, network, addr string) (net.Conn, error) {
+	return self.options.dial(ctx, self.ztx, network, addr)
+}
+
+// Dial dials the service for addr, a host and port.
+func (self *AddressDialer) Dial(network, addr string) (net.Conn, error) {
+	return self.DialContext(gocontext.Background(), network, addr)
+}
+
+// DialTimeout dials the service for addr, a host and port, giving up after timeout.
+func (self *AddressDialer) DialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), timeout)
+	defer cancel()
+	return self.DialContext(ctx, network, addr)
+}
+
+// DialAddr dials the service for addr, a host and port, over tcp.
+func (self *AddressDialer) DialAddr(ctx gocontext.Context, addr string) (net.Conn, error) {
+	return self.DialContext(ctx, "tcp", addr)
+}
+
+// DialAddrPort dials the service for addr on network.
+func (self *AddressDialer) DialAddrPort(ctx gocontext.Context, network string, addr netip.AddrPort) (net.Conn, error) {
+	return self.DialContext(ctx, network, addr.String())
+}
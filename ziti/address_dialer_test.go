@@ -0,0 +1,53 @@
+package ziti
+
+import (
+	gocontext "context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AddressDialer(t *testing.T) {
+	req := require.New(t)
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = backend.Close() }()
+
+	ztx := &transportTestContext{proxyTestContext: proxyTestContext{addr: backend.Addr().String()}}
+	dialer := NewAddressDialer(ztx, map[string]string{
+		"db.internal":       "postgres",
+		"db.internal:6379":  "redis",
+		"sql.internal:3306": "mysql",
+		"2001:db8::10":      "ipv6-db",
+		"[fd00::1]:6379":    "ipv6-redis",
+	})
+
+	conn, err := dialer.Dial("tcp", "db.internal:5432")
+	req.NoError(err)
+	_ = conn.Close()
+
+	conn, err = dialer.DialTimeout("tcp", "db.internal:6379", time.Second)
+	req.NoError(err)
+	_ = conn.Close()
+
+	conn, err = dialer.DialAddr(gocontext.Background(), "sql.internal:3306")
+	req.NoError(err)
+	_ = conn.Close()
+
+	conn, err = dialer.Dial("tcp", "[2001:db8:0::10]:5432")
+	req.NoError(err)
+	_ = conn.Close()
+
+	conn, err = dialer.DialAddrPort(gocontext.Background(), "tcp", netip.MustParseAddrPort("[fd00::1]:6379"))
+	req.NoError(err)
+	_ = conn.Close()
+
+	req.Equal([]string{"postgres", "redis", "mysql", "ipv6-db", "ipv6-redis"}, ztx.dials)
+
+	_, err = dialer.Dial("tcp", "unknown.internal:5432")
+	req.Error(err)
+}
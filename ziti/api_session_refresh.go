@@ -0,0 +1,271 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/openziti/edge-api/rest_client_api_client/current_api_session"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultApiSessionRefreshFraction is the fraction of the remaining API Session lifetime after which it is
+	// refreshed, unless Options.ApiSessionRefreshFraction is set.
+	DefaultApiSessionRefreshFraction = 0.75
+
+	// DefaultApiSessionRefreshJitter is the largest fraction of the remaining API Session lifetime by which refreshes
+	// are moved earlier at random, unless Options.ApiSessionRefreshJitter is set.
+	DefaultApiSessionRefreshJitter = 0.1
+
+	// apiSessionHeartbeatInterval caps how long the refresh loop sleeps, so that a refresh missed while the host was
+	// suspended, or after the wall clock jumped, happens soon after rather than after the full delay.
+	apiSessionHeartbeatInterval = 30 * time.Second
+
+	minApiSessionRefreshDelay     = time.Second
+	apiSessionRefreshRetryDelay   = 5 * time.Second
+	maxApiSessionRefreshRetry     = time.Minute
+	defaultApiSessionRefreshDelay = 30 * time.Second
+)
+
+// ApiSessionRefreshStatus reports the background maintenance of the API Session of a Context.
+type ApiSessionRefreshStatus struct {
+	// NextRefresh is when the API Session will next be refreshed, or zero if no refresh is scheduled.
+	NextRefresh time.Time
+
+	// LastRefresh is when the API Session was last refreshed successfully, or zero if it has not been yet.
+	LastRefresh time.Time
+
+	// LastError is the error of the last refresh, or nil if it succeeded.
+	LastError error
+
+	// ConsecutiveFailures is the number of refreshes that failed since the last successful one.
+	ConsecutiveFailures int
+}
+
+type apiSessionRefresher struct {
+	lock   sync.Mutex
+	status ApiSessionRefreshStatus
+	reset  chan struct{}
+}
+
+// notifyReset makes the refresh loop reschedule from the current API Session, after authenticating again.
+func (self *apiSessionRefresher) notifyReset() {
+	select {
+	case self.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (self *apiSessionRefresher) getStatus() ApiSessionRefreshStatus {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.status
+}
+
+func (self *apiSessionRefresher) scheduled(next time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.status.NextRefresh = next
+}
+
+func (self *apiSessionRefresher) refreshed(err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.status.LastError = err
+	if err == nil {
+		self.status.LastRefresh = time.Now()
+		self.status.ConsecutiveFailures = 0
+	} else {
+		self.status.ConsecutiveFailures++
+	}
+}
+
+// apiSessionRemaining returns how much longer the API Session is valid, as of when it was received. For legacy API
+// Sessions the controller reports the remaining seconds, which doesn't depend on the local clock agreeing with the
+// controller's. OIDC token expiry is already computed from the local clock.
+func apiSessionRemaining(apiSession apis.ApiSession, receivedAt time.Time) time.Duration {
+	if legacy, ok := apiSession.(*apis.ApiSessionLegacy); ok && legacy.Detail != nil && legacy.Detail.ExpirationSeconds != nil {
+		return time.Duration(*legacy.Detail.ExpirationSeconds) * time.Second
+	}
+
+	if expiresAt := apiSession.GetExpiresAt(); expiresAt != nil {
+		return expiresAt.Sub(receivedAt)
+	}
+
+	return 0
+}
+
+// apiSessionRefreshDelay returns how long to wait before refreshing an API Session that is valid for remaining.
+// Refreshes happen at fraction of the remaining lifetime, moved earlier by up to jitter of it at random.
+func apiSessionRefreshDelay(remaining time.Duration, fraction, jitter float64) time.Duration {
+	if remaining <= 0 {
+		return defaultApiSessionRefreshDelay
+	}
+
+	delay := time.Duration(float64(remaining) * fraction)
+	if jitter > 0 {
+		delay -= time.Duration(rand.Float64() * jitter * float64(remaining))
+	}
+
+	if delay < minApiSessionRefreshDelay {
+		delay = minApiSessionRefreshDelay
+	}
+	return delay
+}
+
+// apiSessionRetryDelay returns the delay before retrying after the given number of consecutive failed refreshes,
+// doubling from apiSessionRefreshRetryDelay up to maxApiSessionRefreshRetry.
+func apiSessionRetryDelay(failures int) time.Duration {
+	delay := apiSessionRefreshRetryDelay
+	for i := 1; i < failures && delay < maxApiSessionRefreshRetry; i++ {
+		delay *= 2
+	}
+	if delay > maxApiSessionRefreshRetry {
+		delay = maxApiSessionRefreshRetry
+	}
+	return delay
+}
+
+func (self *Options) getApiSessionRefreshFraction() float64 {
+	if self.ApiSessionRefreshFraction <= 0 || self.ApiSessionRefreshFraction >= 1 {
+		return DefaultApiSessionRefreshFraction
+	}
+	return self.ApiSessionRefreshFraction
+}
+
+func (self *Options) getApiSessionRefreshJitter() float64 {
+	if self.ApiSessionRefreshJitter == 0 {
+		return DefaultApiSessionRefreshJitter
+	}
+	if self.ApiSessionRefreshJitter < 0 {
+		return 0
+	}
+	return self.ApiSessionRefreshJitter
+}
+
+func (context *ContextImpl) ApiSessionRefreshStatus() ApiSessionRefreshStatus {
+	return context.apiSessionRefresher.getStatus()
+}
+
+// scheduleApiSessionRefresh returns the wall clock time the current API Session should next be refreshed at.
+func (context *ContextImpl) scheduleApiSessionRefresh(receivedAt time.Time) time.Time {
+	delay := defaultApiSessionRefreshDelay
+	if apiSession := context.CtrlClt.GetCurrentApiSession(); apiSession != nil {
+		delay = apiSessionRefreshDelay(apiSessionRemaining(apiSession, receivedAt),
+			context.options.getApiSessionRefreshFraction(), context.options.getApiSessionRefreshJitter())
+	}
+
+	// strip the monotonic reading, so that comparisons use the wall clock, which keeps running while suspended
+	refreshAt := receivedAt.Add(delay).Round(0)
+	context.apiSessionRefresher.scheduled(refreshAt)
+	return refreshAt
+}
+
+// runApiSessionRefreshes keeps the API Session alive in the background, independent of service and session
+// refreshes, so that a slow or failing controller never holds up dials, which use the current token meanwhile.
+func (context *ContextImpl) runApiSessionRefreshes() {
+	log := context.log()
+	refresher := &context.apiSessionRefresher
+	refreshAt := context.scheduleApiSessionRefresh(time.Now())
+
+	for {
+		wait := time.Until(refreshAt)
+		if wait > apiSessionHeartbeatInterval {
+			wait = apiSessionHeartbeatInterval
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-context.closeNotify:
+				timer.Stop()
+				return
+			case <-refresher.reset:
+				timer.Stop()
+				refreshAt = context.scheduleApiSessionRefresh(time.Now())
+				continue
+			case <-timer.C:
+			}
+
+			if time.Now().Round(0).Before(refreshAt) {
+				continue
+			}
+		}
+
+		if context.CtrlClt.GetCurrentApiSession() == nil {
+			log.Debug("no api session to refresh, waiting for authentication")
+			refresher.scheduled(time.Time{})
+			select {
+			case <-context.closeNotify:
+				return
+			case <-refresher.reset:
+			}
+			refreshAt = context.scheduleApiSessionRefresh(time.Now())
+			continue
+		}
+
+		if err := context.rateLimits.apiSessionRefresh.wait(gocontext.Background(), context.closeNotify); err != nil {
+			return
+		}
+
+		err := context.refreshApiSession()
+		refresher.refreshed(err)
+
+		if err == nil {
+			refreshAt = context.scheduleApiSessionRefresh(time.Now())
+			continue
+		}
+
+		log.WithError(err).Error("could not refresh apiSession")
+		context.recordError(RecentApiSessionRefreshFailed, err)
+
+		unauthorizedErr := &current_api_session.GetCurrentAPISessionUnauthorized{}
+		if errors.As(err, &unauthorizedErr) {
+			log.Info("apiSession expired, attempting to authenticate")
+			if authErr := context.Authenticate(); authErr == nil {
+				refresher.refreshed(nil)
+				refreshAt = context.scheduleApiSessionRefresh(time.Now())
+				continue
+			} else {
+				log.WithError(authErr).Error("unable to authenticate after apiSession expired")
+			}
+		}
+
+		failures := refresher.getStatus().ConsecutiveFailures
+		refreshAt = time.Now().Add(apiSessionRetryDelay(failures)).Round(0)
+		refresher.scheduled(refreshAt)
+	}
+}
+
+func (context *ContextImpl) refreshApiSession() error {
+	newApiSession, err := context.CtrlClt.Refresh()
+	if err != nil {
+		return err
+	}
+
+	if exp := newApiSession.GetExpiresAt(); exp != nil {
+		context.log().Debugf("apiSession refreshed, new expiration[%s]", *exp)
+	}
+	context.updateTokenOnAllErs(newApiSession)
+	return nil
+}
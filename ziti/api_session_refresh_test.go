@@ -0,0 +1,96 @@
+package ziti
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/openziti/edge-api/rest_model"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_apiSessionRemaining(t *testing.T) {
+	req := require.New(t)
+
+	now := time.Now()
+
+	// the controller clock is an hour ahead, the remaining seconds it reports are used instead of the expiry
+	expiresAt := strfmt.DateTime(now.Add(time.Hour + 30*time.Minute))
+	expirationSeconds := int64(1800)
+	legacy := &apis.ApiSessionLegacy{
+		Detail: &rest_model.CurrentAPISessionDetail{
+			ExpiresAt:         &expiresAt,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+	req.Equal(30*time.Minute, apiSessionRemaining(legacy, now))
+
+	legacy.Detail.ExpirationSeconds = nil
+	req.Equal(90*time.Minute, apiSessionRemaining(legacy, now).Round(time.Minute))
+}
+
+func Test_apiSessionRefreshDelay(t *testing.T) {
+	req := require.New(t)
+
+	req.Equal(45*time.Minute, apiSessionRefreshDelay(time.Hour, 0.75, 0))
+	req.Equal(defaultApiSessionRefreshDelay, apiSessionRefreshDelay(0, 0.75, 0.1))
+	req.Equal(minApiSessionRefreshDelay, apiSessionRefreshDelay(time.Second, 0.5, 0))
+
+	for i := 0; i < 100; i++ {
+		delay := apiSessionRefreshDelay(time.Hour, 0.75, 0.1)
+		req.LessOrEqual(delay, 45*time.Minute)
+		req.GreaterOrEqual(delay, 39*time.Minute)
+	}
+}
+
+func Test_apiSessionRetryDelay(t *testing.T) {
+	req := require.New(t)
+
+	req.Equal(5*time.Second, apiSessionRetryDelay(1))
+	req.Equal(10*time.Second, apiSessionRetryDelay(2))
+	req.Equal(40*time.Second, apiSessionRetryDelay(4))
+	req.Equal(time.Minute, apiSessionRetryDelay(10))
+}
+
+func Test_apiSessionRefresher_status(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{}
+	refresher := &ctx.apiSessionRefresher
+
+	// notifying without a reset channel must not block
+	refresher.notifyReset()
+
+	next := time.Now().Add(time.Minute)
+	refresher.scheduled(next)
+	refreshErr := errors.New("controller unavailable")
+	refresher.refreshed(refreshErr)
+	refresher.refreshed(refreshErr)
+
+	status := ReadOnly(ctx).ApiSessionRefreshStatus()
+	req.Equal(next, status.NextRefresh)
+	req.Equal(refreshErr, status.LastError)
+	req.Equal(2, status.ConsecutiveFailures)
+	req.True(status.LastRefresh.IsZero())
+
+	refresher.refreshed(nil)
+	status = ctx.ApiSessionRefreshStatus()
+	req.NoError(status.LastError)
+	req.Zero(status.ConsecutiveFailures)
+	req.False(status.LastRefresh.IsZero())
+}
+
+func TestOptions_apiSessionRefreshDefaults(t *testing.T) {
+	req := require.New(t)
+
+	options := &Options{}
+	req.Equal(DefaultApiSessionRefreshFraction, options.getApiSessionRefreshFraction())
+	req.Equal(DefaultApiSessionRefreshJitter, options.getApiSessionRefreshJitter())
+
+	options.ApiSessionRefreshFraction = 0.5
+	options.ApiSessionRefreshJitter = -1
+	req.Equal(0.5, options.getApiSessionRefreshFraction())
+	req.Zero(options.getApiSessionRefreshJitter())
+}
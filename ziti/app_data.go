@@ -0,0 +1,116 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// CallerInfo describes the dialing side of a connection accepted from a hosted service.
+type CallerInfo struct {
+	// Identity is the name of the dialing identity, as reported by the edge router.
+	Identity string
+
+	// AppData is the data the dialer attached to the dial with DialOptions.AppData, if any.
+	AppData []byte
+
+	// CircuitId identifies the circuit of the connection, for correlating with controller and router logs.
+	CircuitId string
+}
+
+// callerInfoConn is implemented by edge.Conn and by the connection wrappers the SDK returns.
+type callerInfoConn interface {
+	GetAppData() []byte
+	SourceIdentifier() string
+	GetCircuitId() string
+}
+
+// GetCallerInfo returns the caller details of a connection accepted from a hosted service, so that the hosting
+// application can route or authorize it based on who dialed and with what app data. It returns false if conn is not
+// a Ziti connection.
+func GetCallerInfo(conn net.Conn) (*CallerInfo, bool) {
+	c, ok := conn.(callerInfoConn)
+	if !ok {
+		return nil, false
+	}
+
+	return &CallerInfo{
+		Identity:  c.SourceIdentifier(),
+		AppData:   c.GetAppData(),
+		CircuitId: c.GetCircuitId(),
+	}, true
+}
+
+// ConnInfo describes a connection accepted from a hosted service, as returned by GetConnInfo.
+type ConnInfo struct {
+	CallerInfo
+
+	// ServiceId and ServiceName identify the hosted service the connection was accepted for.
+	ServiceId   string
+	ServiceName string
+}
+
+// connInfoConn is implemented by edge.Conn and by the connection wrappers the SDK returns.
+type connInfoConn interface {
+	callerInfoConn
+	GetServiceId() string
+	GetServiceName() string
+}
+
+// GetConnInfo returns the caller and service details of a connection accepted from a hosted service, for authorizing
+// or annotating its requests without a lookup at the controller. The dialing identity is only known by name, as that
+// is all edge routers pass on. It returns false if conn is not a Ziti connection.
+func GetConnInfo(conn net.Conn) (*ConnInfo, bool) {
+	c, ok := conn.(connInfoConn)
+	if !ok {
+		return nil, false
+	}
+
+	return &ConnInfo{
+		CallerInfo: CallerInfo{
+			Identity:  c.SourceIdentifier(),
+			AppData:   c.GetAppData(),
+			CircuitId: c.GetCircuitId(),
+		},
+		ServiceId:   c.GetServiceId(),
+		ServiceName: c.GetServiceName(),
+	}, true
+}
+
+// DecodeAppData unmarshals the app data, which must be JSON, into target. It returns false if the dialer attached no
+// app data.
+func (self *CallerInfo) DecodeAppData(target interface{}) (bool, error) {
+	if len(self.AppData) == 0 {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(self.AppData, target); err != nil {
+		return true, errors.Wrap(err, "app data is not valid JSON")
+	}
+	return true, nil
+}
+
+// WithAppData attaches data to the dial, which the hosting application can read from the accepted connection with
+// GetCallerInfo. See DialOptions.AppData.
+func WithAppData(data []byte) DialOption {
+	return func(options *DialOptions) {
+		options.AppData = data
+	}
+}
@@ -0,0 +1,147 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/openziti/edge-api/rest_model"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/pkg/errors"
+)
+
+// ErrAuthQueryNotAnswerable is returned by an AuthHandler that cannot answer an authentication query, e.g. because it
+// has no user to ask for a TOTP code.
+var ErrAuthQueryNotAnswerable = errors.New("authentication query cannot be answered")
+
+// AuthHandler is consulted when a Context has to authenticate again after its API session expired or was removed,
+// and when the controller requires an authentication query, such as an MFA TOTP code, to be answered. Dials and binds
+// that need a new API session wait for the handler instead of failing. See Options.AuthHandler.
+type AuthHandler interface {
+	// Reauthenticate is called before each authentication after the first full authentication of the Context. It
+	// returns the credentials to authenticate with, or nil to reuse the current ones. Returning an error fails the
+	// authentication, and with it the operation that required the new API session.
+	Reauthenticate(ztx Context, current apis.Credentials) (apis.Credentials, error)
+
+	// AnswerAuthQuery returns the answer to an authentication query of the controller, e.g. the TOTP code for an MFA
+	// query.
+	AnswerAuthQuery(ztx Context, query *rest_model.AuthQueryDetail) (string, error)
+}
+
+// NonInteractiveAuthHandler re-authenticates with the configured credentials, which suits identities authenticating
+// with certificates. Authentication queries fail with ErrAuthQueryNotAnswerable rather than waiting for an answer.
+var NonInteractiveAuthHandler AuthHandler = nonInteractiveAuthHandler{}
+
+type nonInteractiveAuthHandler struct{}
+
+func (nonInteractiveAuthHandler) Reauthenticate(Context, apis.Credentials) (apis.Credentials, error) {
+	return nil, nil
+}
+
+func (nonInteractiveAuthHandler) AnswerAuthQuery(_ Context, query *rest_model.AuthQueryDetail) (string, error) {
+	return "", errors.Wrapf(ErrAuthQueryNotAnswerable, "no user to answer %s query", authQueryProvider(query))
+}
+
+// PromptFunc asks the user for a value. Secret is set when the value should not be echoed, e.g. for passwords.
+type PromptFunc func(prompt string, secret bool) (string, error)
+
+// InteractiveAuthHandler asks the user to answer MFA TOTP queries and, for identities authenticating with a username
+// and password, for the password each time the Context has to authenticate again.
+type InteractiveAuthHandler struct {
+	Prompt PromptFunc
+}
+
+// NewInteractiveAuthHandler returns an InteractiveAuthHandler writing prompts to out and reading the answers, one per
+// line, from in. Input is echoed as typed; applications that need to hide passwords should set their own Prompt.
+func NewInteractiveAuthHandler(in io.Reader, out io.Writer) *InteractiveAuthHandler {
+	var lock sync.Mutex
+	reader := bufio.NewReader(in)
+
+	return &InteractiveAuthHandler{
+		Prompt: func(prompt string, _ bool) (string, error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if _, err := fmt.Fprintf(out, "%s: ", prompt); err != nil {
+				return "", err
+			}
+
+			line, err := reader.ReadString('\n')
+			if err != nil && (err != io.EOF || line == "") {
+				return "", err
+			}
+			return strings.TrimRight(line, "\r\n"), nil
+		},
+	}
+}
+
+func (self *InteractiveAuthHandler) Reauthenticate(_ Context, current apis.Credentials) (apis.Credentials, error) {
+	updb, ok := current.(*apis.UpdbCredentials)
+	if !ok {
+		return nil, nil
+	}
+
+	password, err := self.Prompt(fmt.Sprintf("password for %s", updb.Username), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read password")
+	}
+
+	result := *updb
+	result.Password = password
+	return &result, nil
+}
+
+func (self *InteractiveAuthHandler) AnswerAuthQuery(_ Context, query *rest_model.AuthQueryDetail) (string, error) {
+	if query.Provider == nil || *query.Provider != rest_model.MfaProvidersZiti {
+		return "", errors.Wrapf(ErrAuthQueryNotAnswerable, "unsupported %s query", authQueryProvider(query))
+	}
+
+	code, err := self.Prompt("MFA TOTP code", false)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read MFA TOTP code")
+	}
+	return strings.TrimSpace(code), nil
+}
+
+func authQueryProvider(query *rest_model.AuthQueryDetail) string {
+	if query == nil || query.Provider == nil {
+		return "unknown"
+	}
+	return string(*query.Provider)
+}
+
+// reauthenticateWithHandler lets the AuthHandler replace the credentials before authenticating again.
+func (context *ContextImpl) reauthenticateWithHandler() error {
+	handler := context.options.AuthHandler
+	if handler == nil || !context.fullyAuthenticated.Load() {
+		return nil
+	}
+
+	credentials, err := handler.Reauthenticate(context, context.CtrlClt.Credentials)
+	if err != nil {
+		return errors.Wrap(err, "auth handler failed to provide credentials")
+	}
+
+	if credentials != nil {
+		context.CtrlClt.Credentials = credentials
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package ziti
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newZitiMfaQuery() *rest_model.AuthQueryDetail {
+	provider := rest_model.MfaProvidersZiti
+	return &rest_model.AuthQueryDetail{Provider: &provider}
+}
+
+func TestInteractiveAuthHandler(t *testing.T) {
+	req := require.New(t)
+
+	out := &bytes.Buffer{}
+	handler := NewInteractiveAuthHandler(strings.NewReader("s3cret\n 123456 \r\n"), out)
+
+	credentials, err := handler.Reauthenticate(nil, apis.NewUpdbCredentials("alice", "old"))
+	req.NoError(err)
+	req.Equal("alice", credentials.(*apis.UpdbCredentials).Username)
+	req.Equal("s3cret", credentials.(*apis.UpdbCredentials).Password)
+
+	code, err := handler.AnswerAuthQuery(nil, newZitiMfaQuery())
+	req.NoError(err)
+	req.Equal("123456", code)
+	req.Equal("password for alice: MFA TOTP code: ", out.String())
+
+	credentials, err = handler.Reauthenticate(nil, apis.NewCertCredentials(nil, nil))
+	req.NoError(err)
+	req.Nil(credentials)
+
+	provider := rest_model.MfaProviders("url")
+	_, err = handler.AnswerAuthQuery(nil, &rest_model.AuthQueryDetail{Provider: &provider})
+	req.ErrorIs(err, ErrAuthQueryNotAnswerable)
+}
+
+func TestNonInteractiveAuthHandler(t *testing.T) {
+	req := require.New(t)
+
+	credentials, err := NonInteractiveAuthHandler.Reauthenticate(nil, apis.NewUpdbCredentials("alice", "pw"))
+	req.NoError(err)
+	req.Nil(credentials)
+
+	_, err = NonInteractiveAuthHandler.AnswerAuthQuery(nil, newZitiMfaQuery())
+	req.ErrorIs(err, ErrAuthQueryNotAnswerable)
+}
+
+type testAuthHandler struct {
+	credentials apis.Credentials
+	err         error
+	queries     int
+}
+
+func (self *testAuthHandler) Reauthenticate(Context, apis.Credentials) (apis.Credentials, error) {
+	return self.credentials, self.err
+}
+
+func (self *testAuthHandler) AnswerAuthQuery(Context, *rest_model.AuthQueryDetail) (string, error) {
+	self.queries++
+	return "", self.err
+}
+
+func Test_contextImpl_authHandler(t *testing.T) {
+	req := require.New(t)
+
+	handlerErr := errors.New("user cancelled")
+	handler := &testAuthHandler{err: handlerErr}
+
+	ctx := &ContextImpl{
+		options:      &Options{AuthHandler: handler},
+		CtrlClt:      &CtrlClient{Credentials: apis.NewUpdbCredentials("alice", "pw")},
+		EventEmmiter: events.New(),
+	}
+
+	// the handler is only consulted once the context has authenticated
+	req.NoError(ctx.reauthenticateWithHandler())
+
+	ctx.fullyAuthenticated.Store(true)
+	req.ErrorIs(ctx.reauthenticateWithHandler(), handlerErr)
+
+	replacement := apis.NewUpdbCredentials("alice", "new")
+	handler.credentials, handler.err = replacement, nil
+	req.NoError(ctx.reauthenticateWithHandler())
+	req.Same(replacement, ctx.CtrlClt.Credentials)
+
+	handler.err = handlerErr
+	req.ErrorIs(ctx.handleAuthQuery(newZitiMfaQuery()), handlerErr)
+	req.Equal(1, handler.queries)
+}
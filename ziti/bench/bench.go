@@ -0,0 +1,256 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package bench measures the throughput and latency of the data path, so that performance regressions can be compared
+// release to release. Run opens concurrent connections to a benchmark service hosted with Serve or Host, and sends
+// messages of a configurable size over each, either waiting for every message to be echoed back, measuring round trip
+// latency, or streaming them, measuring throughput. Benchmark adapts Run to a testing.B.
+//
+// Run only needs a ziti.ConnDialer, so the same measurement can be made against a real network, a zititest.Harness, or a plain
+// net.Conn baseline.
+package bench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMessageSize = 1024
+	DefaultConcurrency = 1
+	DefaultDuration    = 10 * time.Second
+
+	// MaxMessageSize is the largest message size a benchmark service accepts.
+	MaxMessageSize = 16 << 20
+
+	frameHeaderSize = 4
+)
+
+// Mode selects what Run measures.
+type Mode byte
+
+const (
+	// ModeEcho sends each message once the previous one was echoed back, measuring round trip latency.
+	ModeEcho Mode = 'e'
+
+	// ModeStream sends messages back to back, which the service discards, measuring throughput. The service
+	// acknowledges the bytes it received at the end of the run, so slow receivers are accounted for.
+	ModeStream Mode = 's'
+)
+
+func (self Mode) String() string {
+	switch self {
+	case ModeEcho:
+		return "echo"
+	case ModeStream:
+		return "stream"
+	default:
+		return fmt.Sprintf("mode(%d)", byte(self))
+	}
+}
+
+// Options configures a Run.
+type Options struct {
+	// Mode defaults to ModeEcho.
+	Mode Mode
+
+	// MessageSize is the payload size of each message. Defaults to DefaultMessageSize.
+	MessageSize int
+
+	// Concurrency is the number of connections messages are sent over in parallel. Defaults to DefaultConcurrency.
+	Concurrency int
+
+	// Messages is the number of messages sent over each connection. If zero, messages are sent for Duration instead.
+	Messages int
+
+	// Duration is how long messages are sent for if Messages is zero. Defaults to DefaultDuration.
+	Duration time.Duration
+}
+
+func (self *Options) getMode() Mode {
+	if self == nil || self.Mode == 0 {
+		return ModeEcho
+	}
+	return self.Mode
+}
+
+func (self *Options) getMessageSize() int {
+	if self == nil || self.MessageSize <= 0 {
+		return DefaultMessageSize
+	}
+	return self.MessageSize
+}
+
+func (self *Options) getConcurrency() int {
+	if self == nil || self.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return self.Concurrency
+}
+
+func (self *Options) getMessages() int {
+	if self == nil || self.Messages < 0 {
+		return 0
+	}
+	return self.Messages
+}
+
+func (self *Options) getDuration() time.Duration {
+	if self == nil || self.Duration <= 0 {
+		return DefaultDuration
+	}
+	return self.Duration
+}
+
+func (self *Options) validate() error {
+	if mode := self.getMode(); mode != ModeEcho && mode != ModeStream {
+		return errors.Errorf("unsupported benchmark %s", mode)
+	}
+	if size := self.getMessageSize(); size > MaxMessageSize {
+		return errors.Errorf("message size %d exceeds maximum of %d", size, MaxMessageSize)
+	}
+	return nil
+}
+
+// Result is the outcome of a Run.
+type Result struct {
+	Mode        Mode
+	Connections int
+	MessageSize int
+
+	// Messages and Bytes count the messages and payload bytes sent, over all connections.
+	Messages int64
+	Bytes    int64
+
+	// Elapsed is the wall clock time from the first connection being dialed to the last one completing. Dialing is
+	// not included, see DialLatency.
+	Elapsed time.Duration
+
+	// DialLatency summarizes the time taken to dial each connection.
+	DialLatency Latencies
+
+	// Latency summarizes the round trip latency of each message. It is only measured in ModeEcho.
+	Latency Latencies
+}
+
+// Throughput returns the payload bytes sent per second.
+func (self *Result) Throughput() float64 {
+	if self.Elapsed <= 0 {
+		return 0
+	}
+	return float64(self.Bytes) / self.Elapsed.Seconds()
+}
+
+// MessageRate returns the messages sent per second.
+func (self *Result) MessageRate() float64 {
+	if self.Elapsed <= 0 {
+		return 0
+	}
+	return float64(self.Messages) / self.Elapsed.Seconds()
+}
+
+func (self *Result) String() string {
+	builder := &strings.Builder{}
+	_, _ = fmt.Fprintf(builder, "%s: %d connections, %d messages of %d bytes in %s, %.1f msg/s, %.2f MiB/s",
+		self.Mode, self.Connections, self.Messages, self.MessageSize, self.Elapsed.Round(time.Millisecond),
+		self.MessageRate(), self.Throughput()/(1<<20))
+	if self.Mode == ModeEcho {
+		_, _ = fmt.Fprintf(builder, ", latency %s", self.Latency.String())
+	}
+	return builder.String()
+}
+
+// Latencies summarizes a set of latency samples.
+type Latencies struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func (self Latencies) String() string {
+	return fmt.Sprintf("min %s, mean %s, p50 %s, p90 %s, p99 %s, max %s", self.Min, self.Mean, self.P50, self.P90, self.P99, self.Max)
+}
+
+// summarize returns the Latencies of the samples, sorting them in place.
+func summarize(samples []time.Duration) Latencies {
+	if len(samples) == 0 {
+		return Latencies{}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+
+	percentile := func(p float64) time.Duration {
+		idx := int(p*float64(len(samples))+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= len(samples) {
+			idx = len(samples) - 1
+		}
+		return samples[idx]
+	}
+
+	return Latencies{
+		Count: len(samples),
+		Min:   samples[0],
+		Mean:  total / time.Duration(len(samples)),
+		P50:   percentile(0.50),
+		P90:   percentile(0.90),
+		P99:   percentile(0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
+
+// writeFrame writes a message prefixed with its size. An empty message ends the run of a connection.
+func writeFrame(w io.Writer, buf []byte, payload int) error {
+	binary.BigEndian.PutUint32(buf, uint32(payload))
+	_, err := w.Write(buf[:frameHeaderSize+payload])
+	return err
+}
+
+// readFrame reads a message into buf, returning its payload size.
+func readFrame(r io.Reader, buf []byte) (int, error) {
+	if _, err := io.ReadFull(r, buf[:frameHeaderSize]); err != nil {
+		return 0, err
+	}
+
+	size := int(binary.BigEndian.Uint32(buf))
+	if size > len(buf)-frameHeaderSize {
+		return 0, errors.Errorf("message of %d bytes exceeds message size %d", size, len(buf)-frameHeaderSize)
+	}
+
+	if _, err := io.ReadFull(r, buf[frameHeaderSize:frameHeaderSize+size]); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
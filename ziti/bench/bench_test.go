@@ -0,0 +1,148 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/zititest"
+	"github.com/stretchr/testify/require"
+)
+
+func pipeDialer(context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		_ = HandleConn(server)
+	}()
+	return client, nil
+}
+
+func TestRun_echo(t *testing.T) {
+	req := require.New(t)
+
+	result, err := Run(context.Background(), pipeDialer, &Options{
+		Mode:        ModeEcho,
+		MessageSize: 128,
+		Concurrency: 3,
+		Messages:    50,
+	})
+	req.NoError(err)
+	req.Equal(3, result.Connections)
+	req.EqualValues(150, result.Messages)
+	req.EqualValues(150*128, result.Bytes)
+	req.Equal(150, result.Latency.Count)
+	req.Equal(3, result.DialLatency.Count)
+	req.LessOrEqual(result.Latency.Min, result.Latency.P50)
+	req.LessOrEqual(result.Latency.P50, result.Latency.P99)
+	req.LessOrEqual(result.Latency.P99, result.Latency.Max)
+	req.Positive(result.Throughput())
+	req.Contains(result.String(), "echo: 3 connections, 150 messages of 128 bytes")
+}
+
+func TestRun_stream(t *testing.T) {
+	req := require.New(t)
+
+	result, err := Run(context.Background(), pipeDialer, &Options{
+		Mode:        ModeStream,
+		MessageSize: 4096,
+		Duration:    50 * time.Millisecond,
+	})
+	req.NoError(err)
+	req.Equal(1, result.Connections)
+	req.Positive(result.Messages)
+	req.Equal(result.Messages*4096, result.Bytes)
+	req.Zero(result.Latency.Count)
+	req.GreaterOrEqual(result.Elapsed, 50*time.Millisecond)
+}
+
+func TestRun_invalidOptions(t *testing.T) {
+	req := require.New(t)
+
+	_, err := Run(context.Background(), pipeDialer, &Options{Mode: 'x'})
+	req.Error(err)
+
+	_, err = Run(context.Background(), pipeDialer, &Options{MessageSize: MaxMessageSize + 1})
+	req.Error(err)
+}
+
+func TestRun_cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := Run(ctx, pipeDialer, &Options{Duration: time.Hour})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSummarize(t *testing.T) {
+	req := require.New(t)
+
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+
+	latencies := summarize(samples)
+	req.Equal(100, latencies.Count)
+	req.Equal(time.Millisecond, latencies.Min)
+	req.Equal(50*time.Millisecond, latencies.P50)
+	req.Equal(90*time.Millisecond, latencies.P90)
+	req.Equal(99*time.Millisecond, latencies.P99)
+	req.Equal(100*time.Millisecond, latencies.Max)
+	req.Equal(50500*time.Microsecond, latencies.Mean)
+
+	req.Equal(Latencies{}, summarize(nil))
+}
+
+// newHarnessDialer returns a ziti.ConnDialer to a benchmark service hosted through a zititest.Harness, so that the SDK data
+// path, including end-to-end encryption and the edge router connection, is measured.
+func newHarnessDialer(b *testing.B) ziti.ConnDialer {
+	req := require.New(b)
+
+	harness, err := zititest.NewHarness()
+	req.NoError(err)
+	b.Cleanup(harness.Close)
+
+	harness.AddService("bench", nil)
+
+	server, err := harness.NewContext("server", nil)
+	req.NoError(err)
+	b.Cleanup(server.Close)
+
+	client, err := harness.NewContext("client", nil)
+	req.NoError(err)
+	b.Cleanup(client.Close)
+
+	dial, listener, err := Pair(server, client, "bench", func(options *ziti.ListenOptions) {
+		options.BindUsingEdgeIdentity = true
+	})
+	req.NoError(err)
+	b.Cleanup(func() { _ = listener.Close() })
+
+	req.Eventually(func() bool {
+		identities, err := client.GetServiceTerminatorIdentities("bench")
+		return err == nil && len(identities) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return dial
+}
+
+func BenchmarkEcho(b *testing.B) {
+	dial := newHarnessDialer(b)
+	for _, size := range []int{64, 1024, 16 * 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			Benchmark(b, dial, &Options{Mode: ModeEcho, MessageSize: size})
+		})
+	}
+}
+
+func BenchmarkStream(b *testing.B) {
+	dial := newHarnessDialer(b)
+	for _, concurrency := range []int{1, 4} {
+		b.Run(strconv.Itoa(concurrency), func(b *testing.B) {
+			Benchmark(b, dial, &Options{Mode: ModeStream, MessageSize: 32 * 1024, Concurrency: concurrency})
+		})
+	}
+}
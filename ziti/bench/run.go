@@ -0,0 +1,242 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// Run runs a benchmark against the service dial connects to. It returns the first error of any connection, after
+// stopping the others. Cancelling ctx stops the run early, returning ctx's error.
+func Run(ctx context.Context, dial ziti.ConnDialer, options *Options) (*Result, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	connections := options.getConcurrency()
+	result := &Result{
+		Mode:        options.getMode(),
+		Connections: connections,
+		MessageSize: options.getMessageSize(),
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	deadline := time.Time{}
+	if options.getMessages() == 0 {
+		deadline = time.Now().Add(options.getDuration())
+	}
+
+	runs := make([]*connRun, connections)
+	for i := range runs {
+		runs[i] = &connRun{
+			mode:        result.Mode,
+			messageSize: result.MessageSize,
+			messages:    options.getMessages(),
+			deadline:    deadline,
+		}
+	}
+
+	var firstErr error
+	var errLock sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for _, run := range runs {
+		wg.Add(1)
+		go func(run *connRun) {
+			defer wg.Done()
+			if err := run.run(runCtx, dial); err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errLock.Unlock()
+				cancel()
+			}
+		}(run)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var dialLatencies, latencies []time.Duration
+	var elapsed time.Duration
+	for _, run := range runs {
+		result.Messages += run.sent
+		result.Bytes += run.sent * int64(result.MessageSize)
+		dialLatencies = append(dialLatencies, run.dialLatency)
+		latencies = append(latencies, run.latencies...)
+		if runElapsed := run.completed.Sub(start) - run.dialLatency; runElapsed > elapsed {
+			elapsed = runElapsed
+		}
+	}
+
+	result.Elapsed = elapsed
+	result.DialLatency = summarize(dialLatencies)
+	result.Latency = summarize(latencies)
+	return result, nil
+}
+
+// Benchmark runs b.N messages, spread over the connections of options, reporting the throughput and, in ModeEcho,
+// the p50 and p99 round trip latency. options.Messages and options.Duration are ignored.
+func Benchmark(b *testing.B, dial ziti.ConnDialer, options *Options) {
+	b.Helper()
+
+	runOptions := Options{}
+	if options != nil {
+		runOptions = *options
+	}
+
+	connections := runOptions.getConcurrency()
+	runOptions.Messages = (b.N + connections - 1) / connections
+
+	b.SetBytes(int64(runOptions.getMessageSize()))
+	b.ResetTimer()
+
+	result, err := Run(context.Background(), dial, &runOptions)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.StopTimer()
+	if result.Mode == ModeEcho {
+		b.ReportMetric(float64(result.Latency.P50.Nanoseconds()), "p50-ns")
+		b.ReportMetric(float64(result.Latency.P99.Nanoseconds()), "p99-ns")
+	}
+}
+
+// connRun is the run of a single connection.
+type connRun struct {
+	mode        Mode
+	messageSize int
+	messages    int
+	deadline    time.Time
+
+	sent        int64
+	dialLatency time.Duration
+	latencies   []time.Duration
+	completed   time.Time
+}
+
+func (self *connRun) done() bool {
+	if self.messages > 0 {
+		return self.sent >= int64(self.messages)
+	}
+	return !time.Now().Before(self.deadline)
+}
+
+func (self *connRun) run(ctx context.Context, dial ziti.ConnDialer) error {
+	dialStart := time.Now()
+	conn, err := dial(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to dial benchmark service")
+	}
+	self.dialLatency = time.Since(dialStart)
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer func() {
+		stop()
+		_ = conn.Close()
+	}()
+
+	header := make([]byte, connHeaderSize)
+	header[0] = byte(self.mode)
+	binary.BigEndian.PutUint32(header[1:], uint32(self.messageSize))
+	if _, err = conn.Write(header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, frameHeaderSize+self.messageSize)
+	if self.mode == ModeEcho {
+		err = self.echo(conn, buf)
+	} else {
+		err = self.stream(conn, buf)
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	self.completed = time.Now()
+	return err
+}
+
+func (self *connRun) echo(conn net.Conn, buf []byte) error {
+	if self.messages > 0 {
+		self.latencies = make([]time.Duration, 0, self.messages)
+	}
+
+	reply := make([]byte, len(buf))
+	for !self.done() {
+		start := time.Now()
+		if err := writeFrame(conn, buf, self.messageSize); err != nil {
+			return err
+		}
+		n, err := readFrame(conn, reply)
+		if err != nil {
+			return err
+		}
+		if n != self.messageSize {
+			return errors.Errorf("echoed message has %d bytes, sent %d", n, self.messageSize)
+		}
+		self.latencies = append(self.latencies, time.Since(start))
+		self.sent++
+	}
+
+	return writeFrame(conn, buf, 0)
+}
+
+func (self *connRun) stream(conn net.Conn, buf []byte) error {
+	for !self.done() {
+		if err := writeFrame(conn, buf, self.messageSize); err != nil {
+			return err
+		}
+		self.sent++
+	}
+
+	if err := writeFrame(conn, buf, 0); err != nil {
+		return err
+	}
+
+	ack := make([]byte, 8)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return errors.Wrap(err, "benchmark service did not acknowledge the stream")
+	}
+
+	sent := uint64(self.sent) * uint64(self.messageSize)
+	if received := binary.BigEndian.Uint64(ack); received != sent {
+		return errors.Errorf("benchmark service received %d bytes, sent %d", received, sent)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package bench
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// connection header: the mode and the message size of the connection
+const connHeaderSize = 5
+
+// Serve handles the benchmark connections accepted from listener until accepting fails, returning that error.
+func Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := HandleConn(conn); err != nil {
+				pfxlog.Logger().WithError(err).Debug("benchmark connection failed")
+			}
+		}()
+	}
+}
+
+// HandleConn serves a single benchmark connection until its run ends, then closes it.
+func HandleConn(conn net.Conn) error {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	header := make([]byte, connHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+
+	mode := Mode(header[0])
+	size := int(binary.BigEndian.Uint32(header[1:]))
+	if size > MaxMessageSize {
+		return errors.Errorf("message size %d exceeds maximum of %d", size, MaxMessageSize)
+	}
+
+	buf := make([]byte, frameHeaderSize+size)
+
+	switch mode {
+	case ModeEcho:
+		for {
+			n, err := readFrame(conn, buf)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return nil
+			}
+			if err = writeFrame(conn, buf, n); err != nil {
+				return err
+			}
+		}
+	case ModeStream:
+		var received uint64
+		for {
+			n, err := readFrame(conn, buf)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				ack := make([]byte, 8)
+				binary.BigEndian.PutUint64(ack, received)
+				_, err = conn.Write(ack)
+				return err
+			}
+			received += uint64(n)
+		}
+	default:
+		return errors.Errorf("unsupported benchmark %s", mode)
+	}
+}
+
+// Host hosts the named service with ztx, serving benchmark connections until the returned listener is closed.
+func Host(ztx ziti.Context, serviceName string, opts ...ziti.ListenOption) (edge.Listener, error) {
+	listener, err := ztx.ListenWithOptions(serviceName, ziti.NewListenOptions(opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_ = Serve(listener)
+	}()
+	return listener, nil
+}
+
+// Pair hosts the named service with server and returns a ziti.ConnDialer dialing it with client. Closing the returned
+// io.Closer stops hosting the service.
+func Pair(server, client ziti.Context, serviceName string, opts ...ziti.ListenOption) (ziti.ConnDialer, io.Closer, error) {
+	listener, err := Host(server, serviceName, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ziti.ServiceDialer(client, serviceName), listener, nil
+}
@@ -17,12 +17,15 @@
 package ziti
 
 import (
+	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"github.com/go-openapi/strfmt"
@@ -44,6 +47,7 @@ import (
 	"github.com/openziti/sdk-golang/ziti/edge/posture"
 	"github.com/openziti/transport/v2"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"strings"
 )
 
@@ -62,6 +66,12 @@ type CtrlClient struct {
 
 	PostureCache *posture.Cache
 	ConfigTypes  []string
+
+	logger *logrus.Logger
+}
+
+func (self *CtrlClient) log() *pfxlog.Builder {
+	return newLogger(self.logger)
 }
 
 // GetCurrentApiSession returns the current cached ApiSession or nil
@@ -119,6 +129,19 @@ func (self *CtrlClient) Authenticate() (apis.ApiSession, error) {
 	return apiSession, nil
 }
 
+// Logout removes the current ApiSession from the controller, invalidating any sessions created with it. It is a no-op
+// if there is no current ApiSession.
+func (self *CtrlClient) Logout(ctx context.Context) error {
+	apiSession := self.GetCurrentApiSession()
+	if apiSession == nil {
+		return nil
+	}
+
+	_, err := self.API.CurrentAPISession.DeleteCurrentAPISession(current_api_session.NewDeleteCurrentAPISessionParamsWithContext(ctx), apiSession)
+
+	return rest_util.WrapErr(err)
+}
+
 // AuthenticateMFA handles MFA authentication queries may be provided. AuthenticateMFA allows
 // the current identity for their current api session to attempt to pass MFA authentication.
 func (self *CtrlClient) AuthenticateMFA(code string) error {
@@ -173,6 +196,18 @@ func (self *CtrlClient) GetCurrentIdentity() (*rest_model.IdentityDetail, error)
 	return resp.Payload.Data, nil
 }
 
+// GetCurrentIdentityEdgeRouters returns the edge routers the identity of the current ApiSession may connect to.
+func (self *CtrlClient) GetCurrentIdentityEdgeRouters() ([]*rest_model.CurrentIdentityEdgeRouterDetail, error) {
+	params := current_identity.NewGetCurrentIdentityEdgeRoutersParams()
+	resp, err := self.API.CurrentIdentity.GetCurrentIdentityEdgeRouters(params, self.GetCurrentApiSession())
+
+	if err != nil {
+		return nil, rest_util.WrapErr(err)
+	}
+
+	return resp.Payload.Data, nil
+}
+
 // GetSession returns the full rest_model.SessionDetail for a specific id. Does not function with JWT backed sessions.
 func (self *CtrlClient) GetSession(id string) (*rest_model.SessionDetail, error) {
 	params := session.NewDetailSessionParams()
@@ -313,20 +348,23 @@ func (self *CtrlClient) NewApiSessionCertificate() error {
 		return fmt.Errorf("expected at least 1 certificate creating an API Session Certificate, got 0")
 	}
 
-	pfxlog.Logger().Infof("new API Session Certificate: %x", sha1.Sum(certs[0].Raw))
+	self.log().Infof("new API Session Certificate: %x", sha1.Sum(certs[0].Raw))
 
 	self.ApiSessionCertificate = certs[0]
 
 	return nil
 }
 
+// maxServicePageSize is the largest page of services the controller will return.
+const maxServicePageSize = 500
+
 // GetServices will fetch the list of services that the identity of the current ApiSession has access to for dialing
 // or binding.
 func (self *CtrlClient) GetServices() ([]*rest_model.ServiceDetail, error) {
 	params := service.NewListServicesParams()
 
 	pageOffset := int64(0)
-	pageLimit := int64(500)
+	pageLimit := int64(maxServicePageSize)
 
 	var services []*rest_model.ServiceDetail
 
@@ -356,6 +394,31 @@ func (self *CtrlClient) GetServices() ([]*rest_model.ServiceDetail, error) {
 	return services, nil
 }
 
+// QueryServices returns a page of the services the identity of the current ApiSession has access to that match the
+// filter, in the controller's filter syntax, along with the total number of matching services. An empty filter
+// matches all services.
+func (self *CtrlClient) QueryServices(filter string, limit, offset int) ([]*rest_model.ServiceDetail, int, error) {
+	params := service.NewListServicesParams()
+
+	if filter != "" {
+		params.Filter = &filter
+	}
+
+	pageLimit := int64(limit)
+	params.Limit = &pageLimit
+
+	pageOffset := int64(offset)
+	params.Offset = &pageOffset
+
+	resp, err := self.API.Service.ListServices(params, self.GetCurrentApiSession())
+
+	if err != nil {
+		return nil, 0, rest_util.WrapErr(err)
+	}
+
+	return resp.Payload.Data, int(*resp.Payload.Meta.Pagination.TotalCount), nil
+}
+
 // GetService will fetch the specific service requested. If the service doesn't exist,
 // nil will be returned
 func (self *CtrlClient) GetService(name string) (*rest_model.ServiceDetail, error) {
@@ -461,6 +524,150 @@ func (self *CtrlClient) RemoveMfa(code string) error {
 	return rest_util.WrapErr(err)
 }
 
+// GetMfa returns the TOTP MFA enrollment of the currently authenticated identity. The provisioning URL and recovery
+// codes are only provided until the enrollment has been verified.
+func (self *CtrlClient) GetMfa() (*rest_model.DetailMfa, error) {
+	params := current_identity.NewDetailMfaParams()
+
+	resp, err := self.API.CurrentIdentity.DetailMfa(params, self.GetCurrentApiSession())
+
+	if err != nil {
+		return nil, rest_util.WrapErr(err)
+	}
+
+	return resp.Payload.Data, nil
+}
+
+// GetMfaRecoveryCodes returns the unused recovery codes of the verified TOTP MFA enrollment of the currently
+// authenticated identity. Code must be a current TOTP code or an unused recovery code.
+func (self *CtrlClient) GetMfaRecoveryCodes(code string) ([]string, error) {
+	params := current_identity.NewDetailMfaRecoveryCodesParams()
+	params.MfaValidationCode = &code
+
+	resp, err := self.API.CurrentIdentity.DetailMfaRecoveryCodes(params, self.GetCurrentApiSession())
+
+	if err != nil {
+		return nil, rest_util.WrapErr(err)
+	}
+
+	// the API specification declares an empty payload, the recovery codes are decoded from the untyped data
+	data, err := json.Marshal(resp.Payload.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read mfa recovery codes")
+	}
+
+	recoveryCodes := &rest_model.DetailMfaRecoveryCodes{}
+	if err = json.Unmarshal(data, recoveryCodes); err != nil {
+		return nil, errors.Wrap(err, "could not read mfa recovery codes")
+	}
+
+	return recoveryCodes.RecoveryCodes, nil
+}
+
+// NewMfaRecoveryCodes replaces the recovery codes of the verified TOTP MFA enrollment of the currently authenticated
+// identity. The controller does not return the new codes, they are read with GetMfaRecoveryCodes. Code must be a
+// current TOTP code or an unused recovery code.
+func (self *CtrlClient) NewMfaRecoveryCodes(code string) error {
+	params := current_identity.NewCreateMfaRecoveryCodesParams()
+	params.MfaValidation = &rest_model.MfaCode{
+		Code: &code,
+	}
+
+	_, err := self.API.CurrentIdentity.CreateMfaRecoveryCodes(params, self.GetCurrentApiSession())
+
+	return rest_util.WrapErr(err)
+}
+
+// GetAuthenticators returns the authenticators of the identity of the current ApiSession.
+func (self *CtrlClient) GetAuthenticators() ([]*rest_model.AuthenticatorDetail, error) {
+	params := current_api_session.NewListCurrentIdentityAuthenticatorsParams()
+
+	resp, err := self.API.CurrentAPISession.ListCurrentIdentityAuthenticators(params, self.GetCurrentApiSession())
+
+	if err != nil {
+		return nil, rest_util.WrapErr(err)
+	}
+
+	return resp.Payload.Data, nil
+}
+
+// UpdatePassword changes the username and password of an updb authenticator of the current identity. The current
+// password must be supplied.
+func (self *CtrlClient) UpdatePassword(authenticatorId, username, currentPassword, newPassword string) error {
+	params := current_api_session.NewUpdateCurrentIdentityAuthenticatorParams()
+	params.ID = authenticatorId
+
+	restUsername := rest_model.Username(username)
+	restPassword := rest_model.Password(newPassword)
+	restCurrentPassword := rest_model.Password(currentPassword)
+
+	params.Authenticator = &rest_model.AuthenticatorUpdateWithCurrent{
+		AuthenticatorUpdate: rest_model.AuthenticatorUpdate{
+			Username: &restUsername,
+			Password: &restPassword,
+		},
+		CurrentPassword: &restCurrentPassword,
+	}
+
+	_, err := self.API.CurrentAPISession.UpdateCurrentIdentityAuthenticator(params, self.GetCurrentApiSession())
+
+	return rest_util.WrapErr(err)
+}
+
+// ExtendCertAuthenticator requests a new client certificate for a cert authenticator of the current identity, issued
+// for the public key of the provided private key. The authenticator keeps accepting the existing certificate until the
+// new one is confirmed via VerifyCertAuthenticatorExtension.
+func (self *CtrlClient) ExtendCertAuthenticator(authenticatorId string, subject pkix.Name, key crypto.Signer) ([]*x509.Certificate, error) {
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject}, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create certificate signing request")
+	}
+
+	csrPem := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: csrBytes,
+	}))
+
+	params := current_api_session.NewExtendCurrentIdentityAuthenticatorParams()
+	params.ID = authenticatorId
+	params.Extend = &rest_model.IdentityExtendEnrollmentRequest{
+		ClientCertCsr: &csrPem,
+	}
+
+	resp, err := self.API.CurrentAPISession.ExtendCurrentIdentityAuthenticator(params, self.GetCurrentApiSession())
+
+	if err != nil {
+		return nil, rest_util.WrapErr(err)
+	}
+
+	certs := nfPem.PemBytesToCertificates([]byte(resp.Payload.Data.ClientCert))
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("expected at least 1 certificate extending authenticator %s, got 0", authenticatorId)
+	}
+
+	return certs, nil
+}
+
+// VerifyCertAuthenticatorExtension confirms that the certificate issued by ExtendCertAuthenticator has been received.
+// After this call, the authenticator only accepts the new certificate.
+func (self *CtrlClient) VerifyCertAuthenticatorExtension(authenticatorId string, cert *x509.Certificate) error {
+	certPem := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	}))
+
+	params := current_api_session.NewExtendVerifyCurrentIdentityAuthenticatorParams()
+	params.ID = authenticatorId
+	params.Extend = &rest_model.IdentityExtendValidateEnrollmentRequest{
+		ClientCert: &certPem,
+	}
+
+	_, err := self.API.CurrentAPISession.ExtendVerifyCurrentIdentityAuthenticator(params, self.GetCurrentApiSession())
+
+	return rest_util.WrapErr(err)
+}
+
 // sanitizeSessionUrls will transform ER urls to transport friendly URIs and remove
 // any addresses that cannot be parsed
 func (self *CtrlClient) sanitizeSessionUrls(session *rest_model.SessionDetail) {
@@ -471,7 +678,7 @@ func (self *CtrlClient) sanitizeSessionUrls(session *rest_model.SessionDetail) {
 			if _, err := transport.ParseAddress(url); err == nil {
 				newUrls[protocol] = url
 			} else {
-				pfxlog.Logger().WithError(err).Debugf("ignoring address [%s] for router [%s], as it can't be parsed", url, genext.OrDefault(edgeRouter.Name))
+				self.log().WithError(err).Debugf("ignoring address [%s] for router [%s], as it can't be parsed", url, genext.OrDefault(edgeRouter.Name))
 			}
 		}
 		edgeRouter.SupportedProtocols = newUrls
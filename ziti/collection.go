@@ -18,13 +18,50 @@ package ziti
 
 import (
 	"context"
+	"fmt"
+	"github.com/kataras/go-events"
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"log/slog"
+	"math/rand"
 	"net"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// ContextSelectionStrategy is used by CtxCollection.Dial to pick a Context when more than one Context in the collection
+// has dial access to a service. The candidates are provided sorted by Context id and will always contain at least one
+// entry. Returning nil aborts the dial.
+type ContextSelectionStrategy func(serviceName string, candidates []Context) Context
+
+// SelectFirstContext is a ContextSelectionStrategy that always selects the candidate with the lowest Context id. It is
+// the default strategy used by CtxCollection.
+func SelectFirstContext(_ string, candidates []Context) Context {
+	return candidates[0]
+}
+
+// SelectRandomContext is a ContextSelectionStrategy that selects a random candidate.
+func SelectRandomContext(_ string, candidates []Context) Context {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// NewRoundRobinContextSelection returns a ContextSelectionStrategy that rotates through the candidates on each dial.
+func NewRoundRobinContextSelection() ContextSelectionStrategy {
+	var counter atomic.Uint64
+	return func(_ string, candidates []Context) Context {
+		idx := (counter.Add(1) - 1) % uint64(len(candidates))
+		return candidates[idx]
+	}
+}
+
 // An CtxCollection allows Context instances to be instantiated and maintained as a group. Useful in scenarios
 // where multiple Context instances are managed together. Instead of using ziti.NewContext() like functions, use
 // the function provided on this type to automatically have contexts added as they are created. If ConfigTypes
@@ -51,68 +88,368 @@ import (
 type CtxCollection struct {
 	contexts    cmap.ConcurrentMap[string, Context]
 	ConfigTypes []string
+
+	// DialStrategy selects the Context used by Dial when multiple contexts have access to the same service. If nil,
+	// SelectFirstContext is used.
+	DialStrategy ContextSelectionStrategy
+
+	// LogHandler, if set, receives the logs of the collection, and of the contexts it creates whose Options don't set
+	// their own LogHandler.
+	LogHandler slog.Handler
+
+	emitter       events.EventEmmiter
+	subscriptions cmap.ConcurrentMap[string, func()]
+	members       cmap.ConcurrentMap[string, *CollectionMember]
 }
 
 // NewSdkCollection creates a new empty collection.
 func NewSdkCollection() *CtxCollection {
 	return &CtxCollection{
-		contexts: cmap.New[Context](),
+		contexts:      cmap.New[Context](),
+		emitter:       events.New(),
+		subscriptions: cmap.New[func()](),
+		members:       cmap.New[*CollectionMember](),
 	}
 }
 
+// DefaultCreateParallelism is the number of contexts created concurrently when populating a collection from multiple
+// identity files, if no other limit is given.
+const DefaultCreateParallelism = 16
+
 // NewSdkCollectionFromEnv will create an empty CtxCollection and then attempt to populate it from configuration files
-// provided in a semicolon separate list of file paths retrieved from an environment variable.
+// provided in a semicolon separate list of file paths retrieved from an environment variable. Contexts are created
+// concurrently, see NewContextsFromFiles. Files that fail to load are logged and skipped.
 func NewSdkCollectionFromEnv(envVariable string) *CtxCollection {
-	collection := NewSdkCollection()
+	collection, _ := NewSdkCollectionFromEnvWithOpts(envVariable, nil, DefaultCreateParallelism)
+	return collection
+}
 
-	envValue := os.Getenv(envVariable)
+// NewSdkCollectionFromEnvWithOpts is the same as NewSdkCollectionFromEnv, but creates each Context with the given
+// Options and at most parallelism at a time. The collection is always returned, populated with the contexts that
+// could be created. If any file fails to load, a ContextErrors keyed by file path is returned as well.
+func NewSdkCollectionFromEnvWithOpts(envVariable string, options *Options, parallelism int) (*CtxCollection, error) {
+	collection := NewSdkCollection()
 
-	identityFiles := strings.Split(envValue, ";")
+	var identityFiles []string
+	for _, identityFile := range strings.Split(os.Getenv(envVariable), ";") {
+		if identityFile != "" {
+			identityFiles = append(identityFiles, identityFile)
+		}
+	}
 
-	for _, identityFile := range identityFiles {
+	return collection, collection.NewContextsFromFiles(identityFiles, options, parallelism)
+}
 
-		if identityFile == "" {
-			continue
-		}
-		cfg, err := NewConfigFromFile(identityFile)
+// NewContextsFromFiles creates a Context for each of the given identity files and adds them to the collection. Up to
+// parallelism contexts are created concurrently; if parallelism is less than 1, DefaultCreateParallelism is used.
+// Files that fail to load are logged and skipped, and their errors returned as ContextErrors keyed by file path.
+func (set *CtxCollection) NewContextsFromFiles(identityFiles []string, options *Options, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = DefaultCreateParallelism
+	}
 
-		if err != nil {
-			pfxlog.Logger().Errorf("failed to load config from file '%s'", identityFile)
-			continue
-		}
+	lock := sync.Mutex{}
+	createErrors := ContextErrors{}
 
-		//collection.NewContext stores the new ctx in its internal collection
-		_, err = collection.NewContext(cfg)
+	semaphore := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
 
-		if err != nil {
-			pfxlog.Logger().Errorf("failed to create context from '%s'", identityFile)
-			continue
-		}
+	for _, identityFile := range identityFiles {
+		semaphore <- struct{}{}
+		wg.Add(1)
+
+		go func(identityFile string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			if _, err := set.NewContextFromFileWithOpts(identityFile, options); err != nil {
+				set.log().WithError(err).Errorf("failed to create context from '%s'", identityFile)
+				lock.Lock()
+				createErrors[identityFile] = err
+				lock.Unlock()
+			}
+		}(identityFile)
 	}
 
-	return collection
+	wg.Wait()
+
+	if len(createErrors) > 0 {
+		return createErrors
+	}
+	return nil
 }
 
 // Add allows the arbitrary idempotent inclusion of a Context in the current collection. If a Context with the same id
 // as an existing Context is added and is a different instance, the original is closed and removed.
 func (set *CtxCollection) Add(ctx Context) {
+	var replaced Context
+	added := false
+
 	set.contexts.Upsert(ctx.GetId(), ctx, func(exist bool, valueInMap Context, newValue Context) Context {
 		if exist && valueInMap != nil && valueInMap != newValue {
-			valueInMap.Close()
+			replaced = valueInMap
 		}
+		added = !exist || valueInMap != newValue
 
 		return newValue
 	})
+
+	if replaced != nil {
+		set.unsubscribe(replaced.GetId())
+		set.members.Remove(replaced.GetId())
+		replaced.Close()
+		set.emitter.Emit(EventContextRemoved, replaced)
+	}
+
+	if added {
+		set.subscribe(ctx)
+		set.emitter.Emit(EventContextAdded, ctx)
+	}
 }
 
 // Remove removes the supplied Context from the collection. It is not closed or altered in any way.
 func (set *CtxCollection) Remove(ctx Context) {
-	set.contexts.Remove(ctx.GetId())
+	set.RemoveById(ctx.GetId())
 }
 
 // RemoveById removes a context by its string id.  It is not closed or altered in any way.
 func (set *CtxCollection) RemoveById(id string) {
-	set.contexts.Remove(id)
+	if ctx, found := set.contexts.Pop(id); found {
+		set.unsubscribe(id)
+		set.members.Remove(id)
+		set.emitter.Emit(EventContextRemoved, ctx)
+	}
+}
+
+// subscribe forwards the authentication events of a Context to the listeners of the collection.
+func (set *CtxCollection) subscribe(ctx Context) {
+	eventer := ctx.Events()
+
+	removeAuthenticated := eventer.AddAuthenticationStateFullListener(func(ztx Context, apiSession edge_apis.ApiSession) {
+		set.emitter.Emit(EventContextAuthenticated, ztx, apiSession)
+	})
+
+	removeFailed := eventer.AddAuthenticationFailedListener(func(ztx Context, err error) {
+		set.emitter.Emit(EventContextFailed, ztx, err)
+	})
+
+	set.subscriptions.Set(ctx.GetId(), func() {
+		removeAuthenticated()
+		removeFailed()
+	})
+}
+
+func (set *CtxCollection) unsubscribe(id string) {
+	if remove, found := set.subscriptions.Pop(id); found {
+		remove()
+	}
+}
+
+// AddContextAddedListener adds an event listener for the EventContextAdded event and returns a function to remove the
+// listener. It is emitted any time a Context instance is added to the collection.
+func (set *CtxCollection) AddContextAddedListener(handler func(Context)) func() {
+	listener := func(args ...interface{}) {
+		handler(set.contextArg(args))
+	}
+
+	set.emitter.AddListener(EventContextAdded, listener)
+
+	return func() {
+		set.emitter.RemoveListener(EventContextAdded, listener)
+	}
+}
+
+// AddContextRemovedListener adds an event listener for the EventContextRemoved event and returns a function to remove
+// the listener. It is emitted any time a Context instance leaves the collection, including when it is replaced by
+// another instance with the same id.
+func (set *CtxCollection) AddContextRemovedListener(handler func(Context)) func() {
+	listener := func(args ...interface{}) {
+		handler(set.contextArg(args))
+	}
+
+	set.emitter.AddListener(EventContextRemoved, listener)
+
+	return func() {
+		set.emitter.RemoveListener(EventContextRemoved, listener)
+	}
+}
+
+// AddContextAuthenticatedListener adds an event listener for the EventContextAuthenticated event and returns a function
+// to remove the listener. It is emitted any time a Context in the collection becomes fully authenticated.
+func (set *CtxCollection) AddContextAuthenticatedListener(handler func(Context, edge_apis.ApiSession)) func() {
+	listener := func(args ...interface{}) {
+		apiSession, ok := args[1].(edge_apis.ApiSession)
+
+		if !ok {
+			set.log().Fatalf("could not convert args[1] to %T was %T", apiSession, args[1])
+		}
+
+		handler(set.contextArg(args), apiSession)
+	}
+
+	set.emitter.AddListener(EventContextAuthenticated, listener)
+
+	return func() {
+		set.emitter.RemoveListener(EventContextAuthenticated, listener)
+	}
+}
+
+// AddContextFailedListener adds an event listener for the EventContextFailed event and returns a function to remove
+// the listener. It is emitted any time a Context in the collection fails to authenticate.
+func (set *CtxCollection) AddContextFailedListener(handler func(Context, error)) func() {
+	listener := func(args ...interface{}) {
+		err, ok := args[1].(error)
+
+		if !ok {
+			set.log().Fatalf("could not convert args[1] to %T was %T", err, args[1])
+		}
+
+		handler(set.contextArg(args), err)
+	}
+
+	set.emitter.AddListener(EventContextFailed, listener)
+
+	return func() {
+		set.emitter.RemoveListener(EventContextFailed, listener)
+	}
+}
+
+func (set *CtxCollection) contextArg(args []interface{}) Context {
+	ctx, ok := args[0].(Context)
+
+	if !ok {
+		set.log().Fatalf("could not convert args[0] to %T was %T", ctx, args[0])
+	}
+
+	return ctx
+}
+
+// GetById returns the Context with the given id, if it is in the collection.
+func (set *CtxCollection) GetById(id string) (Context, bool) {
+	return set.contexts.Get(id)
+}
+
+// Len returns the number of Context instances in the collection.
+func (set *CtxCollection) Len() int {
+	return set.contexts.Count()
+}
+
+// Ids returns the ids of the Context instances in the collection, sorted.
+func (set *CtxCollection) Ids() []string {
+	ids := set.contexts.Keys()
+	sort.Strings(ids)
+	return ids
+}
+
+// Filter returns the Context instances for which `pred` returns true, sorted by id.
+func (set *CtxCollection) Filter(pred func(ctx Context) bool) []Context {
+	var result []Context
+	set.ForAll(func(ctx Context) {
+		if pred(ctx) {
+			result = append(result, ctx)
+		}
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetId() < result[j].GetId()
+	})
+
+	return result
+}
+
+// CollectionService is a service available through one or more Context instances in a CtxCollection.
+type CollectionService struct {
+	// Service is the service detail as provided by the first Context, by id, that has access to the service.
+	Service rest_model.ServiceDetail
+
+	// Contexts are the Context instances that have access to the service, sorted by id.
+	Contexts []Context
+}
+
+// ContextIds returns the ids of the Context instances that provide the service.
+func (s *CollectionService) ContextIds() []string {
+	var result []string
+	for _, ctx := range s.Contexts {
+		result = append(result, ctx.GetId())
+	}
+	return result
+}
+
+// GetServices returns the union of the services of all Context instances in the collection, deduplicated by service id
+// and sorted by service name. Each service is annotated with the contexts that provide it. If some contexts fail to
+// list their services, the services of the remaining contexts are returned along with a ContextErrors.
+func (set *CtxCollection) GetServices() ([]*CollectionService, error) {
+	byId := map[string]*CollectionService{}
+	ctxErrors := ContextErrors{}
+
+	for _, ctx := range set.Filter(func(Context) bool { return true }) {
+		services, err := ctx.GetServices()
+		if err != nil {
+			ctxErrors[ctx.GetId()] = err
+			continue
+		}
+
+		for _, svc := range services {
+			if svc.ID == nil {
+				continue
+			}
+
+			entry, found := byId[*svc.ID]
+			if !found {
+				entry = &CollectionService{Service: svc}
+				byId[*svc.ID] = entry
+			}
+			entry.Contexts = append(entry.Contexts, ctx)
+		}
+	}
+
+	result := make([]*CollectionService, 0, len(byId))
+	for _, entry := range byId {
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		iName, jName := stringz.OrEmpty(result[i].Service.Name), stringz.OrEmpty(result[j].Service.Name)
+		if iName == jName {
+			return *result[i].Service.ID < *result[j].Service.ID
+		}
+		return iName < jName
+	})
+
+	if len(ctxErrors) > 0 {
+		return result, ctxErrors
+	}
+	return result, nil
+}
+
+// ContextErrors maps the ids of Context instances, or the identity files they are created from, to the error an
+// operation on them produced.
+type ContextErrors map[string]error
+
+func (e ContextErrors) Error() string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	buf := strings.Builder{}
+	for i, id := range ids {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(fmt.Sprintf("%s: %v", id, e[id]))
+	}
+	return buf.String()
+}
+
+// CloseAll gracefully closes every Context in the collection and removes them from the collection. It is Shutdown
+// without per-phase timeouts, so in-flight connections are given until ctx is done to drain. If any Context fails to
+// close cleanly, a ContextErrors is returned describing the failure of each.
+func (set *CtxCollection) CloseAll(ctx context.Context) error {
+	return set.Shutdown(ctx, nil)
 }
 
 // ForAll call the provided function `f` on each Context.
@@ -129,7 +466,7 @@ func (set *CtxCollection) NewContextFromFile(file string) (Context, error) {
 }
 
 // NewContextFromFileWithOpts is the same as ziti.NewContextFromFileWithOpts but will also add
-// the resulting context to the current collection.
+// the resulting context to the current collection. The file path is recorded as the context's membership for Save.
 func (set *CtxCollection) NewContextFromFileWithOpts(file string, options *Options) (Context, error) {
 	cfg, err := NewConfigFromFile(file)
 
@@ -137,7 +474,7 @@ func (set *CtxCollection) NewContextFromFileWithOpts(file string, options *Optio
 		return nil, err
 	}
 
-	return set.NewContextWithOpts(cfg, options)
+	return set.newContext(cfg, options, &CollectionMember{File: file})
 }
 
 // NewContext is the same as ziti.NewContext but will also add the resulting context to the current collection.
@@ -146,21 +483,56 @@ func (set *CtxCollection) NewContext(cfg *Config) (Context, error) {
 }
 
 // NewContextWithOpts is the same as ziti.NewContextWithOpts but will also add the resulting context to the current
-// collection.
+// collection. If the configuration can be serialized, i.e. it does not rely on programmatically provided Credentials,
+// it is recorded as the context's membership for Save.
 func (set *CtxCollection) NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
+	var member *CollectionMember
+	if cfg != nil && cfg.Credentials == nil {
+		cfgCopy := *cfg
+		cfgCopy.ConfigTypes = append([]string(nil), cfg.ConfigTypes...)
+		member = &CollectionMember{Config: &cfgCopy}
+	}
+
+	return set.newContext(cfg, options, member)
+}
+
+func (set *CtxCollection) newContext(cfg *Config, options *Options, member *CollectionMember) (Context, error) {
+	if cfg == nil {
+		return nil, errors.New("a config is required")
+	}
+
 	cfg.ConfigTypes = append(cfg.ConfigTypes, set.ConfigTypes...)
 
+	if set.LogHandler != nil && (options == nil || options.LogHandler == nil) {
+		withHandler := *DefaultOptions
+		if options != nil {
+			withHandler = *options
+		}
+		withHandler.LogHandler = set.LogHandler
+		options = &withHandler
+	}
+
 	ctx, err := NewContextWithOpts(cfg, options)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if member != nil {
+		set.members.Set(ctx.GetId(), member)
+	}
+
 	set.Add(ctx)
 
 	return ctx, nil
 }
 
+// log returns the logger of the collection, which forwards to LogHandler if set, or is the process wide pfxlog logger
+// otherwise. It is resolved on every call, so that LogHandler may be set or changed after the collection is in use.
+func (set *CtxCollection) log() *pfxlog.Builder {
+	return newLogger(newHandlerLogger(set.LogHandler))
+}
+
 // NewDialer will return a dialer that will iterate over the Context instances inside the collection, searching for the
 // context that best matches the service.
 //
@@ -186,3 +558,51 @@ func (set *CtxCollection) NewDialerWithFallback(ctx context.Context, fallback Di
 		collection: set,
 	}
 }
+
+// Dial searches all Context instances in the collection for ones that have dial access to the named service and dials
+// it using the Context selected by DialStrategy.
+func (set *CtxCollection) Dial(serviceName string, opts ...DialOption) (net.Conn, error) {
+	ztx, err := set.selectDialContext(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return ztx.DialWithOptions(serviceName, NewDialOptions(opts...))
+}
+
+// DialWithContext performs the same logic as Dial, using Context.DialWithContext on the selected Context so that the
+// dial returns as soon as ctx is done.
+func (set *CtxCollection) DialWithContext(ctx context.Context, serviceName string, opts ...DialOption) (net.Conn, error) {
+	ztx, err := set.selectDialContext(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return ztx.DialWithContext(ctx, serviceName, opts...)
+}
+
+func (set *CtxCollection) selectDialContext(serviceName string) (Context, error) {
+	candidates := set.contextsWithPermission(serviceName, rest_model.DialBindDial)
+
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("service '%s' not found in any context", serviceName)
+	}
+
+	strategy := set.DialStrategy
+	if strategy == nil {
+		strategy = SelectFirstContext
+	}
+
+	ztx := strategy(serviceName, candidates)
+	if ztx == nil {
+		return nil, errors.Errorf("no context selected to dial service '%s'", serviceName)
+	}
+
+	return ztx, nil
+}
+
+// contextsWithPermission returns the contexts that have the given permission on the named service sorted by id.
+func (set *CtxCollection) contextsWithPermission(serviceName string, permission rest_model.DialBind) []Context {
+	return set.Filter(func(ctx Context) bool {
+		svc, found := ctx.GetService(serviceName)
+		return found && slices.Contains(svc.Permissions, permission)
+	})
+}
@@ -0,0 +1,229 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/errorz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// Listen binds the named service on every Context in the collection that has bind access to it, and returns a single
+// edge.Listener that accepts connections from all of them. The DefaultListenOptions are used, modified by opts. See
+// ListenWithOptions.
+func (set *CtxCollection) Listen(serviceName string, opts ...ListenOption) (edge.Listener, error) {
+	return set.ListenWithOptions(serviceName, NewListenOptions(opts...))
+}
+
+// ListenWithOptions performs the same logic as Listen, but allows the specification of ListenOptions which are applied
+// to each Context's listener.
+//
+// Contexts that fail to bind are logged and skipped. An error is only returned if no Context has bind access to the
+// service or if every bind attempt fails.
+func (set *CtxCollection) ListenWithOptions(serviceName string, options *ListenOptions) (edge.Listener, error) {
+	candidates := set.contextsWithPermission(serviceName, rest_model.DialBindBind)
+
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("service '%s' not bindable by any context", serviceName)
+	}
+
+	var listeners []edge.Listener
+	var bindErrors errorz.MultipleErrors
+
+	for _, ztx := range candidates {
+		listener, err := ztx.ListenWithOptions(serviceName, options)
+		if err != nil {
+			set.log().WithError(err).WithField("contextId", ztx.GetId()).
+				WithField("serviceName", serviceName).Warn("failed to bind service for context in collection")
+			bindErrors = append(bindErrors, err)
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.Wrapf(bindErrors.ToError(), "unable to bind service '%s' on any context", serviceName)
+	}
+
+	return newCollectionListener(serviceName, listeners, set.log), nil
+}
+
+var _ edge.Listener = (*collectionListener)(nil)
+
+// collectionListener merges the listeners of several contexts into a single edge.Listener.
+type collectionListener struct {
+	serviceName string
+	listeners   []edge.Listener
+	acceptC     chan edge.Conn
+	closeNotify chan struct{}
+	closeGuard  edge.CloseGuard
+	active      sync.WaitGroup
+	log         func() *pfxlog.Builder
+}
+
+func newCollectionListener(serviceName string, listeners []edge.Listener, log func() *pfxlog.Builder) *collectionListener {
+	result := &collectionListener{
+		log:         log,
+		serviceName: serviceName,
+		listeners:   listeners,
+		acceptC:     make(chan edge.Conn),
+		closeNotify: make(chan struct{}),
+	}
+
+	result.active.Add(len(listeners))
+	for _, listener := range listeners {
+		go result.forward(listener)
+	}
+
+	go func() {
+		result.active.Wait()
+		_ = result.Close()
+	}()
+
+	return result
+}
+
+func (self *collectionListener) forward(listener edge.Listener) {
+	defer self.active.Done()
+
+	for {
+		conn, err := listener.AcceptEdge()
+		if err != nil {
+			self.log().WithError(err).WithField("serviceName", self.serviceName).
+				Debug("child listener of collection listener closed")
+			return
+		}
+
+		select {
+		case self.acceptC <- conn:
+		case <-self.closeNotify:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+func (self *collectionListener) Network() string {
+	return "ziti"
+}
+
+func (self *collectionListener) String() string {
+	return self.serviceName
+}
+
+func (self *collectionListener) Addr() net.Addr {
+	return self
+}
+
+func (self *collectionListener) Id() uint32 {
+	return math.MaxUint32
+}
+
+func (self *collectionListener) Accept() (net.Conn, error) {
+	return self.AcceptEdge()
+}
+
+func (self *collectionListener) AcceptEdge() (edge.Conn, error) {
+	select {
+	case conn := <-self.acceptC:
+		return conn, nil
+	case <-self.closeNotify:
+		return nil, errors.New("listener is closed")
+	}
+}
+
+func (self *collectionListener) IsClosed() bool {
+	return self.closeGuard.IsClosed()
+}
+
+func (self *collectionListener) Close() error {
+	if err := self.closeGuard.Close("collection listener"); err != nil {
+		return err
+	}
+	close(self.closeNotify)
+
+	return self.forEach(func(listener edge.Listener) error {
+		return listener.Close()
+	})
+}
+
+func (self *collectionListener) UpdateCost(cost uint16) error {
+	return self.forEach(func(listener edge.Listener) error {
+		return listener.UpdateCost(cost)
+	})
+}
+
+func (self *collectionListener) UpdatePrecedence(precedence edge.Precedence) error {
+	return self.forEach(func(listener edge.Listener) error {
+		return listener.UpdatePrecedence(precedence)
+	})
+}
+
+func (self *collectionListener) UpdateCostAndPrecedence(cost uint16, precedence edge.Precedence) error {
+	return self.forEach(func(listener edge.Listener) error {
+		return listener.UpdateCostAndPrecedence(cost, precedence)
+	})
+}
+
+func (self *collectionListener) SendHealthEvent(pass bool) error {
+	return self.forEach(func(listener edge.Listener) error {
+		return listener.SendHealthEvent(pass)
+	})
+}
+
+// Drain stops accepting connections and drains the listeners of all contexts concurrently.
+func (self *collectionListener) Drain(ctx context.Context) error {
+	if err := self.closeGuard.Close("collection listener"); err != nil {
+		return err
+	}
+	close(self.closeNotify)
+
+	var lock sync.Mutex
+	var result errorz.MultipleErrors
+	var wg sync.WaitGroup
+	for _, listener := range self.listeners {
+		wg.Add(1)
+		go func(listener edge.Listener) {
+			defer wg.Done()
+			if err := listener.Drain(ctx); err != nil {
+				lock.Lock()
+				result = append(result, err)
+				lock.Unlock()
+			}
+		}(listener)
+	}
+	wg.Wait()
+
+	return result.ToError()
+}
+
+func (self *collectionListener) forEach(f func(listener edge.Listener) error) error {
+	var result errorz.MultipleErrors
+	for _, listener := range self.listeners {
+		if err := f(listener); err != nil {
+			result = append(result, err)
+		}
+	}
+	return result.ToError()
+}
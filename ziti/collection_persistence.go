@@ -0,0 +1,129 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/openziti/foundation/v2/errorz"
+	"github.com/pkg/errors"
+)
+
+// CollectionMember records how a Context in a CtxCollection was created, so that it can be re-created by Load. Exactly
+// one of File or Config is set.
+type CollectionMember struct {
+	// File is the path of the identity file the Context was loaded from.
+	File string `json:"file,omitempty"`
+
+	// Config is the configuration the Context was created with. Configurations with inline keys are persisted as is.
+	Config *Config `json:"config,omitempty"`
+}
+
+type collectionMembership struct {
+	Members []*CollectionMember `json:"members"`
+}
+
+// Members returns the membership records of the contexts in the collection, ordered by context id. Contexts added with
+// Add, or created from configurations with programmatically provided Credentials, have no record and are omitted.
+func (set *CtxCollection) Members() []*CollectionMember {
+	var result []*CollectionMember
+	for _, id := range set.Ids() {
+		if member, found := set.members.Get(id); found {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// Save writes the membership of the collection as JSON to w. See Members.
+func (set *CtxCollection) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&collectionMembership{Members: set.Members()})
+}
+
+// SaveToFile writes the membership of the collection to the given path. The file is replaced atomically and is only
+// readable by the current user, as configurations may contain private keys.
+func (set *CtxCollection) SaveToFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err = tmp.Chmod(0600); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err = set.Save(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads a membership previously written by Save and creates a Context for each member, adding it to the
+// collection. Members that fail to load are skipped and their errors returned together; the remaining members are
+// still added.
+func (set *CtxCollection) Load(r io.Reader, options *Options) error {
+	membership := &collectionMembership{}
+	if err := json.NewDecoder(r).Decode(membership); err != nil {
+		return errors.Wrap(err, "could not decode collection membership")
+	}
+
+	var loadErrors errorz.MultipleErrors
+	for _, member := range membership.Members {
+		var err error
+		switch {
+		case member.File != "":
+			_, err = set.NewContextFromFileWithOpts(member.File, options)
+			err = errors.Wrapf(err, "failed to create context from file '%s'", member.File)
+		case member.Config != nil:
+			_, err = set.NewContextWithOpts(member.Config, options)
+			err = errors.Wrap(err, "failed to create context from config")
+		default:
+			err = errors.New("collection member has neither a file nor a config")
+		}
+
+		if err != nil {
+			set.log().WithError(err).Error("failed to load collection member")
+			loadErrors = append(loadErrors, err)
+		}
+	}
+
+	return loadErrors.ToError()
+}
+
+// LoadFromFile is the same as Load but reads the membership from the given path.
+func (set *CtxCollection) LoadFromFile(path string, options *Options) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	return set.Load(file, options)
+}
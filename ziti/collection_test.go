@@ -0,0 +1,649 @@
+package ziti
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testContext is a partial Context implementation for exercising CtxCollection behavior. Methods that are not
+// overridden panic via the nil embedded interface.
+type testContext struct {
+	Context
+	id       string
+	services map[string]*rest_model.ServiceDetail
+	dials    []string
+	binds    []*testListener
+	eventer  *testEventer
+	closeErr error
+	closed   atomic.Bool
+	stats    ContextStats
+	bus      EventBus
+}
+
+func (self *testContext) EventBus() *EventBus {
+	return &self.bus
+}
+
+func newTestContext(id string, services ...*rest_model.ServiceDetail) *testContext {
+	result := &testContext{
+		id:       id,
+		services: map[string]*rest_model.ServiceDetail{},
+	}
+	result.eventer = &testEventer{ctx: result, emitter: events.New()}
+	for _, svc := range services {
+		result.services[*svc.Name] = svc
+	}
+	return result
+}
+
+func newTestService(name string, permissions ...rest_model.DialBind) *rest_model.ServiceDetail {
+	return &rest_model.ServiceDetail{
+		BaseEntity: rest_model.BaseEntity{
+			ID: ToPtr(name + "-id"),
+		},
+		Name:        ToPtr(name),
+		Permissions: permissions,
+	}
+}
+
+func (self *testContext) GetId() string {
+	return self.id
+}
+
+func (self *testContext) GetService(serviceName string) (*rest_model.ServiceDetail, bool) {
+	svc, found := self.services[serviceName]
+	return svc, found
+}
+
+func (self *testContext) GetServices() ([]rest_model.ServiceDetail, error) {
+	var result []rest_model.ServiceDetail
+	for _, svc := range self.services {
+		result = append(result, *svc)
+	}
+	return result, nil
+}
+
+func (self *testContext) Dial(serviceName string) (edge.Conn, error) {
+	return self.DialWithOptions(serviceName, nil)
+}
+
+func (self *testContext) DialWithOptions(serviceName string, _ *DialOptions) (edge.Conn, error) {
+	self.dials = append(self.dials, serviceName)
+	return nil, nil
+}
+
+func (self *testContext) DialWithContext(ctx context.Context, serviceName string, _ ...DialOption) (edge.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return self.Dial(serviceName)
+}
+
+func (self *testContext) ListenWithOptions(serviceName string, _ *ListenOptions) (edge.Listener, error) {
+	listener := &testListener{
+		acceptC:     make(chan edge.Conn),
+		closeNotify: make(chan struct{}),
+	}
+	self.binds = append(self.binds, listener)
+	return listener, nil
+}
+
+func (self *testContext) Close() {}
+
+func (self *testContext) Stats() ContextStats {
+	return self.stats
+}
+
+func (self *testContext) CloseWithContext(context.Context) error {
+	self.closed.Store(true)
+	return self.closeErr
+}
+
+func (self *testContext) Events() Eventer {
+	return self.eventer
+}
+
+// testEventer implements the Eventer listeners that CtxCollection subscribes to.
+type testEventer struct {
+	Eventer
+	ctx     Context
+	emitter events.EventEmmiter
+}
+
+func (self *testEventer) AddAuthenticationStateFullListener(handler func(Context, edge_apis.ApiSession)) func() {
+	listener := func(args ...interface{}) {
+		handler(self.ctx, args[0].(edge_apis.ApiSession))
+	}
+	self.emitter.AddListener(EventAuthenticationStateFull, listener)
+	return func() {
+		self.emitter.RemoveListener(EventAuthenticationStateFull, listener)
+	}
+}
+
+func (self *testEventer) AddAuthenticationFailedListener(handler func(Context, error)) func() {
+	listener := func(args ...interface{}) {
+		handler(self.ctx, args[0].(error))
+	}
+	self.emitter.AddListener(EventAuthenticationFailed, listener)
+	return func() {
+		self.emitter.RemoveListener(EventAuthenticationFailed, listener)
+	}
+}
+
+// testListener is a partial edge.Listener which hands out whatever connections are pushed to acceptC.
+type testListener struct {
+	edge.Listener
+	acceptC     chan edge.Conn
+	closeNotify chan struct{}
+	cost        uint16
+}
+
+func (self *testListener) AcceptEdge() (edge.Conn, error) {
+	select {
+	case conn := <-self.acceptC:
+		return conn, nil
+	case <-self.closeNotify:
+		return nil, errors.New("closed")
+	}
+}
+
+func (self *testListener) UpdateCost(cost uint16) error {
+	self.cost = cost
+	return nil
+}
+
+func (self *testListener) Close() error {
+	close(self.closeNotify)
+	return nil
+}
+
+type testConn struct {
+	edge.Conn
+	id string
+}
+
+func Test_CtxCollection_Dial(t *testing.T) {
+	req := require.New(t)
+
+	dialOnly := newTestContext("1", newTestService("a", rest_model.DialBindDial))
+	bindOnly := newTestContext("2", newTestService("a", rest_model.DialBindBind))
+	both := newTestContext("3", newTestService("a", rest_model.DialBindDial, rest_model.DialBindBind))
+
+	collection := NewSdkCollection()
+	collection.Add(both)
+	collection.Add(bindOnly)
+	collection.Add(dialOnly)
+
+	t.Run("default strategy selects lowest id", func(t *testing.T) {
+		_, err := collection.Dial("a")
+		req.NoError(err)
+		req.Equal([]string{"a"}, dialOnly.dials)
+		req.Empty(bindOnly.dials)
+		req.Empty(both.dials)
+	})
+
+	t.Run("strategy only sees contexts with dial permission", func(t *testing.T) {
+		var seen []string
+		collection.DialStrategy = func(_ string, candidates []Context) Context {
+			for _, candidate := range candidates {
+				seen = append(seen, candidate.GetId())
+			}
+			return candidates[len(candidates)-1]
+		}
+		defer func() { collection.DialStrategy = nil }()
+
+		_, err := collection.Dial("a")
+		req.NoError(err)
+		req.Equal([]string{"1", "3"}, seen)
+		req.Equal([]string{"a"}, both.dials)
+	})
+
+	t.Run("unknown service errors", func(t *testing.T) {
+		_, err := collection.Dial("b")
+		req.Error(err)
+	})
+
+	t.Run("dial with context uses the selected context", func(t *testing.T) {
+		_, err := collection.DialWithContext(context.Background(), "a")
+		req.NoError(err)
+		req.Equal([]string{"a", "a"}, dialOnly.dials)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = collection.DialWithContext(ctx, "a")
+		req.ErrorIs(err, context.Canceled)
+	})
+
+	t.Run("round robin rotates", func(t *testing.T) {
+		strategy := NewRoundRobinContextSelection()
+		candidates := []Context{dialOnly, both}
+		req.Equal(dialOnly, strategy("a", candidates))
+		req.Equal(both, strategy("a", candidates))
+		req.Equal(dialOnly, strategy("a", candidates))
+	})
+}
+
+func Test_CtxCollection_Listen(t *testing.T) {
+	req := require.New(t)
+
+	dialOnly := newTestContext("1", newTestService("a", rest_model.DialBindDial))
+	bindOnly := newTestContext("2", newTestService("a", rest_model.DialBindBind))
+	both := newTestContext("3", newTestService("a", rest_model.DialBindDial, rest_model.DialBindBind))
+
+	collection := NewSdkCollection()
+	collection.Add(dialOnly)
+	collection.Add(bindOnly)
+	collection.Add(both)
+
+	_, err := collection.Listen("b")
+	req.Error(err)
+
+	listener, err := collection.Listen("a")
+	req.NoError(err)
+	req.Empty(dialOnly.binds)
+	req.Len(bindOnly.binds, 1)
+	req.Len(both.binds, 1)
+
+	for _, ztx := range []*testContext{bindOnly, both} {
+		go func(ztx *testContext) {
+			ztx.binds[0].acceptC <- &testConn{id: ztx.id}
+		}(ztx)
+	}
+
+	var accepted []string
+	for i := 0; i < 2; i++ {
+		conn, err := listener.AcceptEdge()
+		req.NoError(err)
+		accepted = append(accepted, conn.(*testConn).id)
+	}
+	req.ElementsMatch([]string{"2", "3"}, accepted)
+
+	req.NoError(listener.UpdateCost(10))
+	req.Equal(uint16(10), bindOnly.binds[0].cost)
+	req.Equal(uint16(10), both.binds[0].cost)
+
+	req.NoError(listener.Close())
+	req.True(listener.IsClosed())
+	_, err = listener.AcceptEdge()
+	req.Error(err)
+}
+
+func Test_CtxCollection_Events(t *testing.T) {
+	req := require.New(t)
+
+	collection := NewSdkCollection()
+
+	var added, removed, authenticated, failed []string
+	collection.AddContextAddedListener(func(ctx Context) {
+		added = append(added, ctx.GetId())
+	})
+	collection.AddContextRemovedListener(func(ctx Context) {
+		removed = append(removed, ctx.GetId())
+	})
+	collection.AddContextAuthenticatedListener(func(ctx Context, _ edge_apis.ApiSession) {
+		authenticated = append(authenticated, ctx.GetId())
+	})
+	removeFailed := collection.AddContextFailedListener(func(ctx Context, err error) {
+		failed = append(failed, ctx.GetId()+":"+err.Error())
+	})
+
+	first := newTestContext("1")
+	second := newTestContext("2")
+
+	collection.Add(first)
+	collection.Add(first)
+	collection.Add(second)
+	req.Equal([]string{"1", "2"}, added)
+
+	first.eventer.emitter.Emit(EventAuthenticationStateFull, &edge_apis.ApiSessionLegacy{})
+	second.eventer.emitter.Emit(EventAuthenticationFailed, errors.New("denied"))
+	req.Equal([]string{"1"}, authenticated)
+	req.Equal([]string{"2:denied"}, failed)
+
+	removeFailed()
+	second.eventer.emitter.Emit(EventAuthenticationFailed, errors.New("denied"))
+	req.Len(failed, 1)
+
+	replacement := newTestContext("1")
+	collection.Add(replacement)
+	req.Equal([]string{"1"}, removed)
+	req.Equal([]string{"1", "2", "1"}, added)
+
+	first.eventer.emitter.Emit(EventAuthenticationStateFull, &edge_apis.ApiSessionLegacy{})
+	req.Len(authenticated, 1)
+
+	collection.Remove(second)
+	collection.RemoveById("missing")
+	req.Equal([]string{"1", "2"}, removed)
+}
+
+func Test_CtxCollection_CloseAll(t *testing.T) {
+	req := require.New(t)
+
+	clean := newTestContext("1")
+	failing := newTestContext("2")
+	failing.closeErr = errors.New("connections still active")
+
+	collection := NewSdkCollection()
+	collection.Add(clean)
+	collection.Add(failing)
+
+	err := collection.CloseAll(context.Background())
+	req.Error(err)
+
+	var ctxErrs ContextErrors
+	req.True(errors.As(err, &ctxErrs))
+	req.Len(ctxErrs, 1)
+	req.Equal(failing.closeErr, ctxErrs["2"])
+
+	req.True(clean.closed.Load())
+	req.True(failing.closed.Load())
+
+	count := 0
+	collection.ForAll(func(Context) { count++ })
+	req.Zero(count)
+
+	req.NoError(collection.CloseAll(context.Background()))
+}
+
+// phasedTestContext records the order in which shutdown phases are applied to it.
+type phasedTestContext struct {
+	*testContext
+	lock   sync.Mutex
+	phases []string
+	drain  chan struct{}
+}
+
+func (self *phasedTestContext) record(phase string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.phases = append(self.phases, phase)
+}
+
+func (self *phasedTestContext) closeListeners() error {
+	self.record("listeners")
+	return nil
+}
+
+func (self *phasedTestContext) drainEdgeRouterConns(ctx context.Context) error {
+	self.record("drain")
+	select {
+	case <-self.drain:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (self *phasedTestContext) closeEdgeRouterConns() error {
+	self.record("routers")
+	return nil
+}
+
+func (self *phasedTestContext) logout(context.Context) error {
+	self.record("sessions")
+	return nil
+}
+
+func Test_CtxCollection_Shutdown(t *testing.T) {
+	req := require.New(t)
+
+	drained := &phasedTestContext{testContext: newTestContext("1"), drain: make(chan struct{})}
+	close(drained.drain)
+	stuck := &phasedTestContext{testContext: newTestContext("2"), drain: make(chan struct{})}
+	plain := newTestContext("3")
+
+	collection := NewSdkCollection()
+	collection.Add(drained)
+	collection.Add(stuck)
+	collection.Add(plain)
+
+	var progress []ShutdownProgress
+	collection.AddShutdownProgressListener(func(p ShutdownProgress) {
+		progress = append(progress, p)
+	})
+
+	err := collection.Shutdown(context.Background(), &ShutdownOptions{DrainTimeout: 50 * time.Millisecond})
+	req.Error(err)
+
+	var ctxErrs ContextErrors
+	req.True(errors.As(err, &ctxErrs))
+	req.Len(ctxErrs, 1)
+	req.ErrorIs(ctxErrs["2"], context.DeadlineExceeded)
+
+	expected := []string{"listeners", "drain", "routers", "sessions"}
+	req.Equal(expected, drained.phases)
+	req.Equal(expected, stuck.phases)
+	req.True(plain.closed.Load())
+	req.Zero(collection.Len())
+
+	req.Len(progress, 8)
+	for i, phase := range []ShutdownPhase{ShutdownPhaseListeners, ShutdownPhaseDrain, ShutdownPhaseRouters, ShutdownPhaseSessions} {
+		req.Equal(phase, progress[2*i].Phase)
+		req.False(progress[2*i].Completed)
+		req.Equal(phase, progress[2*i+1].Phase)
+		req.True(progress[2*i+1].Completed)
+	}
+	req.Error(progress[3].Err)
+	req.NoError(progress[5].Err)
+}
+
+func Test_CtxCollection_Lookup(t *testing.T) {
+	req := require.New(t)
+
+	collection := NewSdkCollection()
+	req.Zero(collection.Len())
+	req.Empty(collection.Ids())
+
+	first := newTestContext("1", newTestService("a", rest_model.DialBindDial))
+	second := newTestContext("2")
+	collection.Add(second)
+	collection.Add(first)
+
+	req.Equal(2, collection.Len())
+	req.Equal([]string{"1", "2"}, collection.Ids())
+
+	ctx, found := collection.GetById("2")
+	req.True(found)
+	req.Equal(second, ctx)
+
+	_, found = collection.GetById("3")
+	req.False(found)
+
+	withServices := collection.Filter(func(ctx Context) bool {
+		_, found := ctx.GetService("a")
+		return found
+	})
+	req.Equal([]Context{first}, withServices)
+
+	req.Len(collection.Filter(func(Context) bool { return true }), 2)
+}
+
+func Test_CtxCollection_GetServices(t *testing.T) {
+	req := require.New(t)
+
+	first := newTestContext("1", newTestService("b", rest_model.DialBindDial), newTestService("a", rest_model.DialBindDial))
+	second := newTestContext("2", newTestService("a", rest_model.DialBindBind))
+	third := newTestContext("3", newTestService("c", rest_model.DialBindDial))
+
+	collection := NewSdkCollection()
+	collection.Add(third)
+	collection.Add(second)
+	collection.Add(first)
+
+	services, err := collection.GetServices()
+	req.NoError(err)
+	req.Len(services, 3)
+
+	req.Equal("a", *services[0].Service.Name)
+	req.Equal([]string{"1", "2"}, services[0].ContextIds())
+	req.Equal(rest_model.DialBindArray{rest_model.DialBindDial}, services[0].Service.Permissions)
+
+	req.Equal("b", *services[1].Service.Name)
+	req.Equal([]string{"1"}, services[1].ContextIds())
+
+	req.Equal("c", *services[2].Service.Name)
+	req.Equal([]string{"3"}, services[2].ContextIds())
+}
+
+func Test_CtxCollection_Snapshot(t *testing.T) {
+	req := require.New(t)
+
+	first := newTestContext("1")
+	first.stats = ContextStats{Authenticated: true, EdgeRouterConnections: 2, ActiveConnections: 3, BytesIn: 100, BytesOut: 10}
+	second := newTestContext("2")
+	second.stats = ContextStats{EdgeRouterConnections: 1, BytesIn: 5, BytesOut: 50}
+
+	collection := NewSdkCollection()
+	collection.Add(first)
+	collection.Add(second)
+
+	req.Equal(CollectionSnapshot{
+		Contexts:              2,
+		AuthenticatedContexts: 1,
+		EdgeRouterConnections: 3,
+		ActiveConnections:     3,
+		BytesIn:               105,
+		BytesOut:              60,
+	}, collection.Snapshot())
+
+	recorder := httptest.NewRecorder()
+	collection.PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	req.True(strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	req.Contains(body, "# TYPE ziti_collection_contexts gauge\nziti_collection_contexts 2\n")
+	req.Contains(body, "ziti_collection_authenticated_contexts 1\n")
+	req.Contains(body, "# TYPE ziti_collection_rx_bytes_total counter\nziti_collection_rx_bytes_total 105\n")
+	req.Contains(body, "ziti_collection_tx_bytes_total 60\n")
+}
+
+func Test_CtxCollection_Dump(t *testing.T) {
+	req := require.New(t)
+
+	first := newTestContext("1")
+	first.stats = ContextStats{Authenticated: true, IdentityName: "alice", EdgeRouterConnections: 2, ActiveConnections: 3, HostedServices: []string{"echo", "printer"}}
+	second := newTestContext("2")
+
+	collection := NewSdkCollection()
+	collection.Add(second)
+	collection.Add(first)
+
+	req.Equal("CtxCollection[contexts=2, authenticated=1, routerConnections=2, connections=3]", collection.String())
+
+	buf := &bytes.Buffer{}
+	req.NoError(collection.Dump(buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	req.Len(lines, 3)
+	req.Equal([]string{"ID", "IDENTITY", "STATE", "ROUTERS", "CONNS", "HOSTING"}, strings.Fields(lines[0]))
+	req.Equal([]string{"1", "alice", "authenticated", "2", "3", "echo,printer"}, strings.Fields(lines[1]))
+	req.Equal([]string{"2", "-", "unauthenticated", "0", "0", "-"}, strings.Fields(lines[2]))
+}
+
+func Test_CtxCollection_SaveLoad(t *testing.T) {
+	req := require.New(t)
+
+	newConfig := func() *Config {
+		return &Config{
+			ZtAPI:       "https://controller.example.com/edge/client/v1",
+			ConfigTypes: []string{"intercept.v1"},
+			InstanceIdentity: &InstanceIdentityConfig{
+				Provider: "gcp",
+				Audience: "ziti",
+			},
+		}
+	}
+
+	dir := t.TempDir()
+	identityFile := filepath.Join(dir, "identity.json")
+	cfgJson, err := json.Marshal(newConfig())
+	req.NoError(err)
+	req.NoError(os.WriteFile(identityFile, cfgJson, 0600))
+
+	collection := NewSdkCollection()
+	collection.ConfigTypes = []string{"host.v1"}
+
+	_, err = collection.NewContextFromFile(identityFile)
+	req.NoError(err)
+	_, err = collection.NewContext(newConfig())
+	req.NoError(err)
+	collection.Add(newTestContext("manual"))
+
+	members := collection.Members()
+	req.Len(members, 2)
+
+	membershipFile := filepath.Join(dir, "membership.json")
+	req.NoError(collection.SaveToFile(membershipFile))
+
+	info, err := os.Stat(membershipFile)
+	req.NoError(err)
+	req.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	restored := NewSdkCollection()
+	req.NoError(restored.LoadFromFile(membershipFile, nil))
+	req.Equal(2, restored.Len())
+
+	var files []string
+	for _, member := range restored.Members() {
+		if member.File != "" {
+			files = append(files, member.File)
+		} else {
+			req.Equal([]string{"intercept.v1"}, member.Config.ConfigTypes)
+			req.Equal("gcp", member.Config.InstanceIdentity.Provider)
+		}
+	}
+	req.Equal([]string{identityFile}, files)
+
+	err = restored.Load(strings.NewReader(`{"members":[{"file":"`+filepath.Join(dir, "missing.json")+`"},{}]}`), nil)
+	req.Error(err)
+	req.Equal(2, restored.Len())
+}
+
+func Test_NewSdkCollectionFromEnvWithOpts(t *testing.T) {
+	req := require.New(t)
+
+	cfgJson, err := json.Marshal(&Config{
+		ZtAPI:            "https://controller.example.com/edge/client/v1",
+		InstanceIdentity: &InstanceIdentityConfig{Provider: "gcp", Audience: "ziti"},
+	})
+	req.NoError(err)
+
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 10; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("identity-%d.json", i))
+		req.NoError(os.WriteFile(file, cfgJson, 0600))
+		files = append(files, file)
+	}
+	missing := filepath.Join(dir, "missing.json")
+	files = append(files, missing, "")
+
+	t.Setenv("ZITI_TEST_IDENTITIES", strings.Join(files, ";"))
+
+	collection, err := NewSdkCollectionFromEnvWithOpts("ZITI_TEST_IDENTITIES", nil, 3)
+	req.Equal(10, collection.Len())
+
+	var createErrors ContextErrors
+	req.ErrorAs(err, &createErrors)
+	req.Len(createErrors, 1)
+	req.Contains(createErrors, missing)
+
+	req.Equal(10, NewSdkCollectionFromEnv("ZITI_TEST_IDENTITIES").Len())
+}
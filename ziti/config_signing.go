@@ -0,0 +1,218 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// ConfigSignatureSuffix is appended to the path of a config file to find its detached signature, unless a different
+// file is given with WithConfigSignatureFile.
+const ConfigSignatureSuffix = ".sig"
+
+// ErrConfigSignatureInvalid is returned by NewConfigFromFile when a config file fails signature verification.
+var ErrConfigSignatureInvalid = errors.New("config signature verification failed")
+
+// ConfigOption modifies how NewConfigFromFile loads a config file.
+type ConfigOption func(options *configLoadOptions)
+
+type configLoadOptions struct {
+	verificationKey crypto.PublicKey
+	signatureFile   string
+	signature       []byte
+}
+
+// WithConfigVerificationKey requires the config file to be signed by the private key of key, which must be an ECDSA,
+// RSA or Ed25519 public key. The config file is either a JWS compact serialization with the config as its payload, or
+// plain JSON with a detached signature of the file contents. Detached ECDSA and RSA (PKCS #1 v1.5 or PSS) signatures
+// are over the SHA-256 digest of the file, e.g. as created by `openssl dgst -sha256 -sign`, and may be stored raw or
+// base64 encoded.
+func WithConfigVerificationKey(key crypto.PublicKey) ConfigOption {
+	return func(options *configLoadOptions) {
+		options.verificationKey = key
+	}
+}
+
+// WithConfigSignatureFile sets the path of the detached signature of the config file. Defaults to the path of the
+// config file with ConfigSignatureSuffix appended.
+func WithConfigSignatureFile(path string) ConfigOption {
+	return func(options *configLoadOptions) {
+		options.signatureFile = path
+	}
+}
+
+// WithConfigSignature sets the detached signature of the config file, raw or base64 encoded, instead of reading it
+// from a file. See NewConfigFromBytes.
+func WithConfigSignature(signature []byte) ConfigOption {
+	return func(options *configLoadOptions) {
+		options.signature = signature
+	}
+}
+
+// ParseConfigVerificationKey parses a PEM encoded public key or certificate for use with WithConfigVerificationKey.
+func ParseConfigVerificationKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found in verification key")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse verification certificate")
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse verification key")
+		}
+		return key, nil
+	}
+}
+
+// isJwsEnvelope returns true if the config file contents look like a JWS compact serialization rather than JSON.
+func isJwsEnvelope(conf []byte) bool {
+	conf = bytes.TrimSpace(conf)
+	return len(conf) > 0 && conf[0] != '{' && bytes.Count(conf, []byte(".")) == 2
+}
+
+// verifyConfig checks the signature of the config file contents, returning the config JSON.
+func (options *configLoadOptions) verifyConfig(confFile string, conf []byte) ([]byte, error) {
+	if isJwsEnvelope(conf) {
+		if options.verificationKey == nil {
+			return nil, errors.Errorf("config file (%s) is signed, but no verification key was given", confFile)
+		}
+		return verifyJwsEnvelope(string(bytes.TrimSpace(conf)), options.verificationKey)
+	}
+
+	if options.verificationKey == nil {
+		return conf, nil
+	}
+
+	signature := options.signature
+	if signature == nil {
+		signatureFile := options.signatureFile
+		if signatureFile == "" {
+			if confFile == "" {
+				return nil, errors.New("config is not signed and no detached signature was given")
+			}
+			signatureFile = confFile + ConfigSignatureSuffix
+		}
+
+		var err error
+		if signature, err = os.ReadFile(signatureFile); err != nil {
+			return nil, errors.Wrapf(err, "unable to read signature of config file (%s)", confFile)
+		}
+	}
+
+	if err := verifyDetachedSignature(conf, decodeSignature(signature), options.verificationKey); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// decodeSignature returns the base64 decoded signature, or the signature itself if it isn't base64 encoded.
+func decodeSignature(signature []byte) []byte {
+	encoded := strings.TrimSpace(string(signature))
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(encoded); err == nil {
+			return decoded
+		}
+	}
+	return signature
+}
+
+func verifyDetachedSignature(conf, signature []byte, key crypto.PublicKey) error {
+	digest := sha256.Sum256(conf)
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, digest[:], signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil ||
+			rsa.VerifyPSS(k, crypto.SHA256, digest[:], signature, nil) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, conf, signature) {
+			return nil
+		}
+	default:
+		return errors.Errorf("unsupported config verification key type %T", key)
+	}
+
+	return ErrConfigSignatureInvalid
+}
+
+func verifyJwsEnvelope(envelope string, key crypto.PublicKey) ([]byte, error) {
+	parts := strings.Split(envelope, ".")
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JWS header encoding")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	if err = json.Unmarshal(headerJson, &header); err != nil {
+		return nil, errors.Wrap(err, "invalid JWS header")
+	}
+
+	// only asymmetric algorithms can be verified with a public key, this also rules out "none"
+	method := jwt.GetSigningMethod(header.Alg)
+	switch method.(type) {
+	case *jwt.SigningMethodECDSA, *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodEd25519:
+	default:
+		return nil, errors.Errorf("unsupported JWS algorithm '%s'", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JWS signature encoding")
+	}
+
+	if err = method.Verify(parts[0]+"."+parts[1], signature, key); err != nil {
+		return nil, errors.Wrap(ErrConfigSignatureInvalid, err.Error())
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JWS payload encoding")
+	}
+	return payload, nil
+}
@@ -0,0 +1,113 @@
+package ziti
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const signedTestConfig = `{"ztAPI": "https://ctrl.example.com/edge/client/v1", "configTypes": ["intercept.v1"]}`
+
+func Test_NewConfigFromFile_detachedSignature(t *testing.T) {
+	req := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	confFile := filepath.Join(t.TempDir(), "identity.json")
+	req.NoError(os.WriteFile(confFile, []byte(signedTestConfig), 0600))
+
+	digest := sha256.Sum256([]byte(signedTestConfig))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	req.NoError(err)
+	req.NoError(os.WriteFile(confFile+ConfigSignatureSuffix, []byte(base64.StdEncoding.EncodeToString(signature)), 0600))
+
+	pubDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	req.NoError(err)
+	pubKey, err := ParseConfigVerificationKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}))
+	req.NoError(err)
+
+	cfg, err := NewConfigFromFile(confFile, WithConfigVerificationKey(pubKey))
+	req.NoError(err)
+	req.Equal("https://ctrl.example.com/edge/client/v1", cfg.ZtAPI)
+
+	req.NoError(os.WriteFile(confFile, []byte(`{"ztAPI": "https://evil.example.com/edge/client/v1"}`), 0600))
+	_, err = NewConfigFromFile(confFile, WithConfigVerificationKey(pubKey))
+	req.True(errors.Is(err, ErrConfigSignatureInvalid))
+
+	cfg, err = NewConfigFromFile(confFile)
+	req.NoError(err, "unsigned configs load without a verification key")
+	req.Equal("https://evil.example.com/edge/client/v1", cfg.ZtAPI)
+
+	req.NoError(os.Remove(confFile + ConfigSignatureSuffix))
+	_, err = NewConfigFromFile(confFile, WithConfigVerificationKey(pubKey))
+	req.Error(err, "missing signatures should be rejected")
+}
+
+func Test_NewConfigFromFile_jwsEnvelope(t *testing.T) {
+	req := require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+
+	signingString := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(signedTestConfig))
+	signature, err := jwt.SigningMethodEdDSA.Sign(signingString, crypto.Signer(priv))
+	req.NoError(err)
+	envelope := signingString + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	confFile := filepath.Join(t.TempDir(), "identity.jws")
+	req.NoError(os.WriteFile(confFile, []byte(envelope+"\n"), 0600))
+
+	cfg, err := NewConfigFromFile(confFile, WithConfigVerificationKey(pub))
+	req.NoError(err)
+	req.Equal([]string{"intercept.v1"}, cfg.ConfigTypes)
+
+	_, err = NewConfigFromFile(confFile)
+	req.Error(err, "signed configs need a verification key")
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+	_, err = NewConfigFromFile(confFile, WithConfigVerificationKey(otherPub))
+	req.True(errors.Is(err, ErrConfigSignatureInvalid))
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(signedTestConfig)) + "."
+	req.NoError(os.WriteFile(confFile, []byte(unsigned), 0600))
+	_, err = NewConfigFromFile(confFile, WithConfigVerificationKey(pub))
+	req.Error(err)
+}
+
+func Test_NewConfigFromBytes_detachedSignature(t *testing.T) {
+	req := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	digest := sha256.Sum256([]byte(signedTestConfig))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	req.NoError(err)
+
+	cfg, err := NewConfigFromBytes([]byte(signedTestConfig), WithConfigVerificationKey(&key.PublicKey), WithConfigSignature(signature))
+	req.NoError(err)
+	req.Equal("https://ctrl.example.com/edge/client/v1", cfg.ZtAPI)
+
+	_, err = NewConfigFromBytes([]byte(signedTestConfig), WithConfigVerificationKey(&key.PublicKey))
+	req.Error(err, "in-memory configs have no default signature file")
+
+	cfg, err = NewConfigFromBytes([]byte(signedTestConfig))
+	req.NoError(err)
+	req.Equal([]string{"intercept.v1"}, cfg.ConfigTypes)
+}
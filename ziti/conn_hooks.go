@@ -0,0 +1,167 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// DefaultConnHooksQueueSize is the number of events queued for asynchronous ConnHooks if ConnHooks.QueueSize is not
+// set.
+const DefaultConnHooksQueueSize = 256
+
+// ConnAuditEvent describes a connection dialed or accepted by a Context, as passed to ConnHooks.
+type ConnAuditEvent struct {
+	ServiceName string
+
+	// IdentityName is the name of the identity of the Context.
+	IdentityName string
+
+	// SourceIdentity is the identity that dialed an accepted connection, if the router passed it on. It is empty for
+	// dialed connections.
+	SourceIdentity string
+
+	// Hosted is true for connections accepted for a hosted service, and false for dialed connections.
+	Hosted bool
+
+	ConnId    uint32
+	CircuitId string
+	StartTime time.Time
+
+	// Duration, BytesIn, BytesOut and ClosedByRemote are only set when the connection closes.
+	Duration       time.Duration
+	BytesIn        uint64
+	BytesOut       uint64
+	ClosedByRemote bool
+}
+
+// ConnHooks are called when connections are opened and closed, so that audit logs can be produced without wrapping
+// every Dial and Accept. Accepted connections are reported once established, whether or not they have been returned
+// by Accept yet.
+type ConnHooks struct {
+	OnOpen  func(event *ConnAuditEvent)
+	OnClose func(event *ConnAuditEvent)
+
+	// Async calls the hooks in order on a separate goroutine, so that slow hooks don't delay the data path. Events
+	// that don't fit in the queue are dropped and logged. Otherwise, hooks are called on the goroutine opening or
+	// closing the connection.
+	Async bool
+
+	// QueueSize is the number of events queued for Async hooks. Defaults to DefaultConnHooksQueueSize.
+	QueueSize int
+}
+
+// connHookDispatcher calls the ConnHooks of a Context, keeping the open event of each connection until it closes.
+type connHookDispatcher struct {
+	hooks ConnHooks
+	open  sync.Map
+	queue chan func()
+}
+
+func newConnHookDispatcher(hooks *ConnHooks) *connHookDispatcher {
+	if hooks == nil || (hooks.OnOpen == nil && hooks.OnClose == nil) {
+		return nil
+	}
+
+	result := &connHookDispatcher{
+		hooks: *hooks,
+	}
+	if hooks.Async {
+		queueSize := hooks.QueueSize
+		if queueSize <= 0 {
+			queueSize = DefaultConnHooksQueueSize
+		}
+		result.queue = make(chan func(), queueSize)
+	}
+	return result
+}
+
+func (self *connHookDispatcher) run(closeNotify <-chan struct{}) {
+	for {
+		select {
+		case f := <-self.queue:
+			f()
+		case <-closeNotify:
+			return
+		}
+	}
+}
+
+func (self *connHookDispatcher) dispatch(context *ContextImpl, hook func(event *ConnAuditEvent), event *ConnAuditEvent) {
+	if hook == nil {
+		return
+	}
+
+	if self.queue == nil {
+		hook(event)
+		return
+	}
+
+	select {
+	case self.queue <- func() { hook(event) }:
+	default:
+		context.log().WithField("service", event.ServiceName).WithField("connId", event.ConnId).
+			Warn("connection hook queue is full, dropping connection event")
+	}
+}
+
+func (self *connHookDispatcher) opened(context *ContextImpl, conn edge.Conn, event *ConnAuditEvent) {
+	self.open.Store(conn, event)
+	self.dispatch(context, self.hooks.OnOpen, event)
+}
+
+func (self *connHookDispatcher) closed(context *ContextImpl, conn edge.Conn, closedByRemote bool) {
+	val, found := self.open.LoadAndDelete(conn)
+	if !found {
+		return
+	}
+
+	stats := conn.Stats()
+	event := *val.(*ConnAuditEvent)
+	event.Duration = time.Since(event.StartTime)
+	event.BytesIn = stats.BytesIn
+	event.BytesOut = stats.BytesOut
+	event.ClosedByRemote = closedByRemote
+	self.dispatch(context, self.hooks.OnClose, &event)
+}
+
+// OnConnOpened implements network.ConnOpenedOwner, calling the ConnHooks.OnOpen hook from Options.ConnHooks.
+func (context *ContextImpl) OnConnOpened(conn edge.Conn, serviceName string, hosted bool) {
+	if context.connHooks == nil {
+		return
+	}
+
+	stats := conn.Stats()
+	event := &ConnAuditEvent{
+		ServiceName:    serviceName,
+		SourceIdentity: stats.SourceIdentity,
+		Hosted:         hosted,
+		ConnId:         stats.ConnId,
+		CircuitId:      stats.CircuitId,
+		StartTime:      stats.StartTime,
+	}
+	if context.CtrlClt != nil {
+		if apiSession := context.CtrlClt.GetCurrentApiSession(); apiSession != nil {
+			event.IdentityName = apiSession.GetIdentityName()
+		}
+	}
+
+	context.connHooks.opened(context, conn, event)
+}
@@ -0,0 +1,106 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+type statsTestConn struct {
+	edge.Conn
+	stats edge.ConnStats
+}
+
+func (self *statsTestConn) Stats() edge.ConnStats {
+	return self.stats
+}
+
+func Test_connHookDispatcher(t *testing.T) {
+	req := require.New(t)
+
+	var opened, closed []*ConnAuditEvent
+	ctx := &ContextImpl{
+		connHooks: newConnHookDispatcher(&ConnHooks{
+			OnOpen: func(event *ConnAuditEvent) {
+				opened = append(opened, event)
+			},
+			OnClose: func(event *ConnAuditEvent) {
+				closed = append(closed, event)
+			},
+		}),
+	}
+
+	conn := &statsTestConn{stats: edge.ConnStats{
+		ConnId:         7,
+		ServiceName:    "svc",
+		CircuitId:      "circuit",
+		SourceIdentity: "client",
+		StartTime:      time.Now().Add(-time.Minute),
+	}}
+
+	ctx.OnConnOpened(conn, "svc", true)
+	req.Len(opened, 1)
+	req.Equal(&ConnAuditEvent{
+		ServiceName:    "svc",
+		SourceIdentity: "client",
+		Hosted:         true,
+		ConnId:         7,
+		CircuitId:      "circuit",
+		StartTime:      conn.stats.StartTime,
+	}, opened[0])
+
+	conn.stats.BytesIn = 10
+	conn.stats.BytesOut = 20
+	ctx.OnConnClosed(conn, "svc", true)
+	ctx.OnConnClosed(conn, "svc", true)
+	ctx.OnConnClosed(&statsTestConn{}, "svc", false)
+
+	req.Len(closed, 1, "only connections reported open are reported closed, once")
+	req.Equal(uint64(10), closed[0].BytesIn)
+	req.Equal(uint64(20), closed[0].BytesOut)
+	req.True(closed[0].ClosedByRemote)
+	req.True(closed[0].Hosted)
+	req.GreaterOrEqual(closed[0].Duration, time.Minute)
+	req.Zero(opened[0].Duration, "the open event is not modified")
+}
+
+func Test_connHookDispatcher_async(t *testing.T) {
+	req := require.New(t)
+
+	release := make(chan struct{})
+	events := make(chan *ConnAuditEvent, 4)
+	ctx := &ContextImpl{
+		closeNotify: make(chan struct{}),
+		connHooks: newConnHookDispatcher(&ConnHooks{
+			OnOpen: func(event *ConnAuditEvent) {
+				<-release
+				events <- event
+			},
+			Async:     true,
+			QueueSize: 1,
+		}),
+	}
+	defer close(ctx.closeNotify)
+	go ctx.connHooks.run(ctx.closeNotify)
+
+	ctx.OnConnOpened(&statsTestConn{stats: edge.ConnStats{ConnId: 1}}, "svc", false)
+	req.Eventually(func() bool {
+		return len(ctx.connHooks.queue) == 0
+	}, time.Second, time.Millisecond, "the first event is taken by the blocked hook")
+
+	ctx.OnConnOpened(&statsTestConn{stats: edge.ConnStats{ConnId: 2}}, "svc", false)
+	ctx.OnConnOpened(&statsTestConn{stats: edge.ConnStats{ConnId: 3}}, "svc", false)
+	close(release)
+
+	req.Equal(uint32(1), (<-events).ConnId)
+	req.Equal(uint32(2), (<-events).ConnId)
+	select {
+	case event := <-events:
+		req.Failf("event should have been dropped", "got conn %d", event.ConnId)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	req.Nil(newConnHookDispatcher(&ConnHooks{Async: true}), "no hooks, no dispatcher")
+}
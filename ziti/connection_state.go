@@ -0,0 +1,230 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionState is the stage of a Context in establishing its connection to the network, as reported by
+// Context.ConnectionState and EventConnectionStateChanged.
+type ConnectionState string
+
+const (
+	// ConnectionStateDisconnected is the state of a Context that has no API Session, before its first authentication
+	// and after it lost its API Session and failed to authenticate again.
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+
+	// ConnectionStateAuthenticating is the state of a Context while it authenticates, including while it waits for
+	// authentication queries such as MFA to be answered.
+	ConnectionStateAuthenticating ConnectionState = "authenticating"
+
+	// ConnectionStateSyncing is the state of a Context that is authenticated and is listing its services.
+	ConnectionStateSyncing ConnectionState = "syncing"
+
+	// ConnectionStateReady is the state of a Context that is authenticated and has listed its services.
+	ConnectionStateReady ConnectionState = "ready"
+)
+
+// ReconnectPolicy controls how a Context that lost its API Session and failed to authenticate again keeps trying in
+// the background. See DefaultReconnectPolicy.
+type ReconnectPolicy struct {
+	// Disabled stops the Context from reconnecting in the background. It then only authenticates again when an
+	// operation such as a dial needs an API Session.
+	Disabled bool
+
+	// Interval is the delay before the first attempt.
+	Interval time.Duration
+
+	// Multiplier grows the delay after each failed attempt. Values of 1 or less keep the delay at Interval.
+	Multiplier float64
+
+	// MaxInterval caps the delay between attempts, if set.
+	MaxInterval time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it in either direction, so that contexts disconnected
+	// together don't reconnect together.
+	Jitter float64
+
+	// MaxElapsedTime stops reconnecting once this long has passed since the first attempt, if set.
+	MaxElapsedTime time.Duration
+}
+
+// DefaultReconnectPolicy returns a policy reconnecting until the Context is closed, backing off exponentially from
+// 1s to 1m.
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		Interval:    time.Second,
+		Multiplier:  2,
+		MaxInterval: time.Minute,
+		Jitter:      0.2,
+	}
+}
+
+// delay returns how long to wait after the given number of failed attempts before making the next one.
+func (self *ReconnectPolicy) delay(failures int) time.Duration {
+	delay := float64(self.Interval)
+	if self.Multiplier > 1 && failures > 0 {
+		delay *= math.Pow(self.Multiplier, float64(failures))
+	}
+	if self.MaxInterval > 0 && delay > float64(self.MaxInterval) {
+		delay = float64(self.MaxInterval)
+	}
+	if self.Jitter > 0 {
+		delay += delay * self.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+func (self *Options) getReconnectPolicy() *ReconnectPolicy {
+	if self.ReconnectPolicy == nil {
+		return DefaultReconnectPolicy()
+	}
+	return self.ReconnectPolicy
+}
+
+// connectionStateMachine holds the ConnectionState of a Context. The zero value is disconnected.
+type connectionStateMachine struct {
+	lock         sync.Mutex
+	state        ConnectionState
+	reconnecting atomic.Bool
+}
+
+func (self *connectionStateMachine) get() ConnectionState {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.getLocked()
+}
+
+func (self *connectionStateMachine) getLocked() ConnectionState {
+	if self.state == "" {
+		return ConnectionStateDisconnected
+	}
+	return self.state
+}
+
+// transition moves to the given state and returns the previous one, if the current state is one of from, or from is
+// empty. It reports whether the state changed.
+func (self *connectionStateMachine) transition(to ConnectionState, from ...ConnectionState) (ConnectionState, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	current := self.getLocked()
+	if current == to {
+		return current, false
+	}
+
+	if len(from) > 0 {
+		allowed := false
+		for _, state := range from {
+			allowed = allowed || state == current
+		}
+		if !allowed {
+			return current, false
+		}
+	}
+
+	self.state = to
+	return current, true
+}
+
+func (context *ContextImpl) ConnectionState() ConnectionState {
+	return context.connectionState.get()
+}
+
+// setConnectionState moves the Context to the given state if it is in one of the from states, or any state if none
+// are given, emitting EventConnectionStateChanged if the state changed.
+func (context *ContextImpl) setConnectionState(state ConnectionState, from ...ConnectionState) {
+	if prev, changed := context.connectionState.transition(state, from...); changed {
+		context.log().Debugf("connection state changed from %s to %s", prev, state)
+		context.Emit(EventConnectionStateChanged, prev, state)
+	}
+}
+
+// startReconnect authenticates again in the background, following the ReconnectPolicy, after a Context that had been
+// authenticated failed to authenticate. Only one reconnect runs at a time.
+func (context *ContextImpl) startReconnect() {
+	policy := context.options.getReconnectPolicy()
+	if policy.Disabled || !context.fullyAuthenticated.Load() || context.closed.Load() {
+		return
+	}
+
+	if !context.connectionState.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+
+	context.spawn(func() {
+		defer context.connectionState.reconnecting.Store(false)
+
+		log := context.log()
+		start := time.Now()
+
+		for failures := 0; ; failures++ {
+			delay := policy.delay(failures)
+			if policy.MaxElapsedTime > 0 && time.Since(start)+delay > policy.MaxElapsedTime {
+				log.Warnf("giving up reconnecting after %v", time.Since(start))
+				return
+			}
+
+			select {
+			case <-context.closeNotify:
+				return
+			case <-time.After(delay):
+			}
+
+			if context.CtrlClt.GetCurrentApiSession() != nil {
+				return
+			}
+
+			log.Infof("reconnecting, attempt %d", failures+1)
+			if err := context.Authenticate(); err == nil {
+				return
+			} else {
+				log.WithError(err).Info("reconnect attempt failed")
+			}
+		}
+	})
+}
+
+func (context *ContextImpl) AddConnectionStateListener(handler func(ztx Context, from ConnectionState, to ConnectionState)) func() {
+	listener := func(args ...interface{}) {
+		from, ok := args[0].(ConnectionState)
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", from, args[0])
+		}
+
+		to, ok := args[1].(ConnectionState)
+		if !ok {
+			context.log().Fatalf("could not convert args[1] to %T was %T", to, args[1])
+		}
+
+		handler(context, from, to)
+	}
+
+	context.AddListener(EventConnectionStateChanged, listener)
+
+	return func() {
+		context.RemoveListener(EventConnectionStateChanged, listener)
+	}
+}
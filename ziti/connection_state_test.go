@@ -0,0 +1,127 @@
+package ziti
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kataras/go-events"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_contextImpl_connectionState(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options:      &Options{ReconnectPolicy: &ReconnectPolicy{Disabled: true}},
+		EventEmmiter: events.New(),
+	}
+	req.Equal(ConnectionStateDisconnected, ctx.ConnectionState())
+
+	var lock sync.Mutex
+	var changes [][2]ConnectionState
+	remove := ReadOnly(ctx).Events().AddConnectionStateListener(func(_ Context, from ConnectionState, to ConnectionState) {
+		lock.Lock()
+		defer lock.Unlock()
+		changes = append(changes, [2]ConnectionState{from, to})
+	})
+	defer remove()
+
+	sub := ctx.EventBus().Subscribe(nil)
+	defer sub.Close()
+
+	ctx.setConnectionState(ConnectionStateAuthenticating)
+	ctx.setConnectionState(ConnectionStateAuthenticating)
+	ctx.setConnectionState(ConnectionStateSyncing)
+
+	// only a syncing context becomes ready when services are listed
+	ctx.setConnectionState(ConnectionStateReady, ConnectionStateSyncing)
+	ctx.setConnectionState(ConnectionStateReady, ConnectionStateSyncing)
+
+	ctx.onAuthFailed(errors.New("invalid auth"))
+	ctx.setConnectionState(ConnectionStateReady, ConnectionStateSyncing)
+
+	req.Equal(ConnectionStateDisconnected, ctx.ConnectionState())
+
+	lock.Lock()
+	req.Equal([][2]ConnectionState{
+		{ConnectionStateDisconnected, ConnectionStateAuthenticating},
+		{ConnectionStateAuthenticating, ConnectionStateSyncing},
+		{ConnectionStateSyncing, ConnectionStateReady},
+		{ConnectionStateReady, ConnectionStateDisconnected},
+	}, changes)
+	lock.Unlock()
+
+	var typed []ConnectionStateChangedEvent
+	for len(sub.C()) > 0 {
+		if event, ok := (<-sub.C()).(ConnectionStateChangedEvent); ok {
+			typed = append(typed, event)
+		}
+	}
+	req.Len(typed, 4)
+	req.Equal(ConnectionStateChangedEvent{From: ConnectionStateSyncing, To: ConnectionStateReady}, typed[2])
+}
+
+func TestReconnectPolicy_delay(t *testing.T) {
+	req := require.New(t)
+
+	policy := &ReconnectPolicy{Interval: time.Second, Multiplier: 2, MaxInterval: 10 * time.Second}
+	req.Equal(time.Second, policy.delay(0))
+	req.Equal(4*time.Second, policy.delay(2))
+	req.Equal(10*time.Second, policy.delay(6))
+
+	req.Equal(DefaultReconnectPolicy(), (&Options{}).getReconnectPolicy())
+}
+
+func Test_contextImpl_reconnect(t *testing.T) {
+	req := require.New(t)
+
+	var authAttempts atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authAttempts.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"code":"INVALID_AUTH","message":"invalid auth"},"meta":{}}`))
+	}))
+	defer server.Close()
+
+	options := &Options{
+		APIClientCustomizer: func(client *http.Client, transport *http.Transport) {
+			transport.TLSClientConfig.InsecureSkipVerify = true
+		},
+		ReconnectPolicy: &ReconnectPolicy{Interval: 10 * time.Millisecond},
+	}
+
+	cfg := &Config{
+		ZtAPI:       server.URL + "/edge/client/v1",
+		Credentials: edge_apis.NewUpdbCredentials("user", "password"),
+	}
+
+	ztx, err := NewContextWithOpts(cfg, options)
+	req.NoError(err)
+	ctx := ztx.(*ContextImpl)
+	defer ctx.Close()
+
+	// a context that never authenticated doesn't reconnect
+	req.Error(ctx.Authenticate())
+	time.Sleep(50 * time.Millisecond)
+	req.False(ctx.connectionState.reconnecting.Load())
+
+	ctx.fullyAuthenticated.Store(true)
+	attempts := authAttempts.Load()
+	req.Error(ctx.Authenticate())
+	req.Eventually(func() bool {
+		return authAttempts.Load() >= attempts+3
+	}, 2*time.Second, 10*time.Millisecond)
+	req.Equal(ConnectionStateDisconnected, ctx.ConnectionState())
+
+	ctx.Close()
+	req.Eventually(func() bool {
+		return !ctx.connectionState.reconnecting.Load()
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -27,13 +27,13 @@ package ziti
 
 import (
 	"github.com/kataras/go-events"
-	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/edge-api/rest_model"
 	edge_apis "github.com/openziti/sdk-golang/edge-apis"
 	"github.com/openziti/sdk-golang/ziti/edge"
 	"github.com/openziti/sdk-golang/ziti/edge/posture"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/pkg/errors"
+	"net/http"
 	"net/url"
 	"strconv"
 )
@@ -71,6 +71,7 @@ func NewContext(cfg *Config) (Context, error) {
 
 // NewContextWithOpts creates a Context from the supplied Config and Options. The configuration requires
 // either the `ID` field or the `Credentials` field to be populated. If both are supplied, the `ID` field is used.
+// If neither is supplied, the `InstanceIdentity` field may be used to authenticate as the cloud instance.
 func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 	if options == nil {
 		options = DefaultOptions
@@ -80,10 +81,22 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 		Id:                NewId(),
 		routerConnections: cmap.New[edge.RouterConn](),
 		options:           options,
+		logger:            newHandlerLogger(options.LogHandler),
 		authQueryHandlers: map[string]func(query *rest_model.AuthQueryDetail, response MfaCodeResponse) error{},
 		closeNotify:       make(chan struct{}),
 		EventEmmiter:      events.New(),
+		recentEvents:      newRecentEventRing(options.getRecentEventsSize()),
+		terminators:       cmap.New[*serviceTerminators](),
+		listenerManagers:  cmap.New[*listenerManager](),
+		warmServices:      cmap.New[struct{}](),
+		wssFallbacks:      cmap.New[*wssFallback](),
+		routerLatencies:   cmap.New[*routerLatency](),
+		selectedRouters:   cmap.New[string](),
+		unhealthyRouters:  cmap.New[struct{}](),
+
+		serviceRefreshIntervalChanged: make(chan struct{}, 1),
 	}
+	newContext.apiSessionRefresher.reset = make(chan struct{}, 1)
 
 	if cfg == nil {
 		return nil, errors.New("a config is required")
@@ -93,8 +106,15 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 		idCredentials := edge_apis.NewIdentityCredentialsFromConfig(cfg.ID)
 		idCredentials.ConfigTypes = cfg.ConfigTypes
 		cfg.Credentials = idCredentials
+	} else if cfg.Credentials == nil && cfg.InstanceIdentity != nil {
+		instanceCredentials, err := cfg.InstanceIdentity.NewCredentials()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not configure instance identity credentials")
+		}
+		instanceCredentials.ConfigTypes = cfg.ConfigTypes
+		cfg.Credentials = instanceCredentials
 	} else if cfg.Credentials == nil {
-		return nil, errors.New("either cfg.ID or cfg.Credentials must be provided")
+		return nil, errors.New("either cfg.ID, cfg.Credentials, or cfg.InstanceIdentity must be provided")
 	}
 
 	var apiStrs []string
@@ -104,6 +124,12 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 		apiStrs = []string{cfg.ZtAPI}
 	}
 
+	if options.RestrictedEgress {
+		if err := checkRestrictedEgressControllers(apiStrs); err != nil {
+			return nil, err
+		}
+	}
+
 	var apiUrls []*url.URL
 	for _, apiStr := range apiStrs {
 		apiUrl, err := url.Parse(cfg.ZtAPI)
@@ -130,8 +156,18 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 
 				newContext.Emit(EventMfaTotpCode, authQuery, MfaCodeResponse(newContext.authenticateMfa))
 
+				if authHandler := newContext.options.AuthHandler; authHandler != nil {
+					code, err := authHandler.AnswerAuthQuery(newContext, authQuery)
+					if err != nil {
+						newContext.log().WithError(err).Error("auth handler failed to answer mfa totp query")
+						return
+					}
+					codeCh <- code
+					return
+				}
+
 				if handler == nil {
-					pfxlog.Logger().Debugf("no callback handler registered for provider: %v, event will still be emitted", *authQuery.Provider)
+					newContext.log().Debugf("no callback handler registered for provider: %v, event will still be emitted", *authQuery.Provider)
 					return
 				}
 
@@ -143,10 +179,65 @@ func NewContextWithOpts(cfg *Config, options *Options) (Context, error) {
 		}),
 		Credentials: cfg.Credentials,
 		ConfigTypes: cfg.ConfigTypes,
+		logger:      newContext.logger,
 	}
 
 	newContext.CtrlClt.ClientApiClient.SetAllowOidcDynamicallyEnabled(cfg.EnableHa)
+
+	if cfg.Proxy != "" {
+		proxyUrl, err := parseProxyUrl(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		newContext.proxyUrl = proxyUrl
+		newContext.CtrlClt.HttpTransport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	controllerTls := options.ControllerTLS.withPins(cfg.ControllerPins)
+	if err := controllerTls.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid controller TLS options")
+	}
+	if err := options.EdgeRouterTLS.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid edge router TLS options")
+	}
+
+	ctrlTransport := newContext.CtrlClt.HttpTransport
+	ctrlTransport.TLSClientConfig = controllerTls.apply(ctrlTransport.TLSClientConfig, controllerTls.newSessionCache())
+	controllerTls.newRevocationChecker(newContext.logger).apply(ctrlTransport.TLSClientConfig)
+	newContext.edgeRouterTlsSessions = options.EdgeRouterTLS.newSessionCache()
+	newContext.edgeRouterRevocation = options.EdgeRouterTLS.newRevocationChecker(newContext.logger)
+	newContext.rateLimits = newRateLimiters(options.RateLimits, newContext.logger)
+
+	newContext.connHooks = newConnHookDispatcher(options.ConnHooks)
+	if newContext.connHooks != nil && newContext.connHooks.queue != nil {
+		newContext.spawn(func() {
+			newContext.connHooks.run(newContext.closeNotify)
+		})
+	}
+
+	newContext.CtrlClt.HttpClient.Transport = newControllerContactTransport(newContext.CtrlClt.HttpClient.Transport, &newContext.controllerContact)
+
+	if options.ControllerBreaker != nil {
+		httpClient := newContext.CtrlClt.HttpClient
+		breaker := newControllerBreaker(httpClient.Transport, options.ControllerBreaker,
+			newContext.recordControllerBreakerState, newContext.recordControllerBreakerRejection)
+		breaker.logger = newContext.logger
+		httpClient.Transport = breaker
+	}
+
+	if options.CacheControllerResponses {
+		httpClient := newContext.CtrlClt.HttpClient
+		httpClient.Transport = newControllerCache(httpClient.Transport, DefaultControllerCachePaths, newContext.recordControllerCacheResult)
+	}
+
+	if options.APIClientCustomizer != nil {
+		options.APIClientCustomizer(newContext.CtrlClt.HttpClient, newContext.CtrlClt.HttpTransport)
+	}
+
 	newContext.CtrlClt.PostureCache = posture.NewCache(newContext.CtrlClt, newContext.closeNotify)
+	if options.PostureProvider != nil {
+		newContext.CtrlClt.PostureCache.SetProvider(options.PostureProvider)
+	}
 
 	newContext.CtrlClt.AddOnControllerUpdateListeners(func(urls []*url.URL) {
 		newContext.Emit(EventControllerUrlsUpdated, urls)
@@ -0,0 +1,277 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultControllerBreakerFailureThreshold = 5
+	DefaultControllerBreakerOpenTimeout      = 30 * time.Second
+
+	// MetricControllerBreakerOpen is the gauge of the number of controllers whose circuit breaker is open.
+	MetricControllerBreakerOpen = "controller.breaker.open"
+
+	// MetricControllerBreakerRejections meters the controller requests failed without being sent, because the
+	// controller's circuit breaker is open.
+	MetricControllerBreakerRejections = "controller.breaker.rejections"
+)
+
+// ErrControllerBreakerOpen is returned for controller requests made while the controller's circuit breaker is open.
+var ErrControllerBreakerOpen = errors.New("controller circuit breaker is open")
+
+// BreakerState is the state of a controller circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed lets requests through. It is the initial state.
+	BreakerClosed BreakerState = "closed"
+
+	// BreakerOpen fails requests without sending them, until ControllerBreakerOptions.OpenTimeout has passed.
+	BreakerOpen BreakerState = "open"
+
+	// BreakerHalfOpen lets a single request through to probe whether the controller has recovered. The breaker closes
+	// if it succeeds and opens again if it fails.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ControllerBreakerOptions configures the circuit breakers wrapping the Edge Client API requests to each controller, so
+// that a failing or flapping controller is given time to recover instead of being retried in a tight loop. Requests
+// fail if they can't be sent or the controller answers with a 5xx status.
+type ControllerBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the breaker. Defaults to
+	// DefaultControllerBreakerFailureThreshold if zero.
+	FailureThreshold int
+
+	// OpenTimeout is how long the breaker stays open before a request is let through to probe the controller.
+	// Defaults to DefaultControllerBreakerOpenTimeout if zero.
+	OpenTimeout time.Duration
+}
+
+func (self *ControllerBreakerOptions) getFailureThreshold() int {
+	if self.FailureThreshold <= 0 {
+		return DefaultControllerBreakerFailureThreshold
+	}
+	return self.FailureThreshold
+}
+
+func (self *ControllerBreakerOptions) getOpenTimeout() time.Duration {
+	if self.OpenTimeout <= 0 {
+		return DefaultControllerBreakerOpenTimeout
+	}
+	return self.OpenTimeout
+}
+
+// breaker is the circuit breaker of a single controller.
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// controllerBreaker is a http.RoundTripper that keeps a circuit breaker per controller host.
+type controllerBreaker struct {
+	next          http.RoundTripper
+	options       *ControllerBreakerOptions
+	onStateChange func(host string, state BreakerState, openBreakers int)
+	onRejected    func(host string)
+	logger        *logrus.Logger
+
+	lock     sync.Mutex
+	breakers map[string]*breaker
+	now      func() time.Time
+}
+
+func newControllerBreaker(next http.RoundTripper, options *ControllerBreakerOptions,
+	onStateChange func(host string, state BreakerState, openBreakers int), onRejected func(host string)) *controllerBreaker {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &controllerBreaker{
+		next:          next,
+		options:       options,
+		onStateChange: onStateChange,
+		onRejected:    onRejected,
+		breakers:      map[string]*breaker{},
+		now:           time.Now,
+	}
+}
+
+// State returns the state of the breaker of the controller at host.
+func (self *controllerBreaker) State(host string) BreakerState {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if b, found := self.breakers[host]; found {
+		return b.state
+	}
+	return BreakerClosed
+}
+
+func (self *controllerBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	allowed, change := self.allow(host)
+	self.notify(host, change)
+	if !allowed {
+		if self.onRejected != nil {
+			self.onRejected(host)
+		}
+		return nil, errors.Wrapf(ErrControllerBreakerOpen, "controller %s", host)
+	}
+
+	resp, err := self.next.RoundTrip(req)
+	self.notify(host, self.record(host, err == nil && resp.StatusCode < http.StatusInternalServerError))
+	return resp, err
+}
+
+// breakerChange is a breaker state change, reported once the lock is released so that listeners may make controller
+// requests.
+type breakerChange struct {
+	state        BreakerState
+	openBreakers int
+}
+
+func (self *controllerBreaker) allow(host string) (bool, *breakerChange) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	b, found := self.breakers[host]
+	if !found {
+		return true, nil
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		if self.now().Sub(b.openedAt) < self.options.getOpenTimeout() {
+			return false, nil
+		}
+		b.probing = true
+		return true, self.setState(b, BreakerHalfOpen)
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, nil
+		}
+		b.probing = true
+		return true, nil
+	default:
+		return true, nil
+	}
+}
+
+func (self *controllerBreaker) record(host string, success bool) *breakerChange {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	b, found := self.breakers[host]
+	if !found {
+		if success {
+			return nil
+		}
+		b = &breaker{state: BreakerClosed}
+		self.breakers[host] = b
+	}
+
+	if success {
+		b.failures = 0
+		b.probing = false
+		return self.setState(b, BreakerClosed)
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= self.options.getFailureThreshold()) {
+		b.probing = false
+		b.openedAt = self.now()
+		return self.setState(b, BreakerOpen)
+	}
+	return nil
+}
+
+// setState must be called with the lock held.
+func (self *controllerBreaker) setState(b *breaker, state BreakerState) *breakerChange {
+	if b.state == state {
+		return nil
+	}
+	b.state = state
+
+	change := &breakerChange{state: state}
+	for _, v := range self.breakers {
+		if v.state == BreakerOpen {
+			change.openBreakers++
+		}
+	}
+	return change
+}
+
+func (self *controllerBreaker) notify(host string, change *breakerChange) {
+	if change == nil {
+		return
+	}
+
+	log := newLogger(self.logger).WithField("controller", host).WithField("state", change.state)
+	if change.state == BreakerOpen {
+		log.Warn("controller circuit breaker opened")
+	} else {
+		log.Info("controller circuit breaker state changed")
+	}
+
+	if self.onStateChange != nil {
+		self.onStateChange(host, change.state, change.openBreakers)
+	}
+}
+
+func (context *ContextImpl) recordControllerBreakerState(host string, state BreakerState, openBreakers int) {
+	if context.metrics != nil {
+		context.metrics.Gauge(MetricControllerBreakerOpen).Update(int64(openBreakers))
+	}
+	context.Emit(EventControllerBreakerStateChanged, host, state)
+}
+
+func (context *ContextImpl) recordControllerBreakerRejection(string) {
+	if context.metrics != nil {
+		context.metrics.Meter(MetricControllerBreakerRejections).Mark(1)
+	}
+}
+
+func (context *ContextImpl) AddControllerBreakerListener(handler func(ztx Context, host string, state BreakerState)) func() {
+	listener := func(args ...interface{}) {
+		host, ok := args[0].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", host, args[0])
+		}
+
+		state, ok := args[1].(BreakerState)
+		if !ok {
+			context.log().Fatalf("could not convert args[1] to %T was %T", state, args[1])
+		}
+
+		handler(context, host, state)
+	}
+
+	context.AddListener(EventControllerBreakerStateChanged, listener)
+
+	return func() {
+		context.RemoveListener(EventControllerBreakerStateChanged, listener)
+	}
+}
@@ -0,0 +1,128 @@
+package ziti
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_controllerBreaker(t *testing.T) {
+	req := require.New(t)
+
+	var failing atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverUrl, err := url.Parse(server.URL)
+	req.NoError(err)
+	host := serverUrl.Host
+
+	var states []BreakerState
+	var open int
+	rejections := 0
+	breaker := newControllerBreaker(nil, &ControllerBreakerOptions{FailureThreshold: 3, OpenTimeout: time.Minute},
+		func(_ string, state BreakerState, openBreakers int) {
+			states = append(states, state)
+			open = openBreakers
+		},
+		func(string) {
+			rejections++
+		})
+
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	client := &http.Client{Transport: breaker}
+
+	get := func() (int, error) {
+		resp, err := client.Get(server.URL + "/edge/client/v1/services")
+		if err != nil {
+			return 0, err
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	status, err := get()
+	req.NoError(err)
+	req.Equal(http.StatusOK, status)
+	req.Equal(BreakerClosed, breaker.State(host))
+
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		status, err = get()
+		req.NoError(err)
+		req.Equal(http.StatusServiceUnavailable, status)
+	}
+	req.Equal(BreakerOpen, breaker.State(host))
+	req.Equal([]BreakerState{BreakerOpen}, states)
+	req.Equal(1, open)
+
+	_, err = get()
+	req.ErrorIs(err, ErrControllerBreakerOpen)
+	req.Equal(int32(4), requests.Load())
+	req.Equal(1, rejections)
+
+	// the probe fails, so the breaker opens again
+	now = now.Add(time.Minute)
+	_, err = get()
+	req.NoError(err)
+	req.Equal(int32(5), requests.Load())
+	req.Equal([]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen}, states)
+
+	_, err = get()
+	req.ErrorIs(err, ErrControllerBreakerOpen)
+
+	// the probe succeeds, so the breaker closes
+	failing.Store(false)
+	now = now.Add(time.Minute)
+	status, err = get()
+	req.NoError(err)
+	req.Equal(http.StatusOK, status)
+	req.Equal(BreakerClosed, breaker.State(host))
+	req.Equal([]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}, states)
+	req.Equal(0, open)
+
+	status, err = get()
+	req.NoError(err)
+	req.Equal(http.StatusOK, status)
+}
+
+func Test_controllerBreaker_halfOpenAllowsOneProbe(t *testing.T) {
+	req := require.New(t)
+
+	breaker := newControllerBreaker(nil, &ControllerBreakerOptions{}, nil, nil)
+	for i := 0; i < DefaultControllerBreakerFailureThreshold; i++ {
+		breaker.record("ctrl:443", false)
+	}
+	req.Equal(BreakerOpen, breaker.State("ctrl:443"))
+
+	allowed, _ := breaker.allow("ctrl:443")
+	req.False(allowed)
+
+	// other controllers are unaffected
+	allowed, _ = breaker.allow("other:443")
+	req.True(allowed)
+
+	now := time.Now().Add(DefaultControllerBreakerOpenTimeout)
+	breaker.now = func() time.Time { return now }
+
+	allowed, change := breaker.allow("ctrl:443")
+	req.True(allowed)
+	req.Equal(BreakerHalfOpen, change.state)
+
+	allowed, _ = breaker.allow("ctrl:443")
+	req.False(allowed)
+}
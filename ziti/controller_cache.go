@@ -0,0 +1,214 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// MetricControllerCacheHits meters controller GET requests answered from the cache after the controller confirmed
+	// the cached response is current.
+	MetricControllerCacheHits = "controller.cache.hits"
+
+	// MetricControllerCacheMisses meters cacheable controller GET requests whose response had to be transferred.
+	MetricControllerCacheMisses = "controller.cache.misses"
+
+	// maxControllerCacheEntries bounds the number of cached responses.
+	maxControllerCacheEntries = 256
+
+	// maxControllerCacheBodySize is the largest response body that is cached.
+	maxControllerCacheBodySize = 16 * 1024 * 1024
+)
+
+// DefaultControllerCachePaths are the controller API paths whose GET responses are cached when
+// Options.CacheControllerResponses is set.
+var DefaultControllerCachePaths = []string{"/services", "/current-identity", "/config-types"}
+
+type cachedResponse struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// controllerCache is a http.RoundTripper that caches controller GET responses carrying an ETag and revalidates them
+// with If-None-Match, so that unchanged collections are not transferred again. Responses are cached per API Session,
+// as their content depends on the authenticated identity.
+type controllerCache struct {
+	next     http.RoundTripper
+	paths    []string
+	onResult func(hit bool)
+
+	lock    sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+func newControllerCache(next http.RoundTripper, paths []string, onResult func(hit bool)) *controllerCache {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &controllerCache{
+		next:     next,
+		paths:    paths,
+		onResult: onResult,
+		entries:  map[string]*cachedResponse{},
+	}
+}
+
+func (self *controllerCache) isCacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return false
+	}
+	for _, path := range self.paths {
+		if strings.Contains(req.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *controllerCache) cacheKey(req *http.Request) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(req.URL.String()))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(req.Header.Get("zt-session")))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(req.Header.Get("Authorization")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (self *controllerCache) get(key string) *cachedResponse {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.entries[key]
+}
+
+func (self *controllerCache) set(key string, entry *cachedResponse) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if entry == nil {
+		delete(self.entries, key)
+		return
+	}
+
+	if _, found := self.entries[key]; !found && len(self.entries) >= maxControllerCacheEntries {
+		// entries of expired API Sessions are never hit again, so evicting an arbitrary entry is good enough
+		for k := range self.entries {
+			delete(self.entries, k)
+			break
+		}
+	}
+	self.entries[key] = entry
+}
+
+func (self *controllerCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !self.isCacheable(req) {
+		return self.next.RoundTrip(req)
+	}
+
+	key := self.cacheKey(req)
+	entry := self.get(key)
+
+	outbound := req
+	if entry != nil && req.Header.Get("If-None-Match") == "" {
+		outbound = req.Clone(req.Context())
+		outbound.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := self.next.RoundTrip(outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil && outbound != req {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		self.result(true)
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        entry.header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(entry.body)),
+			ContentLength: int64(len(entry.body)),
+			Request:       req,
+		}, nil
+	}
+
+	self.result(false)
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || resp.ContentLength > maxControllerCacheBodySize {
+		self.set(key, nil)
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxControllerCacheBodySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		self.set(key, nil)
+		return nil, err
+	}
+
+	if len(body) > maxControllerCacheBodySize {
+		self.set(key, nil)
+		resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp, nil
+	}
+
+	_ = resp.Body.Close()
+	self.set(key, &cachedResponse{
+		etag:   etag,
+		header: resp.Header.Clone(),
+		body:   body,
+	})
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// prefixedBody returns the part of a response body already read, followed by the rest of it.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func (self *controllerCache) result(hit bool) {
+	if self.onResult != nil {
+		self.onResult(hit)
+	}
+}
+
+func (context *ContextImpl) recordControllerCacheResult(hit bool) {
+	if context.metrics == nil {
+		return
+	}
+	if hit {
+		context.metrics.Meter(MetricControllerCacheHits).Mark(1)
+	} else {
+		context.metrics.Meter(MetricControllerCacheMisses).Mark(1)
+	}
+}
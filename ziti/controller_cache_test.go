@@ -0,0 +1,97 @@
+package ziti
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_controllerCache(t *testing.T) {
+	var transfers atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + r.Header.Get("zt-session") + `-v1"`
+		if r.URL.Path == "/edge/client/v1/services" {
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		transfers.Add(1)
+		_, _ = w.Write([]byte("body for " + r.Header.Get("zt-session")))
+	}))
+	defer server.Close()
+
+	var hits, misses int
+	cache := newControllerCache(nil, DefaultControllerCachePaths, func(hit bool) {
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+	})
+	client := &http.Client{Transport: cache}
+
+	get := func(path, session string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("zt-session", session)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("revalidated responses are served from the cache", func(t *testing.T) {
+		req := require.New(t)
+
+		for i := 0; i < 3; i++ {
+			status, body := get("/edge/client/v1/services", "a")
+			req.Equal(http.StatusOK, status)
+			req.Equal("body for a", body)
+		}
+		req.Equal(int32(1), transfers.Load())
+		req.Equal(2, hits)
+		req.Equal(1, misses)
+	})
+
+	t.Run("responses are cached per session", func(t *testing.T) {
+		req := require.New(t)
+
+		status, body := get("/edge/client/v1/services", "b")
+		req.Equal(http.StatusOK, status)
+		req.Equal("body for b", body)
+		req.Equal(int32(2), transfers.Load())
+		req.Equal(2, misses)
+	})
+
+	t.Run("other paths pass through", func(t *testing.T) {
+		req := require.New(t)
+
+		for i := 0; i < 2; i++ {
+			_, body := get("/edge/client/v1/sessions", "a")
+			req.Equal("body for a", body)
+		}
+		req.Equal(int32(4), transfers.Load())
+		req.Equal(2, hits)
+		req.Equal(2, misses)
+	})
+
+	t.Run("responses without an ETag are not cached", func(t *testing.T) {
+		req := require.New(t)
+
+		for i := 0; i < 2; i++ {
+			_, body := get("/edge/client/v1/current-identity", "a")
+			req.Equal("body for a", body)
+		}
+		req.Equal(int32(6), transfers.Load())
+		req.Equal(2, hits)
+		req.Equal(4, misses)
+	})
+}
@@ -0,0 +1,225 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/openziti/foundation/v2/stringz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// debugState is the state of a Context written by DebugDump. Session tokens are left out.
+type debugState struct {
+	ContextId   string             `json:"contextId"`
+	Closed      bool               `json:"closed"`
+	ApiSession  *debugApiSession   `json:"apiSession,omitempty"`
+	Services    []debugService     `json:"services"`
+	Sessions    []debugSession     `json:"sessions"`
+	EdgeRouters []debugEdgeRouter  `json:"edgeRouters"`
+	Listeners   []debugListener    `json:"listeners"`
+	Connections []edge.ConnStats   `json:"connections"`
+	Events      []debugRecentEvent `json:"recentEvents"`
+}
+
+type debugApiSession struct {
+	Id           string     `json:"id"`
+	IdentityId   string     `json:"identityId"`
+	IdentityName string     `json:"identityName"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	AuthQueries  int        `json:"pendingAuthQueries"`
+	LastRefresh  time.Time  `json:"lastRefresh"`
+}
+
+type debugService struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Encryption  bool     `json:"encryptionRequired"`
+}
+
+type debugSession struct {
+	Id          string   `json:"id"`
+	ServiceId   string   `json:"serviceId"`
+	Type        string   `json:"type"`
+	EdgeRouters []string `json:"edgeRouters"`
+}
+
+type debugEdgeRouter struct {
+	Name        string        `json:"name"`
+	Address     string        `json:"address"`
+	Closed      bool          `json:"closed"`
+	Healthy     bool          `json:"healthy"`
+	Latency     time.Duration `json:"latencyNanos"`
+	Connections int           `json:"connections"`
+}
+
+type debugListener struct {
+	Id      string `json:"id"`
+	Service string `json:"service"`
+	Closed  bool   `json:"closed"`
+}
+
+type debugRecentEvent struct {
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	Detail string    `json:"detail,omitempty"`
+	Err    string    `json:"error,omitempty"`
+}
+
+// DebugDump writes the state of the Context to w as indented JSON. It is meant for debugging, the format may change.
+func (context *ContextImpl) DebugDump(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(context.debugState())
+}
+
+func (context *ContextImpl) debugState() *debugState {
+	result := &debugState{
+		ContextId:   context.Id,
+		Closed:      context.closed.Load(),
+		Services:    []debugService{},
+		Sessions:    []debugSession{},
+		EdgeRouters: []debugEdgeRouter{},
+		Listeners:   []debugListener{},
+		Connections: context.Connections(),
+		Events:      []debugRecentEvent{},
+	}
+
+	if result.Connections == nil {
+		result.Connections = []edge.ConnStats{}
+	}
+
+	if context.CtrlClt != nil {
+		if apiSession := context.CtrlClt.GetCurrentApiSession(); apiSession != nil {
+			context.apiSessionLock.Lock()
+			lastRefresh := context.lastSuccessfulApiSessionRefresh
+			context.apiSessionLock.Unlock()
+
+			result.ApiSession = &debugApiSession{
+				Id:           apiSession.GetId(),
+				IdentityId:   apiSession.GetIdentityId(),
+				IdentityName: apiSession.GetIdentityName(),
+				ExpiresAt:    apiSession.GetExpiresAt(),
+				AuthQueries:  len(apiSession.GetAuthQueries()),
+				LastRefresh:  lastRefresh,
+			}
+		}
+	}
+
+	for entry := range context.services.IterBuffered() {
+		service := debugService{
+			Id:         stringz.OrEmpty(entry.Val.ID),
+			Name:       stringz.OrEmpty(entry.Val.Name),
+			Encryption: entry.Val.EncryptionRequired != nil && *entry.Val.EncryptionRequired,
+		}
+		for _, permission := range entry.Val.Permissions {
+			service.Permissions = append(service.Permissions, string(permission))
+		}
+		result.Services = append(result.Services, service)
+	}
+	sort.Slice(result.Services, func(i, j int) bool {
+		return result.Services[i].Name < result.Services[j].Name
+	})
+
+	for entry := range context.sessions.IterBuffered() {
+		session := debugSession{
+			Id:          stringz.OrEmpty(entry.Val.ID),
+			ServiceId:   stringz.OrEmpty(entry.Val.ServiceID),
+			EdgeRouters: []string{},
+		}
+		if entry.Val.Type != nil {
+			session.Type = string(*entry.Val.Type)
+		}
+		for _, router := range entry.Val.EdgeRouters {
+			session.EdgeRouters = append(session.EdgeRouters, stringz.OrEmpty(router.Name))
+		}
+		result.Sessions = append(result.Sessions, session)
+	}
+	sort.Slice(result.Sessions, func(i, j int) bool {
+		if result.Sessions[i].ServiceId != result.Sessions[j].ServiceId {
+			return result.Sessions[i].ServiceId < result.Sessions[j].ServiceId
+		}
+		return result.Sessions[i].Type < result.Sessions[j].Type
+	})
+
+	for entry := range context.routerConnections.IterBuffered() {
+		result.EdgeRouters = append(result.EdgeRouters, debugEdgeRouter{
+			Name:        entry.Val.GetRouterName(),
+			Address:     entry.Key,
+			Closed:      entry.Val.IsClosed(),
+			Healthy:     context.isRouterConnHealthy(entry.Val),
+			Latency:     context.getRouterConnLatency(entry.Key),
+			Connections: entry.Val.GetActiveConnCount(),
+		})
+	}
+	sort.Slice(result.EdgeRouters, func(i, j int) bool {
+		return result.EdgeRouters[i].Address < result.EdgeRouters[j].Address
+	})
+
+	for entry := range context.listenerManagers.IterBuffered() {
+		result.Listeners = append(result.Listeners, debugListener{
+			Id:      entry.Key,
+			Service: stringz.OrEmpty(entry.Val.service.Name),
+			Closed:  entry.Val.listener.IsClosed(),
+		})
+	}
+	sort.Slice(result.Listeners, func(i, j int) bool {
+		return result.Listeners[i].Id < result.Listeners[j].Id
+	})
+
+	for _, event := range context.RecentEvents() {
+		debugEvent := debugRecentEvent{
+			Time:   event.Time,
+			Name:   string(event.Name),
+			Detail: event.Detail,
+		}
+		if event.Err != nil {
+			debugEvent.Err = event.Err.Error()
+		}
+		result.Events = append(result.Events, debugEvent)
+	}
+
+	return result
+}
+
+// DebugHandler returns an http.Handler that serves the DebugDump of ztx. The dump includes identity and service
+// names, so it should only be served to operators.
+func DebugHandler(ztx Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = ztx.DebugDump(w)
+	})
+}
+
+// PublishDebugExpvar publishes the DebugDump of ztx as the expvar with the given name, so that it is served by the
+// expvar handler. As with expvar.Publish, it panics if the name is already in use.
+func PublishDebugExpvar(name string, ztx Context) {
+	expvar.Publish(name, expvar.Func(func() any {
+		buf := &bytes.Buffer{}
+		if err := ztx.DebugDump(buf); err != nil {
+			return err.Error()
+		}
+		return json.RawMessage(buf.Bytes())
+	}))
+}
@@ -0,0 +1,69 @@
+package ziti
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_contextImpl_DebugDump(t *testing.T) {
+	req := require.New(t)
+
+	sessionType := rest_model.DialBind("Dial")
+	ctx := &ContextImpl{
+		Id:                "ctx1",
+		routerConnections: cmap.New[edge.RouterConn](),
+		routerLatencies:   cmap.New[*routerLatency](),
+		unhealthyRouters:  cmap.New[struct{}](),
+		services:          cmap.New[*rest_model.ServiceDetail](),
+		sessions:          cmap.New[*rest_model.SessionDetail](),
+		listenerManagers:  cmap.New[*listenerManager](),
+		recentEvents:      newRecentEventRing(4),
+	}
+	ctx.services.Set("svc", newTestService("svc"))
+	ctx.sessions.Set("svc:Dial", &rest_model.SessionDetail{
+		BaseEntity: rest_model.BaseEntity{ID: ToPtr("session1")},
+		ServiceID:  ToPtr("svc"),
+		Token:      ToPtr("secret-token"),
+		Type:       &sessionType,
+		EdgeRouters: []*rest_model.SessionEdgeRouter{{
+			CommonEdgeRouterProperties: rest_model.CommonEdgeRouterProperties{Name: ToPtr("er1")},
+		}},
+	})
+	ctx.routerConnections.Set("tls:er1:3022", &activeTestRouterConn{testRouterConn: testRouterConn{name: "er1", key: "tls:er1:3022"}, active: 2})
+	ctx.recordRouterLatency("er1", "tls:er1:3022", 5*time.Millisecond)
+	ctx.recentEvents.add(newRecentEvent(EventRouterConnected, "er1", "tls:er1:3022"))
+
+	buf := &bytes.Buffer{}
+	req.NoError(ctx.DebugDump(buf))
+	req.NotContains(buf.String(), "secret-token")
+
+	state := &debugState{}
+	req.NoError(json.Unmarshal(buf.Bytes(), state))
+	req.Equal("ctx1", state.ContextId)
+	req.Nil(state.ApiSession)
+	req.Len(state.Services, 1)
+	req.Equal("svc", state.Services[0].Name)
+	req.Equal([]debugSession{{Id: "session1", ServiceId: "svc", Type: "Dial", EdgeRouters: []string{"er1"}}}, state.Sessions)
+	req.Equal([]debugEdgeRouter{{
+		Name:        "er1",
+		Address:     "tls:er1:3022",
+		Healthy:     true,
+		Latency:     5 * time.Millisecond,
+		Connections: 2,
+	}}, state.EdgeRouters)
+	req.Empty(state.Listeners)
+	req.Len(state.Events, 1)
+
+	recorder := httptest.NewRecorder()
+	DebugHandler(ctx).ServeHTTP(recorder, httptest.NewRequest("GET", "/debug", nil))
+	req.Equal("application/json", recorder.Header().Get("Content-Type"))
+	req.JSONEq(buf.String(), recorder.Body.String())
+}
@@ -0,0 +1,101 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/openziti/edge-api/rest_util"
+)
+
+// ServiceNotFoundError is returned when dialing or querying a service the current identity has no access to, or which
+// does not exist.
+type ServiceNotFoundError struct {
+	ServiceName string
+}
+
+func (self *ServiceNotFoundError) Error() string {
+	return fmt.Sprintf("service '%s' not found", self.ServiceName)
+}
+
+// nonRetryableApiErrorCodes are the controller error codes that another dial attempt will not get past.
+var nonRetryableApiErrorCodes = map[string]struct{}{
+	"UNAUTHORIZED":       {},
+	"NOT_FOUND":          {},
+	"INVALID_AUTH":       {},
+	"COULD_NOT_VALIDATE": {},
+}
+
+// IsRetryableDialError returns false for dial failures that are not transient, such as the service not being found,
+// the controller denying access to it, or the dial being cancelled. Anything else, such as a failure to create a
+// session or to reach an edge router or the hosting application, is considered transient.
+func IsRetryableDialError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, gocontext.Canceled) || errors.Is(err, gocontext.DeadlineExceeded) || errors.Is(err, ErrReadOnly) {
+		return false
+	}
+
+	var notFoundErr *ServiceNotFoundError
+	if errors.As(err, &notFoundErr) {
+		return false
+	}
+
+	var apiErr *rest_util.APIFormattedError
+	if errors.As(err, &apiErr) && apiErr.APIError != nil {
+		if _, found := nonRetryableApiErrorCodes[apiErr.Code]; found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (self *DialRetryPolicy) shouldRetry(attempt int, err error) bool {
+	if self == nil || attempt >= self.MaxAttempts {
+		return false
+	}
+	if self.Retryable != nil {
+		return self.Retryable(err)
+	}
+	return IsRetryableDialError(err)
+}
+
+// delay returns how long to wait after the given attempt failed before making the next one.
+func (self *DialRetryPolicy) delay(attempt int) time.Duration {
+	delay := float64(self.Interval)
+	if self.Multiplier > 1 {
+		delay *= math.Pow(self.Multiplier, float64(attempt-1))
+	}
+	if self.MaxInterval > 0 && delay > float64(self.MaxInterval) {
+		delay = float64(self.MaxInterval)
+	}
+	if self.Jitter > 0 {
+		delay += delay * self.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
@@ -17,6 +17,17 @@ type ContextDialer interface {
 	DialContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
+// ConnDialer opens a new connection to a fixed destination, such as a service. Helpers that make and remake their own
+// connections, such as those of the reliable, tunnel and bench packages, call it for every connection they need.
+type ConnDialer func(ctx context.Context) (net.Conn, error)
+
+// ServiceDialer returns a ConnDialer that dials the named service with ztx.
+func ServiceDialer(ztx Context, serviceName string) ConnDialer {
+	return func(ctx context.Context) (net.Conn, error) {
+		return ztx.DialWithContext(ctx, serviceName)
+	}
+}
+
 type dialer struct {
 	fallback   Dialer
 	context    context.Context
@@ -21,8 +21,10 @@ package edge
 import (
 	"github.com/openziti/transport/v2"
 	"github.com/openziti/transport/v2/tls"
+	"github.com/openziti/transport/v2/wss"
 )
 
 func AddAddressParsers() {
 	transport.AddAddressParser(tls.AddressParser{})
+	transport.AddAddressParser(wss.AddressParser{})
 }
@@ -0,0 +1,44 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import "sync"
+
+// PooledBufferSize is the size of the buffers returned by GetBuffer. Writes of up to this size are copied into pooled
+// buffers rather than newly allocated ones.
+const PooledBufferSize = 32 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new([PooledBufferSize]byte)
+	},
+}
+
+// GetBuffer returns a buffer of PooledBufferSize bytes from the shared pool. It should be returned with PutBuffer once
+// it is no longer referenced.
+func GetBuffer() []byte {
+	return bufferPool.Get().(*[PooledBufferSize]byte)[:]
+}
+
+// PutBuffer returns a buffer obtained with GetBuffer, or a slice of it starting at its first byte, to the pool. Other
+// buffers are ignored. The buffer must not be used afterwards.
+func PutBuffer(buf []byte) {
+	if cap(buf) != PooledBufferSize {
+		return
+	}
+	bufferPool.Put((*[PooledBufferSize]byte)(buf[:PooledBufferSize]))
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/michaelquigley/pfxlog"
+)
+
+// AlreadyClosedError is returned by Close on connections and listeners that were already closed by an earlier call to
+// Close. The second call has no other effect. It matches both ErrAlreadyClosed and net.ErrClosed with errors.Is.
+//
+// Earlier releases returned nil from repeated Close calls. Callers that close explicitly as well as with a deferred
+// Close, and check the error of both, should ignore it with errors.Is(err, ErrAlreadyClosed).
+type AlreadyClosedError struct {
+	// FirstClose is the stack trace of the first call to Close, if close tracking was enabled at the time. See
+	// SetCloseTracking.
+	FirstClose string
+}
+
+func (self *AlreadyClosedError) Error() string {
+	return "already closed"
+}
+
+func (self *AlreadyClosedError) Is(target error) bool {
+	if target == net.ErrClosed {
+		return true
+	}
+	_, ok := target.(*AlreadyClosedError)
+	return ok
+}
+
+// ErrAlreadyClosed can be used with errors.Is to check for an AlreadyClosedError.
+var ErrAlreadyClosed error = &AlreadyClosedError{}
+
+var closeTracking atomic.Bool
+
+// SetCloseTracking enables or disables capturing the stack of the first Close of every connection and listener, so that
+// later Close calls log a warning with both stacks and return them in AlreadyClosedError.FirstClose. Capturing stacks
+// is expensive, so this is meant for debugging wrappers that close more than once.
+func SetCloseTracking(enabled bool) {
+	closeTracking.Store(enabled)
+}
+
+// CloseGuard makes Close idempotent for the type embedding it. The zero value is open.
+type CloseGuard struct {
+	closed     atomic.Bool
+	firstClose atomic.Pointer[string]
+}
+
+// Close marks the guard closed. It returns nil the first time it is called and an AlreadyClosedError after that, in
+// which case the caller must not close again. The description identifies the closed object in double close warnings.
+func (self *CloseGuard) Close(description string) error {
+	if self.closed.CompareAndSwap(false, true) {
+		if closeTracking.Load() {
+			stack := string(debug.Stack())
+			self.firstClose.Store(&stack)
+		}
+		return nil
+	}
+
+	err := &AlreadyClosedError{}
+	if firstClose := self.firstClose.Load(); firstClose != nil {
+		err.FirstClose = *firstClose
+	}
+
+	if closeTracking.Load() {
+		pfxlog.Logger().
+			WithField("firstClose", err.FirstClose).
+			WithField("stack", string(debug.Stack())).
+			Warnf("%s closed more than once", description)
+	}
+
+	return err
+}
+
+// IsClosed returns true once Close has been called.
+func (self *CloseGuard) IsClosed() bool {
+	return self.closed.Load()
+}
@@ -0,0 +1,40 @@
+package edge
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseGuard(t *testing.T) {
+	req := require.New(t)
+
+	guard := &CloseGuard{}
+	req.False(guard.IsClosed())
+	req.NoError(guard.Close("test"))
+	req.True(guard.IsClosed())
+
+	err := guard.Close("test")
+	req.ErrorIs(err, ErrAlreadyClosed)
+	req.ErrorIs(err, net.ErrClosed)
+
+	closedErr := &AlreadyClosedError{}
+	req.True(errors.As(err, &closedErr))
+	req.Empty(closedErr.FirstClose)
+}
+
+func TestCloseGuard_tracking(t *testing.T) {
+	req := require.New(t)
+
+	SetCloseTracking(true)
+	defer SetCloseTracking(false)
+
+	guard := &CloseGuard{}
+	req.NoError(guard.Close("test"))
+
+	closedErr := &AlreadyClosedError{}
+	req.True(errors.As(guard.Close("test"), &closedErr))
+	req.Contains(closedErr.FirstClose, "TestCloseGuard_tracking")
+}
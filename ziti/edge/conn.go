@@ -17,6 +17,7 @@
 package edge
 
 import (
+	"context"
 	"fmt"
 	"github.com/openziti/edge-api/rest_model"
 	"github.com/openziti/secretstream/kx"
@@ -29,7 +30,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/channel/v2"
+	"github.com/openziti/foundation/v2/concurrenz"
 	"github.com/openziti/foundation/v2/sequence"
+	"github.com/sirupsen/logrus"
 )
 
 func init() {
@@ -53,12 +56,22 @@ type RouterConn interface {
 	Key() string
 	GetRouterName() string
 	GetBoolHeader(key int32) bool
+
+	// GetActiveConnCount returns the number of dial, bind and hosted connections currently multiplexed over the router
+	// connection.
+	GetActiveConnCount() int
+
+	// GetConnStats returns the statistics of the dialed and accepted connections currently multiplexed over the router
+	// connection.
+	GetConnStats() []ConnStats
 }
 
 type Identifiable interface {
 	Id() uint32
 }
 
+// Listener accepts the connections dialed to a hosted service. Close is idempotent: calls after the first return an
+// AlreadyClosedError.
 type Listener interface {
 	net.Listener
 	Identifiable
@@ -68,6 +81,12 @@ type Listener interface {
 	UpdatePrecedence(precedence Precedence) error
 	UpdateCostAndPrecedence(cost uint16, precedence Precedence) error
 	SendHealthEvent(pass bool) error
+
+	// Drain takes the listener out of service without dropping connections: its terminators are marked failed so
+	// that dials are routed to other terminators of the service, new connections are no longer accepted, and once the
+	// connections accepted earlier are all closed, the listener is closed. If ctx is done first, the remaining
+	// connections are closed along with the listener and the ctx error is returned.
+	Drain(ctx context.Context) error
 }
 
 type SessionListener interface {
@@ -82,17 +101,54 @@ type CloseWriter interface {
 	CloseWrite() error
 }
 
+// HalfCloser is implemented by connections that can shut down each direction independently, as *net.TCPConn can.
+// All connections returned by the SDK implement it, so wrappers holding a net.Conn can type-assert to it.
+type HalfCloser interface {
+	CloseWriter
+
+	// CloseRead shuts down the reading side of the connection. Nothing is sent to the peer.
+	CloseRead() error
+}
+
 type ServiceConn interface {
 	net.Conn
-	CloseWriter
+	HalfCloser
 	IsClosed() bool
 	GetAppData() []byte
 	SourceIdentifier() string
 	TraceRoute(hops uint32, timeout time.Duration) (*TraceRouteResult, error)
 	GetCircuitId() string
 	GetStickinessToken() []byte
+
+	// GetServiceId and GetServiceName identify the service the connection was dialed or accepted for.
+	GetServiceId() string
+	GetServiceName() string
+
+	// Stats returns the current statistics of the connection.
+	Stats() ConnStats
+}
+
+// ConnStats is a snapshot of the statistics of a connection. Bytes are payload bytes, as read and written by the
+// application, and messages are the edge messages carrying them.
+type ConnStats struct {
+	ConnId         uint32
+	ServiceName    string
+	CircuitId      string
+	SourceIdentity string
+	StartTime      time.Time
+	BytesIn        uint64
+	BytesOut       uint64
+	MsgsIn         uint64
+	MsgsOut        uint64
+
+	// RTT estimates the round trip time as the smoothed latency of the router connection carrying the connection, or
+	// zero if it hasn't been measured.
+	RTT time.Duration
+
+	Closed bool
 }
 
+// Conn is a connection to or from a service. Close is idempotent: calls after the first return an AlreadyClosedError.
 type Conn interface {
 	ServiceConn
 	Identifiable
@@ -106,8 +162,9 @@ type MsgChannel struct {
 	channel.Channel
 	id            uint32
 	msgIdSeq      *sequence.Sequence
-	writeDeadline time.Time
+	writeDeadline concurrenz.AtomicValue[time.Time]
 	trace         bool
+	logger        *logrus.Logger
 }
 
 type TraceRouteResult struct {
@@ -132,6 +189,19 @@ func NewEdgeMsgChannel(ch channel.Channel, connId uint32) *MsgChannel {
 	}
 }
 
+// SetLogger sets the logger that writes and traced messages are logged to. If it isn't set, or is nil, they are logged
+// to pfxlog.
+func (ec *MsgChannel) SetLogger(logger *logrus.Logger) {
+	ec.logger = logger
+}
+
+func (ec *MsgChannel) log() *pfxlog.Builder {
+	if ec.logger == nil {
+		return pfxlog.Logger()
+	}
+	return &pfxlog.Builder{Entry: logrus.NewEntry(ec.logger)}
+}
+
 func (ec *MsgChannel) Id() uint32 {
 	return ec.id
 }
@@ -141,7 +211,7 @@ func (ec *MsgChannel) NextMsgId() uint32 {
 }
 
 func (ec *MsgChannel) SetWriteDeadline(t time.Time) error {
-	ec.writeDeadline = t
+	ec.writeDeadline.Store(t)
 	return nil
 }
 
@@ -150,10 +220,31 @@ func (ec *MsgChannel) Write(data []byte) (n int, err error) {
 }
 
 func (ec *MsgChannel) WriteTraced(data []byte, msgUUID []byte, hdrs map[int32][]byte) (int, error) {
-	copyBuf := make([]byte, len(data))
+	var copyBuf []byte
+	if len(data) <= PooledBufferSize {
+		copyBuf = GetBuffer()[:len(data)]
+	} else {
+		copyBuf = make([]byte, len(data))
+	}
 	copy(copyBuf, data)
 
-	msg := NewDataMsg(ec.id, ec.msgIdSeq.Next(), copyBuf)
+	n, err := ec.writeMsg(copyBuf, msgUUID, hdrs)
+	if err == nil {
+		// the message is on the wire, so the buffer is no longer referenced. If the write failed, it may still be
+		// queued, so the buffer is left to the garbage collector.
+		PutBuffer(copyBuf)
+	}
+	return n, err
+}
+
+// WriteBuffer writes data without copying it. The caller must not modify data until WriteBuffer returns, nor at all if
+// it returns an error, since data may then still be queued for sending.
+func (ec *MsgChannel) WriteBuffer(data []byte) (int, error) {
+	return ec.writeMsg(data, nil, nil)
+}
+
+func (ec *MsgChannel) writeMsg(data []byte, msgUUID []byte, hdrs map[int32][]byte) (int, error) {
+	msg := NewDataMsg(ec.id, ec.msgIdSeq.Next(), data)
 	if msgUUID != nil {
 		msg.Headers[UUIDHeader] = msgUUID
 	}
@@ -162,16 +253,22 @@ func (ec *MsgChannel) WriteTraced(data []byte, msgUUID []byte, hdrs map[int32][]
 		msg.Headers[k] = v
 	}
 	ec.TraceMsg("write", msg)
-	pfxlog.Logger().WithFields(GetLoggerFields(msg)).Debugf("writing %v bytes", len(copyBuf))
+	if log := ec.log(); log.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		// only build the log fields if they are logged, as this is on the data path
+		log.WithFields(GetLoggerFields(msg)).Debugf("writing %v bytes", len(data))
+	}
 
 	// NOTE: We need to wait for the buffer to be on the wire before returning. The Writer contract
 	//       states that buffers are not allowed be retained, and if we have it queued asynchronously
 	//       it is retained, and we can cause data corruption
 	var err error
-	if ec.writeDeadline.IsZero() {
+	if deadline := ec.writeDeadline.Load(); deadline.IsZero() {
 		err = msg.WithTimeout(forever).SendAndWaitForWire(ec.Channel)
-	} else {
-		err = msg.WithTimeout(time.Until(ec.writeDeadline)).SendAndWaitForWire(ec.Channel)
+	} else if timeout := time.Until(deadline); timeout <= 0 {
+		return 0, os.ErrDeadlineExceeded
+	} else if err = msg.WithTimeout(timeout).SendAndWaitForWire(ec.Channel); channel.IsTimeout(err) {
+		// report timeouts as net.Conn implementations do, so callers can check for them with errors.Is or net.Error
+		return 0, os.ErrDeadlineExceeded
 	}
 
 	if err != nil {
@@ -195,12 +292,12 @@ func (ec *MsgChannel) TraceMsg(source string, msg *channel.Message) {
 			msgUUID = newUUID[:]
 			msg.Headers[UUIDHeader] = msgUUID
 		} else {
-			pfxlog.Logger().WithField("connId", ec.id).WithError(err).Infof("failed to create trace uuid")
+			ec.log().WithField("connId", ec.id).WithError(err).Infof("failed to create trace uuid")
 		}
 	}
 
 	if msgUUID != nil {
-		pfxlog.Logger().WithFields(GetLoggerFields(msg)).WithField("source", source).Debug("tracing message")
+		ec.log().WithFields(GetLoggerFields(msg)).WithField("source", source).Debug("tracing message")
 	}
 }
 
@@ -237,6 +334,16 @@ type ListenOptions struct {
 	ManualStart           bool
 	ListenerId            string
 	KeyPair               *kx.KeyPair
+	MaxBindAttempts       int
+	BindRetryInterval     time.Duration
+	RebindInterval        time.Duration
+	MaxRebindInterval     time.Duration
+	IdleTimeout           time.Duration
+	BeforeIdleClose       func(conn Conn, idle time.Duration) bool
+	OnIdleClose           func(conn Conn, idle time.Duration)
+	AcceptQueueSize       int
+	AcceptQueueFullPolicy AcceptQueueFullPolicy
+	OnAcceptShed          func()
 	eventC                chan *ListenerEvent
 }
 
@@ -252,6 +359,34 @@ func (options *ListenOptions) String() string {
 	return fmt.Sprintf("[ListenOptions cost=%v, max-connections=%v]", options.Cost, options.MaxTerminators)
 }
 
+// DefaultAcceptQueueSize is the number of accepted connections each edge router listener queues for the application.
+const DefaultAcceptQueueSize = 10
+
+// AcceptQueueFullPolicy determines what happens to new connections of a listener whose accept queue is full.
+type AcceptQueueFullPolicy int
+
+const (
+	// AcceptQueueBlock waits for the application to accept a queued connection. Dials arriving through the same edge
+	// router are delayed until then as well. This is the default.
+	AcceptQueueBlock AcceptQueueFullPolicy = iota
+
+	// AcceptQueueReject fails new dials while the queue is full, so that they can be retried elsewhere.
+	AcceptQueueReject
+
+	// AcceptQueueDropOldest closes the connection that has been queued the longest to make room for the new one.
+	AcceptQueueDropOldest
+)
+
+func (p AcceptQueueFullPolicy) String() string {
+	switch p {
+	case AcceptQueueReject:
+		return "reject"
+	case AcceptQueueDropOldest:
+		return "drop-oldest"
+	}
+	return "block"
+}
+
 type ListenerEventType int
 
 const (
@@ -0,0 +1,138 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxDatagramSize is the largest payload of a UDP datagram over IPv4.
+	DefaultMaxDatagramSize = 65507
+
+	datagramHeaderSize = 4
+)
+
+// ErrDatagramTooLarge is returned when writing a datagram larger than the maximum datagram size of a DatagramConn.
+var ErrDatagramTooLarge = errors.New("datagram exceeds maximum datagram size")
+
+// DatagramConn carries datagrams over a Conn, giving it net.PacketConn semantics. Each datagram is framed with its
+// length, so datagrams split or coalesced by the underlying stream are reassembled whole on the reading side, and each
+// read returns exactly one datagram. As with UDP, a datagram larger than the read buffer is truncated.
+//
+// Both sides of the connection must use a DatagramConn. Datagrams received that are larger than the maximum datagram
+// size are discarded.
+type DatagramConn struct {
+	Conn
+	maxDatagramSize int
+
+	readLock  sync.Mutex
+	header    [datagramHeaderSize]byte
+	writeLock sync.Mutex
+}
+
+var _ net.PacketConn = (*DatagramConn)(nil)
+
+// NewDatagramConn wraps conn for sending datagrams of up to maxDatagramSize bytes. If maxDatagramSize is not positive,
+// DefaultMaxDatagramSize is used.
+func NewDatagramConn(conn Conn, maxDatagramSize int) *DatagramConn {
+	if maxDatagramSize <= 0 {
+		maxDatagramSize = DefaultMaxDatagramSize
+	}
+	return &DatagramConn{
+		Conn:            conn,
+		maxDatagramSize: maxDatagramSize,
+	}
+}
+
+// MaxDatagramSize returns the size of the largest datagram that may be sent or received.
+func (conn *DatagramConn) MaxDatagramSize() int {
+	return conn.maxDatagramSize
+}
+
+// Read reads the next datagram into p, returning the number of bytes copied. If the datagram is larger than p, the
+// rest of it is discarded.
+func (conn *DatagramConn) Read(p []byte) (int, error) {
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+
+	for {
+		if _, err := io.ReadFull(conn.Conn, conn.header[:]); err != nil {
+			return 0, err
+		}
+
+		size := int(binary.BigEndian.Uint32(conn.header[:]))
+		if size > conn.maxDatagramSize {
+			pfxlog.Logger().Debugf("discarding datagram of %d bytes, larger than max datagram size %d", size, conn.maxDatagramSize)
+			if _, err := io.CopyN(io.Discard, conn.Conn, int64(size)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := io.ReadFull(conn.Conn, p[:n]); err != nil {
+			return 0, err
+		}
+		if n < size {
+			if _, err := io.CopyN(io.Discard, conn.Conn, int64(size-n)); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	}
+}
+
+// Write sends p as a single datagram.
+func (conn *DatagramConn) Write(p []byte) (int, error) {
+	if len(p) > conn.maxDatagramSize {
+		return 0, fmt.Errorf("%w (%d > %d)", ErrDatagramTooLarge, len(p), conn.maxDatagramSize)
+	}
+
+	frame := make([]byte, datagramHeaderSize+len(p))
+	binary.BigEndian.PutUint32(frame, uint32(len(p)))
+	copy(frame[datagramHeaderSize:], p)
+
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
+	if _, err := conn.Conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads the next datagram. The returned address is always the remote address of the connection.
+func (conn *DatagramConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := conn.Read(p)
+	return n, conn.RemoteAddr(), err
+}
+
+// WriteTo sends p as a single datagram to the peer. The address is ignored, as the connection only has one peer.
+func (conn *DatagramConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return conn.Write(p)
+}
@@ -0,0 +1,82 @@
+package edge
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newDatagramPipe(t *testing.T, dialMax, hostMax int) (*DatagramConn, *DatagramConn) {
+	left, right := net.Pipe()
+	t.Cleanup(func() {
+		_ = left.Close()
+		_ = right.Close()
+	})
+
+	return NewDatagramConn(&pipeConn{pipe: left}, dialMax), NewDatagramConn(&pipeConn{pipe: right}, hostMax)
+}
+
+func TestDatagramConn_PreservesBoundaries(t *testing.T) {
+	req := require.New(t)
+	dialer, host := newDatagramPipe(t, 0, 0)
+
+	datagrams := [][]byte{
+		[]byte("first"),
+		{},
+		bytes.Repeat([]byte("x"), DefaultMaxDatagramSize),
+		[]byte("last"),
+	}
+
+	go func() {
+		for _, datagram := range datagrams {
+			_, _ = dialer.Write(datagram)
+		}
+	}()
+
+	buf := make([]byte, DefaultMaxDatagramSize)
+	for _, expected := range datagrams {
+		n, err := host.Read(buf)
+		req.NoError(err)
+		req.Equal(expected, buf[:n])
+	}
+}
+
+func TestDatagramConn_Truncates(t *testing.T) {
+	req := require.New(t)
+	dialer, host := newDatagramPipe(t, 0, 0)
+
+	go func() {
+		_, _ = dialer.Write([]byte("0123456789"))
+		_, _ = dialer.Write([]byte("next"))
+	}()
+
+	buf := make([]byte, 4)
+	n, _, err := host.ReadFrom(buf)
+	req.NoError(err)
+	req.Equal("0123", string(buf[:n]))
+
+	n, _, err = host.ReadFrom(buf)
+	req.NoError(err)
+	req.Equal("next", string(buf[:n]))
+}
+
+func TestDatagramConn_MaxDatagramSize(t *testing.T) {
+	req := require.New(t)
+	dialer, host := newDatagramPipe(t, 100, 10)
+
+	_, err := host.Write(make([]byte, 11))
+	req.True(errors.Is(err, ErrDatagramTooLarge))
+
+	go func() {
+		_, _ = dialer.Write(make([]byte, 50))
+		_, _ = dialer.Write([]byte("fits"))
+	}()
+
+	buf := make([]byte, 100)
+	n, err := host.Read(buf)
+	req.NoError(err)
+	req.Equal("fits", string(buf[:n]))
+}
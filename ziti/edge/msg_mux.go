@@ -41,6 +41,8 @@ type MsgMux interface {
 	RemoveMsgSinkById(sinkId uint32)
 	Close()
 	GetNextId() uint32
+	GetSinkCount() int
+	GetSinks() []MsgSink
 }
 
 func NewCowMapMsgMux() MsgMux {
@@ -170,6 +172,19 @@ func (mux *CowMapMsgMux) Close() {
 	}
 }
 
+func (mux *CowMapMsgMux) GetSinkCount() int {
+	return len(mux.getSinks())
+}
+
+func (mux *CowMapMsgMux) GetSinks() []MsgSink {
+	sinks := mux.getSinks()
+	result := make([]MsgSink, 0, len(sinks))
+	for _, sink := range sinks {
+		result = append(result, sink)
+	}
+	return result
+}
+
 func (mux *CowMapMsgMux) getSinks() map[uint32]MsgSink {
 	return mux.sinks.Load().(map[uint32]MsgSink)
 }
@@ -48,6 +48,7 @@ const (
 )
 
 var _ edge.Conn = &edgeConn{}
+var _ edge.HalfCloser = &edgeConn{}
 
 type edgeConn struct {
 	edge.MsgChannel
@@ -56,15 +57,29 @@ type edgeConn struct {
 	msgMux                edge.MsgMux
 	hosting               cmap.ConcurrentMap[string, *edgeListener]
 	closed                atomic.Bool
+	closeGuard            edge.CloseGuard
 	readFIN               atomic.Bool
 	sentFIN               atomic.Bool
+	readClosed            atomic.Bool
 	serviceName           string
+	serviceId             string
 	sourceIdentity        string
 	acceptCompleteHandler *newConnHandler
 	connType              ConnType
 	marker                string
 	circuitId             string
 	customState           map[int32][]byte
+	traffic               *edge.TrafficCounter
+	connClosed            ConnClosedOwner
+	connOpened            ConnOpenedOwner
+	latency               func() time.Duration
+	startTime             time.Time
+	msgsIn                atomic.Uint64
+	msgsOut               atomic.Uint64
+	idle                  *idleTimer
+	idleTimeout           *IdleTimeoutConfig
+	multiplex             *MultiplexConfig
+	logger                *logrus.Logger
 
 	crypto   bool
 	keyPair  *kx.KeyPair
@@ -74,28 +89,57 @@ type edgeConn struct {
 	appData  []byte
 }
 
+func (conn *edgeConn) log() *pfxlog.Builder {
+	return newLogger(conn.logger)
+}
+
 func (conn *edgeConn) Write(data []byte) (int, error) {
+	return conn.write(data, false)
+}
+
+// write sends data. If owned is set, data may be sent without copying it, as the caller doesn't reuse it until write
+// returns, nor at all if write fails.
+func (conn *edgeConn) write(data []byte, owned bool) (int, error) {
 	if conn.sentFIN.Load() {
 		return 0, errors.New("calling Write() after CloseWrite()")
 	}
 
+	conn.idle.touch()
+
 	if conn.sender != nil {
 		cipherData, err := conn.sender.Push(data, secretstream.TagMessage)
 		if err != nil {
 			return 0, err
 		}
 
-		_, err = conn.MsgChannel.Write(cipherData)
+		// the cipher text is a new buffer, so it can be sent without copying
+		if _, err = conn.MsgChannel.WriteBuffer(cipherData); err == nil {
+			conn.traffic.AddTx(len(data))
+			conn.msgsOut.Add(1)
+		}
 		return len(data), err
+	}
+
+	var n int
+	var err error
+	if owned {
+		n, err = conn.MsgChannel.WriteBuffer(data)
 	} else {
-		return conn.MsgChannel.Write(data)
+		n, err = conn.MsgChannel.Write(data)
 	}
+	conn.traffic.AddTx(n)
+	if err == nil {
+		conn.msgsOut.Add(1)
+	}
+	return n, err
 }
 
 var finHeaders = map[int32][]byte{
 	edge.FlagsHeader: {edge.FIN, 0, 0, 0},
 }
 
+// CloseWrite sends a FIN to the peer, after which the peer reads io.EOF once it has read all data written so far.
+// Reading is unaffected.
 func (conn *edgeConn) CloseWrite() error {
 	if conn.sentFIN.CompareAndSwap(false, true) {
 		_, err := conn.MsgChannel.WriteTraced(nil, nil, finHeaders)
@@ -105,6 +149,32 @@ func (conn *edgeConn) CloseWrite() error {
 	return nil
 }
 
+// CloseRead makes reads return io.EOF, including a read that is currently blocked. Data still queued or received from
+// the peer later is discarded. Writing is unaffected, and a close by the peer still closes the connection.
+func (conn *edgeConn) CloseRead() error {
+	if !conn.readClosed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	for {
+		select {
+		case msg := <-conn.readQ.ch:
+			if msg.ContentType == edge.ContentTypeStateClosed {
+				conn.close(true)
+			}
+		default:
+			// wake up a blocked Read, which sees the FIN and returns io.EOF
+			fin := channel.NewMessage(edge.ContentTypeData, nil)
+			fin.PutUint32Header(edge.FlagsHeader, edge.FIN)
+			select {
+			case conn.readQ.ch <- fin:
+			default:
+			}
+			return nil
+		}
+	}
+}
+
 func (conn *edgeConn) Inspect() string {
 	result := map[string]interface{}{}
 	result["id"] = conn.Id()
@@ -133,7 +203,7 @@ func (conn *edgeConn) Inspect() string {
 
 	jsonOutput, err := json.Marshal(result)
 	if err != nil {
-		pfxlog.Logger().WithError(err).Error("unable to marshal inspect result")
+		conn.log().WithError(err).Error("unable to marshal inspect result")
 	}
 	return string(jsonOutput)
 }
@@ -144,7 +214,7 @@ func (conn *edgeConn) Accept(msg *channel.Message) {
 	if msg.ContentType == edge.ContentTypeConnInspectRequest {
 		resp := edge.NewConnInspectResponse(0, edge.ConnType(conn.connType), conn.Inspect())
 		if err := resp.ReplyTo(msg).Send(conn.Channel); err != nil {
-			logrus.WithFields(edge.GetLoggerFields(msg)).WithError(err).
+			conn.log().WithFields(edge.GetLoggerFields(msg)).WithError(err).
 				Error("failed to send inspect response")
 		}
 		return
@@ -175,22 +245,34 @@ func (conn *edgeConn) Accept(msg *channel.Message) {
 			}
 
 			if err := conn.Send(resp); err != nil {
-				logrus.WithFields(edge.GetLoggerFields(msg)).WithError(err).
+				conn.log().WithFields(edge.GetLoggerFields(msg)).WithError(err).
 					Error("failed to send trace route response")
 			}
 			return
 		}
 
+		if conn.readClosed.Load() {
+			if msg.ContentType == edge.ContentTypeStateClosed {
+				conn.close(true)
+			}
+			return
+		}
+
+		if msg.ContentType == edge.ContentTypeData {
+			conn.idle.touch()
+			conn.msgsIn.Add(1)
+		}
+
 		if err := conn.readQ.PutSequenced(msg); err != nil {
-			logrus.WithFields(edge.GetLoggerFields(msg)).WithError(err).
+			conn.log().WithFields(edge.GetLoggerFields(msg)).WithError(err).
 				Error("error pushing edge message to sequencer")
 		} else {
-			logrus.WithFields(edge.GetLoggerFields(msg)).Debugf("received %v bytes (msg type: %v)", len(msg.Body), msg.ContentType)
+			conn.log().WithFields(edge.GetLoggerFields(msg)).Debugf("received %v bytes (msg type: %v)", len(msg.Body), msg.ContentType)
 		}
 
 	case ConnTypeBind:
 		if msg.ContentType == edge.ContentTypeDial {
-			logrus.WithFields(edge.GetLoggerFields(msg)).Debug("received dial request")
+			conn.log().WithFields(edge.GetLoggerFields(msg)).Debug("received dial request")
 			go conn.newChildConnection(msg)
 		} else if msg.ContentType == edge.ContentTypeStateClosed {
 			conn.close(true)
@@ -203,12 +285,12 @@ func (conn *edgeConn) Accept(msg *channel.Message) {
 				select {
 				case entry.Val.eventC <- event:
 				default:
-					logrus.WithFields(edge.GetLoggerFields(msg)).Warn("unable to send listener established event")
+					conn.log().WithFields(edge.GetLoggerFields(msg)).Warn("unable to send listener established event")
 				}
 			}
 		}
 	default:
-		logrus.WithFields(edge.GetLoggerFields(msg)).Errorf("invalid connection type: %v", conn.connType)
+		conn.log().WithFields(edge.GetLoggerFields(msg)).Errorf("invalid connection type: %v", conn.connType)
 	}
 }
 
@@ -236,6 +318,33 @@ func (conn *edgeConn) SourceIdentifier() string {
 	return conn.sourceIdentity
 }
 
+func (conn *edgeConn) GetServiceId() string {
+	return conn.serviceId
+}
+
+func (conn *edgeConn) GetServiceName() string {
+	return conn.serviceName
+}
+
+func (conn *edgeConn) Stats() edge.ConnStats {
+	result := edge.ConnStats{
+		ConnId:         conn.Id(),
+		ServiceName:    conn.serviceName,
+		CircuitId:      conn.circuitId,
+		SourceIdentity: conn.sourceIdentity,
+		StartTime:      conn.startTime,
+		BytesIn:        conn.traffic.RxBytes(),
+		BytesOut:       conn.traffic.TxBytes(),
+		MsgsIn:         conn.msgsIn.Load(),
+		MsgsOut:        conn.msgsOut.Load(),
+		Closed:         conn.closed.Load(),
+	}
+	if conn.latency != nil {
+		result.RTT = conn.latency()
+	}
+	return result
+}
+
 func (conn *edgeConn) SetDeadline(t time.Time) error {
 	if err := conn.SetReadDeadline(t); err != nil {
 		return err
@@ -262,7 +371,7 @@ func (conn *edgeConn) GetStickinessToken() []byte {
 }
 
 func (conn *edgeConn) HandleClose(channel.Channel) {
-	logger := pfxlog.Logger().WithField("connId", conn.Id()).WithField("marker", conn.marker)
+	logger := conn.log().WithField("connId", conn.Id()).WithField("marker", conn.marker)
 	defer logger.Debug("received HandleClose from underlying channel, marking conn closed")
 	conn.readQ.Close()
 	conn.closed.Store(true)
@@ -271,7 +380,7 @@ func (conn *edgeConn) HandleClose(channel.Channel) {
 }
 
 func (conn *edgeConn) Connect(session *rest_model.SessionDetail, options *edge.DialOptions) (edge.Conn, error) {
-	logger := pfxlog.Logger().
+	logger := conn.log().
 		WithField("marker", conn.marker).
 		WithField("connId", conn.Id()).
 		WithField("sessionId", session.ID)
@@ -325,6 +434,10 @@ func (conn *edgeConn) Connect(session *rest_model.SessionDetail, options *edge.D
 	}
 	logger.Debug("connected")
 
+	if conn.connOpened != nil {
+		conn.connOpened.OnConnOpened(conn, conn.serviceName, false)
+	}
+
 	return conn, nil
 }
 
@@ -351,7 +464,7 @@ func (conn *edgeConn) establishClientCrypto(keypair *kx.KeyPair, peerKey []byte,
 		return errors.Wrap(err, "failed to write crypto header")
 	}
 
-	pfxlog.Logger().
+	conn.log().
 		WithField("connId", conn.Id()).
 		WithField("marker", conn.marker).
 		Debug("crypto established")
@@ -380,7 +493,7 @@ func (conn *edgeConn) establishServerCrypto(keypair *kx.KeyPair, peerKey []byte,
 }
 
 func (conn *edgeConn) listen(session *rest_model.SessionDetail, service *rest_model.ServiceDetail, options *edge.ListenOptions) (*edgeListener, error) {
-	logger := pfxlog.ContextLogger(conn.Channel.Label()).
+	logger := conn.log().WithField("_context", conn.Channel.Label()).
 		WithField("connId", conn.Id()).
 		WithField("serviceName", *service.Name).
 		WithField("sessionId", *session.ID)
@@ -388,13 +501,15 @@ func (conn *edgeConn) listen(session *rest_model.SessionDetail, service *rest_mo
 	listener := &edgeListener{
 		baseListener: baseListener{
 			service: service,
-			acceptC: make(chan edge.Conn, 10),
+			acceptC: make(chan edge.Conn, acceptQueueSize(options)),
 			errorC:  make(chan error, 1),
 		},
 		token:       *session.Token,
 		edgeChan:    conn,
 		manualStart: options.ManualStart,
 		eventC:      options.GetEventChannel(),
+		queuePolicy: options.AcceptQueueFullPolicy,
+		onShed:      options.OnAcceptShed,
 	}
 	logger.Debug("adding listener for session")
 	conn.hosting.Set(*session.Token, listener)
@@ -451,32 +566,101 @@ func (conn *edgeConn) unbind(logger *logrus.Entry, token string) {
 }
 
 func (conn *edgeConn) Read(p []byte) (int, error) {
-	log := pfxlog.Logger().WithField("connId", conn.Id()).WithField("marker", conn.marker)
-	if conn.closed.Load() {
-		return 0, io.EOF
+	d, err := conn.readNext()
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, d)
+	conn.leftover = d[n:]
+
+	if conn.log().Logger.IsLevelEnabled(logrus.DebugLevel) {
+		conn.readLogger().Debugf("reading %v bytes into buffer of %d bytes, saving %d bytes for leftover", n, len(p), len(conn.leftover))
+	}
+	conn.traffic.AddRx(n)
+	return n, nil
+}
+
+// readLogger returns the logger for the read path. It is only built where needed, as reads are on the data path.
+func (conn *edgeConn) readLogger() *logrus.Entry {
+	return conn.log().WithField("connId", conn.Id()).WithField("marker", conn.marker)
+}
+
+// WriteTo implements io.WriterTo, writing received data to w straight from the received messages, without copying it
+// through an intermediate buffer. It returns once the peer has closed the connection or sent a FIN, or on the first
+// error.
+func (conn *edgeConn) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		d, err := conn.readNext()
+		if err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+
+		n, err := w.Write(d)
+		conn.leftover = d[n:]
+		conn.traffic.AddRx(n)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, reading data from r into a pooled buffer and sending it from there, so that no
+// buffers are allocated or copied per write. It returns once r returns io.EOF, or on the first error. The connection is
+// not closed for writing.
+func (conn *edgeConn) ReadFrom(r io.Reader) (int64, error) {
+	buf := edge.GetBuffer()
+
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, writeErr := conn.write(buf[:n], true); writeErr != nil {
+				// the buffer may still be queued for sending, so it is not returned to the pool
+				return total, writeErr
+			}
+			total += int64(n)
+		}
+
+		if err != nil {
+			edge.PutBuffer(buf)
+			if err == io.EOF {
+				return total, nil
+			}
+			return total, err
+		}
+	}
+}
+
+// readNext returns the leftover data of the last message read, or the data of the next message data is received in.
+func (conn *edgeConn) readNext() ([]byte, error) {
+	if conn.closed.Load() || conn.readClosed.Load() {
+		return nil, io.EOF
 	}
 
-	log.Tracef("read buffer = %d bytes", len(p))
 	if len(conn.leftover) > 0 {
-		log.Tracef("found %d leftover bytes", len(conn.leftover))
-		n := copy(p, conn.leftover)
-		conn.leftover = conn.leftover[n:]
-		return n, nil
+		d := conn.leftover
+		conn.leftover = nil
+		return d, nil
 	}
 
 	for {
 		if conn.readFIN.Load() {
-			return 0, io.EOF
+			return nil, io.EOF
 		}
 
 		msg, err := conn.readQ.GetNext()
 		if err == ErrClosed {
-			log.Debug("sequencer closed, closing connection")
+			conn.readLogger().Debug("sequencer closed, closing connection")
 			conn.closed.Store(true)
-			return 0, io.EOF
+			return nil, io.EOF
 		} else if err != nil {
-			log.Debugf("unexpected sequencer err (%v)", err)
-			return 0, err
+			conn.readLogger().Debugf("unexpected sequencer err (%v)", err)
+			return nil, err
 		}
 
 		flags, _ := msg.GetUint32Header(edge.FlagsHeader)
@@ -487,25 +671,24 @@ func (conn *edgeConn) Read(p []byte) (int, error) {
 		switch msg.ContentType {
 
 		case edge.ContentTypeStateClosed:
-			log.Debug("received ConnState_CLOSED message, closing connection")
+			conn.readLogger().Debug("received ConnState_CLOSED message, closing connection")
 			conn.close(true)
 			continue
 
 		case edge.ContentTypeData:
 			d := msg.Body
-			log.Tracef("got buffer from sequencer %d bytes", len(d))
 			if len(d) == 0 && conn.readFIN.Load() {
-				return 0, io.EOF
+				return nil, io.EOF
 			}
 
 			// first data message should contain crypto header
 			if conn.rxKey != nil {
 				if len(d) != secretstream.StreamHeaderBytes {
-					return 0, errors.Errorf("failed to receive crypto header bytes: read[%d]", len(d))
+					return nil, errors.Errorf("failed to receive crypto header bytes: read[%d]", len(d))
 				}
 				conn.receiver, err = secretstream.NewDecryptor(conn.rxKey, d)
 				if err != nil {
-					return 0, errors.Wrap(err, "failed to init decryptor")
+					return nil, errors.Wrap(err, "failed to init decryptor")
 				}
 				conn.rxKey = nil
 				continue
@@ -514,24 +697,27 @@ func (conn *edgeConn) Read(p []byte) (int, error) {
 			if conn.receiver != nil {
 				d, _, err = conn.receiver.Pull(d)
 				if err != nil {
-					log.WithFields(edge.GetLoggerFields(msg)).Errorf("crypto failed on msg of size=%v, headers=%+v err=(%v)", len(msg.Body), msg.Headers, err)
-					return 0, err
+					conn.readLogger().WithFields(edge.GetLoggerFields(msg)).Errorf("crypto failed on msg of size=%v, headers=%+v err=(%v)", len(msg.Body), msg.Headers, err)
+					return nil, err
 				}
 			}
-			n := copy(p, d)
-			conn.leftover = d[n:]
 
-			log.Tracef("saving %d bytes for leftover", len(conn.leftover))
-			log.Debugf("reading %v bytes", n)
-			return n, nil
+			if len(d) == 0 {
+				continue
+			}
+			return d, nil
 
 		default:
-			log.WithField("type", msg.ContentType).Error("unexpected message")
+			conn.readLogger().WithField("type", msg.ContentType).Error("unexpected message")
 		}
 	}
 }
 
+// Close closes the connection. Only the first call has an effect, later calls return an edge.AlreadyClosedError.
 func (conn *edgeConn) Close() error {
+	if err := conn.closeGuard.Close("edge connection"); err != nil {
+		return err
+	}
 	conn.close(false)
 	return nil
 }
@@ -545,7 +731,7 @@ func (conn *edgeConn) close(closedByRemote bool) {
 	conn.readFIN.Store(true)
 	conn.sentFIN.Store(true)
 
-	log := pfxlog.Logger().WithField("connId", conn.Id()).WithField("marker", conn.marker)
+	log := conn.log().WithField("connId", conn.Id()).WithField("marker", conn.marker)
 	log.Debug("close: begin")
 	defer log.Debug("close: end")
 
@@ -556,9 +742,14 @@ func (conn *edgeConn) close(closedByRemote bool) {
 		}
 	}
 
+	conn.idle.stop()
 	conn.readQ.Close()
 	conn.msgMux.RemoveMsgSink(conn) // if we switch back to ChMsgMux will need to be done async again, otherwise we may deadlock
 
+	if conn.connType == ConnTypeDial && conn.connClosed != nil {
+		conn.connClosed.OnConnClosed(conn, conn.serviceName, closedByRemote)
+	}
+
 	if conn.connType == ConnTypeBind {
 		for entry := range conn.hosting.IterBuffered() {
 			listener := entry.Val
@@ -575,7 +766,7 @@ func (conn *edgeConn) getListener(token string) (*edgeListener, bool) {
 
 func (conn *edgeConn) newChildConnection(message *channel.Message) {
 	token := string(message.Body)
-	logger := pfxlog.Logger().WithField("connId", conn.Id()).WithField("token", token)
+	logger := conn.log().WithField("connId", conn.Id()).WithField("token", token)
 	logger.Debug("looking up listener")
 	listener, found := conn.getListener(token)
 	if !found {
@@ -588,6 +779,27 @@ func (conn *edgeConn) newChildConnection(message *channel.Message) {
 		return
 	}
 
+	if listener.queuePolicy == edge.AcceptQueueReject && listener.isQueueFull() {
+		logger.Warn("accept queue full, rejecting dial")
+		listener.shed()
+		reply := edge.NewDialFailedMsg(conn.Id(), "accept queue full")
+		reply.ReplyTo(message)
+		if err := reply.WithPriority(channel.Highest).WithTimeout(5 * time.Second).SendAndWaitForWire(conn.Channel); err != nil {
+			logger.WithError(err).Error("failed to send reply to dial request")
+		}
+		return
+	}
+
+	if conn.multiplex.isAtCapacity(conn.msgMux.GetSinkCount()) {
+		logger.Warn("router connection has the maximum number of circuits, rejecting dial")
+		reply := edge.NewDialFailedMsg(conn.Id(), "max circuits reached")
+		reply.ReplyTo(message)
+		if err := reply.WithPriority(channel.Highest).WithTimeout(5 * time.Second).SendAndWaitForWire(conn.Channel); err != nil {
+			logger.WithError(err).Error("failed to send reply to dial request")
+		}
+		return
+	}
+
 	logger.Debug("listener found. checking for router provided connection id")
 
 	id, routerProvidedConnId := message.GetUint32Header(edge.RouterProvidedConnId)
@@ -604,17 +816,29 @@ func (conn *edgeConn) newChildConnection(message *channel.Message) {
 
 	edgeCh := &edgeConn{
 		MsgChannel:     *edge.NewEdgeMsgChannel(conn.Channel, id),
-		readQ:          NewNoopSequencer[*channel.Message](4),
+		readQ:          conn.multiplex.newReadQueue(),
 		msgMux:         conn.msgMux,
+		serviceName:    *listener.service.Name,
+		serviceId:      *listener.service.ID,
 		sourceIdentity: sourceIdentity,
 		crypto:         conn.crypto,
 		appData:        message.Headers[edge.AppDataHeader],
 		connType:       ConnTypeDial,
 		marker:         marker,
 		circuitId:      circuitId,
+		traffic:        edge.NewTrafficCounter(conn.traffic.Parent()),
+		connClosed:     conn.connClosed,
+		connOpened:     conn.connOpened,
+		latency:        conn.latency,
+		startTime:      time.Now(),
+		idleTimeout:    conn.idleTimeout,
+		multiplex:      conn.multiplex,
+		logger:         conn.logger,
 	}
+	edgeCh.MsgChannel.SetLogger(conn.logger)
+	edgeCh.idle = newIdleTimer(edgeCh, conn.idleTimeout)
 
-	newConnLogger := pfxlog.Logger().
+	newConnLogger := conn.log().
 		WithField("marker", marker).
 		WithField("connId", id).
 		WithField("parentConnId", conn.Id()).
@@ -671,7 +895,7 @@ func (conn *edgeConn) newChildConnection(message *channel.Message) {
 		return
 	}
 
-	listener.acceptC <- edgeCh
+	listener.enqueue(edgeCh)
 }
 
 func (conn *edgeConn) GetAppData() []byte {
@@ -733,9 +957,9 @@ type newConnHandler struct {
 
 func (self *newConnHandler) dialFailed(err error) {
 	token := string(self.message.Body)
-	logger := pfxlog.Logger().WithField("connId", self.conn.Id()).WithField("token", token)
+	logger := self.conn.log().WithField("connId", self.conn.Id()).WithField("token", token)
 
-	newConnLogger := pfxlog.Logger().
+	newConnLogger := self.conn.log().
 		WithField("connId", self.edgeCh.Id()).
 		WithField("parentConnId", self.conn.Id()).
 		WithField("token", token)
@@ -750,9 +974,9 @@ func (self *newConnHandler) dialFailed(err error) {
 
 func (self *newConnHandler) dialSucceeded() error {
 	token := string(self.message.Body)
-	logger := pfxlog.Logger().WithField("connId", self.conn.Id()).WithField("token", token)
+	logger := self.conn.log().WithField("connId", self.conn.Id()).WithField("token", token)
 
-	newConnLogger := pfxlog.Logger().
+	newConnLogger := self.conn.log().
 		WithField("connId", self.edgeCh.Id()).
 		WithField("marker", self.edgeCh.marker).
 		WithField("parentConnId", self.conn.Id()).
@@ -787,6 +1011,10 @@ func (self *newConnHandler) dialSucceeded() error {
 		}
 		newConnLogger.Debug("tx crypto established")
 	}
+
+	if self.edgeCh.connOpened != nil {
+		self.edgeCh.connOpened.OnConnOpened(self.edgeCh, self.edgeCh.serviceName, true)
+	}
 	return nil
 }
 
@@ -1,11 +1,16 @@
 package network
 
 import (
+	"bytes"
 	"crypto/x509"
 	"github.com/openziti/channel/v2"
 	"github.com/openziti/foundation/v2/sequencer"
 	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -176,3 +181,352 @@ func (ch *NoopTestChannel) IsClosed() bool {
 func (ch *NoopTestChannel) GetTimeSinceLastRead() time.Duration {
 	return 0
 }
+
+// wireTestChannel is a NoopTestChannel that reports messages as written, so that sends waiting for the wire return.
+type wireTestChannel struct {
+	NoopTestChannel
+}
+
+func (ch *wireTestChannel) Send(s channel.Sendable) error {
+	if listener := s.SendListener(); listener != nil {
+		listener.NotifyAfterWrite()
+	}
+	return nil
+}
+
+func TestConnCloseIdempotent(t *testing.T) {
+	req := require.New(t)
+
+	mux := edge.NewCowMapMsgMux()
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      mux,
+		serviceName: "test",
+	}
+	req.NoError(mux.AddMsgSink(conn))
+
+	req.NoError(conn.Close())
+	req.True(conn.IsClosed())
+	req.Equal(0, mux.GetSinkCount())
+
+	err := conn.Close()
+	req.ErrorIs(err, edge.ErrAlreadyClosed)
+	req.ErrorIs(err, net.ErrClosed)
+}
+
+func TestConnCloseRead(t *testing.T) {
+	req := require.New(t)
+
+	mux := edge.NewCowMapMsgMux()
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      mux,
+		serviceName: "test",
+		connType:    ConnTypeDial,
+	}
+	req.NoError(mux.AddMsgSink(conn))
+
+	var halfCloser net.Conn = conn
+	_, ok := halfCloser.(edge.HalfCloser)
+	req.True(ok)
+
+	conn.Accept(edge.NewDataMsg(1, 1, []byte("queued")))
+
+	readDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 16)
+		_, err := conn.Read(buf) // returns the queued data
+		if err == nil {
+			_, err = conn.Read(buf)
+		}
+		readDone <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	req.NoError(conn.CloseRead())
+
+	select {
+	case err := <-readDone:
+		req.ErrorIs(err, io.EOF)
+	case <-time.After(time.Second):
+		req.Fail("blocked read did not return after CloseRead")
+	}
+
+	// data received after CloseRead is discarded rather than queued
+	for i := 0; i < 10; i++ {
+		conn.Accept(edge.NewDataMsg(1, uint32(i+2), []byte("discarded")))
+	}
+	_, err := conn.Read(make([]byte, 16))
+	req.ErrorIs(err, io.EOF)
+
+	// writes are unaffected
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+
+	// a close by the peer still closes the connection
+	conn.Accept(edge.NewStateClosedMsg(1, ""))
+	req.True(conn.IsClosed())
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	req := require.New(t)
+
+	idleC := make(chan time.Duration, 1)
+	config := &IdleTimeoutConfig{
+		Timeout: 50 * time.Millisecond,
+		OnIdle: func(_ edge.Conn, serviceName string, idle time.Duration) {
+			req.Equal("test", serviceName)
+			idleC <- idle
+		},
+	}
+
+	mux := edge.NewCowMapMsgMux()
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      mux,
+		serviceName: "test",
+		connType:    ConnTypeDial,
+	}
+	conn.idle = newIdleTimer(conn, config)
+	req.NoError(mux.AddMsgSink(conn))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_, err := conn.Write([]byte("keep busy"))
+		req.NoError(err)
+	}
+	req.False(conn.IsClosed())
+
+	select {
+	case idle := <-idleC:
+		req.GreaterOrEqual(idle, config.Timeout)
+		req.GreaterOrEqual(time.Since(start), 80*time.Millisecond+config.Timeout)
+	case <-time.After(time.Second):
+		req.Fail("idle connection was not closed")
+	}
+	req.True(conn.IsClosed())
+	req.Equal(0, mux.GetSinkCount())
+}
+
+func TestConnIdleEvictionVeto(t *testing.T) {
+	req := require.New(t)
+
+	var vetoes atomic.Int32
+	routerIdleC := make(chan struct{}, 1)
+	listenIdleC := make(chan struct{}, 1)
+
+	options := &edge.ListenOptions{
+		IdleTimeout: 30 * time.Millisecond,
+		BeforeIdleClose: func(edge.Conn, time.Duration) bool {
+			// keep the connection through the first two evictions
+			return vetoes.Add(1) > 2
+		},
+		OnIdleClose: func(edge.Conn, time.Duration) {
+			listenIdleC <- struct{}{}
+		},
+	}
+	routerIdle := &IdleTimeoutConfig{
+		Timeout: time.Hour,
+		OnIdle: func(edge.Conn, string, time.Duration) {
+			routerIdleC <- struct{}{}
+		},
+	}
+
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      edge.NewCowMapMsgMux(),
+		serviceName: "test",
+		connType:    ConnTypeDial,
+	}
+	conn.idle = newIdleTimer(conn, listenIdleTimeoutConfig(options, routerIdle))
+
+	for _, c := range []chan struct{}{listenIdleC, routerIdleC} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			req.Fail("idle connection was not closed")
+		}
+	}
+	req.Equal(int32(3), vetoes.Load())
+	req.True(conn.IsClosed())
+}
+
+func TestConnDeadlines(t *testing.T) {
+	req := require.New(t)
+
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      edge.NewCowMapMsgMux(),
+		serviceName: "test",
+	}
+
+	req.NoError(conn.SetDeadline(time.Now().Add(-time.Second)))
+
+	_, err := conn.Write([]byte("late"))
+	req.ErrorIs(err, os.ErrDeadlineExceeded)
+
+	_, err = conn.Read(make([]byte, 8))
+	req.ErrorIs(err, os.ErrDeadlineExceeded)
+	var netErr net.Error
+	req.ErrorAs(err, &netErr)
+	req.True(netErr.Timeout())
+
+	req.NoError(conn.SetDeadline(time.Time{}))
+	_, err = conn.Write([]byte("on time"))
+	req.NoError(err)
+}
+
+// recordingTestChannel is a wireTestChannel that keeps a copy of the body of every message sent.
+type recordingTestChannel struct {
+	wireTestChannel
+	bodies [][]byte
+}
+
+func (ch *recordingTestChannel) Send(s channel.Sendable) error {
+	ch.bodies = append(ch.bodies, append([]byte(nil), s.Msg().Body...))
+	return ch.wireTestChannel.Send(s)
+}
+
+func TestConnReadFromWriteTo(t *testing.T) {
+	req := require.New(t)
+
+	testChannel := &recordingTestChannel{}
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(testChannel, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      edge.NewCowMapMsgMux(),
+		serviceName: "test",
+		connType:    ConnTypeDial,
+	}
+
+	data := make([]byte, edge.PooledBufferSize+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var readerFrom io.ReaderFrom = conn
+	n, err := readerFrom.ReadFrom(bytes.NewReader(data))
+	req.NoError(err)
+	req.Equal(int64(len(data)), n)
+	req.Len(testChannel.bodies, 2, "data is sent in pooled buffer sized messages")
+	req.Equal(data, bytes.Join(testChannel.bodies, nil))
+
+	go func() {
+		conn.Accept(edge.NewDataMsg(1, 1, []byte("hello ")))
+		conn.Accept(edge.NewDataMsg(1, 2, []byte("world")))
+		fin := edge.NewDataMsg(1, 3, nil)
+		fin.PutUint32Header(edge.FlagsHeader, edge.FIN)
+		conn.Accept(fin)
+	}()
+
+	// a partial read leaves the rest of the message for WriteTo
+	buf := make([]byte, 2)
+	_, err = conn.Read(buf)
+	req.NoError(err)
+	req.Equal("he", string(buf))
+
+	var writerTo io.WriterTo = conn
+	out := &bytes.Buffer{}
+	n, err = writerTo.WriteTo(out)
+	req.NoError(err)
+	req.Equal(int64(9), n)
+	req.Equal("llo world", out.String())
+}
+
+func BenchmarkConnReadFrom(b *testing.B) {
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      edge.NewCowMapMsgMux(),
+		serviceName: "test",
+	}
+
+	data := make([]byte, 1024*1024)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.ReadFrom(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	req := require.New(t)
+
+	service := edge.NewTrafficCounter(nil)
+	startTime := time.Now()
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      edge.NewCowMapMsgMux(),
+		serviceName: "test",
+		circuitId:   "circuit",
+		connType:    ConnTypeDial,
+		traffic:     edge.NewTrafficCounter(service),
+		latency:     func() time.Duration { return 5 * time.Millisecond },
+		startTime:   startTime,
+	}
+
+	_, err := conn.Write([]byte("hello"))
+	req.NoError(err)
+	_, err = conn.Write([]byte("world!"))
+	req.NoError(err)
+
+	conn.Accept(edge.NewDataMsg(1, 1, []byte("hi")))
+	buf := make([]byte, 10)
+	n, err := conn.Read(buf)
+	req.NoError(err)
+	req.Equal(2, n)
+
+	req.Equal(edge.ConnStats{
+		ConnId:      1,
+		ServiceName: "test",
+		CircuitId:   "circuit",
+		StartTime:   startTime,
+		BytesIn:     2,
+		BytesOut:    11,
+		MsgsIn:      1,
+		MsgsOut:     2,
+		RTT:         5 * time.Millisecond,
+	}, conn.Stats())
+	req.Equal(uint64(11), service.TxBytes(), "bytes also count towards the service")
+
+	router := &routerConn{msgMux: conn.msgMux}
+	req.NoError(router.msgMux.AddMsgSink(conn))
+	req.NoError(router.msgMux.AddMsgSink(&edgeConn{MsgChannel: *edge.NewEdgeMsgChannel(&wireTestChannel{}, 2), connType: ConnTypeBind}))
+
+	stats := router.GetConnStats()
+	req.Len(stats, 1, "hosting connections are not listed")
+	req.Equal(uint32(1), stats[0].ConnId)
+}
+
+func TestConnWriteLogger(t *testing.T) {
+	req := require.New(t)
+
+	buf := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(buf)
+	logger.SetLevel(logrus.DebugLevel)
+
+	conn := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      edge.NewCowMapMsgMux(),
+		serviceName: "test",
+		logger:      logger,
+	}
+	conn.MsgChannel.SetLogger(logger)
+
+	_, err := conn.Write([]byte("hello"))
+	req.NoError(err)
+	req.Contains(buf.String(), "writing 5 bytes")
+}
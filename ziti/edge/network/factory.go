@@ -27,18 +27,79 @@ import (
 	"github.com/openziti/secretstream/kx"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 type RouterConnOwner interface {
 	OnClose(factory edge.RouterConn)
 }
 
+// TrafficCountingOwner may be implemented by a RouterConnOwner to have the payload bytes of all edge connections
+// established over its router connections counted.
+type TrafficCountingOwner interface {
+	GetTrafficCounter() *edge.TrafficCounter
+}
+
+// ServiceTrafficCountingOwner may be implemented by a RouterConnOwner to count the payload bytes of edge connections
+// per service. It takes precedence over TrafficCountingOwner, so the returned counters should count towards the
+// owner's total themselves, see edge.NewTrafficCounter.
+type ServiceTrafficCountingOwner interface {
+	GetServiceTrafficCounter(serviceName string) *edge.TrafficCounter
+}
+
+// ConnClosedOwner may be implemented by a RouterConnOwner to be notified when a dialed or accepted edge connection
+// established over one of its router connections closes.
+type ConnClosedOwner interface {
+	OnConnClosed(conn edge.Conn, serviceName string, closedByRemote bool)
+}
+
+// ConnOpenedOwner may be implemented by a RouterConnOwner to be notified when an edge connection is established over
+// one of its router connections, either dialed or accepted for a hosted service.
+type ConnOpenedOwner interface {
+	OnConnOpened(conn edge.Conn, serviceName string, hosted bool)
+}
+
+// LatencyOwner may be implemented by a RouterConnOwner that measures the latency of its router connections, to provide
+// the RTT estimate in the stats of edge connections. It returns zero if the latency of the connection with the given
+// key hasn't been measured.
+type LatencyOwner interface {
+	GetRouterConnLatency(key string) time.Duration
+}
+
+// LoggerOwner may be implemented by a RouterConnOwner to have its router connections, and the edge connections and
+// listeners established over them, log to the returned logger instead of the process wide pfxlog logger. A nil logger
+// uses pfxlog.
+type LoggerOwner interface {
+	GetLogger() *logrus.Logger
+}
+
 type routerConn struct {
 	routerName string
 	key        string
 	ch         channel.Channel
 	msgMux     edge.MsgMux
 	owner      RouterConnOwner
+	traffic    *edge.TrafficCounter
+	perService ServiceTrafficCountingOwner
+	connClosed ConnClosedOwner
+	connOpened ConnOpenedOwner
+	latency    LatencyOwner
+	keepalive  *KeepaliveConfig
+	idle       *IdleTimeoutConfig
+	multiplex  *MultiplexConfig
+	logger     *logrus.Logger
+}
+
+// newLogger returns a log builder for logger, or for the process wide pfxlog logger if logger is nil.
+func newLogger(logger *logrus.Logger) *pfxlog.Builder {
+	if logger == nil {
+		return pfxlog.Logger()
+	}
+	return &pfxlog.Builder{Entry: logrus.NewEntry(logger)}
+}
+
+func (conn *routerConn) log() *pfxlog.Builder {
+	return newLogger(conn.logger)
 }
 
 func (conn *routerConn) GetBoolHeader(key int32) bool {
@@ -54,6 +115,34 @@ func (conn *routerConn) GetRouterName() string {
 	return conn.routerName
 }
 
+func (conn *routerConn) GetActiveConnCount() int {
+	return conn.msgMux.GetSinkCount()
+}
+
+func (conn *routerConn) GetConnStats() []edge.ConnStats {
+	var result []edge.ConnStats
+	for _, sink := range conn.msgMux.GetSinks() {
+		if edgeCh, ok := sink.(*edgeConn); ok && edgeCh.connType == ConnTypeDial {
+			result = append(result, edgeCh.Stats())
+		}
+	}
+	return result
+}
+
+// getLatency returns the latency of the router connection, as measured by the owner.
+func (conn *routerConn) getLatency() time.Duration {
+	if conn.latency == nil {
+		return 0
+	}
+	return conn.latency.GetRouterConnLatency(conn.key)
+}
+
+// IsAtCapacity returns true if the router connection carries the maximum number of edge connections allowed by its
+// MultiplexConfig.
+func (conn *routerConn) IsAtCapacity() bool {
+	return conn.multiplex.isAtCapacity(conn.GetActiveConnCount())
+}
+
 func (conn *routerConn) HandleClose(channel.Channel) {
 	if conn.owner != nil {
 		conn.owner.OnClose(conn)
@@ -68,6 +157,42 @@ func NewEdgeConnFactory(routerName, key string, owner RouterConnOwner) edge.Rout
 		owner:      owner,
 	}
 
+	if counting, ok := owner.(TrafficCountingOwner); ok {
+		connFactory.traffic = counting.GetTrafficCounter()
+	}
+
+	if counting, ok := owner.(ServiceTrafficCountingOwner); ok {
+		connFactory.perService = counting
+	}
+
+	if closedOwner, ok := owner.(ConnClosedOwner); ok {
+		connFactory.connClosed = closedOwner
+	}
+
+	if openedOwner, ok := owner.(ConnOpenedOwner); ok {
+		connFactory.connOpened = openedOwner
+	}
+
+	if latencyOwner, ok := owner.(LatencyOwner); ok {
+		connFactory.latency = latencyOwner
+	}
+
+	if keepaliveOwner, ok := owner.(KeepaliveOwner); ok {
+		connFactory.keepalive = keepaliveOwner.GetKeepaliveConfig()
+	}
+
+	if idleOwner, ok := owner.(IdleTimeoutOwner); ok {
+		connFactory.idle = idleOwner.GetIdleTimeoutConfig()
+	}
+
+	if multiplexOwner, ok := owner.(MultiplexOwner); ok {
+		connFactory.multiplex = multiplexOwner.GetMultiplexConfig()
+	}
+
+	if loggerOwner, ok := owner.(LoggerOwner); ok {
+		connFactory.logger = loggerOwner.GetLogger()
+	}
+
 	return connFactory
 }
 
@@ -85,33 +210,53 @@ func (conn *routerConn) BindChannel(binding channel.Binding) error {
 	binding.AddCloseHandler(conn.msgMux)
 	binding.AddCloseHandler(conn)
 
+	if conn.keepalive != nil {
+		newKeepalive(conn, conn.keepalive).configure(binding)
+	}
+
 	return nil
 }
 
+func (conn *routerConn) getTrafficCounter(serviceName string) *edge.TrafficCounter {
+	if conn.perService != nil {
+		return conn.perService.GetServiceTrafficCounter(serviceName)
+	}
+	return conn.traffic
+}
+
 func (conn *routerConn) NewDialConn(service *rest_model.ServiceDetail) *edgeConn {
 	id := conn.msgMux.GetNextId()
 
 	edgeCh := &edgeConn{
 		MsgChannel:  *edge.NewEdgeMsgChannel(conn.ch, id),
-		readQ:       NewNoopSequencer[*channel.Message](4),
+		readQ:       conn.multiplex.newReadQueue(),
 		msgMux:      conn.msgMux,
 		serviceName: *service.Name,
+		serviceId:   *service.ID,
 		connType:    ConnTypeDial,
 		marker:      newMarker(),
+		traffic:     edge.NewTrafficCounter(conn.getTrafficCounter(*service.Name)),
+		connClosed:  conn.connClosed,
+		connOpened:  conn.connOpened,
+		latency:     conn.getLatency,
+		startTime:   time.Now(),
+		logger:      conn.logger,
 	}
+	edgeCh.MsgChannel.SetLogger(conn.logger)
+	edgeCh.idle = newIdleTimer(edgeCh, conn.idle)
 
 	var err error
 	if *service.EncryptionRequired {
 		if edgeCh.keyPair, err = kx.NewKeyPair(); err == nil {
 			edgeCh.crypto = true
 		} else {
-			pfxlog.Logger().Errorf("unable to setup encryption for edgeConn[%s] %v", *service.Name, err)
+			conn.log().Errorf("unable to setup encryption for edgeConn[%s] %v", *service.Name, err)
 		}
 	}
 
 	err = conn.msgMux.AddMsgSink(edgeCh) // duplicate errors only happen on the server side, since client controls ids
 	if err != nil {
-		pfxlog.Logger().Warnf("error adding message sink %s[%d]: %v", *service.Name, id, err)
+		conn.log().Warnf("error adding message sink %s[%d]: %v", *service.Name, id, err)
 	}
 	return edgeCh
 }
@@ -142,20 +287,30 @@ func (conn *routerConn) NewListenConn(service *rest_model.ServiceDetail, keyPair
 
 	edgeCh := &edgeConn{
 		MsgChannel:  *edge.NewEdgeMsgChannel(conn.ch, id),
-		readQ:       NewNoopSequencer[*channel.Message](4),
+		readQ:       conn.multiplex.newReadQueue(),
 		msgMux:      conn.msgMux,
 		serviceName: *service.Name,
+		serviceId:   *service.ID,
 		connType:    ConnTypeBind,
 		keyPair:     keyPair,
 		crypto:      keyPair != nil,
 		hosting:     cmap.New[*edgeListener](),
+		traffic:     edge.NewTrafficCounter(conn.getTrafficCounter(*service.Name)),
+		connClosed:  conn.connClosed,
+		connOpened:  conn.connOpened,
+		latency:     conn.getLatency,
+		startTime:   time.Now(),
+		logger:      conn.logger,
+		idleTimeout: conn.idle,
+		multiplex:   conn.multiplex,
 	}
+	edgeCh.MsgChannel.SetLogger(conn.logger)
 
 	// duplicate errors only happen on the server side, since client controls ids
 	if err := conn.msgMux.AddMsgSink(edgeCh); err != nil {
-		pfxlog.Logger().Warnf("error adding message sink %s[%d]: %v", *service.Name, id, err)
+		conn.log().Warnf("error adding message sink %s[%d]: %v", *service.Name, id, err)
 	}
-	pfxlog.Logger().WithField("connId", id).
+	conn.log().WithField("connId", id).
 		WithField("routerName", conn.routerName).
 		WithField("serviceId", *service.ID).
 		WithField("serviceName", *service.Name).
@@ -164,11 +319,15 @@ func (conn *routerConn) NewListenConn(service *rest_model.ServiceDetail, keyPair
 }
 
 func (conn *routerConn) Connect(service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.DialOptions) (edge.Conn, error) {
+	if conn.IsAtCapacity() {
+		return nil, errors.Wrapf(ErrMaxCircuits, "unable to dial service '%s' over router [%s]", *service.Name, conn.Key())
+	}
+
 	ec := conn.NewDialConn(service)
 	dialConn, err := ec.Connect(session, options)
 	if err != nil {
 		if err2 := ec.Close(); err2 != nil {
-			pfxlog.Logger().Errorf("failed to cleanup connection for service '%v' (%v)", service.Name, err2)
+			conn.log().Errorf("failed to cleanup connection for service '%v' (%v)", service.Name, err2)
 		}
 	}
 	return dialConn, err
@@ -176,8 +335,11 @@ func (conn *routerConn) Connect(service *rest_model.ServiceDetail, session *rest
 
 func (conn *routerConn) Listen(service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.ListenOptions) (edge.Listener, error) {
 	ec := conn.NewListenConn(service, options.KeyPair)
+	if options.IdleTimeout > 0 {
+		ec.idleTimeout = listenIdleTimeoutConfig(options, conn.idle)
+	}
 
-	log := pfxlog.Logger().
+	log := conn.log().
 		WithField("connId", ec.Id()).
 		WithField("router", conn.routerName).
 		WithField("serviceId", *service.ID).
@@ -211,3 +373,28 @@ func (conn *routerConn) Close() error {
 func (conn *routerConn) IsClosed() bool {
 	return conn.ch.IsClosed()
 }
+
+// listenIdleTimeoutConfig returns the idle timeout of the connections accepted by a listener with the given options,
+// which replaces the idle timeout of the router connection, if any, but still notifies its owner of closed connections.
+func listenIdleTimeoutConfig(options *edge.ListenOptions, routerIdle *IdleTimeoutConfig) *IdleTimeoutConfig {
+	result := &IdleTimeoutConfig{
+		Timeout: options.IdleTimeout,
+	}
+
+	if options.BeforeIdleClose != nil {
+		result.BeforeClose = func(conn edge.Conn, _ string, idle time.Duration) bool {
+			return options.BeforeIdleClose(conn, idle)
+		}
+	}
+
+	result.OnIdle = func(conn edge.Conn, serviceName string, idle time.Duration) {
+		if options.OnIdleClose != nil {
+			options.OnIdleClose(conn, idle)
+		}
+		if routerIdle != nil && routerIdle.OnIdle != nil {
+			routerIdle.OnIdle(conn, serviceName, idle)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,110 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package network
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// IdleTimeoutOwner may be implemented by a RouterConnOwner to have the edge connections dialed or accepted over its
+// router connections closed once they carry no traffic for a while. Returning nil disables the idle timeout.
+type IdleTimeoutOwner interface {
+	GetIdleTimeoutConfig() *IdleTimeoutConfig
+}
+
+// IdleTimeoutConfig configures the idle timeout of edge connections. Data messages sent or received in either
+// direction count as traffic, whether or not the application has read them yet.
+type IdleTimeoutConfig struct {
+	Timeout time.Duration
+
+	// BeforeClose, if set, is called before an idle connection is closed. Returning false keeps the connection open
+	// for another Timeout, e.g. if the application knows the peer is still there. It may also be used to flush state
+	// associated with the connection before it is closed.
+	BeforeClose func(conn edge.Conn, serviceName string, idle time.Duration) bool
+
+	// OnIdle, if set, is called after an idle connection was closed.
+	OnIdle func(conn edge.Conn, serviceName string, idle time.Duration)
+}
+
+// idleTimer closes its connection once it has been idle for the configured timeout. It re-arms itself for the
+// remainder of the timeout whenever it fires early, so recording activity is only an atomic store.
+type idleTimer struct {
+	conn         *edgeConn
+	config       *IdleTimeoutConfig
+	lastActivity atomic.Int64
+	timer        *time.Timer
+}
+
+func newIdleTimer(conn *edgeConn, config *IdleTimeoutConfig) *idleTimer {
+	if config == nil || config.Timeout <= 0 {
+		return nil
+	}
+
+	result := &idleTimer{
+		conn:   conn,
+		config: config,
+	}
+	result.touch()
+	result.timer = time.AfterFunc(config.Timeout, result.check)
+	return result
+}
+
+func (self *idleTimer) touch() {
+	if self != nil {
+		self.lastActivity.Store(time.Now().UnixNano())
+	}
+}
+
+func (self *idleTimer) stop() {
+	if self != nil {
+		self.timer.Stop()
+	}
+}
+
+func (self *idleTimer) check() {
+	if self.conn.IsClosed() {
+		return
+	}
+
+	idle := time.Since(time.Unix(0, self.lastActivity.Load()))
+	if idle < self.config.Timeout {
+		self.timer.Reset(self.config.Timeout - idle)
+		return
+	}
+
+	log := self.conn.log().WithField("connId", self.conn.Id()).
+		WithField("serviceName", self.conn.serviceName).
+		WithField("idle", idle)
+
+	if self.config.BeforeClose != nil && !self.config.BeforeClose(self.conn, self.conn.serviceName, idle) {
+		log.Debug("closing idle connection vetoed")
+		self.touch()
+		self.timer.Reset(self.config.Timeout)
+		return
+	}
+
+	log.Info("closing idle connection")
+
+	self.conn.close(false)
+
+	if self.config.OnIdle != nil {
+		self.config.OnIdle(self.conn, self.conn.serviceName, idle)
+	}
+}
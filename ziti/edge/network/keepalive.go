@@ -0,0 +1,137 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package network
+
+import (
+	"time"
+
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// KeepaliveOwner may be implemented by a RouterConnOwner to have keepalives sent on its router connections. Returning
+// nil disables keepalives.
+type KeepaliveOwner interface {
+	GetKeepaliveConfig() *KeepaliveConfig
+}
+
+// KeepaliveConfig configures the channel heartbeats used as keepalives on a router connection. Heartbeats piggyback on
+// outgoing messages where possible, and are only sent standalone when nothing has been sent for SendInterval, so they
+// never reach the edge connections multiplexed over the router connection.
+type KeepaliveConfig struct {
+	SendInterval  time.Duration
+	CheckInterval time.Duration
+
+	// UnresponsiveTimeout is how long the router may go without answering a heartbeat before the router connection is
+	// closed. If zero, unresponsive router connections are left open.
+	UnresponsiveTimeout time.Duration
+
+	// OnResponse, if set, is called with the round trip time of every heartbeat the router answers.
+	OnResponse func(conn edge.RouterConn, rtt time.Duration)
+
+	// OnFailure, if set, is called when the router connection is closed for being unresponsive.
+	OnFailure func(conn edge.RouterConn, sinceLastResponse time.Duration)
+
+	// UnhealthyTimeout is how long the router may go without answering a heartbeat before OnUnhealthy is called. It
+	// should be shorter than UnresponsiveTimeout. If zero, router connections are never reported as unhealthy.
+	UnhealthyTimeout time.Duration
+
+	// OnUnhealthy, if set, is called when the router connection has not answered a heartbeat for UnhealthyTimeout.
+	OnUnhealthy func(conn edge.RouterConn, sinceLastResponse time.Duration)
+
+	// OnHealthy, if set, is called when a router connection reported as unhealthy answers a heartbeat again.
+	OnHealthy func(conn edge.RouterConn)
+}
+
+// keepalive implements channel.HeartbeatCallback. All callbacks are made from the heartbeat goroutine of the channel.
+type keepalive struct {
+	conn         *routerConn
+	config       *KeepaliveConfig
+	lastResponse time.Time
+	failed       bool
+	unhealthy    bool
+}
+
+func newKeepalive(conn *routerConn, config *KeepaliveConfig) *keepalive {
+	return &keepalive{
+		conn:         conn,
+		config:       config,
+		lastResponse: time.Now(),
+	}
+}
+
+func (self *keepalive) configure(binding channel.Binding) {
+	channel.ConfigureHeartbeat(binding, self.config.SendInterval, self.config.CheckInterval, self)
+}
+
+func (self *keepalive) HeartbeatTx(int64) {}
+
+func (self *keepalive) HeartbeatRx(int64) {}
+
+func (self *keepalive) HeartbeatRespTx(int64) {}
+
+func (self *keepalive) HeartbeatRespRx(ts int64) {
+	now := time.Now()
+	self.lastResponse = now
+	if self.config.OnResponse != nil {
+		self.config.OnResponse(self.conn, time.Duration(now.UnixNano()-ts))
+	}
+
+	if self.unhealthy && !self.failed {
+		self.unhealthy = false
+		self.conn.log().WithField("router", self.conn.routerName).Info("router connection is answering keepalives again")
+		if self.config.OnHealthy != nil {
+			self.config.OnHealthy(self.conn)
+		}
+	}
+}
+
+func (self *keepalive) CheckHeartBeat() {
+	if self.failed {
+		return
+	}
+
+	sinceLastResponse := time.Since(self.lastResponse)
+
+	if !self.unhealthy && self.config.UnhealthyTimeout > 0 && sinceLastResponse >= self.config.UnhealthyTimeout {
+		self.unhealthy = true
+		self.conn.log().WithField("router", self.conn.routerName).
+			WithField("sinceLastResponse", sinceLastResponse).
+			Warn("router connection is not answering keepalives, marking unhealthy")
+
+		if self.config.OnUnhealthy != nil {
+			self.config.OnUnhealthy(self.conn, sinceLastResponse)
+		}
+	}
+
+	if self.config.UnresponsiveTimeout <= 0 || sinceLastResponse < self.config.UnresponsiveTimeout {
+		return
+	}
+
+	self.failed = true
+	self.conn.log().WithField("router", self.conn.routerName).
+		WithField("sinceLastResponse", sinceLastResponse).
+		Error("router connection did not respond to keepalives, closing")
+
+	if self.config.OnFailure != nil {
+		self.config.OnFailure(self.conn, sinceLastResponse)
+	}
+
+	if self.conn.ch != nil {
+		_ = self.conn.ch.Close()
+	}
+}
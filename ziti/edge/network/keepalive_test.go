@@ -0,0 +1,116 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_keepalive(t *testing.T) {
+	req := require.New(t)
+
+	var rtts []time.Duration
+	failures := 0
+
+	conn := &routerConn{routerName: "test"}
+	k := newKeepalive(conn, &KeepaliveConfig{
+		UnresponsiveTimeout: time.Minute,
+		OnResponse: func(_ edge.RouterConn, rtt time.Duration) {
+			rtts = append(rtts, rtt)
+		},
+		OnFailure: func(edge.RouterConn, time.Duration) {
+			failures++
+		},
+	})
+
+	k.HeartbeatRespRx(time.Now().Add(-10 * time.Millisecond).UnixNano())
+	req.Len(rtts, 1)
+	req.GreaterOrEqual(rtts[0], 10*time.Millisecond)
+
+	k.CheckHeartBeat()
+	req.Equal(0, failures)
+
+	k.lastResponse = time.Now().Add(-2 * time.Minute)
+	k.CheckHeartBeat()
+	req.Equal(1, failures)
+
+	// failures are only reported once per router connection
+	k.CheckHeartBeat()
+	req.Equal(1, failures)
+}
+
+func Test_keepaliveUnhealthy(t *testing.T) {
+	req := require.New(t)
+
+	unhealthy := 0
+	healthy := 0
+	failures := 0
+
+	conn := &routerConn{routerName: "test"}
+	k := newKeepalive(conn, &KeepaliveConfig{
+		UnhealthyTimeout:    30 * time.Second,
+		UnresponsiveTimeout: time.Minute,
+		OnUnhealthy: func(edge.RouterConn, time.Duration) {
+			unhealthy++
+		},
+		OnHealthy: func(edge.RouterConn) {
+			healthy++
+		},
+		OnFailure: func(edge.RouterConn, time.Duration) {
+			failures++
+		},
+	})
+
+	k.CheckHeartBeat()
+	req.Equal(0, unhealthy)
+
+	k.lastResponse = time.Now().Add(-45 * time.Second)
+	k.CheckHeartBeat()
+	k.CheckHeartBeat()
+	req.Equal(1, unhealthy)
+	req.Equal(0, failures)
+
+	k.HeartbeatRespRx(time.Now().UnixNano())
+	req.Equal(1, healthy)
+
+	k.HeartbeatRespRx(time.Now().UnixNano())
+	req.Equal(1, healthy)
+
+	// a connection closed for being unresponsive is reported unhealthy first, and never healthy again
+	k.lastResponse = time.Now().Add(-2 * time.Minute)
+	k.CheckHeartBeat()
+	req.Equal(2, unhealthy)
+	req.Equal(1, failures)
+
+	k.HeartbeatRespRx(time.Now().UnixNano())
+	req.Equal(1, healthy)
+}
+
+type testLoggerOwner struct {
+	logger *logrus.Logger
+}
+
+func (self *testLoggerOwner) OnClose(edge.RouterConn) {}
+
+func (self *testLoggerOwner) GetLogger() *logrus.Logger {
+	return self.logger
+}
+
+func Test_keepaliveLogger(t *testing.T) {
+	req := require.New(t)
+
+	buf := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(buf)
+
+	conn := NewEdgeConnFactory("test", "key", &testLoggerOwner{logger: logger}).(*routerConn)
+	k := newKeepalive(conn, &KeepaliveConfig{UnhealthyTimeout: 30 * time.Second})
+	k.lastResponse = time.Now().Add(-time.Minute)
+	k.CheckHeartBeat()
+
+	req.Contains(buf.String(), "router connection is not answering keepalives")
+}
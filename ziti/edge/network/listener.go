@@ -17,11 +17,13 @@
 package network
 
 import (
+	"context"
 	"fmt"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/edge-api/rest_model"
 	"github.com/openziti/sdk-golang/ziti/edge"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"math"
 	"net"
 	"reflect"
@@ -32,10 +34,13 @@ import (
 )
 
 type baseListener struct {
-	service *rest_model.ServiceDetail
-	acceptC chan edge.Conn
-	errorC  chan error
-	closed  atomic.Bool
+	service  *rest_model.ServiceDetail
+	acceptC  chan edge.Conn
+	errorC   chan error
+	closed   atomic.Bool
+	draining atomic.Bool
+
+	closeGuard edge.CloseGuard
 }
 
 func (listener *baseListener) Network() string {
@@ -67,6 +72,10 @@ func (listener *baseListener) AcceptEdge() (edge.Conn, error) {
 	defer ticker.Stop()
 
 	for !listener.closed.Load() {
+		if listener.draining.Load() {
+			return nil, errors.New("listener is draining")
+		}
+
 		select {
 		case conn, ok := <-listener.acceptC:
 			if ok && conn != nil {
@@ -94,6 +103,78 @@ type edgeListener struct {
 	manualStart bool
 	established atomic.Bool
 	eventC      chan *edge.ListenerEvent
+	queuePolicy edge.AcceptQueueFullPolicy
+	onShed      func()
+	shedCount   atomic.Uint64
+	accepted    connTracker
+}
+
+func acceptQueueSize(options *edge.ListenOptions) int {
+	if options.AcceptQueueSize > 0 {
+		return options.AcceptQueueSize
+	}
+	return edge.DefaultAcceptQueueSize
+}
+
+func (listener *edgeListener) isQueueFull() bool {
+	return len(listener.acceptC) >= cap(listener.acceptC)
+}
+
+// shed records a connection refused or dropped because the accept queue was full.
+func (listener *edgeListener) shed() {
+	listener.shedCount.Add(1)
+	if listener.onShed != nil {
+		listener.onShed()
+	}
+}
+
+// enqueue queues a new connection for accepting, applying the queue policy if the queue is full.
+func (listener *edgeListener) enqueue(conn edge.Conn) {
+	if listener.queuePolicy != edge.AcceptQueueDropOldest {
+		listener.acceptC <- conn
+		return
+	}
+
+	for {
+		select {
+		case listener.acceptC <- conn:
+			return
+		default:
+		}
+
+		select {
+		case oldest := <-listener.acceptC:
+			if oldest == nil {
+				// the listener is closing
+				_ = conn.Close()
+				return
+			}
+			listener.edgeChan.log().WithField("connId", oldest.Id()).WithField("serviceName", listener.edgeChan.serviceName).
+				Warn("accept queue full, dropping oldest connection")
+			_ = oldest.Close()
+			listener.shed()
+		default:
+		}
+	}
+}
+
+func (listener *edgeListener) Accept() (net.Conn, error) {
+	conn, err := listener.AcceptEdge()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// AcceptEdge returns the next connection, tracking it so that Drain can wait for it to close. Connections forwarded by
+// a multiListener are tracked by the multiListener instead.
+func (listener *edgeListener) AcceptEdge() (edge.Conn, error) {
+	conn, err := listener.baseListener.AcceptEdge()
+	if err != nil {
+		return nil, err
+	}
+	listener.accepted.add(conn)
+	return conn, nil
 }
 
 func (listener *edgeListener) Id() uint32 {
@@ -113,7 +194,7 @@ func (listener *edgeListener) UpdateCostAndPrecedence(cost uint16, precedence ed
 }
 
 func (listener *edgeListener) updateCostAndPrecedence(cost *uint16, precedence *edge.Precedence) error {
-	logger := pfxlog.Logger().
+	logger := listener.edgeChan.log().
 		WithField("connId", listener.edgeChan.Id()).
 		WithField("serviceName", listener.edgeChan.serviceName).
 		WithField("session", listener.token)
@@ -124,8 +205,36 @@ func (listener *edgeListener) updateCostAndPrecedence(cost *uint16, precedence *
 	return request.WithTimeout(5 * time.Second).SendAndWaitForWire(listener.edgeChan.Channel)
 }
 
+// Drain marks the terminator failed and stops accepting, then waits for the connections accepted from the listener to
+// close before closing it. If ctx is done first, the remaining connections are closed along with the listener.
+func (listener *edgeListener) Drain(ctx context.Context) error {
+	if listener.closed.Load() {
+		return &edge.AlreadyClosedError{}
+	}
+
+	if !listener.draining.CompareAndSwap(false, true) {
+		return errors.New("listener is already draining")
+	}
+
+	log := listener.edgeChan.log().WithField("serviceName", listener.edgeChan.serviceName)
+	if err := listener.UpdatePrecedence(edge.PrecedenceFailed); err != nil {
+		log.WithError(err).Warn("unable to mark terminator failed before draining")
+	}
+
+	err := listener.accepted.wait(ctx)
+	if err != nil {
+		log.WithError(err).Warn("listener did not drain in time, closing remaining connections")
+		listener.accepted.closeAll()
+	}
+
+	if closeErr := listener.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func (listener *edgeListener) SendHealthEvent(pass bool) error {
-	logger := pfxlog.Logger().
+	logger := listener.edgeChan.log().
 		WithField("connId", listener.edgeChan.Id()).
 		WithField("serviceName", listener.edgeChan.serviceName).
 		WithField("session", listener.token).
@@ -137,7 +246,11 @@ func (listener *edgeListener) SendHealthEvent(pass bool) error {
 	return request.WithTimeout(5 * time.Second).SendAndWaitForWire(listener.edgeChan.Channel)
 }
 
+// Close closes the listener. Only the first call has an effect, later calls return an edge.AlreadyClosedError.
 func (listener *edgeListener) Close() error {
+	if err := listener.closeGuard.Close("edge listener"); err != nil {
+		return err
+	}
 	return listener.close(true)
 }
 
@@ -149,7 +262,7 @@ func (listener *edgeListener) close(closedByRemote bool) error {
 
 	edgeChan := listener.edgeChan
 
-	logger := pfxlog.Logger().
+	logger := listener.edgeChan.log().
 		WithField("connId", listener.edgeChan.Id()).
 		WithField("sessionId", listener.token)
 
@@ -179,6 +292,23 @@ type MultiListener interface {
 	GetService() *rest_model.ServiceDetail
 	CloseWithError(err error)
 	GetEstablishedCount() uint
+	GetAcceptQueueStats() AcceptQueueStats
+
+	// SetLogger sets the logger of the MultiListener, which uses the process wide pfxlog logger if it is nil. It must
+	// be called before listeners are added.
+	SetLogger(logger *logrus.Logger)
+}
+
+// AcceptQueueStats describes the accept queues of the edge router listeners of a MultiListener.
+type AcceptQueueStats struct {
+	// Queued is the number of connections waiting to be accepted by the application.
+	Queued int
+
+	// Capacity is the combined size of the queues.
+	Capacity int
+
+	// Shed is the number of connections rejected or dropped because a queue was full.
+	Shed uint64
 }
 
 func NewMultiListener(service *rest_model.ServiceDetail, getSessionF func() *rest_model.SessionDetail) MultiListener {
@@ -202,6 +332,16 @@ type multiListener struct {
 	listenerEventHandler atomic.Value
 	errorEventHandler    atomic.Value
 	listenerEventC       chan *edge.ListenerEvent
+	accepted             connTracker
+	logger               *logrus.Logger
+}
+
+func (self *multiListener) SetLogger(logger *logrus.Logger) {
+	self.logger = logger
+}
+
+func (self *multiListener) log() *pfxlog.Builder {
+	return newLogger(self.logger)
 }
 
 func (self *multiListener) Id() uint32 {
@@ -220,6 +360,19 @@ func (self *multiListener) GetEstablishedCount() uint {
 	return count
 }
 
+func (self *multiListener) GetAcceptQueueStats() AcceptQueueStats {
+	self.listenerLock.Lock()
+	defer self.listenerLock.Unlock()
+
+	var result AcceptQueueStats
+	for child := range self.listeners {
+		result.Queued += len(child.acceptC)
+		result.Capacity += cap(child.acceptC)
+		result.Shed += child.shedCount.Load()
+	}
+	return result
+}
+
 func (self *multiListener) SetConnectionChangeHandler(handler func([]edge.Listener)) {
 	self.listenerEventHandler.Store(handler)
 
@@ -249,7 +402,7 @@ func (self *multiListener) GetErrorEventHandler() func(error) {
 }
 
 func (self *multiListener) NotifyOfChildError(err error) {
-	pfxlog.Logger().Infof("notify error handler of error: %v", err)
+	self.log().Infof("notify error handler of error: %v", err)
 	if handler := self.GetErrorEventHandler(); handler != nil {
 		handler(err)
 	}
@@ -344,7 +497,7 @@ func (self *multiListener) AddListener(netListener edge.Listener, closeHandler f
 
 	edgeListener, ok := netListener.(*edgeListener)
 	if !ok {
-		pfxlog.Logger().Errorf("multi-listener expects only listeners created by the SDK, not %v", reflect.TypeOf(self))
+		self.log().Errorf("multi-listener expects only listeners created by the SDK, not %v", reflect.TypeOf(self))
 		return
 	}
 
@@ -368,8 +521,8 @@ func (self *multiListener) AddListener(netListener edge.Listener, closeHandler f
 
 func (self *multiListener) forward(edgeListener *edgeListener, closeHandler func()) {
 	defer func() {
-		if err := edgeListener.Close(); err != nil {
-			pfxlog.Logger().Errorf("failure closing edge listener: (%v)", err)
+		if err := edgeListener.close(true); err != nil {
+			edgeListener.edgeChan.log().Errorf("failure closing edge listener: (%v)", err)
 		}
 		closeHandler()
 	}()
@@ -392,17 +545,54 @@ func (self *multiListener) forward(edgeListener *edgeListener, closeHandler func
 }
 
 func (self *multiListener) accept(conn edge.Conn, ticker *time.Ticker) {
-	for !self.closed.Load() {
+	for !self.closed.Load() && !self.draining.Load() {
 		select {
 		case self.acceptC <- conn:
+			self.accepted.add(conn)
 			return
 		case <-ticker.C:
 			// lets us check if the listener is closed, and exit if it has
 		}
 	}
+
+	if self.draining.Load() {
+		// the listener stopped accepting while the connection was waiting to be accepted
+		_ = conn.Close()
+	}
+}
+
+func (self *multiListener) Drain(ctx context.Context) error {
+	if self.closed.Load() {
+		return &edge.AlreadyClosedError{}
+	}
+
+	if !self.draining.CompareAndSwap(false, true) {
+		return errors.New("listener is already draining")
+	}
+
+	log := self.log().WithField("serviceName", self.GetServiceName())
+	if err := self.UpdatePrecedence(edge.PrecedenceFailed); err != nil {
+		log.WithError(err).Warn("unable to mark terminators failed before draining")
+	}
+
+	err := self.accepted.wait(ctx)
+	if err != nil {
+		log.WithError(err).Warn("listener did not drain in time, closing remaining connections")
+		self.accepted.closeAll()
+	}
+
+	if closeErr := self.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
 }
 
+// Close closes the listener and its child listeners. Only the first call has an effect, later calls return an
+// edge.AlreadyClosedError.
 func (self *multiListener) Close() error {
+	if err := self.closeGuard.Close("listener"); err != nil {
+		return err
+	}
 	self.closed.Store(true)
 
 	self.listenerLock.Lock()
@@ -410,7 +600,7 @@ func (self *multiListener) Close() error {
 
 	var resultErrors []error
 	for child := range self.listeners {
-		if err := child.Close(); err != nil {
+		if err := child.close(true); err != nil {
 			resultErrors = append(resultErrors, err)
 		}
 	}
@@ -436,6 +626,77 @@ func (self *multiListener) CloseWithError(err error) {
 	self.closed.Store(true)
 }
 
+// connTracker holds the connections accepted by a listener until they are closed, so that draining can wait for them.
+type connTracker struct {
+	lock    sync.Mutex
+	conns   map[edge.Conn]struct{}
+	pruneAt int
+}
+
+const minConnTrackerPruneSize = 64
+
+func (self *connTracker) add(conn edge.Conn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.conns == nil {
+		self.conns = map[edge.Conn]struct{}{}
+	}
+	self.conns[conn] = struct{}{}
+
+	// closed connections are only removed periodically, keeping the cost of tracking proportional to the active count
+	if len(self.conns) >= self.pruneAt {
+		self.prune()
+		self.pruneAt = 2 * len(self.conns)
+		if self.pruneAt < minConnTrackerPruneSize {
+			self.pruneAt = minConnTrackerPruneSize
+		}
+	}
+}
+
+func (self *connTracker) prune() {
+	for conn := range self.conns {
+		if conn.IsClosed() {
+			delete(self.conns, conn)
+		}
+	}
+}
+
+func (self *connTracker) active() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.prune()
+	return len(self.conns)
+}
+
+// wait waits until all tracked connections are closed, or ctx is done.
+func (self *connTracker) wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		active := self.active()
+		if active == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "%d connections still active", active)
+		}
+	}
+}
+
+func (self *connTracker) closeAll() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for conn := range self.conns {
+		_ = conn.Close()
+	}
+	self.conns = nil
+}
+
 type MultipleErrors []error
 
 func (e MultipleErrors) Error() string {
@@ -0,0 +1,196 @@
+package network
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type drainTestConn struct {
+	edge.Conn
+	closed atomic.Bool
+}
+
+func (self *drainTestConn) IsClosed() bool {
+	return self.closed.Load()
+}
+
+func (self *drainTestConn) Close() error {
+	self.closed.Store(true)
+	return nil
+}
+
+func newDrainTestListener(t *testing.T) (*multiListener, *drainTestConn) {
+	name := "svc"
+	listener := NewMultiListener(&rest_model.ServiceDetail{Name: &name}, nil).(*multiListener)
+
+	conn := &drainTestConn{}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	t.Cleanup(ticker.Stop)
+	go listener.accept(conn, ticker)
+
+	accepted, err := listener.AcceptEdge()
+	require.NoError(t, err)
+	require.Equal(t, conn, accepted)
+
+	return listener, conn
+}
+
+func TestMultiListenerDrain(t *testing.T) {
+	req := require.New(t)
+
+	listener, conn := newDrainTestListener(t)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- listener.Drain(context.Background())
+	}()
+
+	req.Eventually(func() bool { return listener.draining.Load() }, time.Second, 10*time.Millisecond)
+	_, err := listener.AcceptEdge()
+	req.Error(err)
+
+	// connections arriving while draining are refused
+	late := &drainTestConn{}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	listener.accept(late, ticker)
+	req.True(late.IsClosed())
+
+	select {
+	case err = <-drained:
+		req.Fail("drain returned with an active connection", "err: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	req.False(listener.IsClosed())
+
+	req.NoError(conn.Close())
+	select {
+	case err = <-drained:
+		req.NoError(err)
+	case <-time.After(2 * time.Second):
+		req.Fail("drain did not complete after the connection closed")
+	}
+	req.True(listener.IsClosed())
+	req.ErrorIs(listener.Drain(context.Background()), edge.ErrAlreadyClosed)
+}
+
+func TestMultiListenerDrainTimeout(t *testing.T) {
+	req := require.New(t)
+
+	listener, conn := newDrainTestListener(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := listener.Drain(ctx)
+	req.ErrorIs(err, context.DeadlineExceeded)
+	req.True(conn.IsClosed())
+	req.True(listener.IsClosed())
+}
+
+func TestEdgeListenerDrain(t *testing.T) {
+	req := require.New(t)
+
+	mux := edge.NewCowMapMsgMux()
+	edgeChan := &edgeConn{
+		MsgChannel:  *edge.NewEdgeMsgChannel(&wireTestChannel{}, 1),
+		readQ:       NewNoopSequencer[*channel.Message](4),
+		msgMux:      mux,
+		hosting:     cmap.New[*edgeListener](),
+		serviceName: "svc",
+	}
+	req.NoError(mux.AddMsgSink(edgeChan))
+
+	listener := &edgeListener{
+		baseListener: baseListener{
+			acceptC: make(chan edge.Conn, 2),
+			errorC:  make(chan error, 1),
+		},
+		token:    "token",
+		edgeChan: edgeChan,
+	}
+
+	conn := &drainTestConn{}
+	listener.acceptC <- conn
+	accepted, err := listener.AcceptEdge()
+	req.NoError(err)
+	req.Equal(conn, accepted)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- listener.Drain(context.Background())
+	}()
+
+	select {
+	case err = <-drained:
+		req.Fail("drain returned with an active connection", "err: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	req.False(listener.IsClosed())
+	_, err = listener.AcceptEdge()
+	req.Error(err)
+
+	req.NoError(conn.Close())
+	select {
+	case err = <-drained:
+		req.NoError(err)
+	case <-time.After(2 * time.Second):
+		req.Fail("drain did not complete after the connection closed")
+	}
+	req.True(listener.IsClosed())
+	req.ErrorIs(listener.Drain(context.Background()), edge.ErrAlreadyClosed)
+}
+
+type queueTestConn struct {
+	drainTestConn
+	id uint32
+}
+
+func (self *queueTestConn) Id() uint32 {
+	return self.id
+}
+
+func TestEdgeListenerAcceptQueueDropOldest(t *testing.T) {
+	req := require.New(t)
+
+	var shed atomic.Int32
+	listener := &edgeListener{
+		baseListener: baseListener{
+			acceptC: make(chan edge.Conn, acceptQueueSize(&edge.ListenOptions{AcceptQueueSize: 2})),
+		},
+		edgeChan:    &edgeConn{serviceName: "svc"},
+		queuePolicy: edge.AcceptQueueDropOldest,
+		onShed:      func() { shed.Add(1) },
+	}
+
+	var conns []*queueTestConn
+	for i := 0; i < 4; i++ {
+		conn := &queueTestConn{id: uint32(i)}
+		conns = append(conns, conn)
+		listener.enqueue(conn)
+	}
+
+	req.True(listener.isQueueFull())
+	req.Equal(int32(2), shed.Load())
+	req.Equal(uint64(2), listener.shedCount.Load())
+	req.True(conns[0].IsClosed())
+	req.True(conns[1].IsClosed())
+
+	req.Equal(conns[2], <-listener.acceptC)
+	req.Equal(conns[3], <-listener.acceptC)
+	req.False(conns[2].IsClosed())
+
+	multi := NewMultiListener(&rest_model.ServiceDetail{}, nil).(*multiListener)
+	multi.listeners[listener] = struct{}{}
+	listener.enqueue(&queueTestConn{id: 5})
+	req.Equal(AcceptQueueStats{Queued: 1, Capacity: 2, Shed: 2}, multi.GetAcceptQueueStats())
+	req.Equal(edge.DefaultAcceptQueueSize, acceptQueueSize(&edge.ListenOptions{}))
+}
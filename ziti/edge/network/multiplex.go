@@ -0,0 +1,60 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package network
+
+import (
+	"github.com/openziti/channel/v2"
+	"github.com/pkg/errors"
+)
+
+// DefaultReadQueueSize is the number of received messages buffered per edge connection when
+// MultiplexConfig.ReadQueueSize is not set.
+const DefaultReadQueueSize = 4
+
+// ErrMaxCircuits is returned when dialing over a router connection that already carries MultiplexConfig.MaxCircuits
+// edge connections.
+var ErrMaxCircuits = errors.New("router connection has the maximum number of circuits")
+
+// MultiplexOwner may be implemented by a RouterConnOwner to tune how edge connections are multiplexed over its router
+// connections. Returning nil keeps the defaults.
+type MultiplexOwner interface {
+	GetMultiplexConfig() *MultiplexConfig
+}
+
+// MultiplexConfig tunes the edge connections multiplexed over a router connection.
+type MultiplexConfig struct {
+	// MaxCircuits limits the edge connections open at once over the router connection, including listeners. Dials
+	// beyond it fail with ErrMaxCircuits and dials to hosted services are rejected. If zero, there is no limit.
+	MaxCircuits int
+
+	// ReadQueueSize is the number of received messages buffered per edge connection before the router connection
+	// stops reading until the application catches up. Since a full queue stalls every edge connection sharing the
+	// router connection, larger queues favor bulk transfers, while small ones keep memory use and latency low.
+	ReadQueueSize int
+}
+
+func (self *MultiplexConfig) isAtCapacity(activeCount int) bool {
+	return self != nil && self.MaxCircuits > 0 && activeCount >= self.MaxCircuits
+}
+
+func (self *MultiplexConfig) newReadQueue() *noopSeq[*channel.Message] {
+	size := DefaultReadQueueSize
+	if self != nil && self.ReadQueueSize > 0 {
+		size = self.ReadQueueSize
+	}
+	return NewNoopSequencer[*channel.Message](size)
+}
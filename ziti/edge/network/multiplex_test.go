@@ -0,0 +1,40 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_routerConn_multiplexConfig(t *testing.T) {
+	req := require.New(t)
+
+	conn := &routerConn{
+		routerName: "test",
+		msgMux:     edge.NewCowMapMsgMux(),
+		multiplex:  &MultiplexConfig{MaxCircuits: 1, ReadQueueSize: 32},
+	}
+
+	name, id, encryptionRequired := "svc", "svc-id", false
+	service := &rest_model.ServiceDetail{}
+	service.Name = &name
+	service.ID = &id
+	service.EncryptionRequired = &encryptionRequired
+
+	req.False(conn.IsAtCapacity())
+	edgeCh := conn.NewDialConn(service)
+	req.Equal(32, cap(edgeCh.readQ.ch))
+	req.True(conn.IsAtCapacity())
+
+	_, err := conn.Connect(service, nil, &edge.DialOptions{})
+	req.True(errors.Is(err, ErrMaxCircuits))
+
+	conn.msgMux.RemoveMsgSink(edgeCh)
+	req.False(conn.IsAtCapacity())
+
+	req.Equal(DefaultReadQueueSize, cap((*MultiplexConfig)(nil).newReadQueue().ch))
+	req.False((*MultiplexConfig)(nil).isAtCapacity(100))
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"github.com/openziti/foundation/v2/concurrenz"
 	"github.com/pkg/errors"
+	"os"
 	"sync/atomic"
 	"time"
 )
@@ -23,6 +24,11 @@ func (r ReadTimout) Temporary() bool {
 	return true
 }
 
+// Is makes read timeouts match os.ErrDeadlineExceeded, as they do for other net.Conn implementations.
+func (r ReadTimout) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
+}
+
 func NewNoopSequencer[T any](channelDepth int) *noopSeq[T] {
 	return &noopSeq[T]{
 		ch:             make(chan T, channelDepth),
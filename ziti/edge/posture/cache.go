@@ -64,7 +64,9 @@ type Cache struct {
 	startOnce           sync.Once
 	doSingleSubmissions bool
 	closeNotify         <-chan struct{}
+	provider            concurrenz.AtomicValue[Provider]
 
+	// Deprecated: DomainFunc, if set, overrides the domain reported by the Provider. Use SetProvider instead.
 	DomainFunc func() string
 	lock       sync.Mutex
 }
@@ -79,7 +81,6 @@ func NewCache(submitter Submitter, closeNotify <-chan struct{}) *Cache {
 		ctrlClient:       submitter,
 		startOnce:        sync.Once{},
 		closeNotify:      closeNotify,
-		DomainFunc:       Domain,
 	}
 	cache.serviceQueryMap.Store(map[string]map[string]rest_model.PostureQuery{})
 	cache.start()
@@ -217,19 +218,38 @@ func (cache *Cache) GetChangedResponses() []rest_model.PostureResponseCreate {
 	return responses
 }
 
+// SetProvider replaces the Provider of the posture data, which is a HostProvider by default. The data is collected
+// from the new provider on the next evaluation, and responses are only submitted for data that changed.
+func (cache *Cache) SetProvider(provider Provider) {
+	cache.provider.Store(provider)
+}
+
+func (cache *Cache) getProvider() Provider {
+	if provider := cache.provider.Load(); provider != nil {
+		return provider
+	}
+	return HostProvider{}
+}
+
 // Refresh refreshes posture data
 func (cache *Cache) Refresh() {
+	provider := cache.getProvider()
+
 	cache.previousData = cache.currentData
 
 	cache.currentData = NewCacheData()
-	cache.currentData.Os = Os()
+	cache.currentData.Os = provider.Os()
 
-	cache.currentData.Domain = cache.DomainFunc()
-	cache.currentData.MacAddresses = MacAddresses()
+	if cache.DomainFunc != nil {
+		cache.currentData.Domain = cache.DomainFunc()
+	} else {
+		cache.currentData.Domain = provider.Domain()
+	}
+	cache.currentData.MacAddresses = provider.MacAddresses()
 
 	keys := cache.watchedProcesses.Keys()
 	for _, processPath := range keys {
-		cache.currentData.Processes.Set(processPath, Process(processPath))
+		cache.currentData.Processes.Set(processPath, provider.Process(processPath))
 	}
 }
 
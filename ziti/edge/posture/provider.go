@@ -0,0 +1,91 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package posture
+
+// Provider supplies the posture data a Cache submits to the controller for the posture checks of the services in
+// use. Implementations must be safe for concurrent use.
+type Provider interface {
+	Os() OsInfo
+	MacAddresses() []string
+	Domain() string
+	Process(path string) ProcessInfo
+}
+
+// HostProvider gathers posture data from the host the process runs on. It is the default Provider of a Cache.
+type HostProvider struct{}
+
+func (HostProvider) Os() OsInfo {
+	return Os()
+}
+
+func (HostProvider) MacAddresses() []string {
+	return MacAddresses()
+}
+
+func (HostProvider) Domain() string {
+	return Domain()
+}
+
+func (HostProvider) Process(path string) ProcessInfo {
+	return Process(path)
+}
+
+// OverrideProvider reports fixed posture data where set and delegates the rest to Provider, or to HostProvider if
+// Provider is nil. It is meant for testing posture checks without changing the host.
+type OverrideProvider struct {
+	Provider Provider
+
+	OsInfo     *OsInfo
+	Macs       []string
+	DomainName *string
+	Processes  map[string]ProcessInfo
+}
+
+func (self *OverrideProvider) next() Provider {
+	if self.Provider == nil {
+		return HostProvider{}
+	}
+	return self.Provider
+}
+
+func (self *OverrideProvider) Os() OsInfo {
+	if self.OsInfo != nil {
+		return *self.OsInfo
+	}
+	return self.next().Os()
+}
+
+func (self *OverrideProvider) MacAddresses() []string {
+	if self.Macs != nil {
+		return self.Macs
+	}
+	return self.next().MacAddresses()
+}
+
+func (self *OverrideProvider) Domain() string {
+	if self.DomainName != nil {
+		return *self.DomainName
+	}
+	return self.next().Domain()
+}
+
+func (self *OverrideProvider) Process(path string) ProcessInfo {
+	if info, found := self.Processes[path]; found {
+		return info
+	}
+	return self.next().Process(path)
+}
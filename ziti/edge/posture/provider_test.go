@@ -0,0 +1,121 @@
+package posture
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+type testSubmitter struct {
+	sync.Mutex
+	responses []rest_model.PostureResponseCreate
+}
+
+func (self *testSubmitter) SendPostureResponse(response rest_model.PostureResponseCreate) error {
+	self.Lock()
+	defer self.Unlock()
+	self.responses = append(self.responses, response)
+	return nil
+}
+
+func (self *testSubmitter) SendPostureResponseBulk(responses []rest_model.PostureResponseCreate) error {
+	self.Lock()
+	defer self.Unlock()
+	self.responses = append(self.responses, responses...)
+	return nil
+}
+
+func (self *testSubmitter) take() []rest_model.PostureResponseCreate {
+	self.Lock()
+	defer self.Unlock()
+	result := self.responses
+	self.responses = nil
+	return result
+}
+
+func newTestQuery(queryType rest_model.PostureCheckType, process *rest_model.PostureQueryProcess) rest_model.PostureQuery {
+	return rest_model.PostureQuery{
+		QueryType: &queryType,
+		Process:   process,
+	}
+}
+
+func TestCacheUsesProvider(t *testing.T) {
+	req := require.New(t)
+
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	submitter := &testSubmitter{}
+	cache := NewCache(submitter, closeNotify)
+
+	domain := "example.com"
+	provider := &OverrideProvider{
+		Provider:   HostProvider{},
+		OsInfo:     &OsInfo{Type: "Linux", Version: "6.1.0"},
+		Macs:       []string{"00:11:22:33:44:55"},
+		DomainName: &domain,
+		Processes: map[string]ProcessInfo{
+			"/usr/bin/agent": {IsRunning: true, Hash: "abc"},
+		},
+	}
+	cache.SetProvider(provider)
+
+	cache.SetServiceQueryMap(map[string]map[string]rest_model.PostureQuery{
+		"svc": {
+			"os":      newTestQuery(rest_model.PostureCheckTypeOS, nil),
+			"mac":     newTestQuery(rest_model.PostureCheckTypeMAC, nil),
+			"domain":  newTestQuery(rest_model.PostureCheckTypeDOMAIN, nil),
+			"process": newTestQuery(rest_model.PostureCheckTypePROCESS, &rest_model.PostureQueryProcess{Path: "/usr/bin/agent"}),
+		},
+	})
+	cache.AddActiveService("svc")
+
+	responses := map[string]rest_model.PostureResponseCreate{}
+	for _, response := range submitter.take() {
+		responses[*response.ID()] = response
+	}
+	req.Len(responses, 4)
+
+	osResponse := responses["os"].(*rest_model.PostureResponseOperatingSystemCreate)
+	req.Equal("Linux", *osResponse.Type)
+	req.Equal("6.1.0", *osResponse.Version)
+
+	req.Equal([]string{"00:11:22:33:44:55"}, responses["mac"].(*rest_model.PostureResponseMacAddressCreate).MacAddresses)
+	req.Equal(domain, *responses["domain"].(*rest_model.PostureResponseDomainCreate).Domain)
+
+	processResponse := responses["process"].(*rest_model.PostureResponseProcessCreate)
+	req.True(processResponse.IsRunning)
+	req.Equal("abc", processResponse.Hash)
+
+	// unchanged data isn't resubmitted
+	cache.Evaluate()
+	req.Empty(submitter.take())
+
+	provider.Processes["/usr/bin/agent"] = ProcessInfo{IsRunning: false}
+	cache.Evaluate()
+	changed := submitter.take()
+	req.Len(changed, 1)
+	req.Equal("process", *changed[0].ID())
+	req.False(changed[0].(*rest_model.PostureResponseProcessCreate).IsRunning)
+}
+
+func TestOverrideProviderFallsBack(t *testing.T) {
+	req := require.New(t)
+
+	domain := "fallback.example.com"
+	fallback := &OverrideProvider{
+		OsInfo:     &OsInfo{Type: "Windows", Version: "10.0.19045"},
+		DomainName: &domain,
+	}
+	provider := &OverrideProvider{
+		Provider: fallback,
+		Macs:     []string{},
+	}
+
+	req.Equal("Windows", provider.Os().Type)
+	req.Equal(domain, provider.Domain())
+	req.Empty(provider.MacAddresses())
+}
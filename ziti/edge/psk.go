@@ -0,0 +1,262 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pskSaltSize     = 16
+	pskMaxFrameSize = 64 * 1024
+	pskKeyLabel     = "ziti-psk-v1"
+	pskAadSize      = 9
+
+	// pskDialerToHost and pskHostToDialer mark the direction a frame was sent in, so that frames reflected back to
+	// their writer fail authentication.
+	pskDialerToHost byte = 1
+	pskHostToDialer byte = 2
+)
+
+// NewPskConn wraps the dialing side of a Conn with an application layer AES-GCM encryption overlay keyed by a
+// pre-shared key. The hosting side must accept the connection from a Listener wrapped by NewPskListener using the same
+// key. The overlay is independent of, and layered above, any end-to-end encryption the SDK negotiates.
+//
+// Each direction derives its own key from the PSK and a random salt sent by the writer ahead of the first frame, so
+// the same PSK can be safely used for many connections. Every frame is authenticated together with its direction and
+// sequence number, so frames reflected back to their writer, replayed or reordered are rejected.
+func NewPskConn(conn Conn, psk []byte) (Conn, error) {
+	return newPskConn(conn, psk, false)
+}
+
+func newPskConn(conn Conn, psk []byte, hosting bool) (Conn, error) {
+	if len(psk) == 0 {
+		return nil, errors.New("pre-shared key must not be empty")
+	}
+
+	result := &pskConn{
+		Conn:           conn,
+		psk:            psk,
+		writeDirection: pskDialerToHost,
+		readDirection:  pskHostToDialer,
+	}
+	if hosting {
+		result.writeDirection, result.readDirection = pskHostToDialer, pskDialerToHost
+	}
+	return result, nil
+}
+
+type pskConn struct {
+	Conn
+	psk []byte
+
+	writeLock      sync.Mutex
+	sender         cipher.AEAD
+	writeCounter   uint64
+	writeDirection byte
+
+	readLock      sync.Mutex
+	receiver      cipher.AEAD
+	readCounter   uint64
+	readDirection byte
+	leftover      []byte
+}
+
+func (conn *pskConn) newAead(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, conn.psk)
+	mac.Write([]byte(pskKeyLabel))
+	mac.Write(salt)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func pskNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+// pskAad returns the additional data a frame is authenticated with, binding it to its direction and sequence number.
+func pskAad(direction byte, counter uint64) []byte {
+	aad := make([]byte, pskAadSize)
+	aad[0] = direction
+	binary.BigEndian.PutUint64(aad[1:], counter)
+	return aad
+}
+
+func (conn *pskConn) Write(data []byte) (int, error) {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
+	if conn.sender == nil {
+		salt := make([]byte, pskSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return 0, errors.Wrap(err, "unable to generate pre-shared key salt")
+		}
+
+		sender, err := conn.newAead(salt)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to initialize pre-shared key encryption")
+		}
+
+		if _, err = conn.Conn.Write(salt); err != nil {
+			return 0, err
+		}
+		conn.sender = sender
+	}
+
+	written := 0
+	for written < len(data) {
+		chunk := data[written:]
+		if len(chunk) > pskMaxFrameSize {
+			chunk = chunk[:pskMaxFrameSize]
+		}
+
+		sealed := conn.sender.Seal(nil, pskNonce(conn.sender, conn.writeCounter), chunk, pskAad(conn.writeDirection, conn.writeCounter))
+		conn.writeCounter++
+
+		frame := make([]byte, 4+len(sealed))
+		binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+		copy(frame[4:], sealed)
+
+		if _, err := conn.Conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+
+	return written, nil
+}
+
+func (conn *pskConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	conn.readLock.Lock()
+	defer conn.readLock.Unlock()
+
+	if len(conn.leftover) > 0 {
+		n := copy(p, conn.leftover)
+		conn.leftover = conn.leftover[n:]
+		return n, nil
+	}
+
+	if conn.receiver == nil {
+		salt := make([]byte, pskSaltSize)
+		if _, err := io.ReadFull(conn.Conn, salt); err != nil {
+			return 0, err
+		}
+
+		receiver, err := conn.newAead(salt)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to initialize pre-shared key decryption")
+		}
+		conn.receiver = receiver
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn.Conn, header); err != nil {
+		return 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > pskMaxFrameSize+uint32(conn.receiver.Overhead()) {
+		return 0, errors.Errorf("pre-shared key frame too large: %d bytes", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(conn.Conn, sealed); err != nil {
+		return 0, err
+	}
+
+	plain, err := conn.receiver.Open(sealed[:0], pskNonce(conn.receiver, conn.readCounter), sealed, pskAad(conn.readDirection, conn.readCounter))
+	if err != nil {
+		return 0, errors.Wrap(err, "pre-shared key authentication failed")
+	}
+	conn.readCounter++
+
+	n := copy(p, plain)
+	conn.leftover = plain[n:]
+	return n, nil
+}
+
+// NewPskListener wraps a Listener so that every accepted Conn is wrapped with NewPskConn using the given key. If the
+// provided Listener is a SessionListener, the returned Listener will be as well.
+func NewPskListener(listener Listener, psk []byte) (Listener, error) {
+	if len(psk) == 0 {
+		return nil, errors.New("pre-shared key must not be empty")
+	}
+
+	result := &pskListener{
+		Listener: listener,
+		psk:      psk,
+	}
+
+	if sessionListener, ok := listener.(SessionListener); ok {
+		return &pskSessionListener{
+			pskListener:     result,
+			SessionListener: sessionListener,
+		}, nil
+	}
+
+	return result, nil
+}
+
+type pskListener struct {
+	Listener
+	psk []byte
+}
+
+func (listener *pskListener) Accept() (net.Conn, error) {
+	return listener.AcceptEdge()
+}
+
+func (listener *pskListener) AcceptEdge() (Conn, error) {
+	conn, err := listener.Listener.AcceptEdge()
+	if err != nil {
+		return nil, err
+	}
+	return newPskConn(conn, listener.psk, true)
+}
+
+type pskSessionListener struct {
+	*pskListener
+	SessionListener
+}
+
+func (listener *pskSessionListener) Accept() (net.Conn, error) {
+	return listener.pskListener.Accept()
+}
+
+func (listener *pskSessionListener) AcceptEdge() (Conn, error) {
+	return listener.pskListener.AcceptEdge()
+}
@@ -0,0 +1,130 @@
+package edge
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type pipeConn struct {
+	Conn
+	pipe net.Conn
+}
+
+func (conn *pipeConn) Read(p []byte) (int, error) {
+	return conn.pipe.Read(p)
+}
+
+func (conn *pipeConn) Write(p []byte) (int, error) {
+	return conn.pipe.Write(p)
+}
+
+func (conn *pipeConn) RemoteAddr() net.Addr {
+	return conn.pipe.RemoteAddr()
+}
+
+func (conn *pipeConn) Close() error {
+	return conn.pipe.Close()
+}
+
+func newPskPipe(t *testing.T, dialKey, hostKey []byte) (Conn, Conn) {
+	left, right := net.Pipe()
+	t.Cleanup(func() {
+		_ = left.Close()
+		_ = right.Close()
+	})
+
+	dialer, err := NewPskConn(&pipeConn{pipe: left}, dialKey)
+	require.NoError(t, err)
+
+	host, err := newPskConn(&pipeConn{pipe: right}, hostKey, true)
+	require.NoError(t, err)
+
+	return dialer, host
+}
+
+func TestPskConn_RoundTrip(t *testing.T) {
+	req := require.New(t)
+	dialer, host := newPskPipe(t, []byte("secret"), []byte("secret"))
+
+	payload := bytes.Repeat([]byte("0123456789"), pskMaxFrameSize/5)
+
+	go func() {
+		_, _ = dialer.Write(payload)
+	}()
+
+	received := make([]byte, len(payload))
+	_, err := io.ReadFull(host, received)
+	req.NoError(err)
+	req.Equal(payload, received)
+
+	go func() {
+		_, _ = host.Write([]byte("reply"))
+	}()
+
+	reply := make([]byte, 5)
+	_, err = io.ReadFull(dialer, reply)
+	req.NoError(err)
+	req.Equal("reply", string(reply))
+}
+
+func TestPskConn_KeyMismatch(t *testing.T) {
+	dialer, host := newPskPipe(t, []byte("secret"), []byte("other"))
+
+	go func() {
+		_, _ = dialer.Write([]byte("hello"))
+	}()
+
+	_, err := host.Read(make([]byte, 5))
+	require.Error(t, err)
+}
+
+func TestPskConn_ReflectedFrames(t *testing.T) {
+	req := require.New(t)
+
+	// a stand-in for a peer echoing back whatever the dialer sends
+	left, right := net.Pipe()
+	t.Cleanup(func() {
+		_ = left.Close()
+		_ = right.Close()
+	})
+	go func() {
+		_, _ = io.Copy(right, right)
+	}()
+
+	dialer, err := NewPskConn(&pipeConn{pipe: left}, []byte("secret"))
+	req.NoError(err)
+
+	go func() {
+		_, _ = dialer.Write([]byte("hello"))
+	}()
+
+	_, err = dialer.Read(make([]byte, 5))
+	req.ErrorContains(err, "authentication failed")
+}
+
+func TestPskConn_ZeroLengthRead(t *testing.T) {
+	req := require.New(t)
+	dialer, host := newPskPipe(t, []byte("secret"), []byte("secret"))
+
+	go func() {
+		_, _ = dialer.Write([]byte("hello"))
+	}()
+
+	n, err := host.Read(nil)
+	req.NoError(err)
+	req.Zero(n)
+
+	received := make([]byte, 5)
+	_, err = io.ReadFull(host, received)
+	req.NoError(err)
+	req.Equal("hello", string(received), "the zero-length read consumed no data")
+}
+
+func TestPskConn_EmptyKey(t *testing.T) {
+	_, err := NewPskConn(nil, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,72 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package edge
+
+import "sync/atomic"
+
+// TrafficCounter accumulates the number of payload bytes read from and written to edge connections. A nil
+// TrafficCounter ignores all updates.
+type TrafficCounter struct {
+	rx     atomic.Uint64
+	tx     atomic.Uint64
+	parent *TrafficCounter
+}
+
+// NewTrafficCounter returns a TrafficCounter that also counts its bytes towards parent, if not nil.
+func NewTrafficCounter(parent *TrafficCounter) *TrafficCounter {
+	return &TrafficCounter{parent: parent}
+}
+
+// Parent returns the counter this counter also counts its bytes towards, if any.
+func (self *TrafficCounter) Parent() *TrafficCounter {
+	if self == nil {
+		return nil
+	}
+	return self.parent
+}
+
+func (self *TrafficCounter) AddRx(n int) {
+	if n > 0 {
+		for counter := self; counter != nil; counter = counter.parent {
+			counter.rx.Add(uint64(n))
+		}
+	}
+}
+
+func (self *TrafficCounter) AddTx(n int) {
+	if n > 0 {
+		for counter := self; counter != nil; counter = counter.parent {
+			counter.tx.Add(uint64(n))
+		}
+	}
+}
+
+// RxBytes returns the number of bytes read from connections.
+func (self *TrafficCounter) RxBytes() uint64 {
+	if self == nil {
+		return 0
+	}
+	return self.rx.Load()
+}
+
+// TxBytes returns the number of bytes written to connections.
+func (self *TrafficCounter) TxBytes() uint64 {
+	if self == nil {
+		return 0
+	}
+	return self.tx.Load()
+}
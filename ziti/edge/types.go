@@ -8,10 +8,16 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slices"
 	"net"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const InterceptV1 = "intercept.v1"
+const (
+	InterceptV1 = "intercept.v1"
+	HostV1      = "host.v1"
+)
 
 type InterceptV1Config struct {
 	Addresses   []ZitiAddress
@@ -31,8 +37,7 @@ func (intercept *InterceptV1Config) Match(network, hostname string, port uint16)
 	}
 
 	var target any
-	ip := net.ParseIP(hostname)
-	if len(ip) != 0 {
+	if ip, ok := ParseHostIP(hostname); ok {
 		target = ip
 	} else {
 		target = hostname
@@ -82,37 +87,96 @@ func (intercept *InterceptV1Config) Match(network, hostname string, port uint16)
 	return int(uint(addrScore)<<16 | (uint(portScore) & 0xFFFF))
 }
 
+// ParseHostIP parses host as an IP address, accepting IPv6 literals in brackets, as in URLs, and with zones, which
+// are dropped. IPv4-mapped IPv6 addresses are returned as IPv4 addresses.
+func ParseHostIP(host string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(trimHostBrackets(host))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.WithZone("").Unmap(), true
+}
+
+func trimHostBrackets(host string) string {
+	if len(host) > 2 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
 type ZitiAddress struct {
-	cidr   *net.IPNet
-	ip     net.IP
+	cidr   netip.Prefix
+	ip     netip.Addr
 	domain DomainName
 }
 
+// NewZitiAddressFromPrefix returns a ZitiAddress matching the IP addresses in prefix.
+func NewZitiAddressFromPrefix(prefix netip.Prefix) *ZitiAddress {
+	return &ZitiAddress{cidr: prefix.Masked()}
+}
+
+// NewZitiAddressFromAddr returns a ZitiAddress matching ip.
+func NewZitiAddressFromAddr(ip netip.Addr) *ZitiAddress {
+	return &ZitiAddress{ip: ip.WithZone("").Unmap()}
+}
+
+// Matches returns the matching score of v, an IP address given as a netip.Addr, net.IP or string, or a host name,
+// against the address. A negative one (-1) is returned if v doesn't match, zero for an exact match, and otherwise the
+// number of host bits of a matching CIDR or the length of the prefix matched by a wildcard domain.
 func (self *ZitiAddress) Matches(v any) int {
-	if ip, ok := v.(net.IP); ok {
-		if self.ip != nil {
-			if ip.Equal(self.ip) {
-				return 0
-			} else {
-				return -1
-			}
+	switch target := v.(type) {
+	case netip.Addr:
+		return self.matchesIp(target.WithZone("").Unmap())
+	case net.IP:
+		if ip, ok := netip.AddrFromSlice(target); ok {
+			return self.matchesIp(ip.Unmap())
+		}
+	case string:
+		if ip, ok := ParseHostIP(target); ok {
+			return self.matchesIp(ip)
 		}
+		return self.domain.Match(strings.ToLower(target))
+	}
 
-		if self.cidr != nil {
-			if self.cidr.Contains(ip) {
-				ones, bits := self.cidr.Mask.Size()
-				return bits - ones
-			} else {
-				return -1
-			}
+	return -1
+}
+
+func (self *ZitiAddress) matchesIp(ip netip.Addr) int {
+	if self.ip.IsValid() {
+		if ip == self.ip {
+			return 0
 		}
-	} else if hostname, ok := v.(string); ok {
-		return self.domain.Match(strings.ToLower(hostname))
+		return -1
 	}
 
+	if self.cidr.IsValid() {
+		if self.cidr.Contains(ip) || (ip.Is4() && self.cidr.Contains(netip.AddrFrom16(ip.As16()))) {
+			return self.cidr.Addr().BitLen() - self.cidr.Bits()
+		}
+	}
 	return -1
 }
 
+// Prefix returns the IP range of the address, if it is a CIDR.
+func (self *ZitiAddress) Prefix() (netip.Prefix, bool) {
+	return self.cidr, self.cidr.IsValid()
+}
+
+// Addr returns the IP of the address, if it is an IP address.
+func (self *ZitiAddress) Addr() (netip.Addr, bool) {
+	return self.ip, self.ip.IsValid()
+}
+
+func (self ZitiAddress) String() string {
+	if self.ip.IsValid() {
+		return self.ip.String()
+	}
+	if self.cidr.IsValid() {
+		return self.cidr.String()
+	}
+	return string(self.domain)
+}
+
 type DomainName string
 
 func (dn DomainName) Match(hostname string) int {
@@ -215,12 +279,14 @@ func NewZitiAddress(str string) (*ZitiAddress, error) {
 
 func (self *ZitiAddress) UnmarshalText(data []byte) error {
 	v := string(data)
-	if _, cidr, err := net.ParseCIDR(v); err == nil {
-		self.cidr = cidr
-		return nil
+	if addr, bits, found := strings.Cut(v, "/"); found {
+		if prefix, err := netip.ParsePrefix(trimHostBrackets(addr) + "/" + bits); err == nil {
+			self.cidr = prefix.Masked()
+			return nil
+		}
 	}
 
-	if ip := net.ParseIP(v); ip != nil {
+	if ip, ok := ParseHostIP(v); ok {
 		self.ip = ip
 		return nil
 	}
@@ -238,3 +304,113 @@ func (self *ZitiAddress) UnmarshalText(data []byte) error {
 	self.domain = DomainName(strings.ToLower(v))
 	return nil
 }
+
+// HostV1Config is the host.v1 config of a service, describing where a hosting application forwards the connections
+// it accepts for the service. Each of protocol, address and port is either fixed, or forwarded from the intercepted
+// destination if the matching Forward field is set, within the allowed values.
+type HostV1Config struct {
+	Protocol               string
+	ForwardProtocol        bool
+	AllowedProtocols       []string
+	Address                string
+	ForwardAddress         bool
+	AllowedAddresses       []ZitiAddress
+	Port                   int
+	ForwardPort            bool
+	AllowedPortRanges      []*PortRange
+	AllowedSourceAddresses []ZitiAddress
+	ListenOptions          *HostV1ListenOptions
+	PortChecks             []*HostV1PortCheck
+	HttpChecks             []*HostV1HttpCheck
+}
+
+// HostV1ListenOptions are the options a service is bound with according to its host.v1 config.
+type HostV1ListenOptions struct {
+	BindUsingEdgeIdentity bool
+	ConnectTimeout        time.Duration
+	Cost                  uint16
+	Identity              string
+	MaxConnections        int
+	Precedence            string
+}
+
+// HostV1PortCheck checks that a TCP address accepts connections.
+type HostV1PortCheck struct {
+	Address  string
+	Interval time.Duration
+	Timeout  time.Duration
+	Actions  []*HostV1CheckAction
+}
+
+// HostV1HttpCheck checks that a HTTP endpoint responds as expected.
+type HostV1HttpCheck struct {
+	Url          string
+	Method       string
+	Body         string
+	ExpectStatus int
+	ExpectInBody string
+	Interval     time.Duration
+	Timeout      time.Duration
+	Actions      []*HostV1CheckAction
+}
+
+// HostV1CheckAction is taken when a health check passes or fails, such as changing the terminator cost.
+type HostV1CheckAction struct {
+	Trigger           string
+	ConsecutiveEvents uint16
+	Duration          time.Duration
+	Action            string
+}
+
+// Target returns the protocol and address, as host:port, that a connection intercepted with the given destination
+// should be forwarded to. The destination is only used for the forwarded parts, and an error is returned if it is
+// outside the allowed values.
+func (self *HostV1Config) Target(protocol, address string, port uint16) (string, string, error) {
+	if self.ForwardProtocol {
+		if !slices.Contains(self.AllowedProtocols, protocol) {
+			return "", "", errors.Errorf("protocol '%s' is not allowed", protocol)
+		}
+	} else {
+		protocol = self.Protocol
+	}
+
+	if self.ForwardAddress {
+		target := any(address)
+		if ip, ok := ParseHostIP(address); ok {
+			target = ip
+			address = ip.String()
+		}
+		allowed := false
+		for i := range self.AllowedAddresses {
+			if self.AllowedAddresses[i].Matches(target) != -1 {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", "", errors.Errorf("address '%s' is not allowed", address)
+		}
+	} else {
+		address = self.Address
+		if ip, ok := ParseHostIP(address); ok {
+			address = ip.String()
+		}
+	}
+
+	if self.ForwardPort {
+		allowed := false
+		for _, portRange := range self.AllowedPortRanges {
+			if portRange.Match(port) != -1 {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", "", errors.Errorf("port %d is not allowed", port)
+		}
+	} else {
+		port = uint16(self.Port)
+	}
+
+	return protocol, net.JoinHostPort(address, strconv.Itoa(int(port))), nil
+}
@@ -0,0 +1,112 @@
+package edge
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostV1Config(t *testing.T) {
+	req := require.New(t)
+
+	name := "svc"
+	service := &rest_model.ServiceDetail{
+		Name: &name,
+		Config: map[string]map[string]interface{}{
+			HostV1: {
+				"protocol":          "tcp",
+				"forwardAddress":    true,
+				"allowedAddresses":  []string{"10.0.0.0/8", "*.internal"},
+				"forwardPort":       true,
+				"allowedPortRanges": []map[string]interface{}{{"low": 8000, "high": 8100}},
+				"listenOptions": map[string]interface{}{
+					"connectTimeout": "5s",
+					"cost":           10,
+					"precedence":     "required",
+				},
+				"portChecks": []map[string]interface{}{{
+					"address":  "localhost:8080",
+					"interval": "10s",
+					"actions":  []map[string]interface{}{{"trigger": "fail", "action": "mark unhealthy"}},
+				}},
+			},
+		},
+	}
+	service.ID = service.Name
+
+	config := &HostV1Config{}
+	found, err := ParseServiceConfig(service, HostV1, config)
+	req.NoError(err)
+	req.True(found)
+	req.Equal(5*time.Second, config.ListenOptions.ConnectTimeout)
+	req.Equal(uint16(10), config.ListenOptions.Cost)
+	req.Equal(10*time.Second, config.PortChecks[0].Interval)
+	req.Equal("mark unhealthy", config.PortChecks[0].Actions[0].Action)
+
+	protocol, addr, err := config.Target("udp", "10.1.2.3", 8080)
+	req.NoError(err)
+	req.Equal("tcp", protocol)
+	req.Equal("10.1.2.3:8080", addr)
+
+	_, addr, err = config.Target("tcp", "db.internal", 8100)
+	req.NoError(err)
+	req.Equal("db.internal:8100", addr)
+
+	_, _, err = config.Target("tcp", "192.168.1.1", 8080)
+	req.Error(err)
+
+	_, _, err = config.Target("tcp", "10.1.2.3", 9000)
+	req.Error(err)
+}
+
+func TestZitiAddress_ipv6(t *testing.T) {
+	req := require.New(t)
+
+	address, err := NewZitiAddress("[2001:db8::1]")
+	req.NoError(err)
+	ip, ok := address.Addr()
+	req.True(ok)
+	req.Equal(netip.MustParseAddr("2001:db8::1"), ip)
+	req.Equal(0, address.Matches("2001:db8:0::1"))
+	req.Equal(0, address.Matches("[2001:db8::1]"))
+	req.Equal(0, address.Matches("2001:db8::1%eth0"))
+	req.Equal(0, address.Matches(net.ParseIP("2001:db8::1")))
+	req.Equal(-1, address.Matches("2001:db8::2"))
+
+	address, err = NewZitiAddress("[2001:db8::]/32")
+	req.NoError(err)
+	prefix, ok := address.Prefix()
+	req.True(ok)
+	req.Equal(netip.MustParsePrefix("2001:db8::/32"), prefix)
+	req.Equal(96, address.Matches(netip.MustParseAddr("2001:db8:1::1")))
+	req.Equal(-1, address.Matches(netip.MustParseAddr("2001:db9::1")))
+
+	address = NewZitiAddressFromPrefix(netip.MustParsePrefix("10.1.2.3/8"))
+	req.Equal("10.0.0.0/8", address.String())
+	req.Equal(24, address.Matches("::ffff:10.1.2.3"))
+	req.Equal(24, address.Matches(netip.MustParseAddr("10.1.2.3")))
+
+	address = NewZitiAddressFromAddr(netip.MustParseAddr("::ffff:10.1.2.3"))
+	req.Equal(0, address.Matches("10.1.2.3"))
+
+	intercept := &InterceptV1Config{
+		Addresses:  []ZitiAddress{*NewZitiAddressFromPrefix(netip.MustParsePrefix("fd00::/8"))},
+		PortRanges: []*PortRange{{Low: 80, High: 80}},
+		Protocols:  []string{"tcp"},
+	}
+	req.NotEqual(-1, intercept.Match("tcp", "[fd00::10]", 80))
+	req.Equal(-1, intercept.Match("tcp", "fe00::10", 80))
+
+	host := &HostV1Config{
+		ForwardAddress:   true,
+		AllowedAddresses: []ZitiAddress{*NewZitiAddressFromPrefix(netip.MustParsePrefix("fd00::/8"))},
+		Port:             8080,
+	}
+	_, target, err := host.Target("tcp", "[fd00::10]", 80)
+	req.NoError(err)
+	req.Equal("[fd00::10]:8080", target)
+}
@@ -0,0 +1,383 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// DefaultSubscriptionBufferSize is the number of events buffered for a Subscription if SubscriptionOptions.BufferSize
+// is not set.
+const DefaultSubscriptionBufferSize = 64
+
+// Event is implemented by the typed events delivered by an EventBus. Use a type switch to handle them.
+type Event interface {
+	isEvent()
+}
+
+// AuthenticatedEvent is published when the Context becomes fully authenticated.
+type AuthenticatedEvent struct {
+	ApiSession apis.ApiSession
+}
+
+// AuthFailedEvent is published when an authentication attempt, or answering an authentication query, fails.
+type AuthFailedEvent struct {
+	Err error
+}
+
+// ServiceAddedEvent is published when a service becomes accessible.
+type ServiceAddedEvent struct {
+	Service *rest_model.ServiceDetail
+}
+
+// ServiceRemovedEvent is published when a service is no longer accessible.
+type ServiceRemovedEvent struct {
+	Service *rest_model.ServiceDetail
+}
+
+// ServiceChangedEvent is published when the definition of an accessible service changes.
+type ServiceChangedEvent struct {
+	Service *rest_model.ServiceDetail
+}
+
+// ServicesUpdatedEvent is published once per service refresh that added, removed or changed services.
+type ServicesUpdatedEvent struct {
+	Diff *ServiceDiff
+}
+
+// RouterConnectedEvent is published when a connection to an edge router is established.
+type RouterConnectedEvent struct {
+	RouterName string
+	Key        string
+}
+
+// RouterDisconnectedEvent is published when a connection to an edge router closes.
+type RouterDisconnectedEvent struct {
+	RouterName string
+	Key        string
+}
+
+// SessionCreatedEvent is published when a dial or bind session is created for a service.
+type SessionCreatedEvent struct {
+	Session *rest_model.SessionDetail
+}
+
+// ConnectionStateChangedEvent is published when the ConnectionState of the Context changes.
+type ConnectionStateChangedEvent struct {
+	From ConnectionState
+	To   ConnectionState
+}
+
+// ConnClosedEvent is published when a dialed or accepted connection closes.
+type ConnClosedEvent struct {
+	ServiceName    string
+	Conn           edge.Conn
+	ClosedByRemote bool
+}
+
+func (AuthenticatedEvent) isEvent()          {}
+func (AuthFailedEvent) isEvent()             {}
+func (ServiceAddedEvent) isEvent()           {}
+func (ServiceRemovedEvent) isEvent()         {}
+func (ServiceChangedEvent) isEvent()         {}
+func (ServicesUpdatedEvent) isEvent()        {}
+func (RouterConnectedEvent) isEvent()        {}
+func (RouterDisconnectedEvent) isEvent()     {}
+func (ConnectionStateChangedEvent) isEvent() {}
+func (SessionCreatedEvent) isEvent()         {}
+func (ConnClosedEvent) isEvent()             {}
+
+// SlowConsumerPolicy decides what happens to events published to a Subscription whose buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropNewest discards the event that didn't fit. It is the default.
+	DropNewest SlowConsumerPolicy = iota
+
+	// DropOldest discards the oldest buffered event to make room.
+	DropOldest
+
+	// Block waits for room for up to SubscriptionOptions.BlockTimeout, then discards the event. Events are published
+	// from the goroutines of the Context, so blocking delays the Context's work, such as service refreshes.
+	Block
+
+	// Disconnect closes the subscription.
+	Disconnect
+)
+
+// SubscriptionOptions configures a Subscription.
+type SubscriptionOptions struct {
+	// BufferSize is the number of events buffered for the subscriber. Defaults to DefaultSubscriptionBufferSize.
+	BufferSize int
+
+	// Policy decides what happens to events when the buffer is full. Defaults to DropNewest.
+	Policy SlowConsumerPolicy
+
+	// BlockTimeout is how long the Block policy waits for room. If zero, it waits until there is room or the
+	// subscription is closed.
+	BlockTimeout time.Duration
+
+	// Filter, if set, only delivers the events it returns true for.
+	Filter func(Event) bool
+}
+
+// EventBus delivers the typed events of a Context to subscriptions, as returned by Context.EventBus. It complements the
+// Add*Listener callbacks: events are delivered on bounded channels, so slow subscribers don't hold up the Context
+// unless they ask to.
+type EventBus struct {
+	lock          sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+
+	// source is the bus a view subscribes to, delivering its events passed through transform.
+	source    *EventBus
+	transform func(Event) Event
+}
+
+// view returns a bus that delivers the events of the bus passed through transform.
+func (self *EventBus) view(transform func(Event) Event) *EventBus {
+	return &EventBus{source: self, transform: transform}
+}
+
+// Subscribe returns a new Subscription to all events published after it was created. options may be nil.
+func (self *EventBus) Subscribe(options *SubscriptionOptions) *Subscription {
+	if self.source != nil {
+		return self.source.subscribe(options, self.transform)
+	}
+	return self.subscribe(options, nil)
+}
+
+func (self *EventBus) subscribe(options *SubscriptionOptions, transform func(Event) Event) *Subscription {
+	subscription := &Subscription{
+		bus:       self,
+		transform: transform,
+		done:      make(chan struct{}),
+	}
+	if options != nil {
+		subscription.options = *options
+	}
+	if subscription.options.BufferSize <= 0 {
+		subscription.options.BufferSize = DefaultSubscriptionBufferSize
+	}
+	subscription.ch = make(chan Event, subscription.options.BufferSize)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.subscriptions == nil {
+		self.subscriptions = map[*Subscription]struct{}{}
+	}
+	self.subscriptions[subscription] = struct{}{}
+
+	return subscription
+}
+
+func (self *EventBus) remove(subscription *Subscription) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.subscriptions, subscription)
+}
+
+func (self *EventBus) publish(event Event) {
+	self.lock.RLock()
+	subscriptions := make([]*Subscription, 0, len(self.subscriptions))
+	for subscription := range self.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	self.lock.RUnlock()
+
+	for _, subscription := range subscriptions {
+		subscription.deliver(event)
+	}
+}
+
+// publishEmitted publishes the typed equivalent of an event emitted to the Context's listeners, if there is one.
+func (self *EventBus) publishEmitted(eventName events.EventName, args []interface{}) {
+	if event := toTypedEvent(eventName, args); event != nil {
+		self.publish(event)
+	}
+}
+
+func toTypedEvent(eventName events.EventName, args []interface{}) Event {
+	arg := func(idx int) interface{} {
+		if idx < len(args) {
+			return args[idx]
+		}
+		return nil
+	}
+	str := func(idx int) string {
+		v, _ := arg(idx).(string)
+		return v
+	}
+	service := func() *rest_model.ServiceDetail {
+		v, _ := arg(0).(*rest_model.ServiceDetail)
+		return v
+	}
+
+	switch eventName {
+	case EventAuthenticationStateFull:
+		apiSession, _ := arg(0).(apis.ApiSession)
+		return AuthenticatedEvent{ApiSession: apiSession}
+	case EventAuthenticationFailed:
+		err, _ := arg(0).(error)
+		return AuthFailedEvent{Err: err}
+	case EventServiceAdded:
+		return ServiceAddedEvent{Service: service()}
+	case EventServiceRemoved:
+		return ServiceRemovedEvent{Service: service()}
+	case EventServiceChanged:
+		return ServiceChangedEvent{Service: service()}
+	case EventServicesUpdated:
+		diff, _ := arg(0).(*ServiceDiff)
+		return ServicesUpdatedEvent{Diff: diff}
+	case EventRouterConnected:
+		return RouterConnectedEvent{RouterName: str(0), Key: str(1)}
+	case EventRouterDisconnected:
+		return RouterDisconnectedEvent{RouterName: str(0), Key: str(1)}
+	case EventConnectionStateChanged:
+		from, _ := arg(0).(ConnectionState)
+		to, _ := arg(1).(ConnectionState)
+		return ConnectionStateChangedEvent{From: from, To: to}
+	}
+	return nil
+}
+
+// Subscription receives the events of an EventBus on C until it is closed.
+type Subscription struct {
+	bus       *EventBus
+	transform func(Event) Event
+	options   SubscriptionOptions
+	ch        chan Event
+	dropped   atomic.Uint64
+
+	lock      sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// C returns the channel events are delivered on. It is closed when the subscription is closed.
+func (self *Subscription) C() <-chan Event {
+	return self.ch
+}
+
+// Dropped returns the number of events discarded because the subscriber was too slow.
+func (self *Subscription) Dropped() uint64 {
+	return self.dropped.Load()
+}
+
+// Done is closed when the subscription is closed, either by Close or by the Disconnect policy.
+func (self *Subscription) Done() <-chan struct{} {
+	return self.done
+}
+
+// Close stops the delivery of events and closes C. Buffered events can still be received.
+func (self *Subscription) Close() {
+	self.closeOnce.Do(func() {
+		close(self.done)
+		self.bus.remove(self)
+
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		self.closed = true
+		close(self.ch)
+	})
+}
+
+func (self *Subscription) deliver(event Event) {
+	if self.transform != nil {
+		event = self.transform(event)
+	}
+
+	if self.options.Filter != nil && !self.options.Filter(event) {
+		return
+	}
+
+	self.lock.Lock()
+	if self.closed {
+		self.lock.Unlock()
+		return
+	}
+
+	select {
+	case self.ch <- event:
+		self.lock.Unlock()
+		return
+	default:
+	}
+
+	switch self.options.Policy {
+	case DropOldest:
+		for {
+			select {
+			case <-self.ch:
+				self.dropped.Add(1)
+			default:
+			}
+			select {
+			case self.ch <- event:
+				self.lock.Unlock()
+				return
+			default:
+			}
+		}
+	case Block:
+		var timeout <-chan time.Time
+		if self.options.BlockTimeout > 0 {
+			timer := time.NewTimer(self.options.BlockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case self.ch <- event:
+		case <-timeout:
+			self.dropped.Add(1)
+		case <-self.done:
+		}
+		self.lock.Unlock()
+	case Disconnect:
+		self.dropped.Add(1)
+		self.lock.Unlock()
+		self.Close()
+	default:
+		self.dropped.Add(1)
+		self.lock.Unlock()
+	}
+}
+
+func (context *ContextImpl) EventBus() *EventBus {
+	return &context.eventBus
+}
+
+// OnConnClosed implements network.ConnClosedOwner, publishing a ConnClosedEvent and calling the ConnHooks.OnClose hook
+// from Options.ConnHooks.
+func (context *ContextImpl) OnConnClosed(conn edge.Conn, serviceName string, closedByRemote bool) {
+	if context.connHooks != nil {
+		context.connHooks.closed(context, conn, closedByRemote)
+	}
+
+	context.eventBus.publish(ConnClosedEvent{
+		ServiceName:    serviceName,
+		Conn:           conn,
+		ClosedByRemote: closedByRemote,
+	})
+}
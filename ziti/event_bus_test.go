@@ -0,0 +1,105 @@
+package ziti
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EventBus_publish(t *testing.T) {
+	req := require.New(t)
+
+	bus := &EventBus{}
+	all := bus.Subscribe(nil)
+	services := bus.Subscribe(&SubscriptionOptions{
+		Filter: func(event Event) bool {
+			_, ok := event.(ServiceAddedEvent)
+			return ok
+		},
+	})
+
+	svc := newTestService("svc")
+	bus.publishEmitted(EventServiceAdded, []interface{}{svc})
+	bus.publishEmitted(EventRouterConnected, []interface{}{"er1", "tls:er1:3022"})
+	bus.publishEmitted(EventAuthenticationFailed, []interface{}{errors.New("denied")})
+	bus.publishEmitted(EventMfaTotpCode, nil)
+
+	req.Equal(ServiceAddedEvent{Service: svc}, <-all.C())
+	req.Equal(RouterConnectedEvent{RouterName: "er1", Key: "tls:er1:3022"}, <-all.C())
+	req.Equal(AuthFailedEvent{Err: errors.New("denied")}, <-all.C())
+	req.Len(all.C(), 0)
+
+	req.Equal(ServiceAddedEvent{Service: svc}, <-services.C())
+	req.Len(services.C(), 0)
+
+	all.Close()
+	all.Close()
+	_, open := <-all.C()
+	req.False(open)
+
+	bus.publish(ServiceRemovedEvent{Service: svc})
+	bus.publish(ServiceAddedEvent{Service: svc})
+	req.Equal(ServiceAddedEvent{Service: svc}, <-services.C())
+}
+
+func Test_Subscription_slowConsumerPolicies(t *testing.T) {
+	req := require.New(t)
+
+	publish := func(bus *EventBus, names ...string) {
+		for _, name := range names {
+			bus.publish(ConnClosedEvent{ServiceName: name})
+		}
+	}
+	received := func(subscription *Subscription) []string {
+		var result []string
+		for len(subscription.C()) > 0 {
+			result = append(result, (<-subscription.C()).(ConnClosedEvent).ServiceName)
+		}
+		return result
+	}
+
+	bus := &EventBus{}
+	dropNewest := bus.Subscribe(&SubscriptionOptions{BufferSize: 2})
+	dropOldest := bus.Subscribe(&SubscriptionOptions{BufferSize: 2, Policy: DropOldest})
+	disconnect := bus.Subscribe(&SubscriptionOptions{BufferSize: 2, Policy: Disconnect})
+	publish(bus, "a", "b", "c", "d")
+
+	req.Equal([]string{"a", "b"}, received(dropNewest))
+	req.Equal(uint64(2), dropNewest.Dropped())
+
+	req.Equal([]string{"c", "d"}, received(dropOldest))
+	req.Equal(uint64(2), dropOldest.Dropped())
+
+	<-disconnect.Done()
+	req.Equal([]string{"a", "b"}, received(disconnect))
+	req.Equal(uint64(1), disconnect.Dropped())
+
+	block := bus.Subscribe(&SubscriptionOptions{BufferSize: 1, Policy: Block, BlockTimeout: 10 * time.Millisecond})
+	publish(bus, "e", "f")
+	req.Equal(uint64(1), block.Dropped())
+
+	published := make(chan struct{})
+	unbounded := bus.Subscribe(&SubscriptionOptions{BufferSize: 1, Policy: Block})
+	go func() {
+		publish(bus, "g", "h")
+		close(published)
+	}()
+	req.Equal("g", (<-unbounded.C()).(ConnClosedEvent).ServiceName)
+	req.Equal("h", (<-unbounded.C()).(ConnClosedEvent).ServiceName)
+	<-published
+
+	released := make(chan struct{})
+	go func() {
+		publish(bus, "i", "j")
+		close(released)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	unbounded.Close()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		req.Fail("closing the subscription did not release the publisher")
+	}
+}
@@ -17,9 +17,12 @@
 package ziti
 
 import (
+	"time"
+
 	"github.com/kataras/go-events"
 	"github.com/openziti/edge-api/rest_model"
 	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti/edge"
 )
 
 const (
@@ -44,6 +47,14 @@ const (
 	// 2) serviceDetail`*rest_model.ServiceDetail` - The full detail record of the service
 	EventServiceRemoved = events.EventName("service-removed")
 
+	// EventServicesUpdated is emitted once per service refresh that added, removed or changed services, after the
+	// EventServiceAdded, EventServiceChanged and EventServiceRemoved events for the individual services.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) diff `*ServiceDiff` - the services added, removed and changed by the refresh
+	EventServicesUpdated = events.EventName("services-updated")
+
 	// EventRouterConnected is emitted when a connection to an Edge Router is established.
 	//
 	// Arguments:
@@ -60,6 +71,23 @@ const (
 	// 3) routerKey `string` - A string that uniquely identifies a router connection
 	EventRouterDisconnected = events.EventName("router-disconnected")
 
+	// EventRouterOffline is emitted when a connection to an Edge Router stops answering keepalives for
+	// KeepaliveOptions.UnhealthyTimeout. New dials avoid the router until EventRouterOnline is emitted for it.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) routerName `string` - The string name of the target router
+	// 3) routerKey `string` - A string that uniquely identifies a router connection
+	EventRouterOffline = events.EventName("router-offline")
+
+	// EventRouterOnline is emitted when a connection to an Edge Router that was offline answers keepalives again.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) routerName `string` - The string name of the target router
+	// 3) routerKey `string` - A string that uniquely identifies a router connection
+	EventRouterOnline = events.EventName("router-online")
+
 	// EventMfaTotpCode is emitted when a Ziti context requires an MFA TOTP code to proceed with authentication.
 	//
 	// Arguments:
@@ -108,6 +136,86 @@ const (
 	// 1) Context - the context that triggered the listener
 	// 2) apiUrls []*urls.URL - the URLs of the API for the available controllers
 	EventControllerUrlsUpdated = events.EventName("controller-urls-updated")
+
+	// EventAuthenticationFailed is emitted when a context fails to authenticate or answer an authentication query.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) err `error` - the error that caused authentication to fail
+	EventAuthenticationFailed = events.EventName("auth-failed")
+
+	// EventConnectionIdle is emitted when a connection is closed for exceeding Options.IdleTimeout.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) serviceName `string` - the name of the service the connection was for
+	// 3) conn `edge.Conn` - the closed connection
+	// 4) idle `time.Duration` - how long the connection had been idle
+	EventConnectionIdle = events.EventName("connection-idle")
+
+	// EventListenerRebind is emitted when a listener re-establishes a terminator after losing one, e.g. because the
+	// hosting edge router disconnected.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) serviceName `string` - the name of the hosted service
+	// 3) lostRouter `string` - the name of the edge router the terminator was lost on
+	// 4) newRouter `string` - the name of the edge router the terminator was re-established on
+	// 5) downtime `time.Duration` - how long after the loss the terminator was re-established
+	EventListenerRebind = events.EventName("listener-rebind")
+
+	// EventControllerBreakerStateChanged is emitted when the circuit breaker of a controller opens, half-opens or
+	// closes. See Options.ControllerBreaker.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) host `string` - the host and port of the controller
+	// 3) state `BreakerState` - the new state of the breaker
+	EventControllerBreakerStateChanged = events.EventName("controller-breaker-state-changed")
+
+	// EventConnectionStateChanged is emitted when the ConnectionState of a context changes, e.g. from
+	// ConnectionStateReady to ConnectionStateAuthenticating after its API Session was lost.
+	//
+	// Arguments:
+	// 1) Context - the context that triggered the listener
+	// 2) from `ConnectionState` - the previous state
+	// 3) to `ConnectionState` - the new state
+	EventConnectionStateChanged = events.EventName("connection-state-changed")
+)
+
+const (
+	// EventContextAdded is emitted by a CtxCollection when a Context is added to it.
+	//
+	// Arguments:
+	// 1) Context - the context that was added
+	EventContextAdded = events.EventName("collection-context-added")
+
+	// EventContextRemoved is emitted by a CtxCollection when a Context is removed from it, either explicitly or because
+	// it was replaced by a different Context instance with the same id.
+	//
+	// Arguments:
+	// 1) Context - the context that was removed
+	EventContextRemoved = events.EventName("collection-context-removed")
+
+	// EventContextAuthenticated is emitted by a CtxCollection when one of its contexts becomes fully authenticated.
+	//
+	// Arguments:
+	// 1) Context - the context that authenticated
+	// 2) apiSession `edge_apis.ApiSession` - the API Session the context acquired
+	EventContextAuthenticated = events.EventName("collection-context-authenticated")
+
+	// EventContextFailed is emitted by a CtxCollection when one of its contexts fails to authenticate.
+	//
+	// Arguments:
+	// 1) Context - the context that failed
+	// 2) err `error` - the error that caused the failure
+	EventContextFailed = events.EventName("collection-context-failed")
+
+	// EventShutdownProgress is emitted by a CtxCollection when a phase of CtxCollection.Shutdown starts or completes.
+	//
+	// Arguments:
+	// 1) progress `ShutdownProgress` - the phase and, once completed, its outcome
+	EventShutdownProgress = events.EventName("collection-shutdown-progress")
 )
 
 // Eventer provides types methods for adding event listeners to a context and exposes some weakly typed functions
@@ -123,6 +231,11 @@ type Eventer interface {
 	// provided is the service that was changed.
 	AddServiceChangedListener(func(Context, *rest_model.ServiceDetail)) func()
 
+	// AddServicesUpdatedListener adds an event listener for the EventServicesUpdated event and returns a function to
+	// remove the listener. It is emitted once per service refresh that added, removed or changed services, with the
+	// differences, so that listeners don't need to compare full service lists.
+	AddServicesUpdatedListener(func(ztx Context, diff *ServiceDiff)) func()
+
 	// AddServiceRemovedListener adds an event listener for the EventServiceRemoved event and returns a function to remove
 	// the listener. It is emitted any time known service definition is no longer accessible. The service detail
 	// provided is the service that was removed.
@@ -136,6 +249,16 @@ type Eventer interface {
 	// the listener. It is emitted any time a router connection is closed. The strings provided are router name and connection address.
 	AddRouterDisconnectedListener(func(ztx Context, name string, addr string)) func()
 
+	// AddRouterOfflineListener adds an event listener for the EventRouterOffline event and returns a function to remove
+	// the listener. It is emitted any time a router connection stops answering keepalives. The strings provided are
+	// router name and connection address.
+	AddRouterOfflineListener(func(ztx Context, name string, addr string)) func()
+
+	// AddRouterOnlineListener adds an event listener for the EventRouterOnline event and returns a function to remove
+	// the listener. It is emitted any time an offline router connection answers keepalives again. The strings provided
+	// are router name and connection address.
+	AddRouterOnlineListener(func(ztx Context, name string, addr string)) func()
+
 	// AddMfaTotpCodeListener adds an event listener for the EventMfaTotpCode event and returns a function to remove
 	// the listener. It is emitted any time the currently authenticated API Session requires an MFA TOTP Code for
 	// authentication. The authentication query detail and an MfaCodeResponse function are provided. The MfaCodeResponse
@@ -183,6 +306,30 @@ type Eventer interface {
 	// now expired API Session.
 	AddAuthenticationStateUnauthenticatedListener(func(Context, edge_apis.ApiSession)) func()
 
+	// AddAuthenticationFailedListener adds an event listener for the EventAuthenticationFailed event and returns a
+	// function to remove the listener. It is emitted any time an authentication attempt, or answering of an
+	// authentication query, fails. The error provided is the cause of the failure.
+	AddAuthenticationFailedListener(func(Context, error)) func()
+
+	// AddConnectionIdleListener adds an event listener for the EventConnectionIdle event and returns a function to
+	// remove the listener. It is emitted any time a connection is closed after carrying no traffic for
+	// Options.IdleTimeout.
+	AddConnectionIdleListener(func(ztx Context, serviceName string, conn edge.Conn, idle time.Duration)) func()
+
+	// AddListenerRebindListener adds an event listener for the EventListenerRebind event and returns a function to
+	// remove the listener. It is emitted any time a listener re-establishes a terminator after losing one. The
+	// strings provided are the service name and the names of the lost and the new edge router.
+	AddListenerRebindListener(func(ztx Context, serviceName string, lostRouter string, newRouter string, downtime time.Duration)) func()
+
+	// AddControllerBreakerListener adds an event listener for the EventControllerBreakerStateChanged event and returns
+	// a function to remove the listener. It is emitted any time the circuit breaker of a controller changes state.
+	AddControllerBreakerListener(func(ztx Context, host string, state BreakerState)) func()
+
+	// AddConnectionStateListener adds an event listener for the EventConnectionStateChanged event and returns a
+	// function to remove the listener. It is emitted any time the context moves between the disconnected,
+	// authenticating, syncing and ready states.
+	AddConnectionStateListener(func(ztx Context, from ConnectionState, to ConnectionState)) func()
+
 	// AddListener is an alias for .On(eventName, listener).
 	AddListener(events.EventName, ...events.Listener)
 
@@ -0,0 +1,222 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+	"github.com/sirupsen/logrus"
+)
+
+// Well known edge router app data keys describing the failure domain of a router.
+const (
+	RouterLabelRegion     = "region"
+	RouterLabelDatacenter = "datacenter"
+)
+
+// EdgeRouter describes an edge router the current identity may connect to, as returned by Context.GetEdgeRouters.
+type EdgeRouter struct {
+	Id       string
+	Name     string
+	Hostname string
+	Cost     int64
+	Online   bool
+
+	// Labels are the scalar values of the router's app data, which is where failure domains such as RouterLabelRegion
+	// and RouterLabelDatacenter are advertised.
+	Labels map[string]string
+}
+
+// Region returns the RouterLabelRegion label, if the router advertises one.
+func (self *EdgeRouter) Region() string {
+	return self.Labels[RouterLabelRegion]
+}
+
+// Datacenter returns the RouterLabelDatacenter label, if the router advertises one.
+func (self *EdgeRouter) Datacenter() string {
+	return self.Labels[RouterLabelDatacenter]
+}
+
+func (context *ContextImpl) GetEdgeRouters() ([]*EdgeRouter, error) {
+	if err := context.ensureApiSession(); err != nil {
+		return nil, fmt.Errorf("failed to list edge routers: %v", err)
+	}
+
+	details, err := context.CtrlClt.GetCurrentIdentityEdgeRouters()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*EdgeRouter
+	for _, detail := range details {
+		result = append(result, &EdgeRouter{
+			Id:       stringz.OrEmpty(detail.ID),
+			Name:     stringz.OrEmpty(detail.Name),
+			Hostname: stringz.OrEmpty(detail.Hostname),
+			Cost:     int64OrZero(detail.Cost),
+			Online:   detail.IsOnline != nil && *detail.IsOnline,
+			Labels:   routerLabels(detail.AppData),
+		})
+	}
+	return result, nil
+}
+
+// routerLabels flattens the scalar values of router app data to strings. Nested values are skipped.
+func routerLabels(appData *rest_model.Tags) map[string]string {
+	if appData == nil || len(appData.SubTags) == 0 {
+		return nil
+	}
+
+	result := map[string]string{}
+	for k, v := range appData.SubTags {
+		switch v.(type) {
+		case string, bool, float64, int, int64:
+			result[k] = fmt.Sprint(v)
+		}
+	}
+	return result
+}
+
+// FailureDomainPolicy narrows the edge routers, or the terminators on edge routers, used for a dial based on router
+// labels. Avoided routers are excluded and preferred routers are chosen over the rest, but if the policy would leave
+// nothing to choose from, it is ignored rather than failing the dial.
+type FailureDomainPolicy struct {
+	// Prefer selects the routers whose labels contain all the given key/value pairs, e.g. {"region": "us-east-1"}.
+	Prefer map[string]string
+
+	// Avoid excludes the routers whose labels contain any of the given key/value pairs.
+	Avoid map[string]string
+}
+
+func (self *FailureDomainPolicy) isAvoided(labels map[string]string) bool {
+	for k, v := range self.Avoid {
+		if labels[k] == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *FailureDomainPolicy) isPreferred(labels map[string]string) bool {
+	for k, v := range self.Prefer {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func applyFailureDomainPolicy[T any](log *pfxlog.Builder, policy *FailureDomainPolicy, items []T, labels func(T) map[string]string) []T {
+	if policy == nil || len(items) == 0 {
+		return items
+	}
+
+	var allowed []T
+	for _, item := range items {
+		if !policy.isAvoided(labels(item)) {
+			allowed = append(allowed, item)
+		}
+	}
+	if len(allowed) == 0 {
+		log.WithField("avoid", policy.Avoid).Debug("all candidates are in avoided failure domains, ignoring")
+		allowed = items
+	}
+
+	if len(policy.Prefer) == 0 {
+		return allowed
+	}
+
+	var preferred []T
+	for _, item := range allowed {
+		if policy.isPreferred(labels(item)) {
+			preferred = append(preferred, item)
+		}
+	}
+	if len(preferred) == 0 {
+		return allowed
+	}
+	return preferred
+}
+
+// FailureDomainTerminatorStrategy returns a TerminatorStrategy that applies the policy to the labels of the edge routers
+// hosting the terminators, then lets next select among the remaining ones. If next is nil, SelectRandomTerminator
+// is used.
+func FailureDomainTerminatorStrategy(policy *FailureDomainPolicy, next TerminatorStrategy) TerminatorStrategy {
+	if next == nil {
+		next = SelectRandomTerminator
+	}
+	return &failureDomainTerminatorStrategy{policy: policy, next: next}
+}
+
+// loggingTerminatorStrategy is implemented by the strategies that log, so that a Context may have them log to its
+// logger rather than to pfxlog.
+type loggingTerminatorStrategy interface {
+	selectWithLog(log *pfxlog.Builder, serviceName string, terminators []*Terminator) *Terminator
+}
+
+type failureDomainTerminatorStrategy struct {
+	policy *FailureDomainPolicy
+	next   TerminatorStrategy
+}
+
+func (self *failureDomainTerminatorStrategy) Select(serviceName string, terminators []*Terminator) *Terminator {
+	return self.selectWithLog(pfxlog.Logger(), serviceName, terminators)
+}
+
+func (self *failureDomainTerminatorStrategy) selectWithLog(log *pfxlog.Builder, serviceName string, terminators []*Terminator) *Terminator {
+	candidates := applyFailureDomainPolicy(log, self.policy, terminators, func(terminator *Terminator) map[string]string {
+		return terminator.RouterLabels
+	})
+	return selectTerminator(self.next, log, serviceName, candidates)
+}
+
+// edgeRouterPolicy narrows the edge routers of a session considered for a dial.
+type edgeRouterPolicy struct {
+	preferred      []string
+	failureDomains *FailureDomainPolicy
+	logger         *logrus.Logger
+}
+
+func (self *DialOptions) edgeRouterPolicy(logger *logrus.Logger) edgeRouterPolicy {
+	return edgeRouterPolicy{
+		preferred:      self.PreferredEdgeRouters,
+		failureDomains: self.FailureDomains,
+		logger:         logger,
+	}
+}
+
+func (self edgeRouterPolicy) apply(edgeRouters []*rest_model.SessionEdgeRouter) []*rest_model.SessionEdgeRouter {
+	if len(self.preferred) > 0 {
+		var preferred []*rest_model.SessionEdgeRouter
+		for _, edgeRouter := range edgeRouters {
+			if stringz.Contains(self.preferred, stringz.OrEmpty(edgeRouter.Name)) {
+				preferred = append(preferred, edgeRouter)
+			}
+		}
+		if len(preferred) > 0 {
+			return preferred
+		}
+		newLogger(self.logger).WithField("preferredRouters", self.preferred).Debug("no preferred edge routers available for session")
+	}
+
+	return applyFailureDomainPolicy(newLogger(self.logger), self.failureDomains, edgeRouters, func(edgeRouter *rest_model.SessionEdgeRouter) map[string]string {
+		return routerLabels(edgeRouter.AppData)
+	})
+}
@@ -0,0 +1,162 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/pkg/errors"
+)
+
+// ErrInjectedFault is the error of the dials failed by FaultInjector.DropDials.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultInjector injects Ziti-layer failures into a Context, so that tests can verify that an application recovers
+// from them. It is meant for tests only, and is deliberately not part of Context: get the FaultInjector of a Context
+// with GetFaultInjector.
+type FaultInjector interface {
+	// DropDials fails the next n dial attempts with ErrInjectedFault before they reach the controller or an edge
+	// router. Attempts made by a DialRetryPolicy count separately.
+	DropDials(n int)
+
+	// DelayServiceRefresh delays every service refresh by d, until called again with zero.
+	DelayServiceRefresh(d time.Duration)
+
+	// DisconnectRouters closes the connections to the named edge router, or to every edge router if routerName is
+	// empty, failing the connections multiplexed over them as if the router had gone away. It returns the number of
+	// router connections closed.
+	DisconnectRouters(routerName string) int
+
+	// CorruptSessionToken replaces the token of the current API session with an invalid one, so that the following
+	// controller requests and edge router connections fail to authenticate until the Context authenticates again.
+	CorruptSessionToken() error
+
+	// Reset stops dropping dials and delaying service refreshes.
+	Reset()
+}
+
+// GetFaultInjector returns the FaultInjector of ztx, if it is a Context created by this package.
+func GetFaultInjector(ztx Context) (FaultInjector, bool) {
+	context, ok := ztx.(*ContextImpl)
+	if !ok {
+		return nil, false
+	}
+	return &contextFaults{context: context}, true
+}
+
+// faultState holds the faults injected into a ContextImpl.
+type faultState struct {
+	droppedDials        atomic.Int64
+	serviceRefreshDelay atomic.Int64
+}
+
+// dropDial returns ErrInjectedFault if the dial should be dropped.
+func (self *faultState) dropDial() error {
+	for {
+		remaining := self.droppedDials.Load()
+		if remaining <= 0 {
+			return nil
+		}
+		if self.droppedDials.CompareAndSwap(remaining, remaining-1) {
+			return ErrInjectedFault
+		}
+	}
+}
+
+// delayServiceRefresh waits out the injected service refresh delay, returning early if closeNotify is closed.
+func (self *faultState) delayServiceRefresh(closeNotify <-chan struct{}) {
+	delay := time.Duration(self.serviceRefreshDelay.Load())
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-closeNotify:
+	}
+}
+
+type contextFaults struct {
+	context *ContextImpl
+}
+
+func (self *contextFaults) DropDials(n int) {
+	self.context.faults.droppedDials.Store(int64(n))
+}
+
+func (self *contextFaults) DelayServiceRefresh(d time.Duration) {
+	self.context.faults.serviceRefreshDelay.Store(int64(d))
+}
+
+func (self *contextFaults) DisconnectRouters(routerName string) int {
+	closed := 0
+	for entry := range self.context.routerConnections.IterBuffered() {
+		conn := entry.Val
+		if conn.IsClosed() || (routerName != "" && conn.GetRouterName() != routerName) {
+			continue
+		}
+		if err := conn.Close(); err != nil {
+			self.context.log().WithError(err).WithField("router", conn.GetRouterName()).Warn("unable to close router connection")
+			continue
+		}
+		closed++
+	}
+	return closed
+}
+
+func (self *contextFaults) CorruptSessionToken() error {
+	current := self.context.CtrlClt.GetCurrentApiSession()
+	if current == nil {
+		return errors.New("not authenticated to controller")
+	}
+
+	token := "corrupted-" + uuid.NewString()
+
+	var corrupted apis.ApiSession
+	switch apiSession := current.(type) {
+	case *apis.ApiSessionLegacy:
+		if apiSession.Detail == nil {
+			return errors.New("api session has no token")
+		}
+		detail := *apiSession.Detail
+		detail.Token = &token
+		corrupted = &apis.ApiSessionLegacy{Detail: &detail}
+	case *apis.ApiSessionOidc:
+		if apiSession.OidcTokens == nil {
+			return errors.New("api session has no token")
+		}
+		tokens := *apiSession.OidcTokens
+		tokens.AccessToken = token
+		corrupted = &apis.ApiSessionOidc{OidcTokens: &tokens}
+	default:
+		return errors.Errorf("unsupported api session type %T", current)
+	}
+
+	self.context.CtrlClt.ApiSession.Store(&corrupted)
+	return nil
+}
+
+func (self *contextFaults) Reset() {
+	self.DropDials(0)
+	self.DelayServiceRefresh(0)
+}
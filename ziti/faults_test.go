@@ -0,0 +1,53 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_faultState_dropDial(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{}
+	injector, ok := GetFaultInjector(ctx)
+	req.True(ok)
+
+	req.NoError(ctx.faults.dropDial())
+
+	injector.DropDials(2)
+	req.ErrorIs(ctx.faults.dropDial(), ErrInjectedFault)
+	req.ErrorIs(ctx.faults.dropDial(), ErrInjectedFault)
+	req.NoError(ctx.faults.dropDial())
+
+	injector.DropDials(5)
+	injector.Reset()
+	req.NoError(ctx.faults.dropDial())
+
+	req.True(IsRetryableDialError(ErrInjectedFault))
+}
+
+func Test_faultState_delayServiceRefresh(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{}
+	injector, _ := GetFaultInjector(ctx)
+	injector.DelayServiceRefresh(50 * time.Millisecond)
+
+	start := time.Now()
+	ctx.faults.delayServiceRefresh(make(chan struct{}))
+	req.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+
+	closeNotify := make(chan struct{})
+	close(closeNotify)
+	injector.DelayServiceRefresh(time.Hour)
+	start = time.Now()
+	ctx.faults.delayServiceRefresh(closeNotify)
+	req.Less(time.Since(start), time.Second)
+}
+
+func Test_GetFaultInjector_otherContext(t *testing.T) {
+	_, ok := GetFaultInjector(ReadOnly(&ContextImpl{}))
+	require.False(t, ok)
+}
@@ -0,0 +1,441 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultFileChunkSize is the size of the chunks files are sent in by SendFile, if no other size is given.
+	DefaultFileChunkSize = 256 * 1024
+
+	// maxFileChunkSize bounds the chunk size a receiver accepts.
+	maxFileChunkSize = 16 * 1024 * 1024
+
+	fileTransferMagic = "ZFT1"
+
+	fileTransferOk     byte = 0
+	fileTransferFailed byte = 1
+
+	partialFileSuffix     = ".part"
+	partialFileMetaSuffix = ".part.meta"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// FileTransferOptions tunes SendFile.
+type FileTransferOptions struct {
+	// ChunkSize is the number of bytes sent per checksummed chunk. Defaults to DefaultFileChunkSize.
+	ChunkSize int
+}
+
+// FileTransfer describes a completed file transfer.
+type FileTransfer struct {
+	// Name is the base name of the file as sent.
+	Name string
+
+	// Size is the size of the file in bytes.
+	Size int64
+
+	// ResumedAt is the offset the transfer resumed from, or zero if it started from the beginning.
+	ResumedAt int64
+
+	// SHA256 is the hex encoded checksum of the whole file, verified by the receiver.
+	SHA256 string
+}
+
+// fileOffer is sent by the sender to start a transfer. Id identifies the file version, so that a receiver only resumes
+// partial files of the same version.
+type fileOffer struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Id        string `json:"id"`
+	ChunkSize int    `json:"chunkSize"`
+}
+
+// SendFile sends the file at path over conn to a peer calling ReceiveFile. If the peer has part of the file from an
+// earlier, interrupted transfer of the same file, only the rest is sent. Each chunk is checksummed, and the checksum of
+// the whole file is verified by the receiver before the transfer is reported as successful. conn is not closed.
+func SendFile(conn net.Conn, path string, options *FileTransferOptions) (*FileTransfer, error) {
+	chunkSize := DefaultFileChunkSize
+	if options != nil && options.ChunkSize > 0 {
+		chunkSize = options.ChunkSize
+	}
+	if chunkSize > maxFileChunkSize {
+		return nil, errors.Errorf("chunk size %d exceeds maximum of %d", chunkSize, maxFileChunkSize)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offer := &fileOffer{
+		Name:      filepath.Base(path),
+		Size:      stat.Size(),
+		Id:        fileTransferId(filepath.Base(path), stat),
+		ChunkSize: chunkSize,
+	}
+
+	writer := bufio.NewWriterSize(conn, chunkSize+8)
+	if err = writeFileOffer(writer, offer); err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	if err = binary.Read(conn, binary.BigEndian, &offset); err != nil {
+		return nil, errors.Wrap(err, "failed to read resume offset")
+	}
+	if offset < 0 || offset > offer.Size {
+		return nil, errors.Errorf("receiver requested invalid resume offset %d", offset)
+	}
+
+	digest := sha256.New()
+	if _, err = io.CopyN(digest, file, offset); err != nil {
+		return nil, errors.Wrap(err, "failed to checksum already transferred data")
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, readErr := io.ReadFull(file, buf)
+		if n > 0 {
+			digest.Write(buf[:n])
+			if err = writeFileChunk(writer, buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	sum := digest.Sum(nil)
+	if err = writeFileChunk(writer, nil); err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(sum); err != nil {
+		return nil, err
+	}
+	if err = writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	if err = readFileTransferResult(conn); err != nil {
+		return nil, err
+	}
+
+	return &FileTransfer{
+		Name:      offer.Name,
+		Size:      offer.Size,
+		ResumedAt: offset,
+		SHA256:    hex.EncodeToString(sum),
+	}, nil
+}
+
+// ReceiveFile receives a file sent over conn by a peer calling SendFile and stores it at path. Data is written to
+// path with a ".part" suffix until the transfer completes and its checksum is verified, so that if the transfer is
+// interrupted, a later ReceiveFile to the same path resumes it. conn is not closed.
+func ReceiveFile(conn net.Conn, path string) (*FileTransfer, error) {
+	reader := bufio.NewReader(conn)
+
+	offer, err := readFileOffer(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := receiveFile(reader, conn, path, offer)
+	if err != nil {
+		_ = writeFileTransferResult(conn, err)
+		return nil, err
+	}
+
+	if err = writeFileTransferResult(conn, nil); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func receiveFile(reader *bufio.Reader, conn net.Conn, path string, offer *fileOffer) (*FileTransfer, error) {
+	partialPath := path + partialFileSuffix
+	metaPath := path + partialFileMetaSuffix
+
+	partial, offset, err := openPartialFile(partialPath, metaPath, offer)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = partial.Close() }()
+
+	digest := sha256.New()
+	if _, err = io.CopyN(digest, partial, offset); err != nil {
+		return nil, errors.Wrap(err, "failed to checksum partial file")
+	}
+
+	if err = binary.Write(conn, binary.BigEndian, offset); err != nil {
+		return nil, errors.Wrap(err, "failed to send resume offset")
+	}
+
+	received := offset
+	buf := make([]byte, offer.ChunkSize)
+	for {
+		chunk, err := readFileChunk(reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+
+		received += int64(len(chunk))
+		if received > offer.Size {
+			return nil, errors.Errorf("received more than the offered %d bytes", offer.Size)
+		}
+
+		digest.Write(chunk)
+		if _, err = partial.Write(chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	expected := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(reader, expected); err != nil {
+		return nil, errors.Wrap(err, "failed to read file checksum")
+	}
+
+	if err = verifyFileTransfer(digest, expected, received, offer); err != nil {
+		// the partial file can't be trusted, so start over next time
+		_ = os.Remove(metaPath)
+		return nil, err
+	}
+
+	if err = partial.Sync(); err != nil {
+		return nil, err
+	}
+	if err = partial.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(partialPath, path); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(metaPath)
+
+	return &FileTransfer{
+		Name:      offer.Name,
+		Size:      offer.Size,
+		ResumedAt: offset,
+		SHA256:    hex.EncodeToString(expected),
+	}, nil
+}
+
+// fileTransferId identifies a version of a file by its name, size and modification time.
+func fileTransferId(name string, stat os.FileInfo) string {
+	return fmt.Sprintf("%s:%d:%d", name, stat.Size(), stat.ModTime().UnixNano())
+}
+
+func verifyFileTransfer(digest hash.Hash, expected []byte, received int64, offer *fileOffer) error {
+	if received != offer.Size {
+		return errors.Errorf("received %d of %d bytes", received, offer.Size)
+	}
+	if sum := digest.Sum(nil); string(sum) != string(expected) {
+		return errors.Errorf("file checksum mismatch, expected %x, got %x", expected, sum)
+	}
+	return nil
+}
+
+// openPartialFile opens the partial file for the offer, returning the offset to resume from. Partial files of other
+// versions of the file are discarded, and partial files are truncated to a whole number of chunks.
+func openPartialFile(partialPath, metaPath string, offer *fileOffer) (*os.File, int64, error) {
+	var offset int64
+
+	if meta, err := os.ReadFile(metaPath); err == nil && string(meta) == offer.Id {
+		if stat, err := os.Stat(partialPath); err == nil {
+			offset = stat.Size() - stat.Size()%int64(offer.ChunkSize)
+			if offset > offer.Size {
+				offset = 0
+			}
+		}
+	}
+
+	if offset == 0 {
+		if err := os.WriteFile(metaPath, []byte(offer.Id), 0600); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	partial, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err = partial.Truncate(offset); err != nil {
+		_ = partial.Close()
+		return nil, 0, err
+	}
+
+	return partial, offset, nil
+}
+
+func writeFileOffer(w *bufio.Writer, offer *fileOffer) error {
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.WriteString(fileTransferMagic); err != nil {
+		return err
+	}
+	if err = binary.Write(w, binary.BigEndian, uint32(len(body))); err != nil {
+		return err
+	}
+	if _, err = w.Write(body); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func readFileOffer(r io.Reader) (*fileOffer, error) {
+	magic := make([]byte, len(fileTransferMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, errors.Wrap(err, "failed to read file offer")
+	}
+	if string(magic) != fileTransferMagic {
+		return nil, errors.New("peer is not sending a file")
+	}
+
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, errors.Wrap(err, "failed to read file offer")
+	}
+	if size > 64*1024 {
+		return nil, errors.Errorf("file offer of %d bytes is too large", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errors.Wrap(err, "failed to read file offer")
+	}
+
+	offer := &fileOffer{}
+	if err := json.Unmarshal(body, offer); err != nil {
+		return nil, errors.Wrap(err, "invalid file offer")
+	}
+	if offer.ChunkSize <= 0 || offer.ChunkSize > maxFileChunkSize || offer.Size < 0 {
+		return nil, errors.Errorf("invalid file offer for %s", offer.Name)
+	}
+	return offer, nil
+}
+
+// writeFileChunk writes a chunk as its length, data and CRC-32C checksum. An empty chunk ends the transfer.
+func writeFileChunk(w io.Writer, data []byte) error {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc32.Checksum(data, crc32c))
+}
+
+func readFileChunk(r io.Reader, buf []byte) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, errors.Wrap(err, "failed to read chunk")
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	if int(size) > len(buf) {
+		return nil, errors.Errorf("chunk of %d bytes exceeds chunk size %d", size, len(buf))
+	}
+
+	chunk := buf[:size]
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		return nil, errors.Wrap(err, "failed to read chunk")
+	}
+
+	var checksum uint32
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return nil, errors.Wrap(err, "failed to read chunk checksum")
+	}
+	if crc32.Checksum(chunk, crc32c) != checksum {
+		return nil, errors.New("chunk checksum mismatch")
+	}
+	return chunk, nil
+}
+
+func writeFileTransferResult(w io.Writer, transferErr error) error {
+	status := fileTransferOk
+	var msg string
+	if transferErr != nil {
+		status = fileTransferFailed
+		msg = transferErr.Error()
+		if len(msg) > 4096 {
+			msg = msg[:4096]
+		}
+	}
+
+	buf := make([]byte, 3+len(msg))
+	buf[0] = status
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(msg)))
+	copy(buf[3:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFileTransferResult(r io.Reader) error {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.Wrap(err, "failed to read transfer result")
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return errors.Wrap(err, "failed to read transfer result")
+	}
+
+	if header[0] != fileTransferOk {
+		return errors.Errorf("receiver rejected file: %s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package ziti
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func transferFile(t *testing.T, src, dst string, chunkSize int) (*FileTransfer, *FileTransfer, error, error) {
+	sender, receiver := net.Pipe()
+	defer func() {
+		_ = sender.Close()
+		_ = receiver.Close()
+	}()
+
+	type result struct {
+		transfer *FileTransfer
+		err      error
+	}
+	receivedC := make(chan result, 1)
+	go func() {
+		transfer, err := ReceiveFile(receiver, dst)
+		receivedC <- result{transfer, err}
+	}()
+
+	sent, sendErr := SendFile(sender, src, &FileTransferOptions{ChunkSize: chunkSize})
+	received := <-receivedC
+	return sent, received.transfer, sendErr, received.err
+}
+
+func Test_SendFile(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+
+	data := make([]byte, 10*1024+17)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	src := filepath.Join(dir, "src.bin")
+	dst := filepath.Join(dir, "dst.bin")
+	req.NoError(os.WriteFile(src, data, 0600))
+
+	sent, received, sendErr, receiveErr := transferFile(t, src, dst, 1024)
+	req.NoError(sendErr)
+	req.NoError(receiveErr)
+	req.Equal(sent.SHA256, received.SHA256)
+	req.Equal(int64(len(data)), received.Size)
+	req.Zero(received.ResumedAt)
+
+	result, err := os.ReadFile(dst)
+	req.NoError(err)
+	req.Equal(data, result)
+	req.NoFileExists(dst + partialFileSuffix)
+	req.NoFileExists(dst + partialFileMetaSuffix)
+}
+
+func Test_SendFile_Resume(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+
+	data := make([]byte, 10*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	src := filepath.Join(dir, "src.bin")
+	dst := filepath.Join(dir, "dst.bin")
+	req.NoError(os.WriteFile(src, data, 0600))
+
+	stat, err := os.Stat(src)
+	req.NoError(err)
+	offer := &fileOffer{Name: "src.bin", Size: stat.Size()}
+	offer.Id = fileTransferId(offer.Name, stat)
+
+	// simulate an interrupted transfer that wrote two and a half chunks
+	req.NoError(os.WriteFile(dst+partialFileSuffix, data[:2*1024+512], 0600))
+	req.NoError(os.WriteFile(dst+partialFileMetaSuffix, []byte(offer.Id), 0600))
+
+	_, received, sendErr, receiveErr := transferFile(t, src, dst, 1024)
+	req.NoError(sendErr)
+	req.NoError(receiveErr)
+	req.Equal(int64(2*1024), received.ResumedAt)
+
+	result, err := os.ReadFile(dst)
+	req.NoError(err)
+	req.Equal(data, result)
+
+	// a corrupt partial file fails the whole file checksum, and is not resumed from again
+	req.NoError(os.WriteFile(dst+partialFileSuffix, bytes.Repeat([]byte{0}, 4*1024), 0600))
+	req.NoError(os.WriteFile(dst+partialFileMetaSuffix, []byte(offer.Id), 0600))
+
+	_, _, sendErr, receiveErr = transferFile(t, src, dst, 1024)
+	req.ErrorContains(sendErr, "checksum mismatch")
+	req.ErrorContains(receiveErr, "checksum mismatch")
+
+	_, received, sendErr, receiveErr = transferFile(t, src, dst, 1024)
+	req.NoError(sendErr)
+	req.NoError(receiveErr)
+	req.Zero(received.ResumedAt)
+}
@@ -0,0 +1,182 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/concurrenz"
+)
+
+// FeatureFlagsAppDataKey is the identity app data key holding the feature flags of the identity, as an object mapping
+// flag names to values, e.g. {"featureFlags": {"compression": true, "batchSize": 100}}.
+const FeatureFlagsAppDataKey = "featureFlags"
+
+// FeatureFlags gives access to the feature flags set on the current identity in the controller, so that client
+// behaviors can be toggled centrally. Flags are read when the Context authenticates and re-read on every service
+// refresh, so values may change between calls. Flags that are not set, or have a value of the wrong type, read as the
+// zero value of the accessor used. The zero value has no flags.
+type FeatureFlags struct {
+	values concurrenz.AtomicValue[map[string]interface{}]
+}
+
+// Lookup returns the raw value of the named flag and whether it is set. Numbers are float64, as decoded from JSON.
+func (self *FeatureFlags) Lookup(name string) (interface{}, bool) {
+	v, found := self.values.Load()[name]
+	return v, found
+}
+
+// Names returns the names of all set flags.
+func (self *FeatureFlags) Names() []string {
+	values := self.values.Load()
+	result := make([]string, 0, len(values))
+	for k := range values {
+		result = append(result, k)
+	}
+	return result
+}
+
+// IsSet returns true if the named flag is set, regardless of its value.
+func (self *FeatureFlags) IsSet(name string) bool {
+	_, found := self.Lookup(name)
+	return found
+}
+
+// Bool returns the named flag as a bool. String values such as "true" and "0" are parsed.
+func (self *FeatureFlags) Bool(name string) bool {
+	return self.BoolOr(name, false)
+}
+
+// BoolOr returns the named flag as a bool, or def if it is not set or not a bool.
+func (self *FeatureFlags) BoolOr(name string, def bool) bool {
+	switch v := self.valueOf(name).(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// String returns the named flag as a string. Scalar values of other types are formatted.
+func (self *FeatureFlags) String(name string) string {
+	return self.StringOr(name, "")
+}
+
+// StringOr returns the named flag as a string, or def if it is not set or not a scalar.
+func (self *FeatureFlags) StringOr(name string, def string) string {
+	switch v := self.valueOf(name).(type) {
+	case string:
+		return v
+	case bool, float64:
+		return fmt.Sprint(v)
+	}
+	return def
+}
+
+// Int returns the named flag as an int. Fractional numbers are truncated and numeric strings are parsed.
+func (self *FeatureFlags) Int(name string) int {
+	return self.IntOr(name, 0)
+}
+
+// IntOr returns the named flag as an int, or def if it is not set or not a number.
+func (self *FeatureFlags) IntOr(name string, def int) int {
+	switch v := self.valueOf(name).(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// Duration returns the named flag as a duration, parsed from a string such as "30s". Numbers are taken as seconds.
+func (self *FeatureFlags) Duration(name string) time.Duration {
+	return self.DurationOr(name, 0)
+}
+
+// DurationOr returns the named flag as a duration, or def if it is not set or not a duration.
+func (self *FeatureFlags) DurationOr(name string, def time.Duration) time.Duration {
+	switch v := self.valueOf(name).(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func (self *FeatureFlags) valueOf(name string) interface{} {
+	v, _ := self.Lookup(name)
+	return v
+}
+
+// update replaces the flags with those in the identity's app data, returning true if they changed.
+func (self *FeatureFlags) update(identity *rest_model.IdentityDetail) bool {
+	var values map[string]interface{}
+	if identity != nil && identity.AppData != nil {
+		values, _ = identity.AppData.SubTags[FeatureFlagsAppDataKey].(map[string]interface{})
+	}
+
+	current := self.values.Load()
+	if flagsEqual(current, values) {
+		return false
+	}
+	self.values.Store(values)
+	return true
+}
+
+func flagsEqual(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		other, found := b[k]
+		if !found || fmt.Sprint(v) != fmt.Sprint(other) {
+			return false
+		}
+	}
+	return true
+}
+
+func (context *ContextImpl) Flags() *FeatureFlags {
+	return &context.flags
+}
+
+// refreshFlags re-reads the feature flags of the current identity.
+func (context *ContextImpl) refreshFlags() {
+	identity, err := context.CtrlClt.GetCurrentIdentity()
+	if err != nil {
+		context.log().WithError(err).Debug("unable to read current identity, feature flags not refreshed")
+		return
+	}
+
+	if context.flags.update(identity) {
+		context.log().WithField("flags", context.flags.Names()).Info("feature flags updated")
+	}
+}
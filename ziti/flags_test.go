@@ -0,0 +1,51 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	req := require.New(t)
+
+	flags := &FeatureFlags{}
+	req.False(flags.Bool("compression"))
+	req.Empty(flags.Names())
+
+	identity := &rest_model.IdentityDetail{}
+	identity.AppData = &rest_model.Tags{SubTags: rest_model.SubTags{
+		"unrelated": true,
+		FeatureFlagsAppDataKey: map[string]interface{}{
+			"compression": true,
+			"tracing":     "1",
+			"batchSize":   float64(100),
+			"endpoint":    "east",
+			"flushEvery":  "250ms",
+			"timeout":     float64(2),
+		},
+	}}
+
+	req.True(flags.update(identity))
+	req.False(flags.update(identity))
+	req.Len(flags.Names(), 6)
+	req.False(flags.IsSet("unrelated"))
+
+	req.True(flags.Bool("compression"))
+	req.True(flags.Bool("tracing"))
+	req.False(flags.Bool("endpoint"))
+	req.True(flags.BoolOr("missing", true))
+
+	req.Equal(100, flags.Int("batchSize"))
+	req.Equal(7, flags.IntOr("endpoint", 7))
+	req.Equal("east", flags.String("endpoint"))
+	req.Equal("100", flags.String("batchSize"))
+	req.Equal(250*time.Millisecond, flags.Duration("flushEvery"))
+	req.Equal(2*time.Second, flags.Duration("timeout"))
+
+	identity.AppData = nil
+	req.True(flags.update(identity))
+	req.False(flags.IsSet("compression"))
+}
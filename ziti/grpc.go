@@ -0,0 +1,99 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GRPCScheme is the URI scheme of gRPC targets and addresses naming Ziti services.
+const GRPCScheme = "ziti"
+
+// GRPCDialer returns a dialer for grpc.WithContextDialer, so that gRPC clients reach servers hosting Ziti services.
+// The address being dialed is either a plain service name, e.g. passing "my-service" as the gRPC target, or an address
+// in the form returned by GRPCAddress, which may also select the terminator to dial. gRPC servers need no adapter, as
+// the edge.Listener returned by Context.Listen can be passed to grpc.Server.Serve.
+func GRPCDialer(ztx Context) func(ctx gocontext.Context, addr string) (net.Conn, error) {
+	return func(ctx gocontext.Context, addr string) (net.Conn, error) {
+		serviceName, identity, err := ParseGRPCAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		if identity != "" {
+			return ztx.DialWithContext(ctx, serviceName, WithTerminatorIdentity(identity))
+		}
+		return ztx.DialWithContext(ctx, serviceName)
+	}
+}
+
+// GRPCAddress returns the address of the named service for gRPC, e.g. "ziti:///my-service". If identity is set, the
+// address dials the terminator hosted with that instance identity, e.g. "ziti:///my-service?identity=host-1".
+func GRPCAddress(serviceName, identity string) string {
+	result := GRPCScheme + ":///" + url.PathEscape(serviceName)
+	if identity != "" {
+		result += "?" + url.Values{"identity": []string{identity}}.Encode()
+	}
+	return result
+}
+
+// ParseGRPCAddress returns the service name and terminator identity of an address returned by GRPCAddress, which may
+// also be given as "ziti://my-service". Addresses without the ziti scheme are taken as a service name.
+func ParseGRPCAddress(addr string) (serviceName, identity string, err error) {
+	if !strings.HasPrefix(addr, GRPCScheme+":") {
+		return addr, "", nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid gRPC address '%s'", addr)
+	}
+
+	serviceName = strings.TrimPrefix(u.Path, "/")
+	if serviceName == "" {
+		serviceName = u.Host
+	}
+	if serviceName == "" {
+		return "", "", errors.Errorf("gRPC address '%s' names no service", addr)
+	}
+	return serviceName, u.Query().Get("identity"), nil
+}
+
+// GRPCTerminatorAddresses returns an address per instance identity of the service's addressable terminators, or the
+// address of the service itself if it has none. A gRPC resolver for the ziti scheme reports these addresses, so that
+// balancers such as round_robin keep a subconnection to each terminator rather than leaving the choice of terminator
+// to the router.
+func GRPCTerminatorAddresses(ztx Context, serviceName string) ([]string, error) {
+	identities, err := ztx.GetServiceTerminatorIdentities(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(identities) == 0 {
+		return []string{GRPCAddress(serviceName, "")}, nil
+	}
+
+	result := make([]string, 0, len(identities))
+	for _, identity := range identities {
+		result = append(result, GRPCAddress(serviceName, identity))
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package ziti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type grpcTestContext struct {
+	Context
+	identities []string
+}
+
+func (self *grpcTestContext) GetServiceTerminatorIdentities(string) ([]string, error) {
+	return self.identities, nil
+}
+
+func Test_ParseGRPCAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		service  string
+		identity string
+	}{
+		{addr: "my-service", service: "my-service"},
+		{addr: "ziti:///my-service", service: "my-service"},
+		{addr: "ziti://my-service", service: "my-service"},
+		{addr: "ziti:///my-service?identity=host-1", service: "my-service", identity: "host-1"},
+		{addr: GRPCAddress("my service/v2", "host 1"), service: "my service/v2", identity: "host 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.addr, func(t *testing.T) {
+			service, identity, err := ParseGRPCAddress(test.addr)
+			require.NoError(t, err)
+			require.Equal(t, test.service, service)
+			require.Equal(t, test.identity, identity)
+		})
+	}
+
+	_, _, err := ParseGRPCAddress("ziti:///")
+	require.Error(t, err)
+}
+
+func Test_GRPCTerminatorAddresses(t *testing.T) {
+	req := require.New(t)
+
+	addrs, err := GRPCTerminatorAddresses(&grpcTestContext{}, "svc")
+	req.NoError(err)
+	req.Equal([]string{"ziti:///svc"}, addrs)
+
+	addrs, err = GRPCTerminatorAddresses(&grpcTestContext{identities: []string{"a", "b"}}, "svc")
+	req.NoError(err)
+	req.Equal([]string{"ziti:///svc?identity=a", "ziti:///svc?identity=b"}, addrs)
+}
@@ -0,0 +1,256 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxHealthErrors is the number of recent errors reported by Health.
+const maxHealthErrors = 5
+
+// Health is a point-in-time summary of the state of a Context, for readiness and liveness probes. See HealthHandler.
+type Health struct {
+	// Closed is set once the Context has been closed.
+	Closed bool `json:"closed"`
+
+	// ShuttingDown is set once Context.Shutdown has been called, and new dials and listens are refused.
+	ShuttingDown bool `json:"shuttingDown"`
+
+	// ConnectionState is the stage the Context is at in connecting to the network.
+	ConnectionState ConnectionState `json:"connectionState"`
+
+	// Authenticated is set if the Context has an API Session with no pending authentication queries.
+	Authenticated bool `json:"authenticated"`
+
+	// PendingAuthQueries is the number of authentication queries, such as MFA, the API Session is waiting on.
+	PendingAuthQueries int `json:"pendingAuthQueries"`
+
+	// ControllerReachable is set if the last request to the controller got a response.
+	ControllerReachable bool `json:"controllerReachable"`
+
+	// LastControllerContact is when the controller last answered a request, or zero if it has not yet.
+	LastControllerContact time.Time `json:"lastControllerContact"`
+
+	// ControllerError is the error of the last request that got no response from the controller, if the controller
+	// has not answered since.
+	ControllerError string `json:"controllerError,omitempty"`
+
+	// RoutersConnected is the number of open edge router connections, and RoutersHealthy the number of those answering
+	// keepalives.
+	RoutersConnected int `json:"routersConnected"`
+	RoutersHealthy   int `json:"routersHealthy"`
+
+	// ServicesLoaded is set once the services of the current API Session have been listed. ServiceCount is the number
+	// of services the identity has access to.
+	ServicesLoaded bool `json:"servicesLoaded"`
+	ServiceCount   int  `json:"serviceCount"`
+
+	// CertExpiresAt is when the certificate the identity authenticates with expires, if it uses one.
+	// CertDaysToExpiry is the number of whole days until then, negative once it has expired, and is only meaningful
+	// if CertExpiresAt is set.
+	CertExpiresAt    *time.Time `json:"certExpiresAt,omitempty"`
+	CertDaysToExpiry int        `json:"certDaysToExpiry"`
+
+	// ApiSessionNextRefresh and ApiSessionRefreshError report the background API Session refresh. See
+	// Context.ApiSessionRefreshStatus.
+	ApiSessionNextRefresh  time.Time `json:"apiSessionNextRefresh"`
+	ApiSessionRefreshError string    `json:"apiSessionRefreshError,omitempty"`
+
+	// LastErrors are the most recent errors recorded in RecentEvents, newest first.
+	LastErrors []HealthError `json:"lastErrors"`
+}
+
+// HealthError is an error reported by Health.
+type HealthError struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+	Error  string    `json:"error"`
+}
+
+// Live reports whether the Context is still running, for liveness probes.
+func (self *Health) Live() bool {
+	return !self.Closed
+}
+
+// Ready reports whether the Context can dial and host services: it is not shutting down, is authenticated, has listed
+// its services and is connected to at least one healthy edge router.
+func (self *Health) Ready() bool {
+	return self.Live() && !self.ShuttingDown && self.Authenticated && self.ServicesLoaded && self.RoutersHealthy > 0
+}
+
+func (context *ContextImpl) Health() *Health {
+	result := &Health{
+		Closed:          context.closed.Load(),
+		ShuttingDown:    context.shuttingDown.Load(),
+		ConnectionState: context.ConnectionState(),
+		LastErrors:      []HealthError{},
+	}
+
+	if context.CtrlClt != nil {
+		if apiSession := context.CtrlClt.GetCurrentApiSession(); apiSession != nil {
+			result.PendingAuthQueries = len(apiSession.GetAuthQueries())
+			result.Authenticated = result.PendingAuthQueries == 0
+		}
+
+		if cert := context.identityCert(); cert != nil {
+			expiresAt := cert.NotAfter
+			result.CertExpiresAt = &expiresAt
+			result.CertDaysToExpiry = int(time.Until(expiresAt) / (24 * time.Hour))
+		}
+	}
+
+	result.ControllerReachable, result.LastControllerContact, result.ControllerError = context.controllerContact.get()
+
+	for entry := range context.routerConnections.IterBuffered() {
+		if entry.Val.IsClosed() {
+			continue
+		}
+		result.RoutersConnected++
+		if context.isRouterConnHealthy(entry.Val) {
+			result.RoutersHealthy++
+		}
+	}
+
+	result.ServicesLoaded = context.servicesLoaded.Load()
+	result.ServiceCount = context.services.Count()
+
+	refreshStatus := context.ApiSessionRefreshStatus()
+	result.ApiSessionNextRefresh = refreshStatus.NextRefresh
+	if refreshStatus.LastError != nil {
+		result.ApiSessionRefreshError = refreshStatus.LastError.Error()
+	}
+
+	if context.recentEvents != nil {
+		recent := context.recentEvents.snapshot()
+		for i := len(recent) - 1; i >= 0 && len(result.LastErrors) < maxHealthErrors; i-- {
+			if event := recent[i]; event.Err != nil {
+				result.LastErrors = append(result.LastErrors, HealthError{
+					Time:   event.Time,
+					Event:  string(event.Name),
+					Detail: event.Detail,
+					Error:  event.Err.Error(),
+				})
+			}
+		}
+	}
+
+	return result
+}
+
+// identityCert returns the certificate the identity authenticates with, if any.
+func (context *ContextImpl) identityCert() *x509.Certificate {
+	if context.CtrlClt.Credentials == nil {
+		return nil
+	}
+
+	for _, tlsCert := range context.CtrlClt.Credentials.TlsCerts() {
+		if tlsCert.Leaf != nil {
+			return tlsCert.Leaf
+		}
+		if len(tlsCert.Certificate) > 0 {
+			if cert, err := x509.ParseCertificate(tlsCert.Certificate[0]); err == nil {
+				return cert
+			}
+		}
+	}
+	return nil
+}
+
+// HealthHandler returns a http.Handler serving the Health of ztx as JSON, for readiness probes. It answers with
+// status 200 if the Context is ready and 503 otherwise. If live is set, the status reflects Health.Live instead, for
+// liveness probes.
+func HealthHandler(ztx Context, live bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		health := ztx.Health()
+
+		ok := health.Ready()
+		if live {
+			ok = health.Live()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(health)
+	})
+}
+
+// controllerContact tracks whether the controller answers requests.
+type controllerContact struct {
+	lock        sync.Mutex
+	reachable   bool
+	lastContact time.Time
+	lastErr     error
+}
+
+func (self *controllerContact) record(err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.reachable = err == nil
+	self.lastErr = err
+	if err == nil {
+		self.lastContact = time.Now()
+	}
+}
+
+func (self *controllerContact) get() (bool, time.Time, string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var errStr string
+	if self.lastErr != nil {
+		errStr = self.lastErr.Error()
+	}
+	return self.reachable, self.lastContact, errStr
+}
+
+// controllerContactTransport is a http.RoundTripper recording whether each controller request got a response.
+type controllerContactTransport struct {
+	next    http.RoundTripper
+	contact *controllerContact
+}
+
+func newControllerContactTransport(next http.RoundTripper, contact *controllerContact) *controllerContactTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &controllerContactTransport{
+		next:    next,
+		contact: contact,
+	}
+}
+
+func (self *controllerContactTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := self.next.RoundTrip(req)
+	if req.Context().Err() == nil {
+		self.contact.record(err)
+	}
+	return resp, err
+}
@@ -0,0 +1,154 @@
+package ziti
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newHealthTestContext() *ContextImpl {
+	return &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		unhealthyRouters:  cmap.New[struct{}](),
+		services:          cmap.New[*rest_model.ServiceDetail](),
+		recentEvents:      newRecentEventRing(8),
+	}
+}
+
+func Test_contextImpl_Health(t *testing.T) {
+	req := require.New(t)
+
+	ctx := newHealthTestContext()
+	ctx.services.Set("svc", newTestService("svc"))
+	ctx.servicesLoaded.Store(true)
+	ctx.routerConnections.Set("tls:er1:3022", &testRouterConn{name: "er1", key: "tls:er1:3022"})
+	ctx.routerConnections.Set("tls:er2:3022", &testRouterConn{name: "er2", key: "tls:er2:3022"})
+	ctx.unhealthyRouters.Set("tls:er2:3022", struct{}{})
+	ctx.controllerContact.record(nil)
+
+	ctx.recordError(RecentServiceRefreshFailed, errors.New("first"))
+	ctx.recentEvents.add(newRecentEvent(EventRouterConnected, "er1", "tls:er1:3022"))
+	ctx.recordError(RecentApiSessionRefreshFailed, errors.New("second"))
+
+	health := ReadOnly(ctx).Health()
+	req.True(health.Live())
+	req.False(health.Authenticated)
+	req.False(health.Ready())
+	req.True(health.ControllerReachable)
+	req.False(health.LastControllerContact.IsZero())
+	req.Equal(2, health.RoutersConnected)
+	req.Equal(1, health.RoutersHealthy)
+	req.True(health.ServicesLoaded)
+	req.Equal(1, health.ServiceCount)
+	req.Nil(health.CertExpiresAt)
+
+	req.Len(health.LastErrors, 2)
+	req.Equal("second", health.LastErrors[0].Error)
+	req.Equal(string(RecentApiSessionRefreshFailed), health.LastErrors[0].Event)
+	req.Equal("first", health.LastErrors[1].Error)
+
+	health.Authenticated = true
+	req.True(health.Ready())
+
+	ctx.controllerContact.record(errors.New("connection refused"))
+	health = ctx.Health()
+	req.False(health.ControllerReachable)
+	req.Equal("connection refused", health.ControllerError)
+}
+
+func Test_contextImpl_Health_certExpiry(t *testing.T) {
+	req := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	notAfter := time.Now().Add(10*24*time.Hour + time.Hour).Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	req.NoError(err)
+
+	ctx := newHealthTestContext()
+	ctx.CtrlClt = &CtrlClient{
+		ClientApiClient: &edge_apis.ClientApiClient{},
+		Credentials:     edge_apis.NewCertCredentials([]*x509.Certificate{cert}, key),
+	}
+
+	health := ctx.Health()
+	req.NotNil(health.CertExpiresAt)
+	req.True(notAfter.Equal(*health.CertExpiresAt))
+	req.Equal(10, health.CertDaysToExpiry)
+}
+
+func TestHealthHandler(t *testing.T) {
+	req := require.New(t)
+
+	ctx := newHealthTestContext()
+
+	recorder := httptest.NewRecorder()
+	HealthHandler(ctx, false).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	req.Equal(http.StatusServiceUnavailable, recorder.Code)
+
+	health := &Health{}
+	req.NoError(json.Unmarshal(recorder.Body.Bytes(), health))
+	req.False(health.Closed)
+	req.NotNil(health.LastErrors)
+
+	recorder = httptest.NewRecorder()
+	HealthHandler(ctx, true).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/live", nil))
+	req.Equal(http.StatusOK, recorder.Code)
+
+	ctx.closed.Store(true)
+	recorder = httptest.NewRecorder()
+	HealthHandler(ctx, true).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/live", nil))
+	req.Equal(http.StatusServiceUnavailable, recorder.Code)
+}
+
+func Test_controllerContactTransport(t *testing.T) {
+	req := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	contact := &controllerContact{}
+	client := &http.Client{Transport: newControllerContactTransport(&http.Transport{}, contact)}
+
+	resp, err := client.Get(server.URL)
+	req.NoError(err)
+	_ = resp.Body.Close()
+
+	reachable, lastContact, errStr := contact.get()
+	req.True(reachable)
+	req.False(lastContact.IsZero())
+	req.Empty(errStr)
+
+	server.Close()
+	_, err = client.Get(server.URL)
+	req.Error(err)
+
+	reachable, _, errStr = contact.get()
+	req.False(reachable)
+	req.NotEmpty(errStr)
+}
@@ -0,0 +1,161 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTPServerOption modifies how NewHTTPServer and ListenAndServeZiti host a http.Handler.
+type HTTPServerOption func(options *httpServerOptions)
+
+type httpServerOptions struct {
+	tlsConfig     *tls.Config
+	h2c           bool
+	listenOptions []ListenOption
+	configure     func(server *http.Server)
+}
+
+// WithHTTPServerTLS serves HTTPS on top of the service connections, using config for the TLS handshake. The config
+// must provide a certificate, either in Certificates or through GetCertificate. HTTP/2 is negotiated through ALPN.
+func WithHTTPServerTLS(config *tls.Config) HTTPServerOption {
+	return func(options *httpServerOptions) {
+		options.tlsConfig = config
+	}
+}
+
+// WithH2C accepts HTTP/2 without TLS, either with prior knowledge or as an upgrade from HTTP/1.1, as used by gRPC and
+// other HTTP/2 clients over connections that are already encrypted end-to-end. It has no effect together with
+// WithHTTPServerTLS, which negotiates HTTP/2 during the handshake.
+func WithH2C() HTTPServerOption {
+	return func(options *httpServerOptions) {
+		options.h2c = true
+	}
+}
+
+// WithHTTPServerListenOptions sets the options the service is bound with.
+func WithHTTPServerListenOptions(opts ...ListenOption) HTTPServerOption {
+	return func(options *httpServerOptions) {
+		options.listenOptions = append(options.listenOptions, opts...)
+	}
+}
+
+// WithHTTPServerConfig calls configure with the http.Server before it starts serving, e.g. to set timeouts or an
+// ErrorLog. The Handler must not be replaced.
+func WithHTTPServerConfig(configure func(server *http.Server)) HTTPServerOption {
+	return func(options *httpServerOptions) {
+		options.configure = configure
+	}
+}
+
+// HTTPServer is a http.Server bound to a Ziti service. Shutdown and Close stop the server and close the service
+// listener, even if Serve was never called.
+type HTTPServer struct {
+	*http.Server
+	listener  edge.Listener
+	tlsConfig *tls.Config
+}
+
+// NewHTTPServer binds serviceName with ztx and returns a server that serves handler to the service's clients once
+// Serve is called.
+func NewHTTPServer(ztx Context, serviceName string, handler http.Handler, opts ...HTTPServerOption) (*HTTPServer, error) {
+	options := &httpServerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.tlsConfig != nil && len(options.tlsConfig.Certificates) == 0 && options.tlsConfig.GetCertificate == nil &&
+		options.tlsConfig.GetConfigForClient == nil {
+		return nil, errors.New("tls config for http server has no certificate")
+	}
+
+	if options.h2c && options.tlsConfig == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	listener, err := ztx.ListenWithOptions(serviceName, NewListenOptions(options.listenOptions...))
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:   handler,
+		TLSConfig: options.tlsConfig,
+	}
+	if options.configure != nil {
+		options.configure(server)
+	}
+
+	return &HTTPServer{
+		Server:    server,
+		listener:  listener,
+		tlsConfig: options.tlsConfig,
+	}, nil
+}
+
+// Listener returns the listener of the service the server is bound to.
+func (self *HTTPServer) Listener() edge.Listener {
+	return self.listener
+}
+
+// Serve serves requests until the server is shut down or closed, returning http.ErrServerClosed, or until the
+// service listener fails.
+func (self *HTTPServer) Serve() error {
+	if self.tlsConfig != nil {
+		return self.Server.ServeTLS(self.listener, "", "")
+	}
+	return self.Server.Serve(self.listener)
+}
+
+// Shutdown gracefully shuts down the server, waiting for active requests to complete until ctx is done. See
+// http.Server.Shutdown.
+func (self *HTTPServer) Shutdown(ctx gocontext.Context) error {
+	err := self.Server.Shutdown(ctx)
+	return self.closeListener(err)
+}
+
+// Close immediately closes the server and all of its connections. See http.Server.Close.
+func (self *HTTPServer) Close() error {
+	err := self.Server.Close()
+	return self.closeListener(err)
+}
+
+// closeListener closes the service listener, which the http.Server only closes once Serve was called.
+func (self *HTTPServer) closeListener(err error) error {
+	if closeErr := self.listener.Close(); err == nil && closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+		err = closeErr
+	}
+	return err
+}
+
+// ListenAndServeZiti binds serviceName with ztx and serves handler to the service's clients. It always returns a
+// non-nil error. Use NewHTTPServer to be able to shut the server down.
+func ListenAndServeZiti(ztx Context, serviceName string, handler http.Handler, opts ...HTTPServerOption) error {
+	server, err := NewHTTPServer(ztx, serviceName, handler, opts...)
+	if err != nil {
+		return err
+	}
+	return server.Serve()
+}
@@ -0,0 +1,132 @@
+package ziti
+
+import (
+	gocontext "context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+type serverTestContext struct {
+	Context
+	listener *serverTestListener
+}
+
+func (self *serverTestContext) ListenWithOptions(string, *ListenOptions) (edge.Listener, error) {
+	return self.listener, nil
+}
+
+type serverTestListener struct {
+	edge.Listener
+	tcp    net.Listener
+	closed bool
+}
+
+func (self *serverTestListener) Accept() (net.Conn, error) {
+	return self.tcp.Accept()
+}
+
+func (self *serverTestListener) AcceptEdge() (edge.Conn, error) {
+	conn, err := self.tcp.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyTestConn{conn: conn}, nil
+}
+
+func (self *serverTestListener) IsClosed() bool {
+	return self.closed
+}
+
+func (self *serverTestListener) Addr() net.Addr {
+	return self.tcp.Addr()
+}
+
+func (self *serverTestListener) Close() error {
+	self.closed = true
+	return self.tcp.Close()
+}
+
+func newServerTestContext(t *testing.T) *serverTestContext {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return &serverTestContext{listener: &serverTestListener{tcp: l}}
+}
+
+func Test_HTTPServer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	})
+
+	t.Run("serves http", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newServerTestContext(t)
+
+		server, err := NewHTTPServer(ztx, "test", handler)
+		req.NoError(err)
+		errC := make(chan error, 1)
+		go func() { errC <- server.Serve() }()
+
+		resp, err := http.Get("http://" + ztx.listener.Addr().String() + "/hello")
+		req.NoError(err)
+		body, err := io.ReadAll(resp.Body)
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal("HTTP/1.1 /hello", string(body))
+
+		ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 5*time.Second)
+		defer cancel()
+		req.NoError(server.Shutdown(ctx))
+		req.ErrorIs(<-errC, http.ErrServerClosed)
+		req.True(ztx.listener.closed)
+	})
+
+	t.Run("serves h2c", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newServerTestContext(t)
+
+		server, err := NewHTTPServer(ztx, "test", handler, WithH2C())
+		req.NoError(err)
+		go func() { _ = server.Serve() }()
+		defer func() { _ = server.Close() }()
+
+		client := &http.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx gocontext.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				},
+			},
+		}
+		resp, err := client.Get("http://" + ztx.listener.Addr().String() + "/h2")
+		req.NoError(err)
+		body, err := io.ReadAll(resp.Body)
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal("HTTP/2.0 /h2", string(body))
+	})
+
+	t.Run("close before serve closes the listener", func(t *testing.T) {
+		req := require.New(t)
+		ztx := newServerTestContext(t)
+
+		server, err := NewHTTPServer(ztx, "test", handler)
+		req.NoError(err)
+		req.NoError(server.Close())
+		req.True(ztx.listener.closed)
+		req.ErrorIs(server.Serve(), http.ErrServerClosed)
+	})
+
+	t.Run("tls requires a certificate", func(t *testing.T) {
+		_, err := NewHTTPServer(newServerTestContext(t), "test", handler, WithHTTPServerTLS(&tls.Config{}))
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,116 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// HTTPTransportOption modifies how the transport returned by NewHTTPTransport maps hosts to services.
+type HTTPTransportOption func(options *httpTransportOptions)
+
+type httpTransportOptions struct {
+	services map[string]string
+}
+
+// WithHTTPServices maps request hosts to the services dialed for them. Keys are either a host, e.g. "api.internal",
+// or a host and port, e.g. "api.internal:8080", which takes precedence. IPv6 addresses may be given with or without
+// brackets when there is no port, e.g. "[2001:db8::1]" or "2001:db8::1", and with brackets otherwise, e.g.
+// "[2001:db8::1]:8080".
+func WithHTTPServices(services map[string]string) HTTPTransportOption {
+	return func(options *httpTransportOptions) {
+		if options.services == nil {
+			options.services = map[string]string{}
+		}
+		for host, service := range services {
+			options.services[canonicalHost(host)] = service
+		}
+	}
+}
+
+// NewHTTPTransport returns a http.Transport dialing requests over ztx, so that existing HTTP clients work over Ziti
+// by setting it as their Transport. The service dialed for a request is, in order of preference, the one mapped to
+// its host with WithHTTPServices, the one whose intercept config best matches the host and port, or the service named
+// after the host. The transport pools connections per host and port as usual, and HTTPS requests are secured by
+// TLSClientConfig on top of the service connection.
+func NewHTTPTransport(ztx Context, opts ...HTTPTransportOption) *http.Transport {
+	options := &httpTransportOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &http.Transport{
+		DialContext: func(ctx gocontext.Context, network, addr string) (net.Conn, error) {
+			return options.dial(ctx, ztx, network, addr)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// canonicalHost returns a host, or host and port, with IP addresses in canonical form, so that the services mapped
+// with WithHTTPServices are found however the addresses are written.
+func canonicalHost(hostport string) string {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		if ip, ok := edge.ParseHostIP(host); ok {
+			return net.JoinHostPort(ip.String(), port)
+		}
+		return hostport
+	}
+	if ip, ok := edge.ParseHostIP(hostport); ok {
+		return ip.String()
+	}
+	return hostport
+}
+
+func (self *httpTransportOptions) dial(ctx gocontext.Context, ztx Context, network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if service, found := self.services[canonicalHost(addr)]; found {
+		return ztx.DialWithContext(ctx, service)
+	}
+	if service, found := self.services[canonicalHost(host)]; found {
+		return ztx.DialWithContext(ctx, service)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	network = normalizeProtocol(network)
+	if svc, _, err := ztx.GetServiceForAddr(network, host, uint16(port)); err == nil {
+		return ztx.DialWithContext(ctx, *svc.Name, WithAppData(interceptAppData(network, host, uint16(port))))
+	}
+
+	if _, found := ztx.GetService(host); found {
+		return ztx.DialWithContext(ctx, host)
+	}
+
+	return nil, &ServiceNotFoundError{ServiceName: host}
+}
@@ -0,0 +1,68 @@
+package ziti
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+// transportTestContext resolves intercepts of intercepted.internal and knows the service named named-svc.
+type transportTestContext struct {
+	proxyTestContext
+}
+
+func (self *transportTestContext) GetServiceForAddr(_, hostname string, _ uint16) (*rest_model.ServiceDetail, int, error) {
+	if hostname == "intercepted.internal" {
+		return &rest_model.ServiceDetail{Name: ToPtr("intercept-svc")}, 0, nil
+	}
+	return nil, -1, &ServiceNotFoundError{ServiceName: hostname}
+}
+
+func (self *transportTestContext) GetService(serviceName string) (*rest_model.ServiceDetail, bool) {
+	if serviceName == "named-svc" {
+		return &rest_model.ServiceDetail{Name: ToPtr(serviceName)}, true
+	}
+	return nil, false
+}
+
+func Test_NewHTTPTransport(t *testing.T) {
+	req := require.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host)
+	}))
+	defer backend.Close()
+
+	ztx := &transportTestContext{proxyTestContext{addr: backend.Listener.Addr().String()}}
+	client := &http.Client{
+		Transport: NewHTTPTransport(ztx, WithHTTPServices(map[string]string{
+			"mapped.internal":      "mapped-svc",
+			"mapped.internal:8080": "mapped-port-svc",
+		})),
+	}
+
+	get := func(url string) string {
+		resp, err := client.Get(url)
+		req.NoError(err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		req.NoError(err)
+		return string(body)
+	}
+
+	req.Equal("mapped.internal", get("http://mapped.internal/"))
+	req.Equal("mapped.internal:8080", get("http://mapped.internal:8080/"))
+	req.Equal("intercepted.internal", get("http://intercepted.internal/"))
+	req.Equal("named-svc", get("http://named-svc/"))
+	req.Equal("named-svc", get("http://named-svc/"))
+	req.Equal([]string{"mapped-svc", "mapped-port-svc", "intercept-svc", "named-svc"}, ztx.dials,
+		"connections should be reused for requests to the same host")
+
+	_, err := client.Get("http://unknown.internal/")
+	req.Error(err)
+	req.Contains(err.Error(), "service 'unknown.internal' not found")
+}
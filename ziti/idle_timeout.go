@@ -0,0 +1,91 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+)
+
+const (
+	// MetricListenerIdleEvictions is the meter of accepted connections closed by ListenOptions.IdleTimeout.
+	MetricListenerIdleEvictions = "listener.idle.evictions"
+
+	// MetricListenerIdleVetoes is the meter of evictions vetoed by ListenOptions.BeforeIdleClose.
+	MetricListenerIdleVetoes = "listener.idle.vetoes"
+)
+
+// GetIdleTimeoutConfig implements network.IdleTimeoutOwner, closing connections of the Context that carry no traffic
+// for Options.IdleTimeout, if set.
+func (context *ContextImpl) GetIdleTimeoutConfig() *network.IdleTimeoutConfig {
+	if context.options == nil || context.options.IdleTimeout <= 0 {
+		return nil
+	}
+
+	return &network.IdleTimeoutConfig{
+		Timeout: context.options.IdleTimeout,
+		OnIdle: func(conn edge.Conn, serviceName string, idle time.Duration) {
+			context.Emit(EventConnectionIdle, serviceName, conn, idle)
+		},
+	}
+}
+
+// listenBeforeIdleClose wraps the BeforeIdleClose callback of a listener to count vetoed evictions.
+func (context *ContextImpl) listenBeforeIdleClose(beforeClose func(conn edge.Conn, idle time.Duration) bool) func(conn edge.Conn, idle time.Duration) bool {
+	if beforeClose == nil {
+		return nil
+	}
+
+	return func(conn edge.Conn, idle time.Duration) bool {
+		if beforeClose(conn, idle) {
+			return true
+		}
+		if context.metrics != nil {
+			context.metrics.Meter(MetricListenerIdleVetoes).Mark(1)
+		}
+		return false
+	}
+}
+
+func (context *ContextImpl) AddConnectionIdleListener(handler func(ztx Context, serviceName string, conn edge.Conn, idle time.Duration)) func() {
+	listener := func(args ...interface{}) {
+		serviceName, ok := args[0].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", serviceName, args[0])
+		}
+
+		conn, ok := args[1].(edge.Conn)
+		if !ok {
+			context.log().Fatalf("could not convert args[1] to %T was %T", conn, args[1])
+		}
+
+		idle, ok := args[2].(time.Duration)
+		if !ok {
+			context.log().Fatalf("could not convert args[2] to %T was %T", idle, args[2])
+		}
+
+		handler(context, serviceName, conn, idle)
+	}
+
+	context.AddListener(EventConnectionIdle, listener)
+
+	return func() {
+		context.RemoveListener(EventConnectionIdle, listener)
+	}
+}
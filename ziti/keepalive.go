@@ -0,0 +1,114 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+)
+
+const (
+	DefaultKeepaliveSendInterval        = 15 * time.Second
+	DefaultKeepaliveCheckInterval       = time.Second
+	DefaultKeepaliveUnresponsiveTimeout = time.Minute
+	DefaultKeepaliveUnhealthyTimeout    = 30 * time.Second
+
+	// MetricKeepaliveRtt is the histogram of keepalive round trip times, in nanoseconds, across all edge routers.
+	MetricKeepaliveRtt = "keepalive.rtt"
+
+	// MetricKeepaliveFailures is the meter of edge router connections closed for not answering keepalives.
+	MetricKeepaliveFailures = "keepalive.failures"
+)
+
+// KeepaliveOptions tunes the keepalives sent on idle edge router connections. Keepalives are channel heartbeats, so
+// they are invisible to applications and are only sent when no other traffic has been sent for SendInterval. The
+// interval should be shorter than the shortest idle timeout of any NAT or firewall on the path to the edge routers.
+// Edge routers must answer heartbeats for UnresponsiveTimeout to be used.
+type KeepaliveOptions struct {
+	// SendInterval is how long a router connection may be idle before a keepalive is sent. Defaults to
+	// DefaultKeepaliveSendInterval if zero.
+	SendInterval time.Duration
+
+	// CheckInterval is how often router connections are checked for idleness. Defaults to
+	// DefaultKeepaliveCheckInterval if zero.
+	CheckInterval time.Duration
+
+	// UnresponsiveTimeout closes router connections that have not answered a keepalive for this long, so that they are
+	// re-established, and marks MetricKeepaliveFailures. If zero, keepalives are sent but never checked for answers.
+	UnresponsiveTimeout time.Duration
+
+	// UnhealthyTimeout marks router connections that have not answered a keepalive for this long as unhealthy. New
+	// dials avoid unhealthy routers while others are available, and EventRouterOffline and EventRouterOnline are
+	// emitted as routers stop and resume answering. Dials fail over within UnhealthyTimeout plus CheckInterval, so it
+	// should be longer than SendInterval and shorter than UnresponsiveTimeout. If zero, routers are never marked
+	// unhealthy.
+	UnhealthyTimeout time.Duration
+}
+
+// DefaultKeepaliveOptions returns keepalive options suitable for most NAT gateways.
+func DefaultKeepaliveOptions() *KeepaliveOptions {
+	return &KeepaliveOptions{
+		SendInterval:        DefaultKeepaliveSendInterval,
+		CheckInterval:       DefaultKeepaliveCheckInterval,
+		UnresponsiveTimeout: DefaultKeepaliveUnresponsiveTimeout,
+		UnhealthyTimeout:    DefaultKeepaliveUnhealthyTimeout,
+	}
+}
+
+// GetKeepaliveConfig implements network.KeepaliveOwner, enabling keepalives on the edge router connections of the
+// Context if Options.Keepalive is set.
+func (context *ContextImpl) GetKeepaliveConfig() *network.KeepaliveConfig {
+	if context.options == nil || context.options.Keepalive == nil {
+		return nil
+	}
+
+	options := context.options.Keepalive
+	config := &network.KeepaliveConfig{
+		SendInterval:        options.SendInterval,
+		CheckInterval:       options.CheckInterval,
+		UnresponsiveTimeout: options.UnresponsiveTimeout,
+		OnResponse: func(_ edge.RouterConn, rtt time.Duration) {
+			if context.metrics != nil {
+				context.metrics.Histogram(MetricKeepaliveRtt).Update(int64(rtt))
+			}
+		},
+		OnFailure: func(edge.RouterConn, time.Duration) {
+			if context.metrics != nil {
+				context.metrics.Meter(MetricKeepaliveFailures).Mark(1)
+			}
+		},
+		UnhealthyTimeout: options.UnhealthyTimeout,
+		OnUnhealthy: func(conn edge.RouterConn, _ time.Duration) {
+			context.markRouterUnhealthy(conn)
+		},
+		OnHealthy: func(conn edge.RouterConn) {
+			context.markRouterHealthy(conn)
+		},
+	}
+
+	if config.SendInterval <= 0 {
+		config.SendInterval = DefaultKeepaliveSendInterval
+	}
+
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = DefaultKeepaliveCheckInterval
+	}
+
+	return config
+}
@@ -0,0 +1,135 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+	"github.com/sirupsen/logrus"
+)
+
+// log returns the logger of the Context, which forwards to Options.LogHandler if set, or is the process wide pfxlog
+// logger otherwise.
+func (context *ContextImpl) log() *pfxlog.Builder {
+	return newLogger(context.logger)
+}
+
+// newLogger returns a builder logging to logger, or the process wide pfxlog logger if logger is nil.
+func newLogger(logger *logrus.Logger) *pfxlog.Builder {
+	if logger == nil {
+		return pfxlog.Logger()
+	}
+	return &pfxlog.Builder{Entry: logrus.NewEntry(logger)}
+}
+
+// contextLog returns the logger of ctx if it is a network.LoggerOwner, such as ContextImpl and the read-only view of
+// one, or the process wide pfxlog logger otherwise.
+func contextLog(ctx Context) *pfxlog.Builder {
+	if owner, ok := ctx.(network.LoggerOwner); ok {
+		return newLogger(owner.GetLogger())
+	}
+	return pfxlog.Logger()
+}
+
+// GetLogger implements network.LoggerOwner, returning the logger that edge router connections, and the connections and
+// listeners established over them, log to. It is nil if Options.LogHandler isn't set, in which case they log to
+// pfxlog.
+func (context *ContextImpl) GetLogger() *logrus.Logger {
+	return context.logger
+}
+
+// newHandlerLogger returns a logrus logger that hands every entry to handler instead of writing it. Levels are
+// filtered by handler, so that they may be changed at runtime, e.g. with a slog.LevelVar.
+func newHandlerLogger(handler slog.Handler) *logrus.Logger {
+	if handler == nil {
+		return nil
+	}
+
+	logger := &logrus.Logger{
+		Out:       io.Discard,
+		Formatter: discardFormatter{},
+		Hooks:     logrus.LevelHooks{},
+		Level:     logrus.TraceLevel,
+		ExitFunc:  os.Exit,
+	}
+	logger.AddHook(&slogHook{handler: handler})
+	return logger
+}
+
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// slogHook converts logrus entries to slog records. Entry fields become attributes, ordered by key.
+type slogHook struct {
+	handler slog.Handler
+}
+
+func (self *slogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (self *slogHook) Fire(entry *logrus.Entry) error {
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = gocontext.Background()
+	}
+
+	level := slogLevel(entry.Level)
+	if !self.handler.Enabled(ctx, level) {
+		return nil
+	}
+
+	record := slog.NewRecord(entry.Time, level, entry.Message, 0)
+
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		record.AddAttrs(slog.Any(k, entry.Data[k]))
+	}
+
+	return self.handler.Handle(ctx, record)
+}
+
+func slogLevel(level logrus.Level) slog.Level {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return slog.LevelError + 4
+	case logrus.ErrorLevel:
+		return slog.LevelError
+	case logrus.WarnLevel:
+		return slog.LevelWarn
+	case logrus.InfoLevel:
+		return slog.LevelInfo
+	case logrus.DebugLevel:
+		return slog.LevelDebug
+	default:
+		return slog.LevelDebug - 4
+	}
+}
@@ -0,0 +1,88 @@
+package ziti
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_contextImpl_logHandler(t *testing.T) {
+	req := require.New(t)
+
+	buf := &bytes.Buffer{}
+	level := &slog.LevelVar{}
+	level.Set(slog.LevelInfo)
+	handler := slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+
+	ctx := &ContextImpl{logger: newHandlerLogger(handler)}
+
+	ctx.log().WithField("service", "svc").WithError(errors.New("boom")).Warn("dial failed")
+	req.Equal("level=WARN msg=\"dial failed\" error=boom service=svc\n", buf.String())
+
+	buf.Reset()
+	ctx.log().Debugf("refreshing %d services", 3)
+	req.Empty(buf.String())
+
+	level.Set(slog.LevelDebug)
+	ctx.log().Debugf("refreshing %d services", 3)
+	req.Equal("level=DEBUG msg=\"refreshing 3 services\"\n", buf.String())
+
+	buf.Reset()
+	ctx.log().Trace("trace")
+	req.Empty(buf.String())
+}
+
+func Test_contextImpl_logDefault(t *testing.T) {
+	req := require.New(t)
+
+	req.Nil(newHandlerLogger(nil))
+
+	ctx := &ContextImpl{}
+	req.NotNil(ctx.log())
+
+	collection := NewSdkCollection()
+	req.NotNil(collection.log())
+}
+
+func Test_CtxCollection_logHandler(t *testing.T) {
+	req := require.New(t)
+
+	buf := &bytes.Buffer{}
+	collection := NewSdkCollection()
+	req.NotNil(collection.log())
+
+	// the handler may be set after the collection has logged
+	collection.LogHandler = slog.NewTextHandler(buf, nil)
+
+	collection.log().Error("failed to load collection member")
+	req.Contains(buf.String(), "level=ERROR msg=\"failed to load collection member\"")
+}
+
+func Test_contextLog(t *testing.T) {
+	req := require.New(t)
+
+	buf := &bytes.Buffer{}
+	ctx := &ContextImpl{Id: "ctx-1", logger: newHandlerLogger(slog.NewTextHandler(buf, nil))}
+
+	ReadOnly(ctx).SetCredentials(nil)
+	req.Contains(buf.String(), "level=WARN msg=\"SetCredentials not permitted on a read-only context\" contextId=ctx-1")
+
+	buf.Reset()
+	breaker := newControllerBreaker(nil, &ControllerBreakerOptions{}, nil, nil)
+	breaker.logger = ctx.logger
+	breaker.notify("ctrl.example.com", &breakerChange{state: BreakerOpen})
+	req.Contains(buf.String(), "msg=\"controller circuit breaker opened\" controller=ctrl.example.com")
+
+	req.NotNil(contextLog(&testContext{}))
+}
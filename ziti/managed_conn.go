@@ -0,0 +1,420 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// ManagedConnState describes the state of a ManagedConnection.
+type ManagedConnState int
+
+const (
+	// ManagedConnConnecting indicates a dial of the underlying connection is in progress.
+	ManagedConnConnecting ManagedConnState = iota
+
+	// ManagedConnConnected indicates the underlying connection is established.
+	ManagedConnConnected
+
+	// ManagedConnDisconnected indicates the underlying connection failed and a reconnect will be attempted.
+	ManagedConnDisconnected
+
+	// ManagedConnClosed indicates the ManagedConnection was closed or gave up reconnecting.
+	ManagedConnClosed
+)
+
+func (s ManagedConnState) String() string {
+	switch s {
+	case ManagedConnConnecting:
+		return "connecting"
+	case ManagedConnConnected:
+		return "connected"
+	case ManagedConnDisconnected:
+		return "disconnected"
+	case ManagedConnClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// WritePolicy determines how a ManagedConnection handles writes while it is not connected.
+type WritePolicy int
+
+const (
+	// WritePolicyBlock blocks writes until a connection is available. This is the default.
+	WritePolicyBlock WritePolicy = iota
+
+	// WritePolicyBuffer buffers writes, up to MaintainOptions.MaxBufferedBytes, and sends them once reconnected.
+	WritePolicyBuffer
+
+	// WritePolicyFail fails writes with ErrNotConnected.
+	WritePolicyFail
+
+	// WritePolicyDrop discards writes, reporting them as successful.
+	WritePolicyDrop
+)
+
+var (
+	// ErrNotConnected is returned by ManagedConnection writes when using WritePolicyFail and no connection is
+	// available.
+	ErrNotConnected = errors.New("managed connection is not connected")
+
+	// ErrWriteBufferFull is returned by ManagedConnection writes when using WritePolicyBuffer and the write would
+	// exceed MaintainOptions.MaxBufferedBytes.
+	ErrWriteBufferFull = errors.New("managed connection write buffer is full")
+)
+
+const DefaultMaxBufferedBytes = 1024 * 1024
+
+// MaintainOptions configures a ManagedConnection.
+type MaintainOptions struct {
+	// DialOptions are used for every dial of the service. If nil, the Context defaults are used.
+	DialOptions *DialOptions
+
+	// InitialReconnectInterval is the delay before the first redial after a failure. Defaults to 1 second.
+	InitialReconnectInterval time.Duration
+
+	// MaxReconnectInterval caps the exponential backoff between redials. Defaults to 30 seconds.
+	MaxReconnectInterval time.Duration
+
+	// MaxReconnectTime is how long to keep trying to re-establish a connection before giving up and closing the
+	// ManagedConnection. Zero retries forever.
+	MaxReconnectTime time.Duration
+
+	// WritePolicy determines how writes are handled while disconnected.
+	WritePolicy WritePolicy
+
+	// MaxBufferedBytes limits the data buffered by WritePolicyBuffer. Defaults to DefaultMaxBufferedBytes.
+	MaxBufferedBytes int
+
+	// OnStateChange, if set, is invoked every time the state of the ManagedConnection changes from the initial
+	// ManagedConnConnecting state. The error is the cause of the transition, if any. It must not block.
+	OnStateChange func(conn *ManagedConnection, state ManagedConnState, err error)
+}
+
+// ManagedConnection is a connection to a service that is transparently re-established when it fails. Reads and writes
+// are performed against the current underlying connection; while reconnecting, reads block and writes are handled
+// according to the configured WritePolicy. Data in flight when a connection fails may be lost, so the service protocol
+// should be tolerant of a stream restarting.
+type ManagedConnection struct {
+	ztx         Context
+	serviceName string
+	options     MaintainOptions
+
+	lock       sync.Mutex
+	cond       *sync.Cond
+	conn       edge.Conn
+	generation uint64
+	state      ManagedConnState
+	closed     bool
+	closeErr   error
+	buffer     bytes.Buffer
+
+	failC  chan error
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeGuard edge.CloseGuard
+}
+
+// Maintain dials the named service using the given Context and keeps the connection alive, redialing with backoff
+// whenever it fails, until the returned ManagedConnection is closed.
+func Maintain(ctx Context, serviceName string, options *MaintainOptions) *ManagedConnection {
+	result := &ManagedConnection{
+		ztx:         ctx,
+		serviceName: serviceName,
+		failC:       make(chan error, 1),
+	}
+
+	if options != nil {
+		result.options = *options
+	}
+	if result.options.InitialReconnectInterval <= 0 {
+		result.options.InitialReconnectInterval = time.Second
+	}
+	if result.options.MaxReconnectInterval <= 0 {
+		result.options.MaxReconnectInterval = 30 * time.Second
+	}
+	if result.options.MaxBufferedBytes <= 0 {
+		result.options.MaxBufferedBytes = DefaultMaxBufferedBytes
+	}
+
+	result.cond = sync.NewCond(&result.lock)
+	result.ctx, result.cancel = context.WithCancel(context.Background())
+
+	go result.supervise()
+
+	return result
+}
+
+// State returns the current state of the ManagedConnection.
+func (self *ManagedConnection) State() ManagedConnState {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.state
+}
+
+func (self *ManagedConnection) supervise() {
+	log := pfxlog.Logger().WithField("service", self.serviceName)
+
+	for {
+		self.setState(ManagedConnConnecting, nil)
+
+		conn, err := self.dial()
+		if err != nil {
+			if self.isClosed() {
+				return
+			}
+			log.WithError(err).Error("managed connection giving up on reconnecting")
+			self.shutdown(err)
+			return
+		}
+
+		if err = self.install(conn); err != nil {
+			if self.isClosed() {
+				return
+			}
+			log.WithError(err).Info("managed connection failed flushing buffered writes")
+			self.setState(ManagedConnDisconnected, err)
+			continue
+		}
+
+		select {
+		case err = <-self.failC:
+			log.WithError(err).Info("managed connection failed, reconnecting")
+			_ = conn.Close()
+			self.setState(ManagedConnDisconnected, err)
+		case <-self.ctx.Done():
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+func (self *ManagedConnection) dial() (edge.Conn, error) {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.InitialInterval = self.options.InitialReconnectInterval
+	expBackoff.MaxInterval = self.options.MaxReconnectInterval
+	expBackoff.MaxElapsedTime = self.options.MaxReconnectTime
+
+	var conn edge.Conn
+	operation := func() error {
+		var err error
+		if self.options.DialOptions != nil {
+			conn, err = self.ztx.DialWithOptions(self.serviceName, self.options.DialOptions)
+		} else {
+			conn, err = self.ztx.Dial(self.serviceName)
+		}
+		return err
+	}
+
+	if err := backoff.Retry(operation, backoff.WithContext(expBackoff, self.ctx)); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// install flushes any buffered writes to the new connection and makes it the current connection.
+func (self *ManagedConnection) install(conn edge.Conn) error {
+	self.lock.Lock()
+
+	if self.closed {
+		self.lock.Unlock()
+		_ = conn.Close()
+		return net.ErrClosed
+	}
+
+	if self.buffer.Len() > 0 {
+		if _, err := self.buffer.WriteTo(conn); err != nil {
+			self.lock.Unlock()
+			_ = conn.Close()
+			return err
+		}
+	}
+
+	self.conn = conn
+	self.generation++
+	self.state = ManagedConnConnected
+	self.cond.Broadcast()
+	self.lock.Unlock()
+
+	self.notify(ManagedConnConnected, nil)
+	return nil
+}
+
+// fail reports that the connection of the given generation failed. Only the first report per generation is acted on.
+func (self *ManagedConnection) fail(generation uint64, err error) {
+	self.lock.Lock()
+	if self.closed || generation != self.generation || self.conn == nil {
+		self.lock.Unlock()
+		return
+	}
+	self.conn = nil
+	self.lock.Unlock()
+
+	select {
+	case self.failC <- err:
+	default:
+	}
+}
+
+func (self *ManagedConnection) setState(state ManagedConnState, err error) {
+	self.lock.Lock()
+	if self.closed || self.state == state {
+		self.lock.Unlock()
+		return
+	}
+	self.state = state
+	self.lock.Unlock()
+
+	self.notify(state, err)
+}
+
+func (self *ManagedConnection) notify(state ManagedConnState, err error) {
+	if self.options.OnStateChange != nil {
+		self.options.OnStateChange(self, state, err)
+	}
+}
+
+func (self *ManagedConnection) isClosed() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.closed
+}
+
+func (self *ManagedConnection) shutdown(err error) {
+	self.lock.Lock()
+	if self.closed {
+		self.lock.Unlock()
+		return
+	}
+	self.closed = true
+	self.closeErr = err
+	self.state = ManagedConnClosed
+	conn := self.conn
+	self.conn = nil
+	self.cond.Broadcast()
+	self.lock.Unlock()
+
+	self.cancel()
+	if conn != nil {
+		_ = conn.Close()
+	}
+
+	self.notify(ManagedConnClosed, err)
+}
+
+func (self *ManagedConnection) closedError() error {
+	if self.closeErr != nil {
+		return self.closeErr
+	}
+	return net.ErrClosed
+}
+
+// Read reads from the current connection, waiting for a connection to be established if necessary.
+func (self *ManagedConnection) Read(p []byte) (int, error) {
+	for {
+		self.lock.Lock()
+		for self.conn == nil && !self.closed {
+			self.cond.Wait()
+		}
+		if self.closed {
+			err := self.closedError()
+			self.lock.Unlock()
+			return 0, err
+		}
+		conn, generation := self.conn, self.generation
+		self.lock.Unlock()
+
+		n, err := conn.Read(p)
+		if err != nil {
+			self.fail(generation, err)
+		}
+		if n > 0 || err == nil {
+			return n, nil
+		}
+	}
+}
+
+// Write writes to the current connection. If no connection is available, the write is handled according to the
+// configured WritePolicy.
+func (self *ManagedConnection) Write(p []byte) (int, error) {
+	written := 0
+
+	self.lock.Lock()
+	for {
+		if self.closed {
+			err := self.closedError()
+			self.lock.Unlock()
+			return written, err
+		}
+
+		if self.conn != nil {
+			conn, generation := self.conn, self.generation
+			self.lock.Unlock()
+
+			n, err := conn.Write(p)
+			written += n
+			if err == nil {
+				return written, nil
+			}
+
+			self.fail(generation, err)
+			p = p[n:]
+			self.lock.Lock()
+			continue
+		}
+
+		switch self.options.WritePolicy {
+		case WritePolicyFail:
+			self.lock.Unlock()
+			return written, ErrNotConnected
+		case WritePolicyDrop:
+			self.lock.Unlock()
+			return written + len(p), nil
+		case WritePolicyBuffer:
+			if self.buffer.Len()+len(p) > self.options.MaxBufferedBytes {
+				self.lock.Unlock()
+				return written, ErrWriteBufferFull
+			}
+			self.buffer.Write(p)
+			self.lock.Unlock()
+			return written + len(p), nil
+		default:
+			self.cond.Wait()
+		}
+	}
+}
+
+// Close closes the current connection and stops reconnecting. Blocked reads and writes return net.ErrClosed. Calling
+// Close again returns an edge.AlreadyClosedError.
+func (self *ManagedConnection) Close() error {
+	if err := self.closeGuard.Close("managed connection"); err != nil {
+		return err
+	}
+	self.shutdown(nil)
+	return nil
+}
@@ -0,0 +1,136 @@
+package ziti
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+type managedPipeConn struct {
+	edge.Conn
+	pipe net.Conn
+}
+
+func (self *managedPipeConn) Read(p []byte) (int, error) {
+	return self.pipe.Read(p)
+}
+
+func (self *managedPipeConn) Write(p []byte) (int, error) {
+	return self.pipe.Write(p)
+}
+
+func (self *managedPipeConn) Close() error {
+	return self.pipe.Close()
+}
+
+// managedTestContext hands out one end of a new net.Pipe per dial and publishes the other end on servers.
+type managedTestContext struct {
+	Context
+	servers chan net.Conn
+	fail    bool
+}
+
+func (self *managedTestContext) Dial(string) (edge.Conn, error) {
+	if self.fail {
+		return nil, errors.New("dial failed")
+	}
+	client, server := net.Pipe()
+	self.servers <- server
+	return &managedPipeConn{pipe: client}, nil
+}
+
+func Test_ManagedConnection_Reconnect(t *testing.T) {
+	req := require.New(t)
+
+	ztx := &managedTestContext{servers: make(chan net.Conn, 2)}
+
+	var lock sync.Mutex
+	var states []ManagedConnState
+	conn := Maintain(ztx, "svc", &MaintainOptions{
+		InitialReconnectInterval: time.Millisecond,
+		WritePolicy:              WritePolicyBuffer,
+		OnStateChange: func(_ *ManagedConnection, state ManagedConnState, _ error) {
+			lock.Lock()
+			defer lock.Unlock()
+			states = append(states, state)
+		},
+	})
+	defer func() { _ = conn.Close() }()
+
+	server := <-ztx.servers
+	go func() {
+		_, _ = conn.Write([]byte("one"))
+	}()
+
+	buf := make([]byte, 3)
+	_, err := io.ReadFull(server, buf)
+	req.NoError(err)
+	req.Equal("one", string(buf))
+
+	// a failed read triggers a reconnect, after which reads continue on the new connection
+	_ = server.Close()
+	readC := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 3)
+		_, _ = io.ReadFull(conn, buf)
+		readC <- string(buf)
+	}()
+
+	server = <-ztx.servers
+	_, err = server.Write([]byte("two"))
+	req.NoError(err)
+	req.Equal("two", <-readC)
+
+	req.NoError(conn.Close())
+	req.Equal(ManagedConnClosed, conn.State())
+
+	_, err = conn.Write([]byte("x"))
+	req.ErrorIs(err, net.ErrClosed)
+
+	lock.Lock()
+	defer lock.Unlock()
+	req.Equal([]ManagedConnState{
+		ManagedConnConnected,
+		ManagedConnDisconnected,
+		ManagedConnConnecting, ManagedConnConnected,
+		ManagedConnClosed,
+	}, states)
+}
+
+func Test_ManagedConnection_WritePolicies(t *testing.T) {
+	req := require.New(t)
+
+	ztx := &managedTestContext{fail: true}
+
+	failing := Maintain(ztx, "svc", &MaintainOptions{WritePolicy: WritePolicyFail})
+	defer func() { _ = failing.Close() }()
+	_, err := failing.Write([]byte("x"))
+	req.ErrorIs(err, ErrNotConnected)
+
+	dropping := Maintain(ztx, "svc", &MaintainOptions{WritePolicy: WritePolicyDrop})
+	defer func() { _ = dropping.Close() }()
+	n, err := dropping.Write([]byte("x"))
+	req.NoError(err)
+	req.Equal(1, n)
+
+	buffering := Maintain(ztx, "svc", &MaintainOptions{WritePolicy: WritePolicyBuffer, MaxBufferedBytes: 4})
+	defer func() { _ = buffering.Close() }()
+	_, err = buffering.Write([]byte("abc"))
+	req.NoError(err)
+	_, err = buffering.Write([]byte("de"))
+	req.ErrorIs(err, ErrWriteBufferFull)
+
+	giveUp := Maintain(ztx, "svc", &MaintainOptions{
+		InitialReconnectInterval: time.Millisecond,
+		MaxReconnectTime:         10 * time.Millisecond,
+	})
+	_, err = giveUp.Read(make([]byte, 1))
+	req.Error(err)
+	req.Equal(ManagedConnClosed, giveUp.State())
+}
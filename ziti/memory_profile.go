@@ -0,0 +1,105 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"time"
+
+	"github.com/openziti/metrics"
+)
+
+// MemoryProfile trades throughput and latency for memory use. See Options.MemoryProfile.
+type MemoryProfile string
+
+const (
+	// MemoryProfileDefault suits hosts where memory is not a concern.
+	MemoryProfileDefault MemoryProfile = ""
+
+	// MemoryProfileLow suits devices with 64-128MB of memory. It shrinks the defaults of the queues buffering data per
+	// connection and router connection, the accept queues of listeners and the recent events ring, and doesn't cache
+	// the terminators of services. Edge routers are only connected to when a dial or bind finds none of its service's
+	// edge routers connected, rather than to every edge router of the service as sessions are created and refreshed.
+	// Options that are set explicitly are still honored.
+	MemoryProfileLow MemoryProfile = "low"
+)
+
+const (
+	lowMemoryCircuitReadQueueSize = 2
+	lowMemoryWriteQueueSize       = 2
+	lowMemoryAcceptQueueSize      = 2
+	lowMemoryRecentEventsSize     = 16
+)
+
+func (self *Options) isLowMemory() bool {
+	return self != nil && self.MemoryProfile == MemoryProfileLow
+}
+
+func (self *Options) getRecentEventsSize() int {
+	if self.RecentEventsSize == 0 && self.isLowMemory() {
+		return lowMemoryRecentEventsSize
+	}
+	return self.RecentEventsSize
+}
+
+func (self *Options) getAcceptQueueSize(options *ListenOptions) int {
+	if options.AcceptQueueSize == 0 && self.isLowMemory() {
+		return lowMemoryAcceptQueueSize
+	}
+	return options.AcceptQueueSize
+}
+
+// newMetricsRegistry returns the metrics registry of the Context, which discards meters, histograms and timers if
+// Options.DisableMetrics is set.
+func (self *Options) newMetricsRegistry(sourceId string, tags map[string]string) metrics.Registry {
+	registry := metrics.NewRegistry(sourceId, tags)
+	if self != nil && self.DisableMetrics {
+		return &disabledMetricsRegistry{Registry: registry}
+	}
+	return registry
+}
+
+// disabledMetricsRegistry discards the meters, histograms and timers it is asked for. These sample and tick in the
+// background, unlike gauges, which are still kept so that values such as router latencies remain available.
+type disabledMetricsRegistry struct {
+	metrics.Registry
+}
+
+func (self *disabledMetricsRegistry) Meter(string) metrics.Meter {
+	return disabledMetric{}
+}
+
+func (self *disabledMetricsRegistry) Histogram(string) metrics.Histogram {
+	return disabledMetric{}
+}
+
+func (self *disabledMetricsRegistry) Timer(string) metrics.Timer {
+	return disabledTimer{}
+}
+
+type disabledMetric struct{}
+
+func (disabledMetric) Dispose()     {}
+func (disabledMetric) Mark(int64)   {}
+func (disabledMetric) Clear()       {}
+func (disabledMetric) Update(int64) {}
+
+type disabledTimer struct{}
+
+func (disabledTimer) Dispose()              {}
+func (disabledTimer) Time(f func())         { f() }
+func (disabledTimer) Update(time.Duration)  {}
+func (disabledTimer) UpdateSince(time.Time) {}
@@ -0,0 +1,57 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemoryProfileLow_defaults(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{options: &Options{MemoryProfile: MemoryProfileLow}}
+	req.Equal(lowMemoryCircuitReadQueueSize, ctx.GetMultiplexConfig().ReadQueueSize)
+	req.Equal(lowMemoryWriteQueueSize, ctx.getChannelOptions().OutQueueSize)
+	req.Equal(lowMemoryRecentEventsSize, ctx.options.getRecentEventsSize())
+	req.Equal(lowMemoryAcceptQueueSize, ctx.options.getAcceptQueueSize(&ListenOptions{}))
+
+	ctx.options.RouterConnection = &RouterConnectionOptions{MaxCircuits: 10, CircuitReadQueueSize: 8, WriteQueueSize: 16}
+	ctx.options.RecentEventsSize = 32
+	req.Equal(&network.MultiplexConfig{MaxCircuits: 10, ReadQueueSize: 8}, ctx.GetMultiplexConfig())
+	req.Equal(16, ctx.getChannelOptions().OutQueueSize)
+	req.Equal(32, ctx.options.getRecentEventsSize())
+	req.Equal(4, ctx.options.getAcceptQueueSize(&ListenOptions{AcceptQueueSize: 4}))
+
+	ctx.options = &Options{}
+	req.Nil(ctx.GetMultiplexConfig())
+	req.Equal(channel.DefaultOutQueueSize, ctx.getChannelOptions().OutQueueSize)
+	req.Equal(0, ctx.options.getRecentEventsSize())
+	req.Equal(0, ctx.options.getAcceptQueueSize(&ListenOptions{}))
+}
+
+func Test_Options_newMetricsRegistry(t *testing.T) {
+	req := require.New(t)
+
+	registry := (&Options{DisableMetrics: true}).newMetricsRegistry("test", nil)
+	registry.Meter("meter").Mark(1)
+	registry.Histogram("histogram").Update(1)
+	registry.Timer("timer").Update(time.Second)
+	registry.Gauge("gauge").Update(5)
+
+	req.Nil(registry.GetMeter("meter"))
+	req.Nil(registry.GetHistogram("histogram"))
+	req.Nil(registry.GetTimer("timer"))
+	req.Equal(int64(5), registry.GetGauge("gauge").Value())
+
+	ran := false
+	registry.Timer("timer").Time(func() { ran = true })
+	req.True(ran)
+
+	registry = (&Options{}).newMetricsRegistry("test", nil)
+	registry.Meter("meter").Mark(1)
+	req.NotNil(registry.GetMeter("meter"))
+	registry.DisposeAll()
+}
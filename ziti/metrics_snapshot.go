@@ -0,0 +1,262 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// MetricsSnapshot is a point in time copy of the counters a Context keeps since it was created, as returned by
+// Context.MetricsSnapshot. It can be written in the Prometheus text exposition format with WritePrometheus, or served
+// with PrometheusHandler.
+type MetricsSnapshot struct {
+	// Services holds the counters of each service that was dialed or hosted, by service name.
+	Services map[string]*ServiceMetrics
+
+	// OpenCircuits is the number of dialed, accepted and hosting connections currently open.
+	OpenCircuits int
+
+	// AuthFailures is the number of failed authentication attempts.
+	AuthFailures uint64
+
+	// RouterReconnects is the number of connections established to edge routers that had been connected to before.
+	RouterReconnects uint64
+}
+
+// ServiceMetrics are the counters of a single service.
+type ServiceMetrics struct {
+	Dials        uint64
+	DialFailures uint64
+
+	// DialLatency counts the successful dials by latency, in the buckets used by DialLatencies.
+	DialLatency []LatencyBucket
+
+	// DialLatencySum is the total latency of the successful dials.
+	DialLatencySum time.Duration
+
+	// BytesIn and BytesOut are the payload bytes read from and written to the connections of the service.
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+type serviceCounters struct {
+	dials          atomic.Uint64
+	dialFailures   atomic.Uint64
+	dialLatency    [latencyBucketCount]atomic.Uint64
+	dialLatencySum atomic.Int64
+	traffic        *edge.TrafficCounter
+}
+
+// sdkCounters are the counters behind MetricsSnapshot. The zero value is empty and ready to use.
+type sdkCounters struct {
+	lock             sync.Mutex
+	services         map[string]*serviceCounters
+	connectedRouters map[string]struct{}
+
+	authFailures     atomic.Uint64
+	routerReconnects atomic.Uint64
+}
+
+func (self *sdkCounters) service(serviceName string, total *edge.TrafficCounter) *serviceCounters {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.services == nil {
+		self.services = map[string]*serviceCounters{}
+	}
+
+	counters, found := self.services[serviceName]
+	if !found {
+		counters = &serviceCounters{traffic: edge.NewTrafficCounter(total)}
+		self.services[serviceName] = counters
+	}
+	return counters
+}
+
+func (self *sdkCounters) routerConnected(routerName string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.connectedRouters == nil {
+		self.connectedRouters = map[string]struct{}{}
+	}
+
+	if _, found := self.connectedRouters[routerName]; found {
+		self.routerReconnects.Add(1)
+	} else {
+		self.connectedRouters[routerName] = struct{}{}
+	}
+}
+
+func (context *ContextImpl) recordDial(serviceName string, latency time.Duration, err error) {
+	counters := context.counters.service(serviceName, &context.traffic)
+	counters.dials.Add(1)
+	if err != nil {
+		counters.dialFailures.Add(1)
+		return
+	}
+	counters.dialLatency[latencyBucketIndex(latency)].Add(1)
+	counters.dialLatencySum.Add(int64(latency))
+}
+
+// GetServiceTrafficCounter implements network.ServiceTrafficCountingOwner, so that the traffic of each service is
+// counted for MetricsSnapshot, as well as towards the totals in Stats.
+func (context *ContextImpl) GetServiceTrafficCounter(serviceName string) *edge.TrafficCounter {
+	return context.counters.service(serviceName, &context.traffic).traffic
+}
+
+func (context *ContextImpl) MetricsSnapshot() *MetricsSnapshot {
+	result := &MetricsSnapshot{
+		Services:         map[string]*ServiceMetrics{},
+		AuthFailures:     context.counters.authFailures.Load(),
+		RouterReconnects: context.counters.routerReconnects.Load(),
+	}
+
+	context.counters.lock.Lock()
+	for name, counters := range context.counters.services {
+		service := &ServiceMetrics{
+			Dials:          counters.dials.Load(),
+			DialFailures:   counters.dialFailures.Load(),
+			DialLatency:    make([]LatencyBucket, latencyBucketCount),
+			DialLatencySum: time.Duration(counters.dialLatencySum.Load()),
+			BytesIn:        counters.traffic.RxBytes(),
+			BytesOut:       counters.traffic.TxBytes(),
+		}
+		for idx := range service.DialLatency {
+			service.DialLatency[idx] = LatencyBucket{
+				UpperBound: latencyBucketBound(idx),
+				Count:      int64(counters.dialLatency[idx].Load()),
+			}
+		}
+		result.Services[name] = service
+	}
+	context.counters.lock.Unlock()
+
+	for entry := range context.routerConnections.IterBuffered() {
+		if !entry.Val.IsClosed() {
+			result.OpenCircuits += entry.Val.GetActiveConnCount()
+		}
+	}
+
+	return result
+}
+
+const prometheusContextMetricPrefix = "ziti_"
+
+// WritePrometheus writes the snapshot in the Prometheus text exposition format. Service counters are labeled with
+// the service name, and dial latencies are written as a histogram in seconds.
+func (s *MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	pw := &prometheusWriter{w: w}
+
+	names := make([]string, 0, len(s.Services))
+	for name := range s.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	serviceCounter := func(name, help string, value func(*ServiceMetrics) uint64) {
+		pw.header(name, "counter", help)
+		for _, service := range names {
+			pw.sample(name, `service="`+escapePrometheusLabel(service)+`"`, fmt.Sprint(value(s.Services[service])))
+		}
+	}
+
+	serviceCounter("dials_total", "Dials of the service, including failed ones.", func(m *ServiceMetrics) uint64 { return m.Dials })
+	serviceCounter("dial_failures_total", "Failed dials of the service.", func(m *ServiceMetrics) uint64 { return m.DialFailures })
+
+	pw.header("dial_duration_seconds", "histogram", "Latency of successful dials of the service.")
+	for _, service := range names {
+		m := s.Services[service]
+		label := `service="` + escapePrometheusLabel(service) + `"`
+
+		var cumulative uint64
+		for _, bucket := range m.DialLatency {
+			cumulative += uint64(bucket.Count)
+			le := "+Inf"
+			if bucket.UpperBound > 0 {
+				le = fmt.Sprint(bucket.UpperBound.Seconds())
+			}
+			pw.sample("dial_duration_seconds_bucket", label+`,le="`+le+`"`, fmt.Sprint(cumulative))
+		}
+		pw.sample("dial_duration_seconds_sum", label, fmt.Sprint(m.DialLatencySum.Seconds()))
+		pw.sample("dial_duration_seconds_count", label, fmt.Sprint(cumulative))
+	}
+
+	serviceCounter("service_rx_bytes_total", "Payload bytes read from connections of the service.", func(m *ServiceMetrics) uint64 { return m.BytesIn })
+	serviceCounter("service_tx_bytes_total", "Payload bytes written to connections of the service.", func(m *ServiceMetrics) uint64 { return m.BytesOut })
+
+	pw.header("open_circuits", "gauge", "Connections currently multiplexed over edge router connections.")
+	pw.sample("open_circuits", "", fmt.Sprint(s.OpenCircuits))
+
+	pw.header("auth_failures_total", "counter", "Failed authentication attempts.")
+	pw.sample("auth_failures_total", "", fmt.Sprint(s.AuthFailures))
+
+	pw.header("router_reconnects_total", "counter", "Connections re-established to previously connected edge routers.")
+	pw.sample("router_reconnects_total", "", fmt.Sprint(s.RouterReconnects))
+
+	return pw.err
+}
+
+// PrometheusHandler returns an http.Handler that serves the MetricsSnapshot of ztx in the Prometheus text exposition
+// format, so it can be scraped without the SDK depending on the Prometheus client library.
+func PrometheusHandler(ztx Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = ztx.MetricsSnapshot().WritePrometheus(w)
+	})
+}
+
+// prometheusWriter writes metrics in the text exposition format, keeping the first error.
+type prometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (self *prometheusWriter) header(name, metricType, help string) {
+	name = prometheusContextMetricPrefix + name
+	self.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (self *prometheusWriter) sample(name, labels, value string) {
+	name = prometheusContextMetricPrefix + name
+	if labels != "" {
+		self.printf("%s{%s} %s\n", name, labels, value)
+	} else {
+		self.printf("%s %s\n", name, value)
+	}
+}
+
+func (self *prometheusWriter) printf(format string, args ...interface{}) {
+	if self.err == nil {
+		_, self.err = fmt.Fprintf(self.w, format, args...)
+	}
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapePrometheusLabel(value string) string {
+	return prometheusLabelEscaper.Replace(value)
+}
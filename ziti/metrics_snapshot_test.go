@@ -0,0 +1,90 @@
+package ziti
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type activeTestRouterConn struct {
+	testRouterConn
+	active int
+}
+
+func (self *activeTestRouterConn) GetActiveConnCount() int {
+	return self.active
+}
+
+func Test_contextImpl_MetricsSnapshot(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+	}
+	ctx.routerConnections.Set("tls:er1:3022", &activeTestRouterConn{testRouterConn: testRouterConn{name: "er1", key: "tls:er1:3022"}, active: 3})
+
+	ctx.recordDial("svc", 3*time.Millisecond, nil)
+	ctx.recordDial("svc", 500*time.Microsecond, nil)
+	ctx.recordDial("svc", 0, errors.New("dial failed"))
+	ctx.recordDial(`we"ird`, time.Minute*5, nil)
+
+	traffic := ctx.GetServiceTrafficCounter("svc")
+	req.Same(traffic, ctx.GetServiceTrafficCounter("svc"))
+	traffic.AddRx(100)
+	traffic.AddTx(40)
+	ctx.GetServiceTrafficCounter("other").AddRx(1)
+
+	ctx.counters.routerConnected("er1")
+	ctx.counters.routerConnected("er2")
+	ctx.counters.routerConnected("er1")
+	ctx.counters.authFailures.Add(2)
+
+	snapshot := ctx.MetricsSnapshot()
+	req.Equal(3, snapshot.OpenCircuits)
+	req.Equal(uint64(2), snapshot.AuthFailures)
+	req.Equal(uint64(1), snapshot.RouterReconnects)
+
+	svc := snapshot.Services["svc"]
+	req.Equal(uint64(3), svc.Dials)
+	req.Equal(uint64(1), svc.DialFailures)
+	req.Equal(3500*time.Microsecond, svc.DialLatencySum)
+	req.Equal(int64(1), svc.DialLatency[0].Count)
+	req.Equal(int64(1), svc.DialLatency[2].Count)
+	req.Equal(uint64(100), svc.BytesIn)
+	req.Equal(uint64(40), svc.BytesOut)
+
+	// service traffic counts towards the Context's totals
+	req.Equal(uint64(101), ctx.traffic.RxBytes())
+	req.Equal(uint64(40), ctx.traffic.TxBytes())
+
+	buf := &bytes.Buffer{}
+	req.NoError(snapshot.WritePrometheus(buf))
+	out := buf.String()
+
+	req.Contains(out, "# TYPE ziti_dials_total counter\n")
+	req.Contains(out, `ziti_dials_total{service="svc"} 3`+"\n")
+	req.Contains(out, `ziti_dial_failures_total{service="svc"} 1`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_bucket{service="svc",le="0.001"} 1`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_bucket{service="svc",le="0.002"} 1`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_bucket{service="svc",le="0.004"} 2`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_bucket{service="svc",le="+Inf"} 2`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_sum{service="svc"} 0.0035`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_count{service="svc"} 2`+"\n")
+	req.Contains(out, `ziti_dial_duration_seconds_bucket{service="we\"ird",le="+Inf"} 1`+"\n")
+	req.Contains(out, `ziti_service_rx_bytes_total{service="svc"} 100`+"\n")
+	req.Contains(out, `ziti_service_tx_bytes_total{service="other"} 0`+"\n")
+	req.Contains(out, "ziti_open_circuits 3\n")
+	req.Contains(out, "ziti_auth_failures_total 2\n")
+	req.Contains(out, "ziti_router_reconnects_total 1\n")
+
+	recorder := httptest.NewRecorder()
+	PrometheusHandler(ctx).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	req.Equal("text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	req.Equal(out, recorder.Body.String())
+}
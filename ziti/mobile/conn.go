@@ -0,0 +1,136 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package mobile
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// DefaultReadSize is the largest number of bytes Conn.Read returns when called with a size of zero or less.
+const DefaultReadSize = 32 * 1024
+
+// Conn is a connection to a service, dialed with Context.Dial or accepted from a Listener.
+type Conn struct {
+	conn edge.Conn
+}
+
+// Read waits for data and returns up to size bytes of it. At the end of the stream it returns an error for which
+// IsEOF is true.
+func (self *Conn) Read(size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultReadSize
+	}
+	buf := make([]byte, size)
+	n, err := self.conn.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	return nil, err
+}
+
+// Write sends data, returning the number of bytes written.
+func (self *Conn) Write(data []byte) (int, error) {
+	return self.conn.Write(data)
+}
+
+// CloseWrite signals the end of the stream to the other side, while still allowing data to be read.
+func (self *Conn) CloseWrite() error {
+	return self.conn.CloseWrite()
+}
+
+// Close closes the connection.
+func (self *Conn) Close() error {
+	return self.conn.Close()
+}
+
+// SetReadTimeout fails reads that don't receive data within timeoutMillis with an error for which IsTimeout is true.
+// Zero disables the timeout.
+func (self *Conn) SetReadTimeout(timeoutMillis int64) error {
+	return self.conn.SetReadDeadline(deadline(timeoutMillis))
+}
+
+// SetWriteTimeout fails writes that don't complete within timeoutMillis with an error for which IsTimeout is true.
+// Zero disables the timeout.
+func (self *Conn) SetWriteTimeout(timeoutMillis int64) error {
+	return self.conn.SetWriteDeadline(deadline(timeoutMillis))
+}
+
+// ServiceName returns the name of the service the connection is to.
+func (self *Conn) ServiceName() string {
+	return self.conn.GetServiceName()
+}
+
+// SourceIdentifier returns the identity of the dialing side of an accepted connection.
+func (self *Conn) SourceIdentifier() string {
+	return self.conn.SourceIdentifier()
+}
+
+// AppData returns the application data the dialing side of an accepted connection sent with its dial, if any.
+func (self *Conn) AppData() []byte {
+	return self.conn.GetAppData()
+}
+
+func deadline(timeoutMillis int64) time.Time {
+	if timeout := millis(timeoutMillis); timeout > 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
+// ConnHandler handles the connections accepted by Listener.Serve.
+type ConnHandler interface {
+	OnConnection(conn *Conn)
+}
+
+// Listener accepts the connections of clients dialing a service hosted with Context.Listen.
+type Listener struct {
+	listener edge.Listener
+	closed   atomic.Bool
+}
+
+// Accept waits for and returns the next connection to the service.
+func (self *Listener) Accept() (*Conn, error) {
+	conn, err := self.listener.AcceptEdge()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn}, nil
+}
+
+// Serve accepts connections until the listener is closed, passing each to handler on its own goroutine. It returns
+// nil once Close is called, or the error that stopped accepting otherwise.
+func (self *Listener) Serve(handler ConnHandler) error {
+	for {
+		conn, err := self.Accept()
+		if err != nil {
+			if self.closed.Load() {
+				return nil
+			}
+			return err
+		}
+		go handler.OnConnection(conn)
+	}
+}
+
+// Close stops hosting the service.
+func (self *Listener) Close() error {
+	self.closed.Store(true)
+	return self.listener.Close()
+}
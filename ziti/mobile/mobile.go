@@ -0,0 +1,241 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package mobile is a facade over the SDK for embedding it in iOS and Android applications with gomobile bind. It
+// only uses the types gobind can translate: functions take and return strings, integers, booleans, byte slices,
+// errors and pointers to the structs in this package, streams are read and written with plain calls instead of
+// channels, and events are delivered to callback interfaces implemented by the application.
+//
+//	gomobile bind -target android github.com/openziti/sdk-golang/ziti/mobile
+//
+// Timeouts are given in milliseconds, with zero meaning no timeout or the SDK default.
+package mobile
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// StringList is a list of strings, as gobind can't translate string slices.
+type StringList struct {
+	values []string
+}
+
+// Len returns the number of strings in the list.
+func (self *StringList) Len() int {
+	return len(self.values)
+}
+
+// Get returns the string at index i, or an empty string if i is out of range.
+func (self *StringList) Get(i int) string {
+	if i < 0 || i >= len(self.values) {
+		return ""
+	}
+	return self.values[i]
+}
+
+// EventHandler receives the events of a Context. Callbacks are made on SDK goroutines and must not block.
+type EventHandler interface {
+	OnServiceAdded(serviceName string)
+	OnServiceChanged(serviceName string)
+	OnServiceRemoved(serviceName string)
+	OnRouterConnected(routerName string, addr string)
+	OnRouterDisconnected(routerName string, addr string)
+
+	// OnConnectionStateChanged is called with the names of the ziti.ConnectionState the Context moved from and to,
+	// e.g. "authenticating" and "ready".
+	OnConnectionStateChanged(from string, to string)
+
+	OnAuthenticationFailed(message string)
+}
+
+// MfaHandler answers the MFA TOTP prompts of the controller, returning the code entered by the user.
+type MfaHandler interface {
+	OnMfaCodeRequested() string
+}
+
+// Context is an identity's connection to a Ziti network.
+type Context struct {
+	ztx             ziti.Context
+	removeListeners []func()
+	removeMfa       func()
+}
+
+// NewContextFromJson creates a Context from the contents of an identity config file, such as one created by
+// enrollment. Certificates and keys must be given inline, with the "pem:" prefix.
+func NewContextFromJson(config string) (*Context, error) {
+	cfg, err := ziti.NewConfigFromBytes([]byte(config))
+	if err != nil {
+		return nil, err
+	}
+	return newContext(cfg)
+}
+
+// NewContextFromFile creates a Context from the identity config file at path.
+func NewContextFromFile(path string) (*Context, error) {
+	cfg, err := ziti.NewConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newContext(cfg)
+}
+
+func newContext(cfg *ziti.Config) (*Context, error) {
+	ztx, err := ziti.NewContext(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Context{ztx: ztx}, nil
+}
+
+// Authenticate authenticates with the controller. Authentication otherwise happens on the first operation needing it.
+func (self *Context) Authenticate() error {
+	return self.ztx.Authenticate()
+}
+
+// SetEventHandler delivers the events of the Context to handler, replacing the handler set before. A nil handler
+// stops delivering events.
+func (self *Context) SetEventHandler(handler EventHandler) {
+	for _, remove := range self.removeListeners {
+		remove()
+	}
+	self.removeListeners = nil
+
+	if handler == nil {
+		return
+	}
+
+	serviceName := func(service *rest_model.ServiceDetail) string {
+		return stringz.OrEmpty(service.Name)
+	}
+
+	self.removeListeners = []func(){
+		self.ztx.Events().AddServiceAddedListener(func(_ ziti.Context, service *rest_model.ServiceDetail) {
+			handler.OnServiceAdded(serviceName(service))
+		}),
+		self.ztx.Events().AddServiceChangedListener(func(_ ziti.Context, service *rest_model.ServiceDetail) {
+			handler.OnServiceChanged(serviceName(service))
+		}),
+		self.ztx.Events().AddServiceRemovedListener(func(_ ziti.Context, service *rest_model.ServiceDetail) {
+			handler.OnServiceRemoved(serviceName(service))
+		}),
+		self.ztx.Events().AddRouterConnectedListener(func(_ ziti.Context, name string, addr string) {
+			handler.OnRouterConnected(name, addr)
+		}),
+		self.ztx.Events().AddRouterDisconnectedListener(func(_ ziti.Context, name string, addr string) {
+			handler.OnRouterDisconnected(name, addr)
+		}),
+		self.ztx.Events().AddConnectionStateListener(func(_ ziti.Context, from ziti.ConnectionState, to ziti.ConnectionState) {
+			handler.OnConnectionStateChanged(string(from), string(to))
+		}),
+		self.ztx.Events().AddAuthenticationFailedListener(func(_ ziti.Context, err error) {
+			handler.OnAuthenticationFailed(err.Error())
+		}),
+	}
+}
+
+// SetMfaHandler answers MFA TOTP prompts with the codes returned by handler, replacing the handler set before. A nil
+// handler leaves the prompts unanswered.
+func (self *Context) SetMfaHandler(handler MfaHandler) {
+	if self.removeMfa != nil {
+		self.removeMfa()
+		self.removeMfa = nil
+	}
+
+	if handler == nil {
+		return
+	}
+
+	self.removeMfa = self.ztx.Events().AddMfaTotpCodeListener(func(_ ziti.Context, _ *rest_model.AuthQueryDetail, response ziti.MfaCodeResponse) {
+		go func() {
+			_ = response(handler.OnMfaCodeRequested())
+		}()
+	})
+}
+
+// ConnectionState returns the name of the current ziti.ConnectionState of the Context, e.g. "ready".
+func (self *Context) ConnectionState() string {
+	return string(self.ztx.ConnectionState())
+}
+
+// ServiceNames returns the names of the services the identity may dial or bind, sorted by name.
+func (self *Context) ServiceNames() (*StringList, error) {
+	services, err := self.ztx.GetServices()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &StringList{}
+	for _, service := range services {
+		result.values = append(result.values, stringz.OrEmpty(service.Name))
+	}
+	sort.Strings(result.values)
+	return result, nil
+}
+
+// Dial connects to the named service, waiting up to timeoutMillis for the connection to be established, or the SDK
+// default if zero.
+func (self *Context) Dial(serviceName string, timeoutMillis int64) (*Conn, error) {
+	options := ziti.NewDialOptions()
+	if timeout := millis(timeoutMillis); timeout > 0 {
+		options.ConnectTimeout = timeout
+	}
+	conn, err := self.ztx.DialWithOptions(serviceName, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn}, nil
+}
+
+// Listen hosts the named service.
+func (self *Context) Listen(serviceName string) (*Listener, error) {
+	listener, err := self.ztx.Listen(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{listener: listener}, nil
+}
+
+// Close closes the Context, along with its connections and listeners.
+func (self *Context) Close() {
+	self.SetEventHandler(nil)
+	self.SetMfaHandler(nil)
+	self.ztx.Close()
+}
+
+// IsEOF returns true if err reports that the other side closed the connection.
+func IsEOF(err error) bool {
+	return errors.Is(err, io.EOF)
+}
+
+// IsTimeout returns true if err reports that a read or write timeout expired.
+func IsTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func millis(v int64) time.Duration {
+	if v <= 0 {
+		return 0
+	}
+	return time.Duration(v) * time.Millisecond
+}
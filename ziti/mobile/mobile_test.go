@@ -0,0 +1,134 @@
+package mobile
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+type pipeConn struct {
+	edge.Conn
+	pipe net.Conn
+}
+
+func (self *pipeConn) Read(p []byte) (int, error) {
+	return self.pipe.Read(p)
+}
+
+func (self *pipeConn) Write(p []byte) (int, error) {
+	return self.pipe.Write(p)
+}
+
+func (self *pipeConn) Close() error {
+	return self.pipe.Close()
+}
+
+func (self *pipeConn) SetReadDeadline(t time.Time) error {
+	return self.pipe.SetReadDeadline(t)
+}
+
+type testListener struct {
+	edge.Listener
+	conns chan edge.Conn
+}
+
+func (self *testListener) AcceptEdge() (edge.Conn, error) {
+	conn, ok := <-self.conns
+	if !ok {
+		return nil, errors.New("listener is closed")
+	}
+	return conn, nil
+}
+
+func (self *testListener) Close() error {
+	close(self.conns)
+	return nil
+}
+
+func Test_Conn_Read(t *testing.T) {
+	req := require.New(t)
+
+	local, remote := net.Pipe()
+	conn := &Conn{conn: &pipeConn{pipe: local}}
+
+	go func() {
+		_, _ = remote.Write([]byte("hello"))
+		_ = remote.Close()
+	}()
+
+	data, err := conn.Read(3)
+	req.NoError(err)
+	req.Equal("hel", string(data))
+
+	data, err = conn.Read(0)
+	req.NoError(err)
+	req.Equal("lo", string(data))
+
+	_, err = conn.Read(0)
+	req.True(IsEOF(err))
+	req.True(errors.Is(err, io.EOF))
+}
+
+func Test_Conn_SetReadTimeout(t *testing.T) {
+	req := require.New(t)
+
+	local, _ := net.Pipe()
+	conn := &Conn{conn: &pipeConn{pipe: local}}
+
+	req.NoError(conn.SetReadTimeout(10))
+	_, err := conn.Read(0)
+	req.True(IsTimeout(err))
+}
+
+type connHandlerFunc func(conn *Conn)
+
+func (f connHandlerFunc) OnConnection(conn *Conn) {
+	f(conn)
+}
+
+func Test_Listener_Serve(t *testing.T) {
+	req := require.New(t)
+
+	listener := &Listener{listener: &testListener{conns: make(chan edge.Conn, 1)}}
+	accepted := make(chan *Conn, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- listener.Serve(connHandlerFunc(func(conn *Conn) {
+			accepted <- conn
+		}))
+	}()
+
+	local, _ := net.Pipe()
+	listener.listener.(*testListener).conns <- &pipeConn{pipe: local}
+
+	select {
+	case conn := <-accepted:
+		req.NotNil(conn)
+	case <-time.After(time.Second):
+		req.Fail("connection not handled")
+	}
+
+	req.NoError(listener.Close())
+	select {
+	case err := <-done:
+		req.NoError(err, "closing the listener stops Serve without error")
+	case <-time.After(time.Second):
+		req.Fail("Serve did not return")
+	}
+}
+
+func Test_StringList(t *testing.T) {
+	req := require.New(t)
+
+	list := &StringList{values: []string{"a", "b"}}
+	req.Equal(2, list.Len())
+	req.Equal("b", list.Get(1))
+	req.Equal("", list.Get(2))
+	req.Equal("", list.Get(-1))
+}
@@ -2,6 +2,10 @@ package ziti
 
 import (
 	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/posture"
+	"log/slog"
+	"net/http"
 	"time"
 )
 
@@ -29,6 +33,19 @@ type Options struct {
 	// May not be less than 1 second
 	SessionRefreshInterval time.Duration
 
+	// ApiSessionRefreshFraction is the fraction of the remaining API Session lifetime after which it is refreshed in
+	// the background. Values outside (0, 1) use DefaultApiSessionRefreshFraction.
+	ApiSessionRefreshFraction float64
+
+	// ApiSessionRefreshJitter is the largest fraction of the remaining API Session lifetime by which each refresh is
+	// moved earlier at random, so that contexts authenticated together don't refresh together. If zero,
+	// DefaultApiSessionRefreshJitter is used. Negative values disable jitter.
+	ApiSessionRefreshJitter float64
+
+	// WarmRefreshInterval is how often the sessions of services passed to Context.WarmServices are refreshed. If
+	// zero, DefaultWarmRefreshInterval is used. May not be less than 1 second
+	WarmRefreshInterval time.Duration
+
 	// Deprecated: OnContextReady is a callback that is invoked after the first successful authentication request. It
 	// does not delineate between fully and partially authenticated API Sessions. Use context.AddListener() with the events
 	// EventAuthenticationStateFull, EventAuthenticationStatePartial, EventAuthenticationStateUnAuthenticated instead.
@@ -38,9 +55,103 @@ type Options struct {
 	// Use `zitiContext.AddListener(<eventName>, handler)` where `eventName` may be EventServiceAdded, EventServiceChanged, EventServiceRemoved.
 	OnServiceUpdate     serviceCB
 	EdgeRouterUrlFilter func(string) bool
+
+	// RecentEventsSize is the number of events retained for Context.RecentEvents. If zero, DefaultRecentEventsSize is
+	// used. If negative, no events are retained.
+	RecentEventsSize int
+
+	// APIClientCustomizer, if set, is invoked with the HTTP client and transport used for all Edge Client API requests
+	// when the Context is created, before any request is made. Both may be modified in place, e.g. to add proxy or TLS
+	// settings to the transport, or to replace client.Transport with a middleware http.RoundTripper that adds headers
+	// or signs requests before delegating to transport. The client itself must not be replaced.
+	APIClientCustomizer func(client *http.Client, transport *http.Transport)
+
+	// Keepalive, if set, enables keepalives on edge router connections, so that NAT mappings and firewall state
+	// between the client and an edge router do not expire while no connections are active. See KeepaliveOptions.
+	Keepalive *KeepaliveOptions
+
+	// IdleTimeout, if set, closes connections dialed or accepted by the Context once no data has been sent or received
+	// on them for this long, emitting EventConnectionIdle. Deadlines set on a connection are independent of it.
+	IdleTimeout time.Duration
+
+	// RestrictedEgress confines all connectivity to port 443, for networks that block other outbound ports. Controller
+	// URLs must be HTTPS on port 443 and only TLS and WSS edge router listeners on port 443 are used, in addition to
+	// EdgeRouterUrlFilter. Contexts, dials and listeners fail with an EgressCapabilityError, listing the advertised
+	// addresses, if the required listeners aren't available. See RestrictedEgressOptions.
+	RestrictedEgress bool
+
+	// EdgeRouterTransport selects the edge router listeners that data-plane connections are made to. By default, TLS
+	// is preferred and WSS is fallen back to when a router's TLS listener can't be reached, such as behind firewalls
+	// that only pass HTTPS. Set EdgeRouterTransportTls or EdgeRouterTransportWss to only use one or the other. When
+	// built for js/wasm, where browsers can't open raw TLS connections, only WSS listeners are used unless set.
+	EdgeRouterTransport EdgeRouterTransport
+
+	// ControllerTLS, if set, tunes the TLS connections to controllers. Session resumption is enabled regardless,
+	// unless disabled with TLSOptions.SessionCacheSize.
+	ControllerTLS *TLSOptions
+
+	// EdgeRouterTLS, if set, tunes the TLS connections to edge routers, including those tunneled over WSS. Session
+	// resumption is enabled regardless, unless disabled with TLSOptions.SessionCacheSize.
+	EdgeRouterTLS *TLSOptions
+
+	// RouterConnection, if set, tunes how connections are multiplexed over edge router connections, e.g. for bulk
+	// transfers. See RouterConnectionOptions.
+	RouterConnection *RouterConnectionOptions
+
+	// RouterLatencyHysteresis is the fraction by which a connected edge router's latency must be lower than that of the
+	// router a service is being dialed through before new dials of the service switch to it. Defaults to
+	// DefaultRouterLatencyHysteresis. Set to a negative value to always dial through the lowest latency router.
+	RouterLatencyHysteresis float64
+
+	// CacheControllerResponses caches the controller's GET responses for DefaultControllerCachePaths and revalidates
+	// them with If-None-Match, so that unchanged services, identity and config types aren't transferred again on every
+	// refresh. Controllers that don't send ETags are unaffected. Revalidated and transferred responses are counted by
+	// the MetricControllerCacheHits and MetricControllerCacheMisses meters.
+	CacheControllerResponses bool
+
+	// ControllerBreaker, if set, wraps the requests to each controller in a circuit breaker, so that a failing
+	// controller isn't retried in a tight loop. Breaker state changes emit EventControllerBreakerStateChanged. See
+	// ControllerBreakerOptions.
+	ControllerBreaker *ControllerBreakerOptions
+
+	// RateLimits, if set, limits the rate of API Session refreshes, service refreshes and session creation, and
+	// jitters the start of periodic refreshes. See DefaultRateLimitOptions.
+	RateLimits *RateLimitOptions
+
+	// LogHandler, if set, receives the logs of the Context instead of the process wide pfxlog logger, so that they can
+	// be routed to log/slog, or to zap and other libraries via their slog.Handler adapters. Entries are filtered by the
+	// handler's Enabled method, so levels may be changed at runtime. This includes the logs of edge router connections
+	// and of the connections and listeners established over them. Logs of the underlying channel and transport
+	// libraries still go to pfxlog.
+	LogHandler slog.Handler
+
+	// ReconnectPolicy controls how the Context authenticates again in the background after it lost its API Session
+	// and failed to authenticate. If nil, DefaultReconnectPolicy is used.
+	ReconnectPolicy *ReconnectPolicy
+
+	// AuthHandler, if set, supplies credentials when the Context has to authenticate again and answers authentication
+	// queries such as MFA TOTP codes. See NonInteractiveAuthHandler and InteractiveAuthHandler.
+	AuthHandler AuthHandler
+
+	// PostureProvider, if set, supplies the posture data submitted for the posture checks of the services in use,
+	// instead of gathering it from the host. See posture.OverrideProvider for overriding some of the values.
+	PostureProvider posture.Provider
+
+	// MemoryProfile selects defaults suited to the memory available, such as MemoryProfileLow for embedded devices.
+	MemoryProfile MemoryProfile
+
+	// DisableMetrics stops the Context from recording the meters, histograms and timers of its Metrics registry,
+	// saving the memory and background work of their samples. Gauges are still recorded.
+	DisableMetrics bool
+
+	// ConnHooks, if set, are called when dialed and accepted connections open and close, for audit logging.
+	ConnHooks *ConnHooks
 }
 
 func (self *Options) isEdgeRouterUrlAccepted(url string) bool {
+	if self.RestrictedEgress && !isRestrictedEgressRouterUrl(url) {
+		return false
+	}
 	return self.EdgeRouterUrlFilter == nil || self.EdgeRouterUrlFilter(url)
 }
 
@@ -50,17 +161,133 @@ var DefaultOptions = &Options{
 	OnServiceUpdate:        nil,
 }
 
+// DialRetryPolicy controls whether and how a failed dial is attempted again. See DefaultDialRetryPolicy.
+type DialRetryPolicy struct {
+	// MaxAttempts is the total number of dial attempts, including the first. Values less than 2 disable retries.
+	MaxAttempts int
+
+	// Interval is the delay before the first retry.
+	Interval time.Duration
+
+	// Multiplier grows the delay after each retry. Values of 1 or less keep the delay at Interval.
+	Multiplier float64
+
+	// MaxInterval caps the delay between attempts, if set.
+	MaxInterval time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it in either direction, e.g. 0.2 for +/-20%, so that
+	// clients failing together don't retry together.
+	Jitter float64
+
+	// Retryable decides whether a failed dial is attempted again. Defaults to IsRetryableDialError.
+	Retryable func(err error) bool
+}
+
+// DefaultDialRetryPolicy returns a policy making up to five attempts, backing off exponentially from 250ms to 5s.
+func DefaultDialRetryPolicy() *DialRetryPolicy {
+	return &DialRetryPolicy{
+		MaxAttempts: 5,
+		Interval:    250 * time.Millisecond,
+		Multiplier:  2,
+		MaxInterval: 5 * time.Second,
+		Jitter:      0.2,
+	}
+}
+
+// DialOptions tunes an individual dial. See Context.DialWithOptions.
 type DialOptions struct {
-	ConnectTimeout  time.Duration
-	Identity        string
-	AppData         []byte
+	// ConnectTimeout bounds each dial attempt. Defaults to 15 seconds if zero.
+	ConnectTimeout time.Duration
+
+	// Identity selects the terminator to dial by its instance identity, for services hosted by addressable terminators.
+	Identity string
+
+	// AppData is passed to the hosting application with the dial request. See GetCallerInfo.
+	AppData []byte
+
+	// StickinessToken requests the terminator previously selected for the token, if the service's terminator
+	// strategy supports it.
 	StickinessToken []byte
+
+	// RetryPolicy, if set, re-attempts dials that fail with transient errors. By default a dial is attempted once.
+	RetryPolicy *DialRetryPolicy
+
+	// PreferredEdgeRouters names the edge routers to route the dial through, if the session includes any of them.
+	// Otherwise, the lowest latency router of the session is used.
+	PreferredEdgeRouters []string
+
+	// FailureDomains, if set, prefers or avoids edge routers of the session by their labels. It is applied after
+	// PreferredEdgeRouters, if none of those are available. To apply a policy to the terminators dialed instead, see
+	// FailureDomainTerminatorStrategy.
+	FailureDomains *FailureDomainPolicy
+
+	// PSK, if set, wraps the connection in an additional AES-GCM encryption layer keyed by this pre-shared key. The
+	// hosting side must listen with the same key set in ListenOptions.PSK. See edge.NewPskConn.
+	PSK []byte
+
+	// AffinityKey, if set and Identity is not, selects one of the service's addressable terminators by consistently
+	// hashing the key over the terminator identities, so that dials with the same key reach the same hosting instance
+	// while it remains available. If the service has no addressable terminators, the dial proceeds without affinity.
+	AffinityKey string
+
+	// TerminatorStrategy, if set and Identity is not, selects which of the service's addressable terminators to dial
+	// on the client side, taking precedence over AffinityKey. If the service has no addressable terminators or the
+	// strategy selects none, the router's terminator strategy applies.
+	TerminatorStrategy TerminatorStrategy
+
+	// Variant, if set and Identity is not, dials only the terminators serving the given variant of the service, such
+	// as "version=v2" for a canary deployment, so that variants can share a service name. Terminators serve a variant
+	// if the service's ServiceVariantsTag assigns their instance identity to it, or if the tag has no entry for the
+	// variant and one of the comma separated labels of their instance identity equals it. TerminatorStrategy, if set,
+	// chooses among them. The dial fails if no terminator serves the variant.
+	Variant string
 }
 
 func (d DialOptions) GetConnectTimeout() time.Duration {
 	return d.ConnectTimeout
 }
 
+// DialOption modifies the DialOptions used by Context.DialWithContext, or made by NewDialOptions.
+type DialOption func(options *DialOptions)
+
+// NewDialOptions returns the default DialOptions modified by opts, for use with Context.DialWithOptions.
+func NewDialOptions(opts ...DialOption) *DialOptions {
+	options := &DialOptions{ConnectTimeout: 5 * time.Second}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithTerminatorStrategy selects the terminator to dial using the given strategy. See DialOptions.TerminatorStrategy.
+func WithTerminatorStrategy(strategy TerminatorStrategy) DialOption {
+	return func(options *DialOptions) {
+		options.TerminatorStrategy = strategy
+	}
+}
+
+// WithRetryPolicy re-attempts failed dials as allowed by the policy. See DialOptions.RetryPolicy.
+func WithRetryPolicy(policy *DialRetryPolicy) DialOption {
+	return func(options *DialOptions) {
+		options.RetryPolicy = policy
+	}
+}
+
+// WithVariant dials the terminators serving the given variant of the service. See DialOptions.Variant.
+func WithVariant(variant string) DialOption {
+	return func(options *DialOptions) {
+		options.Variant = variant
+	}
+}
+
+// WithTerminatorIdentity dials the terminator hosted with the given instance identity, e.g. a specific device among
+// the hosts of a shared service. See Context.GetServiceTerminatorIdentities for the identities available.
+func WithTerminatorIdentity(identity string) DialOption {
+	return func(options *DialOptions) {
+		options.Identity = identity
+	}
+}
+
 type ListenOptions struct {
 	// Initial static cost assigned to terminators for this service
 	Cost uint16
@@ -99,6 +326,46 @@ type ListenOptions struct {
 	// Wait for N listeners before returning from the Listen call. By default it will return
 	// before any listeners have been established.
 	WaitForNEstablishedListeners uint
+
+	// PSK, if set, wraps each accepted connection in an additional AES-GCM encryption layer keyed by this pre-shared
+	// key. Dialers must use the same key in DialOptions.PSK. See edge.NewPskListener.
+	PSK []byte
+
+	// MaxBindAttempts, if greater than zero, closes the listener with the last bind error once this many consecutive
+	// binds have failed while no terminator is established. By default, failed binds are retried indefinitely.
+	MaxBindAttempts int
+
+	// BindRetryInterval is the minimum delay before binding again through an edge router where a bind failed. By
+	// default, binds are retried on the next check for missing terminators, which happens every second.
+	BindRetryInterval time.Duration
+
+	// RebindInterval, if set, is the delay before re-binding after an established terminator is lost, e.g. because its
+	// edge router disconnected. The delay doubles with each failed re-bind, up to MaxRebindInterval, and resets once a
+	// terminator is re-established. By default, re-binding starts on the next check for missing terminators. Each
+	// terminator re-established after a loss emits EventListenerRebind.
+	RebindInterval time.Duration
+
+	// MaxRebindInterval caps the re-bind delay. Defaults to DefaultMaxRebindInterval.
+	MaxRebindInterval time.Duration
+
+	// IdleTimeout, if set, evicts accepted connections once no data has been sent or received on them for this long,
+	// so that connections from clients that vanished don't accumulate. It replaces Options.IdleTimeout for the
+	// listener's connections. Evictions are counted by the MetricListenerIdleEvictions meter.
+	IdleTimeout time.Duration
+
+	// BeforeIdleClose, if set, is called before a connection is evicted by IdleTimeout. Returning false vetoes the
+	// eviction, keeping the connection open for another IdleTimeout. It may also be used to flush state associated
+	// with the connection.
+	BeforeIdleClose func(conn edge.Conn, idle time.Duration) bool
+
+	// AcceptQueueSize is the number of dialed connections queued for AcceptEdge through each edge router. Defaults to
+	// edge.DefaultAcceptQueueSize.
+	AcceptQueueSize int
+
+	// AcceptQueueFullPolicy determines what happens to dials when a queue is full: they wait by default, but may
+	// instead be rejected or replace the oldest queued connection, so that an overloaded host sheds load. Shed
+	// connections are counted by the MetricListenerAcceptShed meter.
+	AcceptQueueFullPolicy edge.AcceptQueueFullPolicy
 }
 
 func DefaultListenOptions() *ListenOptions {
@@ -109,3 +376,95 @@ func DefaultListenOptions() *ListenOptions {
 		MaxTerminators: 3,
 	}
 }
+
+// ListenOption modifies the ListenOptions made by NewListenOptions, starting from DefaultListenOptions.
+type ListenOption func(options *ListenOptions)
+
+// NewListenOptions returns DefaultListenOptions modified by opts, for use with Context.ListenWithOptions.
+func NewListenOptions(opts ...ListenOption) *ListenOptions {
+	options := DefaultListenOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithCost sets the initial static cost of the listener's terminators. Dials prefer lower cost terminators.
+func WithCost(cost uint16) ListenOption {
+	return func(options *ListenOptions) {
+		options.Cost = cost
+	}
+}
+
+// WithPrecedence sets the initial precedence of the listener's terminators. Dials only reach terminators of lower
+// precedence if none of a higher precedence are available, e.g. PrecedenceRequired for an active instance and
+// PrecedenceFailed for a standby.
+func WithPrecedence(precedence Precedence) ListenOption {
+	return func(options *ListenOptions) {
+		options.Precedence = precedence
+	}
+}
+
+// WithInstanceIdentity assigns identity to the listener's terminators, so that it can be dialed individually with
+// WithTerminatorIdentity. An empty identity uses the name of the hosting edge identity.
+func WithInstanceIdentity(identity string) ListenOption {
+	return func(options *ListenOptions) {
+		options.Identity = identity
+		options.BindUsingEdgeIdentity = identity == ""
+	}
+}
+
+// WithMaxTerminators sets the number of terminators to establish, each through a different edge router. The binds are
+// made concurrently, and connections accepted through any of them are returned by the one Listener, so a listener
+// stays reachable while any of its edge routers is, and dials are spread across the routers by the service's
+// terminator strategy.
+func WithMaxTerminators(maxTerminators int) ListenOption {
+	return func(options *ListenOptions) {
+		options.MaxTerminators = maxTerminators
+	}
+}
+
+// WithBindRetries limits the consecutive failed binds before the listener is closed, and the minimum delay before a
+// failed bind is retried through the same edge router. See ListenOptions.MaxBindAttempts.
+func WithBindRetries(maxAttempts int, interval time.Duration) ListenOption {
+	return func(options *ListenOptions) {
+		options.MaxBindAttempts = maxAttempts
+		options.BindRetryInterval = interval
+	}
+}
+
+// WithRebindBackoff delays re-binding after a terminator is lost by interval, doubling the delay after each failed
+// re-bind up to maxInterval. See ListenOptions.RebindInterval.
+func WithRebindBackoff(interval, maxInterval time.Duration) ListenOption {
+	return func(options *ListenOptions) {
+		options.RebindInterval = interval
+		options.MaxRebindInterval = maxInterval
+	}
+}
+
+// WithIdleEviction evicts accepted connections idle for timeout, calling beforeClose, if not nil, to veto or prepare
+// for each eviction. See ListenOptions.IdleTimeout.
+func WithIdleEviction(timeout time.Duration, beforeClose func(conn edge.Conn, idle time.Duration) bool) ListenOption {
+	return func(options *ListenOptions) {
+		options.IdleTimeout = timeout
+		options.BeforeIdleClose = beforeClose
+	}
+}
+
+// WithAcceptQueue sets the size of the accept queues and what happens to dials when they are full. See
+// ListenOptions.AcceptQueueSize.
+func WithAcceptQueue(size int, policy edge.AcceptQueueFullPolicy) ListenOption {
+	return func(options *ListenOptions) {
+		options.AcceptQueueSize = size
+		options.AcceptQueueFullPolicy = policy
+	}
+}
+
+// WithWaitForListeners makes Listen wait until count terminators are established, failing if they aren't within
+// timeout.
+func WithWaitForListeners(count uint, timeout time.Duration) ListenOption {
+	return func(options *ListenOptions) {
+		options.WaitForNEstablishedListeners = count
+		options.ConnectTimeout = timeout
+	}
+}
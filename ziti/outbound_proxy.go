@@ -0,0 +1,228 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// parseProxyUrl parses Config.Proxy, which must be a http or socks5 URL.
+func parseProxyUrl(s string) (*url.URL, error) {
+	proxyUrl, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid proxy url '%s'", s)
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, errors.Errorf("invalid proxy url '%s', scheme must be http, socks5 or socks5h", s)
+	}
+
+	if proxyUrl.Host == "" {
+		return nil, errors.Errorf("invalid proxy url '%s', no proxy address given", s)
+	}
+	return proxyUrl, nil
+}
+
+// getProxy returns the proxy to connect to the host and port through: the configured proxy if there is one, or the
+// proxy given by HTTPS_PROXY and NO_PROXY otherwise. It returns nil for direct connections.
+func (context *ContextImpl) getProxy(hostname string, port uint16) (*url.URL, error) {
+	if context.proxyUrl != nil {
+		return context.proxyUrl, nil
+	}
+
+	target := &url.URL{Scheme: "https", Host: net.JoinHostPort(hostname, strconv.Itoa(int(port)))}
+	return http.ProxyFromEnvironment(&http.Request{URL: target})
+}
+
+// proxyEdgeRouterAddress returns the address to dial an edge router at, connecting through a proxy if one applies.
+// Only tls edge router addresses can be proxied, others are dialed directly.
+func (context *ContextImpl) proxyEdgeRouterAddress(addr transport.Address) (transport.Address, error) {
+	hostAddr, ok := addr.(interface {
+		Hostname() string
+		Port() uint16
+	})
+	if !ok {
+		return addr, nil
+	}
+
+	proxyUrl, err := context.getProxy(hostAddr.Hostname(), hostAddr.Port())
+	if err != nil || proxyUrl == nil {
+		return addr, err
+	}
+
+	if addr.Type() != "tls" {
+		context.log().WithField("addr", addr.String()).WithField("proxy", proxyUrl.Redacted()).
+			Warn("only tls edge router connections can use a proxy, connecting directly")
+		return addr, nil
+	}
+
+	return &proxiedAddress{
+		Address:  addr,
+		hostname: hostAddr.Hostname(),
+		port:     hostAddr.Port(),
+		proxyUrl: proxyUrl,
+	}, nil
+}
+
+// proxiedAddress is a tls transport address dialed through a http CONNECT or socks5 proxy.
+type proxiedAddress struct {
+	transport.Address
+	hostname string
+	port     uint16
+	proxyUrl *url.URL
+}
+
+func (self *proxiedAddress) Dial(name string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	return self.DialWithLocalBinding(name, "", i, timeout, tcfg)
+}
+
+func (self *proxiedAddress) DialWithLocalBinding(name string, _ string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	tlsConfig := i.ClientTLSConfig().Clone()
+	tlsConfig.NextProtos = append(tlsConfig.NextProtos, tcfg.Protocols()...)
+	return dialThroughProxy(self.proxyUrl, name, self.hostname, self.port, tlsConfig, timeout)
+}
+
+func dialThroughProxy(proxyUrl *url.URL, name, hostname string, port uint16, tlsConfig *tls.Config, timeout time.Duration) (transport.Conn, error) {
+	destination := net.JoinHostPort(hostname, strconv.Itoa(int(port)))
+	log := pfxlog.Logger().WithField("dest", destination).WithField("proxy", proxyUrl.Redacted())
+
+	var auth *proxy.Auth
+	if proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		auth = &proxy.Auth{User: proxyUrl.User.Username(), Password: password}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var proxyDialer proxy.Dialer
+	if proxyUrl.Scheme == "http" {
+		proxyDialer = &httpConnectDialer{dialer: dialer, address: proxyUrl.Host, auth: auth, timeout: timeout}
+	} else {
+		var err error
+		if proxyDialer, err = proxy.SOCKS5("tcp", proxyUrl.Host, auth, dialer); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debug("connecting through proxy")
+	conn, err := proxyDialer.Dial("tcp", destination)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to %s through proxy %s", destination, proxyUrl.Redacted())
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = hostname
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if timeout > 0 {
+		_ = tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	return &proxiedConn{
+		Conn: tlsConn,
+		detail: &transport.ConnectionDetail{
+			Address: "tls:" + destination,
+			InBound: false,
+			Name:    name,
+		},
+	}, nil
+}
+
+type proxiedConn struct {
+	*tls.Conn
+	detail *transport.ConnectionDetail
+}
+
+func (self *proxiedConn) Detail() *transport.ConnectionDetail {
+	return self.detail
+}
+
+func (self *proxiedConn) PeerCertificates() []*x509.Certificate {
+	return self.ConnectionState().PeerCertificates
+}
+
+// httpConnectDialer connects through a HTTP CONNECT proxy, sending credentials as Proxy-Authorization.
+type httpConnectDialer struct {
+	dialer  *net.Dialer
+	address string
+	auth    *proxy.Auth
+	timeout time.Duration
+}
+
+func (self *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := self.dialer.Dial(network, self.address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to proxy server at %s", self.address)
+	}
+
+	if self.timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(self.timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if self.auth != nil {
+		credentials := base64.StdEncoding.EncodeToString([]byte(self.auth.User + ":" + self.auth.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "unable to send connect request to proxy server at %s", self.address)
+	}
+
+	// the proxy doesn't send anything after its response until the TLS handshake starts, so the reader doesn't
+	// buffer any of the tunneled data
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "unable to read connect response from proxy server at %s", self.address)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.Errorf("proxy server at %s refused connect request with status %s", self.address, resp.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,118 @@
+package ziti
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// startConnectProxy runs a HTTP CONNECT proxy requiring the given basic credentials.
+func startConnectProxy(t *testing.T, credentials string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)) {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer func() { _ = target.Close() }()
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go func() { _, _ = io.Copy(target, reader) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
+
+func Test_parseProxyUrl(t *testing.T) {
+	req := require.New(t)
+
+	for _, valid := range []string{"http://proxy:3128", "http://user:pw@proxy:3128", "socks5://proxy:1080", "socks5h://proxy:1080"} {
+		_, err := parseProxyUrl(valid)
+		req.NoError(err, valid)
+	}
+	for _, invalid := range []string{"ftp://proxy:21", "http://", "proxy:3128"} {
+		_, err := parseProxyUrl(invalid)
+		req.Error(err, invalid)
+	}
+}
+
+func Test_proxyEdgeRouterAddress(t *testing.T) {
+	req := require.New(t)
+
+	proxyUrl, err := parseProxyUrl("http://proxy.corp:3128")
+	req.NoError(err)
+	context := &ContextImpl{proxyUrl: proxyUrl}
+
+	addr, err := transport.ParseAddress("tls:router.example:443")
+	req.NoError(err)
+	proxied, err := context.proxyEdgeRouterAddress(addr)
+	req.NoError(err)
+	req.IsType(&proxiedAddress{}, proxied)
+	req.Equal(addr.String(), proxied.String())
+}
+
+func Test_dialThroughProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	proxyListener := startConnectProxy(t, "user:secret")
+
+	t.Run("connects with credentials", func(t *testing.T) {
+		req := require.New(t)
+		proxyUrl := &url.URL{Scheme: "http", Host: proxyListener.Addr().String(), User: url.UserPassword("user", "secret")}
+
+		conn, err := dialThroughProxy(proxyUrl, "test", host, uint16(port), &tls.Config{RootCAs: roots}, 5*time.Second)
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		req.Equal("tls:"+server.Listener.Addr().String(), conn.Detail().Address)
+		req.Len(conn.PeerCertificates(), 1)
+	})
+
+	t.Run("fails without credentials", func(t *testing.T) {
+		proxyUrl := &url.URL{Scheme: "http", Host: proxyListener.Addr().String()}
+		_, err := dialThroughProxy(proxyUrl, "test", host, uint16(port), &tls.Config{RootCAs: roots}, 5*time.Second)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,290 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/foundation/v2/concurrenz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/pkg/errors"
+)
+
+const DefaultPacketQueueSize = 64
+
+// PacketOptions configures the datagram connections returned by DialPacket and ListenPacket. Both sides of a service
+// must use datagram connections, since datagrams are framed on the wire.
+type PacketOptions struct {
+	// MaxDatagramSize is the size of the largest datagram that may be sent or received. Defaults to
+	// edge.DefaultMaxDatagramSize.
+	MaxDatagramSize int
+
+	// QueueSize is the number of received datagrams ListenPacket buffers for ReadFrom. When the queue is full, reading
+	// from the dialing clients pauses. Defaults to DefaultPacketQueueSize.
+	QueueSize int
+
+	// DialOptions are used by DialPacket, if set.
+	DialOptions *DialOptions
+
+	// ListenOptions are used by ListenPacket, if set.
+	ListenOptions *ListenOptions
+}
+
+func (self *PacketOptions) getQueueSize() int {
+	if self == nil || self.QueueSize <= 0 {
+		return DefaultPacketQueueSize
+	}
+	return self.QueueSize
+}
+
+func (self *PacketOptions) getMaxDatagramSize() int {
+	if self == nil {
+		return 0
+	}
+	return self.MaxDatagramSize
+}
+
+// DialPacket dials the named service and returns a connection for sending datagrams to, and receiving datagrams from,
+// the hosting application, which must use ListenPacket. Each write sends one datagram and each read returns one.
+func DialPacket(ztx Context, serviceName string, options *PacketOptions) (*edge.DatagramConn, error) {
+	var conn edge.Conn
+	var err error
+	if options != nil && options.DialOptions != nil {
+		conn, err = ztx.DialWithOptions(serviceName, options.DialOptions)
+	} else {
+		conn, err = ztx.Dial(serviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return edge.NewDatagramConn(conn, options.getMaxDatagramSize()), nil
+}
+
+// ListenPacket hosts the named service and returns a net.PacketConn receiving the datagrams of every client dialing
+// it with DialPacket. The address returned by ReadFrom identifies the client connection the datagram arrived on, and
+// may be passed to WriteTo to reply. Writing to a client that has disconnected fails, and closing the returned
+// PacketConn closes the service listener and all client connections.
+func ListenPacket(ztx Context, serviceName string, options *PacketOptions) (net.PacketConn, error) {
+	var listener edge.Listener
+	var err error
+	if options != nil && options.ListenOptions != nil {
+		listener, err = ztx.ListenWithOptions(serviceName, options.ListenOptions)
+	} else {
+		listener, err = ztx.Listen(serviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &listenPacketConn{
+		serviceName:     serviceName,
+		listener:        listener,
+		maxDatagramSize: options.getMaxDatagramSize(),
+		conns:           cmap.New[*edge.DatagramConn](),
+		datagrams:       make(chan *datagram, options.getQueueSize()),
+		closeNotify:     make(chan struct{}),
+		deadlineNotify:  make(chan struct{}, 1),
+		log:             contextLog(ztx),
+	}
+	go result.accept()
+	return result, nil
+}
+
+// PacketAddr is the address of a client connection to a service hosted with ListenPacket.
+type PacketAddr struct {
+	ServiceName string
+	Identity    string
+	CircuitId   string
+}
+
+func (self *PacketAddr) Network() string {
+	return "ziti"
+}
+
+func (self *PacketAddr) String() string {
+	return fmt.Sprintf("%s/%s/%s", self.ServiceName, self.Identity, self.CircuitId)
+}
+
+type datagram struct {
+	payload []byte
+	addr    *PacketAddr
+}
+
+type listenPacketConn struct {
+	serviceName     string
+	listener        edge.Listener
+	maxDatagramSize int
+	conns           cmap.ConcurrentMap[string, *edge.DatagramConn]
+	datagrams       chan *datagram
+	closeGuard      edge.CloseGuard
+	closeNotify     chan struct{}
+	readDeadline    concurrenz.AtomicValue[time.Time]
+	writeDeadline   concurrenz.AtomicValue[time.Time]
+	deadlineNotify  chan struct{}
+	connSeq         atomic.Uint64
+	log             *pfxlog.Builder
+}
+
+func (self *listenPacketConn) accept() {
+	log := self.log.WithField("service", self.serviceName)
+	for {
+		conn, err := self.listener.AcceptEdge()
+		if err != nil {
+			if !self.closeGuard.IsClosed() {
+				log.WithError(err).Error("datagram listener stopped accepting")
+				_ = self.Close()
+			}
+			return
+		}
+
+		addr := &PacketAddr{
+			ServiceName: self.serviceName,
+			Identity:    conn.SourceIdentifier(),
+			CircuitId:   conn.GetCircuitId(),
+		}
+		if addr.CircuitId == "" {
+			addr.CircuitId = fmt.Sprintf("conn-%d", self.connSeq.Add(1))
+		}
+
+		datagramConn := edge.NewDatagramConn(conn, self.maxDatagramSize)
+		self.conns.Set(addr.String(), datagramConn)
+		if self.closeGuard.IsClosed() {
+			_ = datagramConn.Close()
+		}
+		go self.read(datagramConn, addr)
+	}
+}
+
+func (self *listenPacketConn) read(conn *edge.DatagramConn, addr *PacketAddr) {
+	defer func() {
+		self.conns.Remove(addr.String())
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, conn.MaxDatagramSize())
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			self.log.WithField("service", self.serviceName).WithField("addr", addr.String()).
+				WithError(err).Debug("datagram client connection closed")
+			return
+		}
+
+		payload := make([]byte, n)
+		copy(payload, buf[:n])
+
+		select {
+		case self.datagrams <- &datagram{payload: payload, addr: addr}:
+		case <-self.closeNotify:
+			return
+		}
+	}
+}
+
+func (self *listenPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		var timer *time.Timer
+		var timeoutCh <-chan time.Time
+		if deadline := self.readDeadline.Load(); !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeoutCh = timer.C
+		}
+
+		select {
+		case d := <-self.datagrams:
+			stopTimer(timer)
+			return copy(p, d.payload), d.addr, nil
+		case <-self.closeNotify:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		case <-self.deadlineNotify:
+			stopTimer(timer)
+		case <-timeoutCh:
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+func (self *listenPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if self.closeGuard.IsClosed() {
+		return 0, net.ErrClosed
+	}
+
+	if addr == nil {
+		return 0, errors.New("no destination address given")
+	}
+
+	conn, found := self.conns.Get(addr.String())
+	if !found {
+		return 0, errors.Errorf("no datagram client connection for address %s", addr.String())
+	}
+
+	if err := conn.SetWriteDeadline(self.writeDeadline.Load()); err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+func (self *listenPacketConn) Close() error {
+	if err := self.closeGuard.Close("datagram listener"); err != nil {
+		return err
+	}
+	close(self.closeNotify)
+
+	err := self.listener.Close()
+	for entry := range self.conns.IterBuffered() {
+		_ = entry.Val.Close()
+	}
+	return err
+}
+
+func (self *listenPacketConn) LocalAddr() net.Addr {
+	return self.listener.Addr()
+}
+
+func (self *listenPacketConn) SetDeadline(t time.Time) error {
+	if err := self.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return self.SetWriteDeadline(t)
+}
+
+func (self *listenPacketConn) SetReadDeadline(t time.Time) error {
+	self.readDeadline.Store(t)
+	select {
+	case self.deadlineNotify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (self *listenPacketConn) SetWriteDeadline(t time.Time) error {
+	self.writeDeadline.Store(t)
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sync"
+	"time"
+
+	metrics2 "github.com/rcrowley/go-metrics"
+)
+
+const (
+	// MetricQueueDialsCompleted meters dials completing, successfully or not, including their retries.
+	MetricQueueDialsCompleted = "queue.dials.completed"
+
+	// MetricQueueSessionsCompleted meters session creation requests to the controller completing.
+	MetricQueueSessionsCompleted = "queue.sessions.completed"
+
+	// MetricQueueRouterConnectsCompleted meters edge router connection attempts completing.
+	MetricQueueRouterConnectsCompleted = "queue.router.connects.completed"
+)
+
+// QueueStats describes the work in progress in a Context, as returned by Context.QueueStats. When dial latency
+// spikes, a deep session queue with a low rate points at a slow controller, while a deep dial queue with shallow
+// session and router connect queues points at a backlog in the SDK or the application.
+type QueueStats struct {
+	// Dials are calls to Dial and its variants.
+	Dials QueueStat
+
+	// Sessions are session creation requests sent to the controller, by dials and listeners.
+	Sessions QueueStat
+
+	// RouterConnects are connection attempts to edge routers.
+	RouterConnects QueueStat
+}
+
+// QueueStat describes one kind of work in progress.
+type QueueStat struct {
+	// Depth is the number of items started but not yet completed.
+	Depth int
+
+	// OldestAge is how long the oldest item in progress has been running, or zero if there is none.
+	OldestAge time.Duration
+
+	// Rate is the one-minute moving average of items completed per second.
+	Rate float64
+
+	// Completed is the number of items completed since the Context was created.
+	Completed int64
+}
+
+// workQueue tracks the start times of work in progress. The zero value is empty and ready to use.
+type workQueue struct {
+	lock    sync.Mutex
+	nextId  uint64
+	started map[uint64]time.Time
+}
+
+func (self *workQueue) add() uint64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.started == nil {
+		self.started = map[uint64]time.Time{}
+	}
+	self.nextId++
+	self.started[self.nextId] = time.Now()
+	return self.nextId
+}
+
+func (self *workQueue) remove(id uint64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.started, id)
+}
+
+func (self *workQueue) snapshot() (int, time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var oldest time.Time
+	for _, start := range self.started {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = start
+		}
+	}
+
+	if oldest.IsZero() {
+		return 0, 0
+	}
+	return len(self.started), time.Since(oldest)
+}
+
+// workQueues are the queues reported by Context.QueueStats.
+type workQueues struct {
+	dials          workQueue
+	sessions       workQueue
+	routerConnects workQueue
+}
+
+// trackWork adds an item to the queue, returning a function to call once it completes.
+func (context *ContextImpl) trackWork(queue *workQueue, metric string) func() {
+	id := queue.add()
+	return func() {
+		queue.remove(id)
+		if context.metrics != nil {
+			context.metrics.Meter(metric).Mark(1)
+		}
+	}
+}
+
+func (context *ContextImpl) queueStat(queue *workQueue, metric string) QueueStat {
+	result := QueueStat{}
+	result.Depth, result.OldestAge = queue.snapshot()
+
+	if context.metrics != nil {
+		if meter, ok := context.metrics.Meter(metric).(metrics2.Meter); ok {
+			result.Rate = meter.Rate1()
+			result.Completed = meter.Count()
+		}
+	}
+	return result
+}
+
+func (context *ContextImpl) QueueStats() QueueStats {
+	return QueueStats{
+		Dials:          context.queueStat(&context.queues.dials, MetricQueueDialsCompleted),
+		Sessions:       context.queueStat(&context.queues.sessions, MetricQueueSessionsCompleted),
+		RouterConnects: context.queueStat(&context.queues.routerConnects, MetricQueueRouterConnectsCompleted),
+	}
+}
@@ -0,0 +1,35 @@
+package ziti
+
+import (
+	"github.com/openziti/metrics"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_contextImpl_QueueStats(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{metrics: metrics.NewRegistry("test", nil)}
+	req.Equal(QueueStats{}, ctx.QueueStats())
+
+	firstDone := ctx.trackWork(&ctx.queues.dials, MetricQueueDialsCompleted)
+	time.Sleep(10 * time.Millisecond)
+	secondDone := ctx.trackWork(&ctx.queues.dials, MetricQueueDialsCompleted)
+	ctx.trackWork(&ctx.queues.sessions, MetricQueueSessionsCompleted)
+
+	stats := ctx.QueueStats()
+	req.Equal(2, stats.Dials.Depth)
+	req.GreaterOrEqual(stats.Dials.OldestAge, 10*time.Millisecond)
+	req.Equal(1, stats.Sessions.Depth)
+	req.Equal(0, stats.RouterConnects.Depth)
+
+	firstDone()
+	secondDone()
+
+	stats = ctx.QueueStats()
+	req.Equal(0, stats.Dials.Depth)
+	req.Zero(stats.Dials.OldestAge)
+	req.Equal(int64(2), stats.Dials.Completed)
+	req.Equal(1, stats.Sessions.Depth)
+}
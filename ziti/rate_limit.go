@@ -0,0 +1,174 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimit is a token bucket allowing Burst requests at once, refilled with one request every Interval. An Interval
+// of zero disables the limit.
+type RateLimit struct {
+	Interval time.Duration
+	Burst    int
+}
+
+// RateLimitOptions limits the rate of the requests a Context makes to the controller, to protect controllers when a
+// fleet of clients reconnects at once, e.g. after a controller restart. Requests over the limit are delayed, not
+// failed. Unset limits are unlimited.
+type RateLimitOptions struct {
+	// ApiSessionRefresh limits API Session refreshes.
+	ApiSessionRefresh *RateLimit
+
+	// ServiceRefresh limits refreshes of the service list and of single services.
+	ServiceRefresh *RateLimit
+
+	// SessionCreate limits the creation of service sessions for dials and binds.
+	SessionCreate *RateLimit
+
+	// StartupJitter delays the first periodic refresh of services and sessions by a random duration of up to
+	// StartupJitter, so that clients started together don't refresh together. It should be shorter than the refresh
+	// intervals and the API Session expiry.
+	StartupJitter time.Duration
+}
+
+// DefaultRateLimitOptions returns rate limits that leave single clients unaffected, while spreading the load of
+// clients reconnecting in bulk.
+func DefaultRateLimitOptions() *RateLimitOptions {
+	return &RateLimitOptions{
+		ApiSessionRefresh: &RateLimit{Interval: 5 * time.Second, Burst: 2},
+		ServiceRefresh:    &RateLimit{Interval: 5 * time.Second, Burst: 3},
+		SessionCreate:     &RateLimit{Interval: 100 * time.Millisecond, Burst: 20},
+		StartupJitter:     30 * time.Second,
+	}
+}
+
+func (self *RateLimitOptions) getStartupJitter() time.Duration {
+	if self == nil || self.StartupJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(self.StartupJitter)))
+}
+
+// tokenBucket implements a RateLimit. A nil tokenBucket is unlimited.
+type tokenBucket struct {
+	name     string
+	interval time.Duration
+	burst    float64
+	logger   *logrus.Logger
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(name string, limit *RateLimit) *tokenBucket {
+	if limit == nil || limit.Interval <= 0 {
+		return nil
+	}
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		name:     name,
+		interval: limit.Interval,
+		burst:    burst,
+		tokens:   burst,
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// reserve takes a token and returns how long to wait before it may be used. Tokens may be borrowed from the future, so
+// that concurrent callers are spaced out by the interval.
+func (self *tokenBucket) reserve() time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	now := self.now()
+	self.tokens += float64(now.Sub(self.last)) / float64(self.interval)
+	if self.tokens > self.burst {
+		self.tokens = self.burst
+	}
+	self.last = now
+
+	self.tokens--
+	if self.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-self.tokens * float64(self.interval))
+}
+
+// wait blocks until a request may be made, ctx is done or the Context is closed.
+func (self *tokenBucket) wait(ctx gocontext.Context, closeNotify <-chan struct{}) error {
+	if self == nil {
+		return nil
+	}
+
+	delay := self.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	newLogger(self.logger).WithField("limit", self.name).Debugf("controller request rate limited, delaying for %v", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closeNotify:
+		return errors.New("context is closed")
+	}
+}
+
+// rateLimiters are the token buckets of the limits in RateLimitOptions.
+type rateLimiters struct {
+	apiSessionRefresh *tokenBucket
+	serviceRefresh    *tokenBucket
+	sessionCreate     *tokenBucket
+}
+
+func newRateLimiters(options *RateLimitOptions, logger *logrus.Logger) rateLimiters {
+	if options == nil {
+		return rateLimiters{}
+	}
+	result := rateLimiters{
+		apiSessionRefresh: newTokenBucket("apiSessionRefresh", options.ApiSessionRefresh),
+		serviceRefresh:    newTokenBucket("serviceRefresh", options.ServiceRefresh),
+		sessionCreate:     newTokenBucket("sessionCreate", options.SessionCreate),
+	}
+	for _, bucket := range []*tokenBucket{result.apiSessionRefresh, result.serviceRefresh, result.sessionCreate} {
+		if bucket != nil {
+			bucket.logger = logger
+		}
+	}
+	return result
+}
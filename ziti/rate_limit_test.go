@@ -0,0 +1,73 @@
+package ziti
+
+import (
+	gocontext "context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tokenBucket(t *testing.T) {
+	req := require.New(t)
+
+	bucket := newTokenBucket("test", &RateLimit{Interval: time.Second, Burst: 2})
+	now := time.Now()
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+
+	req.Zero(bucket.reserve())
+	req.Zero(bucket.reserve())
+	req.Equal(time.Second, bucket.reserve())
+	req.Equal(2*time.Second, bucket.reserve())
+
+	now = now.Add(3 * time.Second)
+	req.Zero(bucket.reserve())
+
+	// tokens don't accumulate past the burst
+	now = now.Add(time.Hour)
+	req.Zero(bucket.reserve())
+	req.Zero(bucket.reserve())
+	req.Equal(time.Second, bucket.reserve())
+}
+
+func Test_tokenBucket_wait(t *testing.T) {
+	req := require.New(t)
+
+	var unlimited *tokenBucket
+	req.NoError(unlimited.wait(gocontext.Background(), nil))
+	req.Nil(newTokenBucket("test", nil))
+	req.Nil(newTokenBucket("test", &RateLimit{}))
+
+	bucket := newTokenBucket("test", &RateLimit{Interval: time.Hour})
+	req.NoError(bucket.wait(gocontext.Background(), nil))
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 10*time.Millisecond)
+	defer cancel()
+	req.ErrorIs(bucket.wait(ctx, nil), gocontext.DeadlineExceeded)
+
+	closeNotify := make(chan struct{})
+	close(closeNotify)
+	req.Error(bucket.wait(gocontext.Background(), closeNotify))
+
+	bucket = newTokenBucket("test", &RateLimit{Interval: 20 * time.Millisecond})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		req.NoError(bucket.wait(gocontext.Background(), nil))
+	}
+	req.GreaterOrEqual(time.Since(start), 35*time.Millisecond)
+}
+
+func Test_RateLimitOptions_getStartupJitter(t *testing.T) {
+	req := require.New(t)
+
+	var options *RateLimitOptions
+	req.Zero(options.getStartupJitter())
+
+	options = &RateLimitOptions{StartupJitter: time.Minute}
+	for i := 0; i < 100; i++ {
+		jitter := options.getStartupJitter()
+		req.GreaterOrEqual(jitter, time.Duration(0))
+		req.Less(jitter, time.Minute)
+	}
+}
@@ -0,0 +1,485 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"crypto"
+	"crypto/x509"
+	"io"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/metrics"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrReadOnly is returned by the operations a Context returned by ReadOnly does not permit.
+var ErrReadOnly = errors.New("operation not permitted on a read-only context")
+
+// ReadOnly returns a Context that delegates to ctx but only permits dialing and querying services, so that it can be
+// handed to third party code without giving it control over the Context. Listening, closing, authenticating and
+// changing credentials, MFA enrollment or the id fail with ErrReadOnly, or are ignored if they cannot return an error.
+// Credentials and API Sessions are not exposed: GetCredentials returns nil, authentication event listeners receive
+// a nil API Session, and the EventBus strips API Sessions and session tokens from its events. Listeners receive the read-only Context instead of ctx, and untyped event listeners may not be
+// added or removed.
+func ReadOnly(ctx Context) Context {
+	if readOnly, ok := ctx.(*readOnlyContext); ok {
+		return readOnly
+	}
+	return &readOnlyContext{ctx: ctx}
+}
+
+var _ Context = (*readOnlyContext)(nil)
+
+type readOnlyContext struct {
+	ctx Context
+}
+
+func (self *readOnlyContext) denied(operation string) {
+	contextLog(self.ctx).WithField("contextId", self.ctx.GetId()).Warnf("%s not permitted on a read-only context", operation)
+}
+
+// GetLogger implements network.LoggerOwner, returning the logger of the wrapped Context if it has one.
+func (self *readOnlyContext) GetLogger() *logrus.Logger {
+	if owner, ok := self.ctx.(network.LoggerOwner); ok {
+		return owner.GetLogger()
+	}
+	return nil
+}
+
+func (self *readOnlyContext) Authenticate() error {
+	return ErrReadOnly
+}
+
+func (self *readOnlyContext) SetCredentials(apis.Credentials) {
+	self.denied("SetCredentials")
+}
+
+func (self *readOnlyContext) GetCredentials() apis.Credentials {
+	return nil
+}
+
+func (self *readOnlyContext) GetCurrentIdentity() (*rest_model.IdentityDetail, error) {
+	return self.ctx.GetCurrentIdentity()
+}
+
+func (self *readOnlyContext) GetCurrentIdentityWithBackoff() (*rest_model.IdentityDetail, error) {
+	return self.ctx.GetCurrentIdentityWithBackoff()
+}
+
+func (self *readOnlyContext) Dial(serviceName string) (edge.Conn, error) {
+	return self.ctx.Dial(serviceName)
+}
+
+func (self *readOnlyContext) DialWithOptions(serviceName string, options *DialOptions) (edge.Conn, error) {
+	return self.ctx.DialWithOptions(serviceName, options)
+}
+
+func (self *readOnlyContext) DialWithContext(ctx gocontext.Context, serviceName string, opts ...DialOption) (edge.Conn, error) {
+	return self.ctx.DialWithContext(ctx, serviceName, opts...)
+}
+
+func (self *readOnlyContext) DialWithOptionsContext(ctx gocontext.Context, serviceName string, options *DialOptions) (edge.Conn, error) {
+	return self.ctx.DialWithOptionsContext(ctx, serviceName, options)
+}
+
+func (self *readOnlyContext) DialAddr(network string, addr string) (edge.Conn, error) {
+	return self.ctx.DialAddr(network, addr)
+}
+
+func (self *readOnlyContext) Listen(string) (edge.Listener, error) {
+	return nil, ErrReadOnly
+}
+
+func (self *readOnlyContext) ListenWithOptions(string, *ListenOptions) (edge.Listener, error) {
+	return nil, ErrReadOnly
+}
+
+func (self *readOnlyContext) GetServiceId(serviceName string) (string, bool, error) {
+	return self.ctx.GetServiceId(serviceName)
+}
+
+func (self *readOnlyContext) GetServices() ([]rest_model.ServiceDetail, error) {
+	return self.ctx.GetServices()
+}
+
+func (self *readOnlyContext) GetService(serviceName string) (*rest_model.ServiceDetail, bool) {
+	return self.ctx.GetService(serviceName)
+}
+
+func (self *readOnlyContext) GetServiceConfig(serviceName string, configType string, target interface{}) (bool, error) {
+	return self.ctx.GetServiceConfig(serviceName, configType, target)
+}
+
+func (self *readOnlyContext) GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error) {
+	return self.ctx.GetServiceForAddr(network, hostname, port)
+}
+
+func (self *readOnlyContext) RefreshServices() error {
+	return self.ctx.RefreshServices()
+}
+
+func (self *readOnlyContext) SetServiceRefreshInterval(time.Duration) {
+	self.denied("SetServiceRefreshInterval")
+}
+
+func (self *readOnlyContext) GetServicePermissions(name string) (*ServicePermissions, bool) {
+	return self.ctx.GetServicePermissions(name)
+}
+
+func (self *readOnlyContext) QueryServices(filter string, limit, offset int) ([]*rest_model.ServiceDetail, int, error) {
+	return self.ctx.QueryServices(filter, limit, offset)
+}
+
+func (self *readOnlyContext) RefreshService(serviceName string) (*rest_model.ServiceDetail, error) {
+	return self.ctx.RefreshService(serviceName)
+}
+
+func (self *readOnlyContext) GetServiceTerminators(serviceName string, offset, limit int) ([]*rest_model.TerminatorClientDetail, int, error) {
+	return self.ctx.GetServiceTerminators(serviceName, offset, limit)
+}
+
+func (self *readOnlyContext) GetServiceTerminatorIdentities(serviceName string) ([]string, error) {
+	return self.ctx.GetServiceTerminatorIdentities(serviceName)
+}
+
+func (self *readOnlyContext) GetEdgeRouters() ([]*EdgeRouter, error) {
+	return self.ctx.GetEdgeRouters()
+}
+
+func (self *readOnlyContext) GetSession(id string) (*rest_model.SessionDetail, error) {
+	return self.ctx.GetSession(id)
+}
+
+func (self *readOnlyContext) Metrics() metrics.Registry {
+	return self.ctx.Metrics()
+}
+
+func (self *readOnlyContext) Close() {
+	self.denied("Close")
+}
+
+func (self *readOnlyContext) CloseWithContext(gocontext.Context) error {
+	return ErrReadOnly
+}
+
+func (self *readOnlyContext) Shutdown(gocontext.Context) error {
+	return ErrReadOnly
+}
+
+func (self *readOnlyContext) RecentEvents() []RecentEvent {
+	return self.ctx.RecentEvents()
+}
+
+func (self *readOnlyContext) ResourceUsage() ResourceUsage {
+	return self.ctx.ResourceUsage()
+}
+
+func (self *readOnlyContext) DebugDump(w io.Writer) error {
+	return self.ctx.DebugDump(w)
+}
+
+func (self *readOnlyContext) Connections() []edge.ConnStats {
+	return self.ctx.Connections()
+}
+
+// EventBus returns a view of the Context's bus that doesn't expose the API Session of AuthenticatedEvent or the tokens
+// of created sessions.
+func (self *readOnlyContext) EventBus() *EventBus {
+	return self.ctx.EventBus().view(redactEvent)
+}
+
+// redactEvent returns the event without the API Session or session token it carries.
+func redactEvent(event Event) Event {
+	switch e := event.(type) {
+	case AuthenticatedEvent:
+		return AuthenticatedEvent{}
+	case SessionCreatedEvent:
+		if e.Session != nil {
+			session := *e.Session
+			session.Token = nil
+			return SessionCreatedEvent{Session: &session}
+		}
+	}
+	return event
+}
+
+func (self *readOnlyContext) ConnectionState() ConnectionState {
+	return self.ctx.ConnectionState()
+}
+
+func (self *readOnlyContext) Health() *Health {
+	return self.ctx.Health()
+}
+
+func (self *readOnlyContext) ApiSessionRefreshStatus() ApiSessionRefreshStatus {
+	return self.ctx.ApiSessionRefreshStatus()
+}
+
+func (self *readOnlyContext) MetricsSnapshot() *MetricsSnapshot {
+	return self.ctx.MetricsSnapshot()
+}
+
+func (self *readOnlyContext) Stats() ContextStats {
+	return self.ctx.Stats()
+}
+
+func (self *readOnlyContext) QueueStats() QueueStats {
+	return self.ctx.QueueStats()
+}
+
+func (self *readOnlyContext) DialLatencies(serviceName string, window time.Duration) *LatencyHistogram {
+	return self.ctx.DialLatencies(serviceName, window)
+}
+
+func (self *readOnlyContext) RegisterDialSLO(slo DialSLO) {
+	self.ctx.RegisterDialSLO(slo)
+}
+
+func (self *readOnlyContext) AddZitiMfaHandler(func(query *rest_model.AuthQueryDetail, resp MfaCodeResponse) error) {
+	self.denied("AddZitiMfaHandler")
+}
+
+func (self *readOnlyContext) EnrollZitiMfa() (*rest_model.DetailMfa, error) {
+	return nil, ErrReadOnly
+}
+
+func (self *readOnlyContext) VerifyZitiMfa(string) error {
+	return ErrReadOnly
+}
+
+func (self *readOnlyContext) RemoveZitiMfa(string) error {
+	return ErrReadOnly
+}
+
+// GetZitiMfa returns the enrollment without the provisioning URL and recovery codes, which would allow answering MFA
+// challenges.
+func (self *readOnlyContext) GetZitiMfa() (*rest_model.DetailMfa, error) {
+	detail, err := self.ctx.GetZitiMfa()
+	if err != nil || detail == nil {
+		return detail, err
+	}
+	result := *detail
+	result.ProvisioningURL = ""
+	result.RecoveryCodes = nil
+	return &result, nil
+}
+
+func (self *readOnlyContext) GetZitiMfaRecoveryCodes(string) ([]string, error) {
+	return nil, ErrReadOnly
+}
+
+func (self *readOnlyContext) NewZitiMfaRecoveryCodes(string) error {
+	return ErrReadOnly
+}
+
+func (self *readOnlyContext) GetAuthenticators() ([]*rest_model.AuthenticatorDetail, error) {
+	return self.ctx.GetAuthenticators()
+}
+
+func (self *readOnlyContext) UpdatePassword(string, string) error {
+	return ErrReadOnly
+}
+
+func (self *readOnlyContext) RotateCertAuthenticator() ([]*x509.Certificate, crypto.PrivateKey, error) {
+	return nil, nil, ErrReadOnly
+}
+
+func (self *readOnlyContext) GetId() string {
+	return self.ctx.GetId()
+}
+
+func (self *readOnlyContext) SetId(string) {
+	self.denied("SetId")
+}
+
+func (self *readOnlyContext) Flags() *FeatureFlags {
+	return self.ctx.Flags()
+}
+
+func (self *readOnlyContext) WarmServices(names []string) error {
+	return self.ctx.WarmServices(names)
+}
+
+func (self *readOnlyContext) Events() Eventer {
+	return &readOnlyEventer{
+		ctx:     self,
+		eventer: self.ctx.Events(),
+	}
+}
+
+var _ Eventer = (*readOnlyEventer)(nil)
+
+// readOnlyEventer forwards typed listener registrations, substituting the read-only Context in listener arguments.
+type readOnlyEventer struct {
+	ctx     *readOnlyContext
+	eventer Eventer
+}
+
+func noopRemover() {}
+
+func (self *readOnlyEventer) AddServiceAddedListener(handler func(Context, *rest_model.ServiceDetail)) func() {
+	return self.eventer.AddServiceAddedListener(func(_ Context, detail *rest_model.ServiceDetail) {
+		handler(self.ctx, detail)
+	})
+}
+
+func (self *readOnlyEventer) AddServiceChangedListener(handler func(Context, *rest_model.ServiceDetail)) func() {
+	return self.eventer.AddServiceChangedListener(func(_ Context, detail *rest_model.ServiceDetail) {
+		handler(self.ctx, detail)
+	})
+}
+
+func (self *readOnlyEventer) AddServiceRemovedListener(handler func(Context, *rest_model.ServiceDetail)) func() {
+	return self.eventer.AddServiceRemovedListener(func(_ Context, detail *rest_model.ServiceDetail) {
+		handler(self.ctx, detail)
+	})
+}
+
+func (self *readOnlyEventer) AddRouterConnectedListener(handler func(ztx Context, name string, addr string)) func() {
+	return self.eventer.AddRouterConnectedListener(func(_ Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *readOnlyEventer) AddRouterDisconnectedListener(handler func(ztx Context, name string, addr string)) func() {
+	return self.eventer.AddRouterDisconnectedListener(func(_ Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *readOnlyEventer) AddRouterOfflineListener(handler func(ztx Context, name string, addr string)) func() {
+	return self.eventer.AddRouterOfflineListener(func(_ Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *readOnlyEventer) AddRouterOnlineListener(handler func(ztx Context, name string, addr string)) func() {
+	return self.eventer.AddRouterOnlineListener(func(_ Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+// AddMfaTotpCodeListener is not permitted, as answering MFA challenges would allow authenticating the Context.
+func (self *readOnlyEventer) AddMfaTotpCodeListener(func(Context, *rest_model.AuthQueryDetail, MfaCodeResponse)) func() {
+	self.ctx.denied("AddMfaTotpCodeListener")
+	return noopRemover
+}
+
+func (self *readOnlyEventer) AddAuthQueryListener(handler func(Context, *rest_model.AuthQueryDetail)) func() {
+	return self.eventer.AddAuthQueryListener(func(_ Context, query *rest_model.AuthQueryDetail) {
+		handler(self.ctx, query)
+	})
+}
+
+func (self *readOnlyEventer) AddAuthenticationStatePartialListener(handler func(Context, apis.ApiSession)) func() {
+	return self.eventer.AddAuthenticationStatePartialListener(func(Context, apis.ApiSession) {
+		handler(self.ctx, nil)
+	})
+}
+
+func (self *readOnlyEventer) AddAuthenticationStateFullListener(handler func(Context, apis.ApiSession)) func() {
+	return self.eventer.AddAuthenticationStateFullListener(func(Context, apis.ApiSession) {
+		handler(self.ctx, nil)
+	})
+}
+
+func (self *readOnlyEventer) AddAuthenticationStateUnauthenticatedListener(handler func(Context, apis.ApiSession)) func() {
+	return self.eventer.AddAuthenticationStateUnauthenticatedListener(func(Context, apis.ApiSession) {
+		handler(self.ctx, nil)
+	})
+}
+
+func (self *readOnlyEventer) AddAuthenticationFailedListener(handler func(Context, error)) func() {
+	return self.eventer.AddAuthenticationFailedListener(func(_ Context, err error) {
+		handler(self.ctx, err)
+	})
+}
+
+func (self *readOnlyEventer) AddConnectionIdleListener(handler func(Context, string, edge.Conn, time.Duration)) func() {
+	return self.eventer.AddConnectionIdleListener(func(_ Context, serviceName string, conn edge.Conn, idle time.Duration) {
+		handler(self.ctx, serviceName, conn, idle)
+	})
+}
+
+func (self *readOnlyEventer) AddListenerRebindListener(handler func(Context, string, string, string, time.Duration)) func() {
+	return self.eventer.AddListenerRebindListener(func(_ Context, serviceName string, lostRouter string, newRouter string, downtime time.Duration) {
+		handler(self.ctx, serviceName, lostRouter, newRouter, downtime)
+	})
+}
+
+func (self *readOnlyEventer) AddServicesUpdatedListener(handler func(Context, *ServiceDiff)) func() {
+	return self.eventer.AddServicesUpdatedListener(func(_ Context, diff *ServiceDiff) {
+		handler(self.ctx, diff)
+	})
+}
+
+func (self *readOnlyEventer) AddConnectionStateListener(handler func(Context, ConnectionState, ConnectionState)) func() {
+	return self.eventer.AddConnectionStateListener(func(_ Context, from ConnectionState, to ConnectionState) {
+		handler(self.ctx, from, to)
+	})
+}
+
+func (self *readOnlyEventer) AddControllerBreakerListener(handler func(Context, string, BreakerState)) func() {
+	return self.eventer.AddControllerBreakerListener(func(_ Context, host string, state BreakerState) {
+		handler(self.ctx, host, state)
+	})
+}
+
+func (self *readOnlyEventer) AddListener(events.EventName, ...events.Listener) {
+	self.ctx.denied("AddListener")
+}
+
+func (self *readOnlyEventer) EventNames() []events.EventName {
+	return self.eventer.EventNames()
+}
+
+func (self *readOnlyEventer) GetMaxListeners() int {
+	return self.eventer.GetMaxListeners()
+}
+
+func (self *readOnlyEventer) ListenerCount(eventName events.EventName) int {
+	return self.eventer.ListenerCount(eventName)
+}
+
+func (self *readOnlyEventer) Listeners(events.EventName) []events.Listener {
+	return nil
+}
+
+func (self *readOnlyEventer) On(events.EventName, ...events.Listener) {
+	self.ctx.denied("On")
+}
+
+func (self *readOnlyEventer) Once(events.EventName, ...events.Listener) {
+	self.ctx.denied("Once")
+}
+
+func (self *readOnlyEventer) RemoveAllListeners(events.EventName) bool {
+	self.ctx.denied("RemoveAllListeners")
+	return false
+}
+
+func (self *readOnlyEventer) RemoveListener(events.EventName, events.Listener) bool {
+	self.ctx.denied("RemoveListener")
+	return false
+}
@@ -0,0 +1,68 @@
+package ziti
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_ReadOnly(t *testing.T) {
+	req := require.New(t)
+
+	ztx := newTestContext("1", newTestService("svc", rest_model.DialBindDial, rest_model.DialBindBind))
+	readOnly := ReadOnly(ztx)
+	req.Same(readOnly, ReadOnly(readOnly))
+
+	req.Equal("1", readOnly.GetId())
+	_, found := readOnly.GetService("svc")
+	req.True(found)
+
+	_, err := readOnly.Dial("svc")
+	req.NoError(err)
+	req.Equal([]string{"svc"}, ztx.dials)
+
+	_, err = readOnly.Listen("svc")
+	req.ErrorIs(err, ErrReadOnly)
+	_, err = readOnly.ListenWithOptions("svc", DefaultListenOptions())
+	req.ErrorIs(err, ErrReadOnly)
+	req.Empty(ztx.binds)
+
+	readOnly.Close()
+	req.ErrorIs(readOnly.CloseWithContext(context.Background()), ErrReadOnly)
+	req.False(ztx.closed.Load())
+
+	req.ErrorIs(readOnly.Authenticate(), ErrReadOnly)
+	req.ErrorIs(readOnly.UpdatePassword("old", "new"), ErrReadOnly)
+	_, _, err = readOnly.RotateCertAuthenticator()
+	req.ErrorIs(err, ErrReadOnly)
+	req.Nil(readOnly.GetCredentials())
+
+	readOnly.SetId("2")
+	req.Equal("1", ztx.GetId())
+
+	var eventCtx Context
+	var eventSession edge_apis.ApiSession = &edge_apis.ApiSessionLegacy{}
+	readOnly.Events().AddAuthenticationStateFullListener(func(ctx Context, apiSession edge_apis.ApiSession) {
+		eventCtx = ctx
+		eventSession = apiSession
+	})
+	ztx.eventer.emitter.Emit(EventAuthenticationStateFull, edge_apis.ApiSession(&edge_apis.ApiSessionLegacy{}))
+	req.Same(readOnly, eventCtx)
+	req.Nil(eventSession)
+
+	subscription := readOnly.EventBus().Subscribe(nil)
+	defer subscription.Close()
+	token := "session-token"
+	ztx.bus.publish(AuthenticatedEvent{ApiSession: &edge_apis.ApiSessionLegacy{}})
+	session := &rest_model.SessionDetail{Token: &token}
+	ztx.bus.publish(SessionCreatedEvent{Session: session})
+	ztx.bus.publish(RouterConnectedEvent{RouterName: "er1"})
+
+	req.Equal(AuthenticatedEvent{}, <-subscription.C())
+	created := (<-subscription.C()).(SessionCreatedEvent)
+	req.Nil(created.Session.Token)
+	req.Same(&token, session.Token, "the published session is not modified")
+	req.Equal(RouterConnectedEvent{RouterName: "er1"}, <-subscription.C())
+}
@@ -0,0 +1,121 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"time"
+
+	"github.com/openziti/foundation/v2/stringz"
+)
+
+// DefaultMaxRebindInterval caps the re-bind delay of listeners with a ListenOptions.RebindInterval but no
+// ListenOptions.MaxRebindInterval.
+const DefaultMaxRebindInterval = time.Minute
+
+// terminatorLost records that the listener lost its terminator on the named router and, if re-binding is backed off,
+// schedules the first re-bind.
+func (mgr *listenerManager) terminatorLost(router string) {
+	mgr.context.log().WithField("serviceName", stringz.OrEmpty(mgr.service.Name)).
+		WithField("router", router).
+		Warn("lost listener terminator, re-binding")
+
+	if mgr.lostRouter == "" {
+		mgr.lostRouter = router
+		mgr.lostAt = time.Now()
+	}
+	mgr.scheduleRebind()
+}
+
+// scheduleRebind delays the next re-bind, doubling the delay with each attempt up to the maximum re-bind interval.
+func (mgr *listenerManager) scheduleRebind() {
+	if mgr.options.RebindInterval <= 0 {
+		return
+	}
+
+	maxInterval := mgr.options.MaxRebindInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultMaxRebindInterval
+	}
+
+	delay := mgr.options.RebindInterval
+	for i := 0; i < mgr.rebindAttempts && delay < maxInterval; i++ {
+		delay *= 2
+	}
+	if delay > maxInterval {
+		delay = maxInterval
+	}
+
+	mgr.rebindAttempts++
+	mgr.nextRebind = time.Now().Add(delay)
+}
+
+// terminatorEstablished emits EventListenerRebind if the terminator established on the named router replaces a lost
+// one, and resets the re-bind backoff.
+func (mgr *listenerManager) terminatorEstablished(router string) {
+	mgr.rebindAttempts = 0
+	mgr.nextRebind = time.Time{}
+
+	if mgr.lostRouter == "" {
+		return
+	}
+
+	lostRouter := mgr.lostRouter
+	downtime := time.Since(mgr.lostAt)
+	mgr.lostRouter = ""
+	mgr.lostAt = time.Time{}
+
+	serviceName := stringz.OrEmpty(mgr.service.Name)
+	mgr.context.log().WithField("serviceName", serviceName).
+		WithField("lostRouter", lostRouter).
+		WithField("router", router).
+		Infof("listener terminator re-established after %v", downtime)
+
+	mgr.notify(ListenerRebound)
+	mgr.context.Emit(EventListenerRebind, serviceName, lostRouter, router, downtime)
+}
+
+func (context *ContextImpl) AddListenerRebindListener(handler func(ztx Context, serviceName string, lostRouter string, newRouter string, downtime time.Duration)) func() {
+	listener := func(args ...interface{}) {
+		serviceName, ok := args[0].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", serviceName, args[0])
+		}
+
+		lostRouter, ok := args[1].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[1] to %T was %T", lostRouter, args[1])
+		}
+
+		newRouter, ok := args[2].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[2] to %T was %T", newRouter, args[2])
+		}
+
+		downtime, ok := args[3].(time.Duration)
+		if !ok {
+			context.log().Fatalf("could not convert args[3] to %T was %T", downtime, args[3])
+		}
+
+		handler(context, serviceName, lostRouter, newRouter, downtime)
+	}
+
+	context.AddListener(EventListenerRebind, listener)
+
+	return func() {
+		context.RemoveListener(EventListenerRebind, listener)
+	}
+}
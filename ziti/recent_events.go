@@ -0,0 +1,156 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+)
+
+// DefaultRecentEventsSize is the number of entries retained by Context.RecentEvents when Options.RecentEventsSize is
+// not set.
+const DefaultRecentEventsSize = 64
+
+const (
+	// RecentApiSessionRefreshFailed is recorded in RecentEvents when a background API Session refresh fails.
+	RecentApiSessionRefreshFailed = events.EventName("api-session-refresh-failed")
+
+	// RecentServiceRefreshFailed is recorded in RecentEvents when a background service refresh fails.
+	RecentServiceRefreshFailed = events.EventName("service-refresh-failed")
+)
+
+// RecentEvent is an entry in the history returned by Context.RecentEvents. Name is either one of the event names
+// emitted by the Context (e.g. EventServiceAdded) or one of the Recent* names for errors that are not otherwise emitted.
+type RecentEvent struct {
+	Time time.Time
+	Name events.EventName
+
+	// Detail is a short human-readable description of the event arguments, e.g. the affected service name.
+	Detail string
+
+	// Err is set if the event carried or represents an error.
+	Err error
+}
+
+func (e RecentEvent) String() string {
+	result := e.Time.Format(time.RFC3339Nano) + " " + string(e.Name)
+	if e.Detail != "" {
+		result += " " + e.Detail
+	}
+	if e.Err != nil {
+		result += " error: " + e.Err.Error()
+	}
+	return result
+}
+
+// recentEventRing is a fixed size ring buffer of RecentEvent entries. A nil ring discards all entries.
+type recentEventRing struct {
+	lock    sync.Mutex
+	entries []RecentEvent
+	next    int
+	full    bool
+}
+
+func newRecentEventRing(size int) *recentEventRing {
+	if size == 0 {
+		size = DefaultRecentEventsSize
+	}
+	if size < 0 {
+		return nil
+	}
+	return &recentEventRing{
+		entries: make([]RecentEvent, size),
+	}
+}
+
+func (self *recentEventRing) add(event RecentEvent) {
+	if self == nil {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.entries[self.next] = event
+	self.next = (self.next + 1) % len(self.entries)
+	if self.next == 0 {
+		self.full = true
+	}
+}
+
+// snapshot returns a copy of the retained entries, oldest first.
+func (self *recentEventRing) snapshot() []RecentEvent {
+	if self == nil {
+		return nil
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.full {
+		return append([]RecentEvent(nil), self.entries[:self.next]...)
+	}
+
+	result := make([]RecentEvent, 0, len(self.entries))
+	result = append(result, self.entries[self.next:]...)
+	return append(result, self.entries[:self.next]...)
+}
+
+// newRecentEvent summarizes the arguments of an emitted event into a RecentEvent.
+func newRecentEvent(name events.EventName, args ...interface{}) RecentEvent {
+	result := RecentEvent{
+		Time: time.Now(),
+		Name: name,
+	}
+
+	var details []string
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case error:
+			result.Err = v
+		case string:
+			details = append(details, v)
+		case *rest_model.ServiceDetail:
+			details = append(details, stringz.OrEmpty(v.Name))
+		case *rest_model.AuthQueryDetail:
+			if v.Provider != nil {
+				details = append(details, string(*v.Provider))
+			}
+		case apis.ApiSession:
+			if v != nil {
+				details = append(details, v.GetId())
+			}
+		case []*url.URL:
+			for _, u := range v {
+				details = append(details, u.String())
+			}
+		case fmt.Stringer:
+			details = append(details, v.String())
+		}
+	}
+	result.Detail = strings.Join(details, " ")
+
+	return result
+}
@@ -0,0 +1,50 @@
+package ziti
+
+import (
+	"errors"
+	"github.com/kataras/go-events"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_recentEventRing(t *testing.T) {
+	req := require.New(t)
+
+	ring := newRecentEventRing(3)
+	req.Empty(ring.snapshot())
+
+	for _, name := range []string{"a", "b"} {
+		ring.add(newRecentEvent(events.EventName(name)))
+	}
+	req.Equal([]events.EventName{"a", "b"}, recentEventNames(ring.snapshot()))
+
+	for _, name := range []string{"c", "d", "e"} {
+		ring.add(newRecentEvent(events.EventName(name)))
+	}
+	req.Equal([]events.EventName{"c", "d", "e"}, recentEventNames(ring.snapshot()))
+
+	disabled := newRecentEventRing(-1)
+	disabled.add(newRecentEvent("a"))
+	req.Nil(disabled.snapshot())
+}
+
+func Test_newRecentEvent(t *testing.T) {
+	req := require.New(t)
+
+	err := errors.New("boom")
+	event := newRecentEvent(EventRouterConnected, "router1", "tls:localhost:3022", err)
+	req.Equal(EventRouterConnected, event.Name)
+	req.Equal("router1 tls:localhost:3022", event.Detail)
+	req.Equal(err, event.Err)
+
+	event = newRecentEvent(EventServiceAdded, newTestService("svc"))
+	req.Equal("svc", event.Detail)
+}
+
+func recentEventNames(recent []RecentEvent) []events.EventName {
+	var result []events.EventName
+	for _, event := range recent {
+		result = append(result, event.Name)
+	}
+	return result
+}
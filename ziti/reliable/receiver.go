@@ -0,0 +1,183 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package reliable
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/pkg/errors"
+)
+
+// Message is a message delivered by a Receiver.
+type Message struct {
+	// StreamId identifies the Sender of the message.
+	StreamId string
+
+	// Seq is the sequence number of the message, starting at 1 for the first message of a Sender.
+	Seq uint64
+
+	Payload []byte
+}
+
+// Handler processes a delivered message. The message is acknowledged once the handler returns nil. If it returns an
+// error, the connection is closed and the Sender will deliver the message again after reconnecting.
+type Handler func(msg *Message) error
+
+// Receiver accepts connections from Senders, delivering their messages to a Handler in order and once each, even when
+// they are replayed after a reconnect. Messages of different senders may be delivered concurrently.
+type Receiver struct {
+	handler        Handler
+	maxMessageSize int
+
+	lock    sync.Mutex
+	streams map[string]*stream
+}
+
+type stream struct {
+	sync.Mutex
+	delivered uint64
+}
+
+// NewReceiver returns a Receiver delivering messages to handler.
+func NewReceiver(handler Handler, options *Options) *Receiver {
+	return &Receiver{
+		handler:        handler,
+		maxMessageSize: options.getMaxMessageSize(),
+		streams:        map[string]*stream{},
+	}
+}
+
+// Serve handles the connections accepted from listener until accepting fails, returning that error.
+func (self *Receiver) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := self.HandleConn(conn); err != nil {
+				pfxlog.Logger().WithError(err).Debug("reliable sender connection closed")
+			}
+		}()
+	}
+}
+
+// HandleConn receives messages from a single Sender connection until it fails, then closes it.
+func (self *Receiver) HandleConn(conn net.Conn) error {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	s, streamId, err := self.handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := readFrameOfType(conn, frameData, self.maxMessageSize)
+		if err != nil {
+			return err
+		}
+
+		delivered, err := self.deliver(s, streamId, msg)
+		if err != nil {
+			return err
+		}
+
+		if err = writeFrame(conn, frameAck, delivered, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// Forget discards the delivery state of the stream, after which its messages are no longer recognized as duplicates.
+// It may be used to release the state of senders that are known to be gone.
+func (self *Receiver) Forget(streamId string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.streams, streamId)
+}
+
+func (self *Receiver) handshake(conn net.Conn) (*stream, string, error) {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, "", err
+	}
+
+	hello, err := readFrameOfType(conn, frameHello, maxStreamIdSize)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "handshake failed")
+	}
+
+	streamId := string(hello.payload)
+	if streamId == "" {
+		return nil, "", errors.New("handshake failed, no stream id given")
+	}
+
+	s := self.getStream(streamId)
+
+	// messages the sender has had acknowledged were delivered, possibly before this receiver was started
+	s.Lock()
+	if s.delivered < hello.seq {
+		s.delivered = hello.seq
+	}
+	delivered := s.delivered
+	s.Unlock()
+
+	if err = writeFrame(conn, frameHello, delivered, nil); err != nil {
+		return nil, "", err
+	}
+
+	return s, streamId, conn.SetDeadline(time.Time{})
+}
+
+func (self *Receiver) getStream(streamId string) *stream {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	s, found := self.streams[streamId]
+	if !found {
+		s = &stream{}
+		self.streams[streamId] = s
+	}
+	return s
+}
+
+// deliver passes the message to the handler, unless it was delivered already, returning the sequence number of the
+// last delivered message of the stream.
+func (self *Receiver) deliver(s *stream, streamId string, msg *frame) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if msg.seq <= s.delivered {
+		return s.delivered, nil
+	}
+
+	if msg.seq != s.delivered+1 {
+		return 0, errors.Errorf("stream %s skipped from message %d to %d", streamId, s.delivered, msg.seq)
+	}
+
+	if err := self.handler(&Message{StreamId: streamId, Seq: msg.seq, Payload: msg.payload}); err != nil {
+		return 0, errors.Wrapf(err, "handler failed for message %d of stream %s", msg.seq, streamId)
+	}
+
+	s.delivered = msg.seq
+	return s.delivered, nil
+}
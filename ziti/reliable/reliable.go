@@ -0,0 +1,162 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package reliable provides ordered, at-least-once delivery of messages over connections that may fail, such as edge
+// connections whose router goes away. A Sender numbers each message and keeps it until the Receiver acknowledges it,
+// replaying unacknowledged messages in order after reconnecting. A Receiver delivers the messages of each sender in
+// order and drops the duplicates replays produce, so a handler only sees a message again if the Receiver itself was
+// restarted before acknowledging it.
+package reliable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxUnacked           = 1024
+	DefaultMaxMessageSize       = 1 << 20
+	DefaultReconnectInterval    = 250 * time.Millisecond
+	DefaultMaxReconnectInterval = 30 * time.Second
+
+	// handshakeTimeout bounds the exchange of hello frames on a new connection.
+	handshakeTimeout = 10 * time.Second
+
+	frameHeaderSize = 13
+	maxStreamIdSize = 256
+)
+
+const (
+	frameHello byte = 1
+	frameData  byte = 2
+	frameAck   byte = 3
+)
+
+var (
+	// ErrClosed is returned by operations on a closed Sender.
+	ErrClosed = errors.New("reliable sender closed")
+
+	// ErrMessageTooLarge is returned when sending a message larger than Options.MaxMessageSize.
+	ErrMessageTooLarge = errors.New("message exceeds maximum message size")
+)
+
+// Options configures a Sender or Receiver. Only MaxMessageSize applies to a Receiver, and it should match the
+// senders'.
+type Options struct {
+	// StreamId identifies the sender to the Receiver across reconnects, so that replayed messages can be recognized.
+	// It must be unique among the senders of a Receiver. A random id is used if empty. Reusing the id of a Sender that
+	// was restarted makes the Receiver drop messages it has already delivered for that stream id, so it is only safe if
+	// sequence numbers are persisted along with it, which Sender does not do.
+	StreamId string
+
+	// MaxUnacked is the number of messages a Sender holds until they are acknowledged. Send blocks when it is reached.
+	// Defaults to DefaultMaxUnacked.
+	MaxUnacked int
+
+	// MaxMessageSize is the size of the largest message that may be sent or received. Defaults to
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
+
+	// ReconnectInterval is the delay before the first reconnect attempt of a Sender, growing exponentially up to
+	// MaxReconnectInterval. Defaults to DefaultReconnectInterval and DefaultMaxReconnectInterval.
+	ReconnectInterval    time.Duration
+	MaxReconnectInterval time.Duration
+}
+
+func (self *Options) getMaxUnacked() int {
+	if self == nil || self.MaxUnacked <= 0 {
+		return DefaultMaxUnacked
+	}
+	return self.MaxUnacked
+}
+
+func (self *Options) getMaxMessageSize() int {
+	if self == nil || self.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return self.MaxMessageSize
+}
+
+func (self *Options) getReconnectIntervals() (time.Duration, time.Duration) {
+	initial, max := DefaultReconnectInterval, DefaultMaxReconnectInterval
+	if self != nil && self.ReconnectInterval > 0 {
+		initial = self.ReconnectInterval
+	}
+	if self != nil && self.MaxReconnectInterval > 0 {
+		max = self.MaxReconnectInterval
+	}
+	return initial, max
+}
+
+// Frames are a type byte, a big-endian sequence number and a big-endian payload length, followed by the payload.
+// Hello frames carry the stream id and the highest acknowledged sequence number from the sender, and the highest
+// delivered sequence number in the reply. Ack frames acknowledge all messages up to the sequence number.
+
+type frame struct {
+	frameType byte
+	seq       uint64
+	payload   []byte
+}
+
+func writeFrame(w io.Writer, frameType byte, seq uint64, payload []byte) error {
+	buf := make([]byte, frameHeaderSize+len(payload))
+	buf[0] = frameType
+	binary.BigEndian.PutUint64(buf[1:], seq)
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(payload)))
+	copy(buf[frameHeaderSize:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader, maxPayloadSize int) (*frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	result := &frame{
+		frameType: header[0],
+		seq:       binary.BigEndian.Uint64(header[1:]),
+	}
+
+	size := binary.BigEndian.Uint32(header[9:])
+	if int64(size) > int64(maxPayloadSize) {
+		return nil, fmt.Errorf("%w (%d > %d)", ErrMessageTooLarge, size, maxPayloadSize)
+	}
+
+	if size > 0 {
+		result.payload = make([]byte, size)
+		if _, err := io.ReadFull(r, result.payload); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func readFrameOfType(r io.Reader, frameType byte, maxPayloadSize int) (*frame, error) {
+	result, err := readFrame(r, maxPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+	if result.frameType != frameType {
+		return nil, errors.Errorf("unexpected frame type %d, expected %d", result.frameType, frameType)
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package reliable
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeDialer connects each dial to one of the receivers returned by next.
+func pipeDialer(next func() *Receiver) ziti.ConnDialer {
+	return func(context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			_ = next().HandleConn(server)
+		}()
+		return client, nil
+	}
+}
+
+type collector struct {
+	sync.Mutex
+	seqs     []uint64
+	failOnce map[uint64]bool
+}
+
+func (self *collector) handle(msg *Message) error {
+	self.Lock()
+	defer self.Unlock()
+	if self.failOnce[msg.Seq] {
+		delete(self.failOnce, msg.Seq)
+		return errors.New("handler failure")
+	}
+	self.seqs = append(self.seqs, msg.Seq)
+	return nil
+}
+
+func (self *collector) received() []uint64 {
+	self.Lock()
+	defer self.Unlock()
+	return append([]uint64(nil), self.seqs...)
+}
+
+func sequence(n int) []uint64 {
+	var result []uint64
+	for i := 1; i <= n; i++ {
+		result = append(result, uint64(i))
+	}
+	return result
+}
+
+func TestSenderReplaysAfterFailure(t *testing.T) {
+	req := require.New(t)
+
+	c := &collector{failOnce: map[uint64]bool{10: true, 55: true}}
+	receiver := NewReceiver(c.handle, nil)
+
+	sender := NewSender(pipeDialer(func() *Receiver { return receiver }), &Options{
+		MaxUnacked:        16,
+		ReconnectInterval: time.Millisecond,
+	})
+	defer func() { _ = sender.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i := 1; i <= 100; i++ {
+		seq, err := sender.Send(ctx, []byte{byte(i)})
+		req.NoError(err)
+		req.Equal(uint64(i), seq)
+	}
+
+	req.NoError(sender.Flush(ctx))
+	req.Equal(0, sender.Pending())
+	req.Equal(sequence(100), c.received())
+}
+
+func TestSenderResumesWithNewReceiver(t *testing.T) {
+	req := require.New(t)
+
+	first := &collector{failOnce: map[uint64]bool{6: true}}
+	second := &collector{}
+
+	var lock sync.Mutex
+	receiver := NewReceiver(first.handle, nil)
+	next := func() *Receiver {
+		lock.Lock()
+		defer lock.Unlock()
+		current := receiver
+		// the receiver is restarted after the first connection fails
+		receiver = NewReceiver(second.handle, nil)
+		return current
+	}
+
+	sender := NewSender(pipeDialer(next), &Options{ReconnectInterval: time.Millisecond})
+	defer func() { _ = sender.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i := 1; i <= 5; i++ {
+		_, err := sender.Send(ctx, []byte{byte(i)})
+		req.NoError(err)
+	}
+	req.NoError(sender.Flush(ctx))
+
+	for i := 6; i <= 10; i++ {
+		_, err := sender.Send(ctx, []byte{byte(i)})
+		req.NoError(err)
+	}
+	req.NoError(sender.Flush(ctx))
+
+	req.Equal(sequence(5), first.received())
+	req.Equal([]uint64{6, 7, 8, 9, 10}, second.received())
+}
+
+func TestSenderLimits(t *testing.T) {
+	req := require.New(t)
+
+	sender := NewSender(func(context.Context) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	}, &Options{MaxUnacked: 2, MaxMessageSize: 4})
+
+	_, err := sender.Send(context.Background(), []byte("too large"))
+	req.ErrorIs(err, ErrMessageTooLarge)
+
+	for i := 0; i < 2; i++ {
+		_, err = sender.Send(context.Background(), []byte("ok"))
+		req.NoError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = sender.Send(ctx, []byte("ok"))
+	req.ErrorIs(err, context.DeadlineExceeded)
+	req.Equal(2, sender.Pending())
+
+	req.NoError(sender.Close())
+	_, err = sender.Send(context.Background(), []byte("ok"))
+	req.ErrorIs(err, ErrClosed)
+	req.ErrorIs(sender.Flush(context.Background()), ErrClosed)
+}
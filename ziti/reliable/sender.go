@@ -0,0 +1,317 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package reliable
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// Sender sends messages to a Receiver, reconnecting with its dialer whenever the connection fails and replaying the
+// messages that were not yet acknowledged. Messages are held in memory only, so messages not acknowledged when the
+// Sender is closed, or its process exits, are lost. Use Flush before closing to wait for them.
+type Sender struct {
+	dial           ziti.ConnDialer
+	streamId       string
+	maxMessageSize int
+	options        *Options
+
+	slots       chan struct{}
+	notify      chan struct{}
+	closeNotify chan struct{}
+	closed      atomic.Bool
+
+	lock       sync.Mutex
+	unacked    []*frame
+	seq        uint64
+	acked      uint64
+	ackChanged chan struct{}
+	conn       net.Conn
+}
+
+// NewSender returns a Sender that connects to the Receiver using dial. Connecting happens in the background, so
+// messages may be sent right away.
+func NewSender(dial ziti.ConnDialer, options *Options) *Sender {
+	result := &Sender{
+		dial:           dial,
+		maxMessageSize: options.getMaxMessageSize(),
+		options:        options,
+		slots:          make(chan struct{}, options.getMaxUnacked()),
+		notify:         make(chan struct{}, 1),
+		closeNotify:    make(chan struct{}),
+		ackChanged:     make(chan struct{}),
+	}
+
+	if options != nil && options.StreamId != "" {
+		result.streamId = options.StreamId
+	} else {
+		result.streamId = uuid.NewString()
+	}
+
+	go result.run()
+	return result
+}
+
+// StreamId returns the id identifying this Sender to the Receiver.
+func (self *Sender) StreamId() string {
+	return self.streamId
+}
+
+// Send queues a copy of payload for delivery and returns its sequence number. It returns once the message is queued,
+// not when it is delivered, blocking only while Options.MaxUnacked messages are awaiting acknowledgement.
+func (self *Sender) Send(ctx context.Context, payload []byte) (uint64, error) {
+	if len(payload) > self.maxMessageSize {
+		return 0, errors.Wrapf(ErrMessageTooLarge, "%d > %d", len(payload), self.maxMessageSize)
+	}
+
+	if self.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	select {
+	case self.slots <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-self.closeNotify:
+		return 0, ErrClosed
+	}
+
+	msg := &frame{
+		frameType: frameData,
+		payload:   append([]byte(nil), payload...),
+	}
+
+	self.lock.Lock()
+	self.seq++
+	msg.seq = self.seq
+	self.unacked = append(self.unacked, msg)
+	self.lock.Unlock()
+
+	select {
+	case self.notify <- struct{}{}:
+	default:
+	}
+
+	return msg.seq, nil
+}
+
+// Pending returns the number of messages awaiting acknowledgement.
+func (self *Sender) Pending() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.unacked)
+}
+
+// Flush waits until all messages sent so far have been acknowledged.
+func (self *Sender) Flush(ctx context.Context) error {
+	for {
+		self.lock.Lock()
+		pending := len(self.unacked)
+		ackChanged := self.ackChanged
+		self.lock.Unlock()
+
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ackChanged:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-self.closeNotify:
+			return ErrClosed
+		}
+	}
+}
+
+// Close stops the Sender and closes its connection. Messages not yet acknowledged are discarded.
+func (self *Sender) Close() error {
+	if !self.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(self.closeNotify)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.conn != nil {
+		return self.conn.Close()
+	}
+	return nil
+}
+
+func (self *Sender) run() {
+	log := pfxlog.Logger().WithField("streamId", self.streamId)
+
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.InitialInterval, expBackoff.MaxInterval = self.options.getReconnectIntervals()
+	expBackoff.MaxElapsedTime = 0
+
+	for !self.closed.Load() {
+		conn, err := self.connect()
+		if err != nil {
+			log.WithError(err).Debug("unable to connect to receiver")
+			select {
+			case <-time.After(expBackoff.NextBackOff()):
+			case <-self.closeNotify:
+			}
+			continue
+		}
+		expBackoff.Reset()
+
+		if err = self.serve(conn); err != nil && !self.closed.Load() {
+			log.WithError(err).Info("connection to receiver failed, reconnecting")
+		}
+		_ = conn.Close()
+	}
+}
+
+func (self *Sender) connect() (net.Conn, error) {
+	conn, err := self.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	self.lock.Lock()
+	if self.closed.Load() {
+		self.lock.Unlock()
+		_ = conn.Close()
+		return nil, ErrClosed
+	}
+	self.conn = conn
+	acked := self.acked
+	self.lock.Unlock()
+
+	if err = self.handshake(conn, acked); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (self *Sender) handshake(conn net.Conn, acked uint64) error {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+
+	if err := writeFrame(conn, frameHello, acked, []byte(self.streamId)); err != nil {
+		return err
+	}
+
+	reply, err := readFrameOfType(conn, frameHello, 0)
+	if err != nil {
+		return errors.Wrap(err, "handshake failed")
+	}
+	self.ack(reply.seq)
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// serve writes unacknowledged messages to conn, starting with those sent before the connection was established, until
+// the connection fails.
+func (self *Sender) serve(conn net.Conn) error {
+	errC := make(chan error, 1)
+	go func() {
+		err := self.readAcks(conn)
+		_ = conn.Close()
+		errC <- err
+	}()
+
+	written := self.ackedSeq()
+	for {
+		pending := self.pendingAfter(written)
+		for _, msg := range pending {
+			if err := writeFrame(conn, frameData, msg.seq, msg.payload); err != nil {
+				return err
+			}
+			written = msg.seq
+		}
+
+		if len(pending) > 0 {
+			continue
+		}
+
+		select {
+		case <-self.notify:
+		case err := <-errC:
+			return err
+		case <-self.closeNotify:
+			return ErrClosed
+		}
+	}
+}
+
+func (self *Sender) readAcks(conn net.Conn) error {
+	for {
+		ack, err := readFrameOfType(conn, frameAck, 0)
+		if err != nil {
+			return err
+		}
+		self.ack(ack.seq)
+	}
+}
+
+// ack releases the messages up to and including seq.
+func (self *Sender) ack(seq uint64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if seq <= self.acked {
+		return
+	}
+	self.acked = seq
+
+	released := 0
+	for released < len(self.unacked) && self.unacked[released].seq <= seq {
+		released++
+	}
+	self.unacked = self.unacked[released:]
+
+	for i := 0; i < released; i++ {
+		<-self.slots
+	}
+
+	close(self.ackChanged)
+	self.ackChanged = make(chan struct{})
+}
+
+func (self *Sender) ackedSeq() uint64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.acked
+}
+
+func (self *Sender) pendingAfter(seq uint64) []*frame {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var result []*frame
+	for _, msg := range self.unacked {
+		if msg.seq > seq {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
@@ -0,0 +1,244 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// DefaultVirtualCIDR is the range virtual IPs are assigned from for intercepted host names, the same range tunnelers
+// use by default.
+const DefaultVirtualCIDR = "100.64.0.0/10"
+
+// ResolverOptions configures a Resolver.
+type ResolverOptions struct {
+	// VirtualCIDR is the IPv4 range intercepted host names are assigned virtual IPs from. Defaults to
+	// DefaultVirtualCIDR.
+	VirtualCIDR string
+
+	// Fallback resolves host names no service intercepts. If nil, they are not found.
+	Fallback *net.Resolver
+}
+
+// Resolver resolves the host names and addresses in the intercept.v1 and ziti-tunneler-client.v1 configs of the
+// services of a Context, so that applications can address services by their intercepted names. Lookups of
+// intercepted host names return a virtual IP, which stays assigned to the name for the lifetime of the Resolver,
+// and which LookupService and DialContext map back to the name. Intercepted IPs and CIDRs resolve to themselves.
+//
+// Resolver has the lookup methods of net.Resolver, and DialContext can replace net.Dialer.DialContext, e.g. in a
+// http.Transport, to dial the addresses it resolved.
+type Resolver struct {
+	ztx      Context
+	fallback *net.Resolver
+
+	network *net.IPNet
+	lock    sync.Mutex
+	next    uint32
+	byName  map[string]net.IP
+	byIp    map[string]string
+}
+
+// NewResolver returns a Resolver for the services of ztx. If options is nil, the defaults are used.
+func NewResolver(ztx Context, options *ResolverOptions) (*Resolver, error) {
+	cidr := DefaultVirtualCIDR
+	var fallback *net.Resolver
+	if options != nil {
+		if options.VirtualCIDR != "" {
+			cidr = options.VirtualCIDR
+		}
+		fallback = options.Fallback
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid virtual cidr '%s'", cidr)
+	}
+	if network.IP.To4() == nil {
+		return nil, errors.Errorf("virtual cidr '%s' is not an IPv4 range", cidr)
+	}
+
+	return &Resolver{
+		ztx:      ztx,
+		fallback: fallback,
+		network:  network,
+		next:     1,
+		byName:   map[string]net.IP{},
+		byIp:     map[string]string{},
+	}, nil
+}
+
+// LookupService returns the service intercepting the host name or IP and port, trying tcp intercepts before udp.
+// Virtual IPs assigned by the Resolver are looked up by the host name they were assigned to.
+func (self *Resolver) LookupService(hostname string, port uint16) (*rest_model.ServiceDetail, error) {
+	hostname = self.hostFor(hostname)
+
+	service, _, err := self.ztx.GetServiceForAddr("tcp", hostname, port)
+	if err == nil {
+		return service, nil
+	}
+	if service, _, udpErr := self.ztx.GetServiceForAddr("udp", hostname, port); udpErr == nil {
+		return service, nil
+	}
+	return nil, err
+}
+
+// LookupHost returns the virtual IP of an intercepted host name, or the addresses of the fallback resolver.
+func (self *Resolver) LookupHost(ctx gocontext.Context, host string) ([]string, error) {
+	ips, err := self.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	return result, nil
+}
+
+// LookupIPAddr returns the virtual IP of an intercepted host name, or the addresses of the fallback resolver.
+func (self *Resolver) LookupIPAddr(ctx gocontext.Context, host string) ([]net.IPAddr, error) {
+	ips, err := self.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, net.IPAddr{IP: ip})
+	}
+	return result, nil
+}
+
+// LookupIP returns the virtual IP of an intercepted host name, or the addresses of the fallback resolver. network
+// is "ip", "ip4" or "ip6". Virtual IPs are IPv4 addresses, so intercepted host names are not found for "ip6".
+func (self *Resolver) LookupIP(ctx gocontext.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if network != "ip6" && self.isIntercepted(name) {
+		ip, err := self.assign(name)
+		if err != nil {
+			return nil, err
+		}
+		return []net.IP{ip}, nil
+	}
+
+	if self.fallback != nil {
+		return self.fallback.LookupIP(ctx, network, host)
+	}
+	return nil, &net.DNSError{Err: "no service intercepts host", Name: host, IsNotFound: true}
+}
+
+// DialContext dials the service intercepting addr, a host name or IP and port, which may be a virtual IP the
+// Resolver assigned.
+func (self *Resolver) DialContext(ctx gocontext.Context, network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	host = self.hostFor(host)
+	network = normalizeProtocol(network)
+	service, _, err := self.ztx.GetServiceForAddr(network, host, uint16(port))
+	if err != nil {
+		return nil, err
+	}
+	return self.ztx.DialWithContext(ctx, *service.Name, WithAppData(interceptAppData(network, host, uint16(port))))
+}
+
+// hostFor returns the host name a virtual IP was assigned to, or host itself.
+func (self *Resolver) hostFor(host string) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if name, found := self.byIp[host]; found {
+		return name
+	}
+	return host
+}
+
+func (self *Resolver) assign(name string) (net.IP, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if ip, found := self.byName[name]; found {
+		return ip, nil
+	}
+
+	ones, bits := self.network.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	// skip the network and broadcast addresses
+	if uint64(self.next) >= size-1 {
+		return nil, errors.Errorf("virtual cidr %s has no addresses left for %s", self.network.String(), name)
+	}
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(self.network.IP.To4())+self.next)
+	self.next++
+
+	self.byName[name] = ip
+	self.byIp[ip.String()] = name
+	return ip, nil
+}
+
+// isIntercepted returns true if any service intercepts the host name, on any protocol and port.
+func (self *Resolver) isIntercepted(hostname string) bool {
+	services, err := self.ztx.GetServices()
+	if err != nil {
+		return false
+	}
+
+	for i := range services {
+		intercept := getServiceIntercept(self.ztx, *services[i].Name)
+		if intercept == nil {
+			continue
+		}
+		for j := range intercept.Addresses {
+			if intercept.Addresses[j].Matches(hostname) != -1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getServiceIntercept returns the intercept.v1 config of the named service, or its ziti-tunneler-client.v1 config
+// converted to one, or nil if it has neither.
+func getServiceIntercept(ztx Context, serviceName string) *edge.InterceptV1Config {
+	if intercept, found, err := GetInterceptV1Config(ztx, serviceName); err == nil && found {
+		return intercept
+	}
+	if clientConfig, found, err := GetClientV1Config(ztx, serviceName); err == nil && found {
+		return clientConfig.ToInterceptV1Config()
+	}
+	return nil
+}
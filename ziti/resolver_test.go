@@ -0,0 +1,137 @@
+package ziti
+
+import (
+	gocontext "context"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// resolverTestContext has services with the given configs and dials every service by connecting to addr.
+type resolverTestContext struct {
+	proxyTestContext
+	services []rest_model.ServiceDetail
+}
+
+func (self *resolverTestContext) GetServices() ([]rest_model.ServiceDetail, error) {
+	return self.services, nil
+}
+
+func (self *resolverTestContext) GetServiceConfig(serviceName string, configType string, target interface{}) (bool, error) {
+	for i := range self.services {
+		if *self.services[i].Name == serviceName {
+			return edge.ParseServiceConfig(&self.services[i], configType, target)
+		}
+	}
+	return false, &ServiceNotFoundError{ServiceName: serviceName}
+}
+
+func (self *resolverTestContext) GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error) {
+	var result *rest_model.ServiceDetail
+	best := math.MaxInt
+	for i := range self.services {
+		if intercept := getServiceIntercept(self, *self.services[i].Name); intercept != nil {
+			if score := intercept.Match(network, hostname, port); score != -1 && score < best {
+				best = score
+				result = &self.services[i]
+			}
+		}
+	}
+	if result == nil {
+		return nil, -1, errors.Errorf("no service for address[%s:%s:%d]", network, hostname, port)
+	}
+	return result, best, nil
+}
+
+func Test_Resolver(t *testing.T) {
+	req := require.New(t)
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = backend.Close() }()
+
+	ztx := &resolverTestContext{
+		proxyTestContext: proxyTestContext{addr: backend.Addr().String()},
+		services: []rest_model.ServiceDetail{
+			{
+				Name: ToPtr("web"),
+				Config: map[string]map[string]interface{}{
+					InterceptV1: {
+						"protocols":  []string{"tcp"},
+						"addresses":  []string{"web.ziti", "*.apps.ziti"},
+						"portRanges": []map[string]interface{}{{"low": 443, "high": 443}},
+					},
+				},
+			},
+			{
+				Name: ToPtr("legacy"),
+				Config: map[string]map[string]interface{}{
+					ClientConfigV1: {"hostname": "legacy.ziti", "port": 8080},
+				},
+			},
+			{
+				Name: ToPtr("subnet"),
+				Config: map[string]map[string]interface{}{
+					InterceptV1: {
+						"protocols":  []string{"udp"},
+						"addresses":  []string{"10.10.0.0/16"},
+						"portRanges": []map[string]interface{}{{"low": 53, "high": 53}},
+					},
+				},
+			},
+		},
+	}
+
+	for i := range ztx.services {
+		ztx.services[i].ID = ztx.services[i].Name
+	}
+
+	resolver, err := NewResolver(ztx, &ResolverOptions{VirtualCIDR: "100.64.0.0/30"})
+	req.NoError(err)
+	ctx := gocontext.Background()
+
+	addrs, err := resolver.LookupHost(ctx, "web.ziti")
+	req.NoError(err)
+	req.Equal([]string{"100.64.0.1"}, addrs)
+
+	addrs, err = resolver.LookupHost(ctx, "WEB.ziti.")
+	req.NoError(err)
+	req.Equal([]string{"100.64.0.1"}, addrs)
+
+	ips, err := resolver.LookupIP(ctx, "ip4", "legacy.ziti")
+	req.NoError(err)
+	req.Equal("100.64.0.2", ips[0].String())
+
+	_, err = resolver.LookupHost(ctx, "one.apps.ziti")
+	req.Error(err, "the /30 only has two usable addresses")
+
+	_, err = resolver.LookupIPAddr(ctx, "unknown.example")
+	var dnsErr *net.DNSError
+	req.ErrorAs(err, &dnsErr)
+	req.True(dnsErr.IsNotFound)
+
+	service, err := resolver.LookupService("100.64.0.1", 443)
+	req.NoError(err)
+	req.Equal("web", *service.Name)
+
+	service, err = resolver.LookupService("legacy.ziti", 8080)
+	req.NoError(err)
+	req.Equal("legacy", *service.Name)
+
+	service, err = resolver.LookupService("10.10.1.1", 53)
+	req.NoError(err)
+	req.Equal("subnet", *service.Name)
+
+	_, err = resolver.LookupService("web.ziti", 80)
+	req.Error(err)
+
+	conn, err := resolver.DialContext(ctx, "tcp", "100.64.0.2:8080")
+	req.NoError(err)
+	_ = conn.Close()
+	req.Equal([]string{"legacy"}, ztx.dials)
+}
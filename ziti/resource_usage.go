@@ -0,0 +1,111 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"encoding/json"
+)
+
+// Rough per-item costs used to estimate the memory attributable to a Context. They are not measured at runtime.
+const (
+	// estimatedGoroutineBytes approximates the stack of a long-running goroutine.
+	estimatedGoroutineBytes = 8 * 1024
+
+	// estimatedRouterConnBytes approximates the read/write buffers and queues of an edge router channel.
+	estimatedRouterConnBytes = 32 * 1024
+
+	// estimatedConnBytes approximates the sequencer and buffers of a connection multiplexed over an edge router.
+	estimatedConnBytes = 4 * 1024
+
+	// channelGoroutinesPerRouterConn is the number of goroutines the channel library runs per edge router connection.
+	channelGoroutinesPerRouterConn = 2
+)
+
+// ResourceUsage is an approximation of the resources held by a Context, as returned by Context.ResourceUsage. It is
+// meant for comparing contexts with each other, e.g. to find the heaviest ones in a process running many, rather than
+// for exact accounting.
+type ResourceUsage struct {
+	// Goroutines is the number of long-running goroutines started by the Context, such as the background refresh,
+	// latency probes and listener managers, plus those run by the channel library for each edge router connection.
+	Goroutines int
+
+	EdgeRouterConnections int
+
+	// Connections is the number of dialed, accepted and hosting connections multiplexed over edge router connections.
+	Connections int
+
+	Services int
+	Sessions int
+
+	// ControllerStateBytes is the serialized size of the services and sessions cached from the controller.
+	ControllerStateBytes uint64
+
+	// EstimatedMemoryBytes adds estimates for goroutine stacks and connection buffers to ControllerStateBytes.
+	EstimatedMemoryBytes uint64
+}
+
+func (context *ContextImpl) ResourceUsage() ResourceUsage {
+	result := ResourceUsage{
+		Goroutines: int(context.goroutines.Load()),
+	}
+
+	for entry := range context.routerConnections.IterBuffered() {
+		if !entry.Val.IsClosed() {
+			result.EdgeRouterConnections++
+			result.Connections += entry.Val.GetActiveConnCount()
+		}
+	}
+	goroutinesPerRouterConn := channelGoroutinesPerRouterConn
+	if context.options != nil && context.options.Keepalive != nil {
+		goroutinesPerRouterConn++
+	}
+	result.Goroutines += result.EdgeRouterConnections * goroutinesPerRouterConn
+
+	for entry := range context.services.IterBuffered() {
+		result.Services++
+		result.ControllerStateBytes += serializedSize(entry.Val)
+	}
+
+	for entry := range context.sessions.IterBuffered() {
+		result.Sessions++
+		result.ControllerStateBytes += serializedSize(entry.Val)
+	}
+
+	result.EstimatedMemoryBytes = result.ControllerStateBytes +
+		uint64(result.Goroutines)*estimatedGoroutineBytes +
+		uint64(result.EdgeRouterConnections)*estimatedRouterConnBytes +
+		uint64(result.Connections)*estimatedConnBytes
+
+	return result
+}
+
+func serializedSize(v interface{}) uint64 {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return uint64(len(data))
+}
+
+// spawn runs f on a new goroutine that counts towards ResourceUsage until it returns.
+func (context *ContextImpl) spawn(f func()) {
+	context.goroutines.Add(1)
+	go func() {
+		defer context.goroutines.Add(-1)
+		f()
+	}()
+}
@@ -0,0 +1,140 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+)
+
+// RestrictedEgressPort is the only port contexts with Options.RestrictedEgress connect to.
+const RestrictedEgressPort = "443"
+
+// restrictedEgressRouterProtocols are the edge router transports usable on RestrictedEgressPort. Both are TLS, so
+// they pass firewalls that only allow HTTPS.
+var restrictedEgressRouterProtocols = []string{"tls", "wss"}
+
+// RestrictedEgressOptions returns DefaultOptions with RestrictedEgress set, for networks that only allow outbound
+// connections to port 443.
+func RestrictedEgressOptions() *Options {
+	options := *DefaultOptions
+	options.RestrictedEgress = true
+	return &options
+}
+
+// EgressCapabilityError is returned when a Context with Options.RestrictedEgress can't reach the network on port 443.
+// It reports the addresses that were advertised instead.
+type EgressCapabilityError struct {
+	// Controllers are the configured controller URLs that aren't HTTPS on port 443.
+	Controllers []string
+
+	// ServiceName is the service that was dialed or hosted, if the error concerns its edge routers.
+	ServiceName string
+
+	// EdgeRouters maps the names of the service's edge routers to the addresses they advertise, none of which are a
+	// TLS or WSS listener on port 443.
+	EdgeRouters map[string][]string
+}
+
+func (self *EgressCapabilityError) Error() string {
+	if len(self.Controllers) > 0 {
+		return fmt.Sprintf("restricted egress requires https controller urls on port %s, got [%s]",
+			RestrictedEgressPort, strings.Join(self.Controllers, ", "))
+	}
+
+	var routers []string
+	for name, addrs := range self.EdgeRouters {
+		routers = append(routers, fmt.Sprintf("%s=[%s]", name, strings.Join(addrs, ", ")))
+	}
+	sort.Strings(routers)
+
+	return fmt.Sprintf("restricted egress requires %s edge router listeners on port %s, but none of the edge routers "+
+		"for service '%s' advertise one: %s", strings.Join(restrictedEgressRouterProtocols, " or "),
+		RestrictedEgressPort, self.ServiceName, strings.Join(routers, "; "))
+}
+
+// isRestrictedEgressControllerUrl returns true if the controller url is HTTPS on RestrictedEgressPort.
+func isRestrictedEgressControllerUrl(controllerUrl string) bool {
+	u, err := url.Parse(controllerUrl)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	return u.Port() == "" || u.Port() == RestrictedEgressPort
+}
+
+// isRestrictedEgressRouterUrl returns true if the edge router address, e.g. tls:router.example.com:443, is a TLS or WSS
+// listener on RestrictedEgressPort.
+func isRestrictedEgressRouterUrl(addr string) bool {
+	addr = strings.Replace(addr, "://", ":", 1)
+	protocol, hostPort, found := strings.Cut(addr, ":")
+	if !found || !stringz.Contains(restrictedEgressRouterProtocols, protocol) {
+		return false
+	}
+
+	idx := strings.LastIndex(hostPort, ":")
+	return idx >= 0 && hostPort[idx+1:] == RestrictedEgressPort
+}
+
+// checkRestrictedEgressControllers fails if any of the controller urls can't be reached with restricted egress.
+func checkRestrictedEgressControllers(controllerUrls []string) error {
+	result := &EgressCapabilityError{}
+	for _, controllerUrl := range controllerUrls {
+		if !isRestrictedEgressControllerUrl(controllerUrl) {
+			result.Controllers = append(result.Controllers, controllerUrl)
+		}
+	}
+
+	if len(result.Controllers) > 0 {
+		return result
+	}
+	return nil
+}
+
+// checkRestrictedEgressRouters fails if restricted egress is enabled and none of the edge routers of the session
+// advertise a usable address. Sessions without edge routers pass, as they are refreshed before use.
+func (context *ContextImpl) checkRestrictedEgressRouters(session *rest_model.SessionDetail) error {
+	if !context.options.RestrictedEgress || len(session.EdgeRouters) == 0 {
+		return nil
+	}
+
+	result := &EgressCapabilityError{
+		EdgeRouters: map[string][]string{},
+	}
+	if session.Service != nil {
+		result.ServiceName = session.Service.Name
+	}
+
+	for _, edgeRouter := range session.EdgeRouters {
+		if urls, _ := context.options.selectEdgeRouterUrls(edgeRouter.SupportedProtocols); len(urls) > 0 {
+			return nil
+		}
+
+		var addrs []string
+		for _, addr := range edgeRouter.SupportedProtocols {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		result.EdgeRouters[stringz.OrEmpty(edgeRouter.Name)] = addrs
+	}
+
+	return result
+}
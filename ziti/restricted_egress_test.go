@@ -0,0 +1,72 @@
+package ziti
+
+import (
+	"errors"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/identity"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_isRestrictedEgressRouterUrl(t *testing.T) {
+	req := require.New(t)
+
+	req.True(isRestrictedEgressRouterUrl("tls:router.example.com:443"))
+	req.True(isRestrictedEgressRouterUrl("wss://router.example.com:443"))
+	req.True(isRestrictedEgressRouterUrl("tls:[::1]:443"))
+	req.False(isRestrictedEgressRouterUrl("tls:router.example.com:3022"))
+	req.False(isRestrictedEgressRouterUrl("ws:router.example.com:443"))
+	req.False(isRestrictedEgressRouterUrl("tls:router.example.com:4430"))
+
+	req.True(isRestrictedEgressControllerUrl("https://ctrl.example.com/edge/client/v1"))
+	req.True(isRestrictedEgressControllerUrl("https://ctrl.example.com:443/edge/client/v1"))
+	req.False(isRestrictedEgressControllerUrl("https://ctrl.example.com:1280/edge/client/v1"))
+	req.False(isRestrictedEgressControllerUrl("http://ctrl.example.com/edge/client/v1"))
+}
+
+func Test_NewContextWithOpts_restrictedEgress(t *testing.T) {
+	req := require.New(t)
+
+	cfg := NewConfig("https://ctrl.example.com:1280/edge/client/v1", identity.Config{Cert: "cert", Key: "key"})
+	_, err := NewContextWithOpts(cfg, RestrictedEgressOptions())
+
+	var capabilityErr *EgressCapabilityError
+	req.True(errors.As(err, &capabilityErr))
+	req.Equal([]string{"https://ctrl.example.com:1280/edge/client/v1"}, capabilityErr.Controllers)
+	req.False(DefaultOptions.RestrictedEgress, "the profile should not modify the defaults")
+}
+
+func Test_contextImpl_checkRestrictedEgressRouters(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{options: RestrictedEgressOptions()}
+	router := func(name string, addrs map[string]string) *rest_model.SessionEdgeRouter {
+		return &rest_model.SessionEdgeRouter{
+			CommonEdgeRouterProperties: rest_model.CommonEdgeRouterProperties{
+				Name:               ToPtr(name),
+				SupportedProtocols: addrs,
+			},
+		}
+	}
+
+	blocked := &rest_model.SessionDetail{
+		Service: &rest_model.EntityRef{Name: "svc"},
+		EdgeRouters: []*rest_model.SessionEdgeRouter{
+			router("router-a", map[string]string{"tls": "tls:a.example.com:3022", "ws": "ws:a.example.com:443"}),
+		},
+	}
+	err := ctx.checkRestrictedEgressRouters(blocked)
+
+	var capabilityErr *EgressCapabilityError
+	req.True(errors.As(err, &capabilityErr))
+	req.Equal(map[string][]string{"router-a": {"tls:a.example.com:3022", "ws:a.example.com:443"}}, capabilityErr.EdgeRouters)
+	req.Contains(err.Error(), "service 'svc'")
+	req.Contains(err.Error(), "router-a=[tls:a.example.com:3022, ws:a.example.com:443]")
+
+	ctx.options = DefaultOptions
+	req.NoError(ctx.checkRestrictedEgressRouters(blocked))
+
+	ctx.options = RestrictedEgressOptions()
+	blocked.EdgeRouters = append(blocked.EdgeRouters, router("router-b", map[string]string{"wss": "wss:b.example.com:443"}))
+	req.NoError(ctx.checkRestrictedEgressRouters(blocked))
+}
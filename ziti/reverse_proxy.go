@@ -0,0 +1,60 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// NewReverseProxy returns a reverse proxy forwarding requests to the HTTP server hosting targetService, dialing the
+// service with ztx. The Host header of proxied requests is passed through unchanged. Responses are flushed to the
+// client as they arrive, so that streamed responses such as server-sent events are not buffered, and upgraded
+// connections such as websockets are proxied in both directions.
+//
+// The returned proxy may be customized before use, e.g. to set an ErrorHandler, but its Transport must keep dialing
+// the service.
+func NewReverseProxy(ztx Context, targetService string) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = targetService
+			if _, ok := req.Header["User-Agent"]; !ok {
+				// keep the default User-Agent from being added, as httputil.NewSingleHostReverseProxy does
+				req.Header.Set("User-Agent", "")
+			}
+		},
+		Transport:     newServiceTransport(ztx, targetService),
+		FlushInterval: -1,
+	}
+}
+
+// newServiceTransport returns a http.Transport which dials targetService for every request, regardless of the
+// requested host.
+func newServiceTransport(ztx Context, targetService string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx gocontext.Context, _, _ string) (net.Conn, error) {
+			return ztx.DialWithContext(ctx, targetService)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
@@ -0,0 +1,95 @@
+package ziti
+
+import (
+	"bufio"
+	gocontext "context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyTestContext dials every service by connecting to addr.
+type proxyTestContext struct {
+	Context
+	addr  string
+	dials []string
+}
+
+func (self *proxyTestContext) DialWithContext(ctx gocontext.Context, serviceName string, _ ...DialOption) (edge.Conn, error) {
+	self.dials = append(self.dials, serviceName)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", self.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyTestConn{conn: conn}, nil
+}
+
+type proxyTestConn struct {
+	edge.Conn
+	conn net.Conn
+}
+
+func (self *proxyTestConn) Read(p []byte) (int, error)  { return self.conn.Read(p) }
+func (self *proxyTestConn) Write(p []byte) (int, error) { return self.conn.Write(p) }
+func (self *proxyTestConn) Close() error                { return self.conn.Close() }
+func (self *proxyTestConn) LocalAddr() net.Addr         { return self.conn.LocalAddr() }
+func (self *proxyTestConn) RemoteAddr() net.Addr        { return self.conn.RemoteAddr() }
+func (self *proxyTestConn) CloseWrite() error           { return self.conn.(*net.TCPConn).CloseWrite() }
+
+func Test_NewReverseProxy(t *testing.T) {
+	req := require.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "echo" {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			_ = rw.Flush()
+			line, _ := rw.ReadString('\n')
+			_, _ = rw.WriteString("echo: " + line)
+			_ = rw.Flush()
+			return
+		}
+		_, _ = io.WriteString(w, r.Host+" "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	ztx := &proxyTestContext{addr: backend.Listener.Addr().String()}
+	proxy := httptest.NewServer(NewReverseProxy(ztx, "backend-svc"))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/hello")
+	req.NoError(err)
+	body, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	_ = resp.Body.Close()
+	req.Equal(strings.TrimPrefix(proxy.URL, "http://")+" /hello", string(body))
+	req.Contains(ztx.dials, "backend-svc")
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	req.NoError(err)
+
+	reader := bufio.NewReader(conn)
+	upgradeResp, err := http.ReadResponse(reader, nil)
+	req.NoError(err)
+	req.Equal(http.StatusSwitchingProtocols, upgradeResp.StatusCode)
+
+	_, err = io.WriteString(conn, "ping\n")
+	req.NoError(err)
+	line, err := reader.ReadString('\n')
+	req.NoError(err)
+	req.Equal("echo: ping\n", line)
+}
@@ -0,0 +1,112 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"time"
+
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+)
+
+// RouterConnectionOptions tunes how connections are multiplexed over each edge router connection. The defaults suit
+// interactive traffic. For bulk transfers, larger queues let more data be in flight per connection, at the cost of
+// memory and of latency for the other connections sharing the router connection.
+type RouterConnectionOptions struct {
+	// MaxCircuits limits the dialed, accepted and hosting connections open at once over a router connection. Dials
+	// prefer other connected edge routers once a router connection is at the limit, and fail with
+	// network.ErrMaxCircuits if there are none. If zero, there is no limit.
+	MaxCircuits int
+
+	// CircuitReadQueueSize is the number of received messages buffered per connection, its receive window, before the
+	// router connection stops reading until the application catches up. Defaults to network.DefaultReadQueueSize.
+	CircuitReadQueueSize int
+
+	// WriteQueueSize is the number of messages queued for sending on a router connection before writes block.
+	// Defaults to channel.DefaultOutQueueSize.
+	WriteQueueSize int
+
+	// WriteTimeout, if set, fails writes to a router connection that can't be sent for this long.
+	WriteTimeout time.Duration
+
+	// PeekHandler, if set, is called for every new edge router connection and may return a handler to observe the
+	// messages sent and received on it, for example to record them.
+	PeekHandler func(routerName, routerUrl string) channel.PeekHandler
+}
+
+// GetMultiplexConfig implements network.MultiplexOwner, applying Options.RouterConnection to the edge router
+// connections of the Context.
+func (context *ContextImpl) GetMultiplexConfig() *network.MultiplexConfig {
+	if context.options == nil || (context.options.RouterConnection == nil && !context.options.isLowMemory()) {
+		return nil
+	}
+
+	result := &network.MultiplexConfig{}
+	if context.options.RouterConnection != nil {
+		result.MaxCircuits = context.options.RouterConnection.MaxCircuits
+		result.ReadQueueSize = context.options.RouterConnection.CircuitReadQueueSize
+	}
+	if result.ReadQueueSize <= 0 && context.options.isLowMemory() {
+		result.ReadQueueSize = lowMemoryCircuitReadQueueSize
+	}
+	return result
+}
+
+// getChannelOptions returns the options of the channels to edge routers.
+func (context *ContextImpl) getChannelOptions() *channel.Options {
+	options := channel.DefaultOptions()
+	options.ConnectTimeout = 15 * time.Second
+
+	if context.options != nil && context.options.RouterConnection != nil {
+		if size := context.options.RouterConnection.WriteQueueSize; size > 0 {
+			options.OutQueueSize = size
+		}
+		options.WriteTimeout = context.options.RouterConnection.WriteTimeout
+	}
+	if context.options.isLowMemory() && (context.options.RouterConnection == nil || context.options.RouterConnection.WriteQueueSize <= 0) {
+		options.OutQueueSize = lowMemoryWriteQueueSize
+	}
+	return options
+}
+
+// getRouterBindHandler returns the bind handler of a new edge router connection, adding the peek handler returned by
+// RouterConnectionOptions.PeekHandler, if any.
+func (context *ContextImpl) getRouterBindHandler(routerName, routerUrl string, conn channel.BindHandler) channel.BindHandler {
+	if context.options == nil || context.options.RouterConnection == nil || context.options.RouterConnection.PeekHandler == nil {
+		return conn
+	}
+
+	peekHandler := context.options.RouterConnection.PeekHandler(routerName, routerUrl)
+	if peekHandler == nil {
+		return conn
+	}
+
+	return channel.BindHandlers(conn, channel.BindHandlerF(func(binding channel.Binding) error {
+		binding.AddPeekHandler(peekHandler)
+		return nil
+	}))
+}
+
+// isRouterConnAtCapacity returns true if the router connection carries Options.RouterConnection.MaxCircuits
+// connections.
+func (context *ContextImpl) isRouterConnAtCapacity(conn edge.RouterConn) bool {
+	if context.options == nil || context.options.RouterConnection == nil || context.options.RouterConnection.MaxCircuits <= 0 {
+		return false
+	}
+	return conn.GetActiveConnCount() >= context.options.RouterConnection.MaxCircuits
+}
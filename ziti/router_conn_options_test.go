@@ -0,0 +1,35 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_contextImpl_routerConnectionOptions(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{options: &Options{}}
+	req.Nil(ctx.GetMultiplexConfig())
+	req.Equal(channel.DefaultOutQueueSize, ctx.getChannelOptions().OutQueueSize)
+	req.Equal(15*time.Second, ctx.getChannelOptions().ConnectTimeout)
+
+	ctx.options.RouterConnection = &RouterConnectionOptions{
+		MaxCircuits:          2,
+		CircuitReadQueueSize: 64,
+		WriteQueueSize:       128,
+		WriteTimeout:         time.Second,
+	}
+	req.Equal(&network.MultiplexConfig{MaxCircuits: 2, ReadQueueSize: 64}, ctx.GetMultiplexConfig())
+
+	options := ctx.getChannelOptions()
+	req.Equal(128, options.OutQueueSize)
+	req.Equal(time.Second, options.WriteTimeout)
+	req.Equal(15*time.Second, options.ConnectTimeout)
+
+	conn := network.NewEdgeConnFactory("er1", "tls:er1:3022", ctx)
+	req.False(ctx.isRouterConnAtCapacity(conn))
+}
@@ -0,0 +1,76 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"github.com/kataras/go-events"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// markRouterUnhealthy excludes the router connection from new dials while other routers are available, emitting
+// EventRouterOffline.
+func (context *ContextImpl) markRouterUnhealthy(conn edge.RouterConn) {
+	if !context.unhealthyRouters.SetIfAbsent(conn.Key(), struct{}{}) {
+		return
+	}
+	context.Emit(EventRouterOffline, conn.GetRouterName(), conn.Key())
+}
+
+// markRouterHealthy makes a router connection marked unhealthy available to new dials again, emitting
+// EventRouterOnline.
+func (context *ContextImpl) markRouterHealthy(conn edge.RouterConn) {
+	if _, found := context.unhealthyRouters.Pop(conn.Key()); !found {
+		return
+	}
+	context.Emit(EventRouterOnline, conn.GetRouterName(), conn.Key())
+}
+
+// isRouterConnHealthy returns false if the router connection has stopped answering keepalives. See
+// KeepaliveOptions.UnhealthyTimeout.
+func (context *ContextImpl) isRouterConnHealthy(conn edge.RouterConn) bool {
+	return !context.unhealthyRouters.Has(conn.Key())
+}
+
+func (context *ContextImpl) AddRouterOfflineListener(handler func(ztx Context, name string, addr string)) func() {
+	return context.addRouterHealthListener(EventRouterOffline, handler)
+}
+
+func (context *ContextImpl) AddRouterOnlineListener(handler func(ztx Context, name string, addr string)) func() {
+	return context.addRouterHealthListener(EventRouterOnline, handler)
+}
+
+func (context *ContextImpl) addRouterHealthListener(event events.EventName, handler func(Context, string, string)) func() {
+	listener := func(args ...interface{}) {
+		name, ok := args[0].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", name, args[0])
+		}
+
+		addr, ok := args[1].(string)
+		if !ok {
+			context.log().Fatalf("could not convert args[1] to %T was %T", addr, args[1])
+		}
+
+		handler(context, name, addr)
+	}
+
+	context.AddListener(event, listener)
+
+	return func() {
+		context.RemoveListener(event, listener)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+const (
+	// MetricRouterLatencyPrefix prefixes the gauges of the smoothed latency, in nanoseconds, to each connected edge
+	// router, e.g. router.latency.er1. Latency is measured when connecting and by the probes sent every
+	// LatencyCheckInterval.
+	MetricRouterLatencyPrefix = "router.latency."
+
+	// DefaultRouterLatencyHysteresis is used when Options.RouterLatencyHysteresis is zero.
+	DefaultRouterLatencyHysteresis = 0.2
+
+	// routerLatencySmoothing is the weight of each new latency measurement in the moving average.
+	routerLatencySmoothing = 0.3
+)
+
+// routerLatency is the exponentially weighted moving average of the latency measured on a router connection.
+type routerLatency struct {
+	routerName string
+	nanos      atomic.Int64
+}
+
+func (self *routerLatency) update(sample time.Duration) time.Duration {
+	current := self.nanos.Load()
+	if current > 0 {
+		current += int64(routerLatencySmoothing * float64(int64(sample)-current))
+	} else {
+		current = int64(sample)
+	}
+	self.nanos.Store(current)
+	return time.Duration(current)
+}
+
+func (self *routerLatency) get() time.Duration {
+	return time.Duration(self.nanos.Load())
+}
+
+// recordRouterLatency updates the smoothed latency of the router connection with the given key, publishing it to the
+// router's MetricRouterLatencyPrefix gauge.
+func (context *ContextImpl) recordRouterLatency(routerName, key string, sample time.Duration) {
+	tracker := context.routerLatencies.Upsert(key, nil, func(exist bool, old *routerLatency, _ *routerLatency) *routerLatency {
+		if exist {
+			return old
+		}
+		return &routerLatency{routerName: routerName}
+	})
+
+	latency := tracker.update(sample)
+	if context.metrics != nil {
+		context.metrics.Gauge(MetricRouterLatencyPrefix + routerName).Update(int64(latency))
+	}
+}
+
+// removeRouterLatency stops tracking the latency of the closed router connection with the given key. The router's
+// gauge is removed once none of its connections are tracked.
+func (context *ContextImpl) removeRouterLatency(key string) {
+	tracker, found := context.routerLatencies.Pop(key)
+	if !found || context.metrics == nil {
+		return
+	}
+
+	for entry := range context.routerLatencies.IterBuffered() {
+		if entry.Val.routerName == tracker.routerName {
+			return
+		}
+	}
+	context.metrics.Gauge(MetricRouterLatencyPrefix + tracker.routerName).Dispose()
+}
+
+// getRouterConnLatency returns the smoothed latency of the router connection with the given key, or zero if it hasn't
+// been measured.
+func (context *ContextImpl) getRouterConnLatency(key string) time.Duration {
+	if tracker, found := context.routerLatencies.Get(key); found {
+		return tracker.get()
+	}
+	return 0
+}
+
+// GetRouterConnLatency implements network.LatencyOwner, providing the RTT estimate of edge connections.
+func (context *ContextImpl) GetRouterConnLatency(key string) time.Duration {
+	return context.getRouterConnLatency(key)
+}
+
+// getRouterLatencies returns the smoothed latency of each connected edge router by name. Routers connected over more
+// than one address report the lowest latency.
+func (context *ContextImpl) getRouterLatencies() map[string]time.Duration {
+	result := map[string]time.Duration{}
+	for entry := range context.routerLatencies.IterBuffered() {
+		latency := entry.Val.get()
+		if current, found := result[entry.Val.routerName]; !found || latency < current {
+			result[entry.Val.routerName] = latency
+		}
+	}
+	return result
+}
+
+// selectRouterConn returns the candidate with the lowest latency for dials of the service. Once a router connection has
+// been selected for the service, it remains selected while it is a candidate, unless another candidate's latency is
+// lower by more than Options.RouterLatencyHysteresis, so that dials don't flap between routers with similar latencies.
+func (context *ContextImpl) selectRouterConn(serviceId string, candidates []edge.RouterConn) (edge.RouterConn, time.Duration) {
+	var best edge.RouterConn
+	var bestLatency time.Duration
+	for _, candidate := range candidates {
+		latency := context.getRouterConnLatency(candidate.Key())
+		if best == nil || latency < bestLatency {
+			best = candidate
+			bestLatency = latency
+		}
+	}
+
+	if best == nil {
+		return nil, 0
+	}
+
+	if current, found := context.selectedRouters.Get(serviceId); found && current != best.Key() {
+		for _, candidate := range candidates {
+			if candidate.Key() != current {
+				continue
+			}
+
+			currentLatency := context.getRouterConnLatency(current)
+			if float64(bestLatency) >= float64(currentLatency)*(1-context.getRouterLatencyHysteresis()) {
+				return candidate, currentLatency
+			}
+			break
+		}
+	}
+
+	context.selectedRouters.Set(serviceId, best.Key())
+	return best, bestLatency
+}
+
+func (context *ContextImpl) getRouterLatencyHysteresis() float64 {
+	if context.options == nil || context.options.RouterLatencyHysteresis == 0 {
+		return DefaultRouterLatencyHysteresis
+	}
+	if context.options.RouterLatencyHysteresis < 0 {
+		return 0
+	}
+	return context.options.RouterLatencyHysteresis
+}
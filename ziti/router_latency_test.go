@@ -0,0 +1,116 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/metrics"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	metrics2 "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouterLatencyTestContext(hysteresis float64) *ContextImpl {
+	return &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		routerLatencies:   cmap.New[*routerLatency](),
+		selectedRouters:   cmap.New[string](),
+		metrics:           metrics.NewRegistry("test", nil),
+		options:           &Options{RouterLatencyHysteresis: hysteresis},
+	}
+}
+
+func Test_routerLatency_update(t *testing.T) {
+	req := require.New(t)
+
+	tracker := &routerLatency{}
+	req.Equal(100*time.Millisecond, tracker.update(100*time.Millisecond))
+	req.Equal(70*time.Millisecond, tracker.update(0))
+	req.Equal(79*time.Millisecond, tracker.update(100*time.Millisecond))
+}
+
+func Test_contextImpl_selectRouterConn_hysteresis(t *testing.T) {
+	req := require.New(t)
+
+	ctx := newRouterLatencyTestContext(0)
+	er1 := &testRouterConn{name: "er1", key: "tls:er1:3022"}
+	er2 := &testRouterConn{name: "er2", key: "tls:er2:3022"}
+	candidates := []edge.RouterConn{er1, er2}
+
+	ctx.recordRouterLatency("er1", er1.key, 100*time.Millisecond)
+	ctx.recordRouterLatency("er2", er2.key, 200*time.Millisecond)
+
+	selected, latency := ctx.selectRouterConn("svc", candidates)
+	req.Equal(er1, selected)
+	req.Equal(100*time.Millisecond, latency)
+
+	// er2 is lower, but not by more than the default hysteresis
+	ctx.routerLatencies.Set(er2.key, &routerLatency{routerName: "er2"})
+	ctx.recordRouterLatency("er2", er2.key, 90*time.Millisecond)
+	selected, _ = ctx.selectRouterConn("svc", candidates)
+	req.Equal(er1, selected)
+
+	// other services pick the lowest latency router
+	selected, _ = ctx.selectRouterConn("other", candidates)
+	req.Equal(er2, selected)
+
+	ctx.recordRouterLatency("er2", er2.key, 10*time.Millisecond)
+	selected, _ = ctx.selectRouterConn("svc", candidates)
+	req.Equal(er2, selected)
+
+	// the selected router is no longer a candidate
+	selected, _ = ctx.selectRouterConn("svc", []edge.RouterConn{er1})
+	req.Equal(er1, selected)
+
+	selected, _ = ctx.selectRouterConn("svc", nil)
+	req.Nil(selected)
+}
+
+func Test_contextImpl_selectRouterConn_hysteresisDisabled(t *testing.T) {
+	req := require.New(t)
+
+	ctx := newRouterLatencyTestContext(-1)
+	er1 := &testRouterConn{name: "er1", key: "tls:er1:3022"}
+	er2 := &testRouterConn{name: "er2", key: "tls:er2:3022"}
+	candidates := []edge.RouterConn{er1, er2}
+
+	ctx.recordRouterLatency("er1", er1.key, 100*time.Millisecond)
+	ctx.recordRouterLatency("er2", er2.key, 200*time.Millisecond)
+
+	selected, _ := ctx.selectRouterConn("svc", candidates)
+	req.Equal(er1, selected)
+
+	ctx.routerLatencies.Set(er2.key, &routerLatency{routerName: "er2"})
+	ctx.recordRouterLatency("er2", er2.key, 90*time.Millisecond)
+	selected, _ = ctx.selectRouterConn("svc", candidates)
+	req.Equal(er2, selected)
+}
+
+func Test_contextImpl_routerLatencyMetrics(t *testing.T) {
+	req := require.New(t)
+
+	ctx := newRouterLatencyTestContext(0)
+	ctx.recordRouterLatency("er1", "tls:er1:3022", 50*time.Millisecond)
+	ctx.recordRouterLatency("er1", "wss:er1:3023", 80*time.Millisecond)
+	ctx.recordRouterLatency("er2", "tls:er2:3022", 20*time.Millisecond)
+
+	req.Equal(map[string]time.Duration{
+		"er1": 50 * time.Millisecond,
+		"er2": 20 * time.Millisecond,
+	}, ctx.getRouterLatencies())
+
+	gauge := ctx.metrics.Gauge(MetricRouterLatencyPrefix + "er2").(metrics2.Gauge)
+	req.Equal(int64(20*time.Millisecond), gauge.Value())
+
+	ctx.removeRouterLatency("tls:er1:3022")
+	req.Equal(map[string]time.Duration{
+		"er1": 80 * time.Millisecond,
+		"er2": 20 * time.Millisecond,
+	}, ctx.getRouterLatencies())
+	req.Zero(ctx.getRouterConnLatency("tls:er1:3022"))
+
+	ctx.removeRouterLatency("tls:er2:3022")
+	ctx.removeRouterLatency("tls:er2:3022")
+	req.Equal(map[string]time.Duration{"er1": 80 * time.Millisecond}, ctx.getRouterLatencies())
+}
@@ -0,0 +1,146 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// EdgeRouterTransport selects which edge router listeners data-plane connections are made to. See
+// Options.EdgeRouterTransport.
+type EdgeRouterTransport string
+
+const (
+	// EdgeRouterTransportAuto connects to the addresses advertised by each edge router, preferring TLS over WSS when
+	// a router advertises both. If a TLS connection to such a router fails, its WSS listener is used instead for
+	// WssFallbackDuration, after which TLS is tried again.
+	EdgeRouterTransportAuto EdgeRouterTransport = ""
+
+	// EdgeRouterTransportTls only connects to the raw TLS listeners of edge routers.
+	EdgeRouterTransportTls EdgeRouterTransport = "tls"
+
+	// EdgeRouterTransportWss only connects to the WebSocket listeners of edge routers, for networks that only pass
+	// HTTPS traffic.
+	EdgeRouterTransportWss EdgeRouterTransport = "wss"
+)
+
+// WssFallbackDuration is how long EdgeRouterTransportAuto keeps using the WSS listener of an edge router after a TLS
+// connection to it failed.
+const WssFallbackDuration = 10 * time.Minute
+
+// wssFallback tracks the WSS addresses of an edge router that also advertises TLS, and when a failed TLS connection
+// last switched the router over to them.
+type wssFallback struct {
+	urls        []string
+	activatedAt time.Time
+}
+
+func (self *wssFallback) isActive() bool {
+	return !self.activatedAt.IsZero() && time.Since(self.activatedAt) < WssFallbackDuration
+}
+
+// getEdgeRouterUrlProtocol returns the transport of an edge router address, e.g. tls for tls:router.example.com:443.
+func getEdgeRouterUrlProtocol(addr string) string {
+	addr = strings.Replace(addr, "://", ":", 1)
+	protocol, _, _ := strings.Cut(addr, ":")
+	return protocol
+}
+
+// selectEdgeRouterUrls returns the accepted addresses of an edge router to connect to, in a stable order. With
+// EdgeRouterTransportAuto, the WSS addresses of a router that also has accepted TLS addresses are returned
+// separately, as the addresses to fall back to.
+func (self *Options) selectEdgeRouterUrls(supportedProtocols map[string]string) (urls []string, fallbackUrls []string) {
+	var wssUrls []string
+	hasTls := false
+	for _, addr := range supportedProtocols {
+		if !self.isEdgeRouterUrlAccepted(addr) {
+			continue
+		}
+
+		protocol := getEdgeRouterUrlProtocol(addr)
+		if edgeRouterTransport := self.getEdgeRouterTransport(); edgeRouterTransport != EdgeRouterTransportAuto &&
+			protocol != string(edgeRouterTransport) {
+			continue
+		}
+
+		if protocol == string(EdgeRouterTransportWss) {
+			wssUrls = append(wssUrls, addr)
+		} else {
+			hasTls = hasTls || protocol == string(EdgeRouterTransportTls)
+			urls = append(urls, addr)
+		}
+	}
+
+	sort.Strings(urls)
+	sort.Strings(wssUrls)
+
+	if hasTls && self.getEdgeRouterTransport() == EdgeRouterTransportAuto {
+		return urls, wssUrls
+	}
+	return append(urls, wssUrls...), nil
+}
+
+// getEdgeRouterTransport returns the configured EdgeRouterTransport, or the platform default if none is set.
+func (self *Options) getEdgeRouterTransport() EdgeRouterTransport {
+	if self.EdgeRouterTransport == EdgeRouterTransportAuto {
+		return defaultEdgeRouterTransport
+	}
+	return self.EdgeRouterTransport
+}
+
+// getEdgeRouterUrls returns the addresses of the edge router to connect to, switching to its WSS addresses while a
+// fallback from TLS is active.
+func (context *ContextImpl) getEdgeRouterUrls(routerName string, supportedProtocols map[string]string) []string {
+	urls, fallbackUrls := context.options.selectEdgeRouterUrls(supportedProtocols)
+	if len(fallbackUrls) == 0 {
+		return urls
+	}
+
+	fallback := context.wssFallbacks.Upsert(routerName, nil, func(exist bool, old *wssFallback, _ *wssFallback) *wssFallback {
+		result := &wssFallback{urls: fallbackUrls}
+		if exist {
+			result.activatedAt = old.activatedAt
+		}
+		return result
+	})
+
+	if fallback.isActive() {
+		return fallbackUrls
+	}
+	return urls
+}
+
+// activateWssFallback switches the edge router over to its WSS addresses after a connection to the TLS address
+// failed, returning the WSS address to connect to instead, or an empty string if the router has none.
+func (context *ContextImpl) activateWssFallback(routerName, failedUrl string) string {
+	if context.options.getEdgeRouterTransport() != EdgeRouterTransportAuto ||
+		getEdgeRouterUrlProtocol(failedUrl) != string(EdgeRouterTransportTls) {
+		return ""
+	}
+
+	fallback, found := context.wssFallbacks.Get(routerName)
+	if !found || len(fallback.urls) == 0 {
+		return ""
+	}
+	context.wssFallbacks.Set(routerName, &wssFallback{urls: fallback.urls, activatedAt: time.Now()})
+
+	context.log().WithField("router", routerName).WithField("failedUrl", failedUrl).
+		Infof("tls connection to edge router failed, using wss for the next %s", WssFallbackDuration)
+	return fallback.urls[0]
+}
@@ -0,0 +1,30 @@
+//go:build js
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import "github.com/openziti/transport/v2"
+
+// defaultEdgeRouterTransport is the EdgeRouterTransport used when Options.EdgeRouterTransport is not set. Browsers
+// can only open WebSocket connections, so edge routers are only reached through their WSS listeners.
+const defaultEdgeRouterTransport = EdgeRouterTransportWss
+
+// wssEdgeRouterAddress returns the address as it is, as browsers open WebSocket connections themselves.
+func wssEdgeRouterAddress(addr transport.Address) transport.Address {
+	return addr
+}
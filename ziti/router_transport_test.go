@@ -0,0 +1,71 @@
+package ziti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openziti/transport/v2"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Options_selectEdgeRouterUrls(t *testing.T) {
+	req := require.New(t)
+
+	both := map[string]string{
+		"tls": "tls:router.example.com:3022",
+		"wss": "wss:router.example.com:443",
+	}
+
+	urls, fallbackUrls := (&Options{}).selectEdgeRouterUrls(both)
+	req.Equal([]string{"tls:router.example.com:3022"}, urls)
+	req.Equal([]string{"wss:router.example.com:443"}, fallbackUrls)
+
+	urls, fallbackUrls = (&Options{EdgeRouterTransport: EdgeRouterTransportWss}).selectEdgeRouterUrls(both)
+	req.Equal([]string{"wss:router.example.com:443"}, urls)
+	req.Empty(fallbackUrls)
+
+	urls, fallbackUrls = (&Options{EdgeRouterTransport: EdgeRouterTransportTls}).selectEdgeRouterUrls(both)
+	req.Equal([]string{"tls:router.example.com:3022"}, urls)
+	req.Empty(fallbackUrls)
+
+	urls, fallbackUrls = (&Options{RestrictedEgress: true}).selectEdgeRouterUrls(both)
+	req.Equal([]string{"wss:router.example.com:443"}, urls, "only wss is on port 443")
+	req.Empty(fallbackUrls)
+
+	urls, fallbackUrls = (&Options{}).selectEdgeRouterUrls(map[string]string{"wss": "wss://router.example.com:443"})
+	req.Equal([]string{"wss://router.example.com:443"}, urls)
+	req.Empty(fallbackUrls)
+}
+
+func Test_contextImpl_wssFallback(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options:      &Options{},
+		wssFallbacks: cmap.New[*wssFallback](),
+	}
+	supported := map[string]string{
+		"tls": "tls:router.example.com:3022",
+		"wss": "wss:router.example.com:443",
+	}
+
+	req.Equal([]string{"tls:router.example.com:3022"}, ctx.getEdgeRouterUrls("er1", supported))
+	req.Empty(ctx.activateWssFallback("er1", "wss:router.example.com:443"), "only tls failures fall back")
+	req.Empty(ctx.activateWssFallback("er2", "tls:other.example.com:3022"), "unknown routers have no fallback")
+
+	req.Equal("wss:router.example.com:443", ctx.activateWssFallback("er1", "tls:router.example.com:3022"))
+	req.Equal([]string{"wss:router.example.com:443"}, ctx.getEdgeRouterUrls("er1", supported))
+
+	fallback, _ := ctx.wssFallbacks.Get("er1")
+	fallback.activatedAt = time.Now().Add(-WssFallbackDuration)
+	req.Equal([]string{"tls:router.example.com:3022"}, ctx.getEdgeRouterUrls("er1", supported), "tls is retried after the fallback expires")
+}
+
+func Test_wssAddressParser(t *testing.T) {
+	req := require.New(t)
+
+	addr, err := transport.ParseAddress("wss:router.example.com:443")
+	req.NoError(err)
+	req.Equal("wss", addr.Type())
+}
@@ -0,0 +1,176 @@
+//go:build !js
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	transporttls "github.com/openziti/transport/v2/tls"
+	"github.com/pkg/errors"
+)
+
+// wssEdgeRouterAddress returns the address to dial an edge router WSS listener at. Both the WebSocket connection and
+// the TLS connection tunneled through it use the TLS configuration of the edge router identity, so that the pins,
+// revocation checks and VerifyPeerCertificate callback of Options.EdgeRouterTLS apply as they do to tls listeners.
+// Other addresses are returned as they are.
+func wssEdgeRouterAddress(addr transport.Address) transport.Address {
+	hostAddr, ok := addr.(interface {
+		Hostname() string
+		Port() uint16
+	})
+	if !ok || addr.Type() != string(EdgeRouterTransportWss) {
+		return addr
+	}
+
+	return &wssAddress{
+		Address:  addr,
+		hostname: hostAddr.Hostname(),
+		port:     hostAddr.Port(),
+	}
+}
+
+// wssAddress is a wss transport address dialed with a WebSocket dialer of its own, rather than the process wide
+// websocket.DefaultDialer the transport library configures for every connection.
+type wssAddress struct {
+	transport.Address
+	hostname string
+	port     uint16
+}
+
+func (self *wssAddress) Hostname() string {
+	return self.hostname
+}
+
+func (self *wssAddress) Port() uint16 {
+	return self.port
+}
+
+func (self *wssAddress) Dial(name string, i *identity.TokenId, timeout time.Duration, _ transport.Configuration) (transport.Conn, error) {
+	tlsConfig := i.ClientTLSConfig()
+	if tlsConfig == nil {
+		return nil, errors.Errorf("no tls configuration to connect to %s with", self.String())
+	}
+	return dialWss(name, self.hostname, self.port, tlsConfig.Clone(), timeout)
+}
+
+func (self *wssAddress) DialWithLocalBinding(name string, _ string, i *identity.TokenId, timeout time.Duration, tcfg transport.Configuration) (transport.Conn, error) {
+	return self.Dial(name, i, timeout, tcfg)
+}
+
+func dialWss(name, hostname string, port uint16, tlsConfig *tls.Config, timeout time.Duration) (transport.Conn, error) {
+	destination := net.JoinHostPort(hostname, strconv.Itoa(int(port)))
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = hostname
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            websocket.DefaultDialer.Proxy,
+		HandshakeTimeout: timeout,
+		TLSClientConfig:  tlsConfig,
+	}
+
+	u := url.URL{Scheme: "wss", Host: destination, Path: "/ws"}
+	ws, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open websocket to %s", destination)
+	}
+	_ = resp.Body.Close()
+
+	tlsConn := tls.Client(&wssConn{ws: ws}, tlsConfig)
+	if timeout > 0 {
+		_ = tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		_ = ws.Close()
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	detail := &transport.ConnectionDetail{
+		Address: string(EdgeRouterTransportWss) + ":" + destination,
+		InBound: false,
+		Name:    name,
+	}
+	return transporttls.NewConnection(detail, tlsConn), nil
+}
+
+// wssConn carries a byte stream over the binary messages of a WebSocket connection.
+type wssConn struct {
+	ws       *websocket.Conn
+	leftover []byte
+	lock     sync.Mutex
+}
+
+func (self *wssConn) Read(b []byte) (int, error) {
+	for len(self.leftover) == 0 {
+		_, msg, err := self.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		self.leftover = msg
+	}
+
+	n := copy(b, self.leftover)
+	self.leftover = self.leftover[n:]
+	return n, nil
+}
+
+func (self *wssConn) Write(b []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if err := self.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (self *wssConn) Close() error {
+	return self.ws.Close()
+}
+
+func (self *wssConn) LocalAddr() net.Addr {
+	return self.ws.LocalAddr()
+}
+
+func (self *wssConn) RemoteAddr() net.Addr {
+	return self.ws.RemoteAddr()
+}
+
+func (self *wssConn) SetDeadline(t time.Time) error {
+	if err := self.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return self.ws.SetWriteDeadline(t)
+}
+
+func (self *wssConn) SetReadDeadline(t time.Time) error {
+	return self.ws.SetReadDeadline(t)
+}
+
+func (self *wssConn) SetWriteDeadline(t time.Time) error {
+	return self.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,174 @@
+//go:build !js
+
+package ziti
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openziti/identity"
+	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newWssTestRouter starts a server that, like the WSS listener of an edge router, upgrades /ws to a WebSocket and
+// serves TLS over it with the certificate of the outer HTTPS server. Data written to the tunneled connection is
+// echoed back. The server uses the httptest certificate if config is nil.
+func newWssTestRouter(t *testing.T, config *tls.Config) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" {
+			http.NotFound(w, r)
+			return
+		}
+
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		conn := tls.Server(&wssConn{ws: ws}, &tls.Config{Certificates: server.TLS.Certificates})
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	if config != nil {
+		server.TLS = config
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// wssTestIdentity provides the TLS configuration of an identity that trusts the test server.
+type wssTestIdentity struct {
+	identity.Identity
+	config *tls.Config
+}
+
+func (self *wssTestIdentity) ClientTLSConfig() *tls.Config {
+	return self.config.Clone()
+}
+
+// dialWssTestRouter dials the server with the edge router TLS options applied to config, or to the configuration of
+// the server's client if config is nil.
+func dialWssTestRouter(server *httptest.Server, config *tls.Config, options *TLSOptions) (transport.Conn, error) {
+	ctx := &ContextImpl{
+		options:              &Options{EdgeRouterTLS: options},
+		edgeRouterRevocation: options.newRevocationChecker(nil),
+	}
+
+	addr, err := transport.ParseAddress("wss:" + server.Listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = server.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+	id := &identity.TokenId{Identity: ctx.getEdgeRouterIdentity(&wssTestIdentity{config: config})}
+	return wssEdgeRouterAddress(addr).Dial("test", id, 5*time.Second, nil)
+}
+
+func Test_wssEdgeRouterAddress(t *testing.T) {
+	req := require.New(t)
+
+	addr, err := transport.ParseAddress("wss:router.example.com:443")
+	req.NoError(err)
+	wssAddr := wssEdgeRouterAddress(addr)
+	req.IsType(&wssAddress{}, wssAddr)
+	req.Equal("wss:router.example.com:443", wssAddr.String())
+
+	addr, err = transport.ParseAddress("tls:router.example.com:3022")
+	req.NoError(err)
+	req.Equal(addr, wssEdgeRouterAddress(addr), "only wss addresses are wrapped")
+}
+
+func Test_wssAddress_pins(t *testing.T) {
+	server := newWssTestRouter(t, nil)
+	otherPin := "sha256/" + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+	t.Run("match", func(t *testing.T) {
+		req := require.New(t)
+
+		conn, err := dialWssTestRouter(server, nil, &TLSOptions{Pins: []string{PublicKeyPin(server.Certificate())}})
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		req.Equal("wss:"+server.Listener.Addr().String(), conn.Detail().Address)
+		req.Equal(server.Certificate().Raw, conn.PeerCertificates()[0].Raw)
+
+		_, err = conn.Write([]byte("hello"))
+		req.NoError(err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		req.NoError(err)
+		req.Equal("hello", string(buf))
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := dialWssTestRouter(server, nil, &TLSOptions{Pins: []string{otherPin}})
+		var mismatch *PinMismatchError
+		req.True(errors.As(err, &mismatch), "expected a pin mismatch, got %v", err)
+	})
+}
+
+func Test_revocationChecker_wss(t *testing.T) {
+	req := require.New(t)
+	testPki := newRevocationTestPki(t)
+
+	server := newWssTestRouter(t, &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{testPki.leaf.Raw},
+			PrivateKey:  testPki.leafKey,
+			OCSPStaple:  testPki.staple(ocsp.Revoked),
+		}},
+	})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(testPki.ca)
+	config := &tls.Config{RootCAs: pool, ServerName: "router.example.com"}
+
+	conn, err := dialWssTestRouter(server, config, nil)
+	req.NoError(err)
+	_ = conn.Close()
+
+	_, err = dialWssTestRouter(server, config, &TLSOptions{Revocation: &RevocationOptions{OCSP: true}})
+	var revokedErr *RevokedCertificateError
+	req.True(errors.As(err, &revokedErr), "unexpected error: %v", err)
+	req.Equal("OCSP", revokedErr.Source)
+
+	config.InsecureSkipVerify = true
+	_, err = dialWssTestRouter(server, config, &TLSOptions{Revocation: &RevocationOptions{OCSP: true, SoftFail: true}})
+	req.ErrorContains(err, "it was not verified")
+}
+
+func Test_wssAddress_VerifyPeerCertificate(t *testing.T) {
+	req := require.New(t)
+	server := newWssTestRouter(t, nil)
+
+	var calls int
+	options := &TLSOptions{
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			req.Equal(server.Certificate().Raw, rawCerts[0])
+			req.NotEmpty(verifiedChains)
+			calls++
+			return errors.New("rejected by test")
+		},
+	}
+
+	_, err := dialWssTestRouter(server, nil, options)
+	req.ErrorContains(err, "rejected by test")
+	req.Equal(1, calls)
+}
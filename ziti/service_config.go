@@ -0,0 +1,199 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// serviceConfigKey identifies a decoded config by its config type and the Go type it was decoded into.
+type serviceConfigKey struct {
+	configType string
+	target     reflect.Type
+}
+
+type decodedServiceConfig struct {
+	value reflect.Value
+	found bool
+	err   error
+}
+
+// serviceConfigEntry holds the decoded configs of a single revision of a service.
+type serviceConfigEntry struct {
+	sync.Mutex
+	service *rest_model.ServiceDetail
+	decoded map[serviceConfigKey]*decodedServiceConfig
+}
+
+// serviceConfigCache memoizes the result of decoding service configs into typed structs. Entries are keyed by service
+// name and are discarded whenever a different revision of the service detail is presented. A nil cache decodes
+// without memoizing.
+type serviceConfigCache struct {
+	lock    sync.Mutex
+	entries map[string]*serviceConfigEntry
+}
+
+func newServiceConfigCache() *serviceConfigCache {
+	return &serviceConfigCache{
+		entries: map[string]*serviceConfigEntry{},
+	}
+}
+
+func (self *serviceConfigCache) getEntry(service *rest_model.ServiceDetail) *serviceConfigEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entry, found := self.entries[*service.Name]
+	if !found || entry.service != service {
+		entry = &serviceConfigEntry{
+			service: service,
+			decoded: map[serviceConfigKey]*decodedServiceConfig{},
+		}
+		self.entries[*service.Name] = entry
+	}
+	return entry
+}
+
+func (self *serviceConfigCache) remove(serviceName string) {
+	if self == nil {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	delete(self.entries, serviceName)
+}
+
+// decode decodes the config of the given type on the service into target, which must be a non-nil pointer. It returns
+// false if the service has no config of that type. See edge.ParseServiceConfig.
+func (self *serviceConfigCache) decode(service *rest_model.ServiceDetail, configType string, target interface{}) (bool, error) {
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Pointer || targetValue.IsNil() {
+		return false, errors.Errorf("config target must be a non-nil pointer, got %T", target)
+	}
+
+	if self == nil {
+		return edge.ParseServiceConfig(service, configType, target)
+	}
+
+	key := serviceConfigKey{
+		configType: configType,
+		target:     targetValue.Type().Elem(),
+	}
+
+	entry := self.getEntry(service)
+	entry.Lock()
+	result, found := entry.decoded[key]
+	if !found {
+		value := reflect.New(key.target)
+		result = &decodedServiceConfig{}
+		result.found, result.err = edge.ParseServiceConfig(service, configType, value.Interface())
+		result.value = value.Elem()
+		entry.decoded[key] = result
+	}
+	entry.Unlock()
+
+	if result.found && result.err == nil {
+		targetValue.Elem().Set(result.value)
+	}
+
+	return result.found, result.err
+}
+
+// GetServiceConfig decodes the config of type configType on the named service into target, which must be a pointer to
+// a struct or map. It returns false if the service has no config of that type. Decoded results are memoized per
+// service revision and target type, so repeated lookups do not decode again.
+func (context *ContextImpl) GetServiceConfig(serviceName string, configType string, target interface{}) (bool, error) {
+	service, found := context.GetService(serviceName)
+	if !found {
+		return false, &ServiceNotFoundError{ServiceName: serviceName}
+	}
+	return context.serviceConfigs.decode(service, configType, target)
+}
+
+// GetInterceptV1Config returns the intercept.v1 config of the named service. It returns false if the service has
+// none.
+func GetInterceptV1Config(ztx Context, serviceName string) (*edge.InterceptV1Config, bool, error) {
+	result := &edge.InterceptV1Config{}
+	found, err := ztx.GetServiceConfig(serviceName, InterceptV1, result)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return result, true, nil
+}
+
+// GetHostV1Config returns the host.v1 config of the named service. It returns false if the service has none.
+func GetHostV1Config(ztx Context, serviceName string) (*edge.HostV1Config, bool, error) {
+	result := &edge.HostV1Config{}
+	found, err := ztx.GetServiceConfig(serviceName, HostV1, result)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return result, true, nil
+}
+
+// GetClientV1Config returns the ziti-tunneler-client.v1 config of the named service. It returns false if the service
+// has none. Services configured with it are intercepted as if they had the equivalent intercept.v1 config, see
+// edge.ClientConfig.ToInterceptV1Config.
+func GetClientV1Config(ztx Context, serviceName string) (*edge.ClientConfig, bool, error) {
+	result := &edge.ClientConfig{}
+	found, err := ztx.GetServiceConfig(serviceName, ClientConfigV1, result)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return result, true, nil
+}
+
+// updateIntercepts decodes the intercept configs of the given services using a pool of workers bounded by GOMAXPROCS.
+func (context *ContextImpl) updateIntercepts(services []*rest_model.ServiceDetail) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(services) {
+		workers = len(services)
+	}
+
+	if workers <= 1 {
+		for _, s := range services {
+			context.updateIntercept(s)
+		}
+		return
+	}
+
+	workC := make(chan *rest_model.ServiceDetail)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for s := range workC {
+				context.updateIntercept(s)
+			}
+		}()
+	}
+
+	for _, s := range services {
+		workC <- s
+	}
+	close(workC)
+	wg.Wait()
+}
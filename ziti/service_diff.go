@@ -0,0 +1,98 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+)
+
+// ServiceDiff lists the services added, removed and changed by a service refresh, as passed to the listeners of
+// EventServicesUpdated.
+type ServiceDiff struct {
+	Added   []*rest_model.ServiceDetail
+	Removed []*rest_model.ServiceDetail
+
+	// Changed are the services whose definition differs from the one previously received.
+	Changed []*rest_model.ServiceDetail
+}
+
+// IsEmpty returns true if the refresh did not add, remove or change any service.
+func (self *ServiceDiff) IsEmpty() bool {
+	return len(self.Added) == 0 && len(self.Removed) == 0 && len(self.Changed) == 0
+}
+
+func (self *ServiceDiff) add(s *rest_model.ServiceDetail, eventType ServiceEventType) {
+	switch eventType {
+	case ServiceAdded:
+		self.Added = append(self.Added, s)
+	case ServiceChanged:
+		self.Changed = append(self.Changed, s)
+	case ServiceRemoved:
+		self.Removed = append(self.Removed, s)
+	}
+}
+
+func (context *ContextImpl) emitServiceDiff(diff *ServiceDiff) {
+	if !diff.IsEmpty() {
+		context.Emit(EventServicesUpdated, diff)
+	}
+}
+
+func (context *ContextImpl) AddServicesUpdatedListener(handler func(ztx Context, diff *ServiceDiff)) func() {
+	listener := func(args ...interface{}) {
+		diff, ok := args[0].(*ServiceDiff)
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", diff, args[0])
+		}
+
+		handler(context, diff)
+	}
+
+	context.AddListener(EventServicesUpdated, listener)
+
+	return func() {
+		context.RemoveListener(EventServicesUpdated, listener)
+	}
+}
+
+// SetServiceRefreshInterval changes how often services are polled for, taking effect immediately. An interval of zero
+// restores Options.RefreshInterval, and intervals below MinRefreshInterval are raised to it. The Edge Client API
+// doesn't push service changes, but each poll first asks the controller whether the services of the identity changed
+// since the last refresh, and only lists them if they did, so short intervals are cheap.
+func (context *ContextImpl) SetServiceRefreshInterval(interval time.Duration) {
+	context.serviceRefreshInterval.Store(int64(interval))
+	select {
+	case context.serviceRefreshIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (context *ContextImpl) getServiceRefreshInterval() time.Duration {
+	interval := time.Duration(context.serviceRefreshInterval.Load())
+	if interval == 0 {
+		interval = context.options.RefreshInterval
+	}
+	if interval == 0 {
+		interval = DefaultServiceRefreshInterval
+	}
+	if interval < MinRefreshInterval {
+		interval = MinRefreshInterval
+	}
+	return interval
+}
@@ -0,0 +1,94 @@
+package ziti
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/posture"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_contextImpl_servicesUpdated(t *testing.T) {
+	req := require.New(t)
+
+	closeNotify := make(chan struct{})
+	defer close(closeNotify)
+
+	ctx := &ContextImpl{
+		options:    &Options{},
+		services:   cmap.New[*rest_model.ServiceDetail](),
+		sessions:   cmap.New[*rest_model.SessionDetail](),
+		intercepts: cmap.New[*edge.InterceptV1Config](),
+		CtrlClt: &CtrlClient{
+			PostureCache: posture.NewCache(nil, closeNotify),
+		},
+		EventEmmiter: events.New(),
+	}
+
+	var diffs []*ServiceDiff
+	remove := ctx.AddServicesUpdatedListener(func(_ Context, diff *ServiceDiff) {
+		diffs = append(diffs, diff)
+	})
+	defer remove()
+
+	var services []*rest_model.ServiceDetail
+	for i := 0; i < 3; i++ {
+		services = append(services, &rest_model.ServiceDetail{
+			BaseEntity: rest_model.BaseEntity{ID: ToPtr(fmt.Sprint("id", i))},
+			Name:       ToPtr(fmt.Sprint("service", i)),
+		})
+	}
+
+	ctx.processServiceUpdates(services)
+	req.Len(diffs, 1)
+	req.Equal(services, diffs[0].Added)
+	req.Empty(diffs[0].Removed)
+	req.Empty(diffs[0].Changed)
+
+	ctx.processServiceUpdates(services)
+	req.Len(diffs, 1, "no diff is emitted for an unchanged refresh")
+
+	changed := &rest_model.ServiceDetail{
+		BaseEntity:  services[1].BaseEntity,
+		Name:        services[1].Name,
+		Permissions: []rest_model.DialBind{rest_model.DialBindDial},
+	}
+	ctx.processServiceUpdates([]*rest_model.ServiceDetail{services[0], changed})
+	req.Len(diffs, 2)
+	req.Empty(diffs[1].Added)
+	req.Equal([]*rest_model.ServiceDetail{changed}, diffs[1].Changed)
+	req.Equal([]*rest_model.ServiceDetail{services[2]}, diffs[1].Removed)
+
+	ctx.processSingleServiceUpdate(*services[0].Name, nil)
+	req.Len(diffs, 3)
+	req.Equal([]*rest_model.ServiceDetail{services[0]}, diffs[2].Removed)
+}
+
+func Test_contextImpl_SetServiceRefreshInterval(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options:                       &Options{RefreshInterval: time.Minute},
+		serviceRefreshIntervalChanged: make(chan struct{}, 1),
+	}
+	req.Equal(time.Minute, ctx.getServiceRefreshInterval())
+
+	ctx.SetServiceRefreshInterval(10 * time.Second)
+	ctx.SetServiceRefreshInterval(5 * time.Second)
+	req.Len(ctx.serviceRefreshIntervalChanged, 1)
+	req.Equal(5*time.Second, ctx.getServiceRefreshInterval())
+
+	ctx.SetServiceRefreshInterval(time.Millisecond)
+	req.Equal(MinRefreshInterval, ctx.getServiceRefreshInterval())
+
+	ctx.SetServiceRefreshInterval(0)
+	req.Equal(time.Minute, ctx.getServiceRefreshInterval())
+
+	ctx.options = &Options{}
+	req.Equal(DefaultServiceRefreshInterval, ctx.getServiceRefreshInterval())
+}
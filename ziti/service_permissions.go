@@ -0,0 +1,86 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"sort"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+)
+
+// ServicePermissions describes what the identity of a Context may do with a service, as returned by
+// Context.GetServicePermissions.
+type ServicePermissions struct {
+	// Dial and Bind are true if a service policy grants the identity the permission.
+	Dial bool
+	Bind bool
+
+	// ConfigTypes are the names of the config types whose configs were returned with the service, sorted by name.
+	// Only config types requested with Config.ConfigTypes are returned.
+	ConfigTypes []string
+
+	// PosturePolicies are the service policies with posture checks granting access to the service. A permission
+	// granted only by policies whose checks are failing is denied when dialing or binding.
+	PosturePolicies []PosturePolicyState
+}
+
+// PosturePolicyState is the posture check state of a service policy granting the identity access to a service.
+type PosturePolicyState struct {
+	PolicyId string
+	Type     rest_model.DialBind
+	Passing  bool
+}
+
+func newServicePermissions(svc *rest_model.ServiceDetail) *ServicePermissions {
+	result := &ServicePermissions{}
+
+	for _, permission := range svc.Permissions {
+		switch permission {
+		case rest_model.DialBindDial:
+			result.Dial = true
+		case rest_model.DialBindBind:
+			result.Bind = true
+		}
+	}
+
+	for configType := range svc.Config {
+		result.ConfigTypes = append(result.ConfigTypes, configType)
+	}
+	sort.Strings(result.ConfigTypes)
+
+	for _, queries := range svc.PostureQueries {
+		if queries == nil {
+			continue
+		}
+		result.PosturePolicies = append(result.PosturePolicies, PosturePolicyState{
+			PolicyId: stringz.OrEmpty(queries.PolicyID),
+			Type:     queries.PolicyType,
+			Passing:  queries.IsPassing != nil && *queries.IsPassing,
+		})
+	}
+
+	return result
+}
+
+func (context *ContextImpl) GetServicePermissions(name string) (*ServicePermissions, bool) {
+	svc, found := context.GetService(name)
+	if !found {
+		return nil, false
+	}
+	return newServicePermissions(svc), true
+}
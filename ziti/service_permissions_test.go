@@ -0,0 +1,35 @@
+package ziti
+
+import (
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newServicePermissions(t *testing.T) {
+	req := require.New(t)
+
+	permissions := newServicePermissions(&rest_model.ServiceDetail{Name: ToPtr("svc")})
+	req.Equal(&ServicePermissions{}, permissions)
+
+	permissions = newServicePermissions(&rest_model.ServiceDetail{
+		Name:        ToPtr("svc"),
+		Permissions: rest_model.DialBindArray{rest_model.DialBindDial, rest_model.DialBindBind},
+		Config: map[string]map[string]interface{}{
+			InterceptV1:    {},
+			ClientConfigV1: {},
+		},
+		PostureQueries: []*rest_model.PostureQueries{
+			{PolicyID: ToPtr("p1"), PolicyType: rest_model.DialBindDial, IsPassing: ToPtr(true)},
+			{PolicyID: ToPtr("p2"), PolicyType: rest_model.DialBindBind, IsPassing: ToPtr(false)},
+		},
+	})
+	req.True(permissions.Dial)
+	req.True(permissions.Bind)
+	req.Equal([]string{InterceptV1, ClientConfigV1}, permissions.ConfigTypes)
+	req.Equal([]PosturePolicyState{
+		{PolicyId: "p1", Type: rest_model.DialBindDial, Passing: true},
+		{PolicyId: "p2", Type: rest_model.DialBindBind, Passing: false},
+	}, permissions.PosturePolicies)
+}
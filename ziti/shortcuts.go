@@ -0,0 +1,94 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"io"
+	"net"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// DialAndCopy dials serviceName and copies between the service and rw in both directions, e.g. to connect stdin and
+// stdout to a service. When reading from rw reaches EOF, the write side of the service connection is closed, and
+// DialAndCopy returns once the service side has closed the connection as well. The returned error excludes io.EOF.
+func DialAndCopy(ztx Context, serviceName string, rw io.ReadWriter, opts ...DialOption) error {
+	conn, err := ztx.DialWithOptions(serviceName, NewDialOptions(opts...))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	writeErrC := make(chan error, 1)
+	go func() {
+		_, copyErr := io.Copy(conn, rw)
+		if copyErr == nil {
+			copyErr = conn.CloseWrite()
+		}
+		writeErrC <- copyErr
+	}()
+
+	_, err = io.Copy(rw, conn)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-writeErrC:
+		return err
+	default:
+		// the service is done, so whatever rw still has to send would go nowhere
+		return nil
+	}
+}
+
+// ListenAndHandleFunc binds serviceName and calls handler in its own goroutine for every connection accepted, closing
+// the connection once handler returns. It returns when the listener is closed, e.g. because the Context was closed.
+func ListenAndHandleFunc(ztx Context, serviceName string, handler func(conn edge.Conn), opts ...ListenOption) error {
+	listener, err := ztx.ListenWithOptions(serviceName, NewListenOptions(opts...))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = listener.Close() }()
+
+	for {
+		conn, err := listener.AcceptEdge()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || listener.IsClosed() {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+			handler(conn)
+		}()
+	}
+}
+
+// ListenAndEcho binds serviceName and writes everything received on each accepted connection back to it. It is meant
+// for trying out services and for tests of dialing applications.
+func ListenAndEcho(ztx Context, serviceName string, opts ...ListenOption) error {
+	return ListenAndHandleFunc(ztx, serviceName, func(conn edge.Conn) {
+		if _, err := io.Copy(conn, conn); err != nil {
+			pfxlog.Logger().WithError(err).WithField("service", serviceName).Debug("echo connection failed")
+		}
+	}, opts...)
+}
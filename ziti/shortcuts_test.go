@@ -0,0 +1,44 @@
+package ziti
+
+import (
+	"bytes"
+	gocontext "context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/stretchr/testify/require"
+)
+
+type shortcutTestContext struct {
+	proxyTestContext
+}
+
+func (self *shortcutTestContext) DialWithOptions(serviceName string, _ *DialOptions) (edge.Conn, error) {
+	return self.DialWithContext(gocontext.Background(), serviceName)
+}
+
+func Test_DialAndCopy_ListenAndEcho(t *testing.T) {
+	req := require.New(t)
+
+	server := newServerTestContext(t)
+	errC := make(chan error, 1)
+	go func() { errC <- ListenAndEcho(server, "echo") }()
+
+	client := &shortcutTestContext{proxyTestContext{addr: server.listener.Addr().String()}}
+	for _, msg := range []string{"hello", strings.Repeat("bulk", 64*1024)} {
+		out := &bytes.Buffer{}
+		rw := &struct {
+			io.Reader
+			io.Writer
+		}{strings.NewReader(msg), out}
+
+		req.NoError(DialAndCopy(client, "echo", rw))
+		req.Equal(msg, out.String())
+	}
+	req.Equal([]string{"echo", "echo"}, client.dials)
+
+	req.NoError(server.listener.Close())
+	req.NoError(<-errC)
+}
@@ -0,0 +1,299 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"time"
+
+	"github.com/openziti/foundation/v2/errorz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// ShutdownPhase identifies a step of CtxCollection.Shutdown. Each phase completes for every Context in the collection
+// before the next one starts.
+type ShutdownPhase string
+
+const (
+	// ShutdownPhaseListeners closes the listeners of all contexts, so that no new connections are accepted.
+	ShutdownPhaseListeners ShutdownPhase = "listeners"
+
+	// ShutdownPhaseDrain waits for the connections dialed or accepted by all contexts to be closed by the application.
+	ShutdownPhaseDrain ShutdownPhase = "drain"
+
+	// ShutdownPhaseRouters closes the edge router connections of all contexts, closing any connection still open.
+	ShutdownPhaseRouters ShutdownPhase = "routers"
+
+	// ShutdownPhaseSessions removes the API Sessions of all contexts from the controller.
+	ShutdownPhaseSessions ShutdownPhase = "sessions"
+)
+
+// ShutdownOptions bounds the phases of CtxCollection.Shutdown. A zero timeout bounds the phase by the context given
+// to Shutdown only. A phase that times out is reported as failed for the contexts that didn't complete it, and
+// shutdown proceeds with the next phase.
+type ShutdownOptions struct {
+	ListenersTimeout time.Duration
+	DrainTimeout     time.Duration
+	RoutersTimeout   time.Duration
+	SessionsTimeout  time.Duration
+}
+
+// DefaultShutdownOptions returns options giving connections up to 30 seconds to drain.
+func DefaultShutdownOptions() *ShutdownOptions {
+	return &ShutdownOptions{
+		ListenersTimeout: 5 * time.Second,
+		DrainTimeout:     30 * time.Second,
+		RoutersTimeout:   5 * time.Second,
+		SessionsTimeout:  5 * time.Second,
+	}
+}
+
+// ShutdownProgress is emitted as EventShutdownProgress when a phase of CtxCollection.Shutdown starts and completes.
+type ShutdownProgress struct {
+	Phase ShutdownPhase
+
+	// Completed is false when the phase starts and true when it has completed for all contexts.
+	Completed bool
+
+	// Elapsed is the time since the phase started.
+	Elapsed time.Duration
+
+	// Err is set on completion if the phase failed or timed out for any Context, as a ContextErrors.
+	Err error
+}
+
+// ErrShuttingDown is returned by dials and listens started after Context.Shutdown was called.
+var ErrShuttingDown = errors.New("context is shutting down")
+
+func (context *ContextImpl) Shutdown(ctx gocontext.Context) error {
+	if context.closed.Load() {
+		return nil
+	}
+
+	if context.shuttingDown.CompareAndSwap(false, true) {
+		context.log().Info("shutting down, no longer accepting dials or listens")
+	}
+
+	var result errorz.MultipleErrors
+
+	if err := context.closeListeners(); err != nil {
+		result = append(result, errors.Wrap(err, "could not close listeners"))
+	}
+
+	if err := context.drainEdgeRouterConns(ctx); err != nil {
+		context.log().WithError(err).Warn("connections did not drain before shutdown deadline, closing them")
+		result = append(result, err)
+	}
+
+	_ = context.closeEdgeRouterConns()
+
+	if ctx.Err() == nil {
+		if err := context.logout(ctx); err != nil {
+			result = append(result, err)
+		}
+	}
+
+	return result.ToError()
+}
+
+// phasedShutdown is implemented by contexts that can be shut down phase by phase. Other contexts in a collection are
+// closed with CloseWithContext during ShutdownPhaseSessions.
+type phasedShutdown interface {
+	closeListeners() error
+	drainEdgeRouterConns(ctx gocontext.Context) error
+	closeEdgeRouterConns() error
+	logout(ctx gocontext.Context) error
+}
+
+var _ phasedShutdown = (*ContextImpl)(nil)
+
+// closeListeners closes every listener of the Context, leaving already accepted connections open.
+func (context *ContextImpl) closeListeners() error {
+	var result errorz.MultipleErrors
+	for entry := range context.listenerManagers.IterBuffered() {
+		if err := entry.Val.listener.Close(); err != nil && !errors.Is(err, edge.ErrAlreadyClosed) {
+			result = append(result, err)
+		}
+	}
+	return result.ToError()
+}
+
+// closeEdgeRouterConns closes the Context, stopping its refreshes, and then all of its edge router connections.
+func (context *ContextImpl) closeEdgeRouterConns() error {
+	if context.closed.CompareAndSwap(false, true) {
+		close(context.closeNotify)
+	}
+	context.CloseAllEdgeRouterConns()
+	return nil
+}
+
+func (context *ContextImpl) logout(ctx gocontext.Context) error {
+	if err := context.CtrlClt.Logout(ctx); err != nil {
+		return errors.Wrap(err, "could not remove api session")
+	}
+	return nil
+}
+
+// Shutdown closes every Context in the collection in phases: first all listeners are closed, then active
+// connections are given time to drain, then edge router connections are closed and finally API Sessions are removed.
+// Progress is reported through EventShutdownProgress. Contexts are removed from the collection once all phases
+// complete. If options is nil, phases are only bounded by ctx. If any phase fails for any Context, a ContextErrors is
+// returned describing the failures of each.
+func (set *CtxCollection) Shutdown(ctx gocontext.Context, options *ShutdownOptions) error {
+	if options == nil {
+		options = &ShutdownOptions{}
+	}
+
+	var contexts []Context
+	set.ForAll(func(ztx Context) {
+		contexts = append(contexts, ztx)
+	})
+
+	failures := map[string]errorz.MultipleErrors{}
+	record := func(errs ContextErrors) {
+		for id, err := range errs {
+			failures[id] = append(failures[id], err)
+		}
+	}
+
+	record(set.runShutdownPhase(ctx, ShutdownPhaseListeners, options.ListenersTimeout, contexts,
+		func(_ gocontext.Context, p phasedShutdown) error {
+			return p.closeListeners()
+		}, nil))
+
+	record(set.runShutdownPhase(ctx, ShutdownPhaseDrain, options.DrainTimeout, contexts,
+		func(phaseCtx gocontext.Context, p phasedShutdown) error {
+			return p.drainEdgeRouterConns(phaseCtx)
+		}, nil))
+
+	record(set.runShutdownPhase(ctx, ShutdownPhaseRouters, options.RoutersTimeout, contexts,
+		func(_ gocontext.Context, p phasedShutdown) error {
+			return p.closeEdgeRouterConns()
+		}, nil))
+
+	record(set.runShutdownPhase(ctx, ShutdownPhaseSessions, options.SessionsTimeout, contexts,
+		func(phaseCtx gocontext.Context, p phasedShutdown) error {
+			return p.logout(phaseCtx)
+		}, func(phaseCtx gocontext.Context, ztx Context) error {
+			return ztx.CloseWithContext(phaseCtx)
+		}))
+
+	for _, ztx := range contexts {
+		set.RemoveById(ztx.GetId())
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	result := ContextErrors{}
+	for id, errs := range failures {
+		if len(errs) == 1 {
+			result[id] = errs[0]
+		} else {
+			result[id] = errs
+		}
+	}
+	return result
+}
+
+// runShutdownPhase runs the phase concurrently for all contexts. Contexts that don't support phased shutdown are
+// handled by fallback, or skipped if it is nil.
+func (set *CtxCollection) runShutdownPhase(ctx gocontext.Context, phase ShutdownPhase, timeout time.Duration, contexts []Context,
+	f func(gocontext.Context, phasedShutdown) error, fallback func(gocontext.Context, Context) error) ContextErrors {
+
+	phaseCtx, cancel := ctx, gocontext.CancelFunc(func() {})
+	if timeout > 0 {
+		phaseCtx, cancel = gocontext.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	start := time.Now()
+	set.emitter.Emit(EventShutdownProgress, ShutdownProgress{Phase: phase})
+	log := set.log().WithField("phase", phase)
+	log.Debug("shutdown phase starting")
+
+	type phaseResult struct {
+		id  string
+		err error
+	}
+
+	results := make(chan phaseResult, len(contexts))
+	pending := map[string]struct{}{}
+	for _, ztx := range contexts {
+		ztx := ztx
+		pending[ztx.GetId()] = struct{}{}
+		go func() {
+			var err error
+			if p, ok := ztx.(phasedShutdown); ok {
+				err = f(phaseCtx, p)
+			} else if fallback != nil {
+				err = fallback(phaseCtx, ztx)
+			}
+			results <- phaseResult{id: ztx.GetId(), err: err}
+		}()
+	}
+
+	errs := ContextErrors{}
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.id)
+			if result.err != nil {
+				errs[result.id] = result.err
+			}
+		case <-phaseCtx.Done():
+			for id := range pending {
+				errs[id] = errors.Wrapf(phaseCtx.Err(), "%s shutdown phase did not complete", phase)
+			}
+			pending = nil
+		}
+	}
+
+	progress := ShutdownProgress{
+		Phase:     phase,
+		Completed: true,
+		Elapsed:   time.Since(start),
+	}
+	if len(errs) > 0 {
+		progress.Err = errs
+		log.WithError(errs).Warn("shutdown phase did not complete cleanly")
+	}
+	set.emitter.Emit(EventShutdownProgress, progress)
+	log.WithField("elapsed", progress.Elapsed).Debug("shutdown phase completed")
+
+	return errs
+}
+
+// AddShutdownProgressListener adds an event listener for the EventShutdownProgress event and returns a function to
+// remove the listener.
+func (set *CtxCollection) AddShutdownProgressListener(handler func(progress ShutdownProgress)) func() {
+	listener := func(args ...interface{}) {
+		progress, ok := args[0].(ShutdownProgress)
+		if !ok {
+			set.log().Fatalf("could not convert args[0] to %T was %T", progress, args[0])
+		}
+		handler(progress)
+	}
+
+	set.emitter.AddListener(EventShutdownProgress, listener)
+
+	return func() {
+		set.emitter.RemoveListener(EventShutdownProgress, listener)
+	}
+}
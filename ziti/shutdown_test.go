@@ -0,0 +1,136 @@
+package ziti
+
+import (
+	gocontext "context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type drainingTestRouterConn struct {
+	testRouterConn
+	active atomic.Int32
+	closed atomic.Bool
+}
+
+func (self *drainingTestRouterConn) GetActiveConnCount() int {
+	return int(self.active.Load())
+}
+
+func (self *drainingTestRouterConn) IsClosed() bool {
+	return self.closed.Load()
+}
+
+func (self *drainingTestRouterConn) Close() error {
+	self.closed.Store(true)
+	return nil
+}
+
+func newShutdownTestContext() (*ContextImpl, *drainingTestRouterConn) {
+	ctx := &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		listenerManagers:  cmap.New[*listenerManager](),
+		unhealthyRouters:  cmap.New[struct{}](),
+		services:          cmap.New[*rest_model.ServiceDetail](),
+		closeNotify:       make(chan struct{}),
+		CtrlClt:           &CtrlClient{ClientApiClient: &edge_apis.ClientApiClient{}},
+	}
+	conn := &drainingTestRouterConn{testRouterConn: testRouterConn{name: "er1", key: "tls:er1:3022"}}
+	conn.active.Store(1)
+	ctx.routerConnections.Set(conn.key, conn)
+	return ctx, conn
+}
+
+func Test_contextImpl_Shutdown(t *testing.T) {
+	req := require.New(t)
+
+	ctx, conn := newShutdownTestContext()
+
+	shutdownCtx, cancel := gocontext.WithTimeout(gocontext.Background(), 5*time.Second)
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- ctx.Shutdown(shutdownCtx)
+	}()
+
+	req.Eventually(ctx.shuttingDown.Load, time.Second, 10*time.Millisecond)
+
+	_, err := ctx.Dial("svc")
+	req.ErrorIs(err, ErrShuttingDown)
+	_, err = ctx.Listen("svc")
+	req.ErrorIs(err, ErrShuttingDown)
+	req.True(ctx.Health().ShuttingDown)
+
+	// the context stays open while connections drain
+	select {
+	case err = <-errC:
+		req.Fail("shutdown returned before connections drained", "err: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	req.False(ctx.closed.Load())
+	req.False(conn.IsClosed())
+
+	conn.active.Store(0)
+	select {
+	case err = <-errC:
+		req.NoError(err)
+	case <-time.After(2 * time.Second):
+		req.Fail("shutdown did not complete after connections drained")
+	}
+	req.True(ctx.closed.Load())
+	req.True(conn.IsClosed())
+}
+
+func Test_contextImpl_Shutdown_deadline(t *testing.T) {
+	req := require.New(t)
+
+	ctx, conn := newShutdownTestContext()
+
+	shutdownCtx, cancel := gocontext.WithTimeout(gocontext.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	err := ctx.Shutdown(shutdownCtx)
+	req.ErrorIs(err, gocontext.DeadlineExceeded)
+	req.True(ctx.closed.Load())
+	req.True(conn.IsClosed())
+
+	// shutting down a closed context is a no-op
+	req.NoError(ctx.Shutdown(gocontext.Background()))
+	req.ErrorIs(ReadOnly(ctx).Shutdown(gocontext.Background()), ErrReadOnly)
+}
+
+func Test_contextImpl_CloseWithContext_drainsBeforeClosing(t *testing.T) {
+	req := require.New(t)
+
+	ctx, conn := newShutdownTestContext()
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- ctx.CloseWithContext(gocontext.Background())
+	}()
+
+	req.Eventually(ctx.shuttingDown.Load, time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case <-ctx.closeNotify:
+		req.Fail("refreshes were stopped before connections drained")
+	default:
+	}
+
+	conn.active.Store(0)
+	select {
+	case err := <-errC:
+		req.NoError(err)
+	case <-time.After(2 * time.Second):
+		req.Fail("close did not complete after connections drained")
+	}
+	req.True(ctx.closed.Load())
+	req.True(conn.IsClosed())
+}
@@ -0,0 +1,238 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// MetricDialSloPrefix prefixes the gauges registered by Context.RegisterDialSLO. Each gauge is named after the
+	// prefix and the SLO's Name, and reports the fraction of good dials in basis points, i.e. 10000 for 100%.
+	MetricDialSloPrefix = "slo.dial."
+
+	// MaxSloWindow is the longest window dial latencies are retained for.
+	MaxSloWindow = time.Hour
+
+	// sloSlotDuration is the granularity of SLO windows.
+	sloSlotDuration = 30 * time.Second
+	sloSlots        = int(MaxSloWindow / sloSlotDuration)
+
+	// latencyBucketCount is the number of exponential latency buckets, with upper bounds from 1ms doubling up to
+	// about 65s, plus one unbounded bucket.
+	latencyBucketCount = 18
+)
+
+// LatencyBucket counts the dials that completed in at most UpperBound, and more than the bound of the previous
+// bucket. The last bucket has no UpperBound.
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// LatencyHistogram is an exponential histogram of the latencies of successful dials of a service within a window, as
+// returned by Context.DialLatencies.
+type LatencyHistogram struct {
+	Window  time.Duration
+	Buckets []LatencyBucket
+
+	// Failures is the number of dials that failed within the window.
+	Failures int64
+}
+
+// Total returns the number of dials within the window, including failed ones.
+func (self *LatencyHistogram) Total() int64 {
+	total := self.Failures
+	for _, bucket := range self.Buckets {
+		total += bucket.Count
+	}
+	return total
+}
+
+// FractionUnder returns the fraction of dials within the window that succeeded in at most threshold. Failed dials
+// count against it. As buckets are exponential, dials are only counted if their whole bucket is within the threshold,
+// so thresholds between bucket bounds are evaluated conservatively. If there were no dials, 1 is returned.
+func (self *LatencyHistogram) FractionUnder(threshold time.Duration) float64 {
+	total := self.Total()
+	if total == 0 {
+		return 1
+	}
+
+	var good int64
+	for _, bucket := range self.Buckets {
+		if bucket.UpperBound == 0 || bucket.UpperBound > threshold {
+			break
+		}
+		good += bucket.Count
+	}
+	return float64(good) / float64(total)
+}
+
+// DialSLO is an objective for the dial latency of a service, evaluated by Context.RegisterDialSLO.
+type DialSLO struct {
+	// Name identifies the SLO in its gauge name. Defaults to the service name.
+	Name string
+
+	ServiceName string
+
+	// Threshold is the latency dials must succeed within to be good.
+	Threshold time.Duration
+
+	// Window is how far back dials are evaluated, up to MaxSloWindow.
+	Window time.Duration
+
+	// Target is the fraction of dials that must be good, e.g. 0.99.
+	Target float64
+}
+
+// Evaluate returns the fraction of good dials in histogram and whether it meets the target.
+func (self *DialSLO) Evaluate(histogram *LatencyHistogram) (float64, bool) {
+	fraction := histogram.FractionUnder(self.Threshold)
+	return fraction, fraction >= self.Target
+}
+
+func latencyBucketBound(idx int) time.Duration {
+	if idx >= latencyBucketCount-1 {
+		return 0
+	}
+	return time.Millisecond << idx
+}
+
+func latencyBucketIndex(latency time.Duration) int {
+	for idx := 0; idx < latencyBucketCount-1; idx++ {
+		if latency <= latencyBucketBound(idx) {
+			return idx
+		}
+	}
+	return latencyBucketCount - 1
+}
+
+type dialLatencySlot struct {
+	slot     int64
+	counts   [latencyBucketCount]int64
+	failures int64
+}
+
+// dialLatencyWindow retains the dial latencies of a service for MaxSloWindow in slots of sloSlotDuration.
+type dialLatencyWindow struct {
+	lock  sync.Mutex
+	slots [sloSlots]dialLatencySlot
+}
+
+func (self *dialLatencyWindow) record(now time.Time, latency time.Duration, failed bool) {
+	slotIdx := now.UnixNano() / int64(sloSlotDuration)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	slot := &self.slots[slotIdx%int64(sloSlots)]
+	if slot.slot != slotIdx {
+		*slot = dialLatencySlot{slot: slotIdx}
+	}
+
+	if failed {
+		slot.failures++
+	} else {
+		slot.counts[latencyBucketIndex(latency)]++
+	}
+}
+
+func (self *dialLatencyWindow) histogram(now time.Time, window time.Duration) *LatencyHistogram {
+	if window <= 0 || window > MaxSloWindow {
+		window = MaxSloWindow
+	}
+
+	result := &LatencyHistogram{
+		Window:  window,
+		Buckets: make([]LatencyBucket, latencyBucketCount),
+	}
+	for idx := range result.Buckets {
+		result.Buckets[idx].UpperBound = latencyBucketBound(idx)
+	}
+
+	if self == nil {
+		return result
+	}
+
+	current := now.UnixNano() / int64(sloSlotDuration)
+	oldest := current - int64(math.Ceil(float64(window)/float64(sloSlotDuration))) + 1
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for i := range self.slots {
+		slot := &self.slots[i]
+		if slot.slot < oldest || slot.slot > current {
+			continue
+		}
+		result.Failures += slot.failures
+		for idx, count := range slot.counts {
+			result.Buckets[idx].Count += count
+		}
+	}
+
+	return result
+}
+
+// dialLatencies holds the dialLatencyWindow of each dialed service. The zero value is empty and ready to use.
+type dialLatencies struct {
+	lock     sync.Mutex
+	services map[string]*dialLatencyWindow
+}
+
+func (self *dialLatencies) record(serviceName string, latency time.Duration, err error) {
+	self.lock.Lock()
+	if self.services == nil {
+		self.services = map[string]*dialLatencyWindow{}
+	}
+	window, found := self.services[serviceName]
+	if !found {
+		window = &dialLatencyWindow{}
+		self.services[serviceName] = window
+	}
+	self.lock.Unlock()
+
+	window.record(time.Now(), latency, err != nil)
+}
+
+func (self *dialLatencies) get(serviceName string) *dialLatencyWindow {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.services[serviceName]
+}
+
+func (context *ContextImpl) DialLatencies(serviceName string, window time.Duration) *LatencyHistogram {
+	return context.dialLatencies.get(serviceName).histogram(time.Now(), window)
+}
+
+func (context *ContextImpl) RegisterDialSLO(slo DialSLO) {
+	name := slo.Name
+	if name == "" {
+		name = slo.ServiceName
+	}
+
+	if context.metrics == nil {
+		return
+	}
+
+	context.metrics.FuncGauge(MetricDialSloPrefix+name, func() int64 {
+		fraction, _ := slo.Evaluate(context.DialLatencies(slo.ServiceName, slo.Window))
+		return int64(math.Round(fraction * 10000))
+	})
+}
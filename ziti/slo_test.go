@@ -0,0 +1,63 @@
+package ziti
+
+import (
+	"errors"
+	"github.com/openziti/metrics"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func Test_dialLatencyWindow(t *testing.T) {
+	req := require.New(t)
+
+	now := time.Now()
+	window := &dialLatencyWindow{}
+	window.record(now.Add(-2*time.Hour), time.Millisecond, false)
+	window.record(now.Add(-5*time.Minute), 3*time.Millisecond, false)
+	window.record(now, 50*time.Millisecond, false)
+	window.record(now, time.Second, false)
+	window.record(now, 0, true)
+
+	histogram := window.histogram(now, 10*time.Minute)
+	req.Equal(int64(4), histogram.Total(), "dials outside the window should not count")
+	req.Equal(int64(1), histogram.Failures)
+	req.Equal(int64(1), histogram.Buckets[2].Count)
+	req.Equal(4*time.Millisecond, histogram.Buckets[2].UpperBound)
+	req.Equal(time.Duration(0), histogram.Buckets[latencyBucketCount-1].UpperBound)
+
+	req.Equal(0.25, histogram.FractionUnder(10*time.Millisecond))
+	req.Equal(0.5, histogram.FractionUnder(64*time.Millisecond))
+	req.Equal(0.25, histogram.FractionUnder(63*time.Millisecond), "partial buckets should not count")
+	req.Equal(0.75, histogram.FractionUnder(time.Minute))
+
+	req.Equal(int64(3), window.histogram(now, time.Minute).Total())
+
+	var empty *dialLatencyWindow
+	req.Equal(1.0, empty.histogram(now, time.Minute).FractionUnder(time.Millisecond))
+}
+
+func Test_contextImpl_RegisterDialSLO(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{metrics: metrics.NewRegistry("test", nil)}
+	for i := 0; i < 9; i++ {
+		ctx.dialLatencies.record("svc", 20*time.Millisecond, nil)
+	}
+	ctx.dialLatencies.record("svc", 0, errors.New("dial failed"))
+
+	slo := DialSLO{ServiceName: "svc", Threshold: 100 * time.Millisecond, Window: 5 * time.Minute, Target: 0.95}
+	fraction, met := slo.Evaluate(ctx.DialLatencies("svc", slo.Window))
+	req.Equal(0.9, fraction)
+	req.False(met)
+
+	ctx.RegisterDialSLO(slo)
+	req.Equal(int64(9000), ctx.metrics.GetGauge(MetricDialSloPrefix+"svc").Value())
+}
+
+func Test_contextImpl_RegisterDialSLO_unauthenticated(t *testing.T) {
+	ctx := &ContextImpl{}
+	require.NotPanics(t, func() {
+		ctx.RegisterDialSLO(DialSLO{ServiceName: "svc", Threshold: 100 * time.Millisecond})
+	})
+}
@@ -0,0 +1,223 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openziti/foundation/v2/stringz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// ContextStats is a point in time summary of a Context, as returned by Context.Stats.
+type ContextStats struct {
+	// Authenticated is true if the Context currently holds an API Session.
+	Authenticated bool
+
+	// IdentityName is the name of the identity of the current API Session, if any.
+	IdentityName string
+
+	// HostedServices are the names of the services the Context has open listeners for, sorted by name.
+	HostedServices []string
+
+	// EdgeRouterConnections is the number of open edge router connections.
+	EdgeRouterConnections int
+
+	// EdgeRouterLatencies is the smoothed latency to each connected edge router, by router name. The same latencies
+	// are published to the metrics registry as MetricRouterLatencyPrefix gauges.
+	EdgeRouterLatencies map[string]time.Duration
+
+	// ActiveConnections is the number of dialed, accepted and hosting connections multiplexed over the edge router
+	// connections.
+	ActiveConnections int
+
+	// BytesIn and BytesOut are the payload bytes read from and written to connections since the Context was created.
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+func (context *ContextImpl) Stats() ContextStats {
+	result := ContextStats{
+		BytesIn:  context.traffic.RxBytes(),
+		BytesOut: context.traffic.TxBytes(),
+	}
+
+	if latencies := context.getRouterLatencies(); len(latencies) > 0 {
+		result.EdgeRouterLatencies = latencies
+	}
+
+	if context.CtrlClt != nil {
+		if apiSession := context.CtrlClt.GetCurrentApiSession(); apiSession != nil {
+			result.Authenticated = true
+			result.IdentityName = apiSession.GetIdentityName()
+		}
+	}
+
+	for entry := range context.listenerManagers.IterBuffered() {
+		if name := stringz.OrEmpty(entry.Val.service.Name); !entry.Val.listener.IsClosed() && !stringz.Contains(result.HostedServices, name) {
+			result.HostedServices = append(result.HostedServices, name)
+		}
+	}
+	sort.Strings(result.HostedServices)
+
+	for entry := range context.routerConnections.IterBuffered() {
+		if !entry.Val.IsClosed() {
+			result.EdgeRouterConnections++
+			result.ActiveConnections += entry.Val.GetActiveConnCount()
+		}
+	}
+
+	return result
+}
+
+// GetTrafficCounter implements network.TrafficCountingOwner, so that connections to edge routers made by the Context
+// count their traffic towards Stats.
+func (context *ContextImpl) GetTrafficCounter() *edge.TrafficCounter {
+	return &context.traffic
+}
+
+// Connections returns the stats of the dialed and accepted connections over the open edge router connections, oldest
+// first. It is meant for finding stuck flows, see edge.ConnStats.
+func (context *ContextImpl) Connections() []edge.ConnStats {
+	var result []edge.ConnStats
+	for entry := range context.routerConnections.IterBuffered() {
+		if !entry.Val.IsClosed() {
+			result = append(result, entry.Val.GetConnStats()...)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+	return result
+}
+
+// CollectionSnapshot aggregates the ContextStats of every Context in a CtxCollection.
+type CollectionSnapshot struct {
+	Contexts              int
+	AuthenticatedContexts int
+	EdgeRouterConnections int
+	ActiveConnections     int
+	BytesIn               uint64
+	BytesOut              uint64
+}
+
+// Snapshot returns the aggregated stats of all contexts currently in the collection. Byte counts are the sum over the
+// current members, so they drop when a Context is removed.
+func (set *CtxCollection) Snapshot() CollectionSnapshot {
+	result := CollectionSnapshot{}
+
+	set.ForAll(func(ctx Context) {
+		stats := ctx.Stats()
+
+		result.Contexts++
+		if stats.Authenticated {
+			result.AuthenticatedContexts++
+		}
+		result.EdgeRouterConnections += stats.EdgeRouterConnections
+		result.ActiveConnections += stats.ActiveConnections
+		result.BytesIn += stats.BytesIn
+		result.BytesOut += stats.BytesOut
+	})
+
+	return result
+}
+
+const prometheusMetricPrefix = "ziti_collection_"
+
+// WritePrometheus writes the snapshot in the Prometheus text exposition format.
+func (s CollectionSnapshot) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name       string
+		metricType string
+		help       string
+		value      uint64
+	}{
+		{"contexts", "gauge", "Number of contexts in the collection.", uint64(s.Contexts)},
+		{"authenticated_contexts", "gauge", "Number of contexts in the collection with an API Session.", uint64(s.AuthenticatedContexts)},
+		{"edge_router_connections", "gauge", "Number of open edge router connections.", uint64(s.EdgeRouterConnections)},
+		{"active_connections", "gauge", "Number of connections multiplexed over edge router connections.", uint64(s.ActiveConnections)},
+		{"rx_bytes_total", "counter", "Payload bytes read from connections.", s.BytesIn},
+		{"tx_bytes_total", "counter", "Payload bytes written to connections.", s.BytesOut},
+	}
+
+	for _, m := range metrics {
+		name := prometheusMetricPrefix + m.name
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, m.help, name, m.metricType, name, m.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PrometheusHandler returns an http.Handler that serves the collection Snapshot in the Prometheus text exposition
+// format, so it can be scraped without the SDK depending on the Prometheus client library.
+func (set *CtxCollection) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = set.Snapshot().WritePrometheus(w)
+	})
+}
+
+// String returns a one line summary of the collection.
+func (set *CtxCollection) String() string {
+	snapshot := set.Snapshot()
+	return fmt.Sprintf("CtxCollection[contexts=%d, authenticated=%d, routerConnections=%d, connections=%d]",
+		snapshot.Contexts, snapshot.AuthenticatedContexts, snapshot.EdgeRouterConnections, snapshot.ActiveConnections)
+}
+
+// Dump writes a table of the contexts in the collection to w, ordered by id, listing the identity, authentication
+// state, connection counts and hosted services of each. It is meant for debugging, the format may change.
+func (set *CtxCollection) Dump(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tIDENTITY\tSTATE\tROUTERS\tCONNS\tHOSTING")
+
+	for _, id := range set.Ids() {
+		ctx, found := set.contexts.Get(id)
+		if !found {
+			continue
+		}
+
+		stats := ctx.Stats()
+		state := "unauthenticated"
+		if stats.Authenticated {
+			state = "authenticated"
+		}
+
+		identity := stats.IdentityName
+		if identity == "" {
+			identity = "-"
+		}
+
+		hosting := "-"
+		if len(stats.HostedServices) > 0 {
+			hosting = strings.Join(stats.HostedServices, ",")
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n",
+			id, identity, state, stats.EdgeRouterConnections, stats.ActiveConnections, hosting)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,318 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/foundation/v2/stringz"
+)
+
+const (
+	// terminatorsTtl is how long the addressable terminators of a service are cached for client-side selection.
+	terminatorsTtl = 30 * time.Second
+
+	// maxTerminatorPageSize is the largest page of terminators the controller will return.
+	maxTerminatorPageSize = 500
+)
+
+// Terminator describes an addressable terminator of a service, as offered to a TerminatorStrategy.
+type Terminator struct {
+	Id string
+
+	// Identity is the instance identity the terminator was bound with. Dials are routed to the selected terminator by
+	// this identity, so terminators sharing an identity are interchangeable.
+	Identity string
+
+	RouterId   string
+	RouterName string
+
+	// RouterCost is the static cost of the edge router hosting the terminator, or zero if the router is not one the
+	// current identity may connect to.
+	RouterCost int64
+
+	// RouterLabels are the labels of the edge router hosting the terminator. See EdgeRouter.Labels.
+	RouterLabels map[string]string
+
+	// Latency is the smoothed latency from this Context to the edge router hosting the terminator, or zero if the Context
+	// is not connected to that router.
+	Latency time.Duration
+}
+
+// TerminatorStrategy selects the terminator to dial when a service has more than one addressable terminator. Select is
+// only called with terminators that have an identity. Returning nil leaves the choice to the service's terminator
+// strategy on the router.
+type TerminatorStrategy interface {
+	Select(serviceName string, terminators []*Terminator) *Terminator
+}
+
+// TerminatorStrategyFunc adapts a function to a TerminatorStrategy.
+type TerminatorStrategyFunc func(serviceName string, terminators []*Terminator) *Terminator
+
+func (f TerminatorStrategyFunc) Select(serviceName string, terminators []*Terminator) *Terminator {
+	return f(serviceName, terminators)
+}
+
+// SelectRandomTerminator picks a terminator uniformly at random.
+var SelectRandomTerminator TerminatorStrategy = TerminatorStrategyFunc(func(_ string, terminators []*Terminator) *Terminator {
+	if len(terminators) == 0 {
+		return nil
+	}
+	return terminators[rand.Intn(len(terminators))]
+})
+
+// SelectLowestLatencyTerminator picks the terminator hosted on the edge router with the lowest measured latency.
+// Terminators on routers without a latency measurement are only picked if no router has one.
+var SelectLowestLatencyTerminator TerminatorStrategy = TerminatorStrategyFunc(func(_ string, terminators []*Terminator) *Terminator {
+	var selected *Terminator
+	for _, terminator := range terminators {
+		if selected == nil ||
+			(terminator.Latency > 0 && (selected.Latency == 0 || terminator.Latency < selected.Latency)) {
+			selected = terminator
+		}
+	}
+	return selected
+})
+
+// SelectCostWeightedTerminator picks a terminator at random, weighted so that terminators on lower cost edge routers
+// are picked proportionally more often.
+var SelectCostWeightedTerminator TerminatorStrategy = TerminatorStrategyFunc(func(_ string, terminators []*Terminator) *Terminator {
+	if len(terminators) == 0 {
+		return nil
+	}
+
+	weights := make([]float64, len(terminators))
+	var total float64
+	for i, terminator := range terminators {
+		weights[i] = 1 / float64(terminator.RouterCost+1)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return terminators[i]
+		}
+		r -= weight
+	}
+	return terminators[len(terminators)-1]
+})
+
+// StickyTerminatorStrategy returns a TerminatorStrategy that consistently maps key to one of the terminator
+// identities, so that dials with the same key reach the same hosting instance while it remains available. See
+// DialOptions.AffinityKey.
+func StickyTerminatorStrategy(key string) TerminatorStrategy {
+	return TerminatorStrategyFunc(func(_ string, terminators []*Terminator) *Terminator {
+		identities := make([]string, 0, len(terminators))
+		for _, terminator := range terminators {
+			identities = append(identities, terminator.Identity)
+		}
+
+		identity := selectAffinityIdentity(key, identities)
+		for _, terminator := range terminators {
+			if terminator.Identity == identity {
+				return terminator
+			}
+		}
+		return nil
+	})
+}
+
+// serviceTerminators is the set of addressable terminators of a service, ordered by identity.
+type serviceTerminators struct {
+	terminators []*Terminator
+	fetchedAt   time.Time
+}
+
+// selectTerminatorIdentity returns the identity of the terminator the strategy selects for the named service, or an
+// empty string if the service has no addressable terminators, they could not be listed or the strategy selected none.
+func (context *ContextImpl) selectTerminatorIdentity(serviceName string, strategy TerminatorStrategy) string {
+	candidates, err := context.getTerminatorCandidates(serviceName)
+	if err != nil {
+		context.log().WithError(err).WithField("service", serviceName).
+			Warn("unable to list terminators for client-side selection, leaving selection to the router")
+		return ""
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	if selected := selectTerminator(strategy, context.log(), serviceName, candidates); selected != nil {
+		return selected.Identity
+	}
+	return ""
+}
+
+// selectTerminator has strategy select among terminators, logging to log if it is a loggingTerminatorStrategy.
+func selectTerminator(strategy TerminatorStrategy, log *pfxlog.Builder, serviceName string, terminators []*Terminator) *Terminator {
+	if logging, ok := strategy.(loggingTerminatorStrategy); ok {
+		return logging.selectWithLog(log, serviceName, terminators)
+	}
+	return strategy.Select(serviceName, terminators)
+}
+
+// getTerminatorCandidates returns copies of the cached addressable terminators of the named service with their current
+// router latencies filled in, listing them from the controller if the cache has expired.
+func (context *ContextImpl) getTerminatorCandidates(serviceName string) ([]*Terminator, error) {
+	cached, found := context.terminators.Get(serviceName)
+	if !found || time.Since(cached.fetchedAt) > terminatorsTtl {
+		terminators, err := context.listTerminators(serviceName)
+		if err != nil {
+			return nil, err
+		}
+		context.addRouterDetails(terminators)
+		cached = &serviceTerminators{
+			terminators: terminators,
+			fetchedAt:   time.Now(),
+		}
+		if !context.options.isLowMemory() {
+			context.terminators.Set(serviceName, cached)
+		}
+	}
+
+	candidates := make([]*Terminator, 0, len(cached.terminators))
+	for _, terminator := range cached.terminators {
+		candidate := *terminator
+		candidate.Latency = context.getRouterLatency(candidate.RouterName)
+		candidates = append(candidates, &candidate)
+	}
+	return candidates, nil
+}
+
+// getRouterLatency returns the smoothed latency of the open connection to the named edge router, if any.
+func (context *ContextImpl) getRouterLatency(routerName string) time.Duration {
+	if routerName == "" {
+		return 0
+	}
+
+	for entry := range context.routerConnections.IterBuffered() {
+		if entry.Val.IsClosed() || entry.Val.GetRouterName() != routerName {
+			continue
+		}
+		if latency := context.getRouterConnLatency(entry.Key); latency > 0 {
+			return latency
+		}
+	}
+	return 0
+}
+
+// listTerminators returns the terminators of the named service that have an identity, ordered by identity.
+func (context *ContextImpl) listTerminators(serviceName string) ([]*Terminator, error) {
+	var result []*Terminator
+
+	for offset := 0; ; offset += maxTerminatorPageSize {
+		terminators, count, err := context.GetServiceTerminators(serviceName, offset, maxTerminatorPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, detail := range terminators {
+			if identity := stringz.OrEmpty(detail.Identity); identity != "" {
+				result = append(result, &Terminator{
+					Id:       stringz.OrEmpty(detail.ID),
+					Identity: identity,
+					RouterId: stringz.OrEmpty(detail.RouterID),
+				})
+			}
+		}
+
+		if len(terminators) == 0 || offset+len(terminators) >= count {
+			break
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Identity != result[j].Identity {
+			return result[i].Identity < result[j].Identity
+		}
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+// addRouterDetails fills in the name and cost of the edge router hosting each terminator, for the routers the current
+// identity may connect to.
+func (context *ContextImpl) addRouterDetails(terminators []*Terminator) {
+	edgeRouters, err := context.CtrlClt.GetCurrentIdentityEdgeRouters()
+	if err != nil {
+		context.log().WithError(err).Debug("unable to list edge routers, terminators will lack router details")
+		return
+	}
+
+	routers := map[string]*rest_model.CurrentIdentityEdgeRouterDetail{}
+	for _, router := range edgeRouters {
+		routers[stringz.OrEmpty(router.ID)] = router
+	}
+
+	for _, terminator := range terminators {
+		if router, found := routers[terminator.RouterId]; found {
+			terminator.RouterName = stringz.OrEmpty(router.Name)
+			terminator.RouterCost = int64OrZero(router.Cost)
+			terminator.RouterLabels = routerLabels(router.AppData)
+		}
+	}
+}
+
+func int64OrZero(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func (context *ContextImpl) GetServiceTerminatorIdentities(serviceName string) ([]string, error) {
+	terminators, err := context.listTerminators(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, terminator := range terminators {
+		if len(result) == 0 || result[len(result)-1] != terminator.Identity {
+			result = append(result, terminator.Identity)
+		}
+	}
+	return result, nil
+}
+
+// selectAffinityIdentity maps the key to one of the identities using rendezvous hashing, so that a key keeps mapping to
+// the same identity for as long as it exists, and only keys mapped to a removed identity move when the set changes.
+func selectAffinityIdentity(key string, identities []string) string {
+	var selected string
+	var highest uint64
+
+	for _, identity := range identities {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(identity))
+
+		if weight := h.Sum64(); selected == "" || weight > highest {
+			selected = identity
+			highest = weight
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,180 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/openziti/identity"
+)
+
+// DefaultTLSSessionCacheSize is the number of TLS sessions cached for resumption when TLSOptions.SessionCacheSize is
+// zero.
+const DefaultTLSSessionCacheSize = 64
+
+// TLSOptions tunes the TLS connections made to controllers or edge routers. Unset fields keep the defaults. Session
+// resumption is enabled by default, so that reconnecting to a controller or edge router skips the full handshake.
+// See Options.ControllerTLS and Options.EdgeRouterTLS.
+type TLSOptions struct {
+	// MinVersion is the lowest TLS version offered, e.g. tls.VersionTLS13.
+	MinVersion uint16
+
+	// MaxVersion is the highest TLS version offered.
+	MaxVersion uint16
+
+	// CipherSuites restricts the TLS 1.2 cipher suites offered. TLS 1.3 cipher suites are not configurable.
+	CipherSuites []uint16
+
+	// CurvePreferences orders the key exchange mechanisms offered.
+	CurvePreferences []tls.CurveID
+
+	// NextProtos are the ALPN protocols offered, ahead of any the connection's transport offers itself. Controller
+	// connections must only offer protocols the HTTP client supports.
+	NextProtos []string
+
+	// SessionCacheSize is the number of TLS sessions cached for resumption. If zero, DefaultTLSSessionCacheSize is
+	// used. If negative, sessions are not resumed.
+	SessionCacheSize int
+
+	// Pins, if set, only accept servers whose certificate, or a CA certificate of its verified chain, matches one of
+	// the pins, in addition to being verified against the CA pool of the identity. Pins are made with PublicKeyPin or
+	// CertificatePin. List the pins of both the current and the next key to rotate keys without an outage. Connections
+	// to servers matching no pin fail with a PinMismatchError.
+	Pins []string
+
+	// Revocation, if set, enables checking the certificates presented by servers for revocation.
+	Revocation *RevocationOptions
+
+	// VerifyPeerCertificate, if set, is called for every connection after the server's certificate chain has been
+	// verified against the CA pool of the identity and the pins, with the same arguments as
+	// tls.Config.VerifyPeerCertificate. Unlike the tls.Config callback it is also called for resumed sessions.
+	// Returning an error fails the handshake, so it may be used for additional trust checks, e.g. against a
+	// certificate transparency log.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+}
+
+// withPins returns a copy of the options with the pins added, or the options themselves if there are none to add.
+func (self *TLSOptions) withPins(pins []string) *TLSOptions {
+	if len(pins) == 0 {
+		return self
+	}
+
+	result := &TLSOptions{}
+	if self != nil {
+		*result = *self
+	}
+	result.Pins = append(append([]string{}, result.Pins...), pins...)
+	return result
+}
+
+// validate returns an error if the options can't be applied.
+func (self *TLSOptions) validate() error {
+	if self == nil {
+		return nil
+	}
+	return validatePins(self.Pins)
+}
+
+// newSessionCache returns the cache to resume sessions from, or nil if resumption is disabled.
+func (self *TLSOptions) newSessionCache() tls.ClientSessionCache {
+	size := DefaultTLSSessionCacheSize
+	if self != nil && self.SessionCacheSize != 0 {
+		size = self.SessionCacheSize
+	}
+
+	if size < 0 {
+		return nil
+	}
+	return tls.NewLRUClientSessionCache(size)
+}
+
+// apply sets the configured fields on config, resuming sessions from sessionCache if it is not nil. It returns config,
+// or a new configuration with the fields set if config is nil, so that pins and other checks are never dropped.
+func (self *TLSOptions) apply(config *tls.Config, sessionCache tls.ClientSessionCache) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+
+	if sessionCache != nil && config.ClientSessionCache == nil {
+		config.ClientSessionCache = sessionCache
+	}
+
+	if self == nil {
+		return config
+	}
+
+	if self.MinVersion != 0 {
+		config.MinVersion = self.MinVersion
+	}
+	if self.MaxVersion != 0 {
+		config.MaxVersion = self.MaxVersion
+	}
+	if len(self.CipherSuites) > 0 {
+		config.CipherSuites = self.CipherSuites
+	}
+	if len(self.CurvePreferences) > 0 {
+		config.CurvePreferences = self.CurvePreferences
+	}
+	if len(self.NextProtos) > 0 {
+		config.NextProtos = append(append([]string{}, self.NextProtos...), config.NextProtos...)
+	}
+	if len(self.Pins) > 0 {
+		config.VerifyConnection = chainVerifyConnection(config.VerifyConnection, newPinVerifier(self.Pins))
+	}
+	if self.VerifyPeerCertificate != nil {
+		config.VerifyConnection = chainVerifyConnection(config.VerifyConnection, newPeerCertificateVerifier(self.VerifyPeerCertificate))
+	}
+	return config
+}
+
+// newPeerCertificateVerifier adapts a tls.Config VerifyPeerCertificate style callback to VerifyConnection, which is
+// called for resumed sessions as well.
+func newPeerCertificateVerifier(verify func([][]byte, [][]*x509.Certificate) error) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		rawCerts := make([][]byte, 0, len(state.PeerCertificates))
+		for _, cert := range state.PeerCertificates {
+			rawCerts = append(rawCerts, cert.Raw)
+		}
+		return verify(rawCerts, state.VerifiedChains)
+	}
+}
+
+// edgeRouterTlsIdentity applies Options.EdgeRouterTLS to the TLS configurations the identity provides for edge router
+// connections.
+type edgeRouterTlsIdentity struct {
+	identity.Identity
+	options      *TLSOptions
+	sessionCache tls.ClientSessionCache
+	revocation   *revocationChecker
+}
+
+func (self *edgeRouterTlsIdentity) ClientTLSConfig() *tls.Config {
+	config := self.options.apply(self.Identity.ClientTLSConfig(), self.sessionCache)
+	self.revocation.apply(config)
+	return config
+}
+
+// getEdgeRouterIdentity returns the identity to connect to edge routers with.
+func (context *ContextImpl) getEdgeRouterIdentity(id identity.Identity) identity.Identity {
+	return &edgeRouterTlsIdentity{
+		Identity:     id,
+		options:      context.options.EdgeRouterTLS,
+		sessionCache: context.edgeRouterTlsSessions,
+		revocation:   context.edgeRouterRevocation,
+	}
+}
@@ -0,0 +1,141 @@
+package ziti
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openziti/identity"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TLSOptions_apply(t *testing.T) {
+	req := require.New(t)
+
+	config := &tls.Config{NextProtos: []string{"ziti-edge"}}
+	options := &TLSOptions{
+		MinVersion:   tls.VersionTLS13,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		NextProtos:   []string{"h2"},
+	}
+	cache := options.newSessionCache()
+	options.apply(config, cache)
+
+	req.Equal(uint16(tls.VersionTLS13), config.MinVersion)
+	req.Zero(config.MaxVersion)
+	req.Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, config.CipherSuites)
+	req.Equal([]string{"h2", "ziti-edge"}, config.NextProtos)
+	req.Equal(cache, config.ClientSessionCache)
+
+	req.NotNil((*TLSOptions)(nil).newSessionCache(), "resumption is enabled by default")
+	req.Nil((&TLSOptions{SessionCacheSize: -1}).newSessionCache())
+
+	req.Equal(cache, (*TLSOptions)(nil).apply(nil, cache).ClientSessionCache)
+}
+
+func Test_TLSOptions_sessionResumption(t *testing.T) {
+	req := require.New(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.StartTLS()
+	defer server.Close()
+
+	config := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	(&TLSOptions{MinVersion: tls.VersionTLS13}).apply(config, (&TLSOptions{}).newSessionCache())
+
+	var resumed []bool
+	for i := 0; i < 2; i++ {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+		req.NoError(err)
+		// tls 1.3 session tickets arrive after the handshake, with the first read
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _ = conn.Read(make([]byte, 1))
+		req.Equal(uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+		resumed = append(resumed, conn.ConnectionState().DidResume)
+		_ = conn.Close()
+	}
+	req.Equal([]bool{false, true}, resumed)
+}
+
+func Test_TLSOptions_VerifyPeerCertificate(t *testing.T) {
+	req := require.New(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.StartTLS()
+	defer server.Close()
+
+	var calls []bool
+	var reject bool
+	options := &TLSOptions{
+		MinVersion: tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			req.Len(rawCerts, 1)
+			req.Equal(server.Certificate().Raw, rawCerts[0])
+			req.NotEmpty(verifiedChains)
+			calls = append(calls, true)
+			if reject {
+				return errors.New("rejected by test")
+			}
+			return nil
+		},
+	}
+
+	config := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	options.apply(config, options.newSessionCache())
+
+	for i := 0; i < 2; i++ {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+		req.NoError(err)
+		// tls 1.3 session tickets arrive after the handshake, with the first read
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _ = conn.Read(make([]byte, 1))
+		req.Equal(i == 1, conn.ConnectionState().DidResume)
+		_ = conn.Close()
+	}
+	req.Len(calls, 2, "the callback is called for resumed sessions too")
+
+	reject = true
+	_, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+	req.ErrorContains(err, "rejected by test")
+}
+
+func Test_edgeRouterTlsIdentity(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options:               &Options{EdgeRouterTLS: &TLSOptions{MinVersion: tls.VersionTLS13}},
+		edgeRouterTlsSessions: tls.NewLRUClientSessionCache(1),
+	}
+
+	id := ctx.getEdgeRouterIdentity(&tlsTestIdentity{})
+	config := id.ClientTLSConfig()
+	req.Equal(uint16(tls.VersionTLS13), config.MinVersion)
+	req.Equal(ctx.edgeRouterTlsSessions, config.ClientSessionCache)
+	req.Equal("router.example.com", config.ServerName, "the identity's own settings are kept")
+}
+
+func Test_edgeRouterTlsIdentity_noTlsConfig(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		options: &Options{EdgeRouterTLS: &TLSOptions{
+			Pins: []string{"sha256/" + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+		}},
+	}
+
+	config := ctx.getEdgeRouterIdentity(&identity.TokenId{}).ClientTLSConfig()
+	req.NotNil(config, "a configuration is created so that the pins apply")
+	req.NotNil(config.VerifyConnection)
+}
+
+type tlsTestIdentity struct {
+	identity.Identity
+}
+
+func (self *tlsTestIdentity) ClientTLSConfig() *tls.Config {
+	return &tls.Config{ServerName: "router.example.com"}
+}
@@ -0,0 +1,147 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// publicKeyPinPrefix prefixes the pins of the SHA-256 hash of a certificate's subject public key info, which
+	// survive the certificate being reissued with the same key.
+	publicKeyPinPrefix = "sha256/"
+
+	// certificatePinPrefix prefixes the pins of the SHA-256 hash of a whole certificate.
+	certificatePinPrefix = "cert-sha256/"
+)
+
+// PublicKeyPin returns the pin of the certificate's public key, the base64 encoded SHA-256 hash of its subject public
+// key info prefixed with "sha256/", as used by HPKP and curl's --pinnedpubkey. See TLSOptions.Pins.
+func PublicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return publicKeyPinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// CertificatePin returns the pin of the certificate itself, the base64 encoded SHA-256 hash of its DER encoding
+// prefixed with "cert-sha256/". See TLSOptions.Pins.
+func CertificatePin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return certificatePinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// PinMismatchError is returned when the certificates presented by a server match none of the configured pins.
+type PinMismatchError struct {
+	ServerName string
+
+	// Pins are the configured pins.
+	Pins []string
+
+	// Presented are the public key pins of the certificates presented by the server, leaf first, to compare the
+	// configured pins against.
+	Presented []string
+}
+
+func (self *PinMismatchError) Error() string {
+	return fmt.Sprintf("certificate of %s matches none of the %d configured pins, presented public keys are [%s]",
+		self.ServerName, len(self.Pins), strings.Join(self.Presented, ", "))
+}
+
+// validatePins checks that every pin is a public key or certificate pin holding a SHA-256 hash.
+func validatePins(pins []string) error {
+	for _, pin := range pins {
+		var encoded string
+		if strings.HasPrefix(pin, publicKeyPinPrefix) {
+			encoded = strings.TrimPrefix(pin, publicKeyPinPrefix)
+		} else if strings.HasPrefix(pin, certificatePinPrefix) {
+			encoded = strings.TrimPrefix(pin, certificatePinPrefix)
+		} else {
+			return errors.Errorf("invalid pin '%s', must start with %s or %s", pin, publicKeyPinPrefix, certificatePinPrefix)
+		}
+
+		hash, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(hash) != sha256.Size {
+			return errors.Errorf("invalid pin '%s', must hold a base64 encoded SHA-256 hash", pin)
+		}
+	}
+	return nil
+}
+
+// newPinVerifier returns a tls.Config VerifyConnection callback accepting a connection only if the leaf certificate,
+// or a certificate of a verified chain, matches one of the pins. The chains have been verified against the CA pool
+// by the time it is called.
+func newPinVerifier(pins []string) func(tls.ConnectionState) error {
+	pinned := make(map[string]struct{}, len(pins))
+	for _, pin := range pins {
+		pinned[pin] = struct{}{}
+	}
+
+	matches := func(cert *x509.Certificate) bool {
+		if _, found := pinned[PublicKeyPin(cert)]; found {
+			return true
+		}
+		_, found := pinned[CertificatePin(cert)]
+		return found
+	}
+
+	return func(state tls.ConnectionState) error {
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain {
+				if matches(cert) {
+					return nil
+				}
+			}
+		}
+
+		if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 && matches(state.PeerCertificates[0]) {
+			return nil
+		}
+
+		presented := make([]string, 0, len(state.PeerCertificates))
+		for _, cert := range state.PeerCertificates {
+			presented = append(presented, PublicKeyPin(cert))
+		}
+
+		return &PinMismatchError{
+			ServerName: state.ServerName,
+			Pins:       pins,
+			Presented:  presented,
+		}
+	}
+}
+
+// chainVerifyConnection returns a VerifyConnection callback running first, then next, either of which may be nil.
+func chainVerifyConnection(first, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	if first == nil {
+		return next
+	}
+	if next == nil {
+		return first
+	}
+	return func(state tls.ConnectionState) error {
+		if err := first(state); err != nil {
+			return err
+		}
+		return next(state)
+	}
+}
@@ -0,0 +1,105 @@
+package ziti
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TLSOptions_pins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	serverCert := server.Certificate()
+	otherPin := "sha256/" + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+	dial := func(pins ...string) error {
+		config := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		(&TLSOptions{Pins: pins}).apply(config, nil)
+
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+
+	t.Run("public key pin", func(t *testing.T) {
+		require.NoError(t, dial(PublicKeyPin(serverCert)))
+	})
+
+	t.Run("certificate pin", func(t *testing.T) {
+		require.NoError(t, dial(CertificatePin(serverCert)))
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		require.NoError(t, dial(otherPin, PublicKeyPin(serverCert)))
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		req := require.New(t)
+		err := dial(otherPin)
+		req.Error(err)
+
+		var mismatch *PinMismatchError
+		req.True(errors.As(err, &mismatch))
+		req.Equal([]string{otherPin}, mismatch.Pins)
+		req.Equal([]string{PublicKeyPin(serverCert)}, mismatch.Presented)
+		req.Contains(err.Error(), "matches none of the 1 configured pins")
+	})
+
+	t.Run("chained with existing verification", func(t *testing.T) {
+		req := require.New(t)
+		config := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		called := false
+		config.VerifyConnection = func(tls.ConnectionState) error {
+			called = true
+			return nil
+		}
+		(&TLSOptions{Pins: []string{PublicKeyPin(serverCert)}}).apply(config, nil)
+
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+		req.NoError(err)
+		_ = conn.Close()
+		req.True(called)
+	})
+}
+
+func Test_validatePins(t *testing.T) {
+	req := require.New(t)
+
+	req.NoError(validatePins(nil))
+	req.NoError(validatePins([]string{"sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "cert-sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}))
+	req.ErrorContains(validatePins([]string{"md5/AAAA"}), "must start with")
+	req.ErrorContains(validatePins([]string{"sha256/not-base64"}), "SHA-256 hash")
+	req.ErrorContains(validatePins([]string{"sha256/AAAA"}), "SHA-256 hash")
+}
+
+func Test_TLSOptions_withPins(t *testing.T) {
+	req := require.New(t)
+
+	var options *TLSOptions
+	req.Nil(options.withPins(nil))
+	req.Equal([]string{"a"}, options.withPins([]string{"a"}).Pins)
+
+	options = &TLSOptions{MinVersion: tls.VersionTLS13, Pins: []string{"a"}}
+	merged := options.withPins([]string{"b"})
+	req.Equal([]string{"a", "b"}, merged.Pins)
+	req.Equal(uint16(tls.VersionTLS13), merged.MinVersion)
+	req.Equal([]string{"a"}, options.Pins, "the options should not be modified")
+}
+
+func Test_NewContextWithOpts_invalidPins(t *testing.T) {
+	cfg := &Config{
+		ZtAPI:          "https://localhost/edge/client/v1",
+		Credentials:    edge_apis.NewUpdbCredentials("user", "password"),
+		ControllerPins: []string{"invalid"},
+	}
+	_, err := NewContextWithOpts(cfg, nil)
+	require.ErrorContains(t, err, "invalid controller TLS options")
+}
@@ -0,0 +1,343 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultCRLCacheTtl is how long a fetched CRL is used for when RevocationOptions.CRLCacheTtl is zero. A CRL is
+	// never used past its next update time.
+	DefaultCRLCacheTtl = time.Hour
+
+	// DefaultCRLFetchTimeout is the timeout of CRL downloads when RevocationOptions.HttpClient is not set.
+	DefaultCRLFetchTimeout = 10 * time.Second
+
+	// maxCRLSize is the size of the largest CRL that will be downloaded.
+	maxCRLSize = 16 * 1024 * 1024
+)
+
+// RevocationOptions enables checking that the certificates presented by controllers or edge routers have not been
+// revoked. Each certificate of the verified chain, except the root, is checked against the OCSP response stapled to
+// the handshake, if any, and the CRLs at its http or https distribution points. Certificates neither stapled nor
+// naming a distribution point can't be checked and are accepted, unless RequireOCSPStaple is set. Connections whose
+// certificate chain is not verified, e.g. because of tls.Config.InsecureSkipVerify, are rejected, even with SoftFail.
+// See TLSOptions.Revocation.
+type RevocationOptions struct {
+	// CRL enables downloading and checking the CRLs at the distribution points of the certificates. Downloaded CRLs
+	// are cached per Context.
+	CRL bool
+
+	// OCSP enables checking the OCSP response stapled by the server to the handshake. The leaf certificate is not
+	// checked against its CRLs if the staple shows it as good.
+	OCSP bool
+
+	// RequireOCSPStaple rejects servers that don't staple a current OCSP response. It implies OCSP.
+	RequireOCSPStaple bool
+
+	// SoftFail accepts certificates whose revocation status can't be determined, e.g. because a CRL can't be
+	// downloaded, logging a warning instead. Certificates known to be revoked are always rejected.
+	SoftFail bool
+
+	// CRLCacheTtl is how long a downloaded CRL is used for before being downloaded again. Defaults to
+	// DefaultCRLCacheTtl.
+	CRLCacheTtl time.Duration
+
+	// HttpClient downloads CRLs. Defaults to a client using the proxy from the environment with a timeout of
+	// DefaultCRLFetchTimeout.
+	HttpClient *http.Client
+}
+
+func (self *RevocationOptions) enabled() bool {
+	return self != nil && (self.CRL || self.OCSP || self.RequireOCSPStaple)
+}
+
+func (self *RevocationOptions) getCRLCacheTtl() time.Duration {
+	if self.CRLCacheTtl > 0 {
+		return self.CRLCacheTtl
+	}
+	return DefaultCRLCacheTtl
+}
+
+// RevokedCertificateError is returned when a server presents a revoked certificate.
+type RevokedCertificateError struct {
+	ServerName   string
+	Subject      string
+	SerialNumber *big.Int
+	RevokedAt    time.Time
+
+	// Source is "CRL" or "OCSP".
+	Source string
+}
+
+func (self *RevokedCertificateError) Error() string {
+	return fmt.Sprintf("certificate '%s' with serial %s presented by %s was revoked at %s according to %s",
+		self.Subject, self.SerialNumber.String(), self.ServerName, self.RevokedAt.Format(time.RFC3339), self.Source)
+}
+
+// cachedCRL is a downloaded CRL and the time it may be used until.
+type cachedCRL struct {
+	list    *x509.RevocationList
+	expires time.Time
+}
+
+// revocationChecker checks the certificates of the connections of a Context for revocation, caching the CRLs it
+// downloads.
+type revocationChecker struct {
+	options *RevocationOptions
+	client  *http.Client
+	logger  *logrus.Logger
+
+	lock sync.Mutex
+	crls map[string]*cachedCRL // distribution point url -> crl
+}
+
+// newRevocationChecker returns a checker for the revocation options, or nil if revocation checking is not enabled.
+// Rejections and soft failures are logged to logger, or to pfxlog if it is nil.
+func (self *TLSOptions) newRevocationChecker(logger *logrus.Logger) *revocationChecker {
+	if self == nil || !self.Revocation.enabled() {
+		return nil
+	}
+
+	client := self.Revocation.HttpClient
+	if client == nil {
+		client = &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+			Timeout:   DefaultCRLFetchTimeout,
+		}
+	}
+
+	return &revocationChecker{
+		options: self.Revocation,
+		client:  client,
+		logger:  logger,
+		crls:    map[string]*cachedCRL{},
+	}
+}
+
+// apply makes config check the revocation status of the certificates presented by servers.
+func (self *revocationChecker) apply(config *tls.Config) {
+	if self == nil || config == nil {
+		return
+	}
+	config.VerifyConnection = chainVerifyConnection(config.VerifyConnection, self.verifyConnection)
+}
+
+func (self *revocationChecker) verifyConnection(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 {
+		// chain verification is disabled, so there is no issuer to check revocation with
+		err := errors.Errorf("unable to check revocation of the certificate presented by %s, it was not verified", state.ServerName)
+		newLogger(self.logger).WithError(err).Error("rejecting connection")
+		return err
+	}
+
+	chain := state.VerifiedChains[0]
+	for i := 0; i < len(chain)-1; i++ {
+		var err error
+		if i == 0 {
+			err = self.checkLeaf(state, chain[0], chain[1])
+		} else {
+			err = self.checkCRLs(state.ServerName, chain[i], chain[i+1])
+		}
+
+		if err == nil {
+			continue
+		}
+
+		var revokedErr *RevokedCertificateError
+		if errors.As(err, &revokedErr) || !self.options.SoftFail {
+			return err
+		}
+
+		newLogger(self.logger).WithError(err).WithField("serverName", state.ServerName).
+			Warn("unable to determine certificate revocation status, accepting certificate")
+	}
+
+	return nil
+}
+
+// checkLeaf checks the leaf certificate against the stapled OCSP response, falling back to its CRLs if there is no
+// current staple.
+func (self *revocationChecker) checkLeaf(state tls.ConnectionState, leaf, issuer *x509.Certificate) error {
+	if self.options.OCSP || self.options.RequireOCSPStaple {
+		good, err := self.checkStaple(state, leaf, issuer)
+		if err != nil || good {
+			return err
+		}
+	}
+	return self.checkCRLs(state.ServerName, leaf, issuer)
+}
+
+// checkStaple returns true if the stapled OCSP response shows the leaf certificate as good, and an error if it shows
+// it as revoked, or if no valid staple is present and one is required.
+func (self *revocationChecker) checkStaple(state tls.ConnectionState, leaf, issuer *x509.Certificate) (bool, error) {
+	if len(state.OCSPResponse) == 0 {
+		if self.options.RequireOCSPStaple {
+			return false, errors.Errorf("%s did not staple an OCSP response for certificate '%s'", state.ServerName, leaf.Subject)
+		}
+		return false, nil
+	}
+
+	response, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid OCSP response stapled by %s", state.ServerName)
+	}
+
+	if !response.NextUpdate.IsZero() && time.Now().After(response.NextUpdate) {
+		if self.options.RequireOCSPStaple {
+			return false, errors.Errorf("OCSP response stapled by %s expired at %s", state.ServerName,
+				response.NextUpdate.Format(time.RFC3339))
+		}
+		return false, nil
+	}
+
+	switch response.Status {
+	case ocsp.Good:
+		return true, nil
+	case ocsp.Revoked:
+		return false, &RevokedCertificateError{
+			ServerName:   state.ServerName,
+			Subject:      leaf.Subject.String(),
+			SerialNumber: leaf.SerialNumber,
+			RevokedAt:    response.RevokedAt,
+			Source:       "OCSP",
+		}
+	default:
+		if self.options.RequireOCSPStaple {
+			return false, errors.Errorf("OCSP response stapled by %s has unknown status for certificate '%s'",
+				state.ServerName, leaf.Subject)
+		}
+		return false, nil
+	}
+}
+
+// checkCRLs checks the certificate against the CRLs at its http and https distribution points.
+func (self *revocationChecker) checkCRLs(serverName string, cert, issuer *x509.Certificate) error {
+	if !self.options.CRL {
+		return nil
+	}
+
+	var lastErr error
+	for _, url := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+
+		list, err := self.getCRL(url, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, entry := range list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return &RevokedCertificateError{
+					ServerName:   serverName,
+					Subject:      cert.Subject.String(),
+					SerialNumber: cert.SerialNumber,
+					RevokedAt:    entry.RevocationTime,
+					Source:       "CRL",
+				}
+			}
+		}
+		return nil
+	}
+
+	return lastErr
+}
+
+// getCRL returns the CRL at the url signed by issuer, downloading it if it isn't cached or its cache entry has
+// expired.
+func (self *revocationChecker) getCRL(url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	self.lock.Lock()
+	cached := self.crls[url]
+	self.lock.Unlock()
+
+	if cached == nil || time.Now().After(cached.expires) {
+		list, err := self.fetchCRL(url)
+		if err != nil {
+			return nil, err
+		}
+
+		expires := time.Now().Add(self.options.getCRLCacheTtl())
+		if !list.NextUpdate.IsZero() && list.NextUpdate.Before(expires) {
+			expires = list.NextUpdate
+		}
+		cached = &cachedCRL{
+			list:    list,
+			expires: expires,
+		}
+
+		self.lock.Lock()
+		self.crls[url] = cached
+		self.lock.Unlock()
+	}
+
+	if err := cached.list.CheckSignatureFrom(issuer); err != nil {
+		return nil, errors.Wrapf(err, "CRL at %s is not signed by '%s'", url, issuer.Subject)
+	}
+
+	if !cached.list.NextUpdate.IsZero() && time.Now().After(cached.list.NextUpdate) {
+		return nil, errors.Errorf("CRL at %s expired at %s", url, cached.list.NextUpdate.Format(time.RFC3339))
+	}
+
+	return cached.list, nil
+}
+
+func (self *revocationChecker) fetchCRL(url string) (*x509.RevocationList, error) {
+	resp, err := self.client.Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to download CRL from %s", url)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unable to download CRL from %s, status %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to download CRL from %s", url)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("-----BEGIN")) {
+		if block, _ := pem.Decode(bytes.TrimSpace(body)); block != nil {
+			body = block.Bytes
+		}
+	}
+
+	list, err := x509.ParseRevocationList(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse CRL from %s", url)
+	}
+	return list, nil
+}
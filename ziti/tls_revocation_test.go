@@ -0,0 +1,221 @@
+package ziti
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+type revocationTestPki struct {
+	req       *require.Assertions
+	caKey     *ecdsa.PrivateKey
+	ca        *x509.Certificate
+	leafKey   *ecdsa.PrivateKey
+	leaf      *x509.Certificate
+	revoked   []x509.RevocationListEntry
+	crlServer *httptest.Server
+	fetches   atomic.Int32
+}
+
+func newRevocationTestPki(t *testing.T) *revocationTestPki {
+	req := require.New(t)
+	result := &revocationTestPki{req: req}
+
+	result.crlServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result.fetches.Add(1)
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(int64(result.fetches.Load())),
+			ThisUpdate:                time.Now().Add(-time.Minute),
+			NextUpdate:                time.Now().Add(time.Hour),
+			RevokedCertificateEntries: result.revoked,
+		}, result.ca, result.caKey)
+		req.NoError(err)
+		_, _ = w.Write(crl)
+	}))
+	t.Cleanup(result.crlServer.Close)
+
+	var err error
+	result.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "revocation test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &result.caKey.PublicKey, result.caKey)
+	req.NoError(err)
+	result.ca, err = x509.ParseCertificate(caDer)
+	req.NoError(err)
+
+	result.leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	leafDer, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "router.example.com"},
+		DNSNames:              []string{"router.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		CRLDistributionPoints: []string{result.crlServer.URL + "/crl"},
+	}, result.ca, &result.leafKey.PublicKey, result.caKey)
+	req.NoError(err)
+	result.leaf, err = x509.ParseCertificate(leafDer)
+	req.NoError(err)
+
+	return result
+}
+
+func (self *revocationTestPki) revokeLeaf() {
+	self.revoked = append(self.revoked, x509.RevocationListEntry{
+		SerialNumber:   self.leaf.SerialNumber,
+		RevocationTime: time.Now().Add(-time.Minute),
+	})
+}
+
+func (self *revocationTestPki) staple(status int) []byte {
+	response, err := ocsp.CreateResponse(self.ca, self.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: self.leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, crypto.Signer(self.caKey))
+	self.req.NoError(err)
+	return response
+}
+
+func (self *revocationTestPki) state(staple []byte) tls.ConnectionState {
+	return tls.ConnectionState{
+		ServerName:     "router.example.com",
+		VerifiedChains: [][]*x509.Certificate{{self.leaf, self.ca}},
+		OCSPResponse:   staple,
+	}
+}
+
+func Test_revocationChecker_crl(t *testing.T) {
+	req := require.New(t)
+	testPki := newRevocationTestPki(t)
+
+	checker := (&TLSOptions{Revocation: &RevocationOptions{CRL: true}}).newRevocationChecker(nil)
+	req.NoError(checker.verifyConnection(testPki.state(nil)))
+	req.NoError(checker.verifyConnection(testPki.state(nil)))
+	req.Equal(int32(1), testPki.fetches.Load(), "the crl is cached")
+
+	testPki.revokeLeaf()
+	req.NoError(checker.verifyConnection(testPki.state(nil)), "the cached crl predates the revocation")
+
+	checker = (&TLSOptions{Revocation: &RevocationOptions{CRL: true}}).newRevocationChecker(nil)
+	err := checker.verifyConnection(testPki.state(nil))
+	var revokedErr *RevokedCertificateError
+	req.True(errors.As(err, &revokedErr), "unexpected error: %v", err)
+	req.Equal("CRL", revokedErr.Source)
+	req.Equal(testPki.leaf.SerialNumber, revokedErr.SerialNumber)
+}
+
+func Test_revocationChecker_crlUnavailable(t *testing.T) {
+	req := require.New(t)
+	testPki := newRevocationTestPki(t)
+	testPki.crlServer.Close()
+
+	checker := (&TLSOptions{Revocation: &RevocationOptions{CRL: true}}).newRevocationChecker(nil)
+	req.Error(checker.verifyConnection(testPki.state(nil)))
+
+	checker = (&TLSOptions{Revocation: &RevocationOptions{CRL: true, SoftFail: true}}).newRevocationChecker(nil)
+	req.NoError(checker.verifyConnection(testPki.state(nil)))
+}
+
+func Test_revocationChecker_ocspStaple(t *testing.T) {
+	req := require.New(t)
+	testPki := newRevocationTestPki(t)
+	testPki.revokeLeaf()
+
+	checker := (&TLSOptions{Revocation: &RevocationOptions{OCSP: true, CRL: true}}).newRevocationChecker(nil)
+	req.NoError(checker.verifyConnection(testPki.state(testPki.staple(ocsp.Good))))
+	req.Zero(testPki.fetches.Load(), "a good staple makes the crl check of the leaf unnecessary")
+
+	err := checker.verifyConnection(testPki.state(testPki.staple(ocsp.Revoked)))
+	var revokedErr *RevokedCertificateError
+	req.True(errors.As(err, &revokedErr), "unexpected error: %v", err)
+	req.Equal("OCSP", revokedErr.Source)
+
+	checker = (&TLSOptions{Revocation: &RevocationOptions{RequireOCSPStaple: true, SoftFail: true}}).newRevocationChecker(nil)
+	req.NoError(checker.verifyConnection(testPki.state(nil)), "soft fail accepts a missing staple")
+	req.Error(checker.verifyConnection(testPki.state(testPki.staple(ocsp.Revoked))), "soft fail never accepts a revoked certificate")
+
+	checker = (&TLSOptions{Revocation: &RevocationOptions{RequireOCSPStaple: true}}).newRevocationChecker(nil)
+	req.Error(checker.verifyConnection(testPki.state(nil)))
+	req.Error(checker.verifyConnection(testPki.state([]byte("garbage"))))
+}
+
+func Test_revocationChecker_handshake(t *testing.T) {
+	req := require.New(t)
+	testPki := newRevocationTestPki(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{testPki.leaf.Raw},
+			PrivateKey:  testPki.leafKey,
+			OCSPStaple:  testPki.staple(ocsp.Revoked),
+		}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(testPki.ca)
+	config := &tls.Config{RootCAs: pool, ServerName: "router.example.com"}
+
+	req.Nil((&TLSOptions{Revocation: &RevocationOptions{}}).newRevocationChecker(nil), "no check is enabled")
+	(&TLSOptions{Revocation: &RevocationOptions{OCSP: true}}).newRevocationChecker(nil).apply(config)
+
+	_, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+	req.Error(err)
+	req.Contains(err.Error(), "revoked")
+}
+
+func Test_edgeRouterTlsIdentity_revocation(t *testing.T) {
+	req := require.New(t)
+
+	options := &TLSOptions{Revocation: &RevocationOptions{CRL: true}}
+	ctx := &ContextImpl{
+		options:              &Options{EdgeRouterTLS: options},
+		edgeRouterRevocation: options.newRevocationChecker(nil),
+	}
+
+	config := ctx.getEdgeRouterIdentity(&tlsTestIdentity{}).ClientTLSConfig()
+	req.NotNil(config.VerifyConnection)
+}
+
+func Test_revocationChecker_unverifiedChain(t *testing.T) {
+	req := require.New(t)
+
+	buf := &bytes.Buffer{}
+	logger := newHandlerLogger(slog.NewTextHandler(buf, nil))
+
+	checker := (&TLSOptions{Revocation: &RevocationOptions{CRL: true, SoftFail: true}}).newRevocationChecker(logger)
+	err := checker.verifyConnection(tls.ConnectionState{ServerName: "router.example.com"})
+	req.ErrorContains(err, "it was not verified")
+	req.Contains(buf.String(), "level=ERROR msg=\"rejecting connection\"")
+}
@@ -0,0 +1,211 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openziti/foundation/v2/errorz"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// Socket activation passes listening sockets to a process as the file descriptors following stderr, announced by the
+// LISTEN_FDS, LISTEN_FDNAMES and LISTEN_PID environment variables, as described in sd_listen_fds(3).
+const (
+	EnvListenFds     = "LISTEN_FDS"
+	EnvListenFdNames = "LISTEN_FDNAMES"
+	EnvListenPid     = "LISTEN_PID"
+
+	listenFdsStart = 3
+)
+
+// ActivatedListener is a listening socket passed to the process by socket activation.
+type ActivatedListener struct {
+	net.Listener
+
+	// Name is the FileDescriptorName of the socket unit, or "unknown" if the socket wasn't named.
+	Name string
+}
+
+// ActivationListeners returns the listening sockets passed to the process by systemd socket activation, or by a
+// parent process using ActivationEnv, in order. It returns no listeners if none were passed, or if LISTEN_PID names
+// another process. The activation environment variables are unset, so that they aren't inherited by child processes.
+func ActivationListeners() ([]*ActivatedListener, error) {
+	fdsValue := os.Getenv(EnvListenFds)
+	pidValue := os.Getenv(EnvListenPid)
+	namesValue := os.Getenv(EnvListenFdNames)
+
+	_ = os.Unsetenv(EnvListenFds)
+	_ = os.Unsetenv(EnvListenPid)
+	_ = os.Unsetenv(EnvListenFdNames)
+
+	if fdsValue == "" {
+		return nil, nil
+	}
+
+	if pidValue != "" {
+		pid, err := strconv.Atoi(pidValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s %q", EnvListenPid, pidValue)
+		}
+		if pid != os.Getpid() {
+			return nil, nil
+		}
+	}
+
+	count, err := strconv.Atoi(fdsValue)
+	if err != nil || count < 0 {
+		return nil, errors.Errorf("invalid %s %q", EnvListenFds, fdsValue)
+	}
+
+	var names []string
+	if namesValue != "" {
+		names = strings.Split(namesValue, ":")
+	}
+
+	var result []*ActivatedListener
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			for _, activated := range result {
+				_ = activated.Close()
+			}
+			return nil, errors.Wrapf(err, "activated file descriptor %d (%s) is not a listening socket", listenFdsStart+i, name)
+		}
+		result = append(result, &ActivatedListener{Listener: listener, Name: name})
+	}
+	return result, nil
+}
+
+// ForwardActivated returns Proxies forwarding the connections accepted by the socket activated listeners to the
+// services dialed with ztx, which services maps the listener names to. Listeners without a service are closed, and
+// an error is returned if a service has no listener. Each Proxy starts forwarding once Serve is called.
+func ForwardActivated(ztx ziti.Context, listeners []*ActivatedListener, services map[string]string, options *Options) ([]*Proxy, error) {
+	var result []*Proxy
+	forwarded := map[string]bool{}
+	for _, listener := range listeners {
+		serviceName, found := services[listener.Name]
+		if !found {
+			_ = listener.Close()
+			continue
+		}
+		forwarded[listener.Name] = true
+		result = append(result, New(listener, ziti.ServiceDialer(ztx, serviceName), options))
+	}
+
+	for name, serviceName := range services {
+		if !forwarded[name] {
+			for _, proxy := range result {
+				_ = proxy.Close()
+			}
+			return nil, errors.Errorf("no activated listener named %s for service %s", name, serviceName)
+		}
+	}
+	return result, nil
+}
+
+// ActivationEnv returns the environment variables announcing files passed to a child process in exec.Cmd.ExtraFiles
+// as activated sockets with the given names, to be picked up with ActivationListeners. LISTEN_PID is not set, as the
+// pid of the child isn't known before it is started.
+func ActivationEnv(names ...string) []string {
+	return []string{
+		fmt.Sprintf("%s=%d", EnvListenFds, len(names)),
+		fmt.Sprintf("%s=%s", EnvListenFdNames, strings.Join(names, ":")),
+	}
+}
+
+// ExportedListener proxies the connections accepted by a Ziti listener to a local listening socket, which can be
+// inherited by child processes. The ExportedListener outlives the child processes accepting from the socket, so that
+// clients can keep connecting while the child is restarted: their connections queue until the new child accepts
+// them, and the service stays bound throughout.
+type ExportedListener struct {
+	*Proxy
+	local net.Listener
+	file  *os.File
+	dir   string
+}
+
+// Export returns an ExportedListener for listener, typically returned by ziti.Context.Listen, serving connections in
+// the background. Pass File to child processes, e.g. with exec.Cmd.ExtraFiles and ActivationEnv.
+func Export(listener net.Listener, options *Options) (*ExportedListener, error) {
+	dir, err := os.MkdirTemp("", "ziti-export-")
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := net.Listen("unix", filepath.Join(dir, "listener.sock"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	file, err := local.(*net.UnixListener).File()
+	if err != nil {
+		_ = local.Close()
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrap(err, "unable to get file of exported listener")
+	}
+
+	result := &ExportedListener{
+		Proxy: New(listener, AddressDialer("unix", local.Addr().String()), options),
+		local: local,
+		file:  file,
+		dir:   dir,
+	}
+	go func() { _ = result.Serve() }()
+	return result, nil
+}
+
+// File returns the file of the local listening socket, for passing to child processes. It remains owned by the
+// ExportedListener and is closed by Close.
+func (self *ExportedListener) File() *os.File {
+	return self.file
+}
+
+// LocalAddr returns the address of the local listening socket.
+func (self *ExportedListener) LocalAddr() net.Addr {
+	return self.local.Addr()
+}
+
+// Close closes the Ziti listener, the connections being proxied and the local listening socket.
+func (self *ExportedListener) Close() error {
+	var errs errorz.MultipleErrors
+	if err := self.Proxy.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := self.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		errs = append(errs, err)
+	}
+	if err := self.local.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		errs = append(errs, err)
+	}
+	_ = os.RemoveAll(self.dir)
+	return errs.ToError()
+}
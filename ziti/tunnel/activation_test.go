@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const activationChildEnv = "ZITI_TEST_ACTIVATION_CHILD"
+
+func Test_ActivationListeners_otherPid(t *testing.T) {
+	req := require.New(t)
+
+	t.Setenv(EnvListenFds, "1")
+	t.Setenv(EnvListenFdNames, "app")
+	t.Setenv(EnvListenPid, strconv.Itoa(os.Getpid()+1))
+
+	listeners, err := ActivationListeners()
+	req.NoError(err)
+	req.Empty(listeners)
+	req.Empty(os.Getenv(EnvListenFds), "activation variables are unset")
+}
+
+func Test_ActivationListeners_invalid(t *testing.T) {
+	t.Setenv(EnvListenFds, "many")
+	_, err := ActivationListeners()
+	require.Error(t, err)
+}
+
+func Test_Export(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "js" {
+		t.Skip("listening sockets can't be inherited")
+	}
+	req := require.New(t)
+
+	// a TCP listener stands in for the Ziti listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+
+	exported, err := Export(listener, nil)
+	req.NoError(err)
+	defer func() { _ = exported.Close() }()
+
+	cmd := exec.Command(os.Args[0], "-test.run=Test_activationChild")
+	cmd.Env = append(os.Environ(), activationChildEnv+"=1")
+	cmd.Env = append(cmd.Env, ActivationEnv("app")...)
+	cmd.ExtraFiles = []*os.File{exported.File()}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	req.NoError(cmd.Start())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	req.NoError(err)
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+	req.NoError(conn.(*net.TCPConn).CloseWrite())
+
+	body, err := io.ReadAll(conn)
+	req.NoError(err)
+	req.Equal("hello", string(body))
+	_ = conn.Close()
+
+	req.NoError(cmd.Wait())
+}
+
+// Test_activationChild runs as the child process of Test_Export, echoing one connection accepted from the inherited
+// listener.
+func Test_activationChild(t *testing.T) {
+	if os.Getenv(activationChildEnv) == "" {
+		t.Skip("only run as a child of Test_Export")
+	}
+	req := require.New(t)
+
+	listeners, err := ActivationListeners()
+	req.NoError(err)
+	req.Len(listeners, 1)
+	req.Equal("app", listeners[0].Name)
+
+	conn, err := listeners[0].Accept()
+	req.NoError(err)
+	_, err = io.Copy(conn, conn)
+	req.NoError(err)
+	req.NoError(conn.Close())
+	req.NoError(listeners[0].Close())
+}
@@ -0,0 +1,449 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+const DefaultHandshakeTimeout = 10 * time.Second
+
+const (
+	socks5Version         = 0x05
+	socks5AuthVersion     = 0x01
+	socks5AuthNone        = 0x00
+	socks5AuthPassword    = 0x02
+	socks5AuthUnavailable = 0xff
+	socks5CmdConnect      = 0x01
+	socks5AddrIPv4        = 0x01
+	socks5AddrDomain      = 0x03
+	socks5AddrIPv6        = 0x04
+
+	socks5Succeeded               = 0x00
+	socks5GeneralFailure          = 0x01
+	socks5NotAllowed              = 0x02
+	socks5HostUnreachable         = 0x04
+	socks5CommandNotSupported     = 0x07
+	socks5AddressTypeNotSupported = 0x08
+)
+
+// Rule allows an ingress proxy to forward connections to matching destinations.
+type Rule struct {
+	// Host matches the destination host: a host name or IP address, "*.example.com" for any subdomain of
+	// example.com, a CIDR such as "10.0.0.0/8" for IP destinations, or "*" for any destination. Host names match
+	// case-insensitively.
+	Host string
+
+	// Ports restricts the destination ports. Empty allows any port.
+	Ports []uint16
+}
+
+// Matches returns true if the rule allows host and port.
+func (self *Rule) Matches(host string, port uint16) bool {
+	if len(self.Ports) > 0 {
+		found := false
+		for _, p := range self.Ports {
+			if p == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	pattern := strings.ToLower(self.Host)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	if strings.Contains(pattern, "/") {
+		address, err := edge.NewZitiAddress(pattern)
+		return err == nil && address.Matches(host) != -1
+	}
+	if ip, ok := edge.ParseHostIP(pattern); ok {
+		hostIp, isIp := edge.ParseHostIP(host)
+		return isIp && hostIp == ip
+	}
+	return host == pattern
+}
+
+// IngressOptions configures an ingress proxy.
+type IngressOptions struct {
+	Options
+
+	// Rules are the destinations the proxy forwards. Destinations no rule matches are refused, so an empty list
+	// refuses every connection.
+	Rules []Rule
+
+	// Username and Password, if set, are required from clients, as SOCKS5 username/password authentication or as
+	// basic Proxy-Authorization of HTTP CONNECT requests.
+	Username string
+	Password string
+
+	// HandshakeTimeout bounds reading the SOCKS5 or HTTP CONNECT request. Defaults to DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+}
+
+func (self *IngressOptions) getHandshakeTimeout() time.Duration {
+	if self.HandshakeTimeout <= 0 {
+		return DefaultHandshakeTimeout
+	}
+	return self.HandshakeTimeout
+}
+
+func (self *IngressOptions) allows(host string, port uint16) bool {
+	for i := range self.Rules {
+		if self.Rules[i].Matches(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectedError is returned for ingress connections which are refused before dialing.
+type rejectedError struct {
+	err error
+}
+
+func (self *rejectedError) Error() string {
+	return self.err.Error()
+}
+
+func (self *rejectedError) Unwrap() error {
+	return self.err
+}
+
+func rejected(format string, args ...interface{}) error {
+	return &rejectedError{err: errors.Errorf(format, args...)}
+}
+
+// NewIngress returns a Proxy which accepts SOCKS5 and HTTP CONNECT requests from listener and forwards the
+// destinations allowed by the rules of options with dialer, once Serve is called. Use ziti.NewAddressDialer to
+// forward with a single Context, or CtxCollection.NewDialer to forward with whichever Context of a collection
+// intercepts the destination. The Proxy takes ownership of listener.
+func NewIngress(listener net.Listener, dialer ziti.Dialer, options *IngressOptions) *Proxy {
+	if options == nil {
+		options = &IngressOptions{}
+	}
+	ingress := &ingress{
+		dialer:  dialer,
+		options: options,
+	}
+	return newProxy(listener, ingress.connect, &options.Options)
+}
+
+// ListenIngress listens on addr on network, e.g. "tcp" "127.0.0.1:1080", and returns an ingress proxy for it. See
+// NewIngress.
+func ListenIngress(network, addr string, dialer ziti.Dialer, options *IngressOptions) (*Proxy, error) {
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewIngress(listener, dialer, options), nil
+}
+
+type ingress struct {
+	dialer  ziti.Dialer
+	options *IngressOptions
+}
+
+func (self *ingress) connect(ctx context.Context, conn net.Conn) (net.Conn, net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(self.options.getHandshakeTimeout())); err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, nil, &rejectedError{err: errors.Wrap(err, "unable to read ingress handshake")}
+	}
+
+	var target net.Conn
+	if first[0] == socks5Version {
+		target, err = self.connectSocks5(ctx, conn, reader)
+	} else {
+		target, err = self.connectHttp(ctx, conn, reader)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		_ = target.Close()
+		return nil, nil, err
+	}
+	return &bufferedConn{Conn: conn, reader: reader}, target, nil
+}
+
+func (self *ingress) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if ctxDialer, ok := self.dialer.(ziti.ContextDialer); ok {
+		return ctxDialer.DialContext(ctx, "tcp", addr)
+	}
+	return self.dialer.Dial("tcp", addr)
+}
+
+func (self *ingress) connectSocks5(ctx context.Context, conn net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, &rejectedError{err: errors.Wrap(err, "unable to read socks5 greeting")}
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, &rejectedError{err: errors.Wrap(err, "unable to read socks5 auth methods")}
+	}
+
+	method := byte(socks5AuthNone)
+	if self.options.Username != "" || self.options.Password != "" {
+		method = socks5AuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		if m == method {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthUnavailable})
+		return nil, rejected("socks5 client offered no acceptable auth method")
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return nil, err
+	}
+
+	if method == socks5AuthPassword {
+		if err := self.authenticateSocks5(conn, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return nil, &rejectedError{err: errors.Wrap(err, "unable to read socks5 request")}
+	}
+	if request[0] != socks5Version {
+		return nil, rejected("unsupported socks version %d", request[0])
+	}
+
+	host, err := readSocks5Host(reader, request[3])
+	if err != nil {
+		writeSocks5Reply(conn, socks5AddressTypeNotSupported)
+		return nil, err
+	}
+	portBytes := make([]byte, 2)
+	if _, err = io.ReadFull(reader, portBytes); err != nil {
+		return nil, &rejectedError{err: errors.Wrap(err, "unable to read socks5 destination port")}
+	}
+	port := binary.BigEndian.Uint16(portBytes)
+
+	if request[1] != socks5CmdConnect {
+		writeSocks5Reply(conn, socks5CommandNotSupported)
+		return nil, rejected("unsupported socks5 command %d", request[1])
+	}
+
+	if !self.options.allows(host, port) {
+		writeSocks5Reply(conn, socks5NotAllowed)
+		return nil, rejected("destination %s:%d is not allowed", host, port)
+	}
+
+	target, err := self.dial(ctx, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		writeSocks5Reply(conn, socks5HostUnreachable)
+		return nil, err
+	}
+
+	if err = writeSocks5Reply(conn, socks5Succeeded); err != nil {
+		_ = target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+func (self *ingress) authenticateSocks5(conn net.Conn, reader *bufio.Reader) error {
+	readField := func() (string, error) {
+		size, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		field := make([]byte, size)
+		_, err = io.ReadFull(reader, field)
+		return string(field), err
+	}
+
+	version, err := reader.ReadByte()
+	if err != nil {
+		return &rejectedError{err: errors.Wrap(err, "unable to read socks5 credentials")}
+	}
+	username, err := readField()
+	if err != nil {
+		return &rejectedError{err: errors.Wrap(err, "unable to read socks5 credentials")}
+	}
+	password, err := readField()
+	if err != nil {
+		return &rejectedError{err: errors.Wrap(err, "unable to read socks5 credentials")}
+	}
+
+	if version != socks5AuthVersion || username != self.options.Username || password != self.options.Password {
+		_, _ = conn.Write([]byte{socks5AuthVersion, 0x01})
+		return rejected("invalid socks5 credentials for user '%s'", username)
+	}
+	_, err = conn.Write([]byte{socks5AuthVersion, 0x00})
+	return err
+}
+
+func readSocks5Host(reader *bufio.Reader, addrType byte) (string, error) {
+	switch addrType {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		size := net.IPv4len
+		if addrType == socks5AddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", &rejectedError{err: errors.Wrap(err, "unable to read socks5 destination address")}
+		}
+		return net.IP(ip).String(), nil
+	case socks5AddrDomain:
+		size, err := reader.ReadByte()
+		if err != nil {
+			return "", &rejectedError{err: errors.Wrap(err, "unable to read socks5 destination address")}
+		}
+		domain := make([]byte, size)
+		if _, err = io.ReadFull(reader, domain); err != nil {
+			return "", &rejectedError{err: errors.Wrap(err, "unable to read socks5 destination address")}
+		}
+		return string(domain), nil
+	default:
+		return "", rejected("unsupported socks5 address type %d", addrType)
+	}
+}
+
+func writeSocks5Reply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socks5Version, reply, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func (self *ingress) connectHttp(ctx context.Context, conn net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, &rejectedError{err: errors.Wrap(err, "unable to read http proxy request")}
+	}
+
+	if req.Method != http.MethodConnect {
+		writeHttpReply(conn, http.StatusMethodNotAllowed, nil)
+		return nil, rejected("unsupported http proxy method %s", req.Method)
+	}
+
+	if self.options.Username != "" || self.options.Password != "" {
+		if !self.checkProxyAuthorization(req.Header.Get("Proxy-Authorization")) {
+			writeHttpReply(conn, http.StatusProxyAuthRequired, http.Header{"Proxy-Authenticate": []string{`Basic realm="ziti"`}})
+			return nil, rejected("invalid http proxy credentials")
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		writeHttpReply(conn, http.StatusBadRequest, nil)
+		return nil, &rejectedError{err: errors.Wrapf(err, "invalid http connect destination '%s'", req.Host)}
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		writeHttpReply(conn, http.StatusBadRequest, nil)
+		return nil, &rejectedError{err: errors.Wrapf(err, "invalid http connect destination '%s'", req.Host)}
+	}
+
+	if !self.options.allows(host, uint16(port)) {
+		writeHttpReply(conn, http.StatusForbidden, nil)
+		return nil, rejected("destination %s is not allowed", req.Host)
+	}
+
+	target, err := self.dial(ctx, req.Host)
+	if err != nil {
+		writeHttpReply(conn, http.StatusBadGateway, nil)
+		return nil, err
+	}
+
+	if err = writeHttpReply(conn, http.StatusOK, nil); err != nil {
+		_ = target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+func (self *ingress) checkProxyAuthorization(value string) bool {
+	encoded, found := strings.CutPrefix(value, "Basic ")
+	if !found {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	username, password, found := strings.Cut(string(decoded), ":")
+	return found && username == self.options.Username && password == self.options.Password
+}
+
+func writeHttpReply(conn net.Conn, status int, header http.Header) error {
+	reply := fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	for key, values := range header {
+		for _, value := range values {
+			reply += key + ": " + value + "\r\n"
+		}
+	}
+	if status != http.StatusOK {
+		reply += "Content-Length: 0\r\nConnection: close\r\n"
+	}
+	_, err := io.WriteString(conn, reply+"\r\n")
+	return err
+}
+
+// bufferedConn reads what was buffered while reading the handshake before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (self *bufferedConn) Read(p []byte) (int, error) {
+	return self.reader.Read(p)
+}
+
+func (self *bufferedConn) CloseWrite() error {
+	if closeWriter, ok := self.Conn.(edge.CloseWriter); ok {
+		return closeWriter.CloseWrite()
+	}
+	return self.Conn.Close()
+}
@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func Test_Rule_Matches(t *testing.T) {
+	req := require.New(t)
+
+	req.True((&Rule{Host: "*"}).Matches("anything", 1))
+	req.True((&Rule{Host: "db.internal"}).Matches("DB.internal", 5432))
+	req.False((&Rule{Host: "db.internal"}).Matches("cache.internal", 5432))
+	req.True((&Rule{Host: "*.internal"}).Matches("db.internal", 5432))
+	req.False((&Rule{Host: "*.internal"}).Matches("internal", 5432))
+	req.True((&Rule{Host: "10.0.0.0/8"}).Matches("10.1.2.3", 80))
+	req.False((&Rule{Host: "10.0.0.0/8"}).Matches("11.1.2.3", 80))
+	req.True((&Rule{Host: "2001:db8::/32"}).Matches("[2001:db8::1]", 80))
+	req.False((&Rule{Host: "2001:db8::/32"}).Matches("2001:db9::1", 80))
+	req.True((&Rule{Host: "[2001:db8::1]"}).Matches("2001:DB8:0::1", 80))
+	req.True((&Rule{Host: "*", Ports: []uint16{80, 443}}).Matches("web", 443))
+	req.False((&Rule{Host: "*", Ports: []uint16{80, 443}}).Matches("web", 8080))
+}
+
+func startIngress(t *testing.T, options *IngressOptions) *Proxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ingress := NewIngress(listener, &net.Dialer{}, options)
+	go func() { _ = ingress.Serve() }()
+	t.Cleanup(func() { _ = ingress.Close() })
+	return ingress
+}
+
+func Test_Ingress(t *testing.T) {
+	echo := startEcho(t)
+	echoHost, echoPortStr, err := net.SplitHostPort(echo.Addr().String())
+	require.NoError(t, err)
+	echoPort, err := strconv.Atoi(echoPortStr)
+	require.NoError(t, err)
+
+	options := &IngressOptions{
+		Rules:    []Rule{{Host: echoHost, Ports: []uint16{uint16(echoPort)}}},
+		Username: "user",
+		Password: "secret",
+	}
+
+	exchange := func(t *testing.T, conn net.Conn) {
+		_, err := io.WriteString(conn, "hello\n")
+		require.NoError(t, err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "hello\n", line)
+	}
+
+	t.Run("socks5", func(t *testing.T) {
+		req := require.New(t)
+		ingress := startIngress(t, options)
+
+		dialer, err := proxy.SOCKS5("tcp", ingress.Addr().String(), &proxy.Auth{User: "user", Password: "secret"}, proxy.Direct)
+		req.NoError(err)
+
+		conn, err := dialer.Dial("tcp", echo.Addr().String())
+		req.NoError(err)
+		exchange(t, conn)
+		_ = conn.Close()
+
+		_, err = dialer.Dial("tcp", net.JoinHostPort(echoHost, "1"))
+		req.Error(err)
+
+		badAuth, err := proxy.SOCKS5("tcp", ingress.Addr().String(), &proxy.Auth{User: "user", Password: "wrong"}, proxy.Direct)
+		req.NoError(err)
+		_, err = badAuth.Dial("tcp", echo.Addr().String())
+		req.Error(err)
+
+		req.Eventually(func() bool { return ingress.Stats().Rejected == 2 }, time.Second, 10*time.Millisecond)
+		req.Equal(uint64(3), ingress.Stats().Accepted)
+	})
+
+	t.Run("http connect", func(t *testing.T) {
+		req := require.New(t)
+		ingress := startIngress(t, options)
+
+		connect := func(target, credentials string) (net.Conn, *http.Response) {
+			conn, err := net.Dial("tcp", ingress.Addr().String())
+			req.NoError(err)
+			request := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+			if credentials != "" {
+				request += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)) + "\r\n"
+			}
+			_, err = io.WriteString(conn, request+"\r\n")
+			req.NoError(err)
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			req.NoError(err)
+			return conn, resp
+		}
+
+		conn, resp := connect(echo.Addr().String(), "user:secret")
+		req.Equal(http.StatusOK, resp.StatusCode)
+		exchange(t, conn)
+		_ = conn.Close()
+
+		conn, resp = connect(echo.Addr().String(), "")
+		req.Equal(http.StatusProxyAuthRequired, resp.StatusCode)
+		_ = conn.Close()
+
+		conn, resp = connect(net.JoinHostPort(echoHost, "1"), "user:secret")
+		req.Equal(http.StatusForbidden, resp.StatusCode)
+		_ = conn.Close()
+	})
+}
@@ -0,0 +1,371 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package tunnel provides the primitives of a tunneler for embedding in applications: hosting a service by proxying
+// the connections accepted for it to a local TCP or unix address, and intercepting a local address by proxying the
+// connections accepted on it to a service. An ingress proxy accepts SOCKS5 and HTTP CONNECT requests from local
+// applications instead, forwarding the destinations its rules allow into Ziti. Proxies count their connections and
+// bytes, and bound dials and idle connections with configurable timeouts. Listeners passed by systemd socket
+// activation can be forwarded into Ziti, and Ziti listeners exported as sockets inherited by child processes.
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/foundation/v2/errorz"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultDialTimeout = 10 * time.Second
+
+	copyBufferSize = 32 * 1024
+)
+
+// AddressDialer returns a ziti.ConnDialer that connects to addr on network, e.g. "tcp" or "unix".
+func AddressDialer(network, addr string) ziti.ConnDialer {
+	return func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+}
+
+// Options configures a Proxy. The zero value, or nil, uses the defaults.
+type Options struct {
+	// DialTimeout bounds connecting to the target for an accepted connection. Defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// IdleTimeout closes connections on which no data was transferred in either direction for this long. Zero keeps
+	// idle connections open.
+	IdleTimeout time.Duration
+
+	// CloseTimeout bounds how long a connection stays open after one side finished sending, waiting for the other to
+	// finish as well. Zero waits as long as IdleTimeout allows.
+	CloseTimeout time.Duration
+}
+
+func (self *Options) getDialTimeout() time.Duration {
+	if self == nil || self.DialTimeout <= 0 {
+		return DefaultDialTimeout
+	}
+	return self.DialTimeout
+}
+
+func (self *Options) getIdleTimeout() time.Duration {
+	if self == nil {
+		return 0
+	}
+	return self.IdleTimeout
+}
+
+func (self *Options) getCloseTimeout() time.Duration {
+	if self == nil {
+		return 0
+	}
+	return self.CloseTimeout
+}
+
+// Stats counts the connections of a Proxy since it was created.
+type Stats struct {
+	// Active is the number of connections currently being proxied.
+	Active int64
+
+	// Accepted is the number of connections accepted.
+	Accepted uint64
+
+	// DialFailures is the number of accepted connections that were closed because the target could not be reached.
+	DialFailures uint64
+
+	// Rejected is the number of accepted connections that were closed without dialing the target, such as ingress
+	// connections failing the handshake or requesting a destination no rule allows.
+	Rejected uint64
+
+	// BytesToTarget is the number of bytes copied from accepted connections to the target.
+	BytesToTarget uint64
+
+	// BytesFromTarget is the number of bytes copied from the target to accepted connections.
+	BytesFromTarget uint64
+}
+
+// Proxy proxies every connection accepted from a listener to a connection made by a ziti.ConnDialer, copying data in both
+// directions until both sides are done or the connection is idle for too long.
+type Proxy struct {
+	listener net.Listener
+	connect  connectFunc
+	options  *Options
+
+	active          atomic.Int64
+	accepted        atomic.Uint64
+	dialFailures    atomic.Uint64
+	rejected        atomic.Uint64
+	bytesToTarget   atomic.Uint64
+	bytesFromTarget atomic.Uint64
+
+	lock   sync.Mutex
+	closed bool
+	conns  map[net.Conn]struct{}
+}
+
+// connectFunc connects an accepted connection to its target. It returns the connection to copy the target's data to
+// and from, which may wrap conn, or an error if conn should be closed.
+type connectFunc func(ctx context.Context, conn net.Conn) (client net.Conn, target net.Conn, err error)
+
+// New returns a Proxy which proxies the connections accepted from listener to the target reached by dial, once Serve
+// is called. The Proxy takes ownership of listener.
+func New(listener net.Listener, dial ziti.ConnDialer, options *Options) *Proxy {
+	return newProxy(listener, func(ctx context.Context, conn net.Conn) (net.Conn, net.Conn, error) {
+		target, err := dial(ctx)
+		return conn, target, err
+	}, options)
+}
+
+func newProxy(listener net.Listener, connect connectFunc, options *Options) *Proxy {
+	return &Proxy{
+		listener: listener,
+		connect:  connect,
+		options:  options,
+		conns:    map[net.Conn]struct{}{},
+	}
+}
+
+// Host binds serviceName with ztx and returns a Proxy which proxies the service's connections to addr on network,
+// e.g. a local application listening on "tcp" "127.0.0.1:8080".
+func Host(ztx ziti.Context, serviceName, network, addr string, options *Options, opts ...ziti.ListenOption) (*Proxy, error) {
+	listener, err := ztx.ListenWithOptions(serviceName, ziti.NewListenOptions(opts...))
+	if err != nil {
+		return nil, err
+	}
+	return New(listener, AddressDialer(network, addr), options), nil
+}
+
+// Intercept listens on addr on network and returns a Proxy which proxies the connections of local clients to
+// serviceName, dialed with ztx.
+func Intercept(ztx ziti.Context, network, addr, serviceName string, options *Options) (*Proxy, error) {
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return New(listener, ziti.ServiceDialer(ztx, serviceName), options), nil
+}
+
+// Addr returns the address of the listener the Proxy accepts connections from.
+func (self *Proxy) Addr() net.Addr {
+	return self.listener.Addr()
+}
+
+// Stats returns the connection counts of the Proxy.
+func (self *Proxy) Stats() Stats {
+	return Stats{
+		Active:          self.active.Load(),
+		Accepted:        self.accepted.Load(),
+		DialFailures:    self.dialFailures.Load(),
+		Rejected:        self.rejected.Load(),
+		BytesToTarget:   self.bytesToTarget.Load(),
+		BytesFromTarget: self.bytesFromTarget.Load(),
+	}
+}
+
+// Serve proxies accepted connections until the Proxy is closed, returning nil, or accepting fails, returning that
+// error.
+func (self *Proxy) Serve() error {
+	for {
+		conn, err := self.listener.Accept()
+		if err != nil {
+			if self.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		self.accepted.Add(1)
+		if !self.track(conn) {
+			_ = conn.Close()
+			return nil
+		}
+
+		go self.proxy(conn)
+	}
+}
+
+// Close closes the listener and all connections being proxied.
+func (self *Proxy) Close() error {
+	self.lock.Lock()
+	if self.closed {
+		self.lock.Unlock()
+		return nil
+	}
+	self.closed = true
+	conns := self.conns
+	self.conns = map[net.Conn]struct{}{}
+	self.lock.Unlock()
+
+	var errs errorz.MultipleErrors
+	if err := self.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		errs = append(errs, err)
+	}
+	for conn := range conns {
+		_ = conn.Close()
+	}
+	return errs.ToError()
+}
+
+func (self *Proxy) isClosed() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.closed
+}
+
+// track registers conn to be closed along with the Proxy, returning false if the Proxy is already closed.
+func (self *Proxy) track(conn net.Conn) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return false
+	}
+	self.conns[conn] = struct{}{}
+	return true
+}
+
+func (self *Proxy) untrack(conn net.Conn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.conns, conn)
+}
+
+func (self *Proxy) proxy(conn net.Conn) {
+	self.active.Add(1)
+	defer self.active.Add(-1)
+	defer func() {
+		self.untrack(conn)
+		_ = conn.Close()
+	}()
+
+	log := pfxlog.Logger().WithField("addr", self.listener.Addr().String()).WithField("remote", conn.RemoteAddr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), self.options.getDialTimeout())
+	client, target, err := self.connect(ctx, conn)
+	cancel()
+	if err != nil {
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			self.rejected.Add(1)
+			log.WithError(err).Debug("proxied connection rejected")
+		} else {
+			self.dialFailures.Add(1)
+			log.WithError(err).Warn("unable to connect proxied connection to target")
+		}
+		return
+	}
+
+	if !self.track(target) {
+		_ = target.Close()
+		return
+	}
+	defer func() {
+		self.untrack(target)
+		_ = target.Close()
+	}()
+
+	p := &pipe{
+		idleTimeout: self.options.getIdleTimeout(),
+	}
+	p.touch()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.copy(target, client, &self.bytesToTarget)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.copy(client, target, &self.bytesFromTarget)
+		done <- struct{}{}
+	}()
+
+	<-done
+	if closeTimeout := self.options.getCloseTimeout(); closeTimeout > 0 {
+		select {
+		case <-done:
+		case <-time.After(closeTimeout):
+			log.Debug("closing half-closed proxied connection")
+		}
+	} else {
+		<-done
+	}
+}
+
+// pipe copies between the two sides of a proxied connection, tracking when data was last transferred in either
+// direction so that idle connections can be closed.
+type pipe struct {
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+}
+
+func (self *pipe) touch() {
+	self.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (self *pipe) idleFor() time.Duration {
+	return time.Since(time.Unix(0, self.lastActivity.Load()))
+}
+
+// copy copies from src to dst until src is done, then closes the write side of dst so that its peer sees EOF. Once
+// the pipe is idle, both connections are closed.
+func (self *pipe) copy(dst, src net.Conn, counter *atomic.Uint64) {
+	buf := make([]byte, copyBufferSize)
+	for {
+		if self.idleTimeout > 0 {
+			_ = src.SetReadDeadline(time.Now().Add(self.idleTimeout - self.idleFor()))
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			self.touch()
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				_ = src.Close()
+				return
+			}
+			counter.Add(uint64(n))
+		}
+
+		if err != nil {
+			if isTimeout(err) && self.idleFor() < self.idleTimeout {
+				// the other direction was active in the meantime
+				continue
+			}
+			if err == io.EOF {
+				if closeWriter, ok := dst.(edge.CloseWriter); ok {
+					_ = closeWriter.CloseWrite()
+					return
+				}
+			}
+			_ = src.Close()
+			_ = dst.Close()
+			return
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
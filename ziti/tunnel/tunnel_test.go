@@ -0,0 +1,121 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func startEcho(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func startProxy(t *testing.T, dial ziti.ConnDialer, options *Options) *Proxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	proxy := New(listener, dial, options)
+	go func() { _ = proxy.Serve() }()
+	t.Cleanup(func() { _ = proxy.Close() })
+	return proxy
+}
+
+func Test_Proxy(t *testing.T) {
+	t.Run("proxies in both directions and counts", func(t *testing.T) {
+		req := require.New(t)
+		echo := startEcho(t)
+		proxy := startProxy(t, AddressDialer("tcp", echo.Addr().String()), nil)
+
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		req.NoError(err)
+		_, err = conn.Write([]byte("hello"))
+		req.NoError(err)
+		req.NoError(conn.(*net.TCPConn).CloseWrite())
+
+		body, err := io.ReadAll(conn)
+		req.NoError(err)
+		req.Equal("hello", string(body))
+		_ = conn.Close()
+
+		req.Eventually(func() bool { return proxy.Stats().Active == 0 }, time.Second, 10*time.Millisecond)
+		stats := proxy.Stats()
+		req.Equal(uint64(1), stats.Accepted)
+		req.Equal(uint64(5), stats.BytesToTarget)
+		req.Equal(uint64(5), stats.BytesFromTarget)
+	})
+
+	t.Run("counts dial failures", func(t *testing.T) {
+		req := require.New(t)
+		proxy := startProxy(t, func(context.Context) (net.Conn, error) {
+			return nil, errors.New("unreachable")
+		}, nil)
+
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		req.NoError(err)
+		_, err = io.ReadAll(conn)
+		req.NoError(err)
+		req.Equal(uint64(1), proxy.Stats().DialFailures)
+	})
+
+	t.Run("closes idle connections", func(t *testing.T) {
+		req := require.New(t)
+		echo := startEcho(t)
+		proxy := startProxy(t, AddressDialer("tcp", echo.Addr().String()), &Options{IdleTimeout: 100 * time.Millisecond})
+
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		buf := make([]byte, 1)
+		for i := 0; i < 4; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_, err = conn.Write([]byte{byte(i)})
+			req.NoError(err)
+			_, err = io.ReadFull(conn, buf)
+			req.NoError(err)
+		}
+
+		start := time.Now()
+		_, err = conn.Read(buf)
+		req.Error(err)
+		req.Less(time.Since(start), time.Second)
+	})
+
+	t.Run("close closes active connections", func(t *testing.T) {
+		req := require.New(t)
+		echo := startEcho(t)
+		proxy := startProxy(t, AddressDialer("tcp", echo.Addr().String()), nil)
+
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+		req.Eventually(func() bool { return proxy.Stats().Active == 1 }, time.Second, 10*time.Millisecond)
+
+		req.NoError(proxy.Close())
+		_, err = conn.Read(make([]byte, 1))
+		req.Error(err)
+		req.Eventually(func() bool { return proxy.Stats().Active == 0 }, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,108 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	"strings"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/pkg/errors"
+)
+
+// ServiceVariantsTag is the service tag mapping variant keys to the instance identities of the terminators serving
+// them, e.g. {"variants": {"version=v2": ["api-canary-1", "api-canary-2"]}}. A value may also be a single identity.
+const ServiceVariantsTag = "variants"
+
+// variantLabelSeparator separates the labels of a terminator instance identity, e.g. "api-1,version=v2,track=canary".
+const variantLabelSeparator = ","
+
+// matchesVariant returns true if one of the comma separated labels of the instance identity equals the variant key.
+func matchesVariant(identity string, variant string) bool {
+	for _, label := range strings.Split(identity, variantLabelSeparator) {
+		if strings.TrimSpace(label) == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// getServiceVariantIdentities returns the instance identities the service's tags assign to the variant key.
+func getServiceVariantIdentities(svc *rest_model.ServiceDetail, variant string) []string {
+	if svc.Tags == nil {
+		return nil
+	}
+
+	variants, ok := svc.Tags.SubTags[ServiceVariantsTag].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	switch v := variants[variant].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var result []string
+		for _, identity := range v {
+			if s, ok := identity.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// selectVariantIdentity returns the instance identity of the terminator to dial for the variant of the service. The
+// terminators serving the variant are those the service's ServiceVariantsTag assigns to it or, if the tag has no
+// entry for the variant, those whose instance identity has a label equal to it. The strategy chooses among them,
+// randomly if nil.
+func (context *ContextImpl) selectVariantIdentity(svc *rest_model.ServiceDetail, variant string, strategy TerminatorStrategy) (string, error) {
+	serviceName := *svc.Name
+
+	terminators, err := context.getTerminatorCandidates(serviceName)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to list terminators of service '%s' to select variant '%s'", serviceName, variant)
+	}
+
+	tagged := getServiceVariantIdentities(svc, variant)
+
+	var candidates []*Terminator
+	for _, terminator := range terminators {
+		if len(tagged) > 0 {
+			for _, identity := range tagged {
+				if terminator.Identity == identity {
+					candidates = append(candidates, terminator)
+					break
+				}
+			}
+		} else if matchesVariant(terminator.Identity, variant) {
+			candidates = append(candidates, terminator)
+		}
+	}
+
+	if strategy == nil {
+		strategy = SelectRandomTerminator
+	}
+
+	if len(candidates) > 0 {
+		if selected := strategy.Select(serviceName, candidates); selected != nil {
+			return selected.Identity, nil
+		}
+	}
+
+	return "", errors.Errorf("no terminators of service '%s' serve variant '%s'", serviceName, variant)
+}
@@ -0,0 +1,164 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openziti/foundation/v2/errorz"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultWarmRefreshInterval is how often the sessions of services passed to Context.WarmServices are refreshed.
+	DefaultWarmRefreshInterval = time.Minute
+
+	// warmConnectTimeout bounds how long warming a service waits for one of its edge routers to connect.
+	warmConnectTimeout = 5 * time.Second
+)
+
+// WarmServices creates dial sessions for the named services and connects to their edge routers ahead of time, so that
+// the first dial of each does not wait for either. The services are kept warm until the Context is closed: their
+// sessions are refreshed every Options.WarmRefreshInterval, re-created if the controller no longer has them, and
+// dropped edge router connections are re-established. Services that could not be warmed are reported in the returned
+// error, but are still retried by the refresh loop.
+func (context *ContextImpl) WarmServices(names []string) error {
+	if context.closed.Load() {
+		return errors.New("context is closed")
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	if err := context.ensureApiSession(); err != nil {
+		return errors.Wrap(err, "failed to warm services")
+	}
+
+	for _, name := range names {
+		context.warmServices.Set(name, struct{}{})
+	}
+
+	context.warmOnce.Do(func() {
+		context.spawn(context.runWarmRefreshes)
+	})
+
+	return context.warm(names)
+}
+
+// warm warms the named services concurrently, returning the errors of those that failed.
+func (context *ContextImpl) warm(names []string) error {
+	var lock sync.Mutex
+	var errs errorz.MultipleErrors
+	var wg sync.WaitGroup
+
+	for _, name := range names {
+		serviceName := name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := context.warmService(serviceName); err != nil {
+				lock.Lock()
+				errs = append(errs, errors.Wrapf(err, "unable to warm service '%s'", serviceName))
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errs
+}
+
+// warmService ensures a dial session for the named service is cached and that at least one of its edge routers is
+// connected. Remaining routers of the session continue connecting in the background.
+func (context *ContextImpl) warmService(serviceName string) error {
+	svc, found := context.GetService(serviceName)
+	if !found {
+		return &ServiceNotFoundError{ServiceName: serviceName}
+	}
+
+	session, err := context.GetSession(*svc.ID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), warmConnectTimeout)
+	defer cancel()
+
+	options := &edge.DialOptions{ConnectTimeout: warmConnectTimeout}
+	_, err = context.getEdgeRouterConn(ctx, session, options, edgeRouterPolicy{})
+	return err
+}
+
+// refreshWarmSession refreshes the cached dial session of a warmed service, dropping it if it can't be refreshed so
+// that warming creates a new one.
+func (context *ContextImpl) refreshWarmSession(serviceName string) {
+	svc, found := context.GetService(serviceName)
+	if !found {
+		return
+	}
+
+	sessionKey := fmt.Sprintf("%s:%s", *svc.ID, SessionDial)
+	if session, found := context.sessions.Get(sessionKey); found {
+		if _, err := context.refreshSession(session); err != nil {
+			context.log().WithError(err).WithField("service", serviceName).
+				Debug("unable to refresh warmed session, creating a new one")
+			context.sessions.Remove(sessionKey)
+		}
+	}
+}
+
+func (context *ContextImpl) runWarmRefreshes() {
+	interval := context.options.WarmRefreshInterval
+	if interval == 0 {
+		interval = DefaultWarmRefreshInterval
+	}
+	if interval < MinRefreshInterval {
+		interval = MinRefreshInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if context.CtrlClt.GetCurrentApiSession() == nil {
+				continue
+			}
+			names := context.warmServices.Keys()
+			for _, serviceName := range names {
+				context.refreshWarmSession(serviceName)
+			}
+			if err := context.warm(names); err != nil {
+				context.log().WithError(err).Warn("unable to keep services warm")
+			}
+		case <-context.closeNotify:
+			return
+		}
+	}
+}
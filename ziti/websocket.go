@@ -0,0 +1,52 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ziti
+
+import (
+	gocontext "context"
+	"net"
+	"net/http"
+)
+
+// WebSocketNetDial returns a dial function for WebSocket clients that take one, such as the NetDialContext of a
+// gorilla/websocket Dialer. Services are resolved from the host and port of the WebSocket URL as by NewHTTPTransport,
+// and wss URLs are secured by the client's TLS config on top of the service connection. The handshake deadline the
+// client sets from its handshake timeout or dial context applies to the service connection and is cleared by the
+// client once the handshake completes.
+//
+// WebSocket servers need no adapter: serve the handler that upgrades requests, e.g. with a gorilla/websocket Upgrader
+// or nhooyr.io/websocket Accept, with NewHTTPServer. The deadlines the http.Server sets for its ReadTimeout and
+// WriteTimeout are cleared when the upgrade hijacks the service connection, so they only bound the handshake.
+func WebSocketNetDial(ztx Context, opts ...HTTPTransportOption) func(ctx gocontext.Context, network, addr string) (net.Conn, error) {
+	options := &httpTransportOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(ctx gocontext.Context, network, addr string) (net.Conn, error) {
+		return options.dial(ctx, ztx, network, addr)
+	}
+}
+
+// NewWebSocketHTTPClient returns a http.Client for WebSocket clients that perform the handshake with one, such as the
+// HTTPClient of nhooyr.io/websocket DialOptions. The client has no timeout of its own, as it would cut off established
+// WebSocket connections, so the handshake is bounded by the context given to the WebSocket dial.
+func NewWebSocketHTTPClient(ztx Context, opts ...HTTPTransportOption) *http.Client {
+	return &http.Client{
+		Transport: NewHTTPTransport(ztx, opts...),
+	}
+}
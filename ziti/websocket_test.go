@@ -0,0 +1,84 @@
+package ziti
+
+import (
+	"bufio"
+	gocontext "context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WebSocket(t *testing.T) {
+	// echoUpgrade hijacks upgrade requests and echoes lines, standing in for a WebSocket library
+	echoUpgrade := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = rw.WriteString(line)
+			_ = rw.Flush()
+		}
+	})
+
+	exchange := func(t *testing.T, handler http.Handler) (string, error) {
+		server := newServerTestContext(t)
+		httpServer, err := NewHTTPServer(server, "ws", handler, WithHTTPServerConfig(func(server *http.Server) {
+			server.ReadTimeout = 50 * time.Millisecond
+		}))
+		require.NoError(t, err)
+		go func() { _ = httpServer.Serve() }()
+		defer func() { _ = httpServer.Close() }()
+
+		client := &proxyTestContext{addr: server.listener.Addr().String()}
+		httpClient := NewWebSocketHTTPClient(client, WithHTTPServices(map[string]string{"ws.ziti": "ws"}))
+
+		req, err := http.NewRequestWithContext(gocontext.Background(), http.MethodGet, "http://ws.ziti/", nil)
+		require.NoError(t, err)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "echo")
+		resp, err := httpClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		require.Equal(t, []string{"ws"}, client.dials)
+
+		conn := resp.Body.(io.ReadWriteCloser)
+		defer func() { _ = conn.Close() }()
+
+		time.Sleep(150 * time.Millisecond)
+		if _, err = io.WriteString(conn, "hello\n"); err != nil {
+			return "", err
+		}
+		return bufio.NewReader(conn).ReadString('\n')
+	}
+
+	t.Run("upgraded connections outlive server timeouts", func(t *testing.T) {
+		line, err := exchange(t, echoUpgrade)
+		require.NoError(t, err)
+		require.Equal(t, "hello\n", line)
+	})
+
+	t.Run("net dial resolves services", func(t *testing.T) {
+		req := require.New(t)
+		server := newServerTestContext(t)
+		go func() { _ = ListenAndEcho(server, "ws") }()
+		defer func() { _ = server.listener.Close() }()
+
+		client := &proxyTestContext{addr: server.listener.Addr().String()}
+		dial := WebSocketNetDial(client, WithHTTPServices(map[string]string{"ws.ziti": "ws"}))
+		conn, err := dial(gocontext.Background(), "tcp", "ws.ziti:80")
+		req.NoError(err)
+		_ = conn.Close()
+		req.Equal([]string{"ws"}, client.dials)
+	})
+}
@@ -17,6 +17,16 @@
 package ziti
 
 import (
+	"bytes"
+	gocontext "context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
 	"github.com/go-openapi/strfmt"
@@ -27,9 +37,11 @@ import (
 	rest_session "github.com/openziti/edge-api/rest_client_api_client/session"
 	"github.com/openziti/foundation/v2/concurrenz"
 	"github.com/openziti/foundation/v2/errorz"
+	nfPem "github.com/openziti/foundation/v2/pem"
 	"github.com/openziti/foundation/v2/stringz"
 	apis "github.com/openziti/sdk-golang/edge-apis"
 	"github.com/openziti/secretstream/kx"
+	"io"
 	"math"
 	"math/rand"
 	"net"
@@ -42,7 +54,6 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
-	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/channel/v2"
 	"github.com/openziti/channel/v2/latency"
 	"github.com/openziti/edge-api/rest_client_api_client/current_api_session"
@@ -56,7 +67,6 @@ import (
 	"github.com/openziti/transport/v2"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"github.com/pkg/errors"
-	metrics2 "github.com/rcrowley/go-metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -68,6 +78,7 @@ const (
 
 	ClientConfigV1 = "ziti-tunneler-client.v1"
 	InterceptV1    = "intercept.v1"
+	HostV1         = "host.v1"
 
 	SessionDial = rest_model.DialBindDial
 	SessionBind = rest_model.DialBindBind
@@ -103,11 +114,19 @@ type Context interface {
 	// DialWithOptions performs the same logic as Dial but allows specification of DialOptions.
 	DialWithOptions(serviceName string, options *DialOptions) (edge.Conn, error)
 
+	// DialWithContext performs the same logic as Dial, but returns as soon as ctx is done. A deadline on ctx bounds
+	// session creation, edge router selection and connection establishment.
+	DialWithContext(ctx gocontext.Context, serviceName string, opts ...DialOption) (edge.Conn, error)
+
+	// DialWithOptionsContext performs the same logic as DialWithContext but allows specification of DialOptions. If
+	// ctx has a deadline that is sooner than the ConnectTimeout, the deadline is used instead.
+	DialWithOptionsContext(ctx gocontext.Context, serviceName string, options *DialOptions) (edge.Conn, error)
+
 	// DialAddr finds the service for given address and performs a Dial for it.
 	DialAddr(network string, addr string) (edge.Conn, error)
 
 	// Listen attempts to host a service by the given service name;  authenticating as necessary in order to obtain
-	// a service session, attach to Edge Routers, and bind (host) the service.
+	// a service session, attach to Edge Routers, and bind (host) the service. The DefaultListenOptions are used.
 	Listen(serviceName string) (edge.Listener, error)
 
 	// ListenWithOptions performs the same logic as Listen, but allows the specification of ListenOptions.
@@ -124,6 +143,10 @@ type Context interface {
 	// GetService will return the service details of a specific service by service name.
 	GetService(serviceName string) (*rest_model.ServiceDetail, bool)
 
+	// GetServiceConfig decodes the config of type configType on the named service into target, which must be a
+	// pointer. It returns false if the service has no config of that type. Results are memoized per service revision.
+	GetServiceConfig(serviceName string, configType string, target interface{}) (bool, error)
+
 	// GetServiceForAddr finds the service with intercept that matches best to given address
 	GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error)
 
@@ -131,15 +154,39 @@ type Context interface {
 	// to.
 	RefreshServices() error
 
+	// SetServiceRefreshInterval changes how often the context polls for service changes, overriding
+	// Options.RefreshInterval. Changes are reported by EventServicesUpdated.
+	SetServiceRefreshInterval(interval time.Duration)
+
 	// RefreshService forces the context to refresh just the service with the given name. If the given service isn't
 	// found, a nil will be returned
 	RefreshService(serviceName string) (*rest_model.ServiceDetail, error)
 
+	// GetServicePermissions returns whether the identity may dial and bind the named service, the config types
+	// returned with it and the state of the posture checks guarding it, so that applications can adapt without
+	// attempting operations that will fail. It returns false if the service is not known to the context.
+	GetServicePermissions(name string) (*ServicePermissions, bool)
+
+	// QueryServices returns up to limit of the services the identity has access to that match the filter, starting
+	// at offset, along with the total number of matching services. The filter uses the controller's filter syntax, e.g.
+	// `name contains "printer" and roleAttributes contains "floor-2"`, and an empty filter matches all services. Limit
+	// may not exceed 500; if it is not positive, 500 is used. Unlike GetServices, only the requested page is fetched,
+	// and the services known to the context are not updated.
+	QueryServices(filter string, limit, offset int) ([]*rest_model.ServiceDetail, int, error)
+
 	// GetServiceTerminators will return a slice of rest_model.TerminatorClientDetail for a specific service name.
 	// The offset and limit options can be used to page through excessive lists of items. A max of 500 is imposed on
 	// limit.
 	GetServiceTerminators(serviceName string, offset, limit int) ([]*rest_model.TerminatorClientDetail, int, error)
 
+	// GetServiceTerminatorIdentities returns the sorted, distinct instance identities of the addressable terminators
+	// of the named service, which can be dialed individually using WithTerminatorIdentity.
+	GetServiceTerminatorIdentities(serviceName string) ([]string, error)
+
+	// GetEdgeRouters returns the edge routers the current identity may connect to, including the failure domain labels
+	// they advertise in their app data.
+	GetEdgeRouters() ([]*EdgeRouter, error)
+
 	// GetSession will return the session detail associated with a specific session id.
 	GetSession(id string) (*rest_model.SessionDetail, error)
 
@@ -149,25 +196,124 @@ type Context interface {
 	// Close closes any connections open to edge routers
 	Close()
 
+	// CloseWithContext gracefully closes the Context. It is the same as Shutdown: listeners are closed first, then
+	// in-flight connections are given until ctx is done to finish before edge router connections are closed and the
+	// API Session is removed from the controller. If connections have not drained in time they are closed forcefully
+	// and the ctx error is returned.
+	CloseWithContext(ctx gocontext.Context) error
+
+	// Shutdown drains the Context before closing it. New dials and listens fail with ErrShuttingDown from the start,
+	// listeners are closed so their terminators are unbound, and open connections are given until ctx is done to
+	// finish while the API Session and sessions are still maintained. Then the Context is closed, closing any
+	// connections still open, and the API Session is removed from the controller if ctx allows. Close, in contrast,
+	// drops traffic immediately.
+	Shutdown(ctx gocontext.Context) error
+
+	// RecentEvents returns the most recent events emitted by the Context and errors encountered in the background,
+	// oldest first. The number of entries retained is controlled by Options.RecentEventsSize.
+	RecentEvents() []RecentEvent
+
+	// ResourceUsage returns an approximation of the goroutines and memory held by the Context.
+	ResourceUsage() ResourceUsage
+
+	// Stats returns a point in time summary of the Context's authentication state and edge router traffic.
+	Stats() ContextStats
+
+	// Connections returns the stats of the open dialed and accepted connections, oldest first.
+	Connections() []edge.ConnStats
+
+	// DebugDump writes the API Session, cached services and sessions, edge router connections, listeners and open
+	// connections of the Context to w as JSON, for field debugging. See DebugHandler and PublishDebugExpvar.
+	DebugDump(w io.Writer) error
+
+	// ConnectionState returns whether the Context is disconnected, authenticating, syncing its services or ready. See
+	// Eventer.AddConnectionStateListener to follow changes, and Options.ReconnectPolicy.
+	ConnectionState() ConnectionState
+
+	// Health returns a summary of the authentication, controller, edge router and service state of the Context, with
+	// its most recent errors, for readiness and liveness probes. See HealthHandler.
+	Health() *Health
+
+	// ApiSessionRefreshStatus returns when the API Session was last refreshed in the background, the error of the last
+	// refresh, if any, and when the next refresh is scheduled. See Options.ApiSessionRefreshFraction.
+	ApiSessionRefreshStatus() ApiSessionRefreshStatus
+
+	// MetricsSnapshot returns the dial, traffic, circuit, authentication and router reconnect counters of the Context.
+	// See PrometheusHandler to serve them to Prometheus.
+	MetricsSnapshot() *MetricsSnapshot
+
+	// EventBus returns the bus publishing the typed events of the Context, such as AuthenticatedEvent and
+	// ConnClosedEvent, to subscriptions with bounded buffers. It is the channel based alternative to Events.
+	EventBus() *EventBus
+
+	// QueueStats returns the depth, oldest item age and completion rate of the dials, controller session requests and
+	// edge router connects in progress.
+	QueueStats() QueueStats
+
+	// DialLatencies returns an exponential histogram of the latencies of dials of the named service within the window,
+	// which is capped at MaxSloWindow.
+	DialLatencies(serviceName string, window time.Duration) *LatencyHistogram
+
+	// RegisterDialSLO registers a gauge in the Metrics registry reporting the fraction of dials meeting the SLO, so
+	// that it can be alerted on along with the other metrics. See MetricDialSloPrefix. The Metrics registry is created
+	// on authentication, so SLOs registered before then are not reported.
+	RegisterDialSLO(slo DialSLO)
+
 	// Deprecated: AddZitiMfaHandler adds a Ziti MFA handler, invoked during authentication.
 	// Replaced with event functionality. Use `zitiContext.AddListener(MfaTotpCode, handler)` instead.
 	AddZitiMfaHandler(handler func(query *rest_model.AuthQueryDetail, resp MfaCodeResponse) error)
 
 	// EnrollZitiMfa will attempt to enable TOTP 2FA on the currently authenticating identity if not already enrolled.
+	// The returned enrollment holds the provisioning URL to load into an authenticator app and the recovery codes.
+	// Enrollment is completed by calling VerifyZitiMfa with a code from the authenticator app.
 	EnrollZitiMfa() (*rest_model.DetailMfa, error)
 
+	// GetZitiMfa returns the TOTP 2FA enrollment of the current identity, if any. The provisioning URL and recovery
+	// codes are only provided until the enrollment is verified.
+	GetZitiMfa() (*rest_model.DetailMfa, error)
+
+	// GetZitiMfaRecoveryCodes returns the unused recovery codes of the verified TOTP 2FA enrollment of the current
+	// identity. The code must be a current TOTP code or an unused recovery code.
+	GetZitiMfaRecoveryCodes(code string) ([]string, error)
+
+	// NewZitiMfaRecoveryCodes replaces the recovery codes of the verified TOTP 2FA enrollment of the current identity,
+	// invalidating the previous ones. The new codes are read with GetZitiMfaRecoveryCodes. The code must be a current
+	// TOTP code or an unused recovery code.
+	NewZitiMfaRecoveryCodes(code string) error
+
 	// VerifyZitiMfa will attempt to complete enrollment of TOTP 2FA with the given code.
 	VerifyZitiMfa(code string) error
 
 	// RemoveZitiMfa will attempt to remove TOTP 2FA for the current identity
 	RemoveZitiMfa(code string) error
 
+	// GetAuthenticators returns the authenticators (cert, updb, etc.) of the current identity.
+	GetAuthenticators() ([]*rest_model.AuthenticatorDetail, error)
+
+	// UpdatePassword changes the password of the current identity's updb authenticator. If the Context authenticates
+	// with UpdbCredentials, they are updated to use the new password.
+	UpdatePassword(currentPassword, newPassword string) error
+
+	// RotateCertAuthenticator replaces the client certificate of the current identity's cert authenticator with a
+	// newly issued certificate and private key, which are returned so that they may be persisted. The Context switches
+	// to authenticating with the new certificate. Identities without a cert authenticator, such as ext-jwt only
+	// identities, must be issued a new enrollment by an administrator instead; see the enroll package.
+	RotateCertAuthenticator() ([]*x509.Certificate, crypto.PrivateKey, error)
+
 	// GetId returns a unique context id
 	GetId() string
 
 	// SetId allows the setting of a context's id
 	SetId(id string)
 
+	// Flags returns the feature flags set in the app data of the current identity, refreshed along with services.
+	// See FeatureFlags.
+	Flags() *FeatureFlags
+
+	// WarmServices creates dial sessions for the named services and connects to their edge routers ahead of time, so
+	// that their first dials avoid the cold start. Warmed services are kept warm until the Context is closed.
+	WarmServices(names []string) error
+
 	Events() Eventer
 }
 
@@ -184,9 +330,31 @@ type ContextImpl struct {
 	sessions   cmap.ConcurrentMap[string, *rest_model.SessionDetail] // svcID:type -> Session
 	intercepts cmap.ConcurrentMap[string, *edge.InterceptV1Config]
 
+	serviceConfigs   *serviceConfigCache
+	terminators      cmap.ConcurrentMap[string, *serviceTerminators]
+	listenerManagers cmap.ConcurrentMap[string, *listenerManager] // listener id -> manager
+	warmServices     cmap.ConcurrentMap[string, struct{}]         // names of services kept warm
+	wssFallbacks     cmap.ConcurrentMap[string, *wssFallback]     // router name -> wss addresses to fall back to
+	routerLatencies  cmap.ConcurrentMap[string, *routerLatency]   // router url -> smoothed latency
+	selectedRouters  cmap.ConcurrentMap[string, string]           // service id -> router url selected for dials
+	unhealthyRouters cmap.ConcurrentMap[string, struct{}]         // urls of router connections not answering keepalives
+	warmOnce         sync.Once
+	flags            FeatureFlags
+
+	edgeRouterTlsSessions tls.ClientSessionCache
+	edgeRouterRevocation  *revocationChecker
+	logger                *logrus.Logger
+	rateLimits            rateLimiters
+
 	metrics metrics.Registry
 
-	firstAuthOnce sync.Once
+	firstAuthOnce       sync.Once
+	fullyAuthenticated  atomic.Bool
+	apiSessionRefresher apiSessionRefresher
+	controllerContact   controllerContact
+	servicesLoaded      atomic.Bool
+	shuttingDown        atomic.Bool
+	connectionState     connectionStateMachine
 
 	closed            atomic.Bool
 	closeNotify       chan struct{}
@@ -195,6 +363,35 @@ type ContextImpl struct {
 	events.EventEmmiter
 	apiSessionLock                  sync.Mutex
 	lastSuccessfulApiSessionRefresh time.Time
+
+	recentEvents  *recentEventRing
+	traffic       edge.TrafficCounter
+	goroutines    atomic.Int64
+	queues        workQueues
+	dialLatencies dialLatencies
+	counters      sdkCounters
+	eventBus      EventBus
+	connHooks     *connHookDispatcher
+	faults        faultState
+
+	serviceRefreshInterval        atomic.Int64
+	serviceRefreshIntervalChanged chan struct{}
+	proxyUrl                      *url.URL
+}
+
+// Emit records the event in RecentEvents and dispatches it to the registered listeners.
+func (context *ContextImpl) Emit(eventName events.EventName, args ...interface{}) {
+	context.recentEvents.add(newRecentEvent(eventName, args...))
+	context.EventEmmiter.Emit(eventName, args...)
+	context.eventBus.publishEmitted(eventName, args)
+}
+
+func (context *ContextImpl) recordError(eventName events.EventName, err error) {
+	context.recentEvents.add(newRecentEvent(eventName, err))
+}
+
+func (context *ContextImpl) RecentEvents() []RecentEvent {
+	return context.recentEvents.snapshot()
 }
 
 func (context *ContextImpl) AddServiceAddedListener(handler func(Context, *rest_model.ServiceDetail)) func() {
@@ -202,11 +399,11 @@ func (context *ContextImpl) AddServiceAddedListener(handler func(Context, *rest_
 		details, ok := args[0].(*rest_model.ServiceDetail)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", details, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", details, args[0])
 		}
 
 		if details == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, details)
@@ -224,11 +421,11 @@ func (context *ContextImpl) AddServiceChangedListener(handler func(Context, *res
 		details, ok := args[0].(*rest_model.ServiceDetail)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", details, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", details, args[0])
 		}
 
 		if details == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, details)
@@ -246,11 +443,11 @@ func (context *ContextImpl) AddServiceRemovedListener(handler func(Context, *res
 		details, ok := args[0].(*rest_model.ServiceDetail)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", details, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", details, args[0])
 		}
 
 		if details == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, details)
@@ -268,13 +465,13 @@ func (context *ContextImpl) AddRouterConnectedListener(handler func(Context, str
 		name, ok := args[0].(string)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", name, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", name, args[0])
 		}
 
 		addr, ok := args[1].(string)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[1] to %T was %T", addr, args[1])
+			context.log().Fatalf("could not convert args[1] to %T was %T", addr, args[1])
 		}
 
 		handler(context, name, addr)
@@ -292,13 +489,13 @@ func (context *ContextImpl) AddRouterDisconnectedListener(handler func(Context,
 		name, ok := args[0].(string)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", name, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", name, args[0])
 		}
 
 		addr, ok := args[1].(string)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[1] to %T was %T", addr, args[1])
+			context.log().Fatalf("could not convert args[1] to %T was %T", addr, args[1])
 		}
 
 		handler(context, name, addr)
@@ -316,21 +513,21 @@ func (context *ContextImpl) AddMfaTotpCodeListener(handler func(Context, *rest_m
 		authQuery, ok := args[0].(*rest_model.AuthQueryDetail)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", authQuery, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", authQuery, args[0])
 		}
 
 		if authQuery == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		responder, ok := args[1].(MfaCodeResponse)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[1] to %T was %T", responder, args[1])
+			context.log().Fatalf("could not convert args[1] to %T was %T", responder, args[1])
 		}
 
 		if responder == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, authQuery, responder)
@@ -348,11 +545,11 @@ func (context *ContextImpl) AddAuthQueryListener(handler func(Context, *rest_mod
 		authQuery, ok := args[0].(*rest_model.AuthQueryDetail)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", authQuery, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", authQuery, args[0])
 		}
 
 		if authQuery == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, authQuery)
@@ -370,11 +567,11 @@ func (context *ContextImpl) AddAuthenticationStatePartialListener(handler func(C
 		apiSession, ok := args[0].(apis.ApiSession)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", apiSession, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", apiSession, args[0])
 		}
 
 		if apiSession == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, apiSession)
@@ -392,11 +589,11 @@ func (context *ContextImpl) AddAuthenticationStateFullListener(handler func(Cont
 		apiSession, ok := args[0].(apis.ApiSession)
 
 		if !ok {
-			pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", apiSession, args[0])
+			context.log().Fatalf("could not convert args[0] to %T was %T", apiSession, args[0])
 		}
 
 		if apiSession == nil {
-			pfxlog.Logger().Fatalf("expected arg[0] was nil, unexpected")
+			context.log().Fatalf("expected arg[0] was nil, unexpected")
 		}
 
 		handler(context, apiSession)
@@ -418,7 +615,7 @@ func (context *ContextImpl) AddAuthenticationStateUnauthenticatedListener(handle
 			apiSession, ok = args[0].(apis.ApiSession)
 
 			if !ok {
-				pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", apiSession, args[0])
+				context.log().Fatalf("could not convert args[0] to %T was %T", apiSession, args[0])
 			}
 		}
 
@@ -432,6 +629,24 @@ func (context *ContextImpl) AddAuthenticationStateUnauthenticatedListener(handle
 	}
 }
 
+func (context *ContextImpl) AddAuthenticationFailedListener(handler func(Context, error)) func() {
+	listener := func(args ...interface{}) {
+		err, ok := args[0].(error)
+
+		if !ok {
+			context.log().Fatalf("could not convert args[0] to %T was %T", err, args[0])
+		}
+
+		handler(context, err)
+	}
+
+	context.AddListener(EventAuthenticationFailed, listener)
+
+	return func() {
+		context.RemoveListener(EventAuthenticationFailed, listener)
+	}
+}
+
 func (context *ContextImpl) AddControllerUrlsUpdateListener(handler func(Context, []*url.URL)) func() {
 	listener := func(args ...interface{}) {
 		var apiUrls []*url.URL
@@ -441,7 +656,7 @@ func (context *ContextImpl) AddControllerUrlsUpdateListener(handler func(Context
 			apiUrls, ok = args[0].([]*url.URL)
 
 			if !ok {
-				pfxlog.Logger().Fatalf("could not convert args[0] to %T was %T", apiUrls, args[0])
+				context.log().Fatalf("could not convert args[0] to %T was %T", apiUrls, args[0])
 			}
 		}
 
@@ -484,24 +699,28 @@ func (context *ContextImpl) Sessions() ([]*rest_model.SessionDetail, error) {
 }
 
 func (context *ContextImpl) OnClose(routerConn edge.RouterConn) {
-	logrus.Debugf("connection to router [%s] was closed", routerConn.Key())
+	context.log().Debugf("connection to router [%s] was closed", routerConn.Key())
 	context.Emit(EventRouterDisconnected, routerConn.GetRouterName(), routerConn.Key())
 	context.routerConnections.Remove(routerConn.Key())
+	context.unhealthyRouters.Remove(routerConn.Key())
 }
 
 func (context *ContextImpl) processServiceUpdates(services []*rest_model.ServiceDetail) {
-	pfxlog.Logger().Debugf("processing service updates with %v services", len(services))
+	context.log().Debugf("processing service updates with %v services", len(services))
 
 	idMap := make(map[string]*rest_model.ServiceDetail)
 	for _, s := range services {
 		idMap[*s.ID] = s
 	}
 
+	diff := &ServiceDiff{}
+
 	// process Deletes
 	var deletes []string
 	context.services.IterCb(func(key string, svc *rest_model.ServiceDetail) {
 		if _, found := idMap[*svc.ID]; !found {
 			deletes = append(deletes, key)
+			diff.Removed = append(diff.Removed, svc)
 			if context.options.OnServiceUpdate != nil {
 				context.options.OnServiceUpdate(ServiceRemoved, svc)
 			}
@@ -515,23 +734,29 @@ func (context *ContextImpl) processServiceUpdates(services []*rest_model.Service
 	for _, deletedKey := range deletes {
 		context.services.Remove(deletedKey)
 		context.intercepts.Remove(deletedKey)
+		context.serviceConfigs.remove(deletedKey)
 	}
 
-	// Adds and Updates
+	// Adds and Updates, decoding intercepts first so they are available to event listeners
+	context.updateIntercepts(services)
 	for _, s := range services {
-		context.processServiceAddOrUpdated(s)
+		diff.add(s, context.processServiceAddOrUpdated(s))
 	}
 
 	context.refreshServiceQueryMap()
+	context.emitServiceDiff(diff)
 }
 
 func (context *ContextImpl) processSingleServiceUpdate(name string, s *rest_model.ServiceDetail) {
+	diff := &ServiceDiff{}
+
 	// process Deletes
 	if s == nil {
 		var deletes []string
 		context.services.IterCb(func(key string, svc *rest_model.ServiceDetail) {
 			if *svc.Name == name {
 				deletes = append(deletes, key)
+				diff.Removed = append(diff.Removed, svc)
 				if context.options.OnServiceUpdate != nil {
 					context.options.OnServiceUpdate(ServiceRemoved, svc)
 				}
@@ -543,16 +768,21 @@ func (context *ContextImpl) processSingleServiceUpdate(name string, s *rest_mode
 		for _, deletedKey := range deletes {
 			context.services.Remove(deletedKey)
 			context.intercepts.Remove(deletedKey)
+			context.serviceConfigs.remove(deletedKey)
 		}
 	} else {
 		// Adds and Updates
-		context.processServiceAddOrUpdated(s)
+		context.updateIntercept(s)
+		diff.add(s, context.processServiceAddOrUpdated(s))
 	}
 
 	context.refreshServiceQueryMap()
+	context.emitServiceDiff(diff)
 }
 
-func (context *ContextImpl) processServiceAddOrUpdated(s *rest_model.ServiceDetail) {
+// processServiceAddOrUpdated stores the service and emits the events for it, returning ServiceAdded if it is new,
+// ServiceChanged if its definition changed and an empty ServiceEventType if it is unchanged.
+func (context *ContextImpl) processServiceAddOrUpdated(s *rest_model.ServiceDetail) ServiceEventType {
 	isChange := false
 	valuesDiffer := false
 
@@ -582,16 +812,26 @@ func (context *ContextImpl) processServiceAddOrUpdated(s *rest_model.ServiceDeta
 		}
 	}
 
+	if !isChange {
+		return ServiceAdded
+	}
+	if valuesDiffer {
+		return ServiceChanged
+	}
+	return ""
+}
+
+func (context *ContextImpl) updateIntercept(s *rest_model.ServiceDetail) {
 	intercept := &edge.InterceptV1Config{}
-	ok, err := edge.ParseServiceConfig(s, InterceptV1, intercept)
+	ok, err := context.serviceConfigs.decode(s, InterceptV1, intercept)
 	if err != nil {
-		pfxlog.Logger().Warnf("failed to parse config[%s] for service[%s]", InterceptV1, *s.Name)
+		context.log().Warnf("failed to parse config[%s] for service[%s]", InterceptV1, *s.Name)
 	} else if ok {
 		intercept.Service = s
 		context.intercepts.Set(*s.Name, intercept)
 	} else {
 		cltCfg := &edge.ClientConfig{}
-		ok, err := edge.ParseServiceConfig(s, ClientConfigV1, cltCfg)
+		ok, err := context.serviceConfigs.decode(s, ClientConfigV1, cltCfg)
 		if err == nil && ok {
 			intercept = cltCfg.ToInterceptV1Config()
 			intercept.Service = s
@@ -621,7 +861,7 @@ func (context *ContextImpl) refreshServiceQueryMap() {
 }
 
 func (context *ContextImpl) refreshSessions() {
-	log := pfxlog.Logger()
+	log := context.log()
 	edgeRouters := make(map[string]string)
 	var toDelete []string
 	for entry := range context.sessions.IterBuffered() {
@@ -634,10 +874,8 @@ func (context *ContextImpl) refreshSessions() {
 			toDelete = append(toDelete, *session.ID)
 		} else {
 			for _, er := range s.EdgeRouters {
-				for _, u := range er.SupportedProtocols {
-					if context.options.isEdgeRouterUrlAccepted(u) {
-						edgeRouters[u] = *er.Name
-					}
+				for _, u := range context.getEdgeRouterUrls(*er.Name, er.SupportedProtocols) {
+					edgeRouters[u] = *er.Name
 				}
 			}
 		}
@@ -647,6 +885,10 @@ func (context *ContextImpl) refreshSessions() {
 		context.sessions.Remove(id)
 	}
 
+	if context.options.isLowMemory() {
+		return
+	}
+
 	for u, name := range edgeRouters {
 		go context.handleConnectEdgeRouter(name, u, nil)
 	}
@@ -657,15 +899,21 @@ func (context *ContextImpl) RefreshServices() error {
 }
 
 func (context *ContextImpl) refreshServices(forceCheck bool) error {
+	context.faults.delayServiceRefresh(context.closeNotify)
+
 	if err := context.ensureApiSession(); err != nil {
 		return fmt.Errorf("failed to refresh services: %v", err)
 	}
 
+	if err := context.rateLimits.serviceRefresh.wait(gocontext.Background(), context.closeNotify); err != nil {
+		return fmt.Errorf("failed to refresh services: %v", err)
+	}
+
 	var checkService bool
 	var lastServiceUpdate *strfmt.DateTime
 	var err error
 
-	log := pfxlog.Logger()
+	log := context.log()
 	log.Debug("checking if service updates available")
 	if checkService, lastServiceUpdate, err = context.CtrlClt.IsServiceListUpdateAvailable(); err != nil {
 		log.WithError(err).Error("failed to check if service list update is available")
@@ -705,8 +953,12 @@ func (context *ContextImpl) refreshServices(forceCheck bool) error {
 		}
 		context.CtrlClt.lastServiceUpdate = lastServiceUpdate
 		context.processServiceUpdates(services)
+		context.servicesLoaded.Store(true)
+		context.setConnectionState(ConnectionStateReady, ConnectionStateSyncing)
 	}
 
+	context.refreshFlags()
+
 	return nil
 }
 
@@ -715,9 +967,13 @@ func (context *ContextImpl) RefreshService(serviceName string) (*rest_model.Serv
 		return nil, fmt.Errorf("failed to refresh service: %v", err)
 	}
 
+	if err := context.rateLimits.serviceRefresh.wait(gocontext.Background(), context.closeNotify); err != nil {
+		return nil, fmt.Errorf("failed to refresh service: %v", err)
+	}
+
 	var err error
 
-	log := pfxlog.Logger().WithField("serviceName", serviceName)
+	log := context.log().WithField("serviceName", serviceName)
 
 	log.Debug("refreshing service")
 
@@ -750,7 +1006,7 @@ func (context *ContextImpl) updateTokenOnAllErs(apiSession apis.ApiSession) {
 			erKey := tpl.Key
 			go func() {
 				if err := erConn.UpdateToken(apiSession.GetToken(), 10*time.Second); err != nil {
-					pfxlog.Logger().WithError(err).WithField("er", erKey).Warn("error updating apiSession token to connected ER")
+					context.log().WithError(err).WithField("er", erKey).Warn("error updating apiSession token to connected ER")
 				}
 			}()
 		}
@@ -758,17 +1014,8 @@ func (context *ContextImpl) updateTokenOnAllErs(apiSession apis.ApiSession) {
 }
 
 func (context *ContextImpl) runRefreshes() {
-	log := pfxlog.Logger()
-	svcRefreshInterval := context.options.RefreshInterval
-
-	if svcRefreshInterval == 0 {
-		svcRefreshInterval = DefaultServiceRefreshInterval
-	}
-	if svcRefreshInterval < MinRefreshInterval {
-		svcRefreshInterval = MinRefreshInterval
-	}
-	svcRefreshTick := time.NewTicker(svcRefreshInterval)
-	defer svcRefreshTick.Stop()
+	log := context.log()
+	svcRefreshInterval := context.getServiceRefreshInterval()
 
 	sessionRefreshInterval := context.options.SessionRefreshInterval
 	if sessionRefreshInterval == 0 {
@@ -778,46 +1025,36 @@ func (context *ContextImpl) runRefreshes() {
 		sessionRefreshInterval = MinRefreshInterval
 	}
 
-	sessionRefreshTick := time.NewTicker(sessionRefreshInterval)
-	defer sessionRefreshTick.Stop()
+	if jitter := context.options.RateLimits.getStartupJitter(); jitter > 0 {
+		log.Debugf("delaying periodic refreshes by %v", jitter)
+		select {
+		case <-context.closeNotify:
+			return
+		case <-time.After(jitter):
+		}
+	}
 
-	refreshAt := time.Now().Add(30 * time.Second)
+	svcRefreshTick := time.NewTicker(svcRefreshInterval)
+	defer svcRefreshTick.Stop()
 
-	if currentApiSession := context.CtrlClt.GetCurrentApiSession(); currentApiSession != nil && currentApiSession.GetExpiresAt() != nil {
-		refreshAt = (*currentApiSession.GetExpiresAt()).Add(-10 * time.Second)
-	}
+	sessionRefreshTick := time.NewTicker(sessionRefreshInterval)
+	defer sessionRefreshTick.Stop()
 
 	for {
 		select {
 		case <-context.closeNotify:
 			return
 
-		case <-time.After(time.Until(refreshAt)):
-			apiSession := context.CtrlClt.GetCurrentApiSession()
-
-			if apiSession == nil {
-				pfxlog.Logger().Warn("could not refresh api session, current api session is nil")
-				continue
-			}
-
-			newApiSession, err := context.CtrlClt.Refresh()
-
-			if err != nil {
-				log.Errorf("could not refresh apiSession: %v", err)
-
-				refreshAt = time.Now().Add(5 * time.Second)
-			} else {
-				exp := newApiSession.GetExpiresAt()
-				refreshAt = exp.Add(-10 * time.Second)
-				log.Debugf("apiSession refreshed, new expiration[%s]", *exp)
-
-				context.updateTokenOnAllErs(newApiSession)
-			}
+		case <-context.serviceRefreshIntervalChanged:
+			svcRefreshInterval = context.getServiceRefreshInterval()
+			log.Debugf("service refresh interval changed to %v", svcRefreshInterval)
+			svcRefreshTick.Reset(svcRefreshInterval)
 
 		case <-svcRefreshTick.C:
 			log.Debug("refreshing services")
 			if err := context.refreshServices(false); err != nil {
 				log.WithError(err).Error("failed to load service updates")
+				context.recordError(RecentServiceRefreshFailed, err)
 			}
 
 		case <-sessionRefreshTick.C:
@@ -829,7 +1066,7 @@ func (context *ContextImpl) runRefreshes() {
 
 func (context *ContextImpl) EnsureAuthenticated(options edge.ConnOptions) error {
 	operation := func() error {
-		pfxlog.Logger().Info("attempting to establish new api session")
+		context.log().Info("attempting to establish new api session")
 		err := context.Authenticate()
 		if err != nil {
 			return backoff.Permanent(err)
@@ -888,16 +1125,25 @@ func (context *ContextImpl) setUnauthenticated() {
 }
 
 func (context *ContextImpl) authenticate() error {
-	logrus.Debug("attempting to authenticate")
+	context.log().Debug("attempting to authenticate")
 	context.services = cmap.New[*rest_model.ServiceDetail]()
 	context.sessions = cmap.New[*rest_model.SessionDetail]()
 	context.intercepts = cmap.New[*edge.InterceptV1Config]()
+	context.serviceConfigs = newServiceConfigCache()
+	context.servicesLoaded.Store(false)
 
 	context.setUnauthenticated()
+	context.setConnectionState(ConnectionStateAuthenticating)
+
+	if err := context.reauthenticateWithHandler(); err != nil {
+		context.onAuthFailed(err)
+		return err
+	}
 
 	apiSession, err := context.CtrlClt.Authenticate()
 
 	if err != nil {
+		context.onAuthFailed(err)
 		return err
 	}
 
@@ -906,6 +1152,7 @@ func (context *ContextImpl) authenticate() error {
 		context.Emit(EventAuthenticationStatePartial, apiSession)
 		for _, authQuery := range apiSession.GetAuthQueries() {
 			if err := context.handleAuthQuery(authQuery); err != nil {
+				context.onAuthFailed(err)
 				return err
 			}
 		}
@@ -916,6 +1163,15 @@ func (context *ContextImpl) authenticate() error {
 	return context.onFullAuth(apiSession)
 }
 
+// onAuthFailed records a failed authentication and, if the Context had been authenticated before, starts
+// reconnecting in the background.
+func (context *ContextImpl) onAuthFailed(err error) {
+	context.counters.authFailures.Add(1)
+	context.Emit(EventAuthenticationFailed, err)
+	context.setConnectionState(ConnectionStateDisconnected)
+	context.startReconnect()
+}
+
 func (context *ContextImpl) Reauthenticate() error {
 	context.CtrlClt.ApiSession.Store(nil)
 	context.CtrlClt.ApiSessionCertificate = nil
@@ -931,13 +1187,13 @@ func (context *ContextImpl) Authenticate() error {
 		if time.Since(context.lastSuccessfulApiSessionRefresh) < 5*time.Second {
 			return nil
 		}
-		logrus.Debug("previous apiSession detected, checking if valid")
+		context.log().Debug("previous apiSession detected, checking if valid")
 		if err := context.RefreshApiSessionWithBackoff(); err == nil {
-			logrus.Info("previous apiSession refreshed")
+			context.log().Info("previous apiSession refreshed")
 			context.lastSuccessfulApiSessionRefresh = time.Now()
 			return nil
 		} else {
-			logrus.WithError(err).Info("previous apiSession failed to refresh, attempting to authenticate")
+			context.log().WithError(err).Info("previous apiSession failed to refresh, attempting to authenticate")
 		}
 	}
 
@@ -952,6 +1208,10 @@ func (context *ContextImpl) RefreshApiSessionWithBackoff() error {
 	expBackoff.MaxElapsedTime = 24 * time.Hour
 
 	operation := func() error {
+		if err := context.rateLimits.apiSessionRefresh.wait(gocontext.Background(), context.closeNotify); err != nil {
+			return backoff.Permanent(err)
+		}
+
 		newApiSession, err := context.CtrlClt.Refresh()
 		if err == nil {
 			context.updateTokenOnAllErs(newApiSession)
@@ -960,10 +1220,10 @@ func (context *ContextImpl) RefreshApiSessionWithBackoff() error {
 
 		unauthorizedErr := &current_api_session.GetCurrentAPISessionUnauthorized{}
 		if errors.As(err, &unauthorizedErr) {
-			logrus.Info("previous apiSession expired")
+			context.log().Info("previous apiSession expired")
 			return backoff.Permanent(err)
 		}
-		logrus.WithError(err).Info("unable to refresh apiSession, will retry")
+		context.log().WithError(err).Info("unable to refresh apiSession, will retry")
 		return err
 	}
 
@@ -975,7 +1235,7 @@ func (context *ContextImpl) CloseAllEdgeRouterConns() {
 		key, val := entry.Key, entry.Val
 		if !val.IsClosed() {
 			if err := val.Close(); err != nil {
-				pfxlog.Logger().WithError(err).Error("error while closing edge router connection")
+				context.log().WithError(err).Error("error while closing edge router connection")
 			}
 		}
 
@@ -989,14 +1249,18 @@ func (context *ContextImpl) onFullAuth(apiSession apis.ApiSession) error {
 		if context.options.OnContextReady != nil {
 			context.options.OnContextReady(context)
 		}
-		go context.runRefreshes()
+		context.spawn(context.runRefreshes)
+		context.spawn(context.runApiSessionRefreshes)
 
 		metricsTags := map[string]string{
 			"srcId": apiSession.GetIdentityId(),
 		}
 
-		context.metrics = metrics.NewRegistry(apiSession.GetIdentityName(), metricsTags)
+		context.metrics = context.options.newMetricsRegistry(apiSession.GetIdentityName(), metricsTags)
 	})
+	context.fullyAuthenticated.Store(true)
+	context.apiSessionRefresher.notifyReset()
+	context.setConnectionState(ConnectionStateSyncing)
 
 	context.Emit(EventAuthenticationStateFull, apiSession)
 
@@ -1041,8 +1305,16 @@ func (context *ContextImpl) handleAuthQuery(authQuery *rest_model.AuthQueryDetai
 
 		context.Emit(EventMfaTotpCode, authQuery, MfaCodeResponse(context.authenticateMfa))
 
+		if authHandler := context.options.AuthHandler; authHandler != nil {
+			code, err := authHandler.AnswerAuthQuery(context, authQuery)
+			if err != nil {
+				return err
+			}
+			return context.authenticateMfa(code)
+		}
+
 		if handler == nil {
-			pfxlog.Logger().Debugf("no callback handler registered for provider: %v, event will still be emitted", *authQuery.Provider)
+			context.log().Debugf("no callback handler registered for provider: %v, event will still be emitted", *authQuery.Provider)
 		} else {
 			return handler(authQuery, context.authenticateMfa)
 		}
@@ -1054,11 +1326,96 @@ func (context *ContextImpl) handleAuthQuery(authQuery *rest_model.AuthQueryDetai
 }
 
 func (context *ContextImpl) Dial(serviceName string) (edge.Conn, error) {
-	defaultOptions := &DialOptions{ConnectTimeout: 5 * time.Second}
-	return context.DialWithOptions(serviceName, defaultOptions)
+	return context.DialWithOptions(serviceName, NewDialOptions())
 }
 
 func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOptions) (edge.Conn, error) {
+	return context.dialWithContext(gocontext.Background(), serviceName, options)
+}
+
+func (context *ContextImpl) DialWithContext(ctx gocontext.Context, serviceName string, opts ...DialOption) (edge.Conn, error) {
+	return context.DialWithOptionsContext(ctx, serviceName, NewDialOptions(opts...))
+}
+
+func (context *ContextImpl) DialWithOptionsContext(ctx gocontext.Context, serviceName string, options *DialOptions) (edge.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, errors.Wrapf(gocontext.DeadlineExceeded, "unable to dial service '%s'", serviceName)
+		}
+		if options.ConnectTimeout == 0 || remaining < options.ConnectTimeout {
+			optionsCopy := *options
+			optionsCopy.ConnectTimeout = remaining
+			options = &optionsCopy
+		}
+	}
+
+	type dialResult struct {
+		conn edge.Conn
+		err  error
+	}
+
+	resultC := make(chan dialResult, 1)
+	go func() {
+		conn, err := context.dialWithContext(ctx, serviceName, options)
+		resultC <- dialResult{conn: conn, err: err}
+	}()
+
+	select {
+	case result := <-resultC:
+		return result.conn, result.err
+	case <-ctx.Done():
+		// controller requests and the router connect exchange can't be interrupted, so clean up once they complete
+		go func() {
+			if result := <-resultC; result.conn != nil {
+				_ = result.conn.Close()
+			}
+		}()
+		return nil, errors.Wrapf(ctx.Err(), "unable to dial service '%s'", serviceName)
+	}
+}
+
+// dialWithContext dials the service, recording the dial's latency for DialLatencies.
+func (context *ContextImpl) dialWithContext(ctx gocontext.Context, serviceName string, options *DialOptions) (edge.Conn, error) {
+	if context.shuttingDown.Load() {
+		return nil, errors.Wrapf(ErrShuttingDown, "unable to dial service '%s'", serviceName)
+	}
+
+	defer context.trackWork(&context.queues.dials, MetricQueueDialsCompleted)()
+
+	start := time.Now()
+	conn, err := context.dialWithRetries(ctx, serviceName, options)
+	latency := time.Since(start)
+	context.dialLatencies.record(serviceName, latency, err)
+	context.recordDial(serviceName, latency, err)
+	return conn, err
+}
+
+// dialWithRetries dials the service, re-attempting failed dials as allowed by the options' RetryPolicy.
+func (context *ContextImpl) dialWithRetries(ctx gocontext.Context, serviceName string, options *DialOptions) (edge.Conn, error) {
+	policy := options.RetryPolicy
+
+	for attempt := 1; ; attempt++ {
+		conn, err := context.dialOnce(ctx, serviceName, options)
+		if err == nil || !policy.shouldRetry(attempt, err) {
+			return conn, err
+		}
+
+		delay := policy.delay(attempt)
+		context.log().WithError(err).WithField("service", serviceName).WithField("attempt", attempt).
+			WithField("delay", delay).Debug("dial failed, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+func (context *ContextImpl) dialOnce(ctx gocontext.Context, serviceName string, options *DialOptions) (edge.Conn, error) {
 	edgeDialOptions := &edge.DialOptions{
 		ConnectTimeout:  options.ConnectTimeout,
 		Identity:        options.Identity,
@@ -1069,13 +1426,33 @@ func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOpt
 		edgeDialOptions.ConnectTimeout = 15 * time.Second
 	}
 
+	if err := context.faults.dropDial(); err != nil {
+		return nil, errors.Wrapf(err, "unable to dial service '%s'", serviceName)
+	}
+
 	if err := context.ensureApiSession(); err != nil {
 		return nil, fmt.Errorf("failed to dial: %v", err)
 	}
 
 	svc, ok := context.GetService(serviceName)
 	if !ok {
-		return nil, errors.Errorf("service '%s' not found", serviceName)
+		return nil, &ServiceNotFoundError{ServiceName: serviceName}
+	}
+
+	if edgeDialOptions.Identity == "" {
+		strategy := options.TerminatorStrategy
+		if strategy == nil && options.AffinityKey != "" {
+			strategy = StickyTerminatorStrategy(options.AffinityKey)
+		}
+		if options.Variant != "" {
+			identity, err := context.selectVariantIdentity(svc, options.Variant, strategy)
+			if err != nil {
+				return nil, err
+			}
+			edgeDialOptions.Identity = identity
+		} else if strategy != nil {
+			edgeDialOptions.Identity = context.selectTerminatorIdentity(serviceName, strategy)
+		}
 	}
 
 	context.CtrlClt.PostureCache.AddActiveService(*svc.ID)
@@ -1085,15 +1462,15 @@ func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOpt
 	session, err := context.GetSession(*svc.ID)
 	if err != nil {
 		context.deleteServiceSessions(*svc.ID)
-		if session, err = context.createSessionWithBackoff(svc, SessionType(SessionDial), options); err != nil {
+		if session, err = context.createSessionWithBackoff(ctx, svc, SessionType(SessionDial), options); err != nil {
 			return nil, errors.Wrapf(err, "unable to dial service '%v'", serviceName)
 		}
 	}
 
-	pfxlog.Logger().WithField("sessionId", *session.ID).WithField("sessionToken", session.Token).Debug("connecting with session")
-	conn, err := context.dialSession(svc, session, edgeDialOptions)
+	context.log().WithField("sessionId", *session.ID).WithField("sessionToken", session.Token).Debug("connecting with session")
+	conn, err := context.dialSession(ctx, svc, session, edgeDialOptions, options.edgeRouterPolicy(context.logger))
 	if err == nil {
-		return conn, nil
+		return context.wrapDialConn(conn, options)
 	}
 
 	var refreshErr error
@@ -1103,20 +1480,34 @@ func (context *ContextImpl) DialWithOptions(serviceName string, options *DialOpt
 	}
 
 	context.deleteServiceSessions(*svc.ID)
-	if session, refreshErr = context.createSessionWithBackoff(svc, SessionType(SessionDial), options); refreshErr != nil {
+	if session, refreshErr = context.createSessionWithBackoff(ctx, svc, SessionType(SessionDial), options); refreshErr != nil {
 		// couldn't create a new session, report the error
 		return nil, errors.Wrapf(refreshErr, "unable to dial service '%s'", serviceName)
 	}
 
 	// retry with new session
-	conn, err = context.dialSession(svc, session, edgeDialOptions)
+	conn, err = context.dialSession(ctx, svc, session, edgeDialOptions, options.edgeRouterPolicy(context.logger))
 	if err == nil {
-		return conn, nil
+		return context.wrapDialConn(conn, options)
 	}
 
 	return nil, errors.Wrapf(err, "unable to dial service '%s'", serviceName)
 }
 
+// wrapDialConn applies any connection overlays requested in the DialOptions to a newly dialed connection.
+func (context *ContextImpl) wrapDialConn(conn edge.Conn, options *DialOptions) (edge.Conn, error) {
+	if len(options.PSK) == 0 {
+		return conn, nil
+	}
+
+	pskConn, err := edge.NewPskConn(conn, options.PSK)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return pskConn, nil
+}
+
 // GetServiceForAddr finds the service with intercept that matches best to given address
 func (context *ContextImpl) GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error) {
 	var svc *rest_model.ServiceDetail
@@ -1151,23 +1542,27 @@ func (context *ContextImpl) GetServiceForAddr(network, hostname string, port uin
 }
 
 func (context *ContextImpl) dialServiceFromAddr(service, network, host string, port uint16) (edge.Conn, error) {
+	options := &DialOptions{
+		ConnectTimeout: 5 * time.Second,
+		AppData:        interceptAppData(network, host, port),
+	}
+
+	return context.DialWithOptions(service, options)
+}
+
+// interceptAppData returns the app data describing the intercepted destination of a dial, as tunnelers send it.
+func interceptAppData(network, host string, port uint16) []byte {
 	appdata := make(map[string]any)
 	appdata["dst_protocol"] = network
 	appdata["dst_port"] = strconv.Itoa(int(port))
-	ip := net.ParseIP(host)
-	if len(ip) != 0 {
-		appdata["dst_ip"] = host
+	if ip, ok := edge.ParseHostIP(host); ok {
+		appdata["dst_ip"] = ip.String()
 	} else {
 		appdata["dst_hostname"] = host
 	}
 
-	options := &DialOptions{
-		ConnectTimeout: 5 * time.Second,
-	}
 	appdataJson, _ := json.Marshal(appdata)
-	options.AppData = appdataJson
-
-	return context.DialWithOptions(service, options)
+	return appdataJson
 }
 
 func (context *ContextImpl) DialAddr(network string, addr string) (edge.Conn, error) {
@@ -1192,11 +1587,14 @@ func (context *ContextImpl) DialAddr(network string, addr string) (edge.Conn, er
 	return context.dialServiceFromAddr(*svc.Name, network, host, uint16(port))
 }
 
-func (context *ContextImpl) dialSession(service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.DialOptions) (edge.Conn, error) {
-	edgeConnFactory, err := context.getEdgeRouterConn(session, options)
+func (context *ContextImpl) dialSession(ctx gocontext.Context, service *rest_model.ServiceDetail, session *rest_model.SessionDetail, options *edge.DialOptions, routerPolicy edgeRouterPolicy) (edge.Conn, error) {
+	edgeConnFactory, err := context.getEdgeRouterConn(ctx, session, options, routerPolicy)
 	if err != nil {
 		return nil, err
 	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 	return edgeConnFactory.Connect(service, session, options)
 }
 
@@ -1214,6 +1612,10 @@ func (context *ContextImpl) Listen(serviceName string) (edge.Listener, error) {
 }
 
 func (context *ContextImpl) ListenWithOptions(serviceName string, options *ListenOptions) (edge.Listener, error) {
+	if context.shuttingDown.Load() {
+		return nil, errors.Wrapf(ErrShuttingDown, "unable to listen on service '%s'", serviceName)
+	}
+
 	if err := context.ensureApiSession(); err != nil {
 		return nil, fmt.Errorf("failed to listen: %v", err)
 	}
@@ -1237,6 +1639,26 @@ func (context *ContextImpl) listenSession(service *rest_model.ServiceDetail, opt
 	edgeListenOptions.Identity = options.Identity
 	edgeListenOptions.BindUsingEdgeIdentity = options.BindUsingEdgeIdentity
 	edgeListenOptions.ManualStart = options.ManualStart
+	edgeListenOptions.MaxBindAttempts = options.MaxBindAttempts
+	edgeListenOptions.BindRetryInterval = options.BindRetryInterval
+	edgeListenOptions.RebindInterval = options.RebindInterval
+	edgeListenOptions.MaxRebindInterval = options.MaxRebindInterval
+	edgeListenOptions.AcceptQueueSize = context.options.getAcceptQueueSize(options)
+	edgeListenOptions.AcceptQueueFullPolicy = options.AcceptQueueFullPolicy
+	edgeListenOptions.OnAcceptShed = func() {
+		if context.metrics != nil {
+			context.metrics.Meter(MetricListenerAcceptShed).Mark(1)
+		}
+	}
+	if options.IdleTimeout > 0 {
+		edgeListenOptions.IdleTimeout = options.IdleTimeout
+		edgeListenOptions.BeforeIdleClose = context.listenBeforeIdleClose(options.BeforeIdleClose)
+		edgeListenOptions.OnIdleClose = func(edge.Conn, time.Duration) {
+			if context.metrics != nil {
+				context.metrics.Meter(MetricListenerIdleEvictions).Mark(1)
+			}
+		}
+	}
 
 	if edgeListenOptions.ConnectTimeout == 0 {
 		edgeListenOptions.ConnectTimeout = time.Minute
@@ -1246,15 +1668,23 @@ func (context *ContextImpl) listenSession(service *rest_model.ServiceDetail, opt
 		edgeListenOptions.MaxTerminators = 1
 	}
 
-	if listenerMgr, err := newListenerManager(service, context, edgeListenOptions, options.WaitForNEstablishedListeners); err != nil {
+	listenerMgr, err := newListenerManager(service, context, edgeListenOptions, options.WaitForNEstablishedListeners)
+	if err != nil {
 		return nil, err
-	} else {
-		return listenerMgr.listener, nil
 	}
+	context.registerAcceptQueueGauge()
+
+	if len(options.PSK) != 0 {
+		return edge.NewPskListener(listenerMgr.listener, options.PSK)
+	}
+
+	return listenerMgr.listener, nil
 }
 
-func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail, options edge.ConnOptions) (edge.RouterConn, error) {
-	logger := pfxlog.Logger().WithField("sessionId", *session.ID)
+// getEdgeRouterConn returns the connection to the lowest latency edge router of the session, connecting to the session's
+// routers if none are connected yet. Only the routers selected by routerPolicy are considered.
+func (context *ContextImpl) getEdgeRouterConn(ctx gocontext.Context, session *rest_model.SessionDetail, options edge.ConnOptions, routerPolicy edgeRouterPolicy) (edge.RouterConn, error) {
+	logger := context.log().WithField("sessionId", *session.ID)
 
 	if len(session.EdgeRouters) == 0 {
 		if refreshedSession, err := context.refreshSession(session); err != nil {
@@ -1274,19 +1704,25 @@ func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail,
 		}
 	}
 
+	if err := context.checkRestrictedEgressRouters(session); err != nil {
+		return nil, err
+	}
+
+	edgeRouters := routerPolicy.apply(session.EdgeRouters)
+
 	// go through connected routers first
-	bestLatency := time.Duration(math.MaxInt64)
-	var bestER edge.RouterConn
+	var connected []edge.RouterConn
+	var unhealthy []edge.RouterConn
 	var unconnected []*rest_model.SessionEdgeRouter
-	for _, edgeRouter := range session.EdgeRouters {
+	for _, edgeRouter := range edgeRouters {
 		for proto, addr := range edgeRouter.SupportedProtocols {
 			addr = strings.Replace(addr, "://", ":", 1)
 			edgeRouter.SupportedProtocols[proto] = addr
-			if er, found := context.routerConnections.Get(addr); found {
-				h := context.metrics.Histogram("latency." + addr).(metrics2.Histogram)
-				if h.Mean() < float64(bestLatency) {
-					bestLatency = time.Duration(int64(h.Mean()))
-					bestER = er
+			if er, found := context.routerConnections.Get(addr); found && !er.IsClosed() && !context.isRouterConnAtCapacity(er) {
+				if context.isRouterConnHealthy(er) {
+					connected = append(connected, er)
+				} else {
+					unhealthy = append(unhealthy, er)
 				}
 			} else {
 				unconnected = append(unconnected, edgeRouter)
@@ -1294,16 +1730,27 @@ func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail,
 		}
 	}
 
+	// only use unhealthy routers if there's nothing else to try
+	if len(connected) == 0 && len(unconnected) == 0 {
+		connected = unhealthy
+	}
+
+	serviceId := ""
+	if session.Service != nil {
+		serviceId = session.Service.ID
+	}
+	bestER, bestLatency := context.selectRouterConn(serviceId, connected)
+
 	var ch chan *edgeRouterConnResult
 	if bestER == nil {
 		ch = make(chan *edgeRouterConnResult, len(unconnected))
+	} else if context.options.isLowMemory() {
+		unconnected = nil
 	}
 
 	for _, edgeRouter := range unconnected {
-		for _, addr := range edgeRouter.SupportedProtocols {
-			if context.options.isEdgeRouterUrlAccepted(addr) {
-				go context.handleConnectEdgeRouter(*edgeRouter.Name, addr, ch)
-			}
+		for _, addr := range context.getEdgeRouterUrls(*edgeRouter.Name, edgeRouter.SupportedProtocols) {
+			go context.handleConnectEdgeRouter(*edgeRouter.Name, addr, ch)
 		}
 	}
 
@@ -1323,12 +1770,19 @@ func (context *ContextImpl) getEdgeRouterConn(session *rest_model.SessionDetail,
 			}
 		case <-timeout:
 			return nil, errors.New("no edge routers connected in time")
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "no edge routers connected")
 		}
 	}
 }
 
 func (context *ContextImpl) handleConnectEdgeRouter(routerName, ingressUrl string, ret chan *edgeRouterConnResult) {
 	result := context.connectEdgeRouter(routerName, ingressUrl)
+	if result.err != nil {
+		if fallbackUrl := context.activateWssFallback(routerName, ingressUrl); fallbackUrl != "" {
+			result = context.connectEdgeRouter(routerName, fallbackUrl)
+		}
+	}
 
 	if ret != nil {
 		select {
@@ -1339,7 +1793,7 @@ func (context *ContextImpl) handleConnectEdgeRouter(routerName, ingressUrl strin
 }
 
 func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *edgeRouterConnResult {
-	logger := pfxlog.Logger().WithField("router", routerName)
+	logger := context.log().WithField("router", routerName)
 
 	if conn, found := context.routerConnections.Get(ingressUrl); found {
 		if !conn.IsClosed() {
@@ -1353,6 +1807,8 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *ed
 		}
 	}
 
+	defer context.trackWork(&context.queues.routerConnects, MetricQueueRouterConnectsCompleted)()
+
 	ingAddr, err := transport.ParseAddress(ingressUrl)
 	if err != nil {
 		logger.WithError(err).Errorf("failed to parse url[%s]", ingressUrl)
@@ -1363,6 +1819,16 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *ed
 		}
 	}
 
+	ingAddr = wssEdgeRouterAddress(ingAddr)
+	if ingAddr, err = context.proxyEdgeRouterAddress(ingAddr); err != nil {
+		logger.WithError(err).Errorf("failed to determine proxy for url[%s]", ingressUrl)
+		return &edgeRouterConnResult{
+			routerUrl:  ingressUrl,
+			routerName: routerName,
+			err:        err,
+		}
+	}
+
 	currentApiSession := context.CtrlClt.GetCurrentApiSession()
 	if currentApiSession == nil {
 		return &edgeRouterConnResult{
@@ -1383,15 +1849,15 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *ed
 		}
 	}
 
-	dialer := channel.NewClassicDialer(identity.NewIdentity(id), ingAddr, map[int32][]byte{
+	dialer := channel.NewClassicDialer(identity.NewIdentity(context.getEdgeRouterIdentity(id)), ingAddr, map[int32][]byte{
 		edge.SessionTokenHeader: context.CtrlClt.GetCurrentApiSession().GetToken(),
 	})
 
 	start := time.Now().UnixNano()
 	edgeConn := network.NewEdgeConnFactory(routerName, ingressUrl, context)
-	options := channel.DefaultOptions()
-	options.ConnectTimeout = 15 * time.Second
-	ch, err := channel.NewChannel(fmt.Sprintf("ziti-sdk[router=%v]", ingressUrl), dialer, edgeConn, options)
+	options := context.getChannelOptions()
+	bindHandler := context.getRouterBindHandler(routerName, ingressUrl, edgeConn)
+	ch, err := channel.NewChannel(fmt.Sprintf("ziti-sdk[router=%v]", ingressUrl), dialer, bindHandler, options)
 	if err != nil {
 		logger.Error(err)
 		return &edgeRouterConnResult{
@@ -1406,9 +1872,9 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *ed
 	if versionHeader, found := ch.Underlay().Headers()[channel.HelloVersionHeader]; found {
 		versionInfo, err := versions.StdVersionEncDec.Decode(versionHeader)
 		if err != nil {
-			pfxlog.Logger().Errorf("could not parse hello version header: %v", err)
+			context.log().Errorf("could not parse hello version header: %v", err)
 		} else {
-			pfxlog.Logger().
+			context.log().
 				WithField("os", versionInfo.OS).
 				WithField("arch", versionInfo.Arch).
 				WithField("version", versionInfo.Version).
@@ -1425,16 +1891,18 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *ed
 	useConn := context.routerConnections.Upsert(ingressUrl, edgeConn,
 		func(exist bool, oldV edge.RouterConn, newV edge.RouterConn) edge.RouterConn {
 			if exist { // use the routerConnection already in the map, close new one
-				pfxlog.Logger().Infof("connection to %s already established, closing duplicate connection", ingressUrl)
+				context.log().Infof("connection to %s already established, closing duplicate connection", ingressUrl)
 				go func() {
 					if err := newV.Close(); err != nil {
-						pfxlog.Logger().Errorf("unable to close router connection (%v)", err)
+						context.log().Errorf("unable to close router connection (%v)", err)
 					}
 				}()
 				return oldV
 			}
 			h := context.metrics.Histogram("latency." + ingressUrl)
 			h.Update(int64(connectTime))
+			context.recordRouterLatency(routerName, ingressUrl, connectTime)
+			context.counters.routerConnected(routerName)
 
 			latencyProbeConfig := &latency.ProbeConfig{
 				Channel:  ch,
@@ -1442,21 +1910,25 @@ func (context *ContextImpl) connectEdgeRouter(routerName, ingressUrl string) *ed
 				Timeout:  LatencyCheckTimeout,
 				ResultHandler: func(resultNanos int64) {
 					h.Update(resultNanos)
+					context.recordRouterLatency(routerName, ingressUrl, time.Duration(resultNanos))
 				},
 				TimeoutHandler: func() {
-					logrus.Errorf("latency timeout after [%s]", LatencyCheckTimeout)
+					context.log().Errorf("latency timeout after [%s]", LatencyCheckTimeout)
 					if ch.GetTimeSinceLastRead() > LatencyCheckInterval {
 						// No traffic on channel, no response. Close the channel
-						logrus.Error("no read traffic on channel since before latency probe was sent, closing channel")
+						context.log().Error("no read traffic on channel since before latency probe was sent, closing channel")
 						_ = ch.Close()
 					}
 				},
 				ExitHandler: func() {
 					h.Dispose()
+					context.removeRouterLatency(ingressUrl)
 				},
 			}
 
-			go latency.ProbeLatencyConfigurable(latencyProbeConfig)
+			context.spawn(func() {
+				latency.ProbeLatencyConfigurable(latencyProbeConfig)
+			})
 			return newV
 		})
 
@@ -1481,7 +1953,7 @@ func (context *ContextImpl) GetServiceId(name string) (string, bool, error) {
 
 func (context *ContextImpl) GetService(name string) (*rest_model.ServiceDetail, bool) {
 	if err := context.ensureApiSession(); err != nil {
-		pfxlog.Logger().Warnf("failed to get service: %v", err)
+		context.log().Warnf("failed to get service: %v", err)
 		return nil, false
 	}
 
@@ -1513,6 +1985,21 @@ func (context *ContextImpl) GetServiceTerminators(serviceName string, offset, li
 	return context.CtrlClt.GetServiceTerminators(svc, offset, limit)
 }
 
+func (context *ContextImpl) QueryServices(filter string, limit, offset int) ([]*rest_model.ServiceDetail, int, error) {
+	if err := context.ensureApiSession(); err != nil {
+		return nil, 0, fmt.Errorf("failed to query services: %v", err)
+	}
+
+	if limit <= 0 || limit > maxServicePageSize {
+		limit = maxServicePageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return context.CtrlClt.QueryServices(filter, limit, offset)
+}
+
 func (context *ContextImpl) GetSession(serviceId string) (*rest_model.SessionDetail, error) {
 	return context.getOrCreateSession(serviceId, SessionType(SessionDial))
 }
@@ -1531,8 +2018,14 @@ func (context *ContextImpl) getOrCreateSession(serviceId string, sessionType Ses
 		}
 	}
 
+	if err := context.rateLimits.sessionCreate.wait(gocontext.Background(), context.closeNotify); err != nil {
+		return nil, err
+	}
+
 	context.CtrlClt.PostureCache.AddActiveService(serviceId)
+	done := context.trackWork(&context.queues.sessions, MetricQueueSessionsCompleted)
 	session, err := context.CtrlClt.CreateSession(serviceId, sessionType)
+	done()
 
 	if err != nil {
 		return nil, err
@@ -1541,7 +2034,7 @@ func (context *ContextImpl) getOrCreateSession(serviceId string, sessionType Ses
 	return session, nil
 }
 
-func (context *ContextImpl) createSessionWithBackoff(service *rest_model.ServiceDetail, sessionType SessionType, options edge.ConnOptions) (*rest_model.SessionDetail, error) {
+func (context *ContextImpl) createSessionWithBackoff(ctx gocontext.Context, service *rest_model.ServiceDetail, sessionType SessionType, options edge.ConnOptions) (*rest_model.SessionDetail, error) {
 	expBackoff := backoff.NewExponentialBackOff()
 
 	if sessionType == SessionType(rest_model.DialBindDial) {
@@ -1557,7 +2050,7 @@ func (context *ContextImpl) createSessionWithBackoff(service *rest_model.Service
 	operation := func() error {
 		latestSvc, _ := context.services.Get(*service.Name)
 		if latestSvc != nil && *latestSvc.ID != *service.ID {
-			pfxlog.Logger().
+			context.log().
 				WithField("serviceName", *service.Name).
 				WithField("oldServiceId", *service.ID).
 				WithField("newServiceId", *latestSvc.ID).
@@ -1578,12 +2071,12 @@ func (context *ContextImpl) createSessionWithBackoff(service *rest_model.Service
 		context.cacheSession("create", session)
 	}
 
-	return session, backoff.Retry(operation, expBackoff)
+	return session, backoff.Retry(operation, backoff.WithContext(expBackoff, ctx))
 }
 
 func (context *ContextImpl) createSession(service *rest_model.ServiceDetail, sessionType SessionType) (*rest_model.SessionDetail, error) {
 	start := time.Now()
-	logger := pfxlog.Logger()
+	logger := context.log()
 	logger.Debugf("establishing %s session to service %s", sessionType, *service.Name)
 	session, err := context.getOrCreateSession(*service.ID, sessionType)
 	if err != nil {
@@ -1633,6 +2126,10 @@ func (context *ContextImpl) refreshSession(session *rest_model.SessionDetail) (*
 func (context *ContextImpl) cacheSession(op string, session *rest_model.SessionDetail) {
 	sessionKey := fmt.Sprintf("%s:%s", *session.ServiceID, *session.Type)
 
+	if op == "create" {
+		context.eventBus.publish(SessionCreatedEvent{Session: session})
+	}
+
 	if *session.Type == SessionDial {
 		if op == "create" {
 			context.sessions.Set(sessionKey, session)
@@ -1665,6 +2162,36 @@ func (context *ContextImpl) Close() {
 	}
 }
 
+// CloseWithContext is Shutdown, so that the API Session and sessions are maintained while connections drain.
+func (context *ContextImpl) CloseWithContext(ctx gocontext.Context) error {
+	return context.Shutdown(ctx)
+}
+
+// drainEdgeRouterConns waits for all connections multiplexed over the edge router connections to close.
+func (context *ContextImpl) drainEdgeRouterConns(ctx gocontext.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		active := 0
+		for entry := range context.routerConnections.IterBuffered() {
+			if !entry.Val.IsClosed() {
+				active += entry.Val.GetActiveConnCount()
+			}
+		}
+
+		if active == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "%d connections still active", active)
+		}
+	}
+}
+
 func (context *ContextImpl) Metrics() metrics.Registry {
 	return context.metrics
 }
@@ -1673,6 +2200,105 @@ func (context *ContextImpl) EnrollZitiMfa() (*rest_model.DetailMfa, error) {
 	return context.CtrlClt.EnrollMfa()
 }
 
+func (context *ContextImpl) GetAuthenticators() ([]*rest_model.AuthenticatorDetail, error) {
+	return context.CtrlClt.GetAuthenticators()
+}
+
+func (context *ContextImpl) getAuthenticator(method string, match func(*rest_model.AuthenticatorDetail) bool) (*rest_model.AuthenticatorDetail, error) {
+	authenticators, err := context.CtrlClt.GetAuthenticators()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*rest_model.AuthenticatorDetail
+	for _, authenticator := range authenticators {
+		if stringz.OrEmpty(authenticator.Method) == method {
+			if match != nil && match(authenticator) {
+				return authenticator, nil
+			}
+			candidates = append(candidates, authenticator)
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	return nil, errors.Errorf("expected exactly one %s authenticator for the current identity, found %d", method, len(candidates))
+}
+
+func (context *ContextImpl) UpdatePassword(currentPassword, newPassword string) error {
+	authenticator, err := context.getAuthenticator("updb", nil)
+	if err != nil {
+		return err
+	}
+
+	if err = context.CtrlClt.UpdatePassword(*authenticator.ID, authenticator.Username, currentPassword, newPassword); err != nil {
+		return err
+	}
+
+	if updbCredentials, ok := context.CtrlClt.Credentials.(*apis.UpdbCredentials); ok {
+		updbCredentials.Password = newPassword
+	}
+
+	return nil
+}
+
+func (context *ContextImpl) RotateCertAuthenticator() ([]*x509.Certificate, crypto.PrivateKey, error) {
+	credentials := context.CtrlClt.Credentials
+
+	var currentCert *x509.Certificate
+	var currentKey crypto.PrivateKey
+	if tlsCerts := credentials.TlsCerts(); len(tlsCerts) > 0 && len(tlsCerts[0].Certificate) > 0 {
+		currentKey = tlsCerts[0].PrivateKey
+		if cert, err := x509.ParseCertificate(tlsCerts[0].Certificate[0]); err == nil {
+			currentCert = cert
+		}
+	}
+
+	authenticator, err := context.getAuthenticator("cert", func(detail *rest_model.AuthenticatorDetail) bool {
+		if currentCert == nil {
+			return false
+		}
+		certs := nfPem.PemBytesToCertificates([]byte(detail.CertPem))
+		return len(certs) > 0 && bytes.Equal(certs[0].Raw, currentCert.Raw)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var newKey crypto.Signer
+	if rsaKey, ok := currentKey.(*rsa.PrivateKey); ok {
+		newKey, err = rsa.GenerateKey(cryptorand.Reader, rsaKey.N.BitLen())
+	} else {
+		newKey, err = ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not generate private key for rotated certificate")
+	}
+
+	var subject pkix.Name
+	if currentCert != nil {
+		subject = currentCert.Subject
+	}
+
+	certs, err := context.CtrlClt.ExtendCertAuthenticator(*authenticator.ID, subject, newKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = context.CtrlClt.VerifyCertAuthenticatorExtension(*authenticator.ID, certs[0]); err != nil {
+		return nil, nil, err
+	}
+
+	newCredentials := apis.NewCertCredentials(certs, newKey)
+	newCredentials.CaPool = credentials.GetCaPool()
+	newCredentials.ConfigTypes = context.CtrlClt.ConfigTypes
+	context.SetCredentials(newCredentials)
+
+	return certs, newKey, nil
+}
+
 func (context *ContextImpl) VerifyZitiMfa(code string) error {
 	return context.CtrlClt.VerifyMfa(code)
 }
@@ -1680,6 +2306,18 @@ func (context *ContextImpl) RemoveZitiMfa(code string) error {
 	return context.CtrlClt.RemoveMfa(code)
 }
 
+func (context *ContextImpl) GetZitiMfa() (*rest_model.DetailMfa, error) {
+	return context.CtrlClt.GetMfa()
+}
+
+func (context *ContextImpl) GetZitiMfaRecoveryCodes(code string) ([]string, error) {
+	return context.CtrlClt.GetMfaRecoveryCodes(code)
+}
+
+func (context *ContextImpl) NewZitiMfaRecoveryCodes(code string) error {
+	return context.CtrlClt.NewMfaRecoveryCodes(code)
+}
+
 type waitForNHelper struct {
 	count  uint
 	mgr    *listenerManager
@@ -1726,12 +2364,14 @@ func newListenerManager(service *rest_model.ServiceDetail, context *ContextImpl,
 		options:           options,
 		routerConnections: map[string]edge.RouterConn{},
 		connects:          map[string]time.Time{},
+		failedBinds:       map[string]time.Time{},
 		connectChan:       make(chan *edgeRouterConnResult, 3),
 		eventChan:         make(chan listenerEvent),
 		disconnectedTime:  &now,
 	}
 
 	listenerMgr.listener = network.NewMultiListener(service, listenerMgr.GetCurrentSession)
+	listenerMgr.listener.SetLogger(context.logger)
 
 	var helper *waitForNHelper
 	if waitForN > 0 {
@@ -1744,7 +2384,8 @@ func newListenerManager(service *rest_model.ServiceDetail, context *ContextImpl,
 		defer listenerMgr.RemoveObserver(helper)
 	}
 
-	go listenerMgr.run()
+	context.listenerManagers.Set(options.ListenerId, listenerMgr)
+	context.spawn(listenerMgr.run)
 
 	if helper != nil {
 		if err := helper.WaitForN(options.ConnectTimeout); err != nil {
@@ -1767,6 +2408,12 @@ type listenerManager struct {
 	options                *edge.ListenOptions
 	routerConnections      map[string]edge.RouterConn
 	connects               map[string]time.Time
+	failedBinds            map[string]time.Time // router name -> time of the last failed bind
+	bindFailures           int
+	lostRouter             string // router of the first lost terminator not yet re-established
+	lostAt                 time.Time
+	rebindAttempts         int
+	nextRebind             time.Time
 	listener               network.MultiListener
 	connectChan            chan *edgeRouterConnResult
 	eventChan              chan listenerEvent
@@ -1793,7 +2440,9 @@ func (mgr *listenerManager) notify(eventType ListenEventType) {
 }
 
 func (mgr *listenerManager) run() {
-	log := pfxlog.Logger().WithField("service", stringz.OrEmpty(mgr.service.Name))
+	defer mgr.context.listenerManagers.Remove(mgr.options.ListenerId)
+
+	log := mgr.context.log().WithField("service", stringz.OrEmpty(mgr.service.Name))
 	// need to either establish a session, or fail if we can't create one
 	for mgr.session == nil {
 		mgr.createSessionWithBackoff()
@@ -1884,7 +2533,7 @@ func (mgr *listenerManager) sessionRefreshed(session *rest_model.SessionDetail)
 	mgr.restartSessionRefresh = true
 	mgr.lastSessionRefresh = time.Now()
 
-	log := pfxlog.Logger().
+	log := mgr.context.log().
 		WithField("service", stringz.OrEmpty(mgr.service.Name)).
 		WithField("sessionId", stringz.OrEmpty(mgr.session.ID)).
 		WithField("usableEndpoints", newUsableCount).
@@ -1899,17 +2548,14 @@ func (mgr *listenerManager) getUsableEndpointCount(session *rest_model.SessionDe
 
 	count := 0
 	for _, edgeRouter := range session.EdgeRouters {
-		for _, routerUrl := range edgeRouter.SupportedProtocols {
-			if mgr.context.options.isEdgeRouterUrlAccepted(routerUrl) {
-				count++
-			}
-		}
+		urls, _ := mgr.context.options.selectEdgeRouterUrls(edgeRouter.SupportedProtocols)
+		count += len(urls)
 	}
 	return count
 }
 
 func (mgr *listenerManager) handleRouterConnectResult(result *edgeRouterConnResult) {
-	log := pfxlog.Logger().
+	log := mgr.context.log().
 		WithField("serviceName", *mgr.service.Name).
 		WithField("listenerCount", len(mgr.routerConnections)).
 		WithField("router", result.routerName).
@@ -1937,7 +2583,7 @@ func (mgr *listenerManager) handleRouterConnectResult(result *edgeRouterConnResu
 
 func (mgr *listenerManager) createListener(routerConnection edge.RouterConn, session *rest_model.SessionDetail) {
 	start := time.Now()
-	logger := pfxlog.Logger().WithField("serviceName", *mgr.service.Name).
+	logger := mgr.context.log().WithField("serviceName", *mgr.service.Name).
 		WithField("router", routerConnection.GetRouterName())
 	svc := mgr.listener.GetService()
 	listener, err := routerConnection.Listen(svc, session, mgr.options)
@@ -1952,7 +2598,7 @@ func (mgr *listenerManager) createListener(routerConnection edge.RouterConn, ses
 				logger.Debugf("listener closed, exiting from createListener")
 			}
 		})
-		mgr.eventChan <- listenSuccessEvent{}
+		mgr.eventChan <- listenSuccessEvent{router: routerConnection.GetRouterName()}
 		if !routerConnection.GetBoolHeader(edge.SupportsBindSuccessHeader) {
 			select {
 			case mgr.options.GetEventChannel() <- &edge.ListenerEvent{EventType: edge.ListenerEstablished}:
@@ -1963,7 +2609,7 @@ func (mgr *listenerManager) createListener(routerConnection edge.RouterConn, ses
 		logger.Errorf("creating listener failed after %vms: %v", elapsed.Milliseconds(), err)
 		mgr.listener.NotifyOfChildError(err)
 		select {
-		case mgr.eventChan <- &routerConnectionListenFailedEvent{router: routerConnection.GetRouterName()}:
+		case mgr.eventChan <- &routerConnectionListenFailedEvent{router: routerConnection.GetRouterName(), err: err}:
 		case <-mgr.context.closeNotify:
 			logger.Debugf("listener closed, exiting from createListener")
 		}
@@ -1971,12 +2617,23 @@ func (mgr *listenerManager) createListener(routerConnection edge.RouterConn, ses
 }
 
 func (mgr *listenerManager) makeMoreListeners() {
-	log := pfxlog.Logger().WithField("service", *mgr.service.Name).WithField("erCount", len(mgr.session.EdgeRouters))
+	log := mgr.context.log().WithField("service", *mgr.service.Name).WithField("erCount", len(mgr.session.EdgeRouters))
 	if mgr.listener.IsClosed() || len(mgr.routerConnections) >= mgr.options.MaxTerminators || len(mgr.session.EdgeRouters) <= len(mgr.routerConnections) {
 		log.Trace("not trying to make more connections")
 		return
 	}
 
+	if err := mgr.context.checkRestrictedEgressRouters(mgr.session); err != nil {
+		log.WithError(err).Error("no usable edge routers, closing listener")
+		mgr.listener.CloseWithError(err)
+		return
+	}
+
+	if time.Now().Before(mgr.nextRebind) {
+		log.Trace("waiting to re-bind lost terminator")
+		return
+	}
+
 	for _, edgeRouter := range mgr.session.EdgeRouters {
 		if _, ok := mgr.routerConnections[*edgeRouter.Name]; ok {
 			log.WithField("router", *edgeRouter.Name).Trace("already connected")
@@ -1984,13 +2641,15 @@ func (mgr *listenerManager) makeMoreListeners() {
 			continue
 		}
 
-		for _, routerUrl := range edgeRouter.SupportedProtocols {
-			if !mgr.context.options.isEdgeRouterUrlAccepted(routerUrl) {
-				log.WithField("router", *edgeRouter.Name).WithField("url", routerUrl).
-					Trace("skipping unusable url")
+		if failedAt, ok := mgr.failedBinds[*edgeRouter.Name]; ok {
+			if time.Since(failedAt) < mgr.options.BindRetryInterval {
+				log.WithField("router", *edgeRouter.Name).Trace("waiting to retry failed bind")
 				continue
 			}
+			delete(mgr.failedBinds, *edgeRouter.Name)
+		}
 
+		for _, routerUrl := range mgr.context.getEdgeRouterUrls(*edgeRouter.Name, edgeRouter.SupportedProtocols) {
 			if connectTime, ok := mgr.connects[routerUrl]; ok && time.Since(connectTime) < 30*time.Second {
 				// this url already has a connect in progress
 				log.WithField("router", *edgeRouter.Name).WithField("url", routerUrl).
@@ -2011,7 +2670,7 @@ func (mgr *listenerManager) refreshSession() {
 		return
 	}
 
-	log := pfxlog.Logger().WithField("service", stringz.OrEmpty(mgr.service.Name))
+	log := mgr.context.log().WithField("service", stringz.OrEmpty(mgr.service.Name))
 	if mgr.session == nil {
 		log.Debug("establishing initial session")
 		mgr.createSessionWithBackoff()
@@ -2072,7 +2731,7 @@ func (mgr *listenerManager) refreshSession() {
 func (mgr *listenerManager) createSessionWithBackoff() {
 	latestSvc, _ := mgr.context.services.Get(*mgr.service.Name)
 	if latestSvc != nil && *latestSvc.ID != *mgr.service.ID {
-		pfxlog.Logger().
+		mgr.context.log().
 			WithField("serviceName", *mgr.service.Name).
 			WithField("oldServiceId", *mgr.service.ID).
 			WithField("newServiceId", *latestSvc.ID).
@@ -2080,12 +2739,12 @@ func (mgr *listenerManager) createSessionWithBackoff() {
 		mgr.service = latestSvc
 	}
 
-	session, err := mgr.context.createSessionWithBackoff(mgr.service, SessionType(SessionBind), mgr.options)
+	session, err := mgr.context.createSessionWithBackoff(gocontext.Background(), mgr.service, SessionType(SessionBind), mgr.options)
 	if session != nil {
 		mgr.sessionRefreshed(session)
-		pfxlog.Logger().WithField("session token", *session.Token).Info("new service session")
+		mgr.context.log().WithField("session token", *session.Token).Info("new service session")
 	} else {
-		pfxlog.Logger().WithError(err).Errorf("failed to create bind session for service %v", mgr.service.Name)
+		mgr.context.log().WithError(err).Errorf("failed to create bind session for service %v", mgr.service.Name)
 	}
 }
 
@@ -2118,11 +2777,31 @@ type listenerEvent interface {
 
 type routerConnectionListenFailedEvent struct {
 	router string
+	err    error // set if the bind failed, rather than an established listener closing
 }
 
 func (event *routerConnectionListenFailedEvent) handle(mgr *listenerManager) {
 	delete(mgr.routerConnections, event.router)
-	pfxlog.Logger().WithField("serviceName", *mgr.service.Name).
+
+	if event.err != nil {
+		mgr.bindFailures++
+		if mgr.options.BindRetryInterval > 0 {
+			mgr.failedBinds[event.router] = time.Now()
+		}
+		if mgr.options.MaxBindAttempts > 0 && mgr.bindFailures >= mgr.options.MaxBindAttempts && len(mgr.routerConnections) == 0 {
+			mgr.context.log().WithField("serviceName", *mgr.service.Name).WithError(event.err).
+				Errorf("giving up on listener after %d failed bind attempts", mgr.bindFailures)
+			mgr.listener.CloseWithError(errors.Wrapf(event.err, "bind failed %d times", mgr.bindFailures))
+			return
+		}
+		if mgr.lostRouter != "" {
+			mgr.scheduleRebind()
+		}
+	} else if !mgr.listener.IsClosed() {
+		mgr.terminatorLost(event.router)
+	}
+
+	mgr.context.log().WithField("serviceName", *mgr.service.Name).
 		WithField("listenerCount", len(mgr.routerConnections)).
 		WithField("router", event.router).
 		Debugf("child listener connection closed. parent listener closed: %v", mgr.listener.IsClosed())
@@ -2146,10 +2825,14 @@ type edgeRouterConnResult struct {
 	err              error
 }
 
-type listenSuccessEvent struct{}
+type listenSuccessEvent struct {
+	router string
+}
 
 func (event listenSuccessEvent) handle(mgr *listenerManager) {
 	mgr.disconnectedTime = nil
+	mgr.bindFailures = 0
+	mgr.terminatorEstablished(event.router)
 	mgr.notify(ListenerAdded)
 }
 
@@ -2169,6 +2852,7 @@ const (
 	ListenerAdded       ListenEventType = 1
 	ListenerEstablished ListenEventType = 2
 	ListenerRemoved     ListenEventType = 3
+	ListenerRebound     ListenEventType = 4
 )
 
 type ListenEventObserver interface {
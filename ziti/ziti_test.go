@@ -1,14 +1,26 @@
 package ziti
 
 import (
+	gocontext "context"
 	"fmt"
 	"github.com/kataras/go-events"
 	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/edge-api/rest_util"
+	"github.com/openziti/metrics"
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
 	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/sdk-golang/ziti/edge/network"
 	"github.com/openziti/sdk-golang/ziti/edge/posture"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 )
 
 func ToPtr[T any](s T) *T {
@@ -227,3 +239,772 @@ func Test_AddressMatch(t *testing.T) {
 
 	}
 }
+
+func Test_serviceConfigCache_decode(t *testing.T) {
+	req := require.New(t)
+
+	svc := newTestService("svc")
+	svc.Config = map[string]map[string]interface{}{
+		InterceptV1: {
+			"protocols": []string{"tcp"},
+			"addresses": []string{"example.ziti"},
+		},
+	}
+
+	cache := newServiceConfigCache()
+
+	intercept := &edge.InterceptV1Config{}
+	found, err := cache.decode(svc, InterceptV1, intercept)
+	req.NoError(err)
+	req.True(found)
+	req.Equal([]string{"tcp"}, intercept.Protocols)
+
+	// memoized results are returned without consulting the config again
+	svc.Config[InterceptV1]["protocols"] = []string{"udp"}
+	second := &edge.InterceptV1Config{}
+	found, err = cache.decode(svc, InterceptV1, second)
+	req.NoError(err)
+	req.True(found)
+	req.Equal([]string{"tcp"}, second.Protocols)
+
+	// a new service revision is decoded again
+	updated := *svc
+	third := &edge.InterceptV1Config{}
+	found, err = cache.decode(&updated, InterceptV1, third)
+	req.NoError(err)
+	req.True(found)
+	req.Equal([]string{"udp"}, third.Protocols)
+
+	found, err = cache.decode(svc, ClientConfigV1, &edge.ClientConfig{})
+	req.NoError(err)
+	req.False(found)
+
+	_, err = cache.decode(svc, InterceptV1, edge.InterceptV1Config{})
+	req.Error(err)
+}
+
+type headerRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (self *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Test-Middleware", "applied")
+	return self.next.RoundTrip(req)
+}
+
+func Test_Options_APIClientCustomizer(t *testing.T) {
+	req := require.New(t)
+
+	headerC := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case headerC <- r.Header.Get("X-Test-Middleware"):
+		default:
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	var customizedClient *http.Client
+	options := &Options{
+		APIClientCustomizer: func(client *http.Client, transport *http.Transport) {
+			customizedClient = client
+			transport.TLSClientConfig.InsecureSkipVerify = true
+			client.Transport = &headerRoundTripper{next: transport}
+		},
+	}
+
+	cfg := &Config{
+		ZtAPI:       server.URL + "/edge/client/v1",
+		Credentials: edge_apis.NewUpdbCredentials("user", "password"),
+	}
+
+	ztx, err := NewContextWithOpts(cfg, options)
+	req.NoError(err)
+
+	ctxImpl := ztx.(*ContextImpl)
+	req.Same(ctxImpl.CtrlClt.HttpClient, customizedClient)
+
+	_, err = ctxImpl.CtrlClt.GetCurrentIdentity()
+	req.Error(err)
+	req.Equal("applied", <-headerC)
+}
+
+func Test_CtrlClient_QueryServices(t *testing.T) {
+	req := require.New(t)
+
+	queryC := make(chan url.Values, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queryC <- r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"id":"svc1","name":"printer-1"}],"meta":{"pagination":{"limit":10,"offset":20,"totalCount":21}}}`))
+	}))
+	defer server.Close()
+
+	options := &Options{
+		APIClientCustomizer: func(client *http.Client, transport *http.Transport) {
+			transport.TLSClientConfig.InsecureSkipVerify = true
+		},
+	}
+
+	cfg := &Config{
+		ZtAPI:       server.URL + "/edge/client/v1",
+		Credentials: edge_apis.NewUpdbCredentials("user", "password"),
+	}
+
+	ztx, err := NewContextWithOpts(cfg, options)
+	req.NoError(err)
+
+	services, total, err := ztx.(*ContextImpl).CtrlClt.QueryServices(`name contains "printer"`, 10, 20)
+	req.NoError(err)
+	req.Equal(21, total)
+	req.Len(services, 1)
+	req.Equal("printer-1", *services[0].Name)
+
+	query := <-queryC
+	req.Equal(`name contains "printer"`, query.Get("filter"))
+	req.Equal("10", query.Get("limit"))
+	req.Equal("20", query.Get("offset"))
+}
+
+func Test_CtrlClient_MfaRecoveryCodes(t *testing.T) {
+	req := require.New(t)
+
+	type request struct {
+		method string
+		code   string
+		body   string
+	}
+
+	requestC := make(chan request, 2)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requestC <- request{method: r.Method, code: r.Header.Get("mfa-validation-code"), body: string(body)}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"data":{"id":"mfa1","recoveryCodes":["AAAA","BBBB"]},"meta":{}}`))
+		} else {
+			_, _ = w.Write([]byte(`{"data":{},"meta":{}}`))
+		}
+	}))
+	defer server.Close()
+
+	options := &Options{
+		APIClientCustomizer: func(client *http.Client, transport *http.Transport) {
+			transport.TLSClientConfig.InsecureSkipVerify = true
+		},
+	}
+
+	cfg := &Config{
+		ZtAPI:       server.URL + "/edge/client/v1",
+		Credentials: edge_apis.NewUpdbCredentials("user", "password"),
+	}
+
+	ztx, err := NewContextWithOpts(cfg, options)
+	req.NoError(err)
+
+	codes, err := ztx.GetZitiMfaRecoveryCodes("123456")
+	req.NoError(err)
+	req.Equal([]string{"AAAA", "BBBB"}, codes)
+
+	r := <-requestC
+	req.Equal(http.MethodGet, r.method)
+	req.Equal("123456", r.code)
+
+	req.NoError(ztx.NewZitiMfaRecoveryCodes("654321"))
+
+	r = <-requestC
+	req.Equal(http.MethodPost, r.method)
+	req.JSONEq(`{"code":"654321"}`, r.body)
+
+	_, err = ReadOnly(ztx).GetZitiMfaRecoveryCodes("123456")
+	req.ErrorIs(err, ErrReadOnly)
+}
+
+func Test_selectAffinityIdentity(t *testing.T) {
+	req := require.New(t)
+
+	req.Equal("", selectAffinityIdentity("client-1", nil))
+
+	identities := []string{"host-a", "host-b", "host-c", "host-d"}
+
+	selected := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 400; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		selected[key] = selectAffinityIdentity(key, identities)
+		req.Equal(selected[key], selectAffinityIdentity(key, identities))
+		counts[selected[key]]++
+	}
+	req.Len(counts, len(identities))
+
+	// removing a terminator only moves the keys that were mapped to it
+	remaining := []string{"host-a", "host-c", "host-d"}
+	for key, identity := range selected {
+		if identity != "host-b" {
+			req.Equal(identity, selectAffinityIdentity(key, remaining))
+		}
+	}
+}
+
+func Test_NewDialOptions(t *testing.T) {
+	req := require.New(t)
+
+	options := NewDialOptions()
+	req.Equal(5*time.Second, options.ConnectTimeout)
+	req.Empty(options.Identity)
+
+	options = NewDialOptions(WithTerminatorIdentity("printer-42"))
+	req.Equal(5*time.Second, options.ConnectTimeout)
+	req.Equal("printer-42", options.Identity)
+}
+
+func Test_contextImpl_DialWithOptionsContext_expired(t *testing.T) {
+	req := require.New(t)
+
+	ctx, cancel := gocontext.WithDeadline(gocontext.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, err := (&ContextImpl{}).DialWithOptionsContext(ctx, "svc", &DialOptions{})
+	req.ErrorIs(err, gocontext.DeadlineExceeded)
+}
+
+func Test_contextImpl_GetKeepaliveConfig_unauthenticated(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{options: &Options{Keepalive: &KeepaliveOptions{}}}
+	config := ctx.GetKeepaliveConfig()
+	req.NotNil(config)
+	req.NotPanics(func() {
+		config.OnResponse(nil, time.Millisecond)
+		config.OnFailure(nil, time.Second)
+		ctx.registerAcceptQueueGauge()
+	})
+}
+
+func Test_contextImpl_ResourceUsage(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		services:          cmap.New[*rest_model.ServiceDetail](),
+		sessions:          cmap.New[*rest_model.SessionDetail](),
+	}
+	ctx.services.Set("svc", &rest_model.ServiceDetail{Name: ToPtr("svc")})
+
+	release := make(chan struct{})
+	ctx.spawn(func() { <-release })
+
+	usage := ctx.ResourceUsage()
+	req.Equal(1, usage.Goroutines)
+	req.Equal(1, usage.Services)
+	req.Equal(0, usage.Sessions)
+	req.NotZero(usage.ControllerStateBytes)
+	req.Equal(usage.ControllerStateBytes+estimatedGoroutineBytes, usage.EstimatedMemoryBytes)
+
+	close(release)
+	req.Eventually(func() bool {
+		return ctx.ResourceUsage().Goroutines == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+type testRouterConn struct {
+	edge.RouterConn
+	name string
+	key  string
+}
+
+func (self *testRouterConn) GetRouterName() string {
+	return self.name
+}
+
+func (self *testRouterConn) Key() string {
+	return self.key
+}
+
+func (self *testRouterConn) IsClosed() bool {
+	return false
+}
+
+func (self *testRouterConn) GetConnStats() []edge.ConnStats {
+	return nil
+}
+
+func Test_contextImpl_getEdgeRouterConn_preferred(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		routerLatencies:   cmap.New[*routerLatency](),
+		selectedRouters:   cmap.New[string](),
+		unhealthyRouters:  cmap.New[struct{}](),
+		metrics:           metrics.NewRegistry("test", nil),
+		options:           DefaultOptions,
+		EventEmmiter:      events.New(),
+	}
+
+	session := &rest_model.SessionDetail{
+		BaseEntity: rest_model.BaseEntity{ID: ToPtr("session")},
+	}
+	for i, latency := range []time.Duration{time.Millisecond, time.Second} {
+		name := fmt.Sprintf("router-%d", i)
+		addr := fmt.Sprintf("tls:%s:3022", name)
+		ctx.routerConnections.Set(addr, &testRouterConn{name: name, key: addr})
+		ctx.recordRouterLatency(name, addr, latency)
+		session.EdgeRouters = append(session.EdgeRouters, &rest_model.SessionEdgeRouter{
+			CommonEdgeRouterProperties: rest_model.CommonEdgeRouterProperties{
+				Name:               ToPtr(name),
+				SupportedProtocols: map[string]string{"tls": "tls://" + name + ":3022"},
+			},
+		})
+	}
+
+	options := &edge.DialOptions{ConnectTimeout: time.Second}
+
+	conn, err := ctx.getEdgeRouterConn(gocontext.Background(), session, options, edgeRouterPolicy{})
+	req.NoError(err)
+	req.Equal("router-0", conn.GetRouterName())
+
+	conn, err = ctx.getEdgeRouterConn(gocontext.Background(), session, options, edgeRouterPolicy{preferred: []string{"router-1"}})
+	req.NoError(err)
+	req.Equal("router-1", conn.GetRouterName())
+
+	conn, err = ctx.getEdgeRouterConn(gocontext.Background(), session, options, edgeRouterPolicy{preferred: []string{"unknown"}})
+	req.NoError(err)
+	req.Equal("router-0", conn.GetRouterName())
+
+	var offline, online []string
+	ctx.AddRouterOfflineListener(func(_ Context, name string, _ string) {
+		offline = append(offline, name)
+	})
+	ctx.AddRouterOnlineListener(func(_ Context, name string, _ string) {
+		online = append(online, name)
+	})
+
+	router0, _ := ctx.routerConnections.Get("tls:router-0:3022")
+	ctx.markRouterUnhealthy(router0)
+	ctx.markRouterUnhealthy(router0)
+	req.Equal([]string{"router-0"}, offline)
+
+	conn, err = ctx.getEdgeRouterConn(gocontext.Background(), session, options, edgeRouterPolicy{})
+	req.NoError(err)
+	req.Equal("router-1", conn.GetRouterName())
+
+	// unhealthy routers are still used if no other router is available
+	conn, err = ctx.getEdgeRouterConn(gocontext.Background(), session, options, edgeRouterPolicy{preferred: []string{"router-0"}})
+	req.NoError(err)
+	req.Equal("router-0", conn.GetRouterName())
+
+	ctx.markRouterHealthy(router0)
+	ctx.markRouterHealthy(router0)
+	req.Equal([]string{"router-0"}, online)
+
+	conn, err = ctx.getEdgeRouterConn(gocontext.Background(), session, options, edgeRouterPolicy{})
+	req.NoError(err)
+	req.Equal("router-0", conn.GetRouterName())
+}
+
+func Test_TerminatorStrategies(t *testing.T) {
+	req := require.New(t)
+
+	terminators := []*Terminator{
+		{Id: "t1", Identity: "host-a", RouterName: "router-0", RouterCost: 0, Latency: 30 * time.Millisecond},
+		{Id: "t2", Identity: "host-b", RouterName: "router-1", RouterCost: 1000, Latency: 10 * time.Millisecond},
+		{Id: "t3", Identity: "host-c", RouterName: "router-2", RouterCost: 1000},
+	}
+
+	req.Nil(SelectRandomTerminator.Select("svc", nil))
+	req.Nil(SelectCostWeightedTerminator.Select("svc", nil))
+	req.Nil(SelectLowestLatencyTerminator.Select("svc", nil))
+
+	req.Contains(terminators, SelectRandomTerminator.Select("svc", terminators))
+	req.Equal("t2", SelectLowestLatencyTerminator.Select("svc", terminators).Id)
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[SelectCostWeightedTerminator.Select("svc", terminators).Id]++
+	}
+	req.Greater(counts["t1"], 900)
+
+	sticky := StickyTerminatorStrategy("client-1")
+	selected := sticky.Select("svc", terminators)
+	req.Equal(selectAffinityIdentity("client-1", []string{"host-a", "host-b", "host-c"}), selected.Identity)
+	req.Equal(selected, sticky.Select("svc", terminators))
+}
+
+func Test_contextImpl_selectTerminatorIdentity(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		routerLatencies:   cmap.New[*routerLatency](),
+		metrics:           metrics.NewRegistry("test", nil),
+		terminators:       cmap.New[*serviceTerminators](),
+	}
+
+	ctx.terminators.Set("svc", &serviceTerminators{
+		terminators: []*Terminator{
+			{Id: "t1", Identity: "host-a", RouterName: "router-0"},
+			{Id: "t2", Identity: "host-b", RouterName: "router-1"},
+		},
+		fetchedAt: time.Now(),
+	})
+
+	for i, latency := range []time.Duration{time.Second, time.Millisecond} {
+		addr := fmt.Sprintf("tls:router-%d:3022", i)
+		ctx.routerConnections.Set(addr, &testRouterConn{name: fmt.Sprintf("router-%d", i), key: addr})
+		ctx.recordRouterLatency(fmt.Sprintf("router-%d", i), addr, latency)
+	}
+
+	req.Equal("host-b", ctx.selectTerminatorIdentity("svc", SelectLowestLatencyTerminator))
+
+	none := TerminatorStrategyFunc(func(string, []*Terminator) *Terminator { return nil })
+	req.Equal("", ctx.selectTerminatorIdentity("svc", none))
+
+	ctx.terminators.Set("empty", &serviceTerminators{fetchedAt: time.Now()})
+	req.Equal("", ctx.selectTerminatorIdentity("empty", SelectRandomTerminator))
+}
+
+func Test_FailureDomainPolicy(t *testing.T) {
+	req := require.New(t)
+
+	newRouter := func(name string, labels map[string]interface{}) *rest_model.SessionEdgeRouter {
+		return &rest_model.SessionEdgeRouter{
+			CommonEdgeRouterProperties: rest_model.CommonEdgeRouterProperties{
+				Name:    ToPtr(name),
+				AppData: &rest_model.Tags{SubTags: labels},
+			},
+		}
+	}
+
+	routers := []*rest_model.SessionEdgeRouter{
+		newRouter("east-1", map[string]interface{}{RouterLabelRegion: "us-east", RouterLabelDatacenter: "dc1"}),
+		newRouter("east-2", map[string]interface{}{RouterLabelRegion: "us-east", RouterLabelDatacenter: "dc2"}),
+		newRouter("west-1", map[string]interface{}{RouterLabelRegion: "us-west", RouterLabelDatacenter: "dc3"}),
+		newRouter("unlabeled", nil),
+	}
+
+	names := func(routers []*rest_model.SessionEdgeRouter) []string {
+		var result []string
+		for _, router := range routers {
+			result = append(result, *router.Name)
+		}
+		return result
+	}
+
+	req.Len(edgeRouterPolicy{}.apply(routers), 4)
+
+	policy := edgeRouterPolicy{failureDomains: &FailureDomainPolicy{Prefer: map[string]string{RouterLabelRegion: "us-east"}}}
+	req.Equal([]string{"east-1", "east-2"}, names(policy.apply(routers)))
+
+	policy.failureDomains.Avoid = map[string]string{RouterLabelDatacenter: "dc1"}
+	req.Equal([]string{"east-2"}, names(policy.apply(routers)))
+
+	// preferring an unavailable failure domain falls back to all routers that aren't avoided
+	policy.failureDomains.Prefer = map[string]string{RouterLabelRegion: "eu-central"}
+	req.Equal([]string{"east-2", "west-1", "unlabeled"}, names(policy.apply(routers)))
+
+	// avoiding everything falls back to all routers
+	policy.failureDomains = &FailureDomainPolicy{Avoid: map[string]string{"unknown": ""}}
+	req.Len(policy.apply(routers), 4)
+
+	// named routers take precedence
+	policy.preferred = []string{"west-1"}
+	req.Equal([]string{"west-1"}, names(policy.apply(routers)))
+
+	terminators := []*Terminator{
+		{Id: "t1", Identity: "host-a", RouterLabels: map[string]string{RouterLabelRegion: "us-east"}},
+		{Id: "t2", Identity: "host-b", RouterLabels: map[string]string{RouterLabelRegion: "us-west"}},
+	}
+	strategy := FailureDomainTerminatorStrategy(&FailureDomainPolicy{Avoid: map[string]string{RouterLabelRegion: "us-east"}}, nil)
+	for i := 0; i < 10; i++ {
+		req.Equal("t2", strategy.Select("svc", terminators).Id)
+	}
+}
+
+func Test_DialRetryPolicy(t *testing.T) {
+	req := require.New(t)
+
+	policy := &DialRetryPolicy{
+		MaxAttempts: 4,
+		Interval:    100 * time.Millisecond,
+		Multiplier:  2,
+		MaxInterval: 300 * time.Millisecond,
+	}
+	req.Equal(100*time.Millisecond, policy.delay(1))
+	req.Equal(200*time.Millisecond, policy.delay(2))
+	req.Equal(300*time.Millisecond, policy.delay(3))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.delay(1)
+		req.GreaterOrEqual(delay, 50*time.Millisecond)
+		req.LessOrEqual(delay, 150*time.Millisecond)
+	}
+
+	transient := errors.New("no edge routers available")
+	req.True(policy.shouldRetry(1, transient))
+	req.False(policy.shouldRetry(4, transient))
+	req.False(policy.shouldRetry(1, errors.Wrap(&ServiceNotFoundError{ServiceName: "svc"}, "unable to dial")))
+	req.False(policy.shouldRetry(1, errors.Wrap(gocontext.Canceled, "unable to dial")))
+	req.False(policy.shouldRetry(1, &rest_util.APIFormattedError{APIError: &rest_model.APIError{Code: "UNAUTHORIZED"}}))
+
+	var nilPolicy *DialRetryPolicy
+	req.False(nilPolicy.shouldRetry(1, transient))
+
+	policy.Retryable = func(error) bool { return false }
+	req.False(policy.shouldRetry(1, transient))
+}
+
+func Test_contextImpl_selectVariantIdentity(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		routerConnections: cmap.New[edge.RouterConn](),
+		metrics:           metrics.NewRegistry("test", nil),
+		terminators:       cmap.New[*serviceTerminators](),
+	}
+
+	ctx.terminators.Set("svc", &serviceTerminators{
+		terminators: []*Terminator{
+			{Id: "t1", Identity: "api-1,version=v1"},
+			{Id: "t2", Identity: "api-2,version=v2"},
+			{Id: "t3", Identity: "api-3"},
+		},
+		fetchedAt: time.Now(),
+	})
+
+	svc := &rest_model.ServiceDetail{Name: ToPtr("svc")}
+
+	identity, err := ctx.selectVariantIdentity(svc, "version=v2", nil)
+	req.NoError(err)
+	req.Equal("api-2,version=v2", identity)
+
+	_, err = ctx.selectVariantIdentity(svc, "version=v3", nil)
+	req.Error(err)
+
+	svc.Tags = &rest_model.Tags{SubTags: map[string]interface{}{
+		ServiceVariantsTag: map[string]interface{}{
+			"canary": []interface{}{"api-3"},
+		},
+	}}
+
+	identity, err = ctx.selectVariantIdentity(svc, "canary", nil)
+	req.NoError(err)
+	req.Equal("api-3", identity)
+
+	identity, err = ctx.selectVariantIdentity(svc, "version=v1", nil)
+	req.NoError(err)
+	req.Equal("api-1,version=v1", identity)
+}
+
+type callerTestConn struct {
+	edge.Conn
+}
+
+func (self *callerTestConn) GetAppData() []byte {
+	return []byte(`{"tenant":"acme"}`)
+}
+
+func (self *callerTestConn) SourceIdentifier() string {
+	return "client-1"
+}
+
+func (self *callerTestConn) GetCircuitId() string {
+	return "circuit-1"
+}
+
+func Test_GetCallerInfo(t *testing.T) {
+	req := require.New(t)
+
+	info, ok := GetCallerInfo(&callerTestConn{})
+	req.True(ok)
+	req.Equal("client-1", info.Identity)
+	req.Equal("circuit-1", info.CircuitId)
+
+	var appData struct {
+		Tenant string `json:"tenant"`
+	}
+	found, err := info.DecodeAppData(&appData)
+	req.NoError(err)
+	req.True(found)
+	req.Equal("acme", appData.Tenant)
+
+	found, err = (&CallerInfo{}).DecodeAppData(&appData)
+	req.NoError(err)
+	req.False(found)
+
+	_, ok = GetCallerInfo(nil)
+	req.False(ok)
+
+	options := NewDialOptions(WithAppData([]byte("route-a")))
+	req.Equal([]byte("route-a"), options.AppData)
+}
+
+func (self *callerTestConn) GetServiceId() string {
+	return "svc-id"
+}
+
+func (self *callerTestConn) GetServiceName() string {
+	return "svc"
+}
+
+func Test_GetConnInfo(t *testing.T) {
+	req := require.New(t)
+
+	info, ok := GetConnInfo(edge.NewDatagramConn(&callerTestConn{}, 0))
+	req.True(ok, "wrapped connections should expose conn info")
+	req.Equal("client-1", info.Identity)
+	req.Equal("circuit-1", info.CircuitId)
+	req.Equal([]byte(`{"tenant":"acme"}`), info.AppData)
+	req.Equal("svc-id", info.ServiceId)
+	req.Equal("svc", info.ServiceName)
+
+	_, ok = GetConnInfo(nil)
+	req.False(ok)
+}
+
+func Test_contextImpl_WarmServices(t *testing.T) {
+	req := require.New(t)
+
+	ctx := &ContextImpl{
+		warmServices: cmap.New[struct{}](),
+		closeNotify:  make(chan struct{}),
+	}
+
+	req.NoError(ctx.WarmServices(nil))
+	req.Empty(ctx.warmServices.Keys())
+
+	ctx.closed.Store(true)
+	req.Error(ctx.WarmServices([]string{"svc"}))
+	req.Empty(ctx.warmServices.Keys())
+}
+
+func Test_NewListenOptions(t *testing.T) {
+	req := require.New(t)
+
+	options := NewListenOptions()
+	req.Equal(DefaultListenOptions(), options)
+
+	options = NewListenOptions(
+		WithCost(100),
+		WithPrecedence(PrecedenceRequired),
+		WithInstanceIdentity("host-a"),
+		WithMaxTerminators(5),
+		WithBindRetries(3, time.Second),
+		WithWaitForListeners(2, time.Minute),
+	)
+	req.Equal(uint16(100), options.Cost)
+	req.Equal(PrecedenceRequired, options.Precedence)
+	req.Equal("host-a", options.Identity)
+	req.False(options.BindUsingEdgeIdentity)
+	req.Equal(5, options.MaxTerminators)
+	req.Equal(3, options.MaxBindAttempts)
+	req.Equal(time.Second, options.BindRetryInterval)
+	req.Equal(uint(2), options.WaitForNEstablishedListeners)
+	req.Equal(time.Minute, options.ConnectTimeout)
+
+	req.True(NewListenOptions(WithInstanceIdentity("")).BindUsingEdgeIdentity)
+}
+
+func Test_listenerManager_bindRetries(t *testing.T) {
+	req := require.New(t)
+
+	svc := &rest_model.ServiceDetail{BaseEntity: rest_model.BaseEntity{ID: ToPtr("svc-id")}, Name: ToPtr("svc")}
+	mgr := &listenerManager{
+		service: svc,
+		context: &ContextImpl{options: DefaultOptions},
+		options: &edge.ListenOptions{
+			MaxTerminators:    1,
+			MaxBindAttempts:   2,
+			BindRetryInterval: time.Minute,
+		},
+		session: &rest_model.SessionDetail{
+			EdgeRouters: []*rest_model.SessionEdgeRouter{{
+				CommonEdgeRouterProperties: rest_model.CommonEdgeRouterProperties{
+					Name:               ToPtr("router-0"),
+					SupportedProtocols: map[string]string{"tls": "tls:router-0:3022"},
+				},
+			}},
+		},
+		routerConnections:  map[string]edge.RouterConn{},
+		connects:           map[string]time.Time{},
+		failedBinds:        map[string]time.Time{},
+		listener:           network.NewMultiListener(svc, nil),
+		lastSessionRefresh: time.Now(),
+	}
+
+	bindErr := errors.New("bind refused")
+
+	mgr.routerConnections["router-0"] = &testRouterConn{name: "router-0"}
+	(&routerConnectionListenFailedEvent{router: "router-0", err: bindErr}).handle(mgr)
+	req.False(mgr.listener.IsClosed())
+	req.Contains(mgr.failedBinds, "router-0")
+	req.Empty(mgr.connects, "failed router should not be reconnected before the retry interval")
+
+	listenSuccessEvent{}.handle(mgr)
+	req.Equal(0, mgr.bindFailures)
+
+	(&routerConnectionListenFailedEvent{router: "router-0", err: bindErr}).handle(mgr)
+	req.False(mgr.listener.IsClosed())
+	(&routerConnectionListenFailedEvent{router: "router-0", err: bindErr}).handle(mgr)
+	req.True(mgr.listener.IsClosed())
+}
+
+func Test_listenerManager_rebind(t *testing.T) {
+	req := require.New(t)
+
+	svc := &rest_model.ServiceDetail{BaseEntity: rest_model.BaseEntity{ID: ToPtr("svc-id")}, Name: ToPtr("svc")}
+	ztx := &ContextImpl{options: DefaultOptions, EventEmmiter: events.New()}
+	mgr := &listenerManager{
+		service: svc,
+		context: ztx,
+		options: &edge.ListenOptions{
+			MaxTerminators:    1,
+			RebindInterval:    time.Minute,
+			MaxRebindInterval: 3 * time.Minute,
+		},
+		session: &rest_model.SessionDetail{
+			EdgeRouters: []*rest_model.SessionEdgeRouter{{
+				CommonEdgeRouterProperties: rest_model.CommonEdgeRouterProperties{
+					Name:               ToPtr("router-1"),
+					SupportedProtocols: map[string]string{"tls": "tls:router-1:3022"},
+				},
+			}},
+		},
+		routerConnections:  map[string]edge.RouterConn{},
+		connects:           map[string]time.Time{},
+		failedBinds:        map[string]time.Time{},
+		listener:           network.NewMultiListener(svc, nil),
+		lastSessionRefresh: time.Now(),
+	}
+
+	rebinds := make(chan string, 1)
+	ztx.AddListenerRebindListener(func(_ Context, serviceName string, lostRouter string, newRouter string, downtime time.Duration) {
+		rebinds <- serviceName + ":" + lostRouter + "->" + newRouter
+	})
+
+	mgr.routerConnections["router-0"] = &testRouterConn{name: "router-0"}
+	(&routerConnectionListenFailedEvent{router: "router-0"}).handle(mgr)
+	req.Equal("router-0", mgr.lostRouter)
+	req.Empty(mgr.connects, "re-bind should wait for the rebind interval")
+	req.WithinDuration(time.Now().Add(time.Minute), mgr.nextRebind, 5*time.Second)
+
+	(&routerConnectionListenFailedEvent{router: "router-1", err: errors.New("bind refused")}).handle(mgr)
+	req.WithinDuration(time.Now().Add(2*time.Minute), mgr.nextRebind, 5*time.Second)
+
+	(&routerConnectionListenFailedEvent{router: "router-1", err: errors.New("bind refused")}).handle(mgr)
+	req.WithinDuration(time.Now().Add(3*time.Minute), mgr.nextRebind, 5*time.Second, "delay should be capped")
+
+	listenSuccessEvent{router: "router-1"}.handle(mgr)
+	req.Equal("", mgr.lostRouter)
+	req.Equal(0, mgr.rebindAttempts)
+	req.True(mgr.nextRebind.IsZero())
+
+	select {
+	case rebind := <-rebinds:
+		req.Equal("svc:router-0->router-1", rebind)
+	case <-time.After(time.Second):
+		req.Fail("no rebind event emitted")
+	}
+}
@@ -0,0 +1,364 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// Addr is the address of the sides of an in-memory connection.
+type Addr struct {
+	ServiceName string
+	Identity    string
+}
+
+func (self *Addr) Network() string {
+	return "ziti"
+}
+
+func (self *Addr) String() string {
+	return fmt.Sprintf("%s/%s", self.ServiceName, self.Identity)
+}
+
+// conn is one side of an in-memory connection. Each direction is carried by its own net.Pipe, so that either
+// direction can be closed on its own. As with net.Pipe, writes block until the other side reads the data.
+type conn struct {
+	id             uint32
+	reader         net.Conn
+	writer         net.Conn
+	serviceId      string
+	serviceName    string
+	sourceIdentity string
+	circuitId      string
+	appData        []byte
+	localAddr      *Addr
+	remoteAddr     *Addr
+	listener       *listener
+	startTime      time.Time
+
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	msgsIn   atomic.Uint64
+	msgsOut  atomic.Uint64
+
+	closed    atomic.Bool
+	closeOnce sync.Once
+	onClose   func()
+}
+
+var _ edge.Conn = (*conn)(nil)
+
+// newConnPair returns the dialing and accepting sides of a connection to a service.
+func newConnPair(id uint32, service *serviceEntry, dialIdentity, hostIdentity string, appData []byte) (*conn, *conn) {
+	toHost, fromDialer := net.Pipe()
+	toDialer, fromHost := net.Pipe()
+
+	dialerAddr := &Addr{ServiceName: service.name, Identity: dialIdentity}
+	hostAddr := &Addr{ServiceName: service.name, Identity: hostIdentity}
+	circuitId := fmt.Sprintf("zititest-circuit-%d", id)
+	now := time.Now()
+
+	dialer := &conn{
+		id:          id,
+		reader:      fromHost,
+		writer:      toHost,
+		serviceId:   service.id,
+		serviceName: service.name,
+		circuitId:   circuitId,
+		localAddr:   dialerAddr,
+		remoteAddr:  hostAddr,
+		startTime:   now,
+	}
+
+	host := &conn{
+		id:             id,
+		reader:         fromDialer,
+		writer:         toDialer,
+		serviceId:      service.id,
+		serviceName:    service.name,
+		sourceIdentity: dialIdentity,
+		circuitId:      circuitId,
+		appData:        appData,
+		localAddr:      hostAddr,
+		remoteAddr:     dialerAddr,
+		startTime:      now,
+	}
+	return dialer, host
+}
+
+func (self *conn) Read(p []byte) (int, error) {
+	n, err := self.reader.Read(p)
+	if n > 0 {
+		self.bytesIn.Add(uint64(n))
+		self.msgsIn.Add(1)
+	}
+	return n, err
+}
+
+func (self *conn) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	if n > 0 {
+		self.bytesOut.Add(uint64(n))
+		self.msgsOut.Add(1)
+	}
+	return n, err
+}
+
+func (self *conn) Close() error {
+	self.closeOnce.Do(func() {
+		self.closed.Store(true)
+		_ = self.reader.Close()
+		_ = self.writer.Close()
+		if self.onClose != nil {
+			self.onClose()
+		}
+	})
+	return nil
+}
+
+func (self *conn) CloseWrite() error {
+	return self.writer.Close()
+}
+
+func (self *conn) CloseRead() error {
+	return self.reader.Close()
+}
+
+func (self *conn) LocalAddr() net.Addr {
+	return self.localAddr
+}
+
+func (self *conn) RemoteAddr() net.Addr {
+	return self.remoteAddr
+}
+
+func (self *conn) SetDeadline(t time.Time) error {
+	if err := self.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return self.SetWriteDeadline(t)
+}
+
+func (self *conn) SetReadDeadline(t time.Time) error {
+	return self.reader.SetReadDeadline(t)
+}
+
+func (self *conn) SetWriteDeadline(t time.Time) error {
+	return self.writer.SetWriteDeadline(t)
+}
+
+func (self *conn) IsClosed() bool {
+	return self.closed.Load()
+}
+
+func (self *conn) GetAppData() []byte {
+	return self.appData
+}
+
+func (self *conn) SourceIdentifier() string {
+	return self.sourceIdentity
+}
+
+// TraceRoute reports the in-memory network as a single hop.
+func (self *conn) TraceRoute(hops uint32, _ time.Duration) (*edge.TraceRouteResult, error) {
+	if self.IsClosed() {
+		return nil, net.ErrClosed
+	}
+	return &edge.TraceRouteResult{Hops: hops, HopType: "zititest"}, nil
+}
+
+func (self *conn) GetCircuitId() string {
+	return self.circuitId
+}
+
+func (self *conn) GetStickinessToken() []byte {
+	return nil
+}
+
+func (self *conn) GetServiceId() string {
+	return self.serviceId
+}
+
+func (self *conn) GetServiceName() string {
+	return self.serviceName
+}
+
+func (self *conn) Stats() edge.ConnStats {
+	return edge.ConnStats{
+		ConnId:         self.id,
+		ServiceName:    self.serviceName,
+		CircuitId:      self.circuitId,
+		SourceIdentity: self.sourceIdentity,
+		StartTime:      self.startTime,
+		BytesIn:        self.bytesIn.Load(),
+		BytesOut:       self.bytesOut.Load(),
+		MsgsIn:         self.msgsIn.Load(),
+		MsgsOut:        self.msgsOut.Load(),
+		Closed:         self.IsClosed(),
+	}
+}
+
+func (self *conn) Id() uint32 {
+	return self.id
+}
+
+func (self *conn) CompleteAcceptSuccess() error {
+	return nil
+}
+
+func (self *conn) CompleteAcceptFailed(error) {
+	_ = self.Close()
+}
+
+// listener hosts a service on the in-memory network, accepting the connections dialed to it.
+type listener struct {
+	id       uint32
+	ctx      *Context
+	service  *serviceEntry
+	identity string
+	addr     *Addr
+	acceptC  chan *conn
+
+	lock       sync.Mutex
+	cost       uint16
+	precedence edge.Precedence
+	healthy    bool
+
+	closed      atomic.Bool
+	closeNotify chan struct{}
+	closeOnce   sync.Once
+}
+
+var _ edge.Listener = (*listener)(nil)
+
+func (self *listener) Accept() (net.Conn, error) {
+	return self.AcceptEdge()
+}
+
+func (self *listener) AcceptEdge() (edge.Conn, error) {
+	select {
+	case conn := <-self.acceptC:
+		return conn, nil
+	case <-self.closeNotify:
+		return nil, errors.Wrapf(net.ErrClosed, "listener for service '%s' is closed", self.service.name)
+	}
+}
+
+// deliver hands the accepting side of a dialed connection to the listener, waiting until it is accepted.
+func (self *listener) deliver(ctx context.Context, conn *conn) error {
+	select {
+	case self.acceptC <- conn:
+		return nil
+	case <-self.closeNotify:
+		return errors.Errorf("listener for service '%s' closed", self.service.name)
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "dial of service '%s' was not accepted", self.service.name)
+	}
+}
+
+// Close stops the listener and closes the connections it accepted.
+func (self *listener) Close() error {
+	self.stop()
+	for _, conn := range self.ctx.listenerConns(self) {
+		_ = conn.Close()
+	}
+	return nil
+}
+
+// stop stops the listener from accepting connections, leaving the accepted ones open.
+func (self *listener) stop() {
+	self.closeOnce.Do(func() {
+		self.closed.Store(true)
+		close(self.closeNotify)
+		self.ctx.removeListener(self)
+	})
+}
+
+func (self *listener) Addr() net.Addr {
+	return self.addr
+}
+
+func (self *listener) Id() uint32 {
+	return self.id
+}
+
+func (self *listener) IsClosed() bool {
+	return self.closed.Load()
+}
+
+func (self *listener) UpdateCost(cost uint16) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.cost = cost
+	return nil
+}
+
+func (self *listener) UpdatePrecedence(precedence edge.Precedence) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.precedence = precedence
+	return nil
+}
+
+func (self *listener) UpdateCostAndPrecedence(cost uint16, precedence edge.Precedence) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.cost = cost
+	self.precedence = precedence
+	return nil
+}
+
+// SendHealthEvent marks the listener healthy or not. Dials prefer healthy listeners.
+func (self *listener) SendHealthEvent(pass bool) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.healthy = pass
+	return nil
+}
+
+func (self *listener) isHealthy() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.healthy
+}
+
+// Drain stops the listener from accepting connections and waits for the accepted ones to close. If ctx is done
+// first, the remaining connections are closed and the context error returned.
+func (self *listener) Drain(ctx context.Context) error {
+	self.stop()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for len(self.ctx.listenerConns(self)) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			_ = self.Close()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,788 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/go-events"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/metrics"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// defaultDialTimeout is the time a dial waits to be accepted when no connect timeout is given, as in ziti.Context.
+const defaultDialTimeout = 5 * time.Second
+
+// Context is an in-memory ziti.Context on a Network. It is authenticated when created and stays ready until closed.
+// Operations which need a controller, such as MFA and authenticator management, fail with ErrNotSupported.
+//
+// Events are raised as by a connected Context, through both Events and EventBus. RecentEvents is always empty.
+type Context struct {
+	network  *Network
+	identity string
+
+	// impl only carries the event emitter, event bus and feature flags, it is never authenticated.
+	impl    *ziti.ContextImpl
+	eventer *eventer
+
+	lock        sync.Mutex
+	id          string
+	credentials apis.Credentials
+	metrics     metrics.Registry
+	listeners   map[*listener]struct{}
+	conns       map[*conn]struct{}
+
+	closed       atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+var _ ziti.Context = (*Context)(nil)
+
+func newContext(network *Network, identityName string) *Context {
+	result := &Context{
+		network:   network,
+		identity:  identityName,
+		impl:      &ziti.ContextImpl{EventEmmiter: events.New()},
+		id:        identityName,
+		listeners: map[*listener]struct{}{},
+		conns:     map[*conn]struct{}{},
+	}
+	result.eventer = &eventer{Eventer: result.impl, ctx: result}
+	return result
+}
+
+// Network returns the Network the Context is on.
+func (self *Context) Network() *Network {
+	return self.network
+}
+
+func (self *Context) emit(eventName events.EventName, args ...interface{}) {
+	self.impl.Emit(eventName, args...)
+}
+
+func (self *Context) emitServiceDiff(diff *ziti.ServiceDiff) {
+	if self.closed.Load() {
+		return
+	}
+	for _, detail := range diff.Removed {
+		self.emit(ziti.EventServiceRemoved, detail)
+	}
+	for _, detail := range diff.Changed {
+		self.emit(ziti.EventServiceChanged, detail)
+	}
+	for _, detail := range diff.Added {
+		self.emit(ziti.EventServiceAdded, detail)
+	}
+	self.emit(ziti.EventServicesUpdated, diff)
+}
+
+func (self *Context) checkOpen() error {
+	if self.closed.Load() {
+		return errors.New("context is closed")
+	}
+	if self.shuttingDown.Load() {
+		return ziti.ErrShuttingDown
+	}
+	return nil
+}
+
+func (self *Context) getService(serviceName string) (*serviceEntry, error) {
+	service, found := self.network.getService(serviceName)
+	if !found {
+		return nil, &ziti.ServiceNotFoundError{ServiceName: serviceName}
+	}
+	return service, nil
+}
+
+func (self *Context) track(c *conn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.conns[c] = struct{}{}
+	c.onClose = func() {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		delete(self.conns, c)
+	}
+}
+
+func (self *Context) getConns() []*conn {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	result := make([]*conn, 0, len(self.conns))
+	for c := range self.conns {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+	return result
+}
+
+func (self *Context) listenerConns(l *listener) []*conn {
+	var result []*conn
+	for _, c := range self.getConns() {
+		if c.listener == l {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (self *Context) getListeners() []*listener {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	result := make([]*listener, 0, len(self.listeners))
+	for l := range self.listeners {
+		result = append(result, l)
+	}
+	return result
+}
+
+func (self *Context) removeListener(l *listener) {
+	self.network.removeListener(l)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.listeners, l)
+}
+
+func (self *Context) Authenticate() error {
+	return self.checkOpen()
+}
+
+func (self *Context) SetCredentials(credentials apis.Credentials) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.credentials = credentials
+}
+
+func (self *Context) GetCredentials() apis.Credentials {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.credentials
+}
+
+func (self *Context) GetCurrentIdentity() (*rest_model.IdentityDetail, error) {
+	id := self.identity
+	name := self.identity
+	return &rest_model.IdentityDetail{
+		BaseEntity: rest_model.BaseEntity{ID: &id},
+		Name:       &name,
+	}, nil
+}
+
+func (self *Context) GetCurrentIdentityWithBackoff() (*rest_model.IdentityDetail, error) {
+	return self.GetCurrentIdentity()
+}
+
+func (self *Context) Dial(serviceName string) (edge.Conn, error) {
+	return self.DialWithContext(context.Background(), serviceName)
+}
+
+func (self *Context) DialWithOptions(serviceName string, options *ziti.DialOptions) (edge.Conn, error) {
+	return self.DialWithOptionsContext(context.Background(), serviceName, options)
+}
+
+func (self *Context) DialWithContext(ctx context.Context, serviceName string, opts ...ziti.DialOption) (edge.Conn, error) {
+	options := &ziti.DialOptions{ConnectTimeout: defaultDialTimeout}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return self.DialWithOptionsContext(ctx, serviceName, options)
+}
+
+// DialWithOptionsContext dials the service, delivering the hosting side of the connection to a listener of the
+// service, or its handler. The identity, connect timeout and app data of the options are honored, the others are
+// ignored.
+func (self *Context) DialWithOptionsContext(ctx context.Context, serviceName string, options *ziti.DialOptions) (edge.Conn, error) {
+	if err := self.checkOpen(); err != nil {
+		return nil, errors.Wrapf(err, "unable to dial service '%s'", serviceName)
+	}
+
+	service, err := self.getService(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if options == nil {
+		options = &ziti.DialOptions{}
+	}
+
+	timeout := options.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	target, handler, err := self.network.selectTarget(service, options.Identity)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to dial service '%s'", serviceName)
+	}
+
+	hostIdentity := ""
+	if target != nil {
+		hostIdentity = target.identity
+	}
+
+	dialer, host := newConnPair(self.network.nextId(), service, self.identity, hostIdentity, options.AppData)
+	self.track(dialer)
+
+	if target == nil {
+		go handler(host)
+		return dialer, nil
+	}
+
+	host.listener = target
+	target.ctx.track(host)
+	if err = target.deliver(ctx, host); err != nil {
+		_ = host.Close()
+		_ = dialer.Close()
+		return nil, errors.Wrapf(err, "unable to dial service '%s'", serviceName)
+	}
+	return dialer, nil
+}
+
+// DialAddr dials the service intercepting the address, as configured by its intercept.v1 or
+// ziti-tunneler-client.v1 config. The destination is passed to the hosting side in the app data, as tunnelers do.
+func (self *Context) DialAddr(network string, addr string) (edge.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	network = normalizeProtocol(network)
+	service, _, err := self.GetServiceForAddr(network, host, uint16(port))
+	if err != nil {
+		return nil, err
+	}
+
+	return self.DialWithOptions(*service.Name, &ziti.DialOptions{
+		ConnectTimeout: defaultDialTimeout,
+		AppData:        interceptAppData(network, host, uint16(port)),
+	})
+}
+
+func normalizeProtocol(proto string) string {
+	switch proto {
+	case "tcp", "tcp4", "tcp6":
+		return "tcp"
+	case "udp", "udp4", "udp6":
+		return "udp"
+	default:
+		return proto
+	}
+}
+
+func interceptAppData(network, host string, port uint16) []byte {
+	appData := map[string]any{
+		"dst_protocol": network,
+		"dst_port":     strconv.Itoa(int(port)),
+	}
+	if ip, ok := edge.ParseHostIP(host); ok {
+		appData["dst_ip"] = ip.String()
+	} else {
+		appData["dst_hostname"] = host
+	}
+
+	result, _ := json.Marshal(appData)
+	return result
+}
+
+func (self *Context) Listen(serviceName string) (edge.Listener, error) {
+	return self.ListenWithOptions(serviceName, ziti.DefaultListenOptions())
+}
+
+// ListenWithOptions hosts the service. The identity, cost and precedence of the options are honored, the others are
+// ignored.
+func (self *Context) ListenWithOptions(serviceName string, options *ziti.ListenOptions) (edge.Listener, error) {
+	if err := self.checkOpen(); err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on service '%s'", serviceName)
+	}
+
+	service, err := self.getService(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if options == nil {
+		options = ziti.DefaultListenOptions()
+	}
+
+	identity := options.Identity
+	if options.BindUsingEdgeIdentity {
+		identity = self.identity
+	}
+
+	addrIdentity := identity
+	if addrIdentity == "" {
+		addrIdentity = self.identity
+	}
+
+	result := &listener{
+		id:          self.network.nextId(),
+		ctx:         self,
+		service:     service,
+		identity:    identity,
+		addr:        &Addr{ServiceName: serviceName, Identity: addrIdentity},
+		acceptC:     make(chan *conn),
+		cost:        options.Cost,
+		precedence:  edge.Precedence(options.Precedence),
+		healthy:     true,
+		closeNotify: make(chan struct{}),
+	}
+
+	self.lock.Lock()
+	self.listeners[result] = struct{}{}
+	self.lock.Unlock()
+
+	if err = self.network.addListener(service, result); err != nil {
+		self.removeListener(result)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (self *Context) GetServiceId(serviceName string) (string, bool, error) {
+	service, found := self.network.getService(serviceName)
+	if !found {
+		return "", false, nil
+	}
+	return service.id, true, nil
+}
+
+func (self *Context) GetServices() ([]rest_model.ServiceDetail, error) {
+	details := self.network.getServiceDetails()
+	result := make([]rest_model.ServiceDetail, 0, len(details))
+	for _, detail := range details {
+		result = append(result, *detail)
+	}
+	return result, nil
+}
+
+func (self *Context) GetService(serviceName string) (*rest_model.ServiceDetail, bool) {
+	service, found := self.network.getService(serviceName)
+	if !found {
+		return nil, false
+	}
+	return service.detail, true
+}
+
+func (self *Context) GetServiceConfig(serviceName string, configType string, target interface{}) (bool, error) {
+	detail, found := self.GetService(serviceName)
+	if !found {
+		return false, &ziti.ServiceNotFoundError{ServiceName: serviceName}
+	}
+	return edge.ParseServiceConfig(detail, configType, target)
+}
+
+func (self *Context) getIntercept(detail *rest_model.ServiceDetail) *edge.InterceptV1Config {
+	intercept := &edge.InterceptV1Config{}
+	if ok, err := edge.ParseServiceConfig(detail, edge.InterceptV1, intercept); err == nil && ok {
+		intercept.Service = detail
+		return intercept
+	}
+
+	clientConfig := &edge.ClientConfig{}
+	if ok, err := edge.ParseServiceConfig(detail, ziti.ClientConfigV1, clientConfig); err == nil && ok {
+		intercept = clientConfig.ToInterceptV1Config()
+		intercept.Service = detail
+		return intercept
+	}
+	return nil
+}
+
+func (self *Context) GetServiceForAddr(network, hostname string, port uint16) (*rest_model.ServiceDetail, int, error) {
+	var result *rest_model.ServiceDetail
+	score := -1
+
+	for _, detail := range self.network.getServiceDetails() {
+		intercept := self.getIntercept(detail)
+		if intercept == nil {
+			continue
+		}
+		// details are ordered by name, so on equal scores the alphabetically first service is kept
+		if sc := intercept.Match(network, hostname, port); sc != -1 && (score == -1 || sc < score) {
+			result = detail
+			score = sc
+		}
+	}
+
+	if result == nil {
+		return nil, -1, errors.Errorf("no service for address[%s:%s:%d]", network, hostname, port)
+	}
+	return result, score, nil
+}
+
+func (self *Context) RefreshServices() error {
+	return self.checkOpen()
+}
+
+func (self *Context) SetServiceRefreshInterval(time.Duration) {}
+
+func (self *Context) RefreshService(serviceName string) (*rest_model.ServiceDetail, error) {
+	detail, found := self.GetService(serviceName)
+	if !found {
+		return nil, &ziti.ServiceNotFoundError{ServiceName: serviceName}
+	}
+	return detail, nil
+}
+
+func (self *Context) GetServicePermissions(name string) (*ziti.ServicePermissions, bool) {
+	detail, found := self.GetService(name)
+	if !found {
+		return nil, false
+	}
+
+	result := &ziti.ServicePermissions{
+		Dial: true,
+		Bind: true,
+	}
+	for configType := range detail.Config {
+		result.ConfigTypes = append(result.ConfigTypes, configType)
+	}
+	sort.Strings(result.ConfigTypes)
+	return result, true
+}
+
+// QueryServices pages through the services ordered by name. The filter is not evaluated, all services match.
+func (self *Context) QueryServices(_ string, limit, offset int) ([]*rest_model.ServiceDetail, int, error) {
+	details := self.network.getServiceDetails()
+	return page(details, offset, limit), len(details), nil
+}
+
+func page[T any](values []T, offset, limit int) []T {
+	if offset >= len(values) {
+		return nil
+	}
+	values = values[offset:]
+	if limit > 0 && limit < len(values) {
+		values = values[:limit]
+	}
+	return values
+}
+
+func (self *Context) GetServiceTerminators(serviceName string, offset, limit int) ([]*rest_model.TerminatorClientDetail, int, error) {
+	service, err := self.getService(serviceName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	listeners := self.network.getTerminators(service)
+	result := make([]*rest_model.TerminatorClientDetail, 0, len(listeners))
+	for _, l := range listeners {
+		id := "zititest-terminator-" + strconv.FormatUint(uint64(l.id), 10)
+		identity := l.identity
+		serviceId := service.id
+		routerId := "zititest"
+		result = append(result, &rest_model.TerminatorClientDetail{
+			BaseEntity: rest_model.BaseEntity{ID: &id},
+			Identity:   &identity,
+			ServiceID:  &serviceId,
+			RouterID:   &routerId,
+		})
+	}
+	return page(result, offset, limit), len(result), nil
+}
+
+func (self *Context) GetServiceTerminatorIdentities(serviceName string) ([]string, error) {
+	service, err := self.getService(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := map[string]struct{}{}
+	for _, l := range self.network.getTerminators(service) {
+		if l.identity != "" {
+			identities[l.identity] = struct{}{}
+		}
+	}
+
+	var result []string
+	for identity := range identities {
+		result = append(result, identity)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// GetEdgeRouters returns no edge routers, as connections do not pass through any.
+func (self *Context) GetEdgeRouters() ([]*ziti.EdgeRouter, error) {
+	return nil, nil
+}
+
+func (self *Context) GetSession(string) (*rest_model.SessionDetail, error) {
+	return nil, ErrNotSupported
+}
+
+func (self *Context) Metrics() metrics.Registry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.metrics == nil {
+		self.metrics = metrics.NewRegistry(self.identity, nil)
+	}
+	return self.metrics
+}
+
+func (self *Context) Close() {
+	_ = self.CloseWithContext(context.Background())
+}
+
+// CloseWithContext closes the listeners and connections of the Context. It never blocks, so ctx is not used.
+func (self *Context) CloseWithContext(context.Context) error {
+	if !self.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	for _, l := range self.getListeners() {
+		_ = l.Close()
+	}
+	for _, c := range self.getConns() {
+		_ = c.Close()
+	}
+
+	self.network.removeContext(self)
+	self.emit(ziti.EventConnectionStateChanged, ziti.ConnectionStateReady, ziti.ConnectionStateDisconnected)
+	return nil
+}
+
+// Shutdown stops accepting new dials and listens, waits for the open connections to close, then closes the Context.
+// If ctx is done first, the remaining connections are closed and the context error returned.
+func (self *Context) Shutdown(ctx context.Context) error {
+	self.shuttingDown.Store(true)
+
+	for _, l := range self.getListeners() {
+		l.stop()
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	var err error
+	for len(self.getConns()) > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	self.Close()
+	return err
+}
+
+func (self *Context) RecentEvents() []ziti.RecentEvent {
+	return nil
+}
+
+func (self *Context) ResourceUsage() ziti.ResourceUsage {
+	return ziti.ResourceUsage{
+		Goroutines:  runtime.NumGoroutine(),
+		Connections: len(self.getConns()),
+		Services:    len(self.network.getServiceDetails()),
+	}
+}
+
+func (self *Context) Stats() ziti.ContextStats {
+	result := ziti.ContextStats{
+		Authenticated: !self.closed.Load(),
+		IdentityName:  self.identity,
+	}
+
+	hosted := map[string]struct{}{}
+	for _, l := range self.getListeners() {
+		hosted[l.service.name] = struct{}{}
+	}
+	for name := range hosted {
+		result.HostedServices = append(result.HostedServices, name)
+	}
+	sort.Strings(result.HostedServices)
+
+	for _, c := range self.getConns() {
+		result.ActiveConnections++
+		result.BytesIn += c.bytesIn.Load()
+		result.BytesOut += c.bytesOut.Load()
+	}
+	return result
+}
+
+func (self *Context) Connections() []edge.ConnStats {
+	var result []edge.ConnStats
+	for _, c := range self.getConns() {
+		result = append(result, c.Stats())
+	}
+	return result
+}
+
+func (self *Context) DebugDump(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "zititest context: identity=%s state=%s\n", self.identity, self.ConnectionState()); err != nil {
+		return err
+	}
+
+	for _, l := range self.getListeners() {
+		if _, err := fmt.Fprintf(w, "listener: id=%d service=%s identity=%s\n", l.id, l.service.name, l.identity); err != nil {
+			return err
+		}
+	}
+
+	for _, stats := range self.Connections() {
+		if _, err := fmt.Fprintf(w, "connection: id=%d service=%s circuit=%s bytesIn=%d bytesOut=%d\n",
+			stats.ConnId, stats.ServiceName, stats.CircuitId, stats.BytesIn, stats.BytesOut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Context) ConnectionState() ziti.ConnectionState {
+	if self.closed.Load() {
+		return ziti.ConnectionStateDisconnected
+	}
+	return ziti.ConnectionStateReady
+}
+
+func (self *Context) Health() *ziti.Health {
+	open := !self.closed.Load()
+	return &ziti.Health{
+		Closed:              !open,
+		ShuttingDown:        self.shuttingDown.Load(),
+		ConnectionState:     self.ConnectionState(),
+		Authenticated:       open,
+		ControllerReachable: open,
+	}
+}
+
+func (self *Context) ApiSessionRefreshStatus() ziti.ApiSessionRefreshStatus {
+	return ziti.ApiSessionRefreshStatus{}
+}
+
+func (self *Context) MetricsSnapshot() *ziti.MetricsSnapshot {
+	return &ziti.MetricsSnapshot{
+		Services:     map[string]*ziti.ServiceMetrics{},
+		OpenCircuits: len(self.getConns()),
+	}
+}
+
+func (self *Context) EventBus() *ziti.EventBus {
+	return self.impl.EventBus()
+}
+
+func (self *Context) QueueStats() ziti.QueueStats {
+	return ziti.QueueStats{}
+}
+
+// DialLatencies returns an empty histogram, dial latencies are not recorded.
+func (self *Context) DialLatencies(_ string, window time.Duration) *ziti.LatencyHistogram {
+	return &ziti.LatencyHistogram{Window: window}
+}
+
+// RegisterDialSLO is ignored, dial latencies are not recorded.
+func (self *Context) RegisterDialSLO(ziti.DialSLO) {}
+
+// AddZitiMfaHandler is ignored, as a zititest Context never needs MFA to authenticate.
+func (self *Context) AddZitiMfaHandler(func(query *rest_model.AuthQueryDetail, resp ziti.MfaCodeResponse) error) {
+}
+
+func (self *Context) EnrollZitiMfa() (*rest_model.DetailMfa, error) {
+	return nil, ErrNotSupported
+}
+
+func (self *Context) GetZitiMfa() (*rest_model.DetailMfa, error) {
+	return nil, ErrNotSupported
+}
+
+func (self *Context) GetZitiMfaRecoveryCodes(string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (self *Context) NewZitiMfaRecoveryCodes(string) error {
+	return ErrNotSupported
+}
+
+func (self *Context) VerifyZitiMfa(string) error {
+	return ErrNotSupported
+}
+
+func (self *Context) RemoveZitiMfa(string) error {
+	return ErrNotSupported
+}
+
+func (self *Context) GetAuthenticators() ([]*rest_model.AuthenticatorDetail, error) {
+	return nil, ErrNotSupported
+}
+
+func (self *Context) UpdatePassword(string, string) error {
+	return ErrNotSupported
+}
+
+func (self *Context) RotateCertAuthenticator() ([]*x509.Certificate, crypto.PrivateKey, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (self *Context) GetId() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.id
+}
+
+func (self *Context) SetId(id string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.id = id
+}
+
+func (self *Context) Flags() *ziti.FeatureFlags {
+	return self.impl.Flags()
+}
+
+func (self *Context) WarmServices(names []string) error {
+	for _, name := range names {
+		if _, err := self.getService(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Context) Events() ziti.Eventer {
+	return self.eventer
+}
@@ -0,0 +1,141 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+// eventer registers the typed event listeners of a Context, so that they are called with the Context rather than the
+// emitter it uses internally.
+type eventer struct {
+	ziti.Eventer
+	ctx *Context
+}
+
+func (self *eventer) AddServiceAddedListener(handler func(ziti.Context, *rest_model.ServiceDetail)) func() {
+	return self.Eventer.AddServiceAddedListener(func(_ ziti.Context, detail *rest_model.ServiceDetail) {
+		handler(self.ctx, detail)
+	})
+}
+
+func (self *eventer) AddServiceChangedListener(handler func(ziti.Context, *rest_model.ServiceDetail)) func() {
+	return self.Eventer.AddServiceChangedListener(func(_ ziti.Context, detail *rest_model.ServiceDetail) {
+		handler(self.ctx, detail)
+	})
+}
+
+func (self *eventer) AddServicesUpdatedListener(handler func(ziti.Context, *ziti.ServiceDiff)) func() {
+	return self.Eventer.AddServicesUpdatedListener(func(_ ziti.Context, diff *ziti.ServiceDiff) {
+		handler(self.ctx, diff)
+	})
+}
+
+func (self *eventer) AddServiceRemovedListener(handler func(ziti.Context, *rest_model.ServiceDetail)) func() {
+	return self.Eventer.AddServiceRemovedListener(func(_ ziti.Context, detail *rest_model.ServiceDetail) {
+		handler(self.ctx, detail)
+	})
+}
+
+func (self *eventer) AddRouterConnectedListener(handler func(ztx ziti.Context, name string, addr string)) func() {
+	return self.Eventer.AddRouterConnectedListener(func(_ ziti.Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *eventer) AddRouterDisconnectedListener(handler func(ztx ziti.Context, name string, addr string)) func() {
+	return self.Eventer.AddRouterDisconnectedListener(func(_ ziti.Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *eventer) AddRouterOfflineListener(handler func(ztx ziti.Context, name string, addr string)) func() {
+	return self.Eventer.AddRouterOfflineListener(func(_ ziti.Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *eventer) AddRouterOnlineListener(handler func(ztx ziti.Context, name string, addr string)) func() {
+	return self.Eventer.AddRouterOnlineListener(func(_ ziti.Context, name string, addr string) {
+		handler(self.ctx, name, addr)
+	})
+}
+
+func (self *eventer) AddMfaTotpCodeListener(handler func(ziti.Context, *rest_model.AuthQueryDetail, ziti.MfaCodeResponse)) func() {
+	return self.Eventer.AddMfaTotpCodeListener(func(_ ziti.Context, query *rest_model.AuthQueryDetail, resp ziti.MfaCodeResponse) {
+		handler(self.ctx, query, resp)
+	})
+}
+
+func (self *eventer) AddAuthQueryListener(handler func(ziti.Context, *rest_model.AuthQueryDetail)) func() {
+	return self.Eventer.AddAuthQueryListener(func(_ ziti.Context, query *rest_model.AuthQueryDetail) {
+		handler(self.ctx, query)
+	})
+}
+
+func (self *eventer) AddAuthenticationStatePartialListener(handler func(ziti.Context, apis.ApiSession)) func() {
+	return self.Eventer.AddAuthenticationStatePartialListener(func(_ ziti.Context, session apis.ApiSession) {
+		handler(self.ctx, session)
+	})
+}
+
+func (self *eventer) AddAuthenticationStateFullListener(handler func(ziti.Context, apis.ApiSession)) func() {
+	return self.Eventer.AddAuthenticationStateFullListener(func(_ ziti.Context, session apis.ApiSession) {
+		handler(self.ctx, session)
+	})
+}
+
+func (self *eventer) AddAuthenticationStateUnauthenticatedListener(handler func(ziti.Context, apis.ApiSession)) func() {
+	return self.Eventer.AddAuthenticationStateUnauthenticatedListener(func(_ ziti.Context, session apis.ApiSession) {
+		handler(self.ctx, session)
+	})
+}
+
+func (self *eventer) AddAuthenticationFailedListener(handler func(ziti.Context, error)) func() {
+	return self.Eventer.AddAuthenticationFailedListener(func(_ ziti.Context, err error) {
+		handler(self.ctx, err)
+	})
+}
+
+func (self *eventer) AddConnectionIdleListener(handler func(ziti.Context, string, edge.Conn, time.Duration)) func() {
+	return self.Eventer.AddConnectionIdleListener(func(_ ziti.Context, serviceName string, conn edge.Conn, idle time.Duration) {
+		handler(self.ctx, serviceName, conn, idle)
+	})
+}
+
+func (self *eventer) AddListenerRebindListener(handler func(ziti.Context, string, string, string, time.Duration)) func() {
+	return self.Eventer.AddListenerRebindListener(func(_ ziti.Context, serviceName string, lostRouter string, newRouter string, downtime time.Duration) {
+		handler(self.ctx, serviceName, lostRouter, newRouter, downtime)
+	})
+}
+
+func (self *eventer) AddControllerBreakerListener(handler func(ziti.Context, string, ziti.BreakerState)) func() {
+	return self.Eventer.AddControllerBreakerListener(func(_ ziti.Context, host string, state ziti.BreakerState) {
+		handler(self.ctx, host, state)
+	})
+}
+
+func (self *eventer) AddConnectionStateListener(handler func(ziti.Context, ziti.ConnectionState, ziti.ConnectionState)) func() {
+	return self.Eventer.AddConnectionStateListener(func(_ ziti.Context, from ziti.ConnectionState, to ziti.ConnectionState) {
+		handler(self.ctx, from, to)
+	})
+}
@@ -0,0 +1,98 @@
+package zititest
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjector(t *testing.T) {
+	req := require.New(t)
+
+	harness, err := NewHarness()
+	req.NoError(err)
+	defer harness.Close()
+
+	harness.AddService("echo", nil)
+
+	server, err := harness.NewContext("server", nil)
+	req.NoError(err)
+	defer server.Close()
+
+	listener, err := server.ListenWithOptions("echo", ziti.NewListenOptions(func(options *ziti.ListenOptions) {
+		options.BindUsingEdgeIdentity = true
+	}))
+	req.NoError(err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	client, err := harness.NewContext("client", nil)
+	req.NoError(err)
+	defer client.Close()
+	req.NoError(client.Authenticate())
+
+	req.Eventually(func() bool {
+		identities, err := client.GetServiceTerminatorIdentities("echo")
+		return err == nil && len(identities) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	faults, ok := ziti.GetFaultInjector(client)
+	req.True(ok)
+
+	t.Run("dropped dials", func(t *testing.T) {
+		req := require.New(t)
+		faults.DropDials(1)
+
+		_, err := client.Dial("echo")
+		req.ErrorIs(err, ziti.ErrInjectedFault)
+
+		conn, err := client.Dial("echo")
+		req.NoError(err)
+		req.NoError(conn.Close())
+	})
+
+	t.Run("router disconnect", func(t *testing.T) {
+		req := require.New(t)
+
+		conn, err := client.Dial("echo")
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		req.Equal(1, faults.DisconnectRouters(""))
+
+		_, err = io.ReadAll(conn)
+		req.NoError(err, "the connection should be closed when the router goes away")
+
+		conn, err = client.Dial("echo")
+		req.NoError(err, "the next dial should reconnect to the router")
+		req.NoError(conn.Close())
+	})
+
+	t.Run("corrupted session token", func(t *testing.T) {
+		req := require.New(t)
+		req.NoError(faults.CorruptSessionToken())
+		req.Equal(1, faults.DisconnectRouters(""))
+
+		// the router rejects the corrupted token, after which the SDK re-authenticates and the dial goes through
+		conn, err := client.Dial("echo")
+		req.NoError(err)
+		req.NoError(conn.Close())
+
+		token := string(client.(*ziti.ContextImpl).CtrlClt.GetCurrentApiSession().GetToken())
+		req.NotContains(token, "corrupted")
+	})
+}
@@ -0,0 +1,304 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/identity"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// Harness runs a minimal Edge Client API and edge router in-process, so that integration tests can exercise the SDK
+// as it runs against a real network, including authentication, service listing, dialing and binding. Unlike a
+// Network, the Contexts of a Harness are created by ziti.NewContextWithOpts and talk to the harness over loopback TLS.
+//
+// Identities authenticate with certificates issued by the harness CA, see Harness.NewConfig. Every identity may dial
+// and bind every service. Other controller APIs, such as MFA, posture checks and enrollment, are not emulated and
+// fail with a not found error.
+type Harness struct {
+	pki        *pki
+	controller *httptest.Server
+	router     *harnessRouter
+
+	lock        sync.Mutex
+	identities  map[string]*harnessIdentity
+	services    map[string]*harnessService
+	apiSessions map[string]*harnessApiSession
+	sessions    map[string]*harnessSession
+	lastChange  time.Time
+}
+
+type harnessIdentity struct {
+	id   string
+	name string
+}
+
+type harnessService struct {
+	detail *rest_model.ServiceDetail
+}
+
+type harnessApiSession struct {
+	id       string
+	token    string
+	identity *harnessIdentity
+}
+
+type harnessSession struct {
+	id          string
+	token       string
+	apiSession  *harnessApiSession
+	service     *harnessService
+	sessionType rest_model.DialBind
+}
+
+// NewHarness starts the controller and edge router of a new Harness, listening on ephemeral loopback ports.
+func NewHarness() (*Harness, error) {
+	ca, err := newPki()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Harness{
+		pki:         ca,
+		identities:  map[string]*harnessIdentity{},
+		services:    map[string]*harnessService{},
+		apiSessions: map[string]*harnessApiSession{},
+		sessions:    map[string]*harnessSession{},
+		lastChange:  time.Now(),
+	}
+
+	controllerTls, err := ca.serverTlsConfig("zititest-controller")
+	if err != nil {
+		return nil, err
+	}
+
+	result.controller = httptest.NewUnstartedServer(&harnessController{harness: result})
+	result.controller.TLS = controllerTls
+	result.controller.StartTLS()
+
+	if result.router, err = newHarnessRouter(result); err != nil {
+		result.controller.Close()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Close stops the controller and edge router, closing all connections to them.
+func (self *Harness) Close() {
+	self.router.close()
+	self.controller.CloseClientConnections()
+	self.controller.Close()
+}
+
+// ControllerUrl returns the URL of the Edge Client API, as used for Config.ZtAPI.
+func (self *Harness) ControllerUrl() string {
+	return self.controller.URL + "/edge/client/v1"
+}
+
+// RouterUrl returns the address of the edge router, e.g. tls:127.0.0.1:41234.
+func (self *Harness) RouterUrl() string {
+	return self.router.url
+}
+
+// NewConfig returns the config of the named identity, issuing it a certificate if it is new.
+func (self *Harness) NewConfig(identityName string) (*ziti.Config, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	certPem, keyPem, err := self.pki.issue(identityName, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, found := self.identities[identityName]; !found {
+		self.identities[identityName] = &harnessIdentity{
+			id:   uuid.NewString(),
+			name: identityName,
+		}
+	}
+
+	return &ziti.Config{
+		ZtAPI: self.ControllerUrl(),
+		ID: identity.Config{
+			Cert: "pem:" + string(certPem),
+			Key:  "pem:" + string(keyPem),
+			CA:   "pem:" + string(self.pki.certPem),
+		},
+	}, nil
+}
+
+// NewContext returns a new, unauthenticated ziti.Context for the named identity. options may be nil.
+func (self *Harness) NewContext(identityName string, options *ziti.Options) (ziti.Context, error) {
+	cfg, err := self.NewConfig(identityName)
+	if err != nil {
+		return nil, err
+	}
+	return ziti.NewContextWithOpts(cfg, options)
+}
+
+// AddService adds the named service with the given configs, keyed by config type, or replaces the configs of an
+// existing service. Contexts see the change on their next service refresh.
+func (self *Harness) AddService(name string, configs map[string]map[string]interface{}) *rest_model.ServiceDetail {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	service, found := self.services[name]
+	if !found {
+		service = &harnessService{}
+		self.services[name] = service
+	}
+
+	id := uuid.NewString()
+	if found {
+		id = *service.detail.ID
+	}
+
+	serviceName := name
+	encryptionRequired := true
+	strategy := "smartrouting"
+	now := strfmt.DateTime(time.Now())
+
+	service.detail = &rest_model.ServiceDetail{
+		BaseEntity: rest_model.BaseEntity{
+			ID:        &id,
+			CreatedAt: &now,
+			UpdatedAt: &now,
+			Links:     rest_model.Links{},
+			Tags:      &rest_model.Tags{SubTags: map[string]interface{}{}},
+		},
+		Name:               &serviceName,
+		Config:             configs,
+		Configs:            []string{},
+		EncryptionRequired: &encryptionRequired,
+		Permissions:        rest_model.DialBindArray{rest_model.DialBindDial, rest_model.DialBindBind},
+		PostureQueries:     []*rest_model.PostureQueries{},
+		RoleAttributes:     &rest_model.Attributes{},
+		TerminatorStrategy: &strategy,
+	}
+	self.lastChange = time.Now()
+	return service.detail
+}
+
+// RemoveService removes the named service, closing the circuits and terminators carrying it. Returns false if there
+// is no such service.
+func (self *Harness) RemoveService(name string) bool {
+	self.lock.Lock()
+	service, found := self.services[name]
+	if found {
+		delete(self.services, name)
+		self.lastChange = time.Now()
+		for token, session := range self.sessions {
+			if session.service == service {
+				delete(self.sessions, token)
+			}
+		}
+	}
+	self.lock.Unlock()
+
+	if found {
+		self.router.removeService(service)
+	}
+	return found
+}
+
+// RevokeApiSessions invalidates the api sessions of the named identity, as when an identity is deleted or its
+// sessions are removed by an administrator, so that its Contexts must authenticate again.
+func (self *Harness) RevokeApiSessions(identityName string) {
+	self.lock.Lock()
+	var revoked []string
+	for token, apiSession := range self.apiSessions {
+		if apiSession.identity.name == identityName {
+			delete(self.apiSessions, token)
+			revoked = append(revoked, token)
+		}
+	}
+	for token, session := range self.sessions {
+		if session.apiSession.identity.name == identityName {
+			delete(self.sessions, token)
+		}
+	}
+	self.lock.Unlock()
+
+	self.router.closeApiSessions(revoked)
+}
+
+func (self *Harness) getServiceDetails() []*rest_model.ServiceDetail {
+	result := make([]*rest_model.ServiceDetail, 0, len(self.services))
+	for _, service := range self.services {
+		result = append(result, service.detail)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return *result[i].Name < *result[j].Name
+	})
+	return result
+}
+
+func (self *Harness) getServiceById(id string) *harnessService {
+	for _, service := range self.services {
+		if *service.detail.ID == id {
+			return service
+		}
+	}
+	return nil
+}
+
+func (self *Harness) newApiSession(identityName string) (*harnessApiSession, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	id, found := self.identities[identityName]
+	if !found {
+		return nil, errors.Errorf("no identity named '%s'", identityName)
+	}
+
+	result := &harnessApiSession{
+		id:       uuid.NewString(),
+		token:    uuid.NewString(),
+		identity: id,
+	}
+	self.apiSessions[result.token] = result
+	return result, nil
+}
+
+func (self *Harness) getApiSession(token string) (*harnessApiSession, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	result, found := self.apiSessions[token]
+	return result, found
+}
+
+func (self *Harness) removeApiSession(token string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.apiSessions, token)
+}
+
+func (self *Harness) getSession(token string) (*harnessSession, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	result, found := self.sessions[token]
+	return result, found
+}
@@ -0,0 +1,330 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/google/uuid"
+	"github.com/openziti/edge-api/rest_model"
+)
+
+const (
+	clientApiPrefix = "/edge/client/v1"
+
+	// harnessApiSessionTtl is the lifetime reported for api sessions. Api sessions do not actually expire.
+	harnessApiSessionTtl = time.Hour
+
+	harnessRouterName = "zititest-router"
+)
+
+// envelope is the response body of the Edge Client API.
+type envelope struct {
+	Data  interface{}          `json:"data,omitempty"`
+	Meta  *rest_model.Meta     `json:"meta"`
+	Error *rest_model.APIError `json:"error,omitempty"`
+}
+
+// harnessController serves the parts of the Edge Client API the SDK needs to authenticate, list services and create
+// sessions.
+type harnessController struct {
+	harness *Harness
+}
+
+func (self *harnessController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, clientApiPrefix)
+
+	if path == "/authenticate" && r.Method == http.MethodPost {
+		self.authenticate(w, r)
+		return
+	}
+
+	apiSession, found := self.harness.getApiSession(r.Header.Get("zt-session"))
+	if !found {
+		self.writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "no valid api session")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "/current-api-session" && r.Method == http.MethodGet:
+		self.write(w, http.StatusOK, self.apiSessionDetail(apiSession))
+	case path == "/current-api-session" && r.Method == http.MethodDelete:
+		self.harness.removeApiSession(apiSession.token)
+		self.harness.router.closeApiSessions([]string{apiSession.token})
+		self.write(w, http.StatusOK, struct{}{})
+	case path == "/current-api-session/service-updates" && r.Method == http.MethodGet:
+		self.harness.lock.Lock()
+		lastChange := strfmt.DateTime(self.harness.lastChange)
+		self.harness.lock.Unlock()
+		self.write(w, http.StatusOK, &rest_model.CurrentAPISessionServiceUpdateList{LastChangeAt: &lastChange})
+	case path == "/current-identity" && r.Method == http.MethodGet:
+		self.write(w, http.StatusOK, self.identityDetail(apiSession.identity))
+	case path == "/current-identity/edge-routers" && r.Method == http.MethodGet:
+		self.writeList(w, []*rest_model.CurrentIdentityEdgeRouterDetail{self.edgeRouterDetail()}, 0, 1, 1)
+	case path == "/controllers" && r.Method == http.MethodGet:
+		self.writeList(w, []interface{}{}, 0, 0, 0)
+	case path == "/services" && r.Method == http.MethodGet:
+		self.listServices(w, r)
+	case len(segments) == 3 && segments[0] == "services" && segments[2] == "terminators" && r.Method == http.MethodGet:
+		self.listTerminators(w, r, segments[1])
+	case path == "/sessions" && r.Method == http.MethodPost:
+		self.createSession(w, r, apiSession)
+	case path == "/posture-response" && r.Method == http.MethodPost:
+		self.write(w, http.StatusCreated, struct{}{})
+	case path == "/posture-response-bulk" && r.Method == http.MethodPost:
+		self.write(w, http.StatusOK, struct{}{})
+	default:
+		self.writeError(w, http.StatusNotFound, "NOT_FOUND", "not supported by the zititest controller: "+r.Method+" "+path)
+	}
+}
+
+func (self *harnessController) authenticate(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("method") != "cert" || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		self.writeError(w, http.StatusUnauthorized, "INVALID_AUTH", "only certificate authentication is supported")
+		return
+	}
+
+	apiSession, err := self.harness.newApiSession(r.TLS.PeerCertificates[0].Subject.CommonName)
+	if err != nil {
+		self.writeError(w, http.StatusUnauthorized, "INVALID_AUTH", err.Error())
+		return
+	}
+
+	self.write(w, http.StatusOK, self.apiSessionDetail(apiSession))
+}
+
+func (self *harnessController) apiSessionDetail(apiSession *harnessApiSession) *rest_model.CurrentAPISessionDetail {
+	now := strfmt.DateTime(time.Now())
+	expiresAt := strfmt.DateTime(time.Now().Add(harnessApiSessionTtl))
+	expirationSeconds := int64(harnessApiSessionTtl.Seconds())
+	token := apiSession.token
+	id := apiSession.id
+	identityId := apiSession.identity.id
+	identityName := apiSession.identity.name
+	mfa := false
+
+	return &rest_model.CurrentAPISessionDetail{
+		APISessionDetail: rest_model.APISessionDetail{
+			BaseEntity: rest_model.BaseEntity{
+				ID:        &id,
+				CreatedAt: &now,
+				UpdatedAt: &now,
+				Links:     rest_model.Links{},
+			},
+			AuthQueries:   rest_model.AuthQueryList{},
+			ConfigTypes:   []string{},
+			Identity:      &rest_model.EntityRef{ID: identityId, Name: identityName},
+			IdentityID:    &identityId,
+			IsMfaComplete: &mfa,
+			IsMfaRequired: &mfa,
+			Token:         &token,
+		},
+		ExpirationSeconds: &expirationSeconds,
+		ExpiresAt:         &expiresAt,
+	}
+}
+
+func (self *harnessController) identityDetail(id *harnessIdentity) *rest_model.IdentityDetail {
+	identityId := id.id
+	name := id.name
+	now := strfmt.DateTime(time.Now())
+	return &rest_model.IdentityDetail{
+		BaseEntity: rest_model.BaseEntity{
+			ID:        &identityId,
+			CreatedAt: &now,
+			UpdatedAt: &now,
+			Links:     rest_model.Links{},
+		},
+		Name: &name,
+	}
+}
+
+func (self *harnessController) edgeRouterDetail() *rest_model.CurrentIdentityEdgeRouterDetail {
+	id := self.harness.router.id
+	return &rest_model.CurrentIdentityEdgeRouterDetail{
+		BaseEntity: rest_model.BaseEntity{
+			ID:    &id,
+			Links: rest_model.Links{},
+		},
+		CommonEdgeRouterProperties: self.edgeRouterProperties(),
+	}
+}
+
+func (self *harnessController) edgeRouterProperties() rest_model.CommonEdgeRouterProperties {
+	name := harnessRouterName
+	hostname := "127.0.0.1"
+	syncStatus := "SYNC_DONE"
+	cost := int64(0)
+	online := true
+	disabled := false
+	noTraversal := false
+
+	return rest_model.CommonEdgeRouterProperties{
+		Cost:               &cost,
+		Disabled:           &disabled,
+		Hostname:           &hostname,
+		IsOnline:           &online,
+		Name:               &name,
+		NoTraversal:        &noTraversal,
+		SupportedProtocols: map[string]string{"tls": strings.Replace(self.harness.router.url, ":", "://", 1)},
+		SyncStatus:         &syncStatus,
+	}
+}
+
+func (self *harnessController) listServices(w http.ResponseWriter, r *http.Request) {
+	self.harness.lock.Lock()
+	services := self.harness.getServiceDetails()
+	self.harness.lock.Unlock()
+
+	if filter := r.URL.Query().Get("filter"); strings.HasPrefix(filter, `name="`) {
+		name := strings.TrimSuffix(strings.TrimPrefix(filter, `name="`), `"`)
+		var matched []*rest_model.ServiceDetail
+		for _, service := range services {
+			if *service.Name == name {
+				matched = append(matched, service)
+			}
+		}
+		services = matched
+	}
+
+	offset, limit := self.getPage(r)
+	self.writeList(w, page(services, offset, limit), offset, limit, len(services))
+}
+
+func (self *harnessController) listTerminators(w http.ResponseWriter, r *http.Request, serviceId string) {
+	self.harness.lock.Lock()
+	service := self.harness.getServiceById(serviceId)
+	self.harness.lock.Unlock()
+
+	if service == nil {
+		self.writeError(w, http.StatusNotFound, "NOT_FOUND", "no service with id "+serviceId)
+		return
+	}
+
+	terminators := self.harness.router.getTerminators(service)
+	result := make([]*rest_model.TerminatorClientDetail, 0, len(terminators))
+	for _, terminator := range terminators {
+		id := terminator.id
+		identity := terminator.identity
+		routerId := self.harness.router.id
+		result = append(result, &rest_model.TerminatorClientDetail{
+			BaseEntity: rest_model.BaseEntity{ID: &id, Links: rest_model.Links{}},
+			Identity:   &identity,
+			RouterID:   &routerId,
+			ServiceID:  &serviceId,
+		})
+	}
+
+	offset, limit := self.getPage(r)
+	self.writeList(w, page(result, offset, limit), offset, limit, len(result))
+}
+
+func (self *harnessController) createSession(w http.ResponseWriter, r *http.Request, apiSession *harnessApiSession) {
+	request := &rest_model.SessionCreate{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		self.writeError(w, http.StatusBadRequest, "COULD_NOT_PARSE_BODY", err.Error())
+		return
+	}
+
+	self.harness.lock.Lock()
+	service := self.harness.getServiceById(request.ServiceID)
+	var session *harnessSession
+	if service != nil {
+		session = &harnessSession{
+			id:          uuid.NewString(),
+			token:       uuid.NewString(),
+			apiSession:  apiSession,
+			service:     service,
+			sessionType: request.Type,
+		}
+		self.harness.sessions[session.token] = session
+	}
+	self.harness.lock.Unlock()
+
+	if session == nil {
+		self.writeError(w, http.StatusNotFound, "NOT_FOUND", "no service with id "+request.ServiceID)
+		return
+	}
+
+	id := session.id
+	token := session.token
+	apiSessionId := apiSession.id
+	identityId := apiSession.identity.id
+	serviceId := request.ServiceID
+	sessionType := request.Type
+
+	properties := self.edgeRouterProperties()
+	self.write(w, http.StatusCreated, &rest_model.SessionDetail{
+		BaseEntity: rest_model.BaseEntity{
+			ID:    &id,
+			Links: rest_model.Links{},
+		},
+		APISessionID: &apiSessionId,
+		EdgeRouters: []*rest_model.SessionEdgeRouter{{
+			CommonEdgeRouterProperties: properties,
+			Urls:                       properties.SupportedProtocols,
+		}},
+		IdentityID: &identityId,
+		ServiceID:  &serviceId,
+		Token:      &token,
+		Type:       &sessionType,
+	})
+}
+
+func (self *harnessController) getPage(r *http.Request) (offset, limit int) {
+	offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	return offset, limit
+}
+
+func (self *harnessController) writeList(w http.ResponseWriter, data interface{}, offset, limit, total int) {
+	offset64, limit64, total64 := int64(offset), int64(limit), int64(total)
+	self.writeEnvelope(w, http.StatusOK, &envelope{
+		Data: data,
+		Meta: &rest_model.Meta{
+			Pagination: &rest_model.Pagination{
+				Offset:     &offset64,
+				Limit:      &limit64,
+				TotalCount: &total64,
+			},
+		},
+	})
+}
+
+func (self *harnessController) write(w http.ResponseWriter, status int, data interface{}) {
+	self.writeEnvelope(w, status, &envelope{Data: data, Meta: &rest_model.Meta{}})
+}
+
+func (self *harnessController) writeError(w http.ResponseWriter, status int, code, message string) {
+	self.writeEnvelope(w, status, &envelope{
+		Meta:  &rest_model.Meta{},
+		Error: &rest_model.APIError{Code: code, Message: message},
+	})
+}
+
+func (self *harnessController) writeEnvelope(w http.ResponseWriter, status int, body *envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
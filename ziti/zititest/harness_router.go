@@ -0,0 +1,529 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"crypto/x509"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/channel/v2/latency"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
+)
+
+// harnessDialTimeout is how long the router waits for the hosting side to answer a dial.
+const harnessDialTimeout = 5 * time.Second
+
+// harnessRouter emulates an edge router, relaying the circuits between the SDKs dialing and hosting each service.
+// Payloads are forwarded unchanged, so end-to-end encryption works as through a real router.
+type harnessRouter struct {
+	harness  *Harness
+	id       string
+	url      string
+	listener channel.UnderlayListener
+
+	lock        sync.Mutex
+	channels    map[channel.Channel]string
+	circuits    map[routerLink]routerLink
+	terminators map[string]*harnessTerminator
+	next        int
+	connIdSeq   atomic.Uint32
+	closed      atomic.Bool
+}
+
+// routerLink identifies an edge connection on one of the router's channels.
+type routerLink struct {
+	ch     channel.Channel
+	connId uint32
+}
+
+type harnessTerminator struct {
+	id           string
+	service      *harnessService
+	identity     string
+	token        string
+	link         routerLink
+	pubKey       []byte
+	cryptoMethod []byte
+	cost         uint16
+	precedence   edge.Precedence
+}
+
+func newHarnessRouter(harness *Harness) (*harnessRouter, error) {
+	id, err := harness.pki.routerIdentity(harnessRouterName)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := getFreePort()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &harnessRouter{
+		harness:     harness,
+		id:          uuid.NewString(),
+		url:         "tls:127.0.0.1:" + strconv.Itoa(port),
+		channels:    map[channel.Channel]string{},
+		circuits:    map[routerLink]routerLink{},
+		terminators: map[string]*harnessTerminator{},
+	}
+
+	// the SDK numbers the connections it opens from the bottom half of the id space, the router from the top half
+	result.connIdSeq.Store(math.MaxUint32 / 2)
+
+	addr, err := transport.ParseAddress(result.url)
+	if err != nil {
+		return nil, err
+	}
+
+	result.listener = channel.NewClassicListener(id, addr, channel.ListenerConfig{
+		ConnectOptions:     channel.DefaultConnectOptions(),
+		ConnectionHandlers: []channel.ConnectionHandler{result},
+	})
+	if err = result.listener.Listen(); err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on %s", result.url)
+	}
+
+	go result.accept()
+	return result, nil
+}
+
+// getFreePort returns a loopback port that was free when checked.
+func getFreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (self *harnessRouter) accept() {
+	for !self.closed.Load() {
+		if _, err := channel.NewChannel(harnessRouterName, self.listener, channel.BindHandlerF(self.bindChannel), channel.DefaultOptions()); err != nil {
+			if !self.closed.Load() {
+				pfxlog.Logger().WithError(err).Debug("zititest router failed to accept channel")
+			}
+		}
+	}
+}
+
+// HandleConnection rejects SDK connections that do not present a valid api session token.
+func (self *harnessRouter) HandleConnection(hello *channel.Hello, _ []*x509.Certificate) error {
+	if _, found := self.harness.getApiSession(string(hello.Headers[edge.SessionTokenHeader])); !found {
+		return errors.New("invalid api session token")
+	}
+	return nil
+}
+
+func (self *harnessRouter) bindChannel(binding channel.Binding) error {
+	ch := binding.GetChannel()
+
+	self.lock.Lock()
+	self.channels[ch] = string(ch.Underlay().Headers()[edge.SessionTokenHeader])
+	self.lock.Unlock()
+
+	binding.AddReceiveHandlerF(edge.ContentTypeConnect, self.handleConnect)
+	binding.AddReceiveHandlerF(edge.ContentTypeData, self.handleData)
+	binding.AddReceiveHandlerF(edge.ContentTypeStateClosed, self.handleStateClosed)
+	binding.AddReceiveHandlerF(edge.ContentTypeBind, self.handleBind)
+	binding.AddReceiveHandlerF(edge.ContentTypeUnbind, self.handleUnbind)
+	binding.AddReceiveHandlerF(edge.ContentTypeUpdateBind, self.handleUpdateBind)
+	binding.AddReceiveHandlerF(edge.ContentTypeHealthEvent, func(*channel.Message, channel.Channel) {})
+	binding.AddReceiveHandlerF(edge.ContentTypeTraceRoute, self.handleTraceRoute)
+	binding.AddReceiveHandlerF(edge.ContentTypeUpdateToken, self.handleUpdateToken)
+	binding.AddReceiveHandlerF(edge.ContentTypePostureResponse, func(*channel.Message, channel.Channel) {})
+	binding.AddReceiveHandler(channel.ContentTypeLatencyType, &latency.LatencyHandler{})
+	binding.AddCloseHandler(channel.CloseHandlerF(self.handleClose))
+	return nil
+}
+
+func (self *harnessRouter) send(ch channel.Channel, msg *channel.Message) {
+	if err := msg.WithTimeout(harnessDialTimeout).Send(ch); err != nil {
+		pfxlog.Logger().WithError(err).WithField("contentType", msg.ContentType).Debug("zititest router failed to send message")
+	}
+}
+
+func (self *harnessRouter) reply(ch channel.Channel, request *channel.Message, reply *channel.Message) {
+	reply.ReplyTo(request)
+	self.send(ch, reply)
+}
+
+func (self *harnessRouter) getSession(ch channel.Channel, msg *channel.Message) (*harnessSession, error) {
+	session, found := self.harness.getSession(string(msg.Body))
+	if !found {
+		return nil, errors.New("invalid session")
+	}
+
+	self.lock.Lock()
+	apiSessionToken := self.channels[ch]
+	self.lock.Unlock()
+
+	if session.apiSession.token != apiSessionToken {
+		return nil, errors.New("session does not belong to the api session of the connection")
+	}
+	return session, nil
+}
+
+func (self *harnessRouter) handleConnect(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+	go self.connect(msg, routerLink{ch: ch, connId: connId})
+}
+
+// connect sets up a circuit from the dialing connection to a terminator of the service, by dialing the hosting SDK.
+func (self *harnessRouter) connect(msg *channel.Message, dialer routerLink) {
+	closed := func(reason string) {
+		self.reply(dialer.ch, msg, edge.NewStateClosedMsg(dialer.connId, reason))
+	}
+
+	session, err := self.getSession(dialer.ch, msg)
+	if err != nil {
+		closed(err.Error())
+		return
+	}
+
+	if session.sessionType != rest_model.DialBindDial {
+		closed("session is not a dial session")
+		return
+	}
+
+	identity, _ := msg.GetStringHeader(edge.TerminatorIdentityHeader)
+	terminator := self.selectTerminator(session.service, identity)
+	if terminator == nil {
+		closed("service " + *session.service.detail.Name + " has no terminators")
+		return
+	}
+
+	host := routerLink{ch: terminator.link.ch, connId: self.connIdSeq.Add(1)}
+	circuitId := uuid.NewString()
+
+	dial := edge.NewDialMsg(terminator.link.connId, terminator.token, session.apiSession.identity.name)
+	dial.PutUint32Header(edge.RouterProvidedConnId, host.connId)
+	dial.PutStringHeader(edge.CircuitIdHeader, circuitId)
+	for _, header := range []int32{edge.AppDataHeader, edge.PublicKeyHeader, edge.CryptoMethodHeader, edge.ConnectionMarkerHeader} {
+		if value, found := msg.Headers[header]; found {
+			dial.Headers[header] = value
+		}
+	}
+
+	self.lock.Lock()
+	self.circuits[dialer] = host
+	self.circuits[host] = dialer
+	self.lock.Unlock()
+
+	dialReply, err := dial.WithTimeout(harnessDialTimeout).SendForReply(host.ch)
+	if err == nil && dialReply.ContentType != edge.ContentTypeDialSuccess {
+		err = errors.Errorf("dial failed: %s", string(dialReply.Body))
+	}
+
+	if err != nil {
+		self.removeCircuit(dialer)
+		closed(err.Error())
+		return
+	}
+
+	connected := edge.NewStateConnectedMsg(dialer.connId)
+	connected.PutStringHeader(edge.CircuitIdHeader, circuitId)
+	if terminator.pubKey != nil {
+		connected.Headers[edge.PublicKeyHeader] = terminator.pubKey
+		connected.Headers[edge.CryptoMethodHeader] = terminator.cryptoMethod
+	}
+	self.reply(dialer.ch, msg, connected)
+}
+
+// selectTerminator picks a terminator of the service, rotating between those of the given identity, or between all
+// of them if identity is empty.
+func (self *harnessRouter) selectTerminator(service *harnessService, identity string) *harnessTerminator {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var candidates []*harnessTerminator
+	for _, terminator := range self.terminators {
+		if terminator.service == service && (identity == "" || terminator.identity == identity) {
+			candidates = append(candidates, terminator)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].id < candidates[j].id
+	})
+	self.next++
+	return candidates[self.next%len(candidates)]
+}
+
+func (self *harnessRouter) handleData(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+
+	self.lock.Lock()
+	peer, found := self.circuits[routerLink{ch: ch, connId: connId}]
+	self.lock.Unlock()
+
+	if !found {
+		self.send(ch, edge.NewStateClosedMsg(connId, "no circuit for connection"))
+		return
+	}
+
+	seq, _ := msg.GetUint32Header(edge.SeqHeader)
+	forward := edge.NewDataMsg(peer.connId, seq, msg.Body)
+	if flags, found := msg.Headers[edge.FlagsHeader]; found {
+		forward.Headers[edge.FlagsHeader] = flags
+	}
+	self.send(peer.ch, forward)
+}
+
+func (self *harnessRouter) handleStateClosed(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+	link := routerLink{ch: ch, connId: connId}
+
+	if peer, found := self.removeCircuit(link); found {
+		self.send(peer.ch, edge.NewStateClosedMsg(peer.connId, string(msg.Body)))
+		return
+	}
+
+	self.lock.Lock()
+	for id, terminator := range self.terminators {
+		if terminator.link == link {
+			delete(self.terminators, id)
+		}
+	}
+	self.lock.Unlock()
+}
+
+func (self *harnessRouter) removeCircuit(link routerLink) (routerLink, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	peer, found := self.circuits[link]
+	if found {
+		delete(self.circuits, link)
+		delete(self.circuits, peer)
+	}
+	return peer, found
+}
+
+func (self *harnessRouter) handleBind(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+
+	session, err := self.getSession(ch, msg)
+	if err == nil && session.sessionType != rest_model.DialBindBind {
+		err = errors.New("session is not a bind session")
+	}
+	if err != nil {
+		self.reply(ch, msg, edge.NewStateClosedMsg(connId, err.Error()))
+		return
+	}
+
+	identity, _ := msg.GetStringHeader(edge.TerminatorIdentityHeader)
+	cost, _ := msg.GetUint16Header(edge.CostHeader)
+	precedence, _ := msg.GetByteHeader(edge.PrecedenceHeader)
+
+	terminator := &harnessTerminator{
+		id:           uuid.NewString(),
+		service:      session.service,
+		identity:     identity,
+		token:        session.token,
+		link:         routerLink{ch: ch, connId: connId},
+		pubKey:       msg.Headers[edge.PublicKeyHeader],
+		cryptoMethod: msg.Headers[edge.CryptoMethodHeader],
+		cost:         cost,
+		precedence:   edge.Precedence(precedence),
+	}
+
+	self.lock.Lock()
+	self.terminators[terminator.id] = terminator
+	self.lock.Unlock()
+
+	self.reply(ch, msg, edge.NewStateConnectedMsg(connId))
+
+	if supported, _ := msg.GetBoolHeader(edge.SupportsBindSuccessHeader); supported {
+		bindSuccess := channel.NewMessage(edge.ContentTypeBindSuccess, nil)
+		bindSuccess.PutUint32Header(edge.ConnIdHeader, connId)
+		self.send(ch, bindSuccess)
+	}
+}
+
+func (self *harnessRouter) handleUnbind(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+	link := routerLink{ch: ch, connId: connId}
+	token := string(msg.Body)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for id, terminator := range self.terminators {
+		if terminator.link == link && terminator.token == token {
+			delete(self.terminators, id)
+		}
+	}
+}
+
+func (self *harnessRouter) handleUpdateBind(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+	link := routerLink{ch: ch, connId: connId}
+	cost, hasCost := msg.GetUint16Header(edge.CostHeader)
+	precedence, hasPrecedence := msg.GetByteHeader(edge.PrecedenceHeader)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, terminator := range self.terminators {
+		if terminator.link == link && terminator.token == string(msg.Body) {
+			if hasCost {
+				terminator.cost = cost
+			}
+			if hasPrecedence {
+				terminator.precedence = edge.Precedence(precedence)
+			}
+		}
+	}
+}
+
+func (self *harnessRouter) handleTraceRoute(msg *channel.Message, ch channel.Channel) {
+	connId, _ := msg.GetUint32Header(edge.ConnIdHeader)
+	hops, _ := msg.GetUint32Header(edge.TraceHopCountHeader)
+	timestamp, _ := msg.GetUint64Header(edge.TimestampHeader)
+	self.reply(ch, msg, edge.NewTraceRouteResponseMsg(connId, hops, timestamp, "edge-router", self.id))
+}
+
+func (self *harnessRouter) handleUpdateToken(msg *channel.Message, ch channel.Channel) {
+	if _, found := self.harness.getApiSession(string(msg.Body)); !found {
+		self.reply(ch, msg, edge.NewUpdateTokenFailedMsg(errors.New("invalid api session token")))
+		return
+	}
+
+	self.lock.Lock()
+	self.channels[ch] = string(msg.Body)
+	self.lock.Unlock()
+
+	self.reply(ch, msg, edge.NewUpdateTokenSuccessMsg())
+}
+
+// handleClose removes the terminators of a closed channel and closes the circuits crossing it.
+func (self *harnessRouter) handleClose(ch channel.Channel) {
+	self.lock.Lock()
+	delete(self.channels, ch)
+
+	for id, terminator := range self.terminators {
+		if terminator.link.ch == ch {
+			delete(self.terminators, id)
+		}
+	}
+
+	var peers []routerLink
+	for link, peer := range self.circuits {
+		if link.ch == ch {
+			delete(self.circuits, link)
+			delete(self.circuits, peer)
+			if peer.ch != ch {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	self.lock.Unlock()
+
+	for _, peer := range peers {
+		self.send(peer.ch, edge.NewStateClosedMsg(peer.connId, "router connection closed"))
+	}
+}
+
+func (self *harnessRouter) getTerminators(service *harnessService) []*harnessTerminator {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var result []*harnessTerminator
+	for _, terminator := range self.terminators {
+		if terminator.service == service {
+			result = append(result, terminator)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+	return result
+}
+
+// removeService closes the terminators and circuits of a removed service.
+func (self *harnessRouter) removeService(service *harnessService) {
+	self.lock.Lock()
+	var closed []routerLink
+	for id, terminator := range self.terminators {
+		if terminator.service == service {
+			delete(self.terminators, id)
+			closed = append(closed, terminator.link)
+		}
+	}
+	self.lock.Unlock()
+
+	for _, link := range closed {
+		self.send(link.ch, edge.NewStateClosedMsg(link.connId, "service removed"))
+	}
+}
+
+// closeApiSessions closes the router connections authenticated with any of the api session tokens.
+func (self *harnessRouter) closeApiSessions(tokens []string) {
+	revoked := map[string]struct{}{}
+	for _, token := range tokens {
+		revoked[token] = struct{}{}
+	}
+
+	self.lock.Lock()
+	var channels []channel.Channel
+	for ch, token := range self.channels {
+		if _, found := revoked[token]; found {
+			channels = append(channels, ch)
+		}
+	}
+	self.lock.Unlock()
+
+	for _, ch := range channels {
+		_ = ch.Close()
+	}
+}
+
+func (self *harnessRouter) close() {
+	if !self.closed.CompareAndSwap(false, true) {
+		return
+	}
+	_ = self.listener.Close()
+
+	self.lock.Lock()
+	var channels []channel.Channel
+	for ch := range self.channels {
+		channels = append(channels, ch)
+	}
+	self.lock.Unlock()
+
+	for _, ch := range channels {
+		_ = ch.Close()
+	}
+}
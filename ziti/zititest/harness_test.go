@@ -0,0 +1,74 @@
+package zititest
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarness_dialBind(t *testing.T) {
+	req := require.New(t)
+
+	harness, err := NewHarness()
+	req.NoError(err)
+	defer harness.Close()
+
+	harness.AddService("echo", nil)
+
+	server, err := harness.NewContext("server", nil)
+	req.NoError(err)
+	defer server.Close()
+	req.NoError(server.Authenticate())
+
+	listener, err := server.ListenWithOptions("echo", ziti.NewListenOptions(func(options *ziti.ListenOptions) {
+		options.BindUsingEdgeIdentity = true
+	}))
+	req.NoError(err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	client, err := harness.NewContext("client", nil)
+	req.NoError(err)
+	defer client.Close()
+	req.NoError(client.Authenticate())
+
+	services, err := client.GetServices()
+	req.NoError(err)
+	req.Len(services, 1)
+	req.Equal("echo", *services[0].Name)
+
+	req.Eventually(func() bool {
+		identities, err := client.GetServiceTerminatorIdentities("echo")
+		return err == nil && len(identities) == 1 && identities[0] == "server"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	conn, err := client.Dial("echo")
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	req.NoError(err)
+	req.Equal("hello", string(buf))
+	req.NotEmpty(conn.GetCircuitId())
+
+	_, err = client.Dial("missing")
+	req.Error(err)
+}
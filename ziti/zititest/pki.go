@@ -0,0 +1,158 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/openziti/identity"
+	"github.com/pkg/errors"
+)
+
+// pki is the certificate authority of a Harness, issuing the server certificates of its controller and edge router
+// and the client certificates of its identities.
+type pki struct {
+	cert    *x509.Certificate
+	certPem []byte
+	key     *ecdsa.PrivateKey
+	pool    *x509.CertPool
+	serial  int64
+}
+
+func newPki() (*pki, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate CA key")
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zititest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create CA certificate")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &pki{
+		cert:    cert,
+		certPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     key,
+		pool:    pool,
+		serial:  1,
+	}, nil
+}
+
+// issue returns a certificate and key signed by the CA. Server certificates are valid for localhost.
+func (self *pki) issue(commonName string, server bool) (certPem, keyPem []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to generate key")
+	}
+
+	self.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(self.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, self.cert, &key.PublicKey, self.key)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to create certificate for %s", commonName)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// serverTlsConfig returns a TLS config presenting a server certificate and accepting client certificates issued by
+// the CA.
+func (self *pki) serverTlsConfig(commonName string) (*tls.Config, error) {
+	certPem, keyPem, err := self.issue(commonName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    self.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// routerIdentity returns the identity of an emulated edge router, presenting a server certificate issued by the CA.
+func (self *pki) routerIdentity(commonName string) (*identity.TokenId, error) {
+	certPem, keyPem, err := self.issue(commonName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := identity.LoadIdentity(identity.Config{
+		Cert:       "pem:" + string(certPem),
+		Key:        "pem:" + string(keyPem),
+		ServerCert: "pem:" + string(certPem),
+		ServerKey:  "pem:" + string(keyPem),
+		CA:         "pem:" + string(self.certPem),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load router identity")
+	}
+	return identity.NewIdentity(id), nil
+}
@@ -0,0 +1,331 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pkg/errors"
+)
+
+// ChannelEventType is the kind of a RecordedMessage.
+type ChannelEventType string
+
+const (
+	// ChannelConnect marks the establishment of an edge router connection.
+	ChannelConnect ChannelEventType = "connect"
+
+	// ChannelTx is a message sent by the SDK to the edge router.
+	ChannelTx ChannelEventType = "tx"
+
+	// ChannelRx is a message received by the SDK from the edge router.
+	ChannelRx ChannelEventType = "rx"
+
+	// ChannelClose marks the closing of an edge router connection, by either side.
+	ChannelClose ChannelEventType = "close"
+)
+
+// RecordedEvent is an entry of a Recording. Exactly one of Http and Channel is set.
+type RecordedEvent struct {
+	// Time is when the event happened, relative to the start of the recording.
+	Time time.Duration `json:"time"`
+
+	Http    *RecordedExchange `json:"http,omitempty"`
+	Channel *RecordedMessage  `json:"channel,omitempty"`
+}
+
+// RecordedExchange is a request to the controller and the response to it, or the error that prevented one.
+type RecordedExchange struct {
+	Method      string      `json:"method"`
+	Url         string      `json:"url"`
+	RequestBody []byte      `json:"requestBody,omitempty"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// RecordedMessage is an event on an edge router connection. Connections are numbered from 1 in the order they were
+// established, so reconnects to the same router are told apart.
+type RecordedMessage struct {
+	Connection  int              `json:"connection"`
+	RouterName  string           `json:"routerName"`
+	RouterUrl   string           `json:"routerUrl"`
+	Type        ChannelEventType `json:"type"`
+	ContentType int32            `json:"contentType,omitempty"`
+	Sequence    int32            `json:"sequence,omitempty"`
+	Headers     map[int32][]byte `json:"headers,omitempty"`
+	Body        []byte           `json:"body,omitempty"`
+}
+
+// Recording is the controller API and edge router traffic of a Context, as captured by a Recorder.
+type Recording struct {
+	Events []*RecordedEvent
+}
+
+// ReadRecording reads a recording written by a Recorder.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	result := &Recording{}
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		event := &RecordedEvent{}
+		if err := decoder.Decode(event); err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "invalid event %d in recording", len(result.Events)+1)
+		}
+		result.Events = append(result.Events, event)
+	}
+}
+
+// LoadRecording reads the recording file at path.
+func LoadRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ReadRecording(f)
+}
+
+// Recorder captures the controller API requests and edge router messages of the Contexts created with its Options,
+// writing one JSON event per line. Channel keepalive and latency messages are not recorded. The recording contains
+// the tokens and payloads of the session, so it should only be made against test networks.
+type Recorder struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+	start   time.Time
+	connSeq int
+	err     error
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		encoder: json.NewEncoder(w),
+		start:   time.Now(),
+	}
+}
+
+// RecordToFile returns a Recorder writing to a new file at path, which is closed by Recorder.Close.
+func RecordToFile(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	result := NewRecorder(f)
+	result.closer = f
+	return result, nil
+}
+
+// Options returns a copy of options, or of ziti.DefaultOptions if nil, which records the traffic of a Context.
+func (self *Recorder) Options(options *ziti.Options) *ziti.Options {
+	if options == nil {
+		options = ziti.DefaultOptions
+	}
+	result := *options
+
+	customizer := result.APIClientCustomizer
+	result.APIClientCustomizer = func(client *http.Client, transport *http.Transport) {
+		if customizer != nil {
+			customizer(client, transport)
+		}
+		client.Transport = &recordingTransport{recorder: self, next: client.Transport}
+	}
+
+	routerConnection := ziti.RouterConnectionOptions{}
+	if result.RouterConnection != nil {
+		routerConnection = *result.RouterConnection
+	}
+	peekHandler := routerConnection.PeekHandler
+	routerConnection.PeekHandler = func(routerName, routerUrl string) channel.PeekHandler {
+		var next channel.PeekHandler
+		if peekHandler != nil {
+			next = peekHandler(routerName, routerUrl)
+		}
+		return self.newPeekHandler(routerName, routerUrl, next)
+	}
+	result.RouterConnection = &routerConnection
+
+	return &result
+}
+
+// Close stops recording, closing the recording file if there is one. It returns the first error writing the
+// recording.
+func (self *Recorder) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closer != nil {
+		if err := self.closer.Close(); err != nil && self.err == nil {
+			self.err = err
+		}
+		self.closer = nil
+	}
+	self.encoder = nil
+	return self.err
+}
+
+func (self *Recorder) record(event *RecordedEvent) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.encoder == nil {
+		return
+	}
+
+	event.Time = time.Since(self.start)
+	if err := self.encoder.Encode(event); err != nil && self.err == nil {
+		self.err = errors.Wrap(err, "unable to write recording")
+	}
+}
+
+func (self *Recorder) newPeekHandler(routerName, routerUrl string, next channel.PeekHandler) channel.PeekHandler {
+	self.lock.Lock()
+	self.connSeq++
+	connection := self.connSeq
+	self.lock.Unlock()
+
+	return &recordingPeekHandler{
+		recorder:   self,
+		connection: connection,
+		routerName: routerName,
+		routerUrl:  routerUrl,
+		next:       next,
+	}
+}
+
+// recordingTransport records the controller requests sent through it.
+type recordingTransport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+func (self *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := &RecordedExchange{
+		Method: req.Method,
+		Url:    req.URL.String(),
+	}
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		exchange.RequestBody = body
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := self.next.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		self.recorder.record(&RecordedEvent{Http: exchange})
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		exchange.Error = err.Error()
+		self.recorder.record(&RecordedEvent{Http: exchange})
+		return nil, err
+	}
+
+	exchange.Status = resp.StatusCode
+	exchange.Header = resp.Header.Clone()
+	exchange.Body = body
+	self.recorder.record(&RecordedEvent{Http: exchange})
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// recordingPeekHandler records the messages of an edge router connection.
+type recordingPeekHandler struct {
+	recorder   *Recorder
+	connection int
+	routerName string
+	routerUrl  string
+	next       channel.PeekHandler
+}
+
+func (self *recordingPeekHandler) Connect(ch channel.Channel, remoteAddress string) {
+	self.recorder.record(&RecordedEvent{Channel: self.newMessage(ChannelConnect)})
+	if self.next != nil {
+		self.next.Connect(ch, remoteAddress)
+	}
+}
+
+func (self *recordingPeekHandler) Rx(m *channel.Message, ch channel.Channel) {
+	self.recordMessage(ChannelRx, m)
+	if self.next != nil {
+		self.next.Rx(m, ch)
+	}
+}
+
+func (self *recordingPeekHandler) Tx(m *channel.Message, ch channel.Channel) {
+	self.recordMessage(ChannelTx, m)
+	if self.next != nil {
+		self.next.Tx(m, ch)
+	}
+}
+
+func (self *recordingPeekHandler) Close(ch channel.Channel) {
+	self.recorder.record(&RecordedEvent{Channel: self.newMessage(ChannelClose)})
+	if self.next != nil {
+		self.next.Close(ch)
+	}
+}
+
+func (self *recordingPeekHandler) recordMessage(eventType ChannelEventType, m *channel.Message) {
+	if isChannelControlMessage(m.ContentType) {
+		return
+	}
+
+	msg := self.newMessage(eventType)
+	msg.ContentType = m.ContentType
+	msg.Sequence = m.Sequence()
+	msg.Headers = m.Headers
+	msg.Body = m.Body
+	self.recorder.record(&RecordedEvent{Channel: msg})
+}
+
+func (self *recordingPeekHandler) newMessage(eventType ChannelEventType) *RecordedMessage {
+	return &RecordedMessage{
+		Connection: self.connection,
+		RouterName: self.routerName,
+		RouterUrl:  self.routerUrl,
+		Type:       eventType,
+	}
+}
+
+// isChannelControlMessage returns true for the keepalive and latency messages of the channel itself, whose timing
+// varies between runs.
+func isChannelControlMessage(contentType int32) bool {
+	return contentType <= channel.ContentTypeHeartbeat
+}
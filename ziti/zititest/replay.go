@@ -0,0 +1,536 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package zititest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/channel/v2"
+	"github.com/openziti/channel/v2/latency"
+	"github.com/openziti/identity"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/openziti/transport/v2"
+	"github.com/pkg/errors"
+)
+
+// replayWaitTimeout is how long a replayed edge router connection waits for the SDK to send the next recorded
+// message before the replay is considered to have diverged.
+const replayWaitTimeout = 5 * time.Second
+
+// Replayer plays a Recording back to the Contexts created with its Options. Controller requests are answered with
+// the recorded responses, matched by method and path in recorded order, and every recorded edge router is emulated
+// by a loopback listener, whose connections replay the recorded connections to that router in order. Each replayed
+// connection waits for the SDK to send the messages it sent in the recording, then sends the messages it received,
+// so a replay is deterministic regardless of the timing of the recording. Replies, connection ids and edge router
+// addresses are mapped to those of the replay.
+//
+// Payloads are replayed as recorded, so the data of end-to-end encrypted circuits can't be read on replay. Replay is
+// designed for connection, session and error sequences, such as reconnects.
+type Replayer struct {
+	pki           *pki
+	controllerUrl string
+	routers       []*replayRouter
+
+	lock      sync.Mutex
+	exchanges []*RecordedExchange
+	err       error
+}
+
+// NewReplayer starts the edge router listeners of a new Replayer for the recording.
+func NewReplayer(recording *Recording) (*Replayer, error) {
+	ca, err := newPki()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Replayer{
+		pki:           ca,
+		controllerUrl: "https://localhost/edge/client/v1",
+	}
+
+	routers := map[string]*replayRouter{}
+	for _, event := range recording.Events {
+		if event.Http != nil {
+			if len(result.exchanges) == 0 {
+				result.controllerUrl = getControllerUrl(event.Http.Url, result.controllerUrl)
+			}
+			result.exchanges = append(result.exchanges, event.Http)
+		}
+
+		if msg := event.Channel; msg != nil {
+			router, found := routers[msg.RouterUrl]
+			if !found {
+				router = &replayRouter{
+					replayer:    result,
+					recordedUrl: msg.RouterUrl,
+					scripts:     map[int][]*RecordedMessage{},
+				}
+				routers[msg.RouterUrl] = router
+				result.routers = append(result.routers, router)
+			}
+			router.add(msg)
+		}
+	}
+
+	for _, router := range result.routers {
+		if err = router.listen(); err != nil {
+			result.Close()
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// getControllerUrl returns the Edge Client API URL a request URL was sent to, or defaultUrl if it can't be parsed.
+func getControllerUrl(requestUrl, defaultUrl string) string {
+	u, err := url.Parse(requestUrl)
+	if err != nil || u.Host == "" {
+		return defaultUrl
+	}
+
+	apiPath := ""
+	if idx := strings.Index(u.Path, "/edge/client/v1"); idx >= 0 {
+		apiPath = u.Path[:idx] + "/edge/client/v1"
+	}
+	return u.Scheme + "://" + u.Host + apiPath
+}
+
+// Close stops the edge router listeners, closing all connections to them.
+func (self *Replayer) Close() {
+	for _, router := range self.routers {
+		router.close()
+	}
+}
+
+// Err returns the first divergence of the replay from the recording: a controller request that wasn't recorded, or a
+// message the SDK failed to send in time.
+func (self *Replayer) Err() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.err
+}
+
+func (self *Replayer) fail(err error) {
+	pfxlog.Logger().WithError(err).Warn("replay diverged from recording")
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.err == nil {
+		self.err = err
+	}
+}
+
+// NewConfig returns a config for the replayed controller, with a certificate the replayed edge routers accept.
+func (self *Replayer) NewConfig() (*ziti.Config, error) {
+	certPem, keyPem, err := self.pki.issue("zititest-replay", false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ziti.Config{
+		ZtAPI: self.controllerUrl,
+		ID: identity.Config{
+			Cert: "pem:" + string(certPem),
+			Key:  "pem:" + string(keyPem),
+			CA:   "pem:" + string(self.pki.certPem),
+		},
+	}, nil
+}
+
+// Options returns a copy of options, or of ziti.DefaultOptions if nil, which sends the controller requests of a
+// Context to the recording.
+func (self *Replayer) Options(options *ziti.Options) *ziti.Options {
+	if options == nil {
+		options = ziti.DefaultOptions
+	}
+	result := *options
+
+	customizer := result.APIClientCustomizer
+	result.APIClientCustomizer = func(client *http.Client, transport *http.Transport) {
+		if customizer != nil {
+			customizer(client, transport)
+		}
+		client.Transport = &replayTransport{replayer: self}
+	}
+
+	return &result
+}
+
+// NewContext returns a new, unauthenticated ziti.Context replaying the recording. options may be nil.
+func (self *Replayer) NewContext(options *ziti.Options) (ziti.Context, error) {
+	cfg, err := self.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	return ziti.NewContextWithOpts(cfg, self.Options(options))
+}
+
+// nextExchange returns the first unreplayed exchange for the request, preferring one with the same query.
+func (self *Replayer) nextExchange(req *http.Request) *RecordedExchange {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	match := -1
+	for i, exchange := range self.exchanges {
+		if exchange.Method != req.Method {
+			continue
+		}
+
+		u, err := url.Parse(exchange.Url)
+		if err != nil || u.Path != req.URL.Path {
+			continue
+		}
+
+		if u.RawQuery == req.URL.RawQuery {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return nil
+	}
+
+	result := self.exchanges[match]
+	self.exchanges = append(self.exchanges[:match:match], self.exchanges[match+1:]...)
+	return result
+}
+
+// rewriteRouterUrls replaces the addresses of the recorded edge routers with those of their replay listeners. The
+// controller returns addresses as URLs, e.g. tls://router:443, which the SDK records in transport form, e.g.
+// tls:router:443, so both forms are replaced.
+func (self *Replayer) rewriteRouterUrls(body []byte) []byte {
+	for _, router := range self.routers {
+		if router.recordedUrl == "" {
+			continue
+		}
+		for _, replace := range []func(string) string{toUrlForm, func(s string) string { return s }} {
+			body = bytes.ReplaceAll(body, []byte(replace(router.recordedUrl)), []byte(replace(router.url)))
+		}
+	}
+	return body
+}
+
+// toUrlForm turns a transport address, e.g. tls:router:443, into a URL, e.g. tls://router:443.
+func toUrlForm(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	return strings.Replace(addr, ":", "://", 1)
+}
+
+// replayTransport answers controller requests from the recording.
+type replayTransport struct {
+	replayer *Replayer
+}
+
+func (self *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+
+	exchange := self.replayer.nextExchange(req)
+	if exchange == nil {
+		err := errors.Errorf("no recorded response for %s %s", req.Method, req.URL.Path)
+		self.replayer.fail(err)
+		return nil, err
+	}
+
+	if exchange.Error != "" {
+		return nil, errors.New(exchange.Error)
+	}
+
+	body := self.replayer.rewriteRouterUrls(exchange.Body)
+	header := exchange.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Length")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// replayRouter emulates a recorded edge router, replaying its recorded connections in order.
+type replayRouter struct {
+	replayer    *Replayer
+	recordedUrl string
+	url         string
+	listener    channel.UnderlayListener
+	routerName  string
+
+	lock     sync.Mutex
+	scripts  map[int][]*RecordedMessage
+	order    []int
+	next     int
+	channels []channel.Channel
+	closed   atomic.Bool
+}
+
+func (self *replayRouter) add(msg *RecordedMessage) {
+	if self.routerName == "" {
+		self.routerName = msg.RouterName
+	}
+
+	if _, found := self.scripts[msg.Connection]; !found {
+		self.order = append(self.order, msg.Connection)
+		self.scripts[msg.Connection] = nil
+	}
+	if msg.Type != ChannelConnect {
+		self.scripts[msg.Connection] = append(self.scripts[msg.Connection], msg)
+	}
+}
+
+func (self *replayRouter) listen() error {
+	sort.Ints(self.order)
+
+	id, err := self.replayer.pki.routerIdentity(self.routerName)
+	if err != nil {
+		return err
+	}
+
+	port, err := getFreePort()
+	if err != nil {
+		return err
+	}
+
+	self.url = "tls:127.0.0.1:" + strconv.Itoa(port)
+	addr, err := transport.ParseAddress(self.url)
+	if err != nil {
+		return err
+	}
+
+	self.listener = channel.NewClassicListener(id, addr, channel.ListenerConfig{
+		ConnectOptions: channel.DefaultConnectOptions(),
+	})
+	if err = self.listener.Listen(); err != nil {
+		return errors.Wrapf(err, "unable to listen on %s", self.url)
+	}
+
+	go self.accept()
+	return nil
+}
+
+func (self *replayRouter) accept() {
+	for !self.closed.Load() {
+		if _, err := channel.NewChannel("zititest-replay", self.listener, channel.BindHandlerF(self.bindChannel), channel.DefaultOptions()); err != nil {
+			if !self.closed.Load() {
+				pfxlog.Logger().WithError(err).Debug("zititest replay router failed to accept channel")
+			}
+		}
+	}
+}
+
+func (self *replayRouter) bindChannel(binding channel.Binding) error {
+	self.lock.Lock()
+	var script []*RecordedMessage
+	connection := 0
+	if self.next < len(self.order) {
+		connection = self.order[self.next]
+		script = self.scripts[connection]
+	}
+	self.next++
+	self.channels = append(self.channels, binding.GetChannel())
+	self.lock.Unlock()
+
+	if connection == 0 {
+		self.replayer.fail(errors.Errorf("unrecorded connection to edge router %s", self.recordedUrl))
+		return errors.New("no recorded connection left to replay")
+	}
+
+	conn := &replayConn{
+		router:     self,
+		connection: connection,
+		ch:         binding.GetChannel(),
+		script:     script,
+		incoming:   make(chan *channel.Message, 16),
+		done:       make(chan struct{}),
+		connIds:    map[uint32]uint32{},
+		requests:   map[int32]*channel.Message{},
+	}
+
+	binding.AddReceiveHandlerF(channel.AnyContentType, conn.receive)
+	binding.AddReceiveHandler(channel.ContentTypeLatencyType, &latency.LatencyHandler{})
+	binding.AddCloseHandler(channel.CloseHandlerF(conn.handleClose))
+
+	go conn.run()
+	return nil
+}
+
+func (self *replayRouter) close() {
+	if !self.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if self.listener != nil {
+		_ = self.listener.Close()
+	}
+
+	self.lock.Lock()
+	channels := self.channels
+	self.channels = nil
+	self.lock.Unlock()
+
+	for _, ch := range channels {
+		_ = ch.Close()
+	}
+}
+
+// replayConn replays a recorded edge router connection.
+type replayConn struct {
+	router     *replayRouter
+	connection int
+	ch         channel.Channel
+	script     []*RecordedMessage
+	incoming   chan *channel.Message
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	// pending holds messages received from the SDK that did not match the recorded message being waited for
+	pending []*channel.Message
+
+	// connIds maps recorded connection ids to those of the replay
+	connIds map[uint32]uint32
+
+	// requests maps the sequence numbers of recorded SDK messages to the messages of the replay
+	requests map[int32]*channel.Message
+}
+
+func (self *replayConn) receive(msg *channel.Message, _ channel.Channel) {
+	select {
+	case self.incoming <- msg:
+	case <-self.done:
+	}
+}
+
+func (self *replayConn) handleClose(channel.Channel) {
+	self.closeOnce.Do(func() {
+		close(self.done)
+	})
+}
+
+func (self *replayConn) run() {
+	for _, event := range self.script {
+		switch event.Type {
+		case ChannelTx:
+			if !self.await(event) {
+				return
+			}
+		case ChannelRx:
+			self.send(event)
+		case ChannelClose:
+			_ = self.ch.Close()
+			return
+		}
+	}
+}
+
+// await waits for the SDK to send a message of the same content type as the recorded one.
+func (self *replayConn) await(event *RecordedMessage) bool {
+	timeout := time.NewTimer(replayWaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		for i, msg := range self.pending {
+			if msg.ContentType == event.ContentType {
+				self.pending = append(self.pending[:i:i], self.pending[i+1:]...)
+				self.matched(event, msg)
+				return true
+			}
+		}
+
+		select {
+		case msg := <-self.incoming:
+			self.pending = append(self.pending, msg)
+		case <-self.done:
+			return false
+		case <-timeout.C:
+			self.router.replayer.fail(errors.Errorf("edge router %s connection %d: SDK did not send a message of content type %d",
+				self.router.recordedUrl, self.connection, event.ContentType))
+			_ = self.ch.Close()
+			return false
+		}
+	}
+}
+
+func (self *replayConn) matched(event *RecordedMessage, msg *channel.Message) {
+	self.requests[event.Sequence] = msg
+	if recordedId, found := getUint32Header(event.Headers, edge.ConnIdHeader); found {
+		if connId, found := msg.GetUint32Header(edge.ConnIdHeader); found {
+			self.connIds[recordedId] = connId
+		}
+	}
+}
+
+func (self *replayConn) send(event *RecordedMessage) {
+	msg := channel.NewMessage(event.ContentType, event.Body)
+	for key, value := range event.Headers {
+		if key != channel.ReplyForHeader {
+			msg.Headers[key] = value
+		}
+	}
+
+	if recordedId, found := getUint32Header(event.Headers, edge.ConnIdHeader); found {
+		if connId, found := self.connIds[recordedId]; found {
+			msg.PutUint32Header(edge.ConnIdHeader, connId)
+		}
+	}
+
+	if replyFor, found := getUint32Header(event.Headers, channel.ReplyForHeader); found {
+		if request, found := self.requests[int32(replyFor)]; found {
+			msg.ReplyTo(request)
+		}
+	}
+
+	if err := msg.WithTimeout(replayWaitTimeout).Send(self.ch); err != nil {
+		pfxlog.Logger().WithError(err).WithField("contentType", msg.ContentType).Debug("zititest replay router failed to send message")
+	}
+}
+
+func getUint32Header(headers map[int32][]byte, key int32) (uint32, bool) {
+	value, found := headers[key]
+	if !found || len(value) != 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(value), true
+}
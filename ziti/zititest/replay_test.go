@@ -0,0 +1,95 @@
+package zititest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayer_replaysRecordedSession(t *testing.T) {
+	req := require.New(t)
+
+	harness, err := NewHarness()
+	req.NoError(err)
+	defer harness.Close()
+
+	harness.AddService("echo", nil)
+
+	server, err := harness.NewContext("server", nil)
+	req.NoError(err)
+	defer server.Close()
+
+	_, err = server.ListenWithOptions("echo", ziti.NewListenOptions(func(options *ziti.ListenOptions) {
+		options.BindUsingEdgeIdentity = true
+	}))
+	req.NoError(err)
+
+	req.Eventually(func() bool {
+		identities, err := server.GetServiceTerminatorIdentities("echo")
+		return err == nil && len(identities) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	recorder := NewRecorder(buf)
+
+	client, err := harness.NewContext("client", recorder.Options(nil))
+	req.NoError(err)
+	req.NoError(client.Authenticate())
+
+	conn, err := client.Dial("echo")
+	req.NoError(err)
+	recordedCircuitId := conn.GetCircuitId()
+	req.NoError(conn.Close())
+
+	_, err = client.Dial("missing")
+	req.Error(err)
+
+	client.Close()
+	req.NoError(recorder.Close())
+
+	recording, err := ReadRecording(buf)
+	req.NoError(err)
+
+	var exchanges, messages int
+	for _, event := range recording.Events {
+		if event.Http != nil {
+			exchanges++
+		}
+		if event.Channel != nil {
+			messages++
+		}
+	}
+	req.NotZero(exchanges)
+	req.NotZero(messages)
+
+	// the harness is no longer needed, the replay only uses the recording
+	server.Close()
+	harness.Close()
+
+	replayer, err := NewReplayer(recording)
+	req.NoError(err)
+	defer replayer.Close()
+
+	replayed, err := replayer.NewContext(nil)
+	req.NoError(err)
+	defer replayed.Close()
+	req.NoError(replayed.Authenticate())
+
+	services, err := replayed.GetServices()
+	req.NoError(err)
+	req.Len(services, 1)
+	req.Equal("echo", *services[0].Name)
+
+	conn, err = replayed.Dial("echo")
+	req.NoError(err)
+	req.Equal(recordedCircuitId, conn.GetCircuitId())
+	req.NoError(conn.Close())
+
+	_, err = replayed.Dial("missing")
+	req.Error(err)
+
+	req.NoError(replayer.Err())
+}
@@ -0,0 +1,291 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package zititest provides an in-memory implementation of ziti.Context for unit testing code written against the
+// SDK without a controller or edge routers.
+//
+// A Network holds the services visible to the Contexts created from it. Services are added, changed and removed with
+// AddService and RemoveService, which raise the same service events a Context connected to a controller would. Dials
+// are carried over in-process pipes to a Listen on the same Network, or to a handler set with HandleService:
+//
+//	network := zititest.NewNetwork()
+//	network.AddService("echo", nil)
+//	network.HandleService("echo", func(conn edge.Conn) {
+//		defer conn.Close()
+//		_, _ = io.Copy(conn, conn)
+//	})
+//
+//	ztx := network.NewContext("client")
+//	conn, err := ztx.Dial("echo")
+//
+// Tests that need to exercise the SDK itself can run a Harness, an emulated controller and edge router the SDK talks to
+// over loopback TLS. A Recorder captures the controller and edge router traffic of a Context, for a Replayer to play
+// back in regression tests.
+package zititest
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+)
+
+// ErrNotSupported is returned by the Context operations which need a controller, such as MFA enrollment.
+var ErrNotSupported = errors.New("operation not supported by the zititest context")
+
+// Network is an in-memory Ziti network, shared by the Contexts created from it.
+type Network struct {
+	lock     sync.Mutex
+	services map[string]*serviceEntry
+	contexts map[*Context]struct{}
+	idSeq    atomic.Uint32
+}
+
+type serviceEntry struct {
+	id        string
+	name      string
+	detail    *rest_model.ServiceDetail
+	listeners []*listener
+	next      int
+	handler   func(edge.Conn)
+	dialErr   error
+}
+
+// NewNetwork returns an empty Network.
+func NewNetwork() *Network {
+	return &Network{
+		services: map[string]*serviceEntry{},
+		contexts: map[*Context]struct{}{},
+	}
+}
+
+// NewContext returns a Context for the named identity on a new Network of its own. See Context.Network.
+func NewContext(identityName string) *Context {
+	return NewNetwork().NewContext(identityName)
+}
+
+func (self *Network) nextId() uint32 {
+	return self.idSeq.Add(1)
+}
+
+// NewContext returns a ready Context for the named identity, with dial and bind access to all services of the Network.
+func (self *Network) NewContext(identityName string) *Context {
+	result := newContext(self, identityName)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.contexts[result] = struct{}{}
+	return result
+}
+
+// AddService adds the named service with the given configs, keyed by config type, or replaces the configs of an
+// existing service. Every Context of the Network receives a service added or changed event.
+func (self *Network) AddService(name string, configs map[string]map[string]interface{}) *rest_model.ServiceDetail {
+	self.lock.Lock()
+	service, changed := self.services[name]
+	if !changed {
+		service = &serviceEntry{
+			id:   "zititest-service-" + strconv.FormatUint(uint64(self.nextId()), 10),
+			name: name,
+		}
+		self.services[name] = service
+	}
+
+	id := service.id
+	serviceName := name
+	detail := &rest_model.ServiceDetail{
+		BaseEntity: rest_model.BaseEntity{
+			ID: &id,
+		},
+		Name:        &serviceName,
+		Config:      configs,
+		Permissions: rest_model.DialBindArray{rest_model.DialBindDial, rest_model.DialBindBind},
+	}
+	service.detail = detail
+	contexts := self.getContexts()
+	self.lock.Unlock()
+
+	diff := &ziti.ServiceDiff{}
+	if changed {
+		diff.Changed = append(diff.Changed, detail)
+	} else {
+		diff.Added = append(diff.Added, detail)
+	}
+	for _, ctx := range contexts {
+		ctx.emitServiceDiff(diff)
+	}
+	return detail
+}
+
+// RemoveService removes the named service, closing its listeners. Every Context of the Network receives a service
+// removed event. Returns false if there is no such service.
+func (self *Network) RemoveService(name string) bool {
+	self.lock.Lock()
+	service, found := self.services[name]
+	if !found {
+		self.lock.Unlock()
+		return false
+	}
+	delete(self.services, name)
+	listeners := append([]*listener(nil), service.listeners...)
+	contexts := self.getContexts()
+	self.lock.Unlock()
+
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+
+	diff := &ziti.ServiceDiff{Removed: []*rest_model.ServiceDetail{service.detail}}
+	for _, ctx := range contexts {
+		ctx.emitServiceDiff(diff)
+	}
+	return true
+}
+
+// HandleService serves the dials of the named service which no listener accepts by calling handler with the hosting
+// side of each connection, in a goroutine of its own. A nil handler stops serving dials this way.
+func (self *Network) HandleService(name string, handler func(conn edge.Conn)) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	service, found := self.services[name]
+	if !found {
+		return &ziti.ServiceNotFoundError{ServiceName: name}
+	}
+	service.handler = handler
+	return nil
+}
+
+// FailDials makes all dials of the named service fail with err, until called again with a nil error.
+func (self *Network) FailDials(name string, err error) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	service, found := self.services[name]
+	if !found {
+		return &ziti.ServiceNotFoundError{ServiceName: name}
+	}
+	service.dialErr = err
+	return nil
+}
+
+func (self *Network) getContexts() []*Context {
+	result := make([]*Context, 0, len(self.contexts))
+	for ctx := range self.contexts {
+		result = append(result, ctx)
+	}
+	return result
+}
+
+func (self *Network) removeContext(ctx *Context) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.contexts, ctx)
+}
+
+func (self *Network) getService(name string) (*serviceEntry, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	service, found := self.services[name]
+	return service, found
+}
+
+func (self *Network) getServiceDetails() []*rest_model.ServiceDetail {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	result := make([]*rest_model.ServiceDetail, 0, len(self.services))
+	for _, service := range self.services {
+		result = append(result, service.detail)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return *result[i].Name < *result[j].Name
+	})
+	return result
+}
+
+func (self *Network) addListener(service *serviceEntry, l *listener) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if current, found := self.services[service.name]; !found || current != service {
+		return &ziti.ServiceNotFoundError{ServiceName: service.name}
+	}
+	service.listeners = append(service.listeners, l)
+	return nil
+}
+
+func (self *Network) removeListener(l *listener) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	service := l.service
+	for i, current := range service.listeners {
+		if current == l {
+			service.listeners = append(service.listeners[:i], service.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// selectTarget picks the listener to deliver a dial of the service to, preferring healthy listeners and rotating
+// between equal ones. If identity is set, only listeners hosting with that identity are considered. Returns the
+// service handler if there is no such listener.
+func (self *Network) selectTarget(service *serviceEntry, identity string) (*listener, func(edge.Conn), error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if service.dialErr != nil {
+		return nil, nil, service.dialErr
+	}
+
+	var candidates []*listener
+	for _, healthy := range []bool{true, false} {
+		for _, l := range service.listeners {
+			if l.isHealthy() == healthy && (identity == "" || l.identity == identity) {
+				candidates = append(candidates, l)
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+
+	if len(candidates) > 0 {
+		service.next++
+		return candidates[service.next%len(candidates)], nil, nil
+	}
+
+	if service.handler != nil && identity == "" {
+		return nil, service.handler, nil
+	}
+
+	if identity != "" {
+		return nil, nil, errors.Errorf("service '%s' has no terminator with identity '%s'", service.name, identity)
+	}
+	return nil, nil, errors.Errorf("service '%s' has no terminators", service.name)
+}
+
+func (self *Network) getTerminators(service *serviceEntry) []*listener {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]*listener(nil), service.listeners...)
+}
@@ -0,0 +1,167 @@
+package zititest
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext_dialListen(t *testing.T) {
+	req := require.New(t)
+
+	network := NewNetwork()
+	network.AddService("echo", nil)
+
+	server := network.NewContext("server")
+	defer server.Close()
+	client := network.NewContext("client")
+	defer client.Close()
+
+	listener, err := server.ListenWithOptions("echo", ziti.NewListenOptions(func(options *ziti.ListenOptions) {
+		options.BindUsingEdgeIdentity = true
+	}))
+	req.NoError(err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	conn, err := client.Dial("echo")
+	req.NoError(err)
+
+	_, err = conn.Write([]byte("hello"))
+	req.NoError(err)
+	req.NoError(conn.CloseWrite())
+
+	data, err := io.ReadAll(conn)
+	req.NoError(err)
+	req.Equal("hello", string(data))
+	req.Equal("echo/server", conn.RemoteAddr().String())
+
+	identities, err := client.GetServiceTerminatorIdentities("echo")
+	req.NoError(err)
+	req.Equal([]string{"server"}, identities)
+
+	req.NoError(conn.Close())
+	req.Empty(client.Connections())
+
+	_, err = client.Dial("missing")
+	var notFound *ziti.ServiceNotFoundError
+	req.ErrorAs(err, &notFound)
+}
+
+func TestContext_dialAddr(t *testing.T) {
+	req := require.New(t)
+
+	ztx := NewContext("client")
+	defer ztx.Close()
+
+	ztx.Network().AddService("web", map[string]map[string]interface{}{
+		edge.InterceptV1: {
+			"protocols": []string{"tcp"},
+			"addresses": []string{"web.ziti", "10.1.0.0/16"},
+			"portRanges": []map[string]interface{}{
+				{"low": 80, "high": 80},
+			},
+		},
+	})
+
+	appData := make(chan []byte, 1)
+	req.NoError(ztx.Network().HandleService("web", func(conn edge.Conn) {
+		appData <- conn.GetAppData()
+		_ = conn.Close()
+	}))
+
+	conn, err := ztx.DialAddr("tcp", "10.1.2.3:80")
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	var dst map[string]string
+	req.NoError(json.Unmarshal(<-appData, &dst))
+	req.Equal("10.1.2.3", dst["dst_ip"])
+	req.Equal("80", dst["dst_port"])
+
+	_, err = ztx.DialAddr("tcp", "web.ziti:443")
+	req.Error(err)
+}
+
+func TestContext_serviceEvents(t *testing.T) {
+	req := require.New(t)
+
+	network := NewNetwork()
+	ztx := network.NewContext("client")
+	defer ztx.Close()
+
+	var added, changed, removed atomic.Int32
+	ztx.Events().AddServiceAddedListener(func(eventCtx ziti.Context, detail *rest_model.ServiceDetail) {
+		req.Equal(ziti.Context(ztx), eventCtx)
+		added.Add(1)
+	})
+	ztx.Events().AddServiceChangedListener(func(ziti.Context, *rest_model.ServiceDetail) {
+		changed.Add(1)
+	})
+	ztx.Events().AddServiceRemovedListener(func(ziti.Context, *rest_model.ServiceDetail) {
+		removed.Add(1)
+	})
+
+	network.AddService("svc", nil)
+	network.AddService("svc", map[string]map[string]interface{}{"example.v1": {"value": 1}})
+
+	var value struct{ Value int }
+	found, err := ztx.GetServiceConfig("svc", "example.v1", &value)
+	req.NoError(err)
+	req.True(found)
+	req.Equal(1, value.Value)
+
+	req.True(network.RemoveService("svc"))
+	req.Equal(int32(1), added.Load())
+	req.Equal(int32(1), changed.Load())
+	req.Equal(int32(1), removed.Load())
+
+	services, err := ztx.GetServices()
+	req.NoError(err)
+	req.Empty(services)
+}
+
+func TestContext_failDialsAndClose(t *testing.T) {
+	req := require.New(t)
+
+	ztx := NewContext("client")
+	ztx.Network().AddService("svc", nil)
+
+	failure := errors.New("injected")
+	req.NoError(ztx.Network().FailDials("svc", failure))
+	_, err := ztx.Dial("svc")
+	req.ErrorIs(err, failure)
+
+	req.NoError(ztx.Network().FailDials("svc", nil))
+	_, err = ztx.DialWithOptions("svc", &ziti.DialOptions{ConnectTimeout: 10 * time.Millisecond})
+	req.ErrorContains(err, "no terminators")
+
+	listener, err := ztx.Listen("svc")
+	req.NoError(err)
+
+	ztx.Close()
+	req.True(listener.IsClosed())
+	req.Equal(ziti.ConnectionStateDisconnected, ztx.ConnectionState())
+
+	_, err = ztx.Dial("svc")
+	req.Error(err)
+}